@@ -8,9 +8,13 @@ import (
 	"agro/traits/database"
 	"agro/traits/logger"
 	"context"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
+	_ "time/tzdata" // TIMEZONE работает и в образах без /usr/share/zoneinfo
 
 	"github.com/go-telegram/bot"
 	"go.uber.org/zap"
@@ -27,10 +31,20 @@ func main() {
 		zapLogger.Error("error init config", zap.Error(err))
 		return
 	}
+	if strings.EqualFold(cfg.LogLevel, "debug") {
+		if devLogger, err := logger.NewDevelopmentLogger(); err == nil {
+			zapLogger = devLogger
+		}
+	}
 
-	db, err := database.InitDatabase(cfg.DBPath)
+	dsn := cfg.DBPath
+	if cfg.DBDriver == database.DriverPostgres {
+		dsn = cfg.DBDSN
+	}
+	db, err := database.InitDatabase(cfg.DBDriver, dsn)
 	if err != nil {
 		zapLogger.Error("error initializing database", zap.Error(err))
+		notifyAdminStartupFailure(cfg, err)
 		return
 	}
 	defer db.Close()
@@ -46,29 +60,65 @@ func main() {
 	handl := handler.NewHandler(zapLogger, cfg, ctx, db, redisRepo)
 
 	opts := []bot.Option{
-		// Разрешаем сообщения и callback_query
-		bot.WithAllowedUpdates([]string{"message", "callback_query"}),
+		// Разрешаем сообщения, callback_query и inline-поиск (@bot запрос)
+		bot.WithAllowedUpdates([]string{"message", "callback_query", "inline_query"}),
+
+		// Таймаут long-polling и ошибки getUpdates — в наш лог
+		bot.WithHTTPClient(cfg.BotPollTimeout, &http.Client{Timeout: cfg.BotPollTimeout}),
+		bot.WithErrorsHandler(func(err error) {
+			zapLogger.Warn("telegram bot error", zap.Error(err))
+		}),
 
 		// Админ-команды
 		bot.WithMessageTextHandler("/admin", bot.MatchTypeExact, handl.AdminHandler),
 		bot.WithMessageTextHandler("📢 Хабарлама (Messages)", bot.MatchTypeExact, handl.AdminHandler),
 		bot.WithMessageTextHandler("❌ Жабу (Close)", bot.MatchTypeExact, handl.AdminHandler),
+		bot.WithMessageTextHandler("/backup", bot.MatchTypeExact, handl.BackupHandler),
+		// команда целиком, а не префикс: «/subscribe» и т.п. сюда не попадают
+		bot.WithMessageTextHandler("sub", bot.MatchTypeCommandStartOnly, handl.SubCommandHandler),
+		bot.WithMessageTextHandler("resend", bot.MatchTypeCommandStartOnly, handl.ResendPaymentCommandHandler),
+
+		// Покупатель: заново прислать оплату последнего неоплаченного заказа
+		bot.WithMessageTextHandler("/pay", bot.MatchTypeExact, handl.PayCommandHandler),
+
+		// Покупатель: удалить свои данные (с подтверждением кнопкой delme_ok / delme_cancel)
+		bot.WithMessageTextHandler("/delete_me", bot.MatchTypeExact, handl.DeleteMeCommandHandler),
+		bot.WithCallbackQueryDataHandler("delme_", bot.MatchTypePrefix, handl.DeleteMeCallbackHandler),
 
 		// ✅ Хендлер для inline-кнопок оплаты ЗАКАЗОВ (pay_ok:... / pay_reject:...)
+		// и выбора способа оплаты покупателем (pay_method:<orderID>:<method>)
 		bot.WithCallbackQueryDataHandler("pay_", bot.MatchTypePrefix, handl.PaymentCallbackHandler),
 
 		// ✅ Хендлер для inline-кнопок оплаты ПОДПИСОК (sub_ok:... / sub_reject:...)
 		bot.WithCallbackQueryDataHandler("sub_", bot.MatchTypePrefix, handl.PaymentCallbackHandler),
 
+		// ✅ Быстрые действия по заказу из уведомления админу (ord_preparing:... / ord_done:...)
+		bot.WithCallbackQueryDataHandler("ord_", bot.MatchTypePrefix, handl.OrderStatusCallbackHandler),
+
+		// ✅ Оценка выполненного заказа (fb_<orderID>:<rating>)
+		bot.WithCallbackQueryDataHandler("fb_", bot.MatchTypePrefix, handl.FeedbackCallbackHandler),
+
 		// Дефолтный хендлер (приветствие + мини-апп)
 		bot.WithDefaultHandler(handl.DefaultHandler),
 	}
 
+	// Меню пользователя (ReplyKeyboard): 📦 Мои заказы, 💳 Моя подписка, 🏪 Выбрать магазин, 📞 Поддержка
+	for _, text := range handler.MenuButtons {
+		opts = append(opts, bot.WithMessageTextHandler(text, bot.MatchTypeExact, handl.UserMenuHandler))
+	}
+
 	b, err := bot.New(cfg.Token, opts...)
 	if err != nil {
 		zapLogger.Error("error in start bot", zap.Error(err))
 		return
 	}
+	handl.SetBot(b)
+
+	// Inline-поиск товаров: отдельного типа хендлера для inline_query в библиотеке нет
+	b.RegisterHandlerMatchFunc(handler.IsInlineQuery, handl.InlineQueryHandler)
+	if cfg.DryRun {
+		zapLogger.Warn("DRY_RUN enabled: messages are logged, not sent")
+	}
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGINT)
@@ -80,8 +130,71 @@ func main() {
 	}()
 
 	go handl.StartWebServer(ctx, b)
+	go handl.StartBackups(ctx)
+	go handl.CheckPayment(ctx)
+	go handl.RunOutbox(ctx)
+	go handl.RunPaymentMethodTimeouts(ctx)
+	go handl.BackfillStoreCoords(ctx)
 	zapLogger.Info("Starting web server", zap.String("port", cfg.Port))
-	zapLogger.Info("Bot started successfully")
+	zapLogger.Info("Bot started successfully", zap.Duration("poll_timeout", cfg.BotPollTimeout))
+
+	startBotWithRetry(ctx, zapLogger, b.Start)
+}
 
-	b.Start(ctx)
+const (
+	botRestartMinDelay = time.Second
+	botRestartMaxDelay = 60 * time.Second
+)
+
+// startBotWithRetry перезапускает polling, если b.Start вернулся (или упал)
+// раньше, чем завершился ctx. Пауза между перезапусками растёт вдвое до
+// botRestartMaxDelay и сбрасывается после долгой успешной работы.
+func startBotWithRetry(ctx context.Context, log *zap.Logger, start func(context.Context)) {
+	delay := botRestartMinDelay
+	for attempt := 1; ; attempt++ {
+		startedAt := time.Now()
+		runBot(ctx, log, start)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(startedAt) > botRestartMaxDelay {
+			delay = botRestartMinDelay
+		}
+
+		log.Warn("bot polling stopped, restarting",
+			zap.Int("attempt", attempt), zap.Duration("delay", delay))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, botRestartMaxDelay)
+	}
+}
+
+func runBot(ctx context.Context, log *zap.Logger, start func(context.Context)) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("bot polling panic", zap.Any("panic", r))
+		}
+	}()
+	start(ctx)
+}
+
+// notifyAdminStartupFailure пишет админу напрямую через Bot API,
+// когда сервис не может стартовать (например, база повреждена).
+func notifyAdminStartupFailure(cfg *config.Config, cause error) {
+	if cfg.Token == "" || cfg.AdminID == 0 {
+		return
+	}
+	b, err := bot.New(cfg.Token, bot.WithSkipGetMe())
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: cfg.AdminID,
+		Text:   "🚨 Бот не запущен: ошибка базы данных\n\n" + cause.Error(),
+	})
 }
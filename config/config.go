@@ -1,46 +1,223 @@
 package config
 
 import (
+	"math"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Token           string
-	Port            string
-	DBPath          string
-	ChannelName     string
-	MiniAppUrl      string
+	Token       string
+	Port        string
+	DBPath      string
+	DBDriver    string // sqlite3 | postgres
+	DBDSN       string // строка подключения для postgres
+	ChannelName string
+	MiniAppUrl  string
+	// Адрес админ-панели (MINI_APP_URL_ADMIN); по умолчанию MINI_APP_URL + "/admin-show-catalog".
+	// В проде панель может жить на отдельном поддомене, в разработке — на том же ngrok.
 	MiniAppUrlAdmin string
 	AdminID         int64
 	YandexAPIKey    string
 	KaspiPayURL     string
 
+	// Дополнительные суперадмины API (ADMIN_IDS через запятую): как и ADMIN_ID,
+	// видят и меняют все точки. Управляющие из store_managers — только свои.
+	AdminIDs []int64
+
 	// 🔹 Новые поля для оплаты переводом
 	KaspiCardNumber string
 	KaspiCardHolder string
+
+	// Секрет подписи серверных callback'ов Kaspi Pay (KASPI_WEBHOOK_SECRET).
+	// Пусто — callback'и не принимаются, оплату подтверждает админ по чеку.
+	KaspiWebhookSecret string
+
+	// DryRun — не отправлять ничего в Telegram, только логировать (staging)
+	DryRun bool
+
+	// Обязательная подпись POST /api/orders/ и /api/subscribe/: X-Request-Signature
+	// (серверные клиенты) или X-Telegram-Init-Data (мини-апп)
+	RequireRequestSignature bool
+
+	// Резервные копии SQLite
+	BackupDir      string
+	BackupKeep     int
+	BackupInterval time.Duration
+
+	// Long-polling: сколько Telegram держит запрос getUpdates
+	BotPollTimeout time.Duration
+
+	// Таймаут исходящих HTTP-запросов (геокодер, вебхуки): медленный внешний
+	// сервис не должен держать горутину обработчика
+	HTTPClientTimeout time.Duration
+
+	// Каталог со страницами мини-аппа (STATIC_DIR). Пусто — страницы из
+	// бинарника (agro/static); каталог — для правки вёрстки без пересборки
+	StaticDir string
+
+	// На сколько процентов должна упасть цена товара, чтобы покупателям,
+	// заказывавшим его, ушло сообщение «Цены снизились» (PRICE_DROP_NOTIFY_PERCENT).
+	// 0 — не сообщать
+	PriceDropNotifyPercent float64
+
+	// Радиус доставки от точки, если для неё не задан полигон зоны
+	DeliveryRadiusKm float64
+
+	// Сколько дней после окончания подписки доступ ещё сохраняется (статус grace)
+	SubGraceDays int
+
+	// Ежедневная проверка подписок: местное время запуска (SUB_CHECK_TIME, «ЧЧ:ММ»,
+	// в часовом поясе TIMEZONE) и пауза перед повтором после ошибки (SUB_CHECK_RETRY)
+	SubCheckTime  string
+	SubCheckRetry time.Duration
+
+	// Уровень логов: debug — подробный лог и текст Go-ошибок в ответах API
+	LogLevel string
+
+	// Локаль (ru | kk | en) и часовой пояс для дат и сумм в сообщениях
+	Locale   string
+	Timezone string
+
+	// Режим обслуживания при старте (заказы и подписки не принимаются) и текст
+	// для пользователей; админ переключает его на лету через /api/admin/maintenance
+	Maintenance        bool
+	MaintenanceMessage string
+
+	// Источники, которым разрешены CORS-запросы к API (CORS_ORIGINS через запятую).
+	// По умолчанию — только адрес мини-аппа; "*" — любой источник, только для локальной разработки.
+	CORSOrigins []string
+
+	// Контакт поддержки для кнопки «📞 Поддержка»: @username, телефон или ссылка.
+	// Пусто — кнопка ведёт в чат с администратором (ADMIN_ID).
+	SupportContact string
+
+	// Заказы точки с управляющими (store_managers) уходят только им, владельцу
+	// (ADMIN_ID) — лишь заказы точек без управляющих (ORDER_ADMIN_FALLBACK_ONLY).
+	// По умолчанию владелец получает копию каждого заказа.
+	OrderAdminFallbackOnly bool
+
+	// Имя бота без @ — для ссылок t.me/<bot>?startapp=… из inline-поиска.
+	// Пусто — ссылки ведут прямо на адрес мини-аппа.
+	BotUsername string
+
+	// Пределы размера заказа (0 — без предела). Больше Max* — заказ не
+	// принимается; больше OrderReview* — создаётся в статусе review, и реквизиты
+	// для оплаты уходят после того, как админ подтвердит наличие.
+	// Предел количества позиции можно переопределить у товара (products.max_qty).
+	OrderMaxItemQty    float64 // ORDER_MAX_ITEM_QTY, количество одной позиции
+	OrderMaxItems      int     // ORDER_MAX_ITEMS, разных позиций в заказе
+	OrderMaxTotal      int64   // ORDER_MAX_TOTAL, сумма заказа, ₸
+	OrderReviewItemQty float64 // ORDER_REVIEW_ITEM_QTY
+	OrderReviewTotal   int64   // ORDER_REVIEW_TOTAL
+}
+
+// IsSuperAdmin — ADMIN_ID или один из ADMIN_IDS.
+func (c *Config) IsSuperAdmin(id int64) bool {
+	return id > 0 && (id == c.AdminID || slices.Contains(c.AdminIDs, id))
 }
 
 func envOrDefault(key, def string) string {
-	if v := os.Getenv(key); v != "" {
+	if v := lookup(key); v != "" {
 		return v
 	}
 	return def
 }
 
+func envIntOrDefault(key string, def int) int {
+	if v := lookup(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+// envFloatOrDefault — неотрицательное число; иное значение — def.
+func envFloatOrDefault(key string, def float64) float64 {
+	if v := lookup(key); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && !math.IsInf(f, 0) {
+			return f
+		}
+	}
+	return def
+}
+
+func envDurationOrDefault(key string, def time.Duration) time.Duration {
+	if v := lookup(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+// envListOrDefault читает список через запятую, пустые элементы отбрасываются.
+func envListOrDefault(key string, def []string) []string {
+	v := lookup(key)
+	if v == "" {
+		return def
+	}
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimRight(strings.TrimSpace(s), "/"); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// defaultCORSOrigins — адрес мини-аппа и, если админ-панель на другом
+// поддомене, её источник (схема и хост).
+func defaultCORSOrigins(miniAppUrl, adminUrl string) []string {
+	out := []string{strings.TrimRight(miniAppUrl, "/")}
+	u, err := url.Parse(adminUrl)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return out
+	}
+	admin := u.Scheme + "://" + u.Host
+	if !strings.HasPrefix(out[0]+"/", admin+"/") {
+		out = append(out, admin)
+	}
+	return out
+}
+
 func NewConfig() (*Config, error) {
+	// Необязательный файл настроек (.env или плоский YAML); ENV его перекрывает
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		values, err := loadConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		fileValues = values
+	}
+
 	token := envOrDefault("TELEGRAM_BOT_TOKEN",
 		"8288790284:AAHkDouevMu_7ddQk9CleHDrOdRqFalBV-M")
 
 	port := envOrDefault("PORT", "8080")
 	dbPath := envOrDefault("DB_PATH", "./agro.db")
+	dbDriver := envOrDefault("DB_DRIVER", "sqlite3")
+	dbDSN := envOrDefault("DB_DSN", "")
 
 	miniAppUrl := envOrDefault("MINI_APP_URL",
 		"https://d5dec5ae7f52.ngrok-free.app")
+	miniAppUrlAdmin := envOrDefault("MINI_APP_URL_ADMIN",
+		strings.TrimRight(miniAppUrl, "/")+"/admin-show-catalog")
 
 	// Admin ID — можно переопределить через ENV ADMIN_ID
 	adminIDStr := envOrDefault("ADMIN_ID", "800703982")
 	adminID, _ := strconv.ParseInt(adminIDStr, 10, 64)
+	var adminIDs []int64
+	for _, v := range envListOrDefault("ADMIN_IDS", nil) {
+		if id, err := strconv.ParseInt(v, 10, 64); err == nil && id > 0 {
+			adminIDs = append(adminIDs, id)
+		}
+	}
 
 	// Kaspi Pay по ссылке (инвойс)
 	kaspiPayURL := envOrDefault("KASPI_PAY_URL",
@@ -51,19 +228,105 @@ func NewConfig() (*Config, error) {
 		"4400 4300 0000 1234")
 	kaspiCardHolder := envOrDefault("KASPI_CARD_HOLDER",
 		"AGRO CLUB")
+	kaspiWebhookSecret := envOrDefault("KASPI_WEBHOOK_SECRET", "")
+
+	// Бэкапы: каталог, сколько хранить и как часто делать
+	backupDir := envOrDefault("BACKUP_DIR", "./backups")
+	backupKeep := envIntOrDefault("BACKUP_KEEP", 7)
+	backupInterval := envDurationOrDefault("BACKUP_INTERVAL", 24*time.Hour)
+
+	botPollTimeout := time.Duration(envIntOrDefault("BOT_POLL_TIMEOUT_SECONDS", 30)) * time.Second
+	httpClientTimeout := time.Duration(envIntOrDefault("HTTP_CLIENT_TIMEOUT_SECONDS", 10)) * time.Second
+	if httpClientTimeout <= 0 {
+		httpClientTimeout = 10 * time.Second
+	}
+	staticDir := envOrDefault("STATIC_DIR", "")
+
+	priceDropNotifyPercent, err := strconv.ParseFloat(envOrDefault("PRICE_DROP_NOTIFY_PERCENT", "10"), 64)
+	if err != nil || priceDropNotifyPercent < 0 {
+		priceDropNotifyPercent = 10
+	}
+
+	deliveryRadiusKm, err := strconv.ParseFloat(envOrDefault("DELIVERY_RADIUS_KM", "10"), 64)
+	if err != nil {
+		deliveryRadiusKm = 10
+	}
+
+	subGraceDays := envIntOrDefault("SUB_GRACE_DAYS", 3)
+	subCheckTime := envOrDefault("SUB_CHECK_TIME", "09:00")
+	subCheckRetry := envDurationOrDefault("SUB_CHECK_RETRY", 15*time.Minute)
+
+	logLevel := envOrDefault("LOG_LEVEL", "info")
+	locale := envOrDefault("LOCALE", "ru")
+	timezone := envOrDefault("TIMEZONE", "Asia/Almaty")
+
+	dryRun, _ := strconv.ParseBool(envOrDefault("DRY_RUN", "false"))
+	requireSig, _ := strconv.ParseBool(envOrDefault("REQUIRE_REQUEST_SIGNATURE", "false"))
+	orderAdminFallbackOnly, _ := strconv.ParseBool(envOrDefault("ORDER_ADMIN_FALLBACK_ONLY", "false"))
+	orderMaxItemQty := envFloatOrDefault("ORDER_MAX_ITEM_QTY", 100)
+	orderMaxItems := max(envIntOrDefault("ORDER_MAX_ITEMS", 50), 0)
+	orderMaxTotal := max(int64(envIntOrDefault("ORDER_MAX_TOTAL", 1_000_000)), 0)
+	orderReviewItemQty := envFloatOrDefault("ORDER_REVIEW_ITEM_QTY", 30)
+	orderReviewTotal := max(int64(envIntOrDefault("ORDER_REVIEW_TOTAL", 200_000)), 0)
+	maintenance, _ := strconv.ParseBool(envOrDefault("MAINTENANCE", "false"))
+	maintenanceMessage := envOrDefault("MAINTENANCE_MESSAGE", "")
+	corsOrigins := envListOrDefault("CORS_ORIGINS", defaultCORSOrigins(miniAppUrl, miniAppUrlAdmin))
+	supportContact := envOrDefault("SUPPORT_CONTACT", "")
+	botUsername := strings.TrimPrefix(envOrDefault("BOT_USERNAME", ""), "@")
 
 	return &Config{
 		Token:           token,
 		Port:            port,
 		DBPath:          dbPath,
+		DBDriver:        dbDriver,
+		DBDSN:           dbDSN,
 		ChannelName:     "@jaiAngmeAitamyz",
 		MiniAppUrl:      miniAppUrl,
-		MiniAppUrlAdmin: miniAppUrl + "/admin-show-catalog",
+		MiniAppUrlAdmin: miniAppUrlAdmin,
 		YandexAPIKey:    "8a3e4da0-9ef2-4176-9203-e7014c1dba6f",
 		KaspiPayURL:     kaspiPayURL,
 		AdminID:         adminID,
+		AdminIDs:        adminIDs,
 
 		KaspiCardNumber: kaspiCardNumber,
 		KaspiCardHolder: kaspiCardHolder,
+
+		KaspiWebhookSecret: kaspiWebhookSecret,
+
+		DryRun:                  dryRun,
+		RequireRequestSignature: requireSig,
+
+		BackupDir:      backupDir,
+		BackupKeep:     backupKeep,
+		BackupInterval: backupInterval,
+
+		BotPollTimeout:    botPollTimeout,
+		HTTPClientTimeout: httpClientTimeout,
+		StaticDir:         staticDir,
+		DeliveryRadiusKm:  deliveryRadiusKm,
+		SubGraceDays:      subGraceDays,
+		SubCheckTime:      subCheckTime,
+		SubCheckRetry:     subCheckRetry,
+
+		PriceDropNotifyPercent: priceDropNotifyPercent,
+
+		LogLevel: logLevel,
+		Locale:   locale,
+		Timezone: timezone,
+
+		Maintenance:        maintenance,
+		MaintenanceMessage: maintenanceMessage,
+
+		CORSOrigins:    corsOrigins,
+		SupportContact: supportContact,
+		BotUsername:    botUsername,
+
+		OrderAdminFallbackOnly: orderAdminFallbackOnly,
+
+		OrderMaxItemQty:    orderMaxItemQty,
+		OrderMaxItems:      orderMaxItems,
+		OrderMaxTotal:      orderMaxTotal,
+		OrderReviewItemQty: orderReviewItemQty,
+		OrderReviewTotal:   orderReviewTotal,
 	}, nil
 }
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewConfigFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `# agro config
+port: 9090
+admin_id: "42"
+kaspi-card-holder: 'AGRO TEST'  # владелец
+BACKUP_KEEP=3
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("PORT", "7070") // ENV перекрывает файл
+	t.Cleanup(func() { fileValues = map[string]string{} })
+
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != "7070" {
+		t.Errorf("Port = %q, want env override 7070", cfg.Port)
+	}
+	if cfg.AdminID != 42 {
+		t.Errorf("AdminID = %d, want 42", cfg.AdminID)
+	}
+	if cfg.KaspiCardHolder != "AGRO TEST" {
+		t.Errorf("KaspiCardHolder = %q", cfg.KaspiCardHolder)
+	}
+	if cfg.BackupKeep != 3 {
+		t.Errorf("BackupKeep = %d, want 3", cfg.BackupKeep)
+	}
+	if cfg.DBPath != "./agro.db" {
+		t.Errorf("DBPath = %q, want default", cfg.DBPath)
+	}
+}
+
+func TestNewConfigMissingFile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "nope.env"))
+	if _, err := NewConfig(); err == nil {
+		t.Fatal("expected error for missing CONFIG_FILE")
+	}
+}
+
+func TestNewConfigCORSOrigins(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("MINI_APP_URL", "https://agro.example/")
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.CORSOrigins) != 1 || cfg.CORSOrigins[0] != "https://agro.example" {
+		t.Fatalf("default CORSOrigins = %q", cfg.CORSOrigins)
+	}
+
+	t.Setenv("CORS_ORIGINS", " https://a.example/, ,http://localhost:5173 ")
+	cfg, _ = NewConfig()
+	if len(cfg.CORSOrigins) != 2 || cfg.CORSOrigins[0] != "https://a.example" || cfg.CORSOrigins[1] != "http://localhost:5173" {
+		t.Fatalf("CORSOrigins = %q", cfg.CORSOrigins)
+	}
+}
+
+func TestNewConfigMiniAppUrlAdmin(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("CORS_ORIGINS", "")
+	t.Setenv("MINI_APP_URL", "https://abc.ngrok-free.app/")
+	t.Setenv("MINI_APP_URL_ADMIN", "")
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MiniAppUrlAdmin != "https://abc.ngrok-free.app/admin-show-catalog" {
+		t.Fatalf("default MiniAppUrlAdmin = %q", cfg.MiniAppUrlAdmin)
+	}
+	if len(cfg.CORSOrigins) != 1 {
+		t.Fatalf("same-origin admin CORSOrigins = %q", cfg.CORSOrigins)
+	}
+
+	t.Setenv("MINI_APP_URL", "https://shop.agro.kz")
+	t.Setenv("MINI_APP_URL_ADMIN", "https://admin.agro.kz/panel")
+	cfg, _ = NewConfig()
+	if cfg.MiniAppUrlAdmin != "https://admin.agro.kz/panel" {
+		t.Fatalf("MiniAppUrlAdmin = %q", cfg.MiniAppUrlAdmin)
+	}
+	if len(cfg.CORSOrigins) != 2 || cfg.CORSOrigins[1] != "https://admin.agro.kz" {
+		t.Fatalf("CORSOrigins with admin subdomain = %q", cfg.CORSOrigins)
+	}
+}
+
+func TestNewConfigAdminIDs(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("ADMIN_ID", "100")
+	t.Setenv("ADMIN_IDS", " 200, abc,,300 ")
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.AdminIDs) != 2 || cfg.AdminIDs[0] != 200 || cfg.AdminIDs[1] != 300 {
+		t.Fatalf("AdminIDs = %v", cfg.AdminIDs)
+	}
+	for id, want := range map[int64]bool{100: true, 200: true, 300: true, 400: false, 0: false} {
+		if got := cfg.IsSuperAdmin(id); got != want {
+			t.Fatalf("IsSuperAdmin(%d) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestNewConfigHTTPClientTimeout(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	for env, want := range map[string]time.Duration{"": 10 * time.Second, "3": 3 * time.Second, "0": 10 * time.Second} {
+		t.Setenv("HTTP_CLIENT_TIMEOUT_SECONDS", env)
+		cfg, err := NewConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.HTTPClientTimeout != want {
+			t.Fatalf("HTTP_CLIENT_TIMEOUT_SECONDS=%q: timeout = %v, want %v", env, cfg.HTTPClientTimeout, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// fileValues — значения из CONFIG_FILE. Переменные окружения имеют приоритет.
+var fileValues = map[string]string{}
+
+// lookup ищет ключ сначала в окружении, потом в файле конфигурации.
+func lookup(key string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fileValues[key]
+}
+
+// loadConfigFile читает плоский файл настроек в формате .env (KEY=value)
+// или простого YAML (key: value). Ключи приводятся к виду переменных окружения:
+// telegram_bot_token / telegram-bot-token → TELEGRAM_BOT_TOKEN.
+func loadConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open config file: %w", err)
+	}
+	defer f.Close()
+
+	out := map[string]string{}
+	sc := bufio.NewScanner(f)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		sep := strings.IndexAny(line, "=:")
+		if sep <= 0 {
+			return nil, fmt.Errorf("config file %s:%d: expected KEY=value or key: value", path, lineNo)
+		}
+		key := strings.TrimSpace(line[:sep])
+		val := strings.TrimSpace(line[sep+1:])
+
+		// значение в кавычках берём как есть, иначе отрезаем комментарий в конце строки
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') {
+			if end := strings.IndexByte(val[1:], val[0]); end >= 0 {
+				val = val[1 : end+1]
+			}
+		} else if i := strings.Index(val, " #"); i >= 0 {
+			val = strings.TrimSpace(val[:i])
+		}
+
+		key = strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+		out[key] = val
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	return out, nil
+}
@@ -4,14 +4,20 @@ go 1.24.0
 
 toolchain go1.24.9
 
+require (
+	github.com/alicebob/miniredis/v2 v2.35.0
+	github.com/go-telegram/bot v1.17.0
+	github.com/google/uuid v1.6.0
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.32
+	github.com/redis/go-redis/v9 v9.16.0
+	go.uber.org/zap v1.27.0
+	golang.org/x/time v0.14.0
+)
+
 require (
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/go-telegram/bot v1.17.0 // indirect
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.32 // indirect
-	github.com/redis/go-redis/v9 v9.16.0 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	go.uber.org/multierr v1.10.0 // indirect
-	go.uber.org/zap v1.27.0 // indirect
-	golang.org/x/time v0.14.0 // indirect
 )
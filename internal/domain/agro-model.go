@@ -1 +1,50 @@
+// internal/domain/agro-model.go
 package domain
+
+import "time"
+
+// Order — заказ из таблицы orders.
+type Order struct {
+	ID            int64
+	UserID        int64 // Telegram ID
+	StoreCode     string
+	TotalAmount   int64 // GoodsTotal + DeliveryPrice
+	GoodsTotal    int64 // товары без доставки
+	DeliveryPrice int64 // 0 — самовывоз
+	Status        string
+	PaymentMethod string // kaspi_link | kaspi_transfer | cash; пусто у старых заказов
+	ParentOrderID int64  // >0 — часть заказа из нескольких точек (собирает своя точка)
+	CreatedAt     time.Time
+
+	// Items заполняют OrderRepository.Create (на входе) и Get; ListByUser их не грузит.
+	Items []OrderItem
+}
+
+// DeliveryItemName — название служебной строки заказа со стоимостью доставки.
+const DeliveryItemName = "Доставка"
+
+// OrderItem — позиция заказа; у строки «Доставка» ProductID = 0.
+type OrderItem struct {
+	ProductID int64
+	Name      string
+	Unit      string
+	Qty       float64
+	Price     int64
+	Amount    int64
+
+	Note              string // пожелание покупателя, например «спелые»
+	AllowSubstitution bool   // можно заменить похожим товаром, если нет в наличии
+
+	// эмодзи и фото товара на момент заказа; у старых заказов пусто
+	Emoji string
+	Photo string
+
+	// за что цена (products.price_per на момент заказа): kg | 100g | piece | bundle;
+	// пусто — Price за Unit, как раньше
+	PricePer string
+}
+
+// IsDelivery — строка «Доставка», а не товар.
+func (it OrderItem) IsDelivery() bool {
+	return it.ProductID == 0 && it.Name == DeliveryItemName
+}
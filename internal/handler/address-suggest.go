@@ -0,0 +1,141 @@
+// handler/address-suggest.go
+package handler
+
+import (
+	"agro/internal/repository"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+const (
+	yandexSuggestURL      = "https://suggest-maps.yandex.ru/v1/suggest"
+	addressSuggestResults = 5
+	addressSuggestMinLen  = 3   // короче — ещё печатают, в Яндекс не ходим
+	addressSuggestMaxLen  = 200 // длиннее адресов не бывает
+)
+
+type addressSuggestOut struct {
+	Title    string `json:"title"`
+	Subtitle string `json:"subtitle"`
+	URI      string `json:"uri"` // ymapsbm1://… — по нему геокодер найдёт точку без разбора текста
+}
+
+// yandexSuggestResponse — нужная часть ответа Yandex Suggest API.
+type yandexSuggestResponse struct {
+	Results []struct {
+		Title    struct{ Text string } `json:"title"`
+		Subtitle struct{ Text string } `json:"subtitle"`
+		URI      string                `json:"uri"`
+	} `json:"results"`
+}
+
+// GET /api/address/suggest?q=Алматы+Абая — подсказки адреса доставки (до 5)
+// через Yandex Suggest. Ответы кэшируются в Redis на час (suggest:<sha256(q)>):
+// поле адреса дёргает ручку на каждое нажатие, а адреса повторяются.
+func (h *Handler) handleAddressSuggest(w http.ResponseWriter, r *http.Request) {
+	q := strings.Join(strings.Fields(strings.ToLower(r.URL.Query().Get("q"))), " ")
+	switch n := utf8.RuneCountInString(q); {
+	case n > addressSuggestMaxLen:
+		writeError(w, ErrBadRequest("q is too long").WithField("max", addressSuggestMaxLen))
+		return
+	case n < addressSuggestMinLen:
+		jsonOK(w, []addressSuggestOut{})
+		return
+	}
+	if h.cfg.YandexAPIKey == "" {
+		writeError(w, ErrServiceUnavailable("address suggest is not configured"))
+		return
+	}
+
+	key := repository.SuggestCacheKey(q)
+	if data := h.cachedSuggest(r.Context(), key); data != nil {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = w.Write(data)
+		return
+	}
+
+	out, err := h.suggestAddress(r.Context(), q)
+	if err != nil {
+		h.logger.Warn("yandex suggest", zap.String("q", q), zap.Error(err))
+		writeError(w, ErrServiceUnavailable("address suggest is unavailable").Wrap(err))
+		return
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		writeError(w, ErrInternal(err))
+		return
+	}
+	h.cacheSuggest(r.Context(), key, data)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, _ = w.Write(data)
+}
+
+// suggestAddress запрашивает подсказки у Yandex Suggest API.
+func (h *Handler) suggestAddress(ctx context.Context, q string) ([]addressSuggestOut, error) {
+	params := url.Values{
+		"apikey":        {h.cfg.YandexAPIKey},
+		"text":          {q},
+		"lang":          {"ru_RU"},
+		"results":       {strconv.Itoa(addressSuggestResults)},
+		"types":         {"street,house"},
+		"print_address": {"1"},
+		"attrs":         {"uri"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.suggestURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("yandex suggest: status %d", resp.StatusCode)
+	}
+	var body yandexSuggestResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("yandex suggest: %w", err)
+	}
+	out := make([]addressSuggestOut, 0, addressSuggestResults)
+	for _, res := range body.Results {
+		if len(out) == addressSuggestResults {
+			break
+		}
+		out = append(out, addressSuggestOut{Title: res.Title.Text, Subtitle: res.Subtitle.Text, URI: res.URI})
+	}
+	return out, nil
+}
+
+func (h *Handler) cachedSuggest(ctx context.Context, key string) []byte {
+	if h.redisClient == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, productsCacheTimeout)
+	defer cancel()
+	data, err := h.redisClient.GetSuggestCache(ctx, key)
+	if err != nil {
+		h.logger.Warn("address suggest cache get", zap.Error(err))
+		return nil
+	}
+	return data
+}
+
+func (h *Handler) cacheSuggest(ctx context.Context, key string, data []byte) {
+	if h.redisClient == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, productsCacheTimeout)
+	defer cancel()
+	if err := h.redisClient.SaveSuggestCache(ctx, key, data); err != nil {
+		h.logger.Warn("address suggest cache save", zap.Error(err))
+	}
+}
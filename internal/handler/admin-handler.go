@@ -3,9 +3,13 @@ package handler
 import (
 	"agro/internal/domain"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -26,12 +30,12 @@ const (
 func (h *Handler) AdminHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 
 	var adminId int64
-	switch update.Message.From.ID {
-	case h.cfg.AdminID:
-		adminId = h.cfg.AdminID
+	switch id := update.Message.From.ID; {
+	case h.cfg.IsSuperAdmin(id):
+		adminId = id
 	default:
 		h.logger.Warn("SomeOne is trying to get admin root", zap.Any("user_id", update.Message.From.ID))
-		b.SendMessage(ctx, &bot.SendMessageParams{
+		h.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: h.cfg.AdminID,
 			Text:   fmt.Sprintf("SomeOne is trying to get admin root, user_id: %d", update.Message.From.ID),
 		})
@@ -68,7 +72,7 @@ func (h *Handler) AdminHandler(ctx context.Context, b *bot.Bot, update *models.U
 		if err := h.redisClient.SaveUserState(ctx, adminId, newAdminState); err != nil {
 			h.logger.Error("Failed to save admin state to Redis", zap.Error(err))
 		}
-		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		_, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID:      adminId,
 			Text:        "🔧 Админ панеліне қош келдіңіз!\n\nТаңдаңыз:",
 			ReplyMarkup: adminKeyboard,
@@ -77,13 +81,13 @@ func (h *Handler) AdminHandler(ctx context.Context, b *bot.Bot, update *models.U
 			h.logger.Error("Failed to send admin panel", zap.Error(err))
 		}
 	case "📢 Хабарлама (Messages)":
-		h.handleBroadcastMenu(ctx, b, update)
+		h.handleBroadcastMenu(ctx, update)
 
 	case "❌ Жабу (Close)":
-		h.handleCloseAdmin(ctx, b)
+		h.handleCloseAdmin(ctx, adminId)
 	default:
 		if state != nil && state.State == stateAdminPanel {
-			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			_, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{
 				ChatID:      adminId,
 				Text:        "Белгісіз команда. Төмендегі батырмаларды пайдаланыңыз:",
 				ReplyMarkup: adminKeyboard,
@@ -98,12 +102,12 @@ func (h *Handler) AdminHandler(ctx context.Context, b *bot.Bot, update *models.U
 func (h *Handler) SendMessage(ctx context.Context, b *bot.Bot, update *models.Update) {
 
 	var adminId int64
-	switch update.Message.From.ID {
-	case h.cfg.AdminID:
-		adminId = h.cfg.AdminID
+	switch id := update.Message.From.ID; {
+	case h.cfg.IsSuperAdmin(id):
+		adminId = id
 	default:
 		h.logger.Warn("SomeOne is trying to get admin root", zap.Any("user_id", update.Message.From.ID))
-		b.SendMessage(ctx, &bot.SendMessageParams{
+		h.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: h.cfg.AdminID,
 			Text:   fmt.Sprintf("SomeOne is trying to get admin root, user_id: %d", update.Message.From.ID),
 		})
@@ -127,16 +131,16 @@ func (h *Handler) SendMessage(ctx context.Context, b *bot.Bot, update *models.Up
 
 	switch update.Message.Text {
 	case "📢 Барлығына жіберу":
-		h.startBroadcast(ctx, b, update, "all")
+		h.startBroadcast(ctx, update, "all")
 		return
 	case "🛍 Клиенттерге жіберу":
-		h.startBroadcast(ctx, b, update, "clients")
+		h.startBroadcast(ctx, update, "clients")
 		return
 	case "🎲 Лото қатысушыларына":
-		h.startBroadcast(ctx, b, update, "loto")
+		h.startBroadcast(ctx, update, "loto")
 		return
 	case "👥 Тіркелгендерге":
-		h.startBroadcast(ctx, b, update, "just")
+		h.startBroadcast(ctx, update, "just")
 		return
 	case "🔙 Артқа (Back)":
 		if err := h.redisClient.DeleteUserState(ctx, adminId); err != nil {
@@ -178,7 +182,7 @@ func (h *Handler) SendMessage(ctx context.Context, b *bot.Bot, update *models.Up
 
 	if err != nil {
 		h.logger.Error("Failed to load user ids", zap.Error(err))
-		_, sendErr := b.SendMessage(ctx, &bot.SendMessageParams{
+		_, sendErr := h.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: adminId,
 			Text:   fmt.Sprintf("❌ Қате: Пайдаланушы тізімін алу мүмкін болмады\n%s", err.Error()),
 		})
@@ -191,7 +195,7 @@ func (h *Handler) SendMessage(ctx context.Context, b *bot.Bot, update *models.Up
 	userIds = userIds[1:3]
 
 	if len(userIds) == 0 {
-		_, sendErr := b.SendMessage(ctx, &bot.SendMessageParams{
+		_, sendErr := h.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: adminId,
 			Text:   "📭 Хабарлама жіберуге пайдаланушылар табылмады",
 		})
@@ -201,7 +205,7 @@ func (h *Handler) SendMessage(ctx context.Context, b *bot.Bot, update *models.Up
 		return
 	}
 
-	statusMsg, err := b.SendMessage(ctx, &bot.SendMessageParams{
+	statusMsg, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: adminId,
 		Text:   fmt.Sprintf("📤 Хабарлама жіберіліп жатыр...\n👥 Жалпы: %d пайдаланушы", len(userIds)),
 	})
@@ -222,7 +226,7 @@ func (h *Handler) SendMessage(ctx context.Context, b *bot.Bot, update *models.Up
 		wg.Add(1)
 		go func(userId int64) {
 			defer wg.Done()
-			if err := h.sendToUser(ctx, b, userId, msgType, fileId, caption); err != nil {
+			if err := h.sendToUser(ctx, userId, msgType, fileId, caption); err != nil {
 				atomic.AddInt64(&failedCount, 1)
 				h.logger.Warn("Failed to send message to user", zap.Int64("user", userId), zap.Error(err))
 			} else {
@@ -251,10 +255,10 @@ func (h *Handler) SendMessage(ctx context.Context, b *bot.Bot, update *models.Up
 		finalFailed,
 		successRate,
 		h.getBroadcastTypeName(broadcastType),
-		time.Now().Format("2006-01-02 15:04:05"))
+		h.clock.Now().Format("2006-01-02 15:04:05"))
 
 	if statusMsg != nil {
-		b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		h.sender.EditMessageText(ctx, &bot.EditMessageTextParams{
 			ChatID:    adminId,
 			MessageID: statusMsg.ID,
 			Text:      finalText,
@@ -282,14 +286,14 @@ func (h *Handler) SendMessage(ctx context.Context, b *bot.Bot, update *models.Up
 }
 
 // Helper methods for admin panel
-func (h *Handler) handleBroadcastMenu(ctx context.Context, b *bot.Bot, update *models.Update) {
+func (h *Handler) handleBroadcastMenu(ctx context.Context, update *models.Update) {
 	var adminId int64
-	switch update.Message.From.ID {
-	case h.cfg.AdminID:
-		adminId = h.cfg.AdminID
+	switch id := update.Message.From.ID; {
+	case h.cfg.IsSuperAdmin(id):
+		adminId = id
 	default:
 		h.logger.Warn("SomeOne is trying to get admin root", zap.Any("user_id", update.Message.From.ID))
-		b.SendMessage(ctx, &bot.SendMessageParams{
+		h.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: h.cfg.AdminID,
 			Text:   fmt.Sprintf("SomeOne is trying to get admin root, user_id: %d", update.Message.From.ID),
 		})
@@ -328,7 +332,7 @@ func (h *Handler) handleBroadcastMenu(ctx context.Context, b *bot.Bot, update *m
 Қайсы топқа хабарлама жіберуді қалайсыз?`,
 		len(allCount), len(allCount), len(allCount), len(allCount))
 
-	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+	_, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID:      adminId,
 		Text:        message,
 		ReplyMarkup: broadcastKeyboard,
@@ -338,14 +342,14 @@ func (h *Handler) handleBroadcastMenu(ctx context.Context, b *bot.Bot, update *m
 	}
 }
 
-func (h *Handler) startBroadcast(ctx context.Context, b *bot.Bot, update *models.Update, broadcastType string) {
+func (h *Handler) startBroadcast(ctx context.Context, update *models.Update, broadcastType string) {
 	var adminId int64
-	switch update.Message.From.ID {
-	case h.cfg.AdminID:
-		adminId = h.cfg.AdminID
+	switch id := update.Message.From.ID; {
+	case h.cfg.IsSuperAdmin(id):
+		adminId = id
 	default:
 		h.logger.Warn("SomeOne is trying to get admin root", zap.Any("user_id", update.Message.From.ID))
-		b.SendMessage(ctx, &bot.SendMessageParams{
+		h.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: h.cfg.AdminID,
 			Text:   fmt.Sprintf("SomeOne is trying to get admin root, user_id: %d", update.Message.From.ID),
 		})
@@ -362,7 +366,7 @@ func (h *Handler) startBroadcast(ctx context.Context, b *bot.Bot, update *models
 
 	targetDescription := h.getBroadcastTypeName(broadcastType)
 
-	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+	_, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: adminId,
 		Text: fmt.Sprintf(`📝 ХАБАРЛАМА ЖАЗУ
 
@@ -406,12 +410,10 @@ func (h *Handler) getBroadcastTypeName(broadcastType string) string {
 }
 
 // sendExcelFile sends the Excel file to admin via Telegram
-func (h *Handler) sendExcelFile(ctx context.Context, b *bot.Bot, update *models.Update, filePath, caption string) {
-	var adminId int64
-	if update.Message.From.ID == h.cfg.AdminID {
-		adminId = h.cfg.AdminID
-	} else {
-		adminId = h.cfg.AdminID
+func (h *Handler) sendExcelFile(ctx context.Context, update *models.Update, filePath, caption string) {
+	adminId := h.cfg.AdminID
+	if h.cfg.IsSuperAdmin(update.Message.From.ID) {
+		adminId = update.Message.From.ID
 	}
 	// Check if file exists and get file info
 	fileInfo, err := os.Stat(filePath)
@@ -422,7 +424,7 @@ func (h *Handler) sendExcelFile(ctx context.Context, b *bot.Bot, update *models.
 
 	// Telegram has a 50MB file size limit
 	if fileInfo.Size() > 50*1024*1024 {
-		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+		_, _ = h.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: adminId,
 			Text:   "❌ Файл өте үлкен (>50MB). Файл жергілікті сақталды: " + filePath,
 		})
@@ -437,7 +439,7 @@ func (h *Handler) sendExcelFile(ctx context.Context, b *bot.Bot, update *models.
 	}
 	defer file.Close()
 
-	_, err = b.SendDocument(ctx, &bot.SendDocumentParams{
+	_, err = h.sender.SendDocument(ctx, &bot.SendDocumentParams{
 		ChatID:   adminId,
 		Document: &models.InputFileUpload{Filename: filepath.Base(filePath), Data: file},
 		//Caption:  caption + "\n\n📁 Файл: " + filepath.Base(filePath) + "\n📊 Өлшемі: " + formatFileSize(fileInfo.Size()),
@@ -445,7 +447,7 @@ func (h *Handler) sendExcelFile(ctx context.Context, b *bot.Bot, update *models.
 
 	if err != nil {
 		h.logger.Error("Failed to send Excel file", zap.Error(err), zap.String("file", filePath))
-		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+		_, _ = h.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: adminId,
 			Text:   "❌ Excel файлын жіберу мүмкін болмады. Файл жергілікті сақталды: " + filePath,
 		})
@@ -465,14 +467,14 @@ func (h *Handler) sendExcelFile(ctx context.Context, b *bot.Bot, update *models.
 	}
 }
 
-func (h *Handler) handleCloseAdmin(ctx context.Context, b *bot.Bot) {
-	if err := h.redisClient.DeleteUserState(ctx, h.cfg.AdminID); err != nil {
+func (h *Handler) handleCloseAdmin(ctx context.Context, adminId int64) {
+	if err := h.redisClient.DeleteUserState(ctx, adminId); err != nil {
 		h.logger.Error("Failed to delete admin state from Redis", zap.Error(err))
 	}
 
 	// Remove keyboard
-	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID: h.cfg.AdminID,
+	_, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: adminId,
 		Text:   "✅ Админ панелі жабылды",
 		ReplyMarkup: &models.ReplyKeyboardRemove{
 			RemoveKeyboard: true,
@@ -484,25 +486,25 @@ func (h *Handler) handleCloseAdmin(ctx context.Context, b *bot.Bot) {
 }
 
 // sendToUser отправляет одному пользователю указанное сообщение
-func (h *Handler) sendToUser(ctx context.Context, b *bot.Bot, chatID int64, msgType, fileID, caption string) error {
+func (h *Handler) sendToUser(ctx context.Context, chatID int64, msgType, fileID, caption string) error {
 	switch msgType {
 	case "text":
-		_, err := b.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: caption, ProtectContent: true})
+		_, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: caption, ProtectContent: true})
 		return err
 	case "photo":
-		_, err := b.SendPhoto(ctx, &bot.SendPhotoParams{ChatID: chatID, Photo: &models.InputFileString{Data: fileID}, Caption: caption, ProtectContent: true})
+		_, err := h.sender.SendPhoto(ctx, &bot.SendPhotoParams{ChatID: chatID, Photo: &models.InputFileString{Data: fileID}, Caption: caption, ProtectContent: true})
 		return err
 	case "video":
-		_, err := b.SendVideo(ctx, &bot.SendVideoParams{ChatID: chatID, Video: &models.InputFileString{Data: fileID}, Caption: caption, ProtectContent: true})
+		_, err := h.sender.SendVideo(ctx, &bot.SendVideoParams{ChatID: chatID, Video: &models.InputFileString{Data: fileID}, Caption: caption, ProtectContent: true})
 		return err
 	case "document":
-		_, err := b.SendDocument(ctx, &bot.SendDocumentParams{ChatID: chatID, Document: &models.InputFileString{Data: fileID}, Caption: caption, ProtectContent: true})
+		_, err := h.sender.SendDocument(ctx, &bot.SendDocumentParams{ChatID: chatID, Document: &models.InputFileString{Data: fileID}, Caption: caption, ProtectContent: true})
 		return err
 	case "video_note":
-		_, err := b.SendVideoNote(ctx, &bot.SendVideoNoteParams{ChatID: chatID, VideoNote: &models.InputFileString{Data: fileID}, ProtectContent: true})
+		_, err := h.sender.SendVideoNote(ctx, &bot.SendVideoNoteParams{ChatID: chatID, VideoNote: &models.InputFileString{Data: fileID}, ProtectContent: true})
 		return err
 	case "audio":
-		_, err := b.SendAudio(ctx, &bot.SendAudioParams{ChatID: chatID, Audio: &models.InputFileString{Data: fileID}, ProtectContent: true})
+		_, err := h.sender.SendAudio(ctx, &bot.SendAudioParams{ChatID: chatID, Audio: &models.InputFileString{Data: fileID}, ProtectContent: true})
 		return err
 	default:
 		return nil
@@ -533,3 +535,134 @@ func (h *Handler) parseMessage(msg *models.Message) (msgType, fileId, caption st
 		return "", "", ""
 	}
 }
+
+// SubCommandHandler — админ-команда ручного управления подпиской:
+//
+//	/sub <telegram_id> <active|expired> [days]
+//
+// Обновляет users.sub_status/sub_until и последнюю запись в subscriptions
+// в одной транзакции и уведомляет пользователя.
+func (h *Handler) SubCommandHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil || update.Message.From == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !h.cfg.IsSuperAdmin(update.Message.From.ID) {
+		h.logger.Warn("SomeOne is trying to get admin root", zap.Any("user_id", update.Message.From.ID))
+		_, _ = h.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "⛔️ Команда доступна только администратору.",
+		})
+		return
+	}
+
+	reply := func(text string) {
+		if _, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text}); err != nil {
+			h.logger.Warn("send /sub reply", zap.Error(err))
+		}
+	}
+	const usage = "Использование: /sub <telegram_id> <active|expired> [days]"
+
+	fields := strings.Fields(update.Message.Text)
+	if len(fields) < 3 || len(fields) > 4 {
+		reply(usage)
+		return
+	}
+	userID, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || userID <= 0 {
+		reply("❌ Некорректный telegram_id.\n" + usage)
+		return
+	}
+	status := strings.ToLower(fields[2])
+	if status != "active" && status != "expired" {
+		reply("❌ Статус должен быть active или expired.\n" + usage)
+		return
+	}
+	days := 30
+	if len(fields) == 4 {
+		if status != "active" {
+			reply("❌ Количество дней указывается только для active.\n" + usage)
+			return
+		}
+		days, err = strconv.Atoi(fields[3])
+		if err != nil || days <= 0 || days > 3650 {
+			reply("❌ days должно быть числом от 1 до 3650.\n" + usage)
+			return
+		}
+	}
+
+	validUntil, err := h.setSubscriptionStatus(ctx, update.Message.From.ID, userID, status, days)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			reply(fmt.Sprintf("❌ Пользователь %d не найден.", userID))
+			return
+		}
+		h.logger.Error("admin /sub", zap.Error(err))
+		reply("❌ Ошибка базы данных: " + err.Error())
+		return
+	}
+
+	var userText string
+	if status == "active" {
+		reply(fmt.Sprintf("✅ Подписка пользователя %d активна до %s.", userID, formatDate(validUntil)))
+		userText = fmt.Sprintf("✅ Администратор активировал вашу подписку.\nДоступ к оптовым ценам до: %s.", formatDate(validUntil))
+	} else {
+		reply(fmt.Sprintf("✅ Подписка пользователя %d отключена.", userID))
+		userText = "ℹ️ Ваша подписка на «АГРО Клуб Оптовых Цен» отключена администратором.\nЕсли это ошибка — свяжитесь с нами."
+	}
+	if _, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{ChatID: userID, Text: userText}); err != nil {
+		h.logger.Warn("send /sub notice to user", zap.Error(err))
+	}
+}
+
+// setSubscriptionStatus согласованно меняет статус в users и в последней записи subscriptions.
+// Для active возвращает новую дату окончания. sql.ErrNoRows — пользователя нет.
+// adminID — кто выполнил /sub, для audit_log.
+func (h *Handler) setSubscriptionStatus(ctx context.Context, adminID, userID int64, status string, days int) (time.Time, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var validUntil time.Time
+	var res sql.Result
+	if status == "active" {
+		validUntil = h.clock.Now().AddDate(0, 0, days)
+		res, err = tx.Exec(`UPDATE users SET sub_status = 'active', sub_until = ? WHERE user_id = ?`, validUntil, userID)
+	} else {
+		res, err = tx.Exec(`UPDATE users SET sub_status = 'expired', sub_until = NULL WHERE user_id = ?`, userID)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("update users: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return time.Time{}, sql.ErrNoRows
+	}
+
+	var subID int64
+	err = tx.QueryRow(`SELECT id FROM subscriptions WHERE user_id = ? ORDER BY id DESC LIMIT 1`, userID).Scan(&subID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows) && status == "active":
+		_, err = tx.Exec(`
+			INSERT INTO subscriptions (user_id, status, amount, paid_at, valid_until)
+			VALUES (?, 'active', 0, CURRENT_TIMESTAMP, ?)
+		`, userID, validUntil)
+	case errors.Is(err, sql.ErrNoRows):
+		err = nil
+	case err != nil:
+	case status == "active":
+		_, err = tx.Exec(`UPDATE subscriptions SET status = 'active', valid_until = ? WHERE id = ?`, validUntil, subID)
+	default:
+		_, err = tx.Exec(`UPDATE subscriptions SET status = 'expired' WHERE id = ?`, subID)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("update subscriptions: %w", err)
+	}
+	details := map[string]any{"status": status, "days": days, "source": "bot"}
+	if err := h.writeAudit(tx, adminID, "subscription.set", fmt.Sprint(userID), "", details); err != nil {
+		return time.Time{}, fmt.Errorf("write audit: %w", err)
+	}
+
+	return validUntil, tx.Commit()
+}
@@ -0,0 +1,129 @@
+// handler/admin-message.go
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// adminMessageLimit личных сообщений в час от одного админа
+	adminMessageLimit  = 10
+	adminMessageWindow = time.Hour
+
+	// ограничения Bot API на длину текста и подписи к фото
+	adminMessageMaxText    = 4096
+	adminMessageMaxCaption = 1024
+)
+
+type adminMessageIn struct {
+	UserID   int64  `json:"user_id"`
+	Text     string `json:"text"`
+	PhotoURL string `json:"photo_url"`
+}
+
+// POST /api/admin/send-message {"user_id", "text", "photo_url"} — личное
+// сообщение покупателю от имени бота. С photo_url уходит фото, text — подпись.
+// Не больше adminMessageLimit сообщений в час от одного админа (при Redis),
+// каждое отправленное записывается в журнал действий.
+func (h *Handler) handleAdminSendMessage(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in adminMessageIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("bad json"))
+		return
+	}
+	in.Text = strings.TrimSpace(in.Text)
+	in.PhotoURL = strings.TrimSpace(in.PhotoURL)
+	if in.UserID <= 0 {
+		writeError(w, ErrBadRequest("user_id is required"))
+		return
+	}
+	maxText := adminMessageMaxText
+	if in.PhotoURL != "" {
+		u, err := url.Parse(in.PhotoURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			writeError(w, ErrBadRequest("photo_url must be an http(s) URL"))
+			return
+		}
+		maxText = adminMessageMaxCaption
+	} else if in.Text == "" {
+		writeError(w, ErrBadRequest("text or photo_url is required"))
+		return
+	}
+	if utf8.RuneCountInString(in.Text) > maxText {
+		writeError(w, ErrBadRequest(fmt.Sprintf("text must not exceed %d characters", maxText)))
+		return
+	}
+
+	var one int
+	err := h.db.QueryRowContext(r.Context(), `SELECT 1 FROM users WHERE user_id = ?`, in.UserID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, ErrNotFound("user"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("select user", zap.Int64("user_id", in.UserID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	// в outbox не откладываем: админу нужен message_id отправленного сообщения
+	if h.sender == nil {
+		writeError(w, ErrServiceUnavailable("bot is not connected"))
+		return
+	}
+
+	adminID := h.auditActor(r)
+	if h.redisClient != nil {
+		key := fmt.Sprintf("admin:message:%d", adminID)
+		allowed, left, err := h.redisClient.HitLimit(r.Context(), key, adminMessageLimit, adminMessageWindow)
+		if err != nil {
+			h.logger.Warn("admin message limiter", zap.Error(err))
+		} else if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(left.Seconds()))))
+			writeError(w, ErrTooManyRequests(fmt.Sprintf("no more than %d messages per hour", adminMessageLimit)))
+			return
+		}
+	}
+
+	var msg *models.Message
+	if in.PhotoURL != "" {
+		msg, err = h.sender.SendPhoto(r.Context(), &bot.SendPhotoParams{
+			ChatID:  in.UserID,
+			Photo:   &models.InputFileString{Data: in.PhotoURL},
+			Caption: in.Text,
+		})
+	} else {
+		msg, err = h.sender.SendMessage(r.Context(), &bot.SendMessageParams{ChatID: in.UserID, Text: in.Text})
+	}
+	if err != nil {
+		h.logger.Warn("admin message to user", zap.Int64("user_id", in.UserID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	sentAt := h.clock.Now().UTC()
+
+	h.auditQuiet(adminID, "user.message", fmt.Sprintf("user:%d", in.UserID), map[string]any{
+		"text":       in.Text,
+		"photo_url":  in.PhotoURL,
+		"message_id": msg.ID,
+	})
+	jsonOK(w, map[string]any{"message_id": msg.ID, "sent_at": sentAt})
+}
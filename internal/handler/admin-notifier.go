@@ -0,0 +1,324 @@
+// handler/admin-notifier.go
+package handler
+
+import (
+	"agro/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+const (
+	adminNotifyWindow     = 3 * time.Second // уведомления в этом окне после отправки склеиваются
+	adminNotifyDedupTTL   = time.Minute     // одинаковый текст в течение минуты не повторяем
+	adminNotifyQueueSize  = 256
+	adminNotifyMaxRetries = 3    // повторы после 429 от Telegram
+	adminNotifyMaxLen     = 4000 // лимит Telegram — 4096 символов, оставляем запас
+)
+
+// adminNotice — одно уведомление администратору.
+type adminNotice struct {
+	text    string
+	orderID int64                           // >0 — новый заказ; несколько заказов склеиваются в сводку
+	send    func(ctx context.Context) error // отправка одиночного уведомления; nil — просто текст
+}
+
+// adminNotifier — очередь уведомлений админу с одним воркером: Telegram не любит
+// десятки параллельных отправок в один чат. Считает queued / sent / dropped.
+type adminNotifier struct {
+	queue  chan adminNotice
+	window time.Duration
+	start  sync.Once
+
+	mu   sync.Mutex
+	seen map[string]time.Time // текст → когда последний раз ставили в очередь
+
+	queued, sent, dropped, batches atomic.Int64
+}
+
+func newAdminNotifier() *adminNotifier {
+	return &adminNotifier{
+		queue:  make(chan adminNotice, adminNotifyQueueSize),
+		window: adminNotifyWindow,
+		seen:   map[string]time.Time{},
+	}
+}
+
+// duplicate — такой же текст уже был в последнюю минуту.
+func (n *adminNotifier) duplicate(text string, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for k, at := range n.seen {
+		if now.Sub(at) >= adminNotifyDedupTTL {
+			delete(n.seen, k)
+		}
+	}
+	if _, ok := n.seen[text]; ok {
+		return true
+	}
+	n.seen[text] = now
+	return false
+}
+
+// enqueueAdmin ставит уведомление в очередь; воркер стартует при первом вызове.
+// Не блокируется: при переполненной очереди уведомление отбрасывается.
+// Пока бот не подключён, текст уведомления ждёт в outbox (без кнопок).
+func (h *Handler) enqueueAdmin(nt adminNotice) {
+	if h.cfg == nil || h.cfg.AdminID == 0 {
+		return
+	}
+	if h.sender == nil {
+		h.sendOrQueueQuiet(h.ctx, &bot.SendMessageParams{ChatID: h.cfg.AdminID, Text: nt.text}, "admin notification")
+		return
+	}
+	n := h.notifier
+	n.start.Do(func() { go h.runAdminNotifier(h.ctx) })
+	if n.duplicate(nt.text, time.Now()) {
+		n.dropped.Add(1)
+		h.logger.Info("admin notification deduplicated", zap.Int64("order_id", nt.orderID))
+		return
+	}
+	if h.sharedDuplicate(nt.text) {
+		n.dropped.Add(1)
+		h.logger.Info("admin notification deduplicated via redis", zap.Int64("order_id", nt.orderID))
+		return
+	}
+	select {
+	case n.queue <- nt:
+		n.queued.Add(1)
+	default:
+		n.dropped.Add(1)
+		h.logger.Warn("admin notification queue is full, dropping", zap.Int64("order_id", nt.orderID))
+	}
+}
+
+// sharedDuplicate — тот же текст за последнюю минуту уже отправил другой экземпляр
+// бота (SET NX в Redis). Redis недоступен — отправляем: лучше дубль, чем тишина.
+func (h *Handler) sharedDuplicate(text string) bool {
+	if h.redisClient == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(h.ctx, time.Second)
+	defer cancel()
+	allowed, _, err := h.redisClient.HitOnce(ctx, repository.NotifyDedupKey(text), adminNotifyDedupTTL)
+	if err != nil {
+		h.logger.Warn("admin notification dedup", zap.Error(err))
+		return false
+	}
+	return !allowed
+}
+
+// runAdminNotifier — воркер очереди. Первое уведомление после затишья уходит сразу,
+// всё, что пришло в течение window после отправки, уходит одной пачкой.
+func (h *Handler) runAdminNotifier(ctx context.Context) {
+	n := h.notifier
+	var last time.Time
+	for {
+		var first adminNotice
+		select {
+		case <-ctx.Done():
+			return
+		case first = <-n.queue:
+		}
+		batch := []adminNotice{first}
+		if wait := n.window - time.Since(last); wait > 0 {
+			timer := time.NewTimer(wait)
+		collect:
+			for {
+				select {
+				case nt := <-n.queue:
+					batch = append(batch, nt)
+				case <-timer.C:
+					break collect
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				}
+			}
+		}
+		h.flushAdminNotices(ctx, batch)
+		last = time.Now()
+	}
+}
+
+func (h *Handler) flushAdminNotices(ctx context.Context, batch []adminNotice) {
+	var orders, plain []adminNotice
+	for _, nt := range batch {
+		if nt.orderID > 0 {
+			orders = append(orders, nt)
+		} else {
+			plain = append(plain, nt)
+		}
+	}
+	if len(batch) > 1 {
+		h.notifier.batches.Add(1)
+	}
+
+	switch len(orders) {
+	case 0:
+	case 1:
+		h.deliverAdmin(ctx, 1, orders[0].send)
+	default:
+		text, kb := ordersDigest(orders)
+		h.deliverAdmin(ctx, len(orders), func(ctx context.Context) error {
+			_, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{ChatID: h.cfg.AdminID, Text: text, ReplyMarkup: kb})
+			return err
+		})
+	}
+
+	texts := make([]string, 0, len(plain))
+	for _, nt := range plain {
+		texts = append(texts, nt.text)
+	}
+	for _, chunk := range packTexts(texts, "\n\n———\n\n", adminNotifyMaxLen) {
+		h.deliverAdmin(ctx, chunk.count, func(ctx context.Context) error {
+			_, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{ChatID: h.cfg.AdminID, Text: chunk.text})
+			return err
+		})
+	}
+}
+
+// deliverAdmin отправляет с учётом 429: ждём retry_after и пробуем снова.
+// count — сколько уведомлений из очереди покрывает эта отправка.
+func (h *Handler) deliverAdmin(ctx context.Context, count int, send func(ctx context.Context) error) {
+	n := h.notifier
+	for attempt := 0; ; attempt++ {
+		err := send(ctx)
+		if err == nil {
+			n.sent.Add(int64(count))
+			return
+		}
+		var tooMany *bot.TooManyRequestsError
+		if errors.As(err, &tooMany) && attempt < adminNotifyMaxRetries {
+			wait := time.Duration(max(tooMany.RetryAfter, 1)) * time.Second
+			h.logger.Warn("telegram rate limit, waiting", zap.Duration("retry_after", wait))
+			select {
+			case <-time.After(wait):
+				continue
+			case <-ctx.Done():
+				n.dropped.Add(int64(count))
+				return
+			}
+		}
+		n.dropped.Add(int64(count))
+		h.logger.Warn("notify admin", zap.Int("notices", count), zap.Error(err))
+		return
+	}
+}
+
+// ordersDigest — сводка по нескольким новым заказам: полные тексты подряд
+// и кнопка на каждый заказ, открывающая его карточку с кнопками статусов.
+func ordersDigest(orders []adminNotice) (string, *models.InlineKeyboardMarkup) {
+	nums := make([]string, 0, len(orders))
+	texts := make([]string, 0, len(orders))
+	kb := &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{}}
+	var row []models.InlineKeyboardButton
+	for _, o := range orders {
+		nums = append(nums, fmt.Sprintf("№%d", o.orderID))
+		texts = append(texts, o.text)
+		row = append(row, models.InlineKeyboardButton{
+			Text:         fmt.Sprintf("📋 №%d", o.orderID),
+			CallbackData: fmt.Sprintf("ord_show:%d", o.orderID),
+		})
+		if len(row) == 3 {
+			kb.InlineKeyboard = append(kb.InlineKeyboard, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		kb.InlineKeyboard = append(kb.InlineKeyboard, row)
+	}
+	head := fmt.Sprintf("🛒 %d %s: %s", len(orders),
+		ruPlural(len(orders), "новый заказ", "новых заказа", "новых заказов"), strings.Join(nums, ", "))
+	text := head + "\n\n" + strings.Join(texts, "\n\n———\n\n")
+	if r := []rune(text); len(r) > adminNotifyMaxLen {
+		text = string(r[:adminNotifyMaxLen-1]) + "…"
+	}
+	return text, kb
+}
+
+type textChunk struct {
+	text  string
+	count int
+}
+
+// packTexts склеивает тексты через sep в куски не длиннее limit символов.
+func packTexts(texts []string, sep string, limit int) []textChunk {
+	var (
+		out []textChunk
+		cur textChunk
+	)
+	for _, t := range texts {
+		if r := []rune(t); len(r) > limit {
+			t = string(r[:limit-1]) + "…"
+		}
+		if cur.count > 0 && len([]rune(cur.text))+len([]rune(sep))+len([]rune(t)) > limit {
+			out = append(out, cur)
+			cur = textChunk{}
+		}
+		if cur.count > 0 {
+			cur.text += sep
+		}
+		cur.text += t
+		cur.count++
+	}
+	if cur.count > 0 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+// sendOrderCard — карточка заказа с кнопками статусов (кнопка «📋 №N» из сводки).
+func (h *Handler) sendOrderCard(ctx context.Context, orderID int64) error {
+	order, items, err := h.orderRepo.GetOrderWithItems(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "🛒 Заказ №%d\nТочка: %s\n\n", order.ID, firstNonEmpty(order.StoreCode, "—"))
+	for _, it := range items {
+		if it.IsDelivery() {
+			continue
+		}
+		fmt.Fprintf(&b, "• %s — %.2f %s = %s\n", itemLabel(it.Emoji, it.Name), it.Qty, it.Unit, formatMoney(it.Amount))
+	}
+	b.WriteString("\n")
+	writeOrderTotals(&b, order.GoodsTotal, order.DeliveryPrice, "Итого")
+	b.WriteString(orderStatusMarker + humanOrderStatus(order.Status))
+	_, err = h.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      h.cfg.AdminID,
+		Text:        b.String(),
+		ReplyMarkup: orderActionsMarkup(order.ID, order.Status),
+	})
+	return err
+}
+
+// GET /api/admin/notifications/stats — счётчики очереди уведомлений админу
+// и, с настоящим ботом, повторов и предохранителя вызовов Telegram.
+func (h *Handler) handleAdminNotifierStats(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	n := h.notifier
+	out := map[string]any{
+		"queued":  n.queued.Load(),
+		"sent":    n.sent.Load(),
+		"dropped": n.dropped.Load(),
+		"batches": n.batches.Load(),
+		"pending": len(n.queue),
+	}
+	if h.telegram != nil {
+		out["telegram"] = h.telegram.stats()
+	}
+	jsonOK(w, out)
+}
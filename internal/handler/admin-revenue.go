@@ -0,0 +1,145 @@
+// handler/admin-revenue.go
+package handler
+
+import (
+	"agro/traits/database"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// revenueMaxDays — самый длинный диапазон для /api/admin/revenue.
+const revenueMaxDays = 365
+
+// revenueOrderStatuses — заказы, деньги за которые получены: оплаченные
+// и ушедшие дальше по сборке и доставке.
+var revenueOrderStatuses = []string{"paid", orderPreparing, orderDelivering, orderDone}
+
+// revenuePeriods — выражение SQLite для начала периода; %s — created_at,
+// сдвинутый в часовой пояс TIMEZONE. Неделя начинается с понедельника.
+var revenuePeriods = map[string]string{
+	"day":   "date(%s)",
+	"week":  "date(%s, 'weekday 0', '-6 days')",
+	"month": "strftime('%%Y-%%m-01', %s)",
+}
+
+// revenuePeriodsPostgres — те же периоды для PostgreSQL
+// (date_trunc('week') тоже начинает неделю с понедельника).
+var revenuePeriodsPostgres = map[string]string{
+	"day":   "to_char(%s, 'YYYY-MM-DD')",
+	"week":  "to_char(date_trunc('week', %s), 'YYYY-MM-DD')",
+	"month": "to_char(date_trunc('month', %s), 'YYYY-MM-DD')",
+}
+
+type revenuePeriodOut struct {
+	Period      string `json:"period"`
+	OrderCount  int64  `json:"order_count"`
+	TotalKZT    int64  `json:"total_kzt"`
+	GoodsKZT    int64  `json:"goods_kzt"`    // без доставки
+	DeliveryKZT int64  `json:"delivery_kzt"` // оплаченная доставка
+}
+
+// GET /api/admin/revenue?from=2024-01-01&to=2024-03-31&group_by=day|week|month —
+// выручка по оплаченным заказам за период (даты включительно, по часовому поясу
+// TIMEZONE) с разбивкой по дням, неделям или месяцам и итогом в aggregate.
+func (h *Handler) handleAdminRevenue(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	q := r.URL.Query()
+	loc := display().loc
+
+	var bounds [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		d, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(q.Get(name)), loc)
+		if err != nil {
+			writeError(w, ErrBadRequest(name+" must be YYYY-MM-DD"))
+			return
+		}
+		bounds[i] = d
+	}
+	from, to := bounds[0], bounds[1]
+	if to.Before(from) {
+		writeError(w, ErrBadRequest("to must not be before from"))
+		return
+	}
+	if to.Sub(from) > revenueMaxDays*24*time.Hour {
+		writeError(w, ErrBadRequest(fmt.Sprintf("range must not exceed %d days", revenueMaxDays)))
+		return
+	}
+	groupBy := firstNonEmpty(strings.TrimSpace(q.Get("group_by")), "day")
+	exprs, localFmt := revenuePeriods, "created_at, '%+d seconds'"
+	if database.IsPostgres(h.db) {
+		exprs, localFmt = revenuePeriodsPostgres, "created_at + INTERVAL '%d seconds'"
+	}
+	periodExpr, ok := exprs[groupBy]
+	if !ok {
+		writeError(w, ErrBadRequest("group_by must be day, week or month"))
+		return
+	}
+
+	// created_at хранится в UTC: границы и периоды считаем в местном времени
+	_, offset := from.Zone()
+	local := fmt.Sprintf(localFmt, offset)
+	period := fmt.Sprintf(periodExpr, local)
+	args := []any{
+		from.UTC().Format(dbTimeLayout),
+		to.AddDate(0, 0, 1).UTC().Format(dbTimeLayout),
+	}
+	for _, s := range revenueOrderStatuses {
+		args = append(args, s)
+	}
+	// части заказа из нескольких точек не считаем: деньги — в общем заказе
+	rows, err := h.db.QueryContext(r.Context(), `
+		SELECT `+period+` AS period, COUNT(1), COALESCE(SUM(total_amount), 0),
+		       COALESCE(SUM(goods_total), 0), COALESCE(SUM(delivery_price), 0)
+		FROM orders
+		WHERE created_at >= ? AND created_at < ? AND parent_order_id IS NULL
+		  AND status IN (?`+strings.Repeat(", ?", len(revenueOrderStatuses)-1)+`)
+		GROUP BY period
+		ORDER BY period
+	`, args...)
+	if err != nil {
+		h.logger.Error("admin revenue", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer rows.Close()
+
+	periods := []revenuePeriodOut{}
+	var total revenuePeriodOut
+	for rows.Next() {
+		var p revenuePeriodOut
+		if err := rows.Scan(&p.Period, &p.OrderCount, &p.TotalKZT, &p.GoodsKZT, &p.DeliveryKZT); err != nil {
+			h.logger.Error("scan admin revenue", zap.Error(err))
+			writeError(w, ErrInternal(err))
+			return
+		}
+		periods = append(periods, p)
+		total.OrderCount += p.OrderCount
+		total.TotalKZT += p.TotalKZT
+		total.GoodsKZT += p.GoodsKZT
+		total.DeliveryKZT += p.DeliveryKZT
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	jsonOK(w, map[string]any{
+		"from":     from.Format("2006-01-02"),
+		"to":       to.Format("2006-01-02"),
+		"group_by": groupBy,
+		"periods":  periods,
+		"aggregate": map[string]int64{
+			"order_count":  total.OrderCount,
+			"total_kzt":    total.TotalKZT,
+			"goods_kzt":    total.GoodsKZT,
+			"delivery_kzt": total.DeliveryKZT,
+		},
+	})
+}
@@ -0,0 +1,112 @@
+// handler/admin-users.go
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	adminUsersDefaultLimit = 50
+	adminUsersMaxLimit     = 200
+)
+
+// handleAdminListUsers — справочник покупателей для админки, новые сверху:
+// GET /api/admin/users?q=phone_or_nickname&status=active&limit=50&offset=0
+// q ищет по нику и телефону, включая прежние номера из user_phones;
+// номер в любом виде («8 701 …», «+7 (701) …») приводится к +7XXXXXXXXXX.
+func (h *Handler) handleAdminListUsers(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	q := r.URL.Query()
+
+	where := []string{"1=1"}
+	var args []any
+	if s := strings.TrimSpace(q.Get("q")); s != "" {
+		phone := s
+		if n := normalizePhone(s); n != "" {
+			phone = n
+		}
+		phoneLike := "%" + escapeLike(phone) + "%"
+		where = append(where, `(u.phone LIKE ? ESCAPE '\' OR u.nickname LIKE ? ESCAPE '\'
+			OR u.user_id IN (SELECT user_id FROM user_phones WHERE phone LIKE ? ESCAPE '\'))`)
+		args = append(args, phoneLike, "%"+escapeLike(s)+"%", phoneLike)
+	}
+	if s := strings.TrimSpace(q.Get("status")); s != "" {
+		where = append(where, "COALESCE(u.sub_status, 'inactive') = ?")
+		args = append(args, s)
+	}
+
+	limit := adminUsersDefaultLimit
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		limit = min(v, adminUsersMaxLimit)
+	}
+	offset := 0
+	if v, err := strconv.Atoi(q.Get("offset")); err == nil && v > 0 {
+		offset = v
+	}
+
+	cond := strings.Join(where, " AND ")
+	var total int64
+	if err := h.db.QueryRow(`SELECT COUNT(1) FROM users u WHERE `+cond, args...).Scan(&total); err != nil {
+		h.logger.Error("count users", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	rows, err := h.db.Query(`
+		SELECT u.id, u.user_id, u.nickname, COALESCE(u.phone, ''), COALESCE(u.sub_status, 'inactive'),
+		       u.sub_until, COALESCE(u.selected_store, ''),
+		       (SELECT COUNT(1) FROM orders o WHERE o.user_id = u.user_id AND o.parent_order_id IS NULL), u.created_at
+		FROM users u
+		WHERE `+cond+`
+		ORDER BY u.created_at DESC, u.user_id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, limit, offset)...)
+	if err != nil {
+		h.logger.Error("list users", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer rows.Close()
+
+	type userOut struct {
+		ID            string     `json:"id"`
+		UserID        int64      `json:"user_id"`
+		Nickname      string     `json:"nickname"`
+		Phone         string     `json:"phone"`
+		SubStatus     string     `json:"sub_status"`
+		SubUntil      *time.Time `json:"sub_until"`
+		SelectedStore string     `json:"selected_store"`
+		OrderCount    int64      `json:"order_count"`
+		CreatedAt     time.Time  `json:"created_at"`
+	}
+	items := []userOut{}
+	for rows.Next() {
+		var (
+			u        userOut
+			subUntil sql.NullTime
+		)
+		if err := rows.Scan(&u.ID, &u.UserID, &u.Nickname, &u.Phone, &u.SubStatus, &subUntil, &u.SelectedStore, &u.OrderCount, &u.CreatedAt); err != nil {
+			h.logger.Error("scan user", zap.Error(err))
+			continue
+		}
+		if subUntil.Valid {
+			u.SubUntil = &subUntil.Time
+		}
+		items = append(items, u)
+	}
+	jsonOK(w, map[string]any{
+		"items":  items,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
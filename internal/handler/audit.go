@@ -0,0 +1,186 @@
+// handler/audit.go
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// execer — общий интерфейс *sql.DB и *sql.Tx, чтобы писать в журнал
+// в той же транзакции, что и само действие.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// writeAudit пишет действие администратора в audit_log.
+// details сериализуется в JSON как есть.
+func (h *Handler) writeAudit(ex execer, adminID int64, action, target, reason string, details any) error {
+	var raw []byte
+	if details != nil {
+		b, err := json.Marshal(details)
+		if err != nil {
+			return err
+		}
+		raw = b
+	}
+	_, err := ex.Exec(`
+		INSERT INTO audit_log (admin_id, action, target, reason, details)
+		VALUES (?, ?, ?, ?, ?)
+	`, adminID, action, nullString(target), nullString(reason), nullString(string(raw)))
+	return err
+}
+
+// auditQuiet — запись в журнал для действий без общей транзакции
+// (товары, точки, статусы заказов): само действие уже выполнено,
+// поэтому ошибку записи только логируем.
+func (h *Handler) auditQuiet(adminID int64, action, target string, details any) {
+	if err := h.writeAudit(h.db, adminID, action, target, "", details); err != nil {
+		h.logger.Error("write audit", zap.String("action", action), zap.String("target", target), zap.Error(err))
+	}
+}
+
+// auditActor — кто выполняет действие в админке: Telegram ID из X-Telegram-Id
+// (главный админ или управляющий точкой). Без заголовка — ADMIN_ID.
+func (h *Handler) auditActor(r *http.Request) int64 {
+	if id, err := strconv.ParseInt(strings.TrimSpace(r.Header.Get("X-Telegram-Id")), 10, 64); err == nil && id > 0 {
+		return id
+	}
+	return h.cfg.AdminID
+}
+
+// auditEntity — сущность записи журнала: префикс action до точки
+// («product.update» → «product»).
+func auditEntity(action string) string {
+	entity, _, _ := strings.Cut(action, ".")
+	return entity
+}
+
+const (
+	adminAuditDefaultLimit = 50
+	adminAuditMaxLimit     = 200
+)
+
+type auditEntryOut struct {
+	ID        int64           `json:"id"`
+	AdminID   int64           `json:"admin_id"`
+	Action    string          `json:"action"`
+	Entity    string          `json:"entity"`
+	EntityID  string          `json:"entity_id"`
+	Reason    string          `json:"reason,omitempty"`
+	Details   json.RawMessage `json:"details"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// handleAdminListAudit — журнал действий админов, новые сверху:
+// GET /api/admin/audit?admin_id=&entity=product&entity_id=12&action=product.update&from=2025-01-01&to=2025-01-31&limit=50&offset=0
+// entity — все действия над сущностью (product.add, product.update, …);
+// from/to — даты (UTC, включительно).
+func (h *Handler) handleAdminListAudit(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	q := r.URL.Query()
+
+	where := []string{"1=1"}
+	var args []any
+	if v := strings.TrimSpace(q.Get("admin_id")); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeError(w, ErrBadRequest("admin_id must be a number"))
+			return
+		}
+		where = append(where, "admin_id = ?")
+		args = append(args, id)
+	}
+	if v := strings.TrimSpace(q.Get("entity")); v != "" {
+		where = append(where, `(action = ? OR action LIKE ? ESCAPE '\')`)
+		args = append(args, v, escapeLike(v)+".%")
+	}
+	if v := strings.TrimSpace(q.Get("entity_id")); v != "" {
+		where = append(where, "target = ?")
+		args = append(args, v)
+	}
+	if v := strings.TrimSpace(q.Get("action")); v != "" {
+		where = append(where, "action = ?")
+		args = append(args, v)
+	}
+	for _, p := range []struct {
+		name, op string
+		days     int
+	}{{"from", ">=", 0}, {"to", "<", 1}} {
+		v := strings.TrimSpace(q.Get(p.name))
+		if v == "" {
+			continue
+		}
+		d, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			writeError(w, ErrBadRequest(p.name+" must be YYYY-MM-DD"))
+			return
+		}
+		where = append(where, "created_at "+p.op+" ?")
+		args = append(args, d.AddDate(0, 0, p.days).Format("2006-01-02 15:04:05"))
+	}
+
+	limit := adminAuditDefaultLimit
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		limit = min(v, adminAuditMaxLimit)
+	}
+	offset := 0
+	if v, err := strconv.Atoi(q.Get("offset")); err == nil && v > 0 {
+		offset = v
+	}
+
+	cond := strings.Join(where, " AND ")
+	var total int64
+	if err := h.db.QueryRow(`SELECT COUNT(1) FROM audit_log WHERE `+cond, args...).Scan(&total); err != nil {
+		h.logger.Error("count audit log", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	rows, err := h.db.Query(`
+		SELECT id, admin_id, action, COALESCE(target, ''), COALESCE(reason, ''), details, created_at
+		FROM audit_log
+		WHERE `+cond+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, limit, offset)...)
+	if err != nil {
+		h.logger.Error("list audit log", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer rows.Close()
+
+	items := []auditEntryOut{}
+	for rows.Next() {
+		var (
+			e       auditEntryOut
+			details sql.NullString
+		)
+		if err := rows.Scan(&e.ID, &e.AdminID, &e.Action, &e.EntityID, &e.Reason, &details, &e.CreatedAt); err != nil {
+			h.logger.Error("scan audit entry", zap.Error(err))
+			continue
+		}
+		e.Entity = auditEntity(e.Action)
+		if details.Valid && json.Valid([]byte(details.String)) {
+			e.Details = json.RawMessage(details.String)
+		}
+		items = append(items, e)
+	}
+	jsonOK(w, map[string]any{
+		"items":  items,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
@@ -0,0 +1,111 @@
+// handler/backup-handler.go
+package handler
+
+import (
+	"agro/traits/database"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+// лимит Telegram Bot API на отправку документов
+const telegramMaxDocumentSize = 50 * 1024 * 1024
+
+// StartBackups запускает фоновый цикл резервного копирования базы
+// с интервалом cfg.BackupInterval.
+func (h *Handler) StartBackups(ctx context.Context) {
+	if h.cfg.BackupInterval <= 0 {
+		h.logger.Info("scheduled backups disabled")
+		return
+	}
+	h.logger.Info("started backup scheduler",
+		zap.String("dir", h.cfg.BackupDir),
+		zap.Duration("interval", h.cfg.BackupInterval),
+		zap.Int("keep", h.cfg.BackupKeep))
+
+	ticker := time.NewTicker(h.cfg.BackupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			h.logger.Info("stopping backup scheduler", zap.Error(ctx.Err()))
+			return
+		case <-ticker.C:
+			if _, err := h.runBackup(ctx); err != nil {
+				h.notifyAdmin(fmt.Sprintf("⚠️ Не удалось сделать резервную копию базы:\n%s", err.Error()))
+			}
+		}
+	}
+}
+
+// runBackup делает снапшот и чистит старые копии.
+func (h *Handler) runBackup(ctx context.Context) (string, error) {
+	path, err := database.BackupDatabase(ctx, h.db, h.cfg.BackupDir)
+	if err != nil {
+		h.logger.Error("backup database", zap.Error(err))
+		return "", err
+	}
+	h.logger.Info("database backup created", zap.String("file", path))
+
+	if err := database.PruneBackups(h.cfg.BackupDir, h.cfg.BackupKeep); err != nil {
+		h.logger.Warn("prune old backups", zap.Error(err))
+	}
+	return path, nil
+}
+
+// BackupHandler — админ-команда /backup: делает снапшот и присылает файл в чат.
+func (h *Handler) BackupHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil || update.Message.From == nil {
+		return
+	}
+	if !h.cfg.IsSuperAdmin(update.Message.From.ID) {
+		h.logger.Warn("SomeOne is trying to get admin root", zap.Any("user_id", update.Message.From.ID))
+		return
+	}
+	chatID := update.Message.Chat.ID
+
+	path, err := h.runBackup(ctx)
+	if err != nil {
+		_, _ = h.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "❌ Резервная копия не создана: " + err.Error(),
+		})
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		h.logger.Error("stat backup file", zap.Error(err))
+		return
+	}
+	if info.Size() > telegramMaxDocumentSize {
+		_, _ = h.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "✅ Резервная копия создана, но файл больше 50MB и сохранён только на сервере: " + path,
+		})
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		h.logger.Error("open backup file", zap.Error(err))
+		return
+	}
+	defer file.Close()
+
+	_, err = h.sender.SendDocument(ctx, &bot.SendDocumentParams{
+		ChatID:   chatID,
+		Document: &models.InputFileUpload{Filename: filepath.Base(path), Data: file},
+		Caption:  fmt.Sprintf("🗄 Резервная копия базы (%s)", h.clock.Now().Format("2006-01-02 15:04")),
+	})
+	if err != nil {
+		h.logger.Error("send backup file", zap.Error(err))
+	}
+}
@@ -0,0 +1,129 @@
+// handler/catalog-changes.go
+package handler
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// dbTimeLayout — как SQLite хранит CURRENT_TIMESTAMP (UTC, до секунды).
+const dbTimeLayout = "2006-01-02 15:04:05"
+
+type productChangesOut struct {
+	ServerTime time.Time    `json:"server_time"` // следующий since
+	Changed    []productOut `json:"changed"`
+	Removed    []int64      `json:"removed"`
+}
+
+// GET /api/products/changes?since=<RFC3339> — что изменилось в каталоге после since:
+// changed — новые и изменённые товары в том же виде, что /api/products,
+// removed — id удалённых, снятых с продажи и ушедших из выбранного магазина.
+// Мини-апп хранит каталог у себя и в следующий раз передаёт server_time из
+// ответа: это время сервера, поэтому часы телефона на синхронизацию не влияют.
+// Граница включительная (updated_at хранится до секунды), так что товар,
+// изменённый в ту же секунду, придёт повторно — клиент заменяет его по id.
+func (h *Handler) handleProductChanges(w http.ResponseWriter, r *http.Request) {
+	since, err := time.Parse(time.RFC3339, strings.TrimSpace(r.URL.Query().Get("since")))
+	if err != nil {
+		writeError(w, ErrBadRequest("since must be RFC3339").WithField("since", r.URL.Query().Get("since")))
+		return
+	}
+	// время фиксируем до выборки: правка во время запроса придёт в следующий раз
+	serverTime := h.clock.Now().UTC().Truncate(time.Second)
+	store := h.selectedStore(r)
+
+	touched, removed, err := h.productChangesSince(r.Context(), since)
+	if err != nil {
+		h.logger.Error("select product changes", zap.Time("since", since), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	out := productChangesOut{ServerTime: serverTime, Changed: []productOut{}, Removed: removed}
+	if len(touched) > 0 {
+		visible, err := h.listProducts(store, "")
+		if err != nil {
+			h.logger.Error("select products", zap.Error(err))
+			writeError(w, ErrInternal(err))
+			return
+		}
+		if !h.isSubscriber(r) {
+			visible = guestPrices(visible)
+		}
+		for _, p := range visible {
+			if touched[p.ID] {
+				out.Changed = append(out.Changed, p)
+				delete(touched, p.ID)
+			}
+		}
+		// изменились, но в выдаче /api/products их нет: сняты с продажи или из другого магазина
+		for id := range touched {
+			out.Removed = append(out.Removed, id)
+		}
+		slices.Sort(out.Removed)
+	}
+	jsonOK(w, out)
+}
+
+// productChangesSince — id товаров, изменённых начиная с since, и id удалённых.
+func (h *Handler) productChangesSince(ctx context.Context, since time.Time) (map[int64]bool, []int64, error) {
+	at := since.UTC().Format(dbTimeLayout)
+
+	touched := map[int64]bool{}
+	rows, err := h.db.QueryContext(ctx, `SELECT id FROM products WHERE updated_at >= ?`, at)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		touched[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	removed := []int64{}
+	rows, err = h.db.QueryContext(ctx, `SELECT product_id FROM product_deletions WHERE deleted_at >= ? ORDER BY product_id`, at)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, nil, err
+		}
+		removed = append(removed, id)
+	}
+	return touched, removed, rows.Err()
+}
+
+// deleteProduct удаляет товар и запоминает его id для /api/products/changes.
+func (h *Handler) deleteProduct(ctx context.Context, id int64) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM products WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO product_deletions (product_id) VALUES (?)`, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,175 @@
+// handler/catalog-csv.go
+package handler
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// csvColumns — заголовки CSV-прайса для каждой локали → поле catalogProduct.
+// Прайсы поставщиков обычно приходят из русских/казахских таблиц, поэтому
+// кроме name,emoji,... понимаем «Название,Emoji,Категория,…».
+var csvColumns = map[string]map[string]string{
+	"en": {
+		"name":          "name",
+		"emoji":         "emoji",
+		"category":      "category",
+		"category_slug": "category",
+		"unit":          "unit",
+		"price":         "price",
+		"description":   "description",
+		"store_code":    "store_code",
+		"active":        "active",
+	},
+	"ru": {
+		"название":     "name",
+		"emoji":        "emoji",
+		"категория":    "category",
+		"единица":      "unit",
+		"цена":         "price",
+		"описание":     "description",
+		"код магазина": "store_code",
+		"активный":     "active",
+	},
+}
+
+// csvRequiredColumns — без этих колонок строку товара не собрать.
+var csvRequiredColumns = []string{"name", "category", "unit", "price"}
+
+// normalizeCSVHeader — заголовок без BOM Excel, пробелов и регистра.
+func normalizeCSVHeader(h string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+}
+
+// detectCSVLocale — на каком языке заголовки CSV: "ru" или "en".
+// Побеждает локаль, чьих заголовков в строке больше; при равенстве — "en".
+func detectCSVLocale(headers []string) string {
+	var ru, en int
+	for _, h := range headers {
+		h = normalizeCSVHeader(h)
+		if _, ok := csvColumns["ru"][h]; ok {
+			ru++
+		}
+		if _, ok := csvColumns["en"][h]; ok {
+			en++
+		}
+	}
+	if ru > en {
+		return "ru"
+	}
+	return "en"
+}
+
+// isCSVImport — импорт прислали CSV-файлом (Content-Type: text/csv или ?format=csv).
+func isCSVImport(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "csv" {
+		return true
+	}
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mt == "text/csv"
+}
+
+// csvDelimiter — Excel с русской локалью сохраняет CSV через «;».
+func csvDelimiter(firstLine string) rune {
+	if strings.Count(firstLine, ";") > strings.Count(firstLine, ",") {
+		return ';'
+	}
+	return ','
+}
+
+// parseCatalogCSV превращает CSV-прайс в документ импорта (только товары).
+// У уже существующих товаров колонки, которых нет в CSV (фото, ярлыки,
+// остаток, порядок), импорт не трогает — см. catalogProduct.fromCSV.
+func parseCatalogCSV(body io.Reader) (*catalogDoc, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	text := string(raw)
+	firstLine, _, _ := strings.Cut(text, "\n")
+
+	cr := csv.NewReader(strings.NewReader(text))
+	cr.Comma = csvDelimiter(firstLine)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	headers, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("csv is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := csvColumns[detectCSVLocale(headers)]
+	index := map[string]int{}
+	for i, h := range headers {
+		if field, ok := columns[normalizeCSVHeader(h)]; ok {
+			index[field] = i
+		}
+	}
+	for _, field := range csvRequiredColumns {
+		if _, ok := index[field]; !ok {
+			return nil, fmt.Errorf("csv: column %q is required", field)
+		}
+	}
+
+	doc := &catalogDoc{Version: catalogVersion, Categories: []catalogCategory{}, Stores: []catalogStore{}, Products: []catalogProduct{}}
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		cell := func(field string) string {
+			if i, ok := index[field]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		if strings.TrimSpace(strings.Join(rec, "")) == "" {
+			continue // пустые строки в конце таблицы
+		}
+
+		// «1 200» — пробелы-разделители тысяч из таблиц
+		price, err := strconv.ParseInt(strings.NewReplacer(" ", "", "\u00a0", "").Replace(cell("price")), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("csv line %d: bad price %q", line, cell("price"))
+		}
+		active, err := parseCSVBool(cell("active"))
+		if err != nil {
+			return nil, fmt.Errorf("csv line %d: %w", line, err)
+		}
+		doc.Products = append(doc.Products, catalogProduct{
+			Name:         cell("name"),
+			StoreCode:    cell("store_code"),
+			CategorySlug: cell("category"),
+			Emoji:        cell("emoji"),
+			Unit:         cell("unit"),
+			Price:        price,
+			Active:       active,
+			Description:  cell("description"),
+			Tags:         []string{},
+			fromCSV:      true,
+		})
+	}
+	return doc, nil
+}
+
+// parseCSVBool — колонка «Активный»: пусто — активен.
+func parseCSVBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "", "1", "true", "yes", "да", "иә":
+		return true, nil
+	case "0", "false", "no", "нет", "жоқ":
+		return false, nil
+	}
+	return false, fmt.Errorf("bad active value %q", v)
+}
@@ -0,0 +1,652 @@
+// handler/catalog-handler.go
+package handler
+
+import (
+	"agro/traits/database"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// catalogVersion — версия формата выгрузки; импорт других версий отклоняется.
+const catalogVersion = 1
+
+const (
+	catalogMaxBody  = 20 << 20 // 20 МБ JSON
+	catalogMaxPhoto = 10 << 20 // 10 МБ на фото
+)
+
+var catalogHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// catalogDoc — весь каталог одним JSON: категории, точки и товары.
+// Товары уникальны по (name, store_code) — у каждой точки своя строка и своя цена.
+type catalogDoc struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Categories []catalogCategory `json:"categories"`
+	Stores     []catalogStore    `json:"stores"`
+	Products   []catalogProduct  `json:"products"`
+}
+
+type catalogCategory struct {
+	Slug       string `json:"slug"`
+	Name       string `json:"name"`
+	SortOrder  int64  `json:"sort_order"`
+	ParentSlug string `json:"parent_slug"` // "" — категория верхнего уровня
+}
+
+type catalogStore struct {
+	Code             string   `json:"code"`
+	Name             string   `json:"name"`
+	Address          string   `json:"address"`
+	AddressFormatted string   `json:"address_formatted"`
+	Lat              *float64 `json:"lat"`
+	Lng              *float64 `json:"lng"`
+	WorkingHours     string   `json:"working_hours"`
+}
+
+type catalogProduct struct {
+	Name         string   `json:"name"`
+	StoreCode    string   `json:"store_code"`
+	CategorySlug string   `json:"category_slug"`
+	Emoji        string   `json:"emoji"`
+	Unit         string   `json:"unit"`
+	Price        int64    `json:"price"`
+	Active       bool     `json:"active"`
+	Description  string   `json:"description"`
+	Featured     bool     `json:"featured"`
+	SortOrder    int64    `json:"sort_order"`
+	StockQty     *int64   `json:"stock_qty"`
+	Tags         []string `json:"tags"`
+	PhotoURL     string   `json:"photo_url"` // абсолютный URL или /uploads/...
+
+	// fromCSV — строка из CSV-прайса: фото, ярлыков, остатка и порядка там нет,
+	// у существующего товара они остаются как были.
+	fromCSV bool
+}
+
+// catalogCounts — итог импорта по одной сущности.
+type catalogCounts struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+}
+
+type catalogSummary struct {
+	Categories catalogCounts `json:"categories"`
+	Stores     catalogCounts `json:"stores"`
+	Products   catalogCounts `json:"products"`
+}
+
+func (c *catalogCounts) add(created, changed bool) {
+	switch {
+	case created:
+		c.Created++
+	case changed:
+		c.Updated++
+	default:
+		c.Skipped++
+	}
+}
+
+// ======================== EXPORT ========================
+
+// GET /api/admin/catalog/export — выгрузка каталога для переноса на другой стенд.
+func (h *Handler) handleAdminCatalogExport(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	doc, err := h.exportCatalog()
+	if err != nil {
+		h.logger.Error("export catalog", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="catalog-%s.json"`, h.clock.Now().Format("2006-01-02")))
+	jsonOK(w, doc)
+}
+
+func (h *Handler) exportCatalog() (*catalogDoc, error) {
+	doc := &catalogDoc{
+		Version:    catalogVersion,
+		ExportedAt: h.clock.Now().UTC(),
+		Categories: []catalogCategory{},
+		Stores:     []catalogStore{},
+		Products:   []catalogProduct{},
+	}
+
+	rows, err := h.db.Query(`
+		SELECT slug, name, COALESCE(sort_order, 0), COALESCE(parent_slug, '') FROM categories ORDER BY sort_order, slug
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var c catalogCategory
+		if err := rows.Scan(&c.Slug, &c.Name, &c.SortOrder, &c.ParentSlug); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		doc.Categories = append(doc.Categories, c)
+	}
+	rows.Close()
+
+	rows, err = h.db.Query(`
+		SELECT code, name, COALESCE(address,''), COALESCE(address_formatted,''), latitude, longitude, COALESCE(working_hours,'')
+		FROM stores ORDER BY code
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			s        catalogStore
+			lat, lng sql.NullFloat64
+		)
+		if err := rows.Scan(&s.Code, &s.Name, &s.Address, &s.AddressFormatted, &lat, &lng, &s.WorkingHours); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if lat.Valid && lng.Valid {
+			s.Lat, s.Lng = &lat.Float64, &lng.Float64
+		}
+		doc.Stores = append(doc.Stores, s)
+	}
+	rows.Close()
+
+	tags, err := h.productTagsByID()
+	if err != nil {
+		return nil, err
+	}
+	rows, err = h.db.Query(`
+		SELECT id, name, COALESCE(store_code,''), category_slug, COALESCE(emoji,''), unit, price, active,
+		       COALESCE(description,''), featured, sort_order, stock_qty, COALESCE(photo_path,'')
+		FROM products
+		ORDER BY store_code, category_slug, sort_order, name, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	base := strings.TrimRight(h.cfg.MiniAppUrl, "/")
+	for rows.Next() {
+		var (
+			id    int64
+			p     catalogProduct
+			stock sql.NullInt64
+			photo string
+		)
+		if err := rows.Scan(&id, &p.Name, &p.StoreCode, &p.CategorySlug, &p.Emoji, &p.Unit, &p.Price, &p.Active,
+			&p.Description, &p.Featured, &p.SortOrder, &stock, &photo); err != nil {
+			return nil, err
+		}
+		if stock.Valid {
+			p.StockQty = &stock.Int64
+		}
+		p.Tags = tags[id]
+		if p.Tags == nil {
+			p.Tags = []string{}
+		}
+		if photo != "" {
+			p.PhotoURL = base + photo
+		}
+		doc.Products = append(doc.Products, p)
+	}
+	return doc, rows.Err()
+}
+
+func (h *Handler) productTagsByID() (map[int64][]string, error) {
+	rows, err := h.db.Query(`SELECT product_id, tag FROM product_tags ORDER BY product_id, tag`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int64][]string{}
+	for rows.Next() {
+		var (
+			id  int64
+			tag string
+		)
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, err
+		}
+		out[id] = append(out[id], tag)
+	}
+	return out, rows.Err()
+}
+
+// ======================== IMPORT ========================
+
+// POST /api/admin/catalog/import[?dry_run=1] — применить выгрузку: upsert категорий по slug,
+// точек по code, товаров по (name, store_code). Всё в одной транзакции: при любой
+// ошибке каталог не меняется. Товары, которых нет в документе, не трогаются.
+// dry_run=1 — только посчитать изменения (фото не скачиваются).
+// Вместо JSON можно прислать CSV-прайс (Content-Type: text/csv или ?format=csv),
+// с английскими или русскими заголовками — см. catalog-csv.go.
+func (h *Handler) handleAdminCatalogImport(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var doc catalogDoc
+	body := http.MaxBytesReader(w, r.Body, catalogMaxBody)
+	if isCSVImport(r) {
+		parsed, err := parseCatalogCSV(body)
+		if err != nil {
+			writeError(w, ErrBadRequest(err.Error()))
+			return
+		}
+		doc = *parsed
+	} else if err := json.NewDecoder(body).Decode(&doc); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	if doc.Version != catalogVersion {
+		writeError(w, ErrBadRequest(fmt.Sprintf("unsupported catalog version %d", doc.Version)))
+		return
+	}
+	if err := validateCatalog(&doc); err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "1"
+
+	summary, err := h.importCatalog(&doc, dryRun)
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		writeError(w, appErr)
+		return
+	}
+	if err != nil {
+		h.logger.Error("import catalog", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	h.logger.Info("catalog imported", zap.Bool("dry_run", dryRun), zap.Any("summary", summary))
+	jsonOK(w, map[string]any{"status": "ok", "dry_run": dryRun, "summary": summary})
+}
+
+// checkCategoryNesting — у подкатегорий существующий родитель верхнего уровня:
+// вложенность не глубже двух уровней.
+func checkCategoryNesting(tx *sql.Tx) error {
+	var slug, parent, grandparent string
+	err := tx.QueryRow(`
+		SELECT c.slug, c.parent_slug, COALESCE(p.parent_slug, '')
+		FROM categories c
+		LEFT JOIN categories p ON p.slug = c.parent_slug
+		WHERE c.parent_slug IS NOT NULL AND c.parent_slug != ''
+		  AND (p.slug IS NULL OR (p.parent_slug IS NOT NULL AND p.parent_slug != ''))
+		LIMIT 1
+	`).Scan(&slug, &parent, &grandparent)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	case grandparent == "":
+		return ErrBadRequest(fmt.Sprintf("category %q: unknown parent %q", slug, parent))
+	default:
+		return ErrBadRequest(fmt.Sprintf("category %q: parent %q is itself a sub-category (only two levels allowed)", slug, parent))
+	}
+}
+
+// validateCatalog проверяет обязательные поля и ссылки до начала транзакции.
+func validateCatalog(doc *catalogDoc) error {
+	for i := range doc.Categories {
+		c := &doc.Categories[i]
+		c.Slug, c.Name = strings.TrimSpace(c.Slug), strings.TrimSpace(c.Name)
+		if c.Slug == "" || c.Name == "" {
+			return fmt.Errorf("categories[%d]: slug and name are required", i)
+		}
+		c.ParentSlug = strings.TrimSpace(c.ParentSlug)
+		if c.ParentSlug == c.Slug {
+			return fmt.Errorf("categories[%d] %q: category cannot be its own parent", i, c.Slug)
+		}
+	}
+	for i := range doc.Stores {
+		s := &doc.Stores[i]
+		s.Code, s.Name = strings.TrimSpace(s.Code), strings.TrimSpace(s.Name)
+		if s.Code == "" || s.Name == "" {
+			return fmt.Errorf("stores[%d]: code and name are required", i)
+		}
+	}
+	seen := map[[2]string]bool{}
+	for i := range doc.Products {
+		p := &doc.Products[i]
+		p.Name, p.StoreCode = strings.TrimSpace(p.Name), strings.TrimSpace(p.StoreCode)
+		p.CategorySlug, p.Unit = strings.TrimSpace(p.CategorySlug), strings.TrimSpace(p.Unit)
+		if p.Name == "" || p.CategorySlug == "" || p.Unit == "" {
+			return fmt.Errorf("products[%d]: name, category_slug and unit are required", i)
+		}
+		if p.Price < 0 {
+			return fmt.Errorf("products[%d] %q: price must be >= 0", i, p.Name)
+		}
+		if err := validateEmoji(p.Emoji); err != nil {
+			return fmt.Errorf("products[%d] %q: %w", i, p.Name, err)
+		}
+		key := [2]string{p.Name, p.StoreCode}
+		if seen[key] {
+			return fmt.Errorf("products[%d]: duplicate product %q in store %q", i, p.Name, p.StoreCode)
+		}
+		seen[key] = true
+		for j, t := range p.Tags {
+			p.Tags[j] = strings.ToLower(strings.TrimSpace(t))
+		}
+		slices.Sort(p.Tags)
+		p.Tags = slices.Compact(p.Tags)
+		if len(p.Tags) > 0 && p.Tags[0] == "" {
+			p.Tags = p.Tags[1:]
+		}
+	}
+	return nil
+}
+
+func (h *Handler) importCatalog(doc *catalogDoc, dryRun bool) (summary catalogSummary, err error) {
+	// фото качаем до транзакции (сеть), а при откате удаляем скачанное
+	var downloaded []string
+	defer func() {
+		if err != nil || dryRun {
+			for _, f := range downloaded {
+				_ = os.Remove(f)
+			}
+		}
+	}()
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return summary, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, c := range doc.Categories {
+		var name, parent string
+		var sortOrder int64
+		e := tx.QueryRow(`
+			SELECT name, COALESCE(sort_order, 0), COALESCE(parent_slug, '') FROM categories WHERE slug = ?
+		`, c.Slug).Scan(&name, &sortOrder, &parent)
+		switch {
+		case errors.Is(e, sql.ErrNoRows):
+			_, err = tx.Exec(`INSERT INTO categories (slug, name, sort_order, parent_slug) VALUES (?, ?, ?, ?)`,
+				c.Slug, c.Name, c.SortOrder, nullString(c.ParentSlug))
+			summary.Categories.add(true, false)
+		case e != nil:
+			return summary, e
+		case name != c.Name || sortOrder != c.SortOrder || parent != c.ParentSlug:
+			_, err = tx.Exec(`UPDATE categories SET name = ?, sort_order = ?, parent_slug = ? WHERE slug = ?`,
+				c.Name, c.SortOrder, nullString(c.ParentSlug), c.Slug)
+			summary.Categories.add(false, true)
+		default:
+			summary.Categories.add(false, false)
+		}
+		if err != nil {
+			return summary, fmt.Errorf("category %s: %w", c.Slug, err)
+		}
+	}
+	// родители проверяем после всех категорий документа: порядок в нём произвольный
+	if err = checkCategoryNesting(tx); err != nil {
+		return summary, err
+	}
+
+	for _, s := range doc.Stores {
+		var (
+			cur      catalogStore
+			lat, lng sql.NullFloat64
+		)
+		e := tx.QueryRow(`
+			SELECT name, COALESCE(address,''), COALESCE(address_formatted,''), latitude, longitude, COALESCE(working_hours,'')
+			FROM stores WHERE code = ?
+		`, s.Code).Scan(&cur.Name, &cur.Address, &cur.AddressFormatted, &lat, &lng, &cur.WorkingHours)
+		changed := e == nil && (cur.Name != s.Name || cur.Address != s.Address || cur.AddressFormatted != s.AddressFormatted ||
+			cur.WorkingHours != s.WorkingHours || !sameCoord(lat, s.Lat) || !sameCoord(lng, s.Lng))
+		switch {
+		case errors.Is(e, sql.ErrNoRows):
+			_, err = tx.Exec(`
+				INSERT INTO stores (code, name, address, address_formatted, latitude, longitude, working_hours)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+			`, s.Code, s.Name, nullString(s.Address), nullString(s.AddressFormatted), s.Lat, s.Lng, nullString(s.WorkingHours))
+			summary.Stores.add(true, false)
+		case e != nil:
+			return summary, e
+		case changed:
+			_, err = tx.Exec(`
+				UPDATE stores SET name = ?, address = ?, address_formatted = ?, latitude = ?, longitude = ?, working_hours = ?
+				WHERE code = ?
+			`, s.Name, nullString(s.Address), nullString(s.AddressFormatted), s.Lat, s.Lng, nullString(s.WorkingHours), s.Code)
+			summary.Stores.add(false, true)
+		default:
+			summary.Stores.add(false, false)
+		}
+		if err != nil {
+			return summary, fmt.Errorf("store %s: %w", s.Code, err)
+		}
+	}
+
+	for i, p := range doc.Products {
+		// точку проверяем уже с учётом точек из этого же документа;
+		// category_slug в products — свободная строка, таблица categories не обязательна
+		if p.StoreCode != "" {
+			var n int
+			_ = tx.QueryRow(`SELECT COUNT(1) FROM stores WHERE code = ?`, p.StoreCode).Scan(&n)
+			if n == 0 {
+				return summary, ErrBadRequest(fmt.Sprintf("products[%d] %q: unknown store %q", i, p.Name, p.StoreCode))
+			}
+		}
+
+		var (
+			id               int64
+			cur              catalogProduct
+			stock            sql.NullInt64
+			photo            string
+			active, featured int64
+		)
+		e := tx.QueryRow(`
+			SELECT id, category_slug, COALESCE(emoji,''), unit, price, active, COALESCE(description,''),
+			       featured, sort_order, stock_qty, COALESCE(photo_path,'')
+			FROM products
+			WHERE name = ? AND COALESCE(store_code,'') = ?
+			ORDER BY id
+			LIMIT 1
+		`, p.Name, p.StoreCode).Scan(&id, &cur.CategorySlug, &cur.Emoji, &cur.Unit, &cur.Price, &active, &cur.Description,
+			&featured, &cur.SortOrder, &stock, &photo)
+		if e != nil && !errors.Is(e, sql.ErrNoRows) {
+			return summary, e
+		}
+		exists := e == nil
+
+		var curTags []string
+		if exists {
+			if curTags, err = productTags(tx, id); err != nil {
+				return summary, err
+			}
+		}
+		photoPath := photo
+		if p.fromCSV && exists {
+			p.Featured, p.SortOrder, p.Tags = featured != 0, cur.SortOrder, curTags
+			if stock.Valid {
+				p.StockQty = &stock.Int64
+			}
+		} else if !p.fromCSV {
+			var fetched string
+			photoPath, fetched, err = h.resolveCatalogPhoto(p.PhotoURL, photo, dryRun)
+			if err != nil {
+				return summary, ErrBadRequest(fmt.Sprintf("products[%d] %q: photo: %v", i, p.Name, err))
+			}
+			if fetched != "" {
+				downloaded = append(downloaded, fetched)
+			}
+		}
+
+		stockArg := any(nil)
+		if p.StockQty != nil {
+			stockArg = *p.StockQty
+		}
+		if !exists {
+			id, err = database.InsertID(context.Background(), tx, `
+				INSERT INTO products (name, emoji, category_slug, unit, price, active, description, photo_path, store_code, featured, sort_order, stock_qty)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, p.Name, nullString(p.Emoji), p.CategorySlug, p.Unit, p.Price, p.Active, nullString(p.Description),
+				nullString(photoPath), nullString(p.StoreCode), p.Featured, p.SortOrder, stockArg)
+			if err == nil {
+				err = replaceProductTags(tx, id, p.Tags)
+			}
+			summary.Products.add(true, false)
+		} else {
+			changed := cur.CategorySlug != p.CategorySlug || cur.Emoji != p.Emoji || cur.Unit != p.Unit ||
+				cur.Price != p.Price || (active != 0) != p.Active || cur.Description != p.Description ||
+				(featured != 0) != p.Featured || cur.SortOrder != p.SortOrder ||
+				stock.Valid != (p.StockQty != nil) || (stock.Valid && stock.Int64 != *p.StockQty) ||
+				photo != photoPath || !slices.Equal(curTags, p.Tags)
+			if changed {
+				_, err = tx.Exec(`
+					UPDATE products SET
+					  emoji = ?, category_slug = ?, unit = ?, price = ?, active = ?, description = ?,
+					  photo_path = ?, featured = ?, sort_order = ?, stock_qty = ?
+					WHERE id = ?
+				`, nullString(p.Emoji), p.CategorySlug, p.Unit, p.Price, p.Active, nullString(p.Description),
+					nullString(photoPath), p.Featured, p.SortOrder, stockArg, id)
+				if err == nil && !slices.Equal(curTags, p.Tags) {
+					err = replaceProductTags(tx, id, p.Tags)
+				}
+			}
+			summary.Products.add(false, changed)
+		}
+		if err != nil {
+			return summary, fmt.Errorf("product %q (%s): %w", p.Name, p.StoreCode, err)
+		}
+	}
+
+	if dryRun {
+		return summary, nil // откат в defer
+	}
+	if err = h.writeAudit(tx, h.cfg.AdminID, "catalog.import", "", "", summary); err != nil {
+		return summary, err
+	}
+	if err = tx.Commit(); err != nil {
+		return summary, err
+	}
+	h.invalidateProducts()
+	return summary, nil
+}
+
+func sameCoord(cur sql.NullFloat64, v *float64) bool {
+	if !cur.Valid || v == nil {
+		return !cur.Valid && v == nil
+	}
+	return cur.Float64 == *v
+}
+
+func productTags(tx *sql.Tx, productID int64) ([]string, error) {
+	rows, err := tx.Query(`SELECT tag FROM product_tags WHERE product_id = ? ORDER BY tag`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func replaceProductTags(tx *sql.Tx, productID int64, tags []string) error {
+	if _, err := tx.Exec(`DELETE FROM product_tags WHERE product_id = ?`, productID); err != nil {
+		return err
+	}
+	for _, t := range tags {
+		if _, err := tx.Exec(`INSERT INTO product_tags (product_id, tag) VALUES (?, ?)`, productID, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// имя файла из выгрузки, которое безопасно положить в ./uploads
+var uploadNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// resolveCatalogPhoto превращает photo_url из выгрузки в photo_path.
+// Файл сохраняется под тем же именем, что и в источнике, поэтому повторный
+// импорт того же документа ничего не скачивает и не меняет.
+// fetched — путь скачанного сейчас файла (для удаления при откате).
+func (h *Handler) resolveCatalogPhoto(rawURL, current string, dryRun bool) (photoPath, fetched string, err error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return "", "", nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", err
+	}
+	name := path.Base(u.Path)
+	if !uploadNameRe.MatchString(name) {
+		return "", "", fmt.Errorf("bad file name %q", name)
+	}
+	photoPath = "/uploads/" + name
+	local := filepath.Join("./uploads", name)
+
+	if _, err := os.Stat(local); err == nil {
+		return photoPath, "", nil // файл уже есть
+	}
+	if !u.IsAbs() {
+		if photoPath == current {
+			return photoPath, "", nil
+		}
+		return "", "", fmt.Errorf("file %s not found", photoPath)
+	}
+	if dryRun {
+		return photoPath, "", nil
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	resp, err := catalogHTTPClient.Get(u.String())
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("GET %s: status %d", u.Redacted(), resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "image/") {
+		return "", "", fmt.Errorf("GET %s: not an image (%s)", u.Redacted(), ct)
+	}
+
+	if err := os.MkdirAll("./uploads", 0o755); err != nil {
+		return "", "", err
+	}
+	out, err := os.Create(local)
+	if err != nil {
+		return "", "", err
+	}
+	n, err := io.Copy(out, io.LimitReader(resp.Body, catalogMaxPhoto+1))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > catalogMaxPhoto {
+		err = fmt.Errorf("photo is larger than %d MB", catalogMaxPhoto>>20)
+	}
+	if err != nil {
+		_ = os.Remove(local)
+		return "", "", err
+	}
+	return photoPath, local, nil
+}
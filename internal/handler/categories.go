@@ -0,0 +1,115 @@
+// handler/categories.go
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// errCategoryNotLeaf — товар кладут в категорию, у которой есть подкатегории.
+var errCategoryNotLeaf = errors.New("category has sub-categories, choose one of them")
+
+// categoryNode — категория каталога с подкатегориями (вложенность — два уровня:
+// «Овощи» → «Корнеплоды», «Зелень»).
+type categoryNode struct {
+	Slug      string          `json:"slug"`
+	Name      string          `json:"name"`
+	SortOrder int64           `json:"sort_order"`
+	Children  []*categoryNode `json:"children"`
+}
+
+// categoryTree — все категории деревом. Подкатегория с несуществующим
+// родителем показывается на верхнем уровне, чтобы не пропасть из меню.
+func (h *Handler) categoryTree() ([]*categoryNode, error) {
+	rows, err := h.db.Query(`
+		SELECT slug, name, COALESCE(sort_order, 0), COALESCE(parent_slug, '')
+		FROM categories
+		ORDER BY sort_order, slug
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		nodes   []*categoryNode
+		parents = map[*categoryNode]string{}
+		bySlug  = map[string]*categoryNode{}
+	)
+	for rows.Next() {
+		var (
+			n      categoryNode
+			parent string
+		)
+		if err := rows.Scan(&n.Slug, &n.Name, &n.SortOrder, &parent); err != nil {
+			return nil, err
+		}
+		n.Children = []*categoryNode{}
+		nodes = append(nodes, &n)
+		parents[&n] = parent
+		bySlug[n.Slug] = &n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tree := []*categoryNode{}
+	for _, n := range nodes {
+		if p, ok := bySlug[parents[n]]; ok && p != n {
+			p.Children = append(p.Children, n)
+			continue
+		}
+		tree = append(tree, n)
+	}
+	return tree, nil
+}
+
+// GET /api/categories — дерево категорий для меню мини-аппа:
+// [{slug, name, sort_order, children: [...]}]
+func (h *Handler) handleGetCategories(w http.ResponseWriter, r *http.Request) {
+	tree, err := h.categoryTree()
+	if err != nil {
+		h.logger.Error("select categories", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, tree)
+}
+
+// categoryWithChildren — slug и slug-и его подкатегорий: ?category=vegetables
+// показывает и корнеплоды, и зелень.
+func (h *Handler) categoryWithChildren(slug string) ([]string, error) {
+	out := []string{slug}
+	rows, err := h.db.Query(`SELECT slug FROM categories WHERE parent_slug = ? ORDER BY sort_order, slug`, slug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// checkLeafCategory — товар можно положить только в категорию без подкатегорий.
+// Slug, которого нет в categories, пропускаем: category_slug у товаров —
+// свободная строка, справочник категорий заполнять не обязательно.
+func (h *Handler) checkLeafCategory(slug string) error {
+	var children int
+	err := h.db.QueryRow(`SELECT COUNT(1) FROM categories WHERE parent_slug = ?`, slug).Scan(&children)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if children > 0 {
+		return fmt.Errorf("%w: %s", errCategoryNotLeaf, slug)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+// handler/clock.go
+package handler
+
+import "time"
+
+// Clock — источник текущего времени. В проде это системные часы,
+// в тестах подменяется через SetClock, чтобы проверять истечение подписок.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// SetClock подменяет часы Handler.
+func (h *Handler) SetClock(c Clock) { h.clock = c }
@@ -0,0 +1,186 @@
+// handler/delivery-handler.go
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// deliveryTier — ступень тарифа доставки: до UpToKm километров от точки стоит Price.
+type deliveryTier struct {
+	UpToKm float64 `json:"up_to_km"`
+	Price  int64   `json:"price"`
+}
+
+// loadDeliveryTiers возвращает ступени точки по возрастанию расстояния.
+func (h *Handler) loadDeliveryTiers(storeCode string) ([]deliveryTier, error) {
+	rows, err := h.db.Query(`
+		SELECT up_to_km, price FROM delivery_tiers
+		WHERE store_code = ?
+		ORDER BY up_to_km
+	`, storeCode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []deliveryTier
+	for rows.Next() {
+		var t deliveryTier
+		if err := rows.Scan(&t.UpToKm, &t.Price); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// deliveryQuote считает стоимость доставки для точки storeCode.
+// Если у точки есть ступени и известны координаты клиента и точки — берётся
+// первая ступень, покрывающая расстояние (дальше последней — errOutsideDeliveryZone).
+// Иначе — плоская ставка deliveryFlatPrice. Для самовывоза — 0.
+// Точка без доставки (stores.delivers = 0) — errDeliveryUnavailable.
+func (h *Handler) deliveryQuote(storeCode string, d deliveryIn) (int64, *deliveryTier, error) {
+	if !strings.EqualFold(d.Type, "delivery") {
+		return 0, nil, nil
+	}
+	settings, err := h.storeDeliverySettings(storeCode)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !settings.Delivers {
+		return 0, nil, errDeliveryUnavailable
+	}
+	if storeCode == "" || (d.Lat == 0 && d.Lng == 0) {
+		return deliveryFlatPrice, nil, nil
+	}
+
+	tiers, err := h.loadDeliveryTiers(storeCode)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(tiers) == 0 {
+		return deliveryFlatPrice, nil, nil
+	}
+
+	var storeLat, storeLng sql.NullFloat64
+	err = h.db.QueryRow(`SELECT latitude, longitude FROM stores WHERE code = ?`, storeCode).Scan(&storeLat, &storeLng)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, nil, err
+	}
+	if !storeLat.Valid || !storeLng.Valid {
+		return deliveryFlatPrice, nil, nil
+	}
+
+	dist := distanceKm(storeLat.Float64, storeLng.Float64, d.Lat, d.Lng)
+	for _, t := range tiers {
+		if dist <= t.UpToKm {
+			return t.Price, &t, nil
+		}
+	}
+	return 0, nil, errOutsideDeliveryZone
+}
+
+// GET /api/admin/delivery/tiers?store_code=samal3 — ступени тарифа точки.
+func (h *Handler) handleAdminListDeliveryTiers(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	store := strings.TrimSpace(r.URL.Query().Get("store_code"))
+	if store == "" {
+		writeError(w, ErrBadRequest("store_code is required"))
+		return
+	}
+	tiers, err := h.loadDeliveryTiers(store)
+	if err != nil {
+		h.logger.Error("list delivery tiers", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	if tiers == nil {
+		tiers = []deliveryTier{}
+	}
+	jsonOK(w, map[string]any{"store_code": store, "tiers": tiers})
+}
+
+type setDeliveryTiersIn struct {
+	StoreCode string         `json:"store_code"`
+	Tiers     []deliveryTier `json:"tiers"` // пустой список — вернуть плоскую ставку
+}
+
+// POST /api/admin/delivery/tiers/set — заменить ступени тарифа точки.
+func (h *Handler) handleAdminSetDeliveryTiers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, ErrMethodNotAllowed())
+		return
+	}
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in setDeliveryTiersIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	in.StoreCode = strings.TrimSpace(in.StoreCode)
+	if in.StoreCode == "" {
+		writeError(w, ErrBadRequest("store_code is required"))
+		return
+	}
+	var cnt int
+	_ = h.db.QueryRow(`SELECT COUNT(1) FROM stores WHERE code = ?`, in.StoreCode).Scan(&cnt)
+	if cnt == 0 {
+		writeError(w, ErrBadRequest("store not found"))
+		return
+	}
+
+	sort.Slice(in.Tiers, func(i, j int) bool { return in.Tiers[i].UpToKm < in.Tiers[j].UpToKm })
+	for i, t := range in.Tiers {
+		if t.UpToKm <= 0 || t.Price < 0 {
+			writeError(w, ErrBadRequest("up_to_km must be > 0 and price >= 0"))
+			return
+		}
+		if i > 0 && t.UpToKm == in.Tiers[i-1].UpToKm {
+			writeError(w, ErrBadRequest("duplicate up_to_km "+strconv.FormatFloat(t.UpToKm, 'f', -1, 64)))
+			return
+		}
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(`DELETE FROM delivery_tiers WHERE store_code = ?`, in.StoreCode)
+	for _, t := range in.Tiers {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec(`INSERT INTO delivery_tiers (store_code, up_to_km, price) VALUES (?, ?, ?)`,
+			in.StoreCode, t.UpToKm, t.Price)
+	}
+	if err == nil {
+		err = h.writeAudit(tx, h.auditActor(r), "delivery_tiers.set", in.StoreCode, "", in.Tiers)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Error("save delivery tiers", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, map[string]any{"status": "ok", "tiers": in.Tiers})
+}
@@ -0,0 +1,4533 @@
+package handler
+
+import (
+	"agro/internal/domain"
+	"agro/internal/repository"
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+func TestE2EProductsStoreFilter(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedStore("aksai", "Аксай")
+	env.seedProduct("Картофель", "vegetables", 250, "samal3")
+	env.seedProduct("Яблоки", "fruits", 600, "aksai")
+	env.seedProduct("Укроп", "greens", 100, "")
+	env.seedUser(555, "samal3")
+
+	var all, filtered []struct {
+		Name  string `json:"name"`
+		Store string `json:"store_code"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/products", nil, nil), &all)
+	if len(all) != 3 {
+		t.Fatalf("unfiltered products = %d, want 3", len(all))
+	}
+
+	w := env.do(http.MethodGet, "/api/products", nil, map[string]string{"X-Telegram-Id": "555"})
+	decode(t, w, &filtered)
+	if len(filtered) != 2 {
+		t.Fatalf("filtered products = %+v, want Картофель + Укроп", filtered)
+	}
+	for _, p := range filtered {
+		if p.Store == "aksai" {
+			t.Fatalf("product from another store leaked: %+v", p)
+		}
+	}
+}
+
+func TestE2EConfirmOrder(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	pid := env.seedProduct("Картофель", "vegetables", 250, "samal3")
+	env.seedUser(555, "samal3")
+
+	w := env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+		"telegram_id":    "555",
+		"payment_method": "kaspi_transfer",
+		"items":          []map[string]any{{"product_id": pid, "name": "Картофель", "qty": 3, "unit": "кг", "price": 250}},
+		"delivery":       map[string]any{"type": "pickup", "phone": "+77010000000"},
+	}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var out struct {
+		OrderID int64 `json:"order_id"`
+		Total   int64 `json:"total"`
+	}
+	decode(t, w, &out)
+	if out.OrderID == 0 || out.Total != 750 {
+		t.Fatalf("confirm response = %+v", out)
+	}
+
+	st, err := env.h.redisClient.GetUserState(context.Background(), 555)
+	if err != nil || st == nil {
+		t.Fatalf("user state = %v, err = %v", st, err)
+	}
+	if st.State != stateWaitingPayment || st.BroadCastType != paymentKaspiTransfer || st.Contact != "+77010000000" {
+		t.Fatalf("user state = %+v", st)
+	}
+
+	admin := waitMessages(t, env.sender, testAdminID, 1)
+	if len(admin) != 1 || !strings.Contains(admin[0], "Самовывоз") || !strings.Contains(admin[0], formatMoney(750)) {
+		t.Fatalf("admin notification = %q", admin)
+	}
+	if receipt := env.sender.MessagesTo(555); len(receipt) != 1 || !strings.Contains(receipt[0], "Итого к оплате: "+formatMoney(750)) {
+		t.Fatalf("receipt = %q", receipt)
+	}
+}
+
+func TestE2EConfirmOrderItemStore(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedStore("aksai", "Аксай")
+	local := env.seedProduct("Картофель", "vegetables", 250, "samal3")
+	global := env.seedProduct("Соль", "grocery", 100, "")
+	foreign := env.seedProduct("Укроп", "greens", 300, "aksai")
+	env.seedUser(555, "samal3")
+
+	confirm := func(ids ...int64) *httptest.ResponseRecorder {
+		items := []map[string]any{}
+		for _, id := range ids {
+			items = append(items, map[string]any{"product_id": id, "name": fmt.Sprint("товар ", id), "qty": 1, "unit": "кг", "price": 100})
+		}
+		return env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+			"telegram_id": "555", "items": items, "delivery": map[string]any{"type": "pickup"},
+		}, nil)
+	}
+
+	// пользователь сменил точку, а в корзине остался товар Аксая
+	w := confirm(local, foreign)
+	var out struct {
+		Code  string           `json:"code"`
+		Items []itemStoreError `json:"items"`
+	}
+	decode(t, w, &out)
+	if w.Code != http.StatusBadRequest || out.Code != "items_not_in_store" ||
+		len(out.Items) != 1 || out.Items[0].ProductID != foreign || out.Items[0].StoreCode != "aksai" {
+		t.Fatalf("foreign item = %d %+v", w.Code, out)
+	}
+	var orders int
+	_ = env.h.db.QueryRow(`SELECT COUNT(1) FROM orders`).Scan(&orders)
+	if orders != 0 {
+		t.Fatalf("order saved with a foreign item")
+	}
+
+	// свои и общие товары (без store_code) заказываются как раньше
+	if w := confirm(local, global); w.Code != http.StatusOK {
+		t.Fatalf("local + global = %d %s", w.Code, w.Body.String())
+	}
+	admin := waitMessages(t, env.sender, testAdminID, 1)
+	if len(admin) != 1 || !strings.Contains(admin[0], "Позиции точки: 1, общие для всех точек: 1") {
+		t.Fatalf("admin notification = %q", admin)
+	}
+}
+
+func TestE2EPaymentMethodChoice(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.seedStore("samal3", "Самал-3")
+	pid := env.seedProduct("Картофель", "vegetables", 250, "samal3")
+	env.seedUser(555, "samal3")
+
+	create := func(qty int) int64 {
+		t.Helper()
+		w := env.do(http.MethodPost, "/api/orders/create", map[string]any{
+			"telegram_id": "555",
+			"items":       []map[string]any{{"product_id": pid, "name": "Картофель", "qty": qty, "unit": "кг", "price": 250}},
+		}, nil)
+		var out struct {
+			OrderID int64 `json:"order_id"`
+		}
+		decode(t, w, &out)
+		return out.OrderID
+	}
+	method := func(id int64) string {
+		var m string
+		_ = env.h.db.QueryRow(`SELECT payment_method FROM orders WHERE id = ?`, id).Scan(&m)
+		return m
+	}
+	press := func(from int64, data string) {
+		env.h.PaymentCallbackHandler(ctx, nil, &models.Update{CallbackQuery: &models.CallbackQuery{
+			ID: "cb", From: models.User{ID: from}, Data: data,
+			Message: models.MaybeInaccessibleMessage{Message: &models.Message{ID: 7, Chat: models.Chat{ID: from}}},
+		}})
+	}
+
+	// после заказа — вопрос со способами оплаты вместо чека
+	id := create(2)
+	msgs := env.sender.MessagesTo(555)
+	if len(msgs) != 1 || !strings.Contains(msgs[0], "Выберите способ оплаты") || method(id) != paymentPending {
+		t.Fatalf("choice = %q, method = %q", msgs, method(id))
+	}
+	var kb *models.InlineKeyboardMarkup
+	for _, m := range env.sender.Messages {
+		if m.ChatID == int64(555) {
+			kb = m.ReplyMarkup.(*models.InlineKeyboardMarkup)
+		}
+	}
+	if len(kb.InlineKeyboard[0]) != 3 || kb.InlineKeyboard[0][2].CallbackData != fmt.Sprintf("pay_method:%d:cash", id) {
+		t.Fatalf("choice keyboard = %+v", kb.InlineKeyboard)
+	}
+
+	// чужой пользователь не может выбрать за покупателя
+	press(556, fmt.Sprintf("pay_method:%d:cash", id))
+	if method(id) != paymentPending {
+		t.Fatalf("foreign choice applied: %q", method(id))
+	}
+
+	press(555, fmt.Sprintf("pay_method:%d:cash", id))
+	msgs = env.sender.MessagesTo(555)
+	if method(id) != paymentCash || len(msgs) != 2 || !strings.Contains(msgs[1], "Наличные") || len(env.sender.Edits) != 1 {
+		t.Fatalf("after choice: method = %q, msgs = %q, edits = %d", method(id), msgs, len(env.sender.Edits))
+	}
+	st, _ := env.h.redisClient.GetUserState(ctx, 555)
+	if st == nil || st.State != stateWaitingPayment || st.BroadCastType != paymentCash {
+		t.Fatalf("user state = %+v", st)
+	}
+
+	// повторное нажатие — чек не дублируется
+	press(555, fmt.Sprintf("pay_method:%d:kaspi_transfer", id))
+	if method(id) != paymentCash || len(env.sender.MessagesTo(555)) != 2 {
+		t.Fatalf("second choice: method = %q", method(id))
+	}
+
+	// не выбрали за 10 минут — kaspi_link и чек с кнопкой Kaspi
+	late := create(3)
+	env.exec(`UPDATE orders SET created_at = datetime('now', '-11 minutes') WHERE id = ?`, late)
+	fresh := create(4)
+	env.h.expirePaymentChoices(ctx)
+	if method(late) != paymentKaspiLink || method(fresh) != paymentPending {
+		t.Fatalf("timeout: late = %q, fresh = %q", method(late), method(fresh))
+	}
+	msgs = env.sender.MessagesTo(555)
+	if !strings.Contains(msgs[len(msgs)-1], fmt.Sprintf("Заказ №%d принят", late)) {
+		t.Fatalf("default receipt = %q", msgs[len(msgs)-1])
+	}
+}
+
+func TestE2EResendReceipt(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	pid := env.seedProduct("Картофель", "vegetables", 250, "samal3")
+	env.seedUser(555, "samal3")
+
+	w := env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+		"telegram_id":    "555",
+		"payment_method": "kaspi_transfer",
+		"items":          []map[string]any{{"product_id": pid, "name": "Картофель", "qty": 3, "unit": "кг", "price": 250, "note": "спелые"}},
+		"delivery":       map[string]any{"type": "pickup"},
+	}, nil)
+	var out struct {
+		OrderID int64 `json:"order_id"`
+	}
+	decode(t, w, &out)
+
+	items, err := env.h.loadOrderItems(out.OrderID)
+	if err != nil || len(items) != 1 || items[0].ProductID != pid || items[0].Qty != 3 || items[0].Note != "спелые" {
+		t.Fatalf("loadOrderItems = %+v, err = %v", items, err)
+	}
+
+	resend := func(tgID string, orderID int64) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, "/api/orders/resend-receipt",
+			map[string]any{"telegram_id": tgID, "order_id": orderID}, nil)
+	}
+	if w := resend("556", out.OrderID); w.Code != http.StatusNotFound {
+		t.Fatalf("foreign order = %d, want 404", w.Code)
+	}
+	if w := resend("555", out.OrderID); w.Code != http.StatusOK {
+		t.Fatalf("resend = %d, body = %s", w.Code, w.Body.String())
+	}
+	receipts := env.sender.MessagesTo(555)
+	if len(receipts) != 2 || receipts[1] != receipts[0] || !strings.Contains(receipts[1], "Kaspi Gold") {
+		t.Fatalf("receipts = %q", receipts)
+	}
+
+	w = resend("555", out.OrderID)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("second resend = %d, Retry-After = %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	env.redis.FastForward(receiptResendInterval)
+	if w := resend("555", out.OrderID); w.Code != http.StatusOK {
+		t.Fatalf("resend after interval = %d", w.Code)
+	}
+}
+
+func TestE2ESubscriptionFlow(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	// 1) заявка на подписку из мини-аппа
+	w := env.do(http.MethodPost, "/api/subscribe/request-invoice",
+		map[string]string{"telegram_id": "555", "phone": "+77010000000"}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("request-invoice status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if msgs := env.sender.MessagesTo(555); len(msgs) != 1 || !strings.Contains(msgs[0], "Подписка АГРО Клуб") {
+		t.Fatalf("payment link message = %q", msgs)
+	}
+
+	// 2) пользователь присылает чек — он уходит админу с кнопками
+	env.h.DefaultHandler(ctx, nil, &models.Update{Message: &models.Message{
+		ID:       10,
+		From:     &models.User{ID: 555, Username: "tester"},
+		Chat:     models.Chat{ID: 555},
+		Document: &models.Document{FileID: "receipt.pdf"},
+	}})
+	if len(env.sender.Copies) != 1 {
+		t.Fatalf("copies to admin = %d, want 1", len(env.sender.Copies))
+	}
+	kb, ok := env.sender.Copies[0].ReplyMarkup.(*models.InlineKeyboardMarkup)
+	if !ok || len(kb.InlineKeyboard) == 0 {
+		t.Fatalf("admin copy has no keyboard: %+v", env.sender.Copies[0].ReplyMarkup)
+	}
+	okData := kb.InlineKeyboard[0][0].CallbackData
+	if !strings.HasPrefix(okData, "sub_ok:") {
+		t.Fatalf("confirm button data = %q", okData)
+	}
+
+	// 3) админ нажимает «Активировать»
+	env.h.PaymentCallbackHandler(ctx, nil, &models.Update{
+		CallbackQuery: &models.CallbackQuery{ID: "cb", Data: okData},
+	})
+
+	var status struct {
+		Active bool   `json:"active"`
+		Until  string `json:"until"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/user/subscription-status?telegram_id=555", nil, nil), &status)
+	if !status.Active || status.Until == "" {
+		t.Fatalf("subscription status = %+v", status)
+	}
+	st, _ := env.h.redisClient.GetUserState(ctx, 555)
+	if st == nil || !st.IsPaid || st.State != stateStart {
+		t.Fatalf("user state after sub_ok = %+v", st)
+	}
+}
+
+func TestE2EAdminProductCRUD(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+
+	post := func(path string, fields map[string]string) int {
+		body, ct := env.multipart(fields)
+		h := env.admin()
+		h["Content-Type"] = ct
+		return env.do(http.MethodPost, path, body, h).Code
+	}
+
+	fields := map[string]string{
+		"name": "Морковь", "category": "vegetables", "unit": "кг",
+		"price": "300", "store_code": "samal3", "tags": "promo, new",
+	}
+	body, ct := env.multipart(fields)
+	if w := env.do(http.MethodPost, "/api/admin/products/add", body, map[string]string{"Content-Type": ct}); w.Code != http.StatusForbidden {
+		t.Fatalf("add without admin header = %d, want 403", w.Code)
+	}
+	if code := post("/api/admin/products/add", fields); code != http.StatusOK {
+		t.Fatalf("add status = %d", code)
+	}
+
+	type product struct {
+		ID    int64    `json:"id"`
+		Name  string   `json:"name"`
+		Price int64    `json:"price"`
+		Tags  []string `json:"tags"`
+	}
+	var list []product
+	decode(t, env.do(http.MethodGet, "/api/admin/products", nil, env.admin()), &list)
+	if len(list) != 1 || list[0].Name != "Морковь" || len(list[0].Tags) != 2 {
+		t.Fatalf("admin list = %+v", list)
+	}
+	id := list[0].ID
+
+	fields["id"] = strconv.FormatInt(id, 10)
+	fields["price"] = "350"
+	fields["tags"] = "hit"
+	if code := post("/api/admin/products/update", fields); code != http.StatusOK {
+		t.Fatalf("update status = %d", code)
+	}
+	var got product
+	decode(t, env.do(http.MethodGet, "/api/admin/products/get?id="+strconv.FormatInt(id, 10), nil, env.admin()), &got)
+	if got.Price != 350 || len(got.Tags) != 1 || got.Tags[0] != "hit" {
+		t.Fatalf("after update = %+v", got)
+	}
+
+	// форма без subscriber_only/retail_price (старая админка) их не сбрасывает
+	env.exec(`UPDATE products SET subscriber_only = 1, retail_price = 400 WHERE id = ?`, id)
+	if code := post("/api/admin/products/update", fields); code != http.StatusOK {
+		t.Fatalf("update without pricing fields = %d", code)
+	}
+	var only int64
+	var retail sql.NullInt64
+	_ = env.h.db.QueryRow(`SELECT subscriber_only, retail_price FROM products WHERE id = ?`, id).Scan(&only, &retail)
+	if only != 1 || retail.Int64 != 400 {
+		t.Fatalf("pricing after update = %d %v", only, retail)
+	}
+	fields["subscriber_only"], fields["retail_price"] = "0", ""
+	if code := post("/api/admin/products/update", fields); code != http.StatusOK {
+		t.Fatalf("update pricing fields = %d", code)
+	}
+	_ = env.h.db.QueryRow(`SELECT subscriber_only, retail_price FROM products WHERE id = ?`, id).Scan(&only, &retail)
+	if only != 0 || retail.Valid {
+		t.Fatalf("pricing after reset = %d %v", only, retail)
+	}
+
+	// без featured/sort_order/stock_qty/tags в форме витрина, остаток и ярлыки остаются
+	env.exec(`UPDATE products SET featured = 1, sort_order = 5, stock_qty = 7 WHERE id = ?`, id)
+	delete(fields, "tags")
+	if code := post("/api/admin/products/update", fields); code != http.StatusOK {
+		t.Fatalf("update without showcase fields = %d", code)
+	}
+	var featured, sortOrder int64
+	var stock sql.NullInt64
+	_ = env.h.db.QueryRow(`SELECT featured, sort_order, stock_qty FROM products WHERE id = ?`, id).Scan(&featured, &sortOrder, &stock)
+	if featured != 1 || sortOrder != 5 || stock.Int64 != 7 {
+		t.Fatalf("showcase after update = %d %d %v", featured, sortOrder, stock)
+	}
+	decode(t, env.do(http.MethodGet, "/api/admin/products/get?id="+strconv.FormatInt(id, 10), nil, env.admin()), &got)
+	if len(got.Tags) != 1 || got.Tags[0] != "hit" {
+		t.Fatalf("tags after update without tags = %+v", got)
+	}
+
+	if w := env.do(http.MethodPost, "/api/admin/products/delete", map[string]int64{"id": id}, env.admin()); w.Code != http.StatusOK {
+		t.Fatalf("delete status = %d", w.Code)
+	}
+	if w := env.do(http.MethodGet, "/api/admin/products/get?id="+strconv.FormatInt(id, 10), nil, env.admin()); w.Code != http.StatusNotFound {
+		t.Fatalf("get after delete = %d, want 404", w.Code)
+	}
+}
+
+func TestE2EAdminProductPhotoCleanup(t *testing.T) {
+	t.Chdir(t.TempDir()) // фото лежат в ./uploads
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	id := env.seedProduct("Морковь", "vegetables", 300, "samal3")
+	if err := os.MkdirAll("uploads", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("uploads/carrot.png", []byte("png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env.exec(`UPDATE products SET photo_path = '/uploads/carrot.png' WHERE id = ?`, id)
+
+	// запись не удалась — старое фото на месте
+	env.exec(`ALTER TABLE product_tags RENAME TO product_tags_off`)
+	body, ct := env.multipart(map[string]string{
+		"id": strconv.FormatInt(id, 10), "name": "Морковь", "category": "vegetables", "unit": "кг",
+		"price": "300", "store_code": "samal3", "tags": "hit", "remove_photo": "1",
+	})
+	h := env.admin()
+	h["Content-Type"] = ct
+	if w := env.do(http.MethodPost, "/api/admin/products/update", body, h); w.Code != http.StatusInternalServerError {
+		t.Fatalf("update with broken tags = %d, want 500", w.Code)
+	}
+	if _, err := os.Stat("uploads/carrot.png"); err != nil {
+		t.Fatalf("photo removed before commit: %v", err)
+	}
+	env.exec(`ALTER TABLE product_tags_off RENAME TO product_tags`)
+
+	env.exec(`ALTER TABLE product_deletions RENAME TO product_deletions_off`)
+	if w := env.do(http.MethodPost, "/api/admin/products/delete", map[string]int64{"id": id}, env.admin()); w.Code != http.StatusInternalServerError {
+		t.Fatalf("delete with broken log = %d, want 500", w.Code)
+	}
+	if _, err := os.Stat("uploads/carrot.png"); err != nil {
+		t.Fatalf("photo removed before delete: %v", err)
+	}
+	env.exec(`ALTER TABLE product_deletions_off RENAME TO product_deletions`)
+
+	if w := env.do(http.MethodPost, "/api/admin/products/delete", map[string]int64{"id": id}, env.admin()); w.Code != http.StatusOK {
+		t.Fatalf("delete = %d", w.Code)
+	}
+	if _, err := os.Stat("uploads/carrot.png"); !os.IsNotExist(err) {
+		t.Fatalf("photo kept after delete: %v", err)
+	}
+}
+
+func TestE2EFeaturedProducts(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedStore("aksai", "Аксай")
+	a := env.seedProduct("Картофель", "vegetables", 250, "samal3")
+	b := env.seedProduct("Яблоки", "fruits", 600, "aksai")
+	c := env.seedProduct("Укроп", "greens", 100, "")
+	env.seedProduct("Лук", "vegetables", 150, "samal3")
+	env.exec(`UPDATE products SET featured = 1, sort_order = 2 WHERE id = ?`, a)
+	env.exec(`UPDATE products SET featured = 1, sort_order = 1 WHERE id IN (?, ?)`, b, c)
+	env.seedUser(555, "samal3")
+
+	var out []struct {
+		Name string `json:"name"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/products/featured", nil, map[string]string{"X-Telegram-Id": "555"}), &out)
+	if len(out) != 2 || out[0].Name != "Укроп" || out[1].Name != "Картофель" {
+		t.Fatalf("featured = %+v, want [Укроп Картофель]", out)
+	}
+
+	decode(t, env.do(http.MethodGet, "/api/products/featured?limit=1", nil, nil), &out)
+	if len(out) != 1 {
+		t.Fatalf("featured with limit=1 = %+v", out)
+	}
+}
+
+func TestE2EBackInStockNotification(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	id := env.seedProduct("Клубника", "fruits", 1200, "samal3")
+	env.exec(`UPDATE products SET stock_qty = 0 WHERE id = ?`, id)
+
+	for i := 0; i < 2; i++ {
+		w := env.do(http.MethodPost, "/api/user/notify-stock", map[string]any{"telegram_id": "777", "product_id": id}, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("notify-stock = %d %s", w.Code, w.Body.String())
+		}
+	}
+	if w := env.do(http.MethodPost, "/api/user/notify-stock", map[string]any{"telegram_id": 777, "product_id": 9999}, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("notify-stock for unknown product = %d, want 404", w.Code)
+	}
+
+	body, ct := env.multipart(map[string]string{
+		"id": strconv.FormatInt(id, 10), "name": "Клубника", "category": "fruits", "unit": "кг",
+		"price": "1200", "store_code": "samal3", "stock_qty": "5",
+	})
+	hdr := env.admin()
+	hdr["Content-Type"] = ct
+	if w := env.do(http.MethodPost, "/api/admin/products/update", body, hdr); w.Code != http.StatusOK {
+		t.Fatalf("update status = %d", w.Code)
+	}
+
+	msgs := waitMessages(t, env.sender, 777, 1)
+	if len(msgs) != 1 || !strings.Contains(msgs[0], "Клубника") {
+		t.Fatalf("back-in-stock messages = %q, want exactly one", msgs)
+	}
+
+	var pending int
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_ = env.h.db.QueryRow(`SELECT COUNT(1) FROM stock_notifications WHERE notified_at IS NULL`).Scan(&pending)
+		if pending == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pending != 0 {
+		t.Fatalf("pending notifications = %d, want 0", pending)
+	}
+}
+
+func TestE2EParallelConfirmCreatesOneOrder(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	pid := env.seedProduct("Картофель", "vegetables", 250, "samal3")
+	env.seedUser(555, "samal3")
+
+	body := map[string]any{
+		"telegram_id": "555",
+		"items":       []map[string]any{{"product_id": pid, "name": "Картофель", "qty": 2, "unit": "кг", "price": 250}},
+		"delivery":    map[string]any{"type": "pickup"},
+	}
+	const n = 5
+	codes := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- env.do(http.MethodPost, "/api/orders/confirm", body, nil).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	for c := range codes {
+		if c != http.StatusOK && c != http.StatusConflict {
+			t.Fatalf("confirm status = %d", c)
+		}
+	}
+
+	var orders int
+	_ = env.h.db.QueryRow(`SELECT COUNT(1) FROM orders WHERE user_id = 555`).Scan(&orders)
+	if orders != 1 {
+		t.Fatalf("orders = %d, want 1", orders)
+	}
+}
+
+func TestE2EDeliveryZones(t *testing.T) {
+	env := newTestEnv(t)
+	env.h.cfg.DeliveryRadiusKm = 5
+	env.seedStore("samal3", "Самал-3")
+	env.seedStore("aksai", "Аксай")
+	env.exec(`UPDATE stores SET latitude = 43.2, longitude = 76.9 WHERE code = 'aksai'`)
+	pid := env.seedProduct("Картофель", "vegetables", 250, "")
+
+	square := `{"type":"Polygon","coordinates":[[[76.90,43.20],[76.95,43.20],[76.95,43.25],[76.90,43.25],[76.90,43.20]]]}`
+	w := env.do(http.MethodPost, "/api/admin/stores/zone", `{"store_code":"samal3","polygon":`+square+`}`, env.admin())
+	if w.Code != http.StatusOK {
+		t.Fatalf("set zone = %d %s", w.Code, w.Body.String())
+	}
+
+	var fc struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry   struct{ Type string } `json:"geometry"`
+			Properties map[string]any        `json:"properties"`
+		} `json:"features"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/stores/zones", nil, nil), &fc)
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 1 || fc.Features[0].Geometry.Type != "Polygon" ||
+		fc.Features[0].Properties["store_code"] != "samal3" {
+		t.Fatalf("zones = %+v", fc)
+	}
+
+	confirm := func(tgID int64, lat, lng float64) int {
+		return env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+			"telegram_id": tgID,
+			"items":       []map[string]any{{"product_id": pid, "name": "Картофель", "qty": 1, "unit": "кг", "price": 250}},
+			"delivery":    map[string]any{"type": "delivery", "lat": lat, "lng": lng},
+		}, nil).Code
+	}
+
+	env.seedUser(501, "samal3")
+	if code := confirm(501, 43.22, 76.92); code != http.StatusOK {
+		t.Fatalf("inside polygon = %d, want 200", code)
+	}
+	env.seedUser(502, "samal3")
+	if code := confirm(502, 43.30, 76.92); code != http.StatusBadRequest {
+		t.Fatalf("outside polygon = %d, want 400", code)
+	}
+
+	// у Аксая полигона нет — радиус 5 км от точки
+	env.seedUser(503, "aksai")
+	if code := confirm(503, 43.21, 76.91); code != http.StatusOK {
+		t.Fatalf("inside radius = %d, want 200", code)
+	}
+	env.seedUser(504, "aksai")
+	if code := confirm(504, 43.40, 76.90); code != http.StatusBadRequest {
+		t.Fatalf("outside radius = %d, want 400", code)
+	}
+}
+
+func TestE2EDeliveryTiers(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("aksai", "Аксай")
+	env.exec(`UPDATE stores SET latitude = 43.2, longitude = 76.9 WHERE code = 'aksai'`)
+	env.seedUser(601, "aksai")
+	potato := env.seedProduct("Картофель", "vegetables", 250, "aksai")
+
+	quote := func(lat, lng float64) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, "/api/orders/quote", map[string]any{
+			"telegram_id": 601,
+			"items":       []map[string]any{{"product_id": potato, "name": "Картофель", "qty": 1, "unit": "кг", "price": 250}},
+			"delivery":    map[string]any{"type": "delivery", "lat": lat, "lng": lng},
+		}, nil)
+	}
+	type quoteOut struct {
+		DeliveryPrice int64         `json:"delivery_price"`
+		DeliveryTier  *deliveryTier `json:"delivery_tier"`
+	}
+
+	// без ступеней — плоская ставка
+	var q quoteOut
+	decode(t, quote(43.21, 76.91), &q)
+	if q.DeliveryPrice != deliveryFlatPrice || q.DeliveryTier != nil {
+		t.Fatalf("flat quote = %+v", q)
+	}
+
+	w := env.do(http.MethodPost, "/api/admin/delivery/tiers/set",
+		`{"store_code":"aksai","tiers":[{"up_to_km":7,"price":1200},{"up_to_km":3,"price":800}]}`, env.admin())
+	if w.Code != http.StatusOK {
+		t.Fatalf("set tiers = %d %s", w.Code, w.Body.String())
+	}
+	var list struct{ Tiers []deliveryTier }
+	decode(t, env.do(http.MethodGet, "/api/admin/delivery/tiers?store_code=aksai", nil, env.admin()), &list)
+	if len(list.Tiers) != 2 || list.Tiers[0].UpToKm != 3 || list.Tiers[1].Price != 1200 {
+		t.Fatalf("tiers = %+v", list.Tiers)
+	}
+
+	// ~1.4 км — первая ступень, ~5.6 км — вторая, ~22 км — вне тарифа
+	q = quoteOut{}
+	decode(t, quote(43.21, 76.91), &q)
+	if q.DeliveryPrice != 800 || q.DeliveryTier == nil || q.DeliveryTier.UpToKm != 3 {
+		t.Fatalf("near quote = %+v", q)
+	}
+	q = quoteOut{}
+	decode(t, quote(43.25, 76.9), &q)
+	if q.DeliveryPrice != 1200 || q.DeliveryTier == nil || q.DeliveryTier.UpToKm != 7 {
+		t.Fatalf("far quote = %+v", q)
+	}
+	if w := quote(43.4, 76.9); w.Code != http.StatusBadRequest {
+		t.Fatalf("beyond tiers = %d, want 400", w.Code)
+	}
+
+	if w := env.do(http.MethodPost, "/api/admin/delivery/tiers/set",
+		`{"store_code":"aksai","tiers":[{"up_to_km":0,"price":500}]}`, env.admin()); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad tier = %d, want 400", w.Code)
+	}
+}
+
+func TestE2EOrderStatusButtons(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.seedUser(701, "")
+	env.exec(`INSERT INTO orders (id, user_id, total_amount, status) VALUES (42, 701, 1000, 'new')`)
+
+	kb := orderActionsMarkup(42, "new")
+	if len(kb.InlineKeyboard) != 2 || kb.InlineKeyboard[0][0].CallbackData != "ord_preparing:42" {
+		t.Fatalf("new order keyboard = %+v", kb.InlineKeyboard)
+	}
+	staleData := kb.InlineKeyboard[0][0].CallbackData
+
+	press := func(from int64, data string) {
+		env.h.OrderStatusCallbackHandler(ctx, nil, &models.Update{CallbackQuery: &models.CallbackQuery{
+			ID:   "cb",
+			From: models.User{ID: from},
+			Data: data,
+			Message: models.MaybeInaccessibleMessage{Message: &models.Message{
+				ID:   5,
+				Chat: models.Chat{ID: testAdminID},
+				Text: "🧾 Новый заказ №42",
+			}},
+		}})
+	}
+	orderStatus := func() string {
+		var s string
+		_ = env.h.db.QueryRow(`SELECT status FROM orders WHERE id = 42`).Scan(&s)
+		return s
+	}
+
+	// не админ — статус не меняется
+	press(701, "ord_done:42")
+	if s := orderStatus(); s != "new" {
+		t.Fatalf("status after non-admin press = %q", s)
+	}
+
+	press(testAdminID, "ord_done:42")
+	if s := orderStatus(); s != orderDone {
+		t.Fatalf("status = %q, want done", s)
+	}
+	if msgs := env.sender.MessagesTo(701); len(msgs) != 1 || !strings.Contains(msgs[0], "выполнен") {
+		t.Fatalf("customer messages = %q", msgs)
+	}
+	if len(env.sender.Edits) != 1 || env.sender.Edits[0].Text != "🧾 Новый заказ №42\n\n📌 Статус: выполнен" {
+		t.Fatalf("edits = %+v", env.sender.Edits)
+	}
+	if kb := env.sender.Edits[0].ReplyMarkup.(*models.InlineKeyboardMarkup); len(kb.InlineKeyboard) != 0 {
+		t.Fatalf("done keyboard = %+v", kb.InlineKeyboard)
+	}
+
+	// устаревшая кнопка «Собирается» не откатывает выполненный заказ
+	press(testAdminID, staleData)
+	if s := orderStatus(); s != orderDone {
+		t.Fatalf("stale press regressed status to %q", s)
+	}
+	if msgs := env.sender.MessagesTo(701); len(msgs) != 1 {
+		t.Fatalf("customer notified on stale press: %q", msgs)
+	}
+
+	// тот же переход через admin API
+	env.exec(`INSERT INTO orders (id, user_id, total_amount, status) VALUES (43, 701, 1000, 'paid')`)
+	if w := env.do(http.MethodPost, "/api/admin/orders/status", map[string]any{"order_id": 43, "status": "delivering"}, env.admin()); w.Code != http.StatusOK {
+		t.Fatalf("admin set status = %d %s", w.Code, w.Body.String())
+	}
+	if w := env.do(http.MethodPost, "/api/admin/orders/status", map[string]any{"order_id": 43, "status": "preparing"}, env.admin()); w.Code != http.StatusConflict {
+		t.Fatalf("regress via api = %d, want 409", w.Code)
+	}
+}
+
+func TestE2EOrderFeedback(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.seedStore("samal3", "Самал-3")
+	env.seedUser(801, "samal3")
+	env.exec(`INSERT INTO orders (id, user_id, store_code, total_amount, status) VALUES (51, 801, 'samal3', 1000, 'new')`)
+	env.exec(`INSERT INTO orders (id, user_id, store_code, total_amount, status) VALUES (52, 801, 'samal3', 2000, 'done')`)
+
+	feedback := func(tgID, orderID int64, rating int) int {
+		return env.do(http.MethodPost, "/api/orders/feedback", map[string]any{
+			"telegram_id": tgID, "order_id": orderID, "rating": rating, "comment": "свежие овощи",
+		}, nil).Code
+	}
+
+	if code := feedback(801, 51, 5); code != http.StatusBadRequest {
+		t.Fatalf("feedback on new order = %d, want 400", code)
+	}
+	if code := feedback(999, 52, 5); code != http.StatusBadRequest {
+		t.Fatalf("feedback on someone else's order = %d, want 400", code)
+	}
+	if code := feedback(801, 52, 6); code != http.StatusBadRequest {
+		t.Fatalf("rating 6 = %d, want 400", code)
+	}
+	if code := feedback(801, 52, 4); code != http.StatusOK {
+		t.Fatalf("feedback = %d, want 200", code)
+	}
+	if code := feedback(801, 52, 5); code != http.StatusConflict {
+		t.Fatalf("duplicate feedback = %d, want 409", code)
+	}
+
+	// заказ 51 выполнен — покупатель получает звёзды и оценивает из чата
+	env.h.OrderStatusCallbackHandler(ctx, nil, &models.Update{CallbackQuery: &models.CallbackQuery{
+		ID: "cb", From: models.User{ID: testAdminID}, Data: "ord_done:51",
+	}})
+	var stars *models.InlineKeyboardMarkup
+	for _, m := range env.sender.Messages {
+		if id, _ := m.ChatID.(int64); id == 801 {
+			stars, _ = m.ReplyMarkup.(*models.InlineKeyboardMarkup)
+		}
+	}
+	if stars == nil || len(stars.InlineKeyboard) != 5 {
+		t.Fatalf("done message has no rating buttons: %+v", stars)
+	}
+	env.h.FeedbackCallbackHandler(ctx, nil, &models.Update{CallbackQuery: &models.CallbackQuery{
+		ID: "fb", From: models.User{ID: 801}, Data: stars.InlineKeyboard[1][0].CallbackData,
+	}})
+
+	var stats struct {
+		Stores []struct {
+			StoreCode string   `json:"store_code"`
+			Orders    int64    `json:"orders"`
+			Ratings   int64    `json:"ratings"`
+			AvgRating *float64 `json:"avg_rating"`
+		} `json:"stores"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/admin/stats", nil, env.admin()), &stats)
+	if len(stats.Stores) != 1 || stats.Stores[0].Orders != 2 || stats.Stores[0].Ratings != 2 ||
+		stats.Stores[0].AvgRating == nil || *stats.Stores[0].AvgRating != 3 {
+		t.Fatalf("stats = %+v", stats.Stores)
+	}
+}
+
+func TestE2ESetStore(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedStore("aksai", "Аксай")
+	env.exec(`UPDATE stores SET latitude = 43.2, longitude = 76.9, working_hours = '09:00–21:00' WHERE code = 'aksai'`)
+	env.seedUser(901, "samal3")
+	env.redis.Set("products:user:901", "[]")
+
+	setStore := func(store string) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, "/api/user/set-store", map[string]string{"telegram_id": "901", "store": store}, nil)
+	}
+
+	// неоплаченный заказ на Самал-3 не даёт сменить точку
+	env.exec(`INSERT INTO orders (id, user_id, store_code, total_amount, status) VALUES (61, 901, 'samal3', 1000, 'new')`)
+	w := setStore("aksai")
+	var errBody struct{ Error, Code string }
+	decode(t, w, &errBody)
+	if w.Code != http.StatusConflict || errBody.Code != "unpaid_order_in_store" {
+		t.Fatalf("switch with unpaid order = %d %+v", w.Code, errBody)
+	}
+
+	env.exec(`UPDATE orders SET status = 'paid' WHERE id = 61`)
+	var out struct {
+		Store struct {
+			Code         string   `json:"code"`
+			Name         string   `json:"name"`
+			Lat          *float64 `json:"lat"`
+			WorkingHours string   `json:"working_hours"`
+		} `json:"store"`
+		PreviousStore string `json:"previous_store"`
+	}
+	decode(t, setStore("Аксай"), &out) // по названию тоже находится
+	if out.Store.Code != "aksai" || out.Store.Lat == nil || *out.Store.Lat != 43.2 ||
+		out.Store.WorkingHours != "09:00–21:00" || out.PreviousStore != "samal3" {
+		t.Fatalf("set-store response = %+v", out)
+	}
+	if env.redis.Exists("products:user:901") {
+		t.Fatal("product cache was not invalidated")
+	}
+
+	var selected, previous string
+	_ = env.h.db.QueryRow(`SELECT selected_store, previous_store FROM users WHERE user_id = 901`).Scan(&selected, &previous)
+	if selected != "aksai" || previous != "samal3" {
+		t.Fatalf("user row: selected=%q previous=%q", selected, previous)
+	}
+
+	// повторный выбор той же точки не затирает previous_store
+	decode(t, setStore("aksai"), &out)
+	if out.PreviousStore != "aksai" {
+		t.Fatalf("previous in response = %q", out.PreviousStore)
+	}
+	_ = env.h.db.QueryRow(`SELECT previous_store FROM users WHERE user_id = 901`).Scan(&previous)
+	if previous != "samal3" {
+		t.Fatalf("previous_store overwritten: %q", previous)
+	}
+}
+
+func TestE2ESetStoreLikeEscaping(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("s100", "Склад 100%")
+	env.seedStore("s_a", "Точка_А")
+	env.seedStore("sba", "ТочкаБА")
+	env.seedUser(902, "")
+
+	cases := []struct{ input, wantCode string }{
+		{"Склад 100%", "s100"},
+		{"Точка_А", "s_a"}, // _ не должен совпасть с «ТочкаБА»
+		{"%", ""},
+		{"Точка_", ""},
+		{"_", ""},
+	}
+	for _, c := range cases {
+		w := env.do(http.MethodPost, "/api/user/set-store", map[string]string{"telegram_id": "902", "store": c.input}, nil)
+		var out struct {
+			Store struct {
+				Code string `json:"code"`
+			} `json:"store"`
+		}
+		decode(t, w, &out)
+		if c.wantCode == "" {
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%q: status = %d, want 400", c.input, w.Code)
+			}
+			continue
+		}
+		if w.Code != http.StatusOK || out.Store.Code != c.wantCode {
+			t.Errorf("%q: status = %d, code = %q, want %q", c.input, w.Code, out.Store.Code, c.wantCode)
+		}
+	}
+}
+
+func TestE2EWelcomeReturningUser(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	hello := func(id int64, username string) (string, *models.InlineKeyboardMarkup) {
+		t.Helper()
+		before := len(env.sender.Messages)
+		env.h.DefaultHandler(ctx, nil, &models.Update{Message: &models.Message{
+			From: &models.User{ID: id, Username: username, FirstName: "Айгуль"},
+			Chat: models.Chat{ID: id},
+			Text: "/start",
+		}})
+		// приветствие с мини-аппом + сообщение с меню
+		if len(env.sender.Messages) != before+2 {
+			t.Fatalf("welcome messages = %d", len(env.sender.Messages)-before)
+		}
+		m := env.sender.Messages[before]
+		kb, _ := m.ReplyMarkup.(*models.InlineKeyboardMarkup)
+		return m.Text, kb
+	}
+
+	// новый пользователь: обычное приветствие, строка в users создана
+	text, kb := hello(903, "")
+	if !strings.Contains(text, "Привет!") || strings.Contains(kb.InlineKeyboard[0][0].Text, "заказов") {
+		t.Fatalf("first welcome = %q / %q", text, kb.InlineKeyboard[0][0].Text)
+	}
+	var nick string
+	_ = env.h.db.QueryRow(`SELECT nickname FROM users WHERE user_id = 903`).Scan(&nick)
+	if nick != "Айгуль" {
+		t.Fatalf("nickname = %q", nick)
+	}
+
+	// постоянный покупатель: ник из Telegram не затирает сохранённый
+	env.exec(`INSERT INTO orders (user_id, total_amount, status) VALUES (903, 1000, 'done'), (903, 2000, 'new')`)
+	text, kb = hello(903, "aigul_kz")
+	if !strings.Contains(text, "Добро пожаловать обратно, Айгуль! У вас 2 заказа.") {
+		t.Fatalf("returning welcome = %q", text)
+	}
+	if !strings.Contains(kb.InlineKeyboard[0][0].Text, "заказов: 2") {
+		t.Fatalf("button text = %q", kb.InlineKeyboard[0][0].Text)
+	}
+}
+
+func TestE2EUserMenu(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.h.SetClock(&fakeClock{t: time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC)})
+	env.seedStore("aksai", "Аксай")
+	env.seedUser(910, "aksai")
+
+	press := func(text string) *bot.SendMessageParams {
+		t.Helper()
+		before := len(env.sender.Messages)
+		env.h.UserMenuHandler(ctx, nil, &models.Update{Message: &models.Message{
+			From: &models.User{ID: 910}, Chat: models.Chat{ID: 910}, Text: text,
+		}})
+		if len(env.sender.Messages) != before+1 {
+			t.Fatalf("%s: replies = %d", text, len(env.sender.Messages)-before)
+		}
+		return env.sender.Messages[before]
+	}
+
+	// меню приходит вместе с приветствием
+	env.h.DefaultHandler(ctx, nil, &models.Update{Message: &models.Message{
+		From: &models.User{ID: 910}, Chat: models.Chat{ID: 910}, Text: "/start",
+	}})
+	menu, ok := env.sender.Messages[1].ReplyMarkup.(*models.ReplyKeyboardMarkup)
+	if !ok || len(menu.Keyboard) != 2 || menu.Keyboard[0][0].Text != MenuMyOrders || !menu.IsPersistent {
+		t.Fatalf("menu keyboard = %+v", env.sender.Messages[1].ReplyMarkup)
+	}
+
+	if m := press(MenuMyOrders); !strings.Contains(m.Text, "нет заказов") {
+		t.Fatalf("no orders = %q", m.Text)
+	}
+	env.exec(`INSERT INTO orders (id, user_id, total_amount, status) VALUES (71, 910, 1500, 'preparing'), (72, 911, 900, 'new')`)
+	if m := press(MenuMyOrders); !strings.Contains(m.Text, "№71") || strings.Contains(m.Text, "№72") ||
+		!strings.Contains(m.Text, humanOrderStatus("preparing")) {
+		t.Fatalf("my orders = %q", m.Text)
+	}
+
+	if m := press(MenuMySub); !strings.Contains(m.Text, "подписки нет") || m.ReplyMarkup == nil {
+		t.Fatalf("no subscription = %q", m.Text)
+	}
+	env.exec(`UPDATE users SET sub_status = 'active', sub_until = '2025-05-01 00:00:00' WHERE user_id = 910`)
+	if m := press(MenuMySub); !strings.Contains(m.Text, "активна до "+formatDate(time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC))) {
+		t.Fatalf("active subscription = %q", m.Text)
+	}
+
+	m := press(MenuChooseStore)
+	kb := m.ReplyMarkup.(*models.InlineKeyboardMarkup)
+	if !strings.Contains(m.Text, "Аксай") || !strings.HasSuffix(kb.InlineKeyboard[0][0].WebApp.URL, "/store-select") {
+		t.Fatalf("choose store = %q / %+v", m.Text, kb.InlineKeyboard)
+	}
+
+	if kb := press(MenuSupport).ReplyMarkup.(*models.InlineKeyboardMarkup); kb.InlineKeyboard[0][0].URL != fmt.Sprintf("tg://user?id=%d", testAdminID) {
+		t.Fatalf("support default = %+v", kb.InlineKeyboard)
+	}
+	env.h.cfg.SupportContact = "@agro_help"
+	if kb := press(MenuSupport).ReplyMarkup.(*models.InlineKeyboardMarkup); kb.InlineKeyboard[0][0].URL != "https://t.me/agro_help" {
+		t.Fatalf("support contact = %+v", kb.InlineKeyboard)
+	}
+}
+
+func TestRuPlural(t *testing.T) {
+	for n, want := range map[int]string{1: "заказ", 2: "заказа", 4: "заказа", 5: "заказов", 11: "заказов", 12: "заказов", 21: "заказ", 111: "заказов", 122: "заказа"} {
+		if got := ruPlural(n, "заказ", "заказа", "заказов"); got != want {
+			t.Errorf("ruPlural(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestE2ERequestInvoiceExistingSubscription(t *testing.T) {
+	now := time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name        string
+		status      string
+		until       any
+		wantStatus  string
+		wantPending int
+		wantUser    string
+	}{
+		{"active", "active", now.AddDate(0, 0, 20), "already_active", 0, "active"},
+		{"near expiry", "active", now.AddDate(0, 0, 2), "ok", 1, "active"},
+		{"expired", "expired", nil, "ok", 1, "pending"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			env.h.SetClock(&fakeClock{t: now})
+			env.exec(`INSERT INTO users (id, user_id, nickname, sub_status, sub_until) VALUES ('u904', 904, 'tester', ?, ?)`, c.status, c.until)
+
+			var out struct {
+				Status  string `json:"status"`
+				Until   string `json:"until"`
+				Renewal bool   `json:"renewal"`
+			}
+			w := env.do(http.MethodPost, "/api/subscribe/request-invoice",
+				map[string]string{"telegram_id": "904", "phone": "+77010000000"}, nil)
+			decode(t, w, &out)
+			if w.Code != http.StatusOK || out.Status != c.wantStatus {
+				t.Fatalf("response = %d %+v", w.Code, out)
+			}
+
+			var pending int
+			var userStatus string
+			_ = env.h.db.QueryRow(`SELECT COUNT(1) FROM subscriptions WHERE user_id = 904 AND status = 'pending'`).Scan(&pending)
+			_ = env.h.db.QueryRow(`SELECT sub_status FROM users WHERE user_id = 904`).Scan(&userStatus)
+			if pending != c.wantPending || userStatus != c.wantUser {
+				t.Fatalf("pending = %d, sub_status = %q", pending, userStatus)
+			}
+
+			switch c.name {
+			case "active":
+				if out.Until != "2025-04-30" || len(env.sender.MessagesTo(904)) != 0 {
+					t.Fatalf("already active: until = %q, messages = %q", out.Until, env.sender.MessagesTo(904))
+				}
+			case "near expiry":
+				if !out.Renewal {
+					t.Fatal("renewal flag not set")
+				}
+			}
+		})
+	}
+}
+
+func TestE2EMaintenanceMode(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	pid := env.seedProduct("Картофель", "veg", 250, "samal3")
+
+	setMaintenance := func(body map[string]any) {
+		t.Helper()
+		w := env.do(http.MethodPost, "/api/admin/maintenance", body, env.admin())
+		if w.Code != http.StatusOK {
+			t.Fatalf("set maintenance = %d %s", w.Code, w.Body.String())
+		}
+	}
+	if w := env.do(http.MethodPost, "/api/admin/maintenance", map[string]any{"enabled": true}, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin toggle = %d", w.Code)
+	}
+
+	setMaintenance(map[string]any{"enabled": true, "message": "Импорт каталога до 15:00"})
+
+	writes := []struct {
+		path string
+		body any
+	}{
+		{"/api/orders/create", map[string]any{"telegram_id": "905", "items": []map[string]any{{"product_id": pid, "qty": 1}}}},
+		{"/api/orders/confirm", map[string]any{"telegram_id": "905", "items": []map[string]any{{"product_id": pid, "qty": 1}}}},
+		{"/api/subscribe/request-invoice", map[string]string{"telegram_id": "905", "phone": "+77010000000"}},
+	}
+	for _, wr := range writes {
+		w := env.do(http.MethodPost, wr.path, wr.body, nil)
+		var out struct{ Error, Code string }
+		decode(t, w, &out)
+		if w.Code != http.StatusServiceUnavailable || out.Code != "maintenance" || out.Error != "Импорт каталога до 15:00" {
+			t.Fatalf("%s in maintenance = %d %+v", wr.path, w.Code, out)
+		}
+	}
+	if w := env.do(http.MethodGet, "/api/products", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("products in maintenance = %d", w.Code)
+	}
+
+	env.h.DefaultHandler(context.Background(), nil, &models.Update{Message: &models.Message{
+		From: &models.User{ID: 905}, Chat: models.Chat{ID: 905}, Text: "/start",
+	}})
+	if msgs := env.sender.MessagesTo(905); len(msgs) != 2 || !strings.HasPrefix(msgs[0], "Импорт каталога до 15:00") {
+		t.Fatalf("welcome in maintenance = %q", msgs)
+	}
+
+	// пустой текст — стандартное сообщение; выключение возвращает приём заказов
+	setMaintenance(map[string]any{"enabled": true, "message": ""})
+	var st struct {
+		Enabled bool   `json:"enabled"`
+		Message string `json:"message"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/maintenance", nil, nil), &st)
+	if !st.Enabled || st.Message != defaultMaintenanceMessage {
+		t.Fatalf("status = %+v", st)
+	}
+	setMaintenance(map[string]any{"enabled": false})
+	if w := env.do(http.MethodPost, "/api/orders/create", writes[0].body, nil); w.Code == http.StatusServiceUnavailable {
+		t.Fatalf("create after maintenance off = %d", w.Code)
+	}
+}
+
+func TestE2EBulkPriceUpdate(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedStore("aksai", "Аксай")
+	potato := env.seedProduct("Картофель", "veg", 250, "samal3")
+	onion := env.seedProduct("Лук", "veg", 333, "samal3")
+	apple := env.seedProduct("Яблоки", "fruit", 500, "samal3")
+	other := env.seedProduct("Морковь", "veg", 200, "aksai")
+	hidden := env.seedProduct("Свёкла", "veg", 100, "samal3")
+	env.exec(`UPDATE products SET active = 0 WHERE id = ?`, hidden)
+
+	bulk := func(body map[string]any) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, "/api/admin/products/bulk-price-update", body, env.admin())
+	}
+	for _, d := range []float64{-51, 101, 0} {
+		if w := bulk(map[string]any{"store_code": "samal3", "category_slug": "veg", "delta_percent": d}); w.Code != http.StatusBadRequest {
+			t.Fatalf("delta %v = %d", d, w.Code)
+		}
+	}
+	if w := env.do(http.MethodPost, "/api/admin/products/bulk-price-update",
+		map[string]any{"store_code": "samal3", "delta_percent": 10}, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin = %d", w.Code)
+	}
+
+	var out struct {
+		Updated int64 `json:"updated"`
+	}
+	decode(t, bulk(map[string]any{"store_code": "samal3", "category_slug": "veg", "delta_percent": 10}), &out)
+	if out.Updated != 2 {
+		t.Fatalf("updated = %d, want 2", out.Updated)
+	}
+
+	price := func(id int64) (p int64) {
+		_ = env.h.db.QueryRow(`SELECT price FROM products WHERE id = ?`, id).Scan(&p)
+		return p
+	}
+	for id, want := range map[int64]int64{potato: 275, onion: 366, apple: 500, other: 200, hidden: 100} {
+		if got := price(id); got != want {
+			t.Errorf("product %d price = %d, want %d", id, got, want)
+		}
+	}
+
+	var oldP, newP int64
+	var source string
+	_ = env.h.db.QueryRow(`SELECT old_price, new_price, source FROM product_price_history WHERE product_id = ?`, onion).Scan(&oldP, &newP, &source)
+	if oldP != 333 || newP != 366 || source != "bulk" {
+		t.Fatalf("history = %d -> %d (%s)", oldP, newP, source)
+	}
+	var rows int
+	_ = env.h.db.QueryRow(`SELECT COUNT(1) FROM product_price_history`).Scan(&rows)
+	if rows != 2 {
+		t.Fatalf("history rows = %d, want 2", rows)
+	}
+}
+
+func TestE2EWebhooks(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	clock := &fakeClock{t: time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC)}
+	env.h.SetClock(clock)
+	env.seedStore("samal3", "Самал-3")
+	pid := env.seedProduct("Картофель", "vegetables", 250, "samal3")
+	env.seedUser(906, "samal3")
+
+	type received struct {
+		event, signature string
+		body             []byte
+	}
+	var (
+		mu       sync.Mutex
+		got      []received
+		failNext = true
+	)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, received{r.Header.Get("X-Agro-Event"), r.Header.Get("X-Agro-Signature"), body})
+		if failNext {
+			failNext = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	env.h.webhookClient = srv.Client() // тестовый сервер — на 127.0.0.1 с самоподписанным сертификатом
+
+	if w := env.do(http.MethodPost, "/api/admin/webhooks/add",
+		map[string]any{"url": "https://hooks.example.com/agro", "events": []string{"order.unknown"}}, env.admin()); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown event = %d", w.Code)
+	}
+	// только https и не внутренние адреса
+	for _, bad := range []string{"http://hooks.example.com/agro", srv.URL, "https://localhost/hook", "https://10.0.0.5/hook", "https://[::1]/hook"} {
+		if w := env.do(http.MethodPost, "/api/admin/webhooks/add",
+			map[string]any{"url": bad, "events": []string{webhookOrderCreated}}, env.admin()); w.Code != http.StatusBadRequest {
+			t.Fatalf("add %s = %d, want 400", bad, w.Code)
+		}
+	}
+	var added struct {
+		ID     int64  `json:"id"`
+		Secret string `json:"secret"`
+	}
+	decode(t, env.do(http.MethodPost, "/api/admin/webhooks/add", map[string]any{
+		"url": "https://hooks.example.com/agro", "secret": "s3cr3t", "events": []string{webhookOrderCreated, webhookOrderStatusChanged},
+	}, env.admin()), &added)
+	if added.ID == 0 || added.Secret != "s3cr3t" {
+		t.Fatalf("add webhook = %+v", added)
+	}
+	if w := env.do(http.MethodPost, "/api/admin/webhooks/update",
+		map[string]any{"id": added.ID, "url": "https://192.168.1.10/hook"}, env.admin()); w.Code != http.StatusBadRequest {
+		t.Fatalf("update to private url = %d, want 400", w.Code)
+	}
+	env.exec(`UPDATE webhooks SET url = ? WHERE id = ?`, srv.URL, added.ID)
+
+	// ping уходит сразу, без очереди
+	var ping struct {
+		OK         bool `json:"ok"`
+		StatusCode int  `json:"status_code"`
+	}
+	failNext = false
+	decode(t, env.do(http.MethodPost, "/api/admin/webhooks/ping", map[string]any{"id": added.ID}, env.admin()), &ping)
+	if !ping.OK || ping.StatusCode != http.StatusOK || len(got) != 1 || got[0].event != webhookPing {
+		t.Fatalf("ping = %+v, received = %d", ping, len(got))
+	}
+	got, failNext = nil, true
+
+	var order struct {
+		OrderID int64 `json:"order_id"`
+	}
+	decode(t, env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+		"telegram_id":    "906",
+		"payment_method": "kaspi_transfer",
+		"items":          []map[string]any{{"product_id": pid, "name": "Картофель", "qty": 2, "unit": "кг", "price": 250}},
+		"delivery":       map[string]any{"type": "pickup", "phone": "+77010000000"},
+	}, nil), &order)
+
+	// первая попытка — 503 от получателя: доставка откладывается
+	env.h.deliverWebhooks(ctx)
+	var status string
+	var attempts int
+	_ = env.h.db.QueryRow(`SELECT status, attempts FROM webhook_deliveries WHERE event = ?`, webhookOrderCreated).Scan(&status, &attempts)
+	if len(got) != 1 || status != "pending" || attempts != 1 {
+		t.Fatalf("after failure: received = %d, status = %s, attempts = %d", len(got), status, attempts)
+	}
+	var code, deliveryOrder int64
+	_ = env.h.db.QueryRow(`SELECT status_code, order_id FROM webhook_deliveries WHERE event = ?`, webhookOrderCreated).Scan(&code, &deliveryOrder)
+	if code != http.StatusServiceUnavailable || deliveryOrder != order.OrderID {
+		t.Fatalf("delivery log: status_code = %d, order_id = %d", code, deliveryOrder)
+	}
+	env.h.deliverWebhooks(ctx) // backoff ещё не прошёл
+	if len(got) != 1 {
+		t.Fatalf("retried before backoff: %d", len(got))
+	}
+
+	clock.t = clock.t.Add(webhookBackoff(1) + time.Second)
+	env.h.deliverWebhooks(ctx)
+	_ = env.h.db.QueryRow(`SELECT status FROM webhook_deliveries WHERE event = ?`, webhookOrderCreated).Scan(&status)
+	if len(got) != 2 || status != "delivered" {
+		t.Fatalf("after retry: received = %d, status = %s", len(got), status)
+	}
+
+	last := got[1]
+	if last.event != webhookOrderCreated || last.signature != signWebhook("s3cr3t", last.body) {
+		t.Fatalf("event = %q, signature = %q", last.event, last.signature)
+	}
+	if string(got[0].body) != string(last.body) {
+		t.Fatal("retry payload differs from the first attempt")
+	}
+	var env1 struct {
+		Event string       `json:"event"`
+		Data  webhookOrder `json:"data"`
+	}
+	if err := json.Unmarshal(last.body, &env1); err != nil {
+		t.Fatal(err)
+	}
+	if env1.Data.ID != order.OrderID || env1.Data.UserID != 906 || len(env1.Data.Items) == 0 || env1.Data.Items[0].Name != "Картофель" {
+		t.Fatalf("order payload = %+v", env1.Data)
+	}
+
+	// смена статуса админом → order.status_changed с прежним статусом
+	env.do(http.MethodPost, "/api/admin/orders/status", map[string]any{"order_id": order.OrderID, "status": orderPreparing}, env.admin())
+	env.h.deliverWebhooks(ctx)
+	if len(got) != 3 || got[2].event != webhookOrderStatusChanged || !strings.Contains(string(got[2].body), `"previous_status":"new"`) {
+		t.Fatalf("status_changed delivery = %d %q", len(got), got[len(got)-1].body)
+	}
+
+	// выключенный вебхук ничего не получает; список скрывает секрет
+	env.do(http.MethodPost, "/api/admin/webhooks/update", map[string]any{"id": added.ID, "active": false}, env.admin())
+	env.do(http.MethodPost, "/api/admin/orders/status", map[string]any{"order_id": order.OrderID, "status": orderDone}, env.admin())
+	env.h.deliverWebhooks(ctx)
+	if len(got) != 3 {
+		t.Fatalf("inactive webhook received %d", len(got))
+	}
+	var list struct {
+		Webhooks []struct {
+			Secret string `json:"secret"`
+			Active bool   `json:"active"`
+		} `json:"webhooks"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/admin/webhooks", nil, env.admin()), &list)
+	if len(list.Webhooks) != 1 || list.Webhooks[0].Secret != "…cr3t" || list.Webhooks[0].Active {
+		t.Fatalf("list = %+v", list)
+	}
+}
+
+func TestE2EWebhookRetriesExhausted(t *testing.T) {
+	env := newTestEnv(t)
+	clock := &fakeClock{t: time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC)}
+	env.h.SetClock(clock)
+	var hits int
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	env.h.webhookClient = srv.Client()
+	env.exec(`INSERT INTO webhooks (url, secret, events) VALUES (?, 'k', '*')`, srv.URL)
+
+	env.h.emitWebhook(webhookOrderCreated, webhookOrder{ID: 42})
+	for attempt := 1; attempt <= webhookMaxAttempts+1; attempt++ {
+		env.h.deliverWebhooks(context.Background())
+		clock.t = clock.t.Add(webhookBackoff(attempt) + time.Second)
+	}
+	if hits != webhookMaxAttempts {
+		t.Fatalf("hits = %d, want %d", hits, webhookMaxAttempts)
+	}
+
+	var log []struct {
+		OrderID    *int64 `json:"order_id"`
+		Status     string `json:"status"`
+		Attempts   int    `json:"attempts"`
+		StatusCode *int64 `json:"status_code"`
+		Error      string `json:"error"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/admin/webhooks/deliveries?order_id=42", nil, env.admin()), &log)
+	if len(log) != 1 || log[0].Status != "failed" || log[0].Attempts != webhookMaxAttempts ||
+		log[0].StatusCode == nil || *log[0].StatusCode != 500 || log[0].Error == "" {
+		t.Fatalf("deliveries = %+v", log)
+	}
+	if w := env.do(http.MethodGet, "/api/admin/webhooks/deliveries", nil, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin deliveries = %d", w.Code)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	for attempt, want := range map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 4: 4 * time.Minute, 20: time.Hour} {
+		if got := webhookBackoff(attempt); got != want {
+			t.Errorf("webhookBackoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestE2EAdminListSubscriptions(t *testing.T) {
+	env := newTestEnv(t)
+	env.exec(`INSERT INTO users (id, user_id, nickname, phone) VALUES ('u907', 907, 'farmer', '+77010000907')`)
+	env.exec(`INSERT INTO subscriptions (user_id, status, invoice_no, amount, valid_until, created_at) VALUES
+		(907, 'active',  'INV-1', 3000, '2025-05-01 00:00:00', '2025-03-01 10:00:00'),
+		(907, 'pending', 'INV-2', 3000, NULL,                  '2025-04-01 10:00:00'),
+		(908, 'expired', NULL,    3000, '2025-02-01 00:00:00', '2025-01-01 10:00:00'),
+		(908, 'pending', NULL,    3000, NULL,                  '2025-04-02 10:00:00')`)
+
+	type page struct {
+		Items []struct {
+			ID         int64   `json:"id"`
+			UserID     int64   `json:"user_id"`
+			Nickname   string  `json:"nickname"`
+			Phone      string  `json:"phone"`
+			InvoiceNo  string  `json:"invoice_no"`
+			Status     string  `json:"status"`
+			ValidUntil *string `json:"valid_until"`
+		} `json:"items"`
+		Total int64 `json:"total"`
+	}
+	list := func(query string) page {
+		t.Helper()
+		w := env.do(http.MethodGet, "/api/admin/subscriptions"+query, nil, env.admin())
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d %s", query, w.Code, w.Body.String())
+		}
+		var p page
+		decode(t, w, &p)
+		return p
+	}
+
+	if w := env.do(http.MethodGet, "/api/admin/subscriptions", nil, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin = %d", w.Code)
+	}
+
+	// по умолчанию — новые сверху; total не зависит от limit
+	p := list("?limit=2")
+	if p.Total != 4 || len(p.Items) != 2 || p.Items[0].ID != 4 || p.Items[1].ID != 2 {
+		t.Fatalf("first page = %+v", p)
+	}
+	if it := p.Items[1]; it.Nickname != "farmer" || it.Phone != "+77010000907" || it.InvoiceNo != "INV-2" {
+		t.Fatalf("joined user fields = %+v", it)
+	}
+	if p = list("?limit=2&offset=2"); len(p.Items) != 2 || p.Items[0].ID != 1 {
+		t.Fatalf("second page = %+v", p)
+	}
+
+	if p = list("?status=pending&user_id=907"); p.Total != 1 || p.Items[0].ID != 2 {
+		t.Fatalf("status+user filter = %+v", p)
+	}
+	if p = list("?from=2025-03-01&to=2025-04-01"); p.Total != 2 {
+		t.Fatalf("date filter total = %d", p.Total)
+	}
+	if p = list("?sort=valid_until"); p.Items[0].ID != 3 || p.Items[1].ID != 1 || p.Items[3].ValidUntil != nil {
+		t.Fatalf("sort by valid_until = %+v", p)
+	}
+
+	// фильтр по статусу идёт по индексу (status, created_at), а не полным проходом
+	rows, err := env.h.db.Query(`EXPLAIN QUERY PLAN
+		SELECT s.id FROM subscriptions s WHERE s.status = ? ORDER BY s.created_at DESC, s.id DESC`, "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, detail)
+	}
+	rows.Close()
+	if !strings.Contains(strings.Join(plan, "; "), "idx_sub_status_created") {
+		t.Fatalf("query plan = %v", plan)
+	}
+
+	for _, bad := range []string{"?sort=phone", "?from=01.04.2025", "?user_id=abc"} {
+		if w := env.do(http.MethodGet, "/api/admin/subscriptions"+bad, nil, env.admin()); w.Code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400", bad, w.Code)
+		}
+	}
+}
+
+func TestE2EOrderItemNotes(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	avocado := env.seedProduct("Авокадо", "fruit", 900, "samal3")
+	onion := env.seedProduct("Лук", "veg", 300, "samal3")
+	env.seedUser(909, "samal3")
+
+	confirm := func(items []map[string]any) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+			"telegram_id":    "909",
+			"payment_method": "cash",
+			"items":          items,
+			"delivery":       map[string]any{"type": "pickup", "phone": "+77010000000"},
+		}, nil)
+	}
+
+	if w := confirm([]map[string]any{{"product_id": avocado, "name": "Авокадо", "qty": 1, "unit": "шт", "price": 900,
+		"note": strings.Repeat("я", maxItemNoteLen+1)}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("long note = %d", w.Code)
+	}
+
+	var out struct {
+		OrderID int64 `json:"order_id"`
+	}
+	decode(t, confirm([]map[string]any{
+		{"product_id": avocado, "name": "Авокадо", "qty": 4, "unit": "шт", "price": 900, "note": "  только спелые ", "allow_substitution": true},
+		{"product_id": onion, "name": "Лук", "qty": 2, "unit": "кг", "price": 300},
+	}), &out)
+
+	var detail struct {
+		Status string `json:"status"`
+		Items  []struct {
+			Name              string `json:"name"`
+			Note              string `json:"note"`
+			AllowSubstitution bool   `json:"allow_substitution"`
+		} `json:"items"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/admin/orders/"+strconv.FormatInt(out.OrderID, 10), nil, env.admin()), &detail)
+	if len(detail.Items) != 2 || detail.Items[0].Note != "только спелые" || !detail.Items[0].AllowSubstitution ||
+		detail.Items[1].Note != "" || detail.Items[1].AllowSubstitution {
+		t.Fatalf("order detail = %+v", detail)
+	}
+	if w := env.do(http.MethodGet, "/api/admin/orders/99999", nil, env.admin()); w.Code != http.StatusNotFound {
+		t.Fatalf("missing order = %d", w.Code)
+	}
+
+	// строки под позицией в уведомлении админу
+	var b strings.Builder
+	writeItemPrefs(&b, orderItemIn{Note: "только спелые", AllowSubstitution: true})
+	if got := b.String(); got != "   📝 только спелые\n   🔁 можно заменить похожим\n" {
+		t.Fatalf("item prefs = %q", got)
+	}
+}
+
+func TestE2ECatalogExportImport(t *testing.T) {
+	t.Chdir(t.TempDir()) // фото пишутся в ./uploads
+
+	var photoHits int
+	photos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		photoHits++
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("\x89PNG fake"))
+	}))
+	defer photos.Close()
+
+	src := newTestEnv(t)
+	src.h.cfg.MiniAppUrl = photos.URL
+	src.seedStore("samal3", "Самал-3")
+	src.seedStore("aksai", "Аксай")
+	src.exec(`INSERT INTO categories (slug, name, sort_order) VALUES ('veg', 'Овощи', 1)`)
+	potato := src.seedProduct("Картофель", "veg", 250, "samal3")
+	src.seedProduct("Картофель", "veg", 270, "aksai")
+	src.seedProduct("Укроп", "greens", 100, "")
+	src.exec(`UPDATE products SET photo_path = '/uploads/potato.png', featured = 1, stock_qty = 40 WHERE id = ?`, potato)
+	src.exec(`INSERT INTO product_tags (product_id, tag) VALUES (?, 'хит'), (?, 'местное')`, potato, potato)
+
+	if w := src.do(http.MethodGet, "/api/admin/catalog/export", nil, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin export = %d", w.Code)
+	}
+	w := src.do(http.MethodGet, "/api/admin/catalog/export", nil, src.admin())
+	if w.Code != http.StatusOK {
+		t.Fatalf("export = %d %s", w.Code, w.Body.String())
+	}
+	var doc catalogDoc
+	decode(t, w, &doc)
+	if len(doc.Categories) != 1 || len(doc.Stores) != 2 || len(doc.Products) != 3 {
+		t.Fatalf("export = %d categories, %d stores, %d products", len(doc.Categories), len(doc.Stores), len(doc.Products))
+	}
+
+	dst := newTestEnv(t)
+	type importOut struct {
+		DryRun  bool           `json:"dry_run"`
+		Summary catalogSummary `json:"summary"`
+	}
+	importDoc := func(d catalogDoc, query string) (*httptest.ResponseRecorder, importOut) {
+		w := dst.do(http.MethodPost, "/api/admin/catalog/import"+query, d, dst.admin())
+		var out importOut
+		if w.Code == http.StatusOK {
+			decode(t, w, &out)
+		}
+		return w, out
+	}
+
+	w, out := importDoc(doc, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("import = %d %s", w.Code, w.Body.String())
+	}
+	want := catalogSummary{
+		Categories: catalogCounts{Created: 1},
+		Stores:     catalogCounts{Created: 2},
+		Products:   catalogCounts{Created: 3},
+	}
+	if out.Summary != want {
+		t.Fatalf("first import = %+v", out.Summary)
+	}
+	var (
+		photo    string
+		featured bool
+		stock    int64
+		tags     int
+	)
+	_ = dst.h.db.QueryRow(`SELECT photo_path, featured, stock_qty FROM products WHERE name = 'Картофель' AND store_code = 'samal3'`).
+		Scan(&photo, &featured, &stock)
+	_ = dst.h.db.QueryRow(`SELECT COUNT(1) FROM product_tags`).Scan(&tags)
+	if photo != "/uploads/potato.png" || !featured || stock != 40 || tags != 2 {
+		t.Fatalf("imported potato: photo=%q featured=%v stock=%d tags=%d", photo, featured, stock, tags)
+	}
+	if _, err := os.Stat("uploads/potato.png"); err != nil || photoHits != 1 {
+		t.Fatalf("photo not fetched: %v, hits=%d", err, photoHits)
+	}
+
+	// повторный импорт ничего не меняет и не качает фото заново
+	_, out = importDoc(doc, "")
+	if out.Summary != (catalogSummary{
+		Categories: catalogCounts{Skipped: 1},
+		Stores:     catalogCounts{Skipped: 2},
+		Products:   catalogCounts{Skipped: 3},
+	}) || photoHits != 1 {
+		t.Fatalf("second import = %+v, hits=%d", out.Summary, photoHits)
+	}
+
+	// dry_run считает изменения, но не применяет их
+	doc.Products[0].Price += 10
+	_, out = importDoc(doc, "?dry_run=1")
+	if !out.DryRun || out.Summary.Products != (catalogCounts{Updated: 1, Skipped: 2}) {
+		t.Fatalf("dry run = %+v", out)
+	}
+	var price int64
+	_ = dst.h.db.QueryRow(`SELECT price FROM products WHERE name = ? AND COALESCE(store_code, '') = ?`,
+		doc.Products[0].Name, doc.Products[0].StoreCode).Scan(&price)
+	if price != doc.Products[0].Price-10 {
+		t.Fatalf("dry run changed price to %d", price)
+	}
+
+	// ошибка в одном товаре откатывает весь импорт
+	bad := doc
+	bad.Categories = append(bad.Categories, catalogCategory{Slug: "fruit", Name: "Фрукты"})
+	bad.Products = append(bad.Products, catalogProduct{Name: "Яблоки", StoreCode: "nowhere", CategorySlug: "fruit", Unit: "кг", Price: 500})
+	if w, _ := importDoc(bad, ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad import = %d %s", w.Code, w.Body.String())
+	}
+	var n int
+	_ = dst.h.db.QueryRow(`SELECT COUNT(1) FROM categories WHERE slug = 'fruit'`).Scan(&n)
+	if n != 0 {
+		t.Fatal("failed import left a new category behind")
+	}
+
+	if w, _ := importDoc(catalogDoc{Version: 2}, ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown version = %d", w.Code)
+	}
+}
+
+func TestE2EProductsCache(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	potato := env.seedProduct("Картофель", "veg", 250, "samal3")
+	onion := env.seedProduct("Лук", "veg", 300, "samal3")
+	env.seedUser(555, "samal3")
+	user := map[string]string{"X-Telegram-Id": "555"}
+
+	list := func() (prices map[int64]int64) {
+		t.Helper()
+		w := env.do(http.MethodGet, "/api/products", nil, user)
+		if w.Code != http.StatusOK {
+			t.Fatalf("products = %d %s", w.Code, w.Body.String())
+		}
+		var out []struct {
+			ID    int64 `json:"id"`
+			Price int64 `json:"price"`
+		}
+		decode(t, w, &out)
+		prices = map[int64]int64{}
+		for _, p := range out {
+			prices[p.ID] = p.Price
+		}
+		return prices
+	}
+
+	list()
+	key := repository.ProductsCacheKey("samal3", "")
+	if !env.redis.Exists(key) {
+		t.Fatalf("cache key %s not set", key)
+	}
+	if ttl := env.redis.TTL(key); ttl <= 0 || ttl > repository.ProductsCacheTTL {
+		t.Fatalf("cache ttl = %v", ttl)
+	}
+
+	// прямое изменение в БД не видно, пока кэш жив
+	env.exec(`UPDATE products SET price = 260 WHERE id = ?`, potato)
+	if got := list()[potato]; got != 250 {
+		t.Fatalf("cached price = %d, want 250", got)
+	}
+
+	// изменение из админки сбрасывает кэш
+	if w := env.do(http.MethodPost, "/api/admin/products/delete", map[string]int64{"id": onion}, env.admin()); w.Code != http.StatusOK {
+		t.Fatalf("delete = %d", w.Code)
+	}
+	if env.redis.Exists(key) {
+		t.Fatal("cache not invalidated after delete")
+	}
+	prices := list()
+	if prices[potato] != 260 || len(prices) != 1 {
+		t.Fatalf("after delete = %v", prices)
+	}
+
+	env.redis.FastForward(repository.ProductsCacheTTL + time.Second)
+	if env.redis.Exists(key) {
+		t.Fatal("cache did not expire")
+	}
+
+	// Redis недоступен — читаем напрямую из БД
+	env.redis.Close()
+	if got := list()[potato]; got != 260 {
+		t.Fatalf("price without redis = %d", got)
+	}
+}
+
+// BenchmarkGetProducts сравнивает /api/products с кэшем в Redis и без него.
+func BenchmarkGetProducts(b *testing.B) {
+	for _, cached := range []bool{false, true} {
+		name := "db"
+		if cached {
+			name = "redis"
+		}
+		b.Run(name, func(b *testing.B) {
+			env := newTestEnv(b)
+			env.seedStore("samal3", "Самал-3")
+			for i := range 300 {
+				id := env.seedProduct(fmt.Sprintf("Товар %03d", i), fmt.Sprintf("cat%d", i%10), int64(100+i), "samal3")
+				env.exec(`INSERT INTO product_tags (product_id, tag) VALUES (?, 'хит')`, id)
+			}
+			env.seedUser(555, "samal3")
+			if !cached {
+				env.h.redisClient = nil
+			}
+			user := map[string]string{"X-Telegram-Id": "555"}
+			env.do(http.MethodGet, "/api/products", nil, user) // прогрев кэша
+
+			b.ResetTimer()
+			for range b.N {
+				if w := env.do(http.MethodGet, "/api/products", nil, user); w.Code != http.StatusOK {
+					b.Fatalf("products = %d", w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestE2EStoreManagerOrders(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedStore("aksai", "Аксай")
+	env.seedUser(555, "samal3")
+	order := func(store string, amount int64) int64 {
+		res, err := env.h.db.Exec(`INSERT INTO orders (user_id, store_code, total_amount, status) VALUES (555, ?, ?, 'new')`, store, amount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		return id
+	}
+	own := order("samal3", 1000)
+	order("samal3", 2000)
+	foreign := order("aksai", 3000)
+
+	manager := map[string]string{"X-Telegram-Id": "777"}
+	list := func(headers map[string]string, query string) (int, []int64) {
+		w := env.do(http.MethodGet, "/api/admin/orders"+query, nil, headers)
+		if w.Code != http.StatusOK {
+			return w.Code, nil
+		}
+		var out struct {
+			Items []struct {
+				ID int64 `json:"id"`
+			} `json:"items"`
+			Total int `json:"total"`
+		}
+		decode(t, w, &out)
+		var ids []int64
+		for _, it := range out.Items {
+			ids = append(ids, it.ID)
+		}
+		if out.Total != len(ids) {
+			t.Fatalf("total = %d, items = %d", out.Total, len(ids))
+		}
+		return w.Code, ids
+	}
+
+	if code, _ := list(manager, ""); code != http.StatusForbidden {
+		t.Fatalf("before assignment = %d", code)
+	}
+	if w := env.do(http.MethodPost, "/api/admin/store-managers/add", storeManagerIn{StoreCode: "nowhere", TelegramID: 777}, env.admin()); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown store = %d", w.Code)
+	}
+	if w := env.do(http.MethodPost, "/api/admin/store-managers/add", storeManagerIn{StoreCode: "samal3", TelegramID: 777}, manager); w.Code != http.StatusForbidden {
+		t.Fatalf("manager assigns managers = %d", w.Code)
+	}
+	if w := env.do(http.MethodPost, "/api/admin/store-managers/add", storeManagerIn{StoreCode: "samal3", TelegramID: 777}, env.admin()); w.Code != http.StatusOK {
+		t.Fatalf("add manager = %d %s", w.Code, w.Body.String())
+	}
+
+	if _, ids := list(env.admin(), ""); len(ids) != 3 {
+		t.Fatalf("root sees %v", ids)
+	}
+	if _, ids := list(env.admin(), "?store_code=aksai"); len(ids) != 1 || ids[0] != foreign {
+		t.Fatalf("root aksai = %v", ids)
+	}
+	if _, ids := list(manager, ""); len(ids) != 2 || slices.Contains(ids, foreign) {
+		t.Fatalf("manager sees %v", ids)
+	}
+	if code, _ := list(manager, "?store_code=aksai"); code != http.StatusForbidden {
+		t.Fatalf("manager foreign store = %d", code)
+	}
+
+	if w := env.do(http.MethodGet, "/api/admin/orders/"+strconv.FormatInt(foreign, 10), nil, manager); w.Code != http.StatusNotFound {
+		t.Fatalf("manager foreign order = %d", w.Code)
+	}
+	if w := env.do(http.MethodGet, "/api/admin/orders/"+strconv.FormatInt(own, 10), nil, manager); w.Code != http.StatusOK {
+		t.Fatalf("manager own order = %d", w.Code)
+	}
+	setStatus := func(id int64) int {
+		return env.do(http.MethodPost, "/api/admin/orders/status",
+			map[string]any{"order_id": id, "status": "preparing"}, manager).Code
+	}
+	if code := setStatus(foreign); code != http.StatusNotFound {
+		t.Fatalf("manager foreign status = %d", code)
+	}
+	if code := setStatus(own); code != http.StatusOK {
+		t.Fatalf("manager own status = %d", code)
+	}
+
+	// остальные админские ручки управляющему недоступны
+	if w := env.do(http.MethodGet, "/api/admin/webhooks", nil, manager); w.Code != http.StatusForbidden {
+		t.Fatalf("manager webhooks = %d", w.Code)
+	}
+
+	if w := env.do(http.MethodPost, "/api/admin/store-managers/delete", storeManagerIn{StoreCode: "samal3", TelegramID: 777}, env.admin()); w.Code != http.StatusOK {
+		t.Fatalf("delete manager = %d", w.Code)
+	}
+	if code, _ := list(manager, ""); code != http.StatusForbidden {
+		t.Fatalf("after removal = %d", code)
+	}
+}
+
+func TestE2EOrdersStream(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedUser(555, "samal3")
+	srv := httptest.NewServer(env.srv)
+	defer srv.Close()
+
+	res, err := env.h.db.Exec(`INSERT INTO orders (user_id, store_code, total_amount, status) VALUES (555, 'samal3', 1500, 'new')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orderID, _ := res.LastInsertId()
+
+	if w := env.do(http.MethodGet, "/api/admin/orders/stream", nil, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("anonymous stream = %d", w.Code)
+	}
+
+	connect := func(lastEventID string) (*bufio.Reader, func()) {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet,
+			srv.URL+"/api/admin/orders/stream?telegram_id="+strconv.FormatInt(testAdminID, 10), nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+			t.Fatalf("stream = %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		return bufio.NewReader(resp.Body), func() { cancel(); resp.Body.Close() }
+	}
+	// next читает следующее событие: id, тип и данные
+	next := func(rd *bufio.Reader) (id, typ string, ev orderEvent) {
+		t.Helper()
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read stream: %v", err)
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				typ = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+					t.Fatal(err)
+				}
+				return id, typ, ev
+			}
+		}
+	}
+
+	rd, closeStream := connect("")
+	// подписка регистрируется до первого события в потоке (retry:)
+	if line, _ := rd.ReadString('\n'); line != "retry: 3000\n" {
+		t.Fatalf("first line = %q", line)
+	}
+	if w := env.do(http.MethodPost, "/api/admin/orders/status",
+		map[string]any{"order_id": orderID, "status": "preparing"}, env.admin()); w.Code != http.StatusOK {
+		t.Fatalf("set status = %d", w.Code)
+	}
+	id, typ, ev := next(rd)
+	if typ != webhookOrderStatusChanged || ev.OrderID != orderID || ev.Status != "preparing" || ev.PreviousStatus != "new" || ev.StoreCode != "samal3" {
+		t.Fatalf("event %s = %+v", typ, ev)
+	}
+	closeStream()
+
+	// пока клиента не было — ещё одно событие; переподключение с Last-Event-ID его досылает
+	if w := env.do(http.MethodPost, "/api/admin/orders/status",
+		map[string]any{"order_id": orderID, "status": "delivering"}, env.admin()); w.Code != http.StatusOK {
+		t.Fatalf("set status = %d", w.Code)
+	}
+	rd, closeStream = connect(id)
+	defer closeStream()
+	_, _, ev = next(rd)
+	if ev.Status != "delivering" {
+		t.Fatalf("replayed = %+v", ev)
+	}
+}
+
+func TestE2ECORSAllowlist(t *testing.T) {
+	env := newTestEnv(t)
+	env.h.cfg.CORSOrigins = []string{"https://agro.example"}
+
+	w := env.do(http.MethodGet, "/api/stores", nil, map[string]string{"Origin": "https://agro.example"})
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://agro.example" {
+		t.Fatalf("allowed origin = %q", got)
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "true" || w.Header().Get("Vary") != "Origin" {
+		t.Fatalf("headers = %v", w.Header())
+	}
+
+	w = env.do(http.MethodGet, "/api/stores", nil, map[string]string{"Origin": "https://evil.example"})
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("foreign origin = %d %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if w := env.do(http.MethodOptions, "/api/orders/create", nil, map[string]string{"Origin": "https://evil.example"}); w.Code != http.StatusForbidden {
+		t.Fatalf("foreign preflight = %d", w.Code)
+	}
+	w = env.do(http.MethodOptions, "/api/orders/create", nil, map[string]string{"Origin": "https://agro.example"})
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), "X-Telegram-Id") {
+		t.Fatalf("preflight = %d %v", w.Code, w.Header())
+	}
+
+	// "*" — любой источник, но без credentials
+	env.h.cfg.CORSOrigins = []string{"*"}
+	w = env.do(http.MethodGet, "/api/stores", nil, map[string]string{"Origin": "http://localhost:5173"})
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatalf("wildcard headers = %v", w.Header())
+	}
+}
+
+func TestE2EUserPhones(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.seedStore("samal3", "Самал-3")
+	p := env.seedProduct("Картофель", "vegetables", 250, "samal3")
+	env.seedUser(555, "samal3")
+
+	confirm := func(qty int, phone string) {
+		t.Helper()
+		w := env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+			"telegram_id": "555",
+			"items":       []map[string]any{{"product_id": p, "name": "Картофель", "qty": qty, "unit": "кг", "price": 250}},
+			"delivery":    map[string]any{"type": "pickup", "phone": phone},
+		}, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("confirm = %d %s", w.Code, w.Body.String())
+		}
+	}
+	userPhone := func() string {
+		var phone sql.NullString
+		_ = env.h.db.QueryRow(`SELECT phone FROM users WHERE user_id = 555`).Scan(&phone)
+		return phone.String
+	}
+	history := func() map[string]int {
+		out := map[string]int{}
+		rows, err := env.h.db.Query(`SELECT phone, uses FROM user_phones WHERE user_id = 555`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				phone string
+				uses  int
+			)
+			_ = rows.Scan(&phone, &uses)
+			out[phone] = uses
+		}
+		return out
+	}
+
+	// телефон доставки попадает в пустую карточку пользователя в нормальном виде
+	confirm(1, "8 (701) 111-22-33")
+	if got := userPhone(); got != "+77011112233" {
+		t.Fatalf("users.phone after order = %q", got)
+	}
+
+	// номер Kaspi из заявки на подписку — главный, следующий заказ с другим номером его не затирает
+	if w := env.do(http.MethodPost, "/api/subscribe/request-invoice", map[string]any{
+		"telegram_id": "555", "phone": "+77029998877",
+	}, nil); w.Code != http.StatusOK {
+		t.Fatalf("request invoice = %d %s", w.Code, w.Body.String())
+	}
+	confirm(2, "+7 701 111 22 33")
+	if got := userPhone(); got != "+77029998877" {
+		t.Fatalf("users.phone after second order = %q", got)
+	}
+	if h := history(); len(h) != 2 || h["+77011112233"] != 2 || h["+77029998877"] != 1 {
+		t.Fatalf("phone history = %v", h)
+	}
+
+	// контакт из Telegram: свой — сохраняется в историю, чужой — нет
+	share := func(owner int64, phone string) string {
+		t.Helper()
+		before := len(env.sender.MessagesTo(555))
+		env.h.DefaultHandler(ctx, nil, &models.Update{Message: &models.Message{
+			From: &models.User{ID: 555}, Chat: models.Chat{ID: 555},
+			Contact: &models.Contact{PhoneNumber: phone, UserID: owner},
+		}})
+		msgs := env.sender.MessagesTo(555)
+		if len(msgs) != before+1 {
+			t.Fatalf("contact replies = %d", len(msgs)-before)
+		}
+		return msgs[before]
+	}
+	if reply := share(555, "77051234567"); !strings.Contains(reply, "+77051234567") {
+		t.Fatalf("own contact reply = %q", reply)
+	}
+	if st, err := env.h.redisClient.GetUserState(ctx, 555); err != nil || st == nil || st.Contact != "+77051234567" {
+		t.Fatalf("user state after contact = %+v, %v", st, err)
+	}
+	if reply := share(777, "77060000000"); !strings.Contains(reply, "свой номер") {
+		t.Fatalf("foreign contact reply = %q", reply)
+	}
+	if h := history(); len(h) != 3 || h["+77051234567"] != 1 {
+		t.Fatalf("phone history after contacts = %v", h)
+	}
+	if got := userPhone(); got != "+77029998877" {
+		t.Fatalf("users.phone after contact = %q", got)
+	}
+}
+
+func TestE2EInlineSearch(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.seedStore("samal3", "Самал-3")
+	env.seedStore("aksai", "Аксай")
+	local := env.seedProduct("Картофель молодой", "vegetables", 250, "samal3")
+	env.seedProduct("Картофель красный", "vegetables", 270, "aksai")
+	env.seedProduct("Морковь", "vegetables", 180, "samal3")
+	env.seedUser(555, "samal3")
+
+	search := func(query string) *bot.AnswerInlineQueryParams {
+		t.Helper()
+		env.h.InlineQueryHandler(ctx, nil, &models.Update{InlineQuery: &models.InlineQuery{
+			ID: "q1", From: &models.User{ID: 555}, Query: query,
+		}})
+		return env.sender.Inline[len(env.sender.Inline)-1]
+	}
+
+	// регистр не важен, товар другой точки не показываем
+	res := search("  КАРТ ")
+	if len(res.Results) != 1 || !res.IsPersonal {
+		t.Fatalf("results = %+v", res)
+	}
+	a := res.Results[0].(*models.InlineQueryResultArticle)
+	if a.ID != strconv.FormatInt(local, 10) || a.Title != "Картофель молодой" || !strings.Contains(a.Description, formatMoney(250)) {
+		t.Fatalf("article = %+v", a)
+	}
+	if res.Button == nil || !strings.HasSuffix(res.Button.WebApp.URL, "/catalog?q=%D0%BA%D0%B0%D1%80%D1%82") {
+		t.Fatalf("button = %+v", res.Button)
+	}
+	if !env.redis.Exists(repository.InlineSearchCacheKey("samal3", "карт")) {
+		t.Fatal("inline results are not cached")
+	}
+
+	// одна буква — без поиска, только кнопка каталога
+	if res := search("к"); len(res.Results) != 0 || res.Button == nil {
+		t.Fatalf("short query = %+v", res)
+	}
+}
+
+func TestE2EAdminListUsers(t *testing.T) {
+	env := newTestEnv(t)
+	env.exec(`INSERT INTO users (id, user_id, nickname, phone, sub_status, sub_until, selected_store, created_at) VALUES
+		('u1', 901, 'farmer',  '+77010000901', 'active',   '2025-05-01 00:00:00', 'samal3', '2025-03-01 10:00:00'),
+		('u2', 902, 'baker',   NULL,           'inactive', NULL,                  NULL,     '2025-03-02 10:00:00'),
+		('u3', 903, 'farm_50', '+77020000903', 'active',   '2025-06-01 00:00:00', NULL,     '2025-03-03 10:00:00')`)
+	env.exec(`INSERT INTO user_phones (user_id, phone, source) VALUES (902, '+77050000902', 'order')`)
+	env.exec(`INSERT INTO orders (user_id, total_amount, status) VALUES (901, 1000, 'done'), (901, 500, 'new')`)
+
+	type page struct {
+		Items []struct {
+			UserID     int64   `json:"user_id"`
+			Nickname   string  `json:"nickname"`
+			Phone      string  `json:"phone"`
+			SubStatus  string  `json:"sub_status"`
+			SubUntil   *string `json:"sub_until"`
+			OrderCount int64   `json:"order_count"`
+		} `json:"items"`
+		Total int64 `json:"total"`
+	}
+	list := func(query string) page {
+		t.Helper()
+		w := env.do(http.MethodGet, "/api/admin/users"+query, nil, env.admin())
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d %s", query, w.Code, w.Body.String())
+		}
+		var p page
+		decode(t, w, &p)
+		return p
+	}
+	ids := func(p page) []int64 {
+		var out []int64
+		for _, it := range p.Items {
+			out = append(out, it.UserID)
+		}
+		return out
+	}
+
+	if w := env.do(http.MethodGet, "/api/admin/users", nil, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("without admin = %d", w.Code)
+	}
+
+	all := list("")
+	if all.Total != 3 || !slices.Equal(ids(all), []int64{903, 902, 901}) {
+		t.Fatalf("all = %+v", all)
+	}
+	if u := all.Items[2]; u.OrderCount != 2 || u.SubUntil == nil || u.Phone != "+77010000901" {
+		t.Fatalf("farmer = %+v", u)
+	}
+
+	// ник; «_» — буквально, а не любой символ
+	if p := list("?q=farm_"); p.Total != 1 || p.Items[0].UserID != 903 {
+		t.Fatalf("q=farm_ = %+v", p)
+	}
+	// телефон в другом формате и номер из истории
+	if p := list("?q=" + url.QueryEscape("8 (701) 000-09-01")); p.Total != 1 || p.Items[0].UserID != 901 {
+		t.Fatalf("q=phone = %+v", p)
+	}
+	if p := list("?q=0500009"); p.Total != 1 || p.Items[0].UserID != 902 {
+		t.Fatalf("q=history phone = %+v", p)
+	}
+
+	// статус и пагинация: total — по всему фильтру
+	p := list("?status=active&limit=1&offset=1")
+	if p.Total != 2 || !slices.Equal(ids(p), []int64{901}) {
+		t.Fatalf("status+page = %+v", p)
+	}
+}
+
+func TestE2EResendPayment(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.h.cfg.KaspiPayURL = "https://pay.kaspi.kz/pay/test"
+	env.seedUser(555, "")
+	env.exec(`INSERT INTO orders (id, user_id, total_amount, status, payment_method) VALUES
+		(1, 555, 750, 'new', 'kaspi_link'),
+		(2, 555, 500, 'paid', 'kaspi_link'),
+		(3, 555, 300, 'rejected', 'kaspi_transfer'),
+		(4, 555, 200, 'cancelled', 'cash')`)
+	env.exec(`INSERT INTO order_items (order_id, product_id, name, unit, qty, price, amount) VALUES (1, 1, 'Картофель', 'кг', 3, 250, 750)`)
+
+	resend := func(orderID int64) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, "/api/admin/orders/resend-payment", map[string]any{"order_id": orderID}, env.admin())
+	}
+	if w := env.do(http.MethodPost, "/api/admin/orders/resend-payment", map[string]any{"order_id": 1}, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("without admin = %d", w.Code)
+	}
+	if w := resend(1); w.Code != http.StatusOK {
+		t.Fatalf("resend kaspi link = %d %s", w.Code, w.Body.String())
+	}
+	// тот же чек, что и после оформления, ссылка — с суммой заказа
+	msg := env.sender.Messages[len(env.sender.Messages)-1]
+	kb := msg.ReplyMarkup.(*models.InlineKeyboardMarkup)
+	if !strings.Contains(msg.Text, "Заказ №1 принят") || kb.InlineKeyboard[0][0].URL != "https://pay.kaspi.kz/pay/test?amount=750" {
+		t.Fatalf("payment message = %q %+v", msg.Text, kb)
+	}
+	st, err := env.h.redisClient.GetUserState(ctx, 555)
+	if err != nil || st == nil || st.State != stateWaitingPayment {
+		t.Fatalf("user state = %+v, err = %v", st, err)
+	}
+	for _, id := range []int64{2, 4} {
+		if w := resend(id); w.Code != http.StatusConflict {
+			t.Fatalf("order %d = %d, want 409", id, w.Code)
+		}
+	}
+	if w := resend(99); w.Code != http.StatusNotFound {
+		t.Fatalf("missing order = %d", w.Code)
+	}
+
+	command := func(from int64, text string) string {
+		t.Helper()
+		env.h.ResendPaymentCommandHandler(ctx, nil, &models.Update{Message: &models.Message{
+			From: &models.User{ID: from}, Chat: models.Chat{ID: from}, Text: text,
+		}})
+		msgs := env.sender.MessagesTo(from)
+		return msgs[len(msgs)-1]
+	}
+	if reply := command(testAdminID, "/resend 2"); !strings.Contains(reply, "оплата не требуется") {
+		t.Fatalf("/resend paid = %q", reply)
+	}
+	if reply := command(testAdminID, "/resend №3"); !strings.Contains(reply, "отправлена покупателю") {
+		t.Fatalf("/resend rejected = %q", reply)
+	}
+	if last := env.sender.MessagesTo(555); !strings.Contains(last[len(last)-1], "Kaspi Gold") {
+		t.Fatalf("transfer requisites = %q", last[len(last)-1])
+	}
+	if reply := command(555, "/resend 1"); !strings.Contains(reply, "только администратору") {
+		t.Fatalf("/resend by user = %q", reply)
+	}
+
+	// /pay у покупателя — последний неоплаченный заказ (№3, оплата отклонена)
+	env.h.PayCommandHandler(ctx, nil, &models.Update{Message: &models.Message{
+		From: &models.User{ID: 555}, Chat: models.Chat{ID: 555}, Text: "/pay",
+	}})
+	if last := env.sender.MessagesTo(555); !strings.Contains(last[len(last)-1], "Заказ №3 принят") {
+		t.Fatalf("/pay = %q", last[len(last)-1])
+	}
+	env.h.PayCommandHandler(ctx, nil, &models.Update{Message: &models.Message{
+		From: &models.User{ID: 556}, Chat: models.Chat{ID: 556}, Text: "/pay",
+	}})
+	if msgs := env.sender.MessagesTo(556); len(msgs) != 1 || !strings.Contains(msgs[0], "Неоплаченных заказов нет") {
+		t.Fatalf("/pay without orders = %q", msgs)
+	}
+}
+
+func TestE2EOrderStatusMessages(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedUser(555, "")
+	env.exec(`INSERT INTO orders (id, user_id, total_amount, status) VALUES (7, 555, 1000, 'paid'), (8, 555, 500, 'paid')`)
+
+	// тексты заведены для всех статусов
+	w := env.do(http.MethodGet, "/api/admin/order-status-messages", nil, env.admin())
+	var list struct {
+		Items []orderStatusMessageOut `json:"items"`
+	}
+	decode(t, w, &list)
+	if len(list.Items) != len(orderStatuses) {
+		t.Fatalf("items = %+v", list.Items)
+	}
+	for _, m := range list.Items {
+		if m.UserMessageRU == "" || m.UserMessageKZ == "" {
+			t.Fatalf("status %s is not seeded: %+v", m.Status, m)
+		}
+	}
+
+	set := func(body map[string]any) int {
+		return env.do(http.MethodPost, "/api/admin/order-status-messages", body, env.admin()).Code
+	}
+	if code := set(map[string]any{"status": "unknown", "user_message_ru": "x"}); code != http.StatusBadRequest {
+		t.Fatalf("unknown status = %d", code)
+	}
+	if code := set(map[string]any{"status": "preparing", "user_message_ru": "🥕 Собираем ваш заказ №{order_id}"}); code != http.StatusOK {
+		t.Fatalf("set ru = %d", code)
+	}
+
+	setStatus := func(orderID int64) string {
+		t.Helper()
+		if w := env.do(http.MethodPost, "/api/admin/orders/status",
+			map[string]any{"order_id": orderID, "status": "preparing"}, env.admin()); w.Code != http.StatusOK {
+			t.Fatalf("set status = %d %s", w.Code, w.Body.String())
+		}
+		msgs := env.sender.MessagesTo(555)
+		return msgs[len(msgs)-1]
+	}
+	if got := setStatus(7); got != "🥕 Собираем ваш заказ №7" {
+		t.Fatalf("ru message = %q", got)
+	}
+
+	// LOCALE=kk — казахский текст; ru-текст при изменении kz остаётся прежним
+	if code := set(map[string]any{"status": "preparing", "user_message_kz": "🥕 №{order_id} тапсырысыңызды жинап жатырмыз"}); code != http.StatusOK {
+		t.Fatalf("set kz = %d", code)
+	}
+	env.h.cfg.Locale = "kk"
+	if got := setStatus(8); got != "🥕 №8 тапсырысыңызды жинап жатырмыз" {
+		t.Fatalf("kz message = %q", got)
+	}
+	var ru string
+	_ = env.h.db.QueryRow(`SELECT user_message_ru FROM order_status_messages WHERE status = 'preparing'`).Scan(&ru)
+	if ru != "🥕 Собираем ваш заказ №{order_id}" {
+		t.Fatalf("ru after kz update = %q", ru)
+	}
+}
+
+func TestE2EPaymentDecisionByAdmin(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.seedUser(555, "")
+	env.exec(`INSERT INTO orders (id, user_id, total_amount, status, payment_method) VALUES (1, 555, 750, 'checking', 'kaspi_link')`)
+	env.exec(`INSERT INTO subscriptions (id, user_id, status) VALUES (1, 555, 'pending')`)
+
+	alice := models.User{ID: 901, Username: "alice"}
+	bob := models.User{ID: 902, FirstName: "Боб"}
+	press := func(from models.User, data string) {
+		env.h.PaymentCallbackHandler(ctx, nil, &models.Update{CallbackQuery: &models.CallbackQuery{
+			ID: fmt.Sprintf("cb-%d", from.ID), From: from, Data: data,
+			Message: models.MaybeInaccessibleMessage{Message: &models.Message{
+				ID: 42, Chat: models.Chat{ID: testAdminID}, Caption: "💳 Подтверждение оплаты по заказу №1",
+			}},
+		}})
+	}
+	answers := func(from models.User) []string {
+		var out []string
+		for _, c := range env.sender.Callbacks {
+			if c.CallbackQueryID == fmt.Sprintf("cb-%d", from.ID) {
+				out = append(out, c.Text)
+			}
+		}
+		return out
+	}
+
+	// два админа жмут одновременно: побеждает только первый
+	var wg sync.WaitGroup
+	for _, u := range []models.User{alice, bob} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			press(u, "pay_ok:1:555")
+		}()
+	}
+	wg.Wait()
+
+	winner, loser := alice, bob
+	if a := answers(bob); len(a) == 1 && a[0] == "Оплата заказа подтверждена ✅" {
+		winner, loser = bob, alice
+	}
+	name := paymentDeciderOf(winner).Name
+	if a := answers(winner); len(a) != 1 || a[0] != "Оплата заказа подтверждена ✅" {
+		t.Fatalf("winner answers = %q", a)
+	}
+	if a := answers(loser); len(a) != 1 || !strings.Contains(a[0], "Уже обработано другим админом ("+name+")") {
+		t.Fatalf("loser answers = %q", a)
+	}
+	if msgs := env.sender.MessagesTo(555); len(msgs) != 1 {
+		t.Fatalf("user notified %d times: %q", len(msgs), msgs)
+	}
+	if len(env.sender.Captions) != 1 || !strings.HasSuffix(env.sender.Captions[0].Caption, "✅ Подтвердил: "+name) {
+		t.Fatalf("captions = %+v", env.sender.Captions)
+	}
+
+	var (
+		by     int64
+		action string
+	)
+	_ = env.h.db.QueryRow(`SELECT payment_decided_by FROM orders WHERE id = 1`).Scan(&by)
+	_ = env.h.db.QueryRow(`SELECT action FROM audit_log WHERE admin_id = ?`, winner.ID).Scan(&action)
+	if by != winner.ID || action != "order.payment_paid" {
+		t.Fatalf("decided_by = %d, audit action = %q", by, action)
+	}
+
+	// решение видно в админке
+	var one struct {
+		Status          string              `json:"status"`
+		PaymentDecision *paymentDecisionOut `json:"payment_decision"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/admin/orders/1", nil, env.admin()), &one)
+	if one.Status != "paid" || one.PaymentDecision == nil || one.PaymentDecision.AdminID != winner.ID || one.PaymentDecision.AdminName != name {
+		t.Fatalf("order = %+v", one)
+	}
+	var list struct {
+		Items []struct {
+			PaymentDecision *paymentDecisionOut `json:"payment_decision"`
+		} `json:"items"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/admin/orders", nil, env.admin()), &list)
+	if len(list.Items) != 1 || list.Items[0].PaymentDecision == nil || list.Items[0].PaymentDecision.AdminName != name {
+		t.Fatalf("orders list = %+v", list)
+	}
+
+	// подписку отклонил Боб — подтверждение Алисы уже не проходит
+	press(bob, "sub_reject:1:555")
+	press(alice, "sub_ok:1:555")
+	var subStatus string
+	_ = env.h.db.QueryRow(`SELECT status FROM subscriptions WHERE id = 1`).Scan(&subStatus)
+	if a := answers(alice); subStatus != "rejected" || !strings.Contains(a[len(a)-1], "Уже обработано другим админом (Боб)") {
+		t.Fatalf("subscription = %q, alice answers = %q", subStatus, a)
+	}
+	if c := env.sender.Captions[len(env.sender.Captions)-1]; !strings.HasSuffix(c.Caption, "❌ Отклонил: Боб") {
+		t.Fatalf("subscription caption = %q", c.Caption)
+	}
+}
+
+func TestE2ESubscriberPrices(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	potato := env.seedProduct("Картофель", "veg", 250, "samal3")
+	onion := env.seedProduct("Лук", "veg", 300, "samal3")
+	honey := env.seedProduct("Мёд", "other", 2000, "samal3")
+	env.exec(`UPDATE products SET retail_price = 320 WHERE id = ?`, potato)
+	env.exec(`UPDATE products SET subscriber_only = 1 WHERE id = ?`, honey)
+	env.seedUser(555, "samal3")
+	env.seedUser(556, "samal3")
+	env.exec(`UPDATE users SET sub_status = 'active', sub_until = ? WHERE user_id = 556`, env.h.clock.Now().Add(72*time.Hour))
+
+	type product struct {
+		Price       int64  `json:"price"`
+		RetailPrice *int64 `json:"retail_price"`
+		Locked      bool   `json:"locked"`
+		LockedText  string `json:"locked_text"`
+	}
+	list := func(tgID string) map[int64]product {
+		t.Helper()
+		var out []struct {
+			ID int64 `json:"id"`
+			product
+		}
+		decode(t, env.do(http.MethodGet, "/api/products", nil, map[string]string{"X-Telegram-Id": tgID}), &out)
+		m := map[int64]product{}
+		for _, p := range out {
+			m[p.ID] = p.product
+		}
+		return m
+	}
+
+	// без подписки: розничная цена, у товара для подписчиков цены нет
+	guest := list("555")
+	if p := guest[potato]; p.Price != 320 || !p.Locked || p.LockedText != lockedPriceText {
+		t.Fatalf("guest potato = %+v", p)
+	}
+	if p := guest[onion]; p.Price != 300 || p.Locked {
+		t.Fatalf("guest onion = %+v", p)
+	}
+	if p := guest[honey]; p.Price != 0 || !p.Locked {
+		t.Fatalf("guest honey = %+v", p)
+	}
+
+	// подписчик видит оптовые цены — и кэш у него свой
+	sub := list("556")
+	if p := sub[potato]; p.Price != 250 || p.Locked || p.RetailPrice == nil || *p.RetailPrice != 320 {
+		t.Fatalf("subscriber potato = %+v", p)
+	}
+	if p := sub[honey]; p.Price != 2000 || p.Locked {
+		t.Fatalf("subscriber honey = %+v", p)
+	}
+	if !env.redis.Exists(repository.SubscriberProductsCacheKey("samal3", "")) || !env.redis.Exists(repository.ProductsCacheKey("samal3", "")) {
+		t.Fatal("catalog views are not cached separately")
+	}
+	if p := list("555")[potato]; p.Price != 320 {
+		t.Fatalf("cached guest potato = %+v", p)
+	}
+
+	// в inline-карточках оптовых цен нет
+	env.h.InlineQueryHandler(context.Background(), nil, &models.Update{InlineQuery: &models.InlineQuery{
+		ID: "q1", From: &models.User{ID: 556}, Query: "мёд",
+	}})
+	a := env.sender.Inline[0].Results[0].(*models.InlineQueryResultArticle)
+	if a.Description != lockedPriceText || strings.Contains(a.InputMessageContent.(*models.InputTextMessageContent).MessageText, formatMoney(2000)) {
+		t.Fatalf("inline honey = %q / %+v", a.Description, a.InputMessageContent)
+	}
+
+	// карусель и карточка товара — те же правила, история оптовых цен гостю закрыта
+	env.exec(`UPDATE products SET featured = 1 WHERE id IN (?, ?)`, potato, honey)
+	env.exec(`INSERT INTO price_feed (product_id, price_date, price) VALUES (?, DATE('now'), 250)`, potato)
+	featured := func(tgID string) map[int64]product {
+		t.Helper()
+		var out []struct {
+			ID int64 `json:"id"`
+			product
+		}
+		decode(t, env.do(http.MethodGet, "/api/products/featured", nil, map[string]string{"X-Telegram-Id": tgID}), &out)
+		m := map[int64]product{}
+		for _, p := range out {
+			m[p.ID] = p.product
+		}
+		return m
+	}
+	if f := featured("555"); f[potato].Price != 320 || !f[potato].Locked || f[honey].Price != 0 || !f[honey].Locked {
+		t.Fatalf("guest featured = %+v", f)
+	}
+	if f := featured("556"); f[potato].Price != 250 || f[honey].Price != 2000 || f[honey].Locked {
+		t.Fatalf("subscriber featured = %+v", f)
+	}
+	type card struct {
+		product
+		History []struct{ Price int64 } `json:"price_history_7d"`
+	}
+	var c card
+	decode(t, env.do(http.MethodGet, fmt.Sprintf("/api/products/%d", potato), nil, map[string]string{"X-Telegram-Id": "555"}), &c)
+	if c.Price != 320 || !c.Locked || len(c.History) != 0 {
+		t.Fatalf("guest card = %+v", c)
+	}
+	decode(t, env.do(http.MethodGet, fmt.Sprintf("/api/products/%d", honey), nil, nil), &c)
+	if c.Price != 0 || !c.Locked {
+		t.Fatalf("anonymous honey card = %+v", c)
+	}
+	c = card{}
+	decode(t, env.do(http.MethodGet, fmt.Sprintf("/api/products/%d", potato), nil, map[string]string{"X-Telegram-Id": "556"}), &c)
+	if c.Price != 250 || c.Locked || len(c.History) != 1 {
+		t.Fatalf("subscriber card = %+v", c)
+	}
+
+	// заказ: цены пересчитывает сервер, цене из корзины не верим
+	order := func(path, tgID string, items ...map[string]any) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, path, map[string]any{
+			"telegram_id": tgID, "payment_method": "kaspi_transfer",
+			"items":    items,
+			"delivery": map[string]any{"type": "pickup", "phone": "+77010000000"},
+		}, nil)
+	}
+	item := func(id, price int64) map[string]any {
+		return map[string]any{"product_id": id, "name": "x", "qty": 2, "unit": "кг", "price": price}
+	}
+	var totals struct {
+		GoodsTotal int64 `json:"goods_total"`
+	}
+	// гость прислал оптовую цену картофеля — платит розничную
+	decode(t, order("/api/orders/quote", "555", item(potato, 250)), &totals)
+	if totals.GoodsTotal != 640 {
+		t.Fatalf("guest quote = %d, want retail 640", totals.GoodsTotal)
+	}
+	decode(t, order("/api/orders/confirm", "555", item(potato, 250)), &totals)
+	if totals.GoodsTotal != 640 {
+		t.Fatalf("guest confirm = %d, want retail 640", totals.GoodsTotal)
+	}
+	// товар только для подписчиков гостю не продаём — ни за 0 ₸, ни за цену из корзины
+	for _, path := range []string{"/api/orders/quote", "/api/orders/confirm", "/api/orders/create"} {
+		w := order(path, "555", item(honey, 0))
+		var out struct {
+			Code string `json:"code"`
+		}
+		decode(t, w, &out)
+		if w.Code != http.StatusForbidden || out.Code != "subscription_required" {
+			t.Fatalf("guest %s honey = %d %s", path, w.Code, w.Body.String())
+		}
+	}
+	// подписчик — оптовые цены, даже если корзина прислала другие
+	decode(t, order("/api/orders/quote", "556", item(potato, 320), item(honey, 1)), &totals)
+	if totals.GoodsTotal != 2*250+2*2000 {
+		t.Fatalf("subscriber quote = %d", totals.GoodsTotal)
+	}
+}
+
+func TestE2ECategoryTree(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.exec(`INSERT INTO categories (slug, name, sort_order, parent_slug) VALUES
+		('veg', 'Овощи', 1, NULL),
+		('fruits', 'Фрукты', 2, NULL),
+		('greens', 'Зелень', 2, 'veg'),
+		('roots', 'Корнеплоды', 1, 'veg')`)
+	potato := env.seedProduct("Картофель", "roots", 250, "samal3")
+	dill := env.seedProduct("Укроп", "greens", 100, "samal3")
+	env.seedProduct("Яблоко", "fruits", 400, "samal3")
+
+	type node struct {
+		Slug     string `json:"slug"`
+		Name     string `json:"name"`
+		Children []node `json:"children"`
+	}
+	var tree []node
+	decode(t, env.do(http.MethodGet, "/api/categories", nil, nil), &tree)
+	if len(tree) != 2 || tree[0].Slug != "veg" || len(tree[0].Children) != 2 ||
+		tree[0].Children[0].Slug != "roots" || tree[0].Children[1].Slug != "greens" || len(tree[1].Children) != 0 {
+		t.Fatalf("tree = %+v", tree)
+	}
+
+	ids := func(query string) []int64 {
+		t.Helper()
+		var out []struct {
+			ID int64 `json:"id"`
+		}
+		decode(t, env.do(http.MethodGet, "/api/products"+query, nil, nil), &out)
+		var got []int64
+		for _, p := range out {
+			got = append(got, p.ID)
+		}
+		slices.Sort(got)
+		return got
+	}
+	if got := ids("?category=veg"); !slices.Equal(got, []int64{potato, dill}) {
+		t.Fatalf("veg = %v, want potato and dill", got)
+	}
+	if got := ids("?category=greens"); !slices.Equal(got, []int64{dill}) {
+		t.Fatalf("greens = %v", got)
+	}
+	if got := ids(""); len(got) != 3 {
+		t.Fatalf("all products = %v", got)
+	}
+
+	// товар — только в категорию без подкатегорий
+	add := func(category string) *httptest.ResponseRecorder {
+		body, ct := env.multipart(map[string]string{
+			"name": "Морковь", "category": category, "unit": "кг", "price": "300", "store_code": "samal3",
+		})
+		hdr := env.admin()
+		hdr["Content-Type"] = ct
+		return env.do(http.MethodPost, "/api/admin/products/add", body, hdr)
+	}
+	if w := add("veg"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "sub-categories") {
+		t.Fatalf("add to parent category = %d %s", w.Code, w.Body.String())
+	}
+	if w := add("roots"); w.Code != http.StatusOK {
+		t.Fatalf("add to leaf category = %d %s", w.Code, w.Body.String())
+	}
+
+	// импорт каталога не даёт вложить глубже двух уровней
+	doc := catalogDoc{Version: catalogVersion, Categories: []catalogCategory{
+		{Slug: "baby", Name: "Мини-морковь", ParentSlug: "roots"},
+	}}
+	if w := env.do(http.MethodPost, "/api/admin/catalog/import", doc, env.admin()); w.Code != http.StatusBadRequest {
+		t.Fatalf("three-level import = %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestE2EPromotions(t *testing.T) {
+	env := newTestEnv(t)
+	clock := &fakeClock{t: time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC)}
+	env.h.SetClock(clock)
+	env.seedStore("samal3", "Самал-3")
+	env.exec(`INSERT INTO categories (slug, name, sort_order, parent_slug) VALUES
+		('veg', 'Овощи', 1, NULL),
+		('roots', 'Корнеплоды', 1, 'veg')`)
+	tomato := env.seedProduct("Томаты", "tomatoes", 600, "samal3")
+	potato := env.seedProduct("Картофель", "roots", 250, "samal3")
+	apple := env.seedProduct("Яблоко", "fruits", 400, "samal3")
+	env.seedUser(701, "samal3")
+
+	add := func(body map[string]any) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, "/api/admin/promotions/add", body, env.admin())
+	}
+	if w := add(map[string]any{"product_id": tomato, "category": "veg", "promo_price": 450, "ends_at": "2025-04-13"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("product and category together = %d %s", w.Code, w.Body.String())
+	}
+	if w := add(map[string]any{"product_id": tomato, "promo_price": 450, "starts_at": "2025-04-13", "ends_at": "2025-04-12"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("ends before start = %d %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	decode(t, add(map[string]any{"product_id": tomato, "promo_price": 450, "badge": "-25%", "ends_at": "2025-04-13T00:00:00Z"}), &created)
+	if w := add(map[string]any{"category": "veg", "promo_price": 200, "badge": "Неделя овощей",
+		"starts_at": "2025-04-10T00:00:00Z", "ends_at": "2025-04-11T00:00:00Z"}); w.Code != http.StatusOK {
+		t.Fatalf("add category promo = %d %s", w.Code, w.Body.String())
+	}
+	if w := add(map[string]any{"product_id": apple, "promo_price": 300,
+		"starts_at": "2025-05-01T00:00:00Z", "ends_at": "2025-05-02T00:00:00Z"}); w.Code != http.StatusOK {
+		t.Fatalf("add scheduled promo = %d %s", w.Code, w.Body.String())
+	}
+
+	// каталог: промо-цена и ярлык только у действующих акций,
+	// акция на «Овощи» действует и на подкатегорию «Корнеплоды»
+	var products []productOut
+	decode(t, env.do(http.MethodGet, "/api/products", nil, nil), &products)
+	byID := map[int64]productOut{}
+	for _, p := range products {
+		byID[p.ID] = p
+	}
+	if p := byID[tomato]; p.PromoPrice == nil || *p.PromoPrice != 450 || p.Badge != "-25%" || p.Price != 600 {
+		t.Fatalf("tomato = %+v", p)
+	}
+	if p := byID[potato]; p.PromoPrice == nil || *p.PromoPrice != 200 || p.Badge != "Неделя овощей" {
+		t.Fatalf("potato = %+v", p)
+	}
+	if p := byID[apple]; p.PromoPrice != nil || p.Badge != "" {
+		t.Fatalf("apple promo is not live yet: %+v", p)
+	}
+
+	quote := func(items ...map[string]any) int64 {
+		t.Helper()
+		var q struct {
+			GoodsTotal int64 `json:"goods_total"`
+		}
+		decode(t, env.do(http.MethodPost, "/api/orders/quote", map[string]any{
+			"telegram_id": 701, "items": items, "delivery": map[string]any{"type": "pickup"},
+		}, nil), &q)
+		return q.GoodsTotal
+	}
+	item := func(id int64, price int64) map[string]any {
+		return map[string]any{"product_id": id, "name": "x", "qty": 1, "unit": "кг", "price": price}
+	}
+	// в окне акции — промо-цена, что бы ни прислал клиент
+	if got := quote(item(tomato, 600), item(potato, 250), item(apple, 400)); got != 450+200+400 {
+		t.Fatalf("goods_total in window = %d", got)
+	}
+	// акция на овощи закончилась — картофель снова по базовой цене, даже если в корзине осталась промо
+	clock.t = time.Date(2025, 4, 11, 9, 0, 0, 0, time.UTC)
+	if got := quote(item(tomato, 450), item(potato, 200)); got != 450+250 {
+		t.Fatalf("goods_total after veg promo = %d", got)
+	}
+	w := env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+		"telegram_id": 701, "items": []map[string]any{item(tomato, 1)}, "delivery": map[string]any{"type": "pickup"},
+	}, nil)
+	var confirmed struct {
+		Total int64 `json:"total"`
+	}
+	decode(t, w, &confirmed)
+	if confirmed.Total != 450 {
+		t.Fatalf("confirmed total = %d, want promo price", confirmed.Total)
+	}
+
+	// админка: статусы и блок для сводки в канал
+	var list struct {
+		Items []struct {
+			ID     int64  `json:"id"`
+			Status string `json:"status"`
+		} `json:"items"`
+		Digest string `json:"digest"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/admin/promotions", nil, env.admin()), &list)
+	statuses := map[string]int{}
+	for _, it := range list.Items {
+		statuses[it.Status]++
+	}
+	if len(list.Items) != 3 || statuses["live"] != 1 || statuses["expired"] != 1 || statuses["scheduled"] != 1 {
+		t.Fatalf("promotions = %+v", list.Items)
+	}
+	if !strings.Contains(list.Digest, "🔥 Акции") || !strings.Contains(list.Digest, "Томаты") ||
+		!strings.Contains(list.Digest, "-25%") || strings.Contains(list.Digest, "Овощи") {
+		t.Fatalf("digest = %q", list.Digest)
+	}
+
+	// продлили акцию и поменяли цену, затем сняли досрочно
+	if w := env.do(http.MethodPost, "/api/admin/promotions/update", map[string]any{"id": created.ID, "promo_price": 400}, env.admin()); w.Code != http.StatusOK {
+		t.Fatalf("update = %d %s", w.Code, w.Body.String())
+	}
+	if got := quote(item(tomato, 600)); got != 400 {
+		t.Fatalf("goods_total after update = %d", got)
+	}
+	if w := env.do(http.MethodPost, "/api/admin/promotions/delete", map[string]any{"id": created.ID}, env.admin()); w.Code != http.StatusOK {
+		t.Fatalf("delete = %d %s", w.Code, w.Body.String())
+	}
+	if got := quote(item(tomato, 450)); got != 600 {
+		t.Fatalf("no promotion rows — catalog price, not the cart one, got %d", got)
+	}
+	if w := env.do(http.MethodPost, "/api/admin/promotions/delete", map[string]any{"id": created.ID}, env.admin()); w.Code != http.StatusNotFound {
+		t.Fatalf("second delete = %d", w.Code)
+	}
+
+	var audits int
+	if err := env.h.db.QueryRow(`SELECT COUNT(1) FROM audit_log WHERE action LIKE 'promotion.%'`).Scan(&audits); err != nil || audits != 5 {
+		t.Fatalf("promotion audit entries = %d (%v)", audits, err)
+	}
+}
+
+func TestE2EAdminAudit(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedUser(801, "samal3")
+	env.exec(`INSERT INTO store_managers (store_code, telegram_id) VALUES ('samal3', 777)`)
+	env.exec(`INSERT INTO orders (id, user_id, store_code, total_amount, status) VALUES (61, 801, 'samal3', 1000, 'paid')`)
+
+	body, ct := env.multipart(map[string]string{
+		"name": "Морковь", "category": "roots", "unit": "кг", "price": "300", "store_code": "samal3",
+	})
+	hdr := env.admin()
+	hdr["Content-Type"] = ct
+	if w := env.do(http.MethodPost, "/api/admin/products/add", body, hdr); w.Code != http.StatusOK {
+		t.Fatalf("add product = %d %s", w.Code, w.Body.String())
+	}
+	var productID int64
+	if err := env.h.db.QueryRow(`SELECT id FROM products WHERE name = 'Морковь'`).Scan(&productID); err != nil {
+		t.Fatal(err)
+	}
+	if w := env.do(http.MethodPost, "/api/admin/products/delete", map[string]any{"id": productID}, env.admin()); w.Code != http.StatusOK {
+		t.Fatalf("delete product = %d %s", w.Code, w.Body.String())
+	}
+	// статус меняет управляющий точкой — в журнале его ID, а не главного админа
+	manager := map[string]string{"X-Telegram-Id": "777"}
+	if w := env.do(http.MethodPost, "/api/admin/orders/status", map[string]any{"order_id": 61, "status": "preparing"}, manager); w.Code != http.StatusOK {
+		t.Fatalf("set order status = %d %s", w.Code, w.Body.String())
+	}
+
+	type entry struct {
+		AdminID  int64          `json:"admin_id"`
+		Action   string         `json:"action"`
+		Entity   string         `json:"entity"`
+		EntityID string         `json:"entity_id"`
+		Details  map[string]any `json:"details"`
+	}
+	list := func(query string) (items []entry, total int64) {
+		t.Helper()
+		var out struct {
+			Items []entry `json:"items"`
+			Total int64   `json:"total"`
+		}
+		decode(t, env.do(http.MethodGet, "/api/admin/audit"+query, nil, env.admin()), &out)
+		return out.Items, out.Total
+	}
+
+	items, total := list("?entity=product")
+	if total != 2 || items[0].Action != "product.delete" || items[1].Action != "product.add" ||
+		items[1].Entity != "product" || items[1].EntityID != fmt.Sprint(productID) ||
+		items[1].AdminID != testAdminID || items[1].Details["name"] != "Морковь" {
+		t.Fatalf("product audit = %d %+v", total, items)
+	}
+	items, _ = list("?entity=order&admin_id=777")
+	if len(items) != 1 || items[0].Action != "order.status" || items[0].EntityID != "61" ||
+		items[0].Details["from"] != "paid" || items[0].Details["to"] != "preparing" {
+		t.Fatalf("manager audit = %+v", items)
+	}
+	if _, total := list("?entity_id=61&action=order.status"); total != 1 {
+		t.Fatalf("entity_id filter total = %d", total)
+	}
+	if _, total := list("?from=2000-01-01&to=2000-01-02"); total != 0 {
+		t.Fatalf("date filter total = %d", total)
+	}
+	if w := env.do(http.MethodGet, "/api/admin/audit?from=yesterday", nil, env.admin()); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad from = %d", w.Code)
+	}
+	if w := env.do(http.MethodGet, "/api/admin/audit", nil, manager); w.Code != http.StatusForbidden {
+		t.Fatalf("manager browsing audit = %d, want 403", w.Code)
+	}
+}
+
+func TestE2EOrderItemSnapshot(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedUser(901, "samal3")
+	tomato := env.seedProduct("Томаты", "veg", 600, "samal3")
+	env.exec(`UPDATE products SET emoji = '🍅', photo_path = '/uploads/tomato.jpg' WHERE id = ?`, tomato)
+	cucumber := env.seedProduct("Огурцы", "veg", 400, "samal3")
+
+	w := env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+		"telegram_id":    901,
+		"payment_method": "cash",
+		"items": []map[string]any{
+			{"product_id": tomato, "name": "Томаты", "qty": 2, "unit": "кг", "price": 600},
+			{"product_id": cucumber, "name": "Огурцы", "qty": 1, "unit": "кг", "price": 400},
+		},
+		"delivery": map[string]any{"type": "pickup"},
+	}, nil)
+	var created struct {
+		OrderID int64 `json:"order_id"`
+	}
+	decode(t, w, &created)
+
+	// каталог поменялся — в заказе остался снимок
+	env.exec(`UPDATE products SET emoji = '🥒' WHERE id = ?`, tomato)
+
+	receipts := env.sender.MessagesTo(901)
+	if len(receipts) == 0 || !strings.Contains(receipts[0], "• 🍅 Томаты — ") || !strings.Contains(receipts[0], "• Огурцы — ") {
+		t.Fatalf("receipt = %q", receipts)
+	}
+	admin := strings.Join(env.sender.MessagesTo(testAdminID), "\n")
+	if !strings.Contains(admin, "• 🍅 Томаты — ") {
+		t.Fatalf("admin notification = %q", admin)
+	}
+
+	// старый заказ без снимка выглядит как раньше
+	env.exec(`INSERT INTO orders (id, user_id, store_code, total_amount, status) VALUES (90, 901, 'samal3', 400, 'done')`)
+	env.exec(`INSERT INTO order_items (order_id, product_id, name, unit, qty, price, amount) VALUES (90, ?, 'Огурцы', 'кг', 1, 400, 400)`, cucumber)
+
+	type item struct {
+		Name  string `json:"name"`
+		Emoji string `json:"emoji"`
+		Photo string `json:"photo"`
+	}
+	var history []struct {
+		ID    int64  `json:"id"`
+		Items []item `json:"items"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/user/orders", nil, map[string]string{"X-Telegram-Id": "901"}), &history)
+	if len(history) != 2 || history[0].ID != 90 || history[1].ID != created.OrderID {
+		t.Fatalf("history = %+v", history)
+	}
+	if got := history[1].Items; len(got) != 2 || got[0] != (item{"Томаты", "🍅", "/uploads/tomato.jpg"}) || got[1] != (item{Name: "Огурцы"}) {
+		t.Fatalf("snapshot items = %+v", got)
+	}
+	if got := history[0].Items; len(got) != 1 || got[0] != (item{Name: "Огурцы"}) {
+		t.Fatalf("old order items = %+v", got)
+	}
+	if w := env.do(http.MethodGet, "/api/user/orders", nil, nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("no telegram id = %d", w.Code)
+	}
+}
+
+func TestE2EUserDataExportAndDelete(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.seedStore("samal3", "Самал-3")
+	env.seedUser(555, "samal3")
+	env.exec(`UPDATE users SET phone = '+77011234567' WHERE user_id = 555`)
+	env.exec(`INSERT INTO user_phones (user_id, phone, source) VALUES (555, '+77011234567', 'order')`)
+	env.exec(`INSERT INTO orders (id, user_id, store_code, total_amount, status, payment_method) VALUES (71, 555, 'samal3', 1500, 'done', 'kaspi_link')`)
+	env.exec(`INSERT INTO order_items (order_id, product_id, name, emoji, unit, qty, price, amount) VALUES (71, 1, 'Томаты', '🍅', 'кг', 2, 750, 1500)`)
+	// часть заказа 71 по точке — в выгрузку не попадает, она уже внутри общего заказа
+	env.exec(`INSERT INTO orders (id, user_id, store_code, total_amount, status, payment_method, parent_order_id) VALUES (72, 555, 'samal3', 1500, 'done', 'kaspi_link', 71)`)
+	env.exec(`INSERT INTO order_feedback (order_id, rating, comment) VALUES (71, 5, 'Курьер Алия — молодец')`)
+	env.exec(`INSERT INTO subscriptions (id, user_id, phone, status) VALUES (81, 555, '+77011234567', 'pending')`)
+	env.exec(`INSERT INTO subscriptions (id, user_id, phone, status) VALUES (82, 555, '+77011234567', 'expired')`)
+	if err := env.h.redisClient.SaveUserState(ctx, 555, &domain.UserState{State: stateWaitingPayment}); err != nil {
+		t.Fatal(err)
+	}
+
+	// выгрузка: всё о пользователе одним JSON
+	w := env.do(http.MethodGet, "/api/admin/users/export?telegram_id=555", nil, env.admin())
+	var export userExport
+	decode(t, w, &export)
+	if export.Profile.Phone != "+77011234567" || len(export.Phones) != 1 || len(export.Orders) != 1 ||
+		len(export.Orders[0].Items) != 1 || export.Orders[0].Items[0].Emoji != "🍅" ||
+		len(export.Payments) != 1 || export.Payments[0].Method != "kaspi_link" ||
+		len(export.Subscriptions) != 2 || len(export.Ratings) != 1 || export.Ratings[0].Rating != 5 {
+		t.Fatalf("export = %+v", export)
+	}
+	if w := env.do(http.MethodGet, "/api/admin/users/export?telegram_id=999", nil, env.admin()); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown user export = %d", w.Code)
+	}
+	if w := env.do(http.MethodGet, "/api/admin/users/export?telegram_id=555", nil, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("export without admin = %d", w.Code)
+	}
+
+	// /delete_me — сначала вопрос с кнопками
+	env.h.DeleteMeCommandHandler(ctx, nil, &models.Update{Message: &models.Message{
+		From: &models.User{ID: 555}, Chat: models.Chat{ID: 555}, Text: "/delete_me",
+	}})
+	kb := env.sender.Messages[len(env.sender.Messages)-1].ReplyMarkup.(*models.InlineKeyboardMarkup)
+	if kb.InlineKeyboard[0][0].CallbackData != deleteMeConfirm {
+		t.Fatalf("confirmation keyboard = %+v", kb.InlineKeyboard)
+	}
+	press := func(data string) {
+		t.Helper()
+		env.h.DeleteMeCallbackHandler(ctx, nil, &models.Update{CallbackQuery: &models.CallbackQuery{
+			ID: "cb", From: models.User{ID: 555}, Data: data,
+			Message: models.MaybeInaccessibleMessage{Message: &models.Message{ID: 9, Chat: models.Chat{ID: 555}}},
+		}})
+	}
+
+	// отмена ничего не трогает
+	press(deleteMeCancel)
+	var phone sql.NullString
+	if err := env.h.db.QueryRow(`SELECT phone FROM users WHERE user_id = 555`).Scan(&phone); err != nil || !phone.Valid {
+		t.Fatalf("phone after cancel = %v, %v", phone, err)
+	}
+
+	press(deleteMeConfirm)
+	if edit := env.sender.Edits[len(env.sender.Edits)-1]; !strings.Contains(edit.Text, "удалены") {
+		t.Fatalf("delete reply = %q", edit.Text)
+	}
+	var nickname string
+	if err := env.h.db.QueryRow(`SELECT nickname, phone FROM users WHERE user_id = 555`).Scan(&nickname, &phone); err != nil ||
+		nickname != deletedNickname || phone.Valid {
+		t.Fatalf("user after delete = %q %v, %v", nickname, phone, err)
+	}
+	var orders, phones, cancelled, subPhones, comments int
+	env.h.db.QueryRow(`SELECT COUNT(1) FROM orders WHERE user_id = 555`).Scan(&orders)
+	env.h.db.QueryRow(`SELECT COUNT(1) FROM user_phones WHERE user_id = 555`).Scan(&phones)
+	env.h.db.QueryRow(`SELECT COUNT(1) FROM subscriptions WHERE user_id = 555 AND status = 'cancelled'`).Scan(&cancelled)
+	env.h.db.QueryRow(`SELECT COUNT(1) FROM subscriptions WHERE user_id = 555 AND phone IS NOT NULL`).Scan(&subPhones)
+	env.h.db.QueryRow(`SELECT COUNT(1) FROM order_feedback WHERE order_id = 71 AND comment IS NOT NULL`).Scan(&comments)
+	if orders != 2 || phones != 0 || cancelled != 1 || subPhones != 0 || comments != 0 {
+		t.Fatalf("orders=%d phones=%d cancelled=%d sub_phones=%d comments=%d", orders, phones, cancelled, subPhones, comments)
+	}
+	if st, _ := env.h.redisClient.GetUserState(ctx, 555); st != nil {
+		t.Fatalf("user state after delete = %+v", st)
+	}
+
+	// оба действия — в журнале
+	var audit struct {
+		Items []auditEntryOut `json:"items"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/admin/audit?entity=user&entity_id=555", nil, env.admin()), &audit)
+	if len(audit.Items) != 2 || audit.Items[0].Action != "user.delete" || audit.Items[0].AdminID != 555 || audit.Items[1].Action != "user.export" {
+		t.Fatalf("audit = %+v", audit.Items)
+	}
+}
+
+func TestE2ECatalogImportCSV(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	potato := env.seedProduct("Картофель", "veg", 250, "samal3")
+	env.exec(`UPDATE products SET stock_qty = 40, featured = 1 WHERE id = ?`, potato)
+	env.exec(`INSERT INTO product_tags (product_id, tag) VALUES (?, 'хит')`, potato)
+
+	csvHeaders := env.admin()
+	csvHeaders["Content-Type"] = "text/csv; charset=utf-8"
+	importCSV := func(body, query string) (*httptest.ResponseRecorder, catalogSummary) {
+		t.Helper()
+		w := env.do(http.MethodPost, "/api/admin/catalog/import"+query, body, csvHeaders)
+		var out struct {
+			Summary catalogSummary `json:"summary"`
+		}
+		if w.Code == http.StatusOK {
+			decode(t, w, &out)
+		}
+		return w, out.Summary
+	}
+
+	// прайс из русского Excel: «;», BOM, цена с пробелом
+	ru := "\ufeffНазвание;Emoji;Категория;Единица;Цена;Описание;Код магазина;Активный\n" +
+		"Картофель;🥔;veg;₸/кг;1 200;;samal3;да\n" +
+		"Морковь;🥕;veg;₸/кг;180;Сладкая;samal3;нет\n"
+	w, sum := importCSV(ru, "")
+	if w.Code != http.StatusOK || sum.Products.Created != 1 || sum.Products.Updated != 1 {
+		t.Fatalf("ru import = %d %s", w.Code, w.Body.String())
+	}
+	var (
+		price, featured int64
+		stock           sql.NullInt64
+		emoji           string
+	)
+	env.h.db.QueryRow(`SELECT price, featured, stock_qty, emoji FROM products WHERE id = ?`, potato).Scan(&price, &featured, &stock, &emoji)
+	if price != 1200 || emoji != "🥔" || featured != 1 || stock.Int64 != 40 {
+		t.Fatalf("potato after csv = price %d emoji %q featured %d stock %v", price, emoji, featured, stock)
+	}
+	var tags, inactive int
+	env.h.db.QueryRow(`SELECT COUNT(1) FROM product_tags WHERE product_id = ?`, potato).Scan(&tags)
+	env.h.db.QueryRow(`SELECT COUNT(1) FROM products WHERE name = 'Морковь' AND active = 0 AND description = 'Сладкая'`).Scan(&inactive)
+	if tags != 1 || inactive != 1 {
+		t.Fatalf("tags = %d, inactive carrot = %d", tags, inactive)
+	}
+
+	// тот же эндпоинт, английские заголовки; повтор без изменений — skipped
+	en := "name,emoji,category,unit,price,description,store_code,active\n" +
+		"Картофель,🥔,veg,₸/кг,1200,,samal3,1\n"
+	if w, sum := importCSV(en, "?format=csv"); w.Code != http.StatusOK || sum.Products.Skipped != 1 {
+		t.Fatalf("en import = %d %s", w.Code, w.Body.String())
+	}
+
+	for _, bad := range []string{
+		"",
+		"Название;Цена\nКартофель;100\n",
+		"name,category,unit,price\nКартофель,veg,кг,дорого\n",
+		"name,category,unit,price,active\nКартофель,veg,кг,100,maybe\n",
+		"name,category,unit,price,store_code\nКартофель,veg,кг,100,nowhere\n",
+	} {
+		if w, _ := importCSV(bad, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%q = %d, want 400", bad, w.Code)
+		}
+	}
+}
+
+func TestE2EBotNotReadyOutbox(t *testing.T) {
+	env := newTestEnv(t)
+	recorder := env.sender
+	env.h.SetSender(nil) // веб-API без бота (окно старта до SetBot)
+
+	if w := env.do(http.MethodGet, "/readyz", nil, nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz without bot = %d", w.Code)
+	}
+
+	// заявка на подписку не отвечает «ok», если ссылка на оплату не ушла
+	var out struct {
+		Status     string `json:"status"`
+		Reason     string `json:"reason"`
+		PaymentURL string `json:"payment_url"`
+	}
+	w := env.do(http.MethodPost, "/api/subscribe/request-invoice",
+		map[string]string{"telegram_id": "905", "phone": "+77010000000"}, nil)
+	decode(t, w, &out)
+	if out.Status != "degraded" || out.Reason != "bot_not_ready" || out.PaymentURL == "" {
+		t.Fatalf("request-invoice without bot = %+v", out)
+	}
+
+	var pending int
+	env.h.db.QueryRow(`SELECT COUNT(1) FROM notification_outbox WHERE sent_at IS NULL`).Scan(&pending)
+	if pending != 2 { // ссылка покупателю и уведомление админу
+		t.Fatalf("outbox pending = %d", pending)
+	}
+
+	// бот подключился — outbox уходит, кнопка оплаты сохранилась
+	env.h.SetSender(recorder)
+	if sent, err := env.h.flushOutbox(context.Background()); err != nil || sent != 2 {
+		t.Fatalf("flush = %d, %v", sent, err)
+	}
+	msgs := recorder.MessagesTo(905)
+	if len(msgs) != 1 || !strings.Contains(msgs[0], "Kaspi Pay") {
+		t.Fatalf("user messages after flush = %v", msgs)
+	}
+	var kb *models.InlineKeyboardMarkup
+	for _, m := range recorder.Messages {
+		if m.ChatID == int64(905) {
+			kb, _ = m.ReplyMarkup.(*models.InlineKeyboardMarkup)
+		}
+	}
+	if kb == nil || kb.InlineKeyboard[0][0].URL != out.PaymentURL {
+		t.Fatalf("payment keyboard after flush = %+v", kb)
+	}
+	if sent, _ := env.h.flushOutbox(context.Background()); sent != 0 {
+		t.Fatalf("second flush sent %d", sent)
+	}
+
+	var ready struct {
+		Status string `json:"status"`
+	}
+	w = env.do(http.MethodGet, "/readyz", nil, nil)
+	decode(t, w, &ready)
+	if w.Code != http.StatusOK || ready.Status != "ready" {
+		t.Fatalf("readyz = %d %+v", w.Code, ready)
+	}
+
+	w = env.do(http.MethodPost, "/api/subscribe/request-invoice",
+		map[string]string{"telegram_id": "906", "phone": "+77010000001"}, nil)
+	decode(t, w, &out)
+	if out.Status != "ok" {
+		t.Fatalf("request-invoice with bot = %+v", out)
+	}
+}
+
+func TestE2EKaspiCallback(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedUser(921, "samal3")
+	env.exec(`INSERT INTO orders (id, user_id, total_amount, status) VALUES (71, 921, 5000, 'invoiced')`)
+	env.exec(`INSERT INTO subscriptions (id, user_id, amount, status) VALUES (91, 921, 2990, 'pending')`)
+
+	callback := func(body, sig string) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, "/api/payments/kaspi-callback", body, map[string]string{"X-Kaspi-Signature": sig})
+	}
+	orderBody := `{"txn_id":"k-1","order_id":71,"amount":5000,"status":"success"}`
+
+	// без секрета — только ручное подтверждение
+	if w := callback(orderBody, signWebhook("s3cret", []byte(orderBody))); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("callback without secret = %d", w.Code)
+	}
+	env.h.cfg.KaspiWebhookSecret = "s3cret"
+
+	if w := callback(orderBody, signWebhook("wrong", []byte(orderBody))); w.Code != http.StatusUnauthorized {
+		t.Fatalf("bad signature = %d", w.Code)
+	}
+	wrongSum := `{"txn_id":"k-2","order_id":71,"amount":4000,"status":"success"}`
+	if w := callback(wrongSum, signWebhook("s3cret", []byte(wrongSum))); w.Code != http.StatusConflict {
+		t.Fatalf("amount mismatch = %d", w.Code)
+	}
+
+	var out struct {
+		Status string `json:"status"`
+	}
+	w := callback(orderBody, signWebhook("s3cret", []byte(orderBody)))
+	decode(t, w, &out)
+	if w.Code != http.StatusOK || out.Status != "confirmed" {
+		t.Fatalf("order callback = %d %+v", w.Code, out)
+	}
+	var status, decidedBy string
+	env.h.db.QueryRow(`SELECT status, payment_decided_by_name FROM orders WHERE id = 71`).Scan(&status, &decidedBy)
+	if status != "paid" || decidedBy != "Kaspi Pay" {
+		t.Fatalf("order after callback = %s by %q", status, decidedBy)
+	}
+	if msgs := env.sender.MessagesTo(921); len(msgs) != 1 || !strings.Contains(msgs[0], "№71") {
+		t.Fatalf("user messages = %v", msgs)
+	}
+
+	// Kaspi повторяет callback — второй раз ничего не меняется
+	w = callback(orderBody, signWebhook("s3cret", []byte(orderBody)))
+	decode(t, w, &out)
+	if out.Status != "already_decided" || len(env.sender.MessagesTo(921)) != 1 {
+		t.Fatalf("repeated callback = %+v", out)
+	}
+
+	subBody := `{"txn_id":"k-3","subscription_id":91,"amount":2990,"status":"success"}`
+	w = callback(subBody, signWebhook("s3cret", []byte(subBody)))
+	decode(t, w, &out)
+	if out.Status != "confirmed" {
+		t.Fatalf("subscription callback = %d %+v", w.Code, out)
+	}
+	env.h.db.QueryRow(`SELECT status FROM subscriptions WHERE id = 91`).Scan(&status)
+	if status != "active" {
+		t.Fatalf("subscription status = %s", status)
+	}
+	state, _ := env.h.redisClient.GetUserState(context.Background(), 921)
+	if state == nil || !state.IsPaid {
+		t.Fatalf("user state after payment = %+v", state)
+	}
+}
+
+func TestE2EProductChanges(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedStore("aksai", "Аксай")
+	env.seedUser(931, "samal3")
+	for id, store := range map[int64]string{1: "", 2: "", 3: "", 4: "aksai"} {
+		env.exec(`INSERT INTO products (id, name, category_slug, unit, price, store_code, updated_at)
+			VALUES (?, ?, 'vegetables', 'кг', 100, ?, '2020-01-01 00:00:00')`, id, fmt.Sprint("Товар ", id), nullString(store))
+	}
+	user := map[string]string{"X-Telegram-Id": "931"}
+
+	var out struct {
+		ServerTime time.Time `json:"server_time"`
+		Changed    []struct {
+			ID int64 `json:"id"`
+		} `json:"changed"`
+		Removed []int64 `json:"removed"`
+	}
+	w := env.do(http.MethodGet, "/api/products/changes?since=2024-01-01T00:00:00Z", nil, user)
+	decode(t, w, &out)
+	if len(out.Changed) != 0 || len(out.Removed) != 0 || out.ServerTime.IsZero() {
+		t.Fatalf("no changes = %+v", out)
+	}
+
+	added := env.seedProduct("Томаты", "vegetables", 700, "")
+	env.exec(`UPDATE products SET active = 0 WHERE id = 2`)
+	env.exec(`UPDATE products SET price = 150 WHERE id = 4`) // другой магазин
+	if w := env.do(http.MethodPost, "/api/admin/products/delete", map[string]int64{"id": 3}, env.admin()); w.Code != http.StatusOK {
+		t.Fatalf("delete = %d", w.Code)
+	}
+
+	w = env.do(http.MethodGet, "/api/products/changes?since=2024-01-01T00:00:00%2B05:00", nil, user)
+	decode(t, w, &out)
+	if len(out.Changed) != 1 || out.Changed[0].ID != added {
+		t.Fatalf("changed = %+v", out.Changed)
+	}
+	if !slices.Equal(out.Removed, []int64{2, 3, 4}) {
+		t.Fatalf("removed = %v", out.Removed)
+	}
+
+	w = env.do(http.MethodGet, "/api/products/changes?since="+url.QueryEscape(out.ServerTime.Add(time.Hour).Format(time.RFC3339)), nil, user)
+	decode(t, w, &out)
+	if len(out.Changed) != 0 || len(out.Removed) != 0 {
+		t.Fatalf("changes after server time = %+v", out)
+	}
+	if w := env.do(http.MethodGet, "/api/products/changes?since=yesterday", nil, nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad since = %d", w.Code)
+	}
+}
+
+func TestE2EAdminRevenue(t *testing.T) {
+	env := newTestEnv(t)
+	env.exec(`INSERT INTO orders (user_id, total_amount, goods_total, delivery_price, status, created_at) VALUES
+		(941, 1000, 1000,    0, 'paid',      '2024-01-01 10:00:00'),
+		(941, 2000, 1000, 1000, 'done',      '2024-01-01 12:00:00'),
+		(941, 4000, 4000,    0, 'paid',      '2024-01-03 12:00:00'),
+		(941, 8000, 7000, 1000, 'paid',      '2024-02-05 12:00:00'),
+		(941, 9999, 9999,    0, 'cancelled', '2024-01-02 12:00:00'),
+		(941, 9999, 9999,    0, 'new',       '2024-01-02 12:00:00'),
+		(941, 9999, 9999,    0, 'paid',      '2024-04-01 12:00:00')`)
+
+	type period struct {
+		Period     string `json:"period"`
+		OrderCount int64  `json:"order_count"`
+		TotalKZT   int64  `json:"total_kzt"`
+	}
+	var out struct {
+		Periods   []period `json:"periods"`
+		Aggregate struct {
+			OrderCount  int64 `json:"order_count"`
+			TotalKZT    int64 `json:"total_kzt"`
+			GoodsKZT    int64 `json:"goods_kzt"`
+			DeliveryKZT int64 `json:"delivery_kzt"`
+		} `json:"aggregate"`
+	}
+	get := func(query string) *httptest.ResponseRecorder {
+		return env.do(http.MethodGet, "/api/admin/revenue?"+query, nil, env.admin())
+	}
+
+	w := get("from=2024-01-01&to=2024-03-31&group_by=day")
+	decode(t, w, &out)
+	want := []period{{"2024-01-01", 2, 3000}, {"2024-01-03", 1, 4000}, {"2024-02-05", 1, 8000}}
+	if !slices.Equal(out.Periods, want) {
+		t.Fatalf("by day = %+v", out.Periods)
+	}
+	if out.Aggregate.OrderCount != 4 || out.Aggregate.TotalKZT != 15000 ||
+		out.Aggregate.GoodsKZT != 13000 || out.Aggregate.DeliveryKZT != 2000 {
+		t.Fatalf("aggregate = %+v", out.Aggregate)
+	}
+
+	w = get("from=2024-01-01&to=2024-03-31&group_by=week")
+	decode(t, w, &out)
+	want = []period{{"2024-01-01", 3, 7000}, {"2024-02-05", 1, 8000}} // понедельники
+	if !slices.Equal(out.Periods, want) {
+		t.Fatalf("by week = %+v", out.Periods)
+	}
+
+	w = get("from=2024-01-01&to=2024-03-31&group_by=month")
+	decode(t, w, &out)
+	want = []period{{"2024-01-01", 3, 7000}, {"2024-02-01", 1, 8000}}
+	if !slices.Equal(out.Periods, want) {
+		t.Fatalf("by month = %+v", out.Periods)
+	}
+
+	for _, bad := range []string{
+		"from=2024-01-01",
+		"from=2024-13-01&to=2024-12-31",
+		"from=2024-03-01&to=2024-01-01",
+		"from=2024-01-01&to=2025-01-02",
+		"from=2024-01-01&to=2024-01-31&group_by=year",
+	} {
+		if w := get(bad); w.Code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400", bad, w.Code)
+		}
+	}
+	if w := env.do(http.MethodGet, "/api/admin/revenue?from=2024-01-01&to=2024-01-31", nil, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin = %d", w.Code)
+	}
+}
+
+func TestE2EStoreStaffOrderRouting(t *testing.T) {
+	env := newTestEnv(t)
+	env.h.notifier.window = 50 * time.Millisecond
+	env.seedStore("samal3", "Самал-3")
+	pid := env.seedProduct("Картофель", "vegetables", 250, "samal3")
+	env.seedUser(951, "samal3")
+	env.exec(`INSERT INTO store_managers (store_code, telegram_id) VALUES ('samal3', 777)`)
+	env.exec(`UPDATE order_status_messages SET user_message_ru = '🙌 Заказ №{order_id} получили, собираем!' WHERE status = 'new'`)
+
+	confirm := func() int64 {
+		t.Helper()
+		w := env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+			"telegram_id": "951",
+			"items":       []map[string]any{{"product_id": pid, "name": "Картофель", "qty": 2, "unit": "кг", "price": 250}},
+			"delivery":    map[string]any{"type": "pickup"},
+		}, nil)
+		var out struct {
+			OrderID int64 `json:"order_id"`
+		}
+		decode(t, w, &out)
+		return out.OrderID
+	}
+
+	orderID := confirm()
+	if msgs := env.sender.MessagesTo(777); len(msgs) != 1 || !strings.Contains(msgs[0], fmt.Sprintf("Новый заказ №%d", orderID)) {
+		t.Fatalf("manager messages = %q", msgs)
+	}
+	if msgs := waitMessages(t, env.sender, testAdminID, 1); len(msgs) != 1 {
+		t.Fatalf("admin copy = %q", msgs)
+	}
+	if receipt := env.sender.MessagesTo(951); len(receipt) == 0 || !strings.HasPrefix(receipt[0], fmt.Sprintf("🙌 Заказ №%d получили", orderID)) {
+		t.Fatalf("receipt = %q", receipt)
+	}
+
+	// управляющий двигает заказ своей точки кнопкой, посторонний — нет
+	press := func(from int64, data string) {
+		env.h.OrderStatusCallbackHandler(context.Background(), nil, &models.Update{CallbackQuery: &models.CallbackQuery{
+			ID: "cb", From: models.User{ID: from}, Data: data,
+		}})
+	}
+	status := func() (s string) {
+		env.h.db.QueryRow(`SELECT status FROM orders WHERE id = ?`, orderID).Scan(&s)
+		return s
+	}
+	press(778, fmt.Sprintf("ord_preparing:%d", orderID))
+	if s := status(); s != "new" {
+		t.Fatalf("stranger changed status to %s", s)
+	}
+	press(777, fmt.Sprintf("ord_preparing:%d", orderID))
+	if s := status(); s != orderPreparing {
+		t.Fatalf("manager press = %s", s)
+	}
+
+	// только управляющим: владельцу копия не приходит
+	env.h.cfg.OrderAdminFallbackOnly = true
+	confirm()
+	if msgs := env.sender.MessagesTo(777); len(msgs) != 2 {
+		t.Fatalf("manager messages = %q", msgs)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if msgs := env.sender.MessagesTo(testAdminID); len(msgs) != 1 {
+		t.Fatalf("admin got fallback-only order: %q", msgs)
+	}
+}
+
+func TestE2EAdminSendMessage(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedUser(555, "samal3")
+
+	send := func(body map[string]any, headers map[string]string) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, "/api/admin/send-message", body, headers)
+	}
+	if w := send(map[string]any{"user_id": 555, "text": "Привет"}, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("without admin = %d, want 403", w.Code)
+	}
+	for _, body := range []map[string]any{
+		{"user_id": 555},
+		{"text": "Привет"},
+		{"user_id": 555, "text": "Привет", "photo_url": "ftp://cdn/x.jpg"},
+	} {
+		if w := send(body, env.admin()); w.Code != http.StatusBadRequest {
+			t.Fatalf("%v = %d, want 400", body, w.Code)
+		}
+	}
+	if w := send(map[string]any{"user_id": 999, "text": "Привет"}, env.admin()); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown user = %d, want 404", w.Code)
+	}
+
+	w := send(map[string]any{"user_id": 555, "text": "Ваш заказ задерживается"}, env.admin())
+	var out struct {
+		MessageID int       `json:"message_id"`
+		SentAt    time.Time `json:"sent_at"`
+	}
+	decode(t, w, &out)
+	if out.MessageID == 0 || out.SentAt.IsZero() {
+		t.Fatalf("out = %+v", out)
+	}
+	if msgs := env.sender.MessagesTo(555); len(msgs) != 1 || msgs[0] != "Ваш заказ задерживается" {
+		t.Fatalf("user messages = %q", msgs)
+	}
+
+	w = send(map[string]any{"user_id": 555, "text": "Новинка", "photo_url": "https://cdn.example.com/apple.jpg"}, env.admin())
+	if w.Code != http.StatusOK {
+		t.Fatalf("photo = %d, body = %s", w.Code, w.Body.String())
+	}
+	if len(env.sender.Photos) != 1 || env.sender.Photos[0].Caption != "Новинка" {
+		t.Fatalf("photos = %+v", env.sender.Photos)
+	}
+
+	var audits int
+	if err := env.h.db.QueryRow(`SELECT COUNT(1) FROM audit_log WHERE action = 'user.message' AND target = 'user:555'`).Scan(&audits); err != nil || audits != 2 {
+		t.Fatalf("audits = %d, err = %v", audits, err)
+	}
+
+	for i := 3; i <= adminMessageLimit; i++ {
+		if w := send(map[string]any{"user_id": 555, "text": "Ещё"}, env.admin()); w.Code != http.StatusOK {
+			t.Fatalf("message %d = %d", i, w.Code)
+		}
+	}
+	w = send(map[string]any{"user_id": 555, "text": "Лишнее"}, env.admin())
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("over limit = %d, Retry-After = %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	env.redis.FastForward(adminMessageWindow)
+	if w := send(map[string]any{"user_id": 555, "text": "Снова можно"}, env.admin()); w.Code != http.StatusOK {
+		t.Fatalf("after window = %d", w.Code)
+	}
+}
+
+func TestE2EUpdatePhone(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedUser(555, "samal3")
+	env.exec(`UPDATE users SET phone = '+77011234560' WHERE user_id = 555`)
+	env.exec(`INSERT INTO subscriptions (id, user_id, phone, status) VALUES (1, 555, '+77011234560', 'pending')`)
+	env.exec(`INSERT INTO subscriptions (id, user_id, phone, status) VALUES (2, 555, '+77011234560', 'expired')`)
+
+	update := func(tgID any, phone string, header string) *httptest.ResponseRecorder {
+		var headers map[string]string
+		if header != "" {
+			headers = map[string]string{"X-Telegram-Id": header}
+		}
+		return env.do(http.MethodPost, "/api/user/update-phone", map[string]any{"telegram_id": tgID, "phone": phone}, headers)
+	}
+	if w := update("555", "87011234567", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("without X-Telegram-Id = %d, want 401", w.Code)
+	}
+	if w := update("555", "87011234567", "556"); w.Code != http.StatusForbidden {
+		t.Fatalf("foreign user = %d, want 403", w.Code)
+	}
+	if w := update("555", "12345", "555"); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad phone = %d, want 400", w.Code)
+	}
+	if w := update(999, "87011234567", "999"); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown user = %d, want 404", w.Code)
+	}
+
+	w := update(555, "8 (701) 123-45-67", "555")
+	var out struct {
+		Phone string `json:"phone"`
+	}
+	decode(t, w, &out)
+	if out.Phone != "+77011234567" {
+		t.Fatalf("phone = %q", out.Phone)
+	}
+
+	var userPhone, pending, expired string
+	_ = env.h.db.QueryRow(`SELECT phone FROM users WHERE user_id = 555`).Scan(&userPhone)
+	_ = env.h.db.QueryRow(`SELECT phone FROM subscriptions WHERE id = 1`).Scan(&pending)
+	_ = env.h.db.QueryRow(`SELECT phone FROM subscriptions WHERE id = 2`).Scan(&expired)
+	if userPhone != "+77011234567" || pending != "+77011234567" || expired != "+77011234560" {
+		t.Fatalf("users = %q, pending = %q, expired = %q", userPhone, pending, expired)
+	}
+	var source string
+	if err := env.h.db.QueryRow(`SELECT source FROM user_phones WHERE user_id = 555 AND phone = '+77011234567'`).Scan(&source); err != nil || source != phoneSourceProfile {
+		t.Fatalf("user_phones source = %q, err = %v", source, err)
+	}
+}
+
+func TestE2EStoreDeliverySettings(t *testing.T) {
+	env := newTestEnv(t)
+	env.h.cfg.DeliveryRadiusKm = 5
+	env.seedStore("samal3", "Самал-3")
+	env.exec(`UPDATE stores SET latitude = 43.2, longitude = 76.9 WHERE code = 'samal3'`)
+	pid := env.seedProduct("Картофель", "vegetables", 250, "")
+	env.seedUser(555, "samal3")
+
+	set := func(body string) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, "/api/admin/stores/delivery", body, env.admin())
+	}
+	if w := env.do(http.MethodPost, "/api/admin/stores/delivery", `{"store_code":"samal3","delivers":false}`, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("without admin = %d, want 403", w.Code)
+	}
+	if w := set(`{"store_code":"samal3"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("without delivers = %d, want 400", w.Code)
+	}
+	if w := set(`{"store_code":"nope","delivers":true}`); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown store = %d, want 404", w.Code)
+	}
+
+	confirm := func(lat, lng float64) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+			"telegram_id": 555,
+			"items":       []map[string]any{{"product_id": pid, "name": "Картофель", "qty": 1, "unit": "кг", "price": 250}},
+			"delivery":    map[string]any{"type": "delivery", "lat": lat, "lng": lng},
+		}, nil)
+	}
+	var rejected struct {
+		Code    string `json:"code"`
+		Suggest string `json:"suggest"`
+	}
+
+	// ~8 км от точки: за общим радиусом 5 км, но внутри собственного 10 км
+	decode(t, confirm(43.27, 76.9), &rejected)
+	if rejected.Code != "outside_delivery_zone" || rejected.Suggest != "pickup" {
+		t.Fatalf("outside default radius = %+v", rejected)
+	}
+	if w := set(`{"store_code":"samal3","delivers":true,"delivery_radius_km":10}`); w.Code != http.StatusOK {
+		t.Fatalf("set radius = %d %s", w.Code, w.Body.String())
+	}
+	if w := confirm(43.27, 76.9); w.Code != http.StatusOK {
+		t.Fatalf("inside store radius = %d %s", w.Code, w.Body.String())
+	}
+
+	if w := set(`{"store_code":"samal3","delivers":false}`); w.Code != http.StatusOK {
+		t.Fatalf("disable delivery = %d", w.Code)
+	}
+	w := confirm(43.2, 76.9)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("non-delivering store = %d, want 400", w.Code)
+	}
+	decode(t, w, &rejected)
+	if rejected.Code != "delivery_unavailable" || rejected.Suggest != "pickup" {
+		t.Fatalf("non-delivering store = %+v", rejected)
+	}
+	pickup := env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+		"telegram_id": 555,
+		"items":       []map[string]any{{"product_id": pid, "name": "Картофель", "qty": 1, "unit": "кг", "price": 250}},
+		"delivery":    map[string]any{"type": "pickup"},
+	}, nil)
+	if pickup.Code != http.StatusOK {
+		t.Fatalf("pickup at non-delivering store = %d %s", pickup.Code, pickup.Body.String())
+	}
+
+	var stores []struct {
+		Code             string   `json:"code"`
+		Delivers         bool     `json:"delivers"`
+		DeliveryRadiusKm *float64 `json:"delivery_radius_km"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/stores", nil, nil), &stores)
+	if len(stores) != 1 || stores[0].Delivers || stores[0].DeliveryRadiusKm == nil || *stores[0].DeliveryRadiusKm != 5 {
+		t.Fatalf("stores = %+v", stores)
+	}
+	var status struct {
+		Delivers bool     `json:"store_delivers"`
+		RadiusKm *float64 `json:"store_delivery_radius_km"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/user/subscription-status?telegram_id=555", nil, nil), &status)
+	if status.Delivers || status.RadiusKm == nil || *status.RadiusKm != 5 {
+		t.Fatalf("subscription-status = %+v", status)
+	}
+}
+
+func TestE2EStoreScopedProductEdits(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedStore("aksai", "Аксай")
+	env.exec(`INSERT INTO store_managers (store_code, telegram_id) VALUES ('samal3', 777)`)
+	env.h.cfg.AdminIDs = []int64{888}
+	own := env.seedProduct("Морковь", "vegetables", 300, "samal3")
+	foreign := env.seedProduct("Лук", "vegetables", 200, "aksai")
+
+	as := func(tgID int64) map[string]string {
+		return map[string]string{"X-Telegram-Id": strconv.FormatInt(tgID, 10)}
+	}
+	post := func(tgID int64, path string, fields map[string]string) int {
+		body, ct := env.multipart(fields)
+		h := as(tgID)
+		h["Content-Type"] = ct
+		return env.do(http.MethodPost, path, body, h).Code
+	}
+	fields := func(id int64, store string) map[string]string {
+		f := map[string]string{"name": "Товар", "category": "vegetables", "unit": "кг", "price": "100", "store_code": store}
+		if id > 0 {
+			f["id"] = strconv.FormatInt(id, 10)
+		}
+		return f
+	}
+
+	if code := post(999, "/api/admin/products/add", fields(0, "samal3")); code != http.StatusForbidden {
+		t.Fatalf("stranger add = %d, want 403", code)
+	}
+	if code := post(777, "/api/admin/products/add", fields(0, "samal3")); code != http.StatusOK {
+		t.Fatalf("manager add to own store = %d", code)
+	}
+	if code := post(777, "/api/admin/products/add", fields(0, "aksai")); code != http.StatusForbidden {
+		t.Fatalf("manager add to foreign store = %d, want 403", code)
+	}
+	if code := post(777, "/api/admin/products/update", fields(own, "samal3")); code != http.StatusOK {
+		t.Fatalf("manager update own product = %d", code)
+	}
+	if code := post(777, "/api/admin/products/update", fields(own, "aksai")); code != http.StatusForbidden {
+		t.Fatalf("manager moves product to foreign store = %d, want 403", code)
+	}
+	if code := post(777, "/api/admin/products/update", fields(foreign, "samal3")); code != http.StatusForbidden {
+		t.Fatalf("manager takes foreign product = %d, want 403", code)
+	}
+	if code := post(777, "/api/admin/products/update", fields(12345, "samal3")); code != http.StatusNotFound {
+		t.Fatalf("manager updates missing product = %d, want 404", code)
+	}
+
+	del := func(tgID, id int64) int {
+		return env.do(http.MethodPost, "/api/admin/products/delete", map[string]int64{"id": id}, as(tgID)).Code
+	}
+	if code := del(777, foreign); code != http.StatusForbidden {
+		t.Fatalf("manager deletes foreign product = %d, want 403", code)
+	}
+	if code := del(777, own); code != http.StatusOK {
+		t.Fatalf("manager deletes own product = %d", code)
+	}
+	// суперадмин из ADMIN_IDS не ограничен точкой
+	if code := post(888, "/api/admin/products/update", fields(foreign, "aksai")); code != http.StatusOK {
+		t.Fatalf("super admin update = %d", code)
+	}
+	if code := del(888, foreign); code != http.StatusOK {
+		t.Fatalf("super admin delete = %d", code)
+	}
+
+	var actor int64
+	_ = env.h.db.QueryRow(`SELECT admin_id FROM audit_log WHERE action = 'product.update' ORDER BY id LIMIT 1`).Scan(&actor)
+	if actor != 777 {
+		t.Fatalf("audit actor = %d, want 777", actor)
+	}
+}
+
+func TestE2EGiftSubscription(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedUser(555, "samal3")
+	env.seedUser(556, "samal3")
+
+	gift := func(body map[string]any, headers map[string]string) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, "/api/admin/subscriptions/gift", body, headers)
+	}
+	if w := gift(map[string]any{"from_user_id": 555, "to_user_id": 556}, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("without admin = %d, want 403", w.Code)
+	}
+	for _, body := range []map[string]any{
+		{"from_user_id": 555},
+		{"from_user_id": 555, "to_user_id": 555},
+		{"from_user_id": 555, "to_user_id": 556, "months": 13},
+	} {
+		if w := gift(body, env.admin()); w.Code != http.StatusBadRequest {
+			t.Fatalf("%v = %d, want 400", body, w.Code)
+		}
+	}
+	if w := gift(map[string]any{"from_user_id": 555, "to_user_id": 999}, env.admin()); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown recipient = %d, want 404", w.Code)
+	}
+
+	var out struct {
+		SubscriptionID int64  `json:"subscription_id"`
+		ValidUntil     string `json:"valid_until"`
+	}
+	decode(t, gift(map[string]any{"from_user_id": 555, "to_user_id": 556}, env.admin()), &out)
+	want := time.Now().AddDate(0, 1, 0).Format("2006-01-02")
+	if out.SubscriptionID == 0 || out.ValidUntil != want {
+		t.Fatalf("gift = %+v, want valid_until %s", out, want)
+	}
+	got := env.sender.MessagesTo(556)
+	if len(got) != 1 || !strings.HasPrefix(got[0], "🎁 Вам подарена подписка АГРО Клуб на 1 месяц! Доступ до: ") {
+		t.Fatalf("recipient messages = %q", got)
+	}
+	if got := env.sender.MessagesTo(555); len(got) != 1 || !strings.Contains(got[0], "подарена пользователю tester") {
+		t.Fatalf("sender messages = %q", got)
+	}
+
+	// второй подарок продлевает уже подаренную подписку
+	decode(t, gift(map[string]any{"from_user_id": 555, "to_user_id": 556, "months": 2}, env.admin()), &out)
+	if want := time.Now().AddDate(0, 3, 0).Format("2006-01-02"); out.ValidUntil != want {
+		t.Fatalf("second gift valid_until = %s, want %s", out.ValidUntil, want)
+	}
+	if got := env.sender.MessagesTo(556); len(got) != 2 || !strings.Contains(got[1], "на 2 месяца!") {
+		t.Fatalf("recipient messages = %q", got)
+	}
+
+	var status, audits int
+	_ = env.h.db.QueryRow(`SELECT COUNT(1) FROM users WHERE user_id = 556 AND sub_status = 'active'`).Scan(&status)
+	_ = env.h.db.QueryRow(`SELECT COUNT(1) FROM audit_log WHERE action = 'subscription.gift' AND target = '556'`).Scan(&audits)
+	if status != 1 || audits != 2 {
+		t.Fatalf("active = %d, audits = %d", status, audits)
+	}
+	var sub struct {
+		Active bool `json:"active"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/user/subscription-status?telegram_id=556", nil, nil), &sub)
+	if !sub.Active {
+		t.Fatal("recipient subscription is not active")
+	}
+}
+
+func TestE2EPendingPaymentReviews(t *testing.T) {
+	env := newTestEnv(t)
+	env.h.notifier.window = 50 * time.Millisecond
+	ctx := context.Background()
+	clock := &fakeClock{t: time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC)}
+	env.h.SetClock(clock)
+
+	env.do(http.MethodPost, "/api/subscribe/request-invoice",
+		map[string]string{"telegram_id": "555", "phone": "+77010000000"}, nil)
+	env.h.DefaultHandler(ctx, nil, &models.Update{Message: &models.Message{
+		ID:       10,
+		From:     &models.User{ID: 555, Username: "tester"},
+		Chat:     models.Chat{ID: 555},
+		Document: &models.Document{FileID: "receipt.pdf"},
+	}})
+
+	if w := env.do(http.MethodGet, "/api/admin/payments/pending", nil, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("without admin = %d, want 403", w.Code)
+	}
+	clock.t = clock.t.Add(13 * time.Hour)
+	var list struct {
+		Items []pendingPaymentOut `json:"items"`
+		Total int                 `json:"total"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/admin/payments/pending", nil, env.admin()), &list)
+	if list.Total != 1 {
+		t.Fatalf("pending = %+v", list)
+	}
+	p := list.Items[0]
+	if p.Kind != paymentKindSubscription || p.UserID != 555 || p.Phone != "+77010000000" ||
+		p.Amount <= 0 || p.AgeMinutes != 13*60 || p.ResendURL == "" {
+		t.Fatalf("pending item = %+v", p)
+	}
+
+	// чек заново приходит запросившему админу с кнопками решения
+	if w := env.do(http.MethodPost, p.ResendURL, nil, env.admin()); w.Code != http.StatusOK {
+		t.Fatalf("resend = %d, body = %s", w.Code, w.Body.String())
+	}
+	if len(env.sender.Documents) != 1 {
+		t.Fatalf("documents = %d, want 1", len(env.sender.Documents))
+	}
+	doc := env.sender.Documents[0]
+	if f, ok := doc.Document.(*models.InputFileString); !ok || f.Data != "receipt.pdf" || doc.ChatID != testAdminID {
+		t.Fatalf("resent document = %+v", doc)
+	}
+	okData := doc.ReplyMarkup.(*models.InlineKeyboardMarkup).InlineKeyboard[0][0].CallbackData
+	if !strings.HasPrefix(okData, "sub_ok:") {
+		t.Fatalf("confirm button data = %q", okData)
+	}
+	if w := env.do(http.MethodPost, "/api/admin/payments/resend", map[string]any{"payment_id": 999}, env.admin()); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown payment = %d, want 404", w.Code)
+	}
+
+	// ежедневная проверка напоминает о чеке старше 12 часов
+	env.h.remindPendingReviews(ctx)
+	// первое сообщение админу — сама заявка на подписку
+	msgs := waitMessages(t, env.sender, testAdminID, 2)
+	if last := msgs[len(msgs)-1]; !strings.Contains(last, "Чеки ждут проверки дольше 12 ч: 1") ||
+		!strings.Contains(last, fmt.Sprintf("подписка №%d", p.TargetID)) {
+		t.Fatalf("reminder = %q", msgs)
+	}
+
+	// после решения чек пропадает из списка, повторно его не прислать
+	env.h.PaymentCallbackHandler(ctx, nil, &models.Update{
+		CallbackQuery: &models.CallbackQuery{ID: "cb", Data: okData},
+	})
+	decode(t, env.do(http.MethodGet, "/api/admin/payments/pending", nil, env.admin()), &list)
+	if list.Total != 0 {
+		t.Fatalf("pending after decision = %+v", list)
+	}
+	if w := env.do(http.MethodPost, p.ResendURL, nil, env.admin()); w.Code != http.StatusConflict {
+		t.Fatalf("resend reviewed = %d, want 409", w.Code)
+	}
+}
+
+func TestE2EBulkPriceByCategory(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedStore("aksai", "Аксай")
+	potato := env.seedProduct("Картофель", "veg", 250, "samal3")
+	onion := env.seedProduct("Лук", "veg", 300, "samal3")
+	apple := env.seedProduct("Яблоки", "fruit", 500, "samal3")
+	other := env.seedProduct("Морковь", "veg", 200, "aksai")
+
+	bulk := func(body map[string]any) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, "/api/admin/products/bulk-price", body, env.admin())
+	}
+	if w := env.do(http.MethodPost, "/api/admin/products/bulk-price",
+		map[string]any{"category": "veg", "store_code": "samal3", "percent_change": 10}, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin = %d", w.Code)
+	}
+	for _, body := range []map[string]any{
+		{"store_code": "samal3", "percent_change": 10},
+		{"category": "veg", "store_code": "samal3", "percent_change": -60},
+		{"category": "veg", "store_code": "samal3", "percent_change": 10, "prices": []map[string]any{{"product_id": potato, "price": 1}}},
+		{"prices": []map[string]any{{"product_id": potato, "price": -1}}},
+	} {
+		if w := bulk(body); w.Code != http.StatusBadRequest {
+			t.Fatalf("%v = %d, want 400", body, w.Code)
+		}
+	}
+
+	var out struct {
+		Updated int64 `json:"updated"`
+	}
+	decode(t, bulk(map[string]any{"category": "veg", "store_code": "samal3", "percent_change": -20}), &out)
+	if out.Updated != 2 {
+		t.Fatalf("updated = %d, want 2", out.Updated)
+	}
+
+	// явные цены: чужая точка — 404, и ничего не меняется
+	if w := bulk(map[string]any{"store_code": "samal3", "prices": []map[string]any{
+		{"product_id": apple, "price": 450}, {"product_id": other, "price": 1},
+	}}); w.Code != http.StatusNotFound {
+		t.Fatalf("foreign product = %d, want 404", w.Code)
+	}
+	decode(t, bulk(map[string]any{"prices": []map[string]any{
+		{"product_id": apple, "price": 450}, {"product_id": other, "price": 0},
+	}}), &out)
+	if out.Updated != 2 {
+		t.Fatalf("updated = %d, want 2", out.Updated)
+	}
+
+	price := func(id int64) (p int64) {
+		_ = env.h.db.QueryRow(`SELECT price FROM products WHERE id = ?`, id).Scan(&p)
+		return p
+	}
+	for id, want := range map[int64]int64{potato: 200, onion: 240, apple: 450, other: 0} {
+		if got := price(id); got != want {
+			t.Errorf("product %d price = %d, want %d", id, got, want)
+		}
+	}
+	var feed int
+	_ = env.h.db.QueryRow(`SELECT COUNT(1) FROM price_feed WHERE product_id IN (?, ?, ?, ?)`, potato, onion, apple, other).Scan(&feed)
+	if feed != 4 {
+		t.Fatalf("price_feed rows = %d, want 4", feed)
+	}
+}
+
+func TestE2EPricePer100g(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedUser(555, "samal3")
+
+	add := func(fields map[string]string) int {
+		body, ct := env.multipart(fields)
+		h := env.admin()
+		h["Content-Type"] = ct
+		return env.do(http.MethodPost, "/api/admin/products/add", body, h).Code
+	}
+	fields := map[string]string{
+		"name": "Зира", "category": "spices", "unit": "кг", "price": "450", "store_code": "samal3", "price_per": "50g",
+	}
+	if code := add(fields); code != http.StatusBadRequest {
+		t.Fatalf("bad price_per = %d, want 400", code)
+	}
+	fields["price_per"] = "100g"
+	if code := add(fields); code != http.StatusOK {
+		t.Fatalf("add = %d", code)
+	}
+
+	var products []productOut
+	decode(t, env.do(http.MethodGet, "/api/products?store_code=samal3", nil, nil), &products)
+	if len(products) != 1 || products[0].PricePer != pricePer100g {
+		t.Fatalf("catalog = %+v", products)
+	}
+
+	// 0.3 кг по 450 ₸/100 г — 3 × 450; price_per клиента игнорируется
+	w := env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+		"telegram_id":    "555",
+		"payment_method": "kaspi_transfer",
+		"items": []map[string]any{{
+			"product_id": products[0].ID, "name": "Зира", "qty": 0.3, "unit": "кг", "price": 450, "price_per": "kg",
+		}},
+		"delivery": map[string]any{"type": "pickup"},
+	}, nil)
+	var out struct {
+		OrderID int64 `json:"order_id"`
+		Total   int64 `json:"total"`
+	}
+	decode(t, w, &out)
+	if out.Total != 1350 {
+		t.Fatalf("total = %d, want 1350", out.Total)
+	}
+	receipts := env.sender.MessagesTo(555)
+	if len(receipts) == 0 || !strings.Contains(receipts[len(receipts)-1], "Зира — 3 × 450\u00a0₸/100 г = 1\u00a0350\u00a0₸") {
+		t.Fatalf("receipt = %q", receipts)
+	}
+	items, err := env.h.loadOrderItems(out.OrderID)
+	if err != nil || len(items) != 1 || items[0].PricePer != pricePer100g {
+		t.Fatalf("order items = %+v, err = %v", items, err)
+	}
+}
+
+func TestE2EVerifyOrderTotals(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedUser(555, "samal3")
+	pid := env.seedProduct("Картофель", "vegetables", 250, "samal3")
+
+	w := env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+		"telegram_id":    "555",
+		"payment_method": "kaspi_transfer",
+		"items":          []map[string]any{{"product_id": pid, "name": "Картофель", "qty": 2, "unit": "кг", "price": 250}},
+		"delivery":       map[string]any{"type": "pickup"},
+	}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm = %d %s", w.Code, w.Body)
+	}
+
+	// заказ, испорченный мимо API: позиции на 500, а total — 700
+	env.exec(`INSERT INTO orders (id, user_id, total_amount, status) VALUES (900, 555, 700, 'new')`)
+	env.exec(`INSERT INTO order_items (order_id, product_id, name, unit, qty, price, amount) VALUES (900, ?, 'Картофель', 'кг', 2, 250, 500)`, pid)
+
+	if w := env.do(http.MethodGet, "/api/admin/orders/verify", nil, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("not admin = %d", w.Code)
+	}
+	if w := env.do(http.MethodGet, "/api/admin/orders/verify?limit=0", nil, env.admin()); w.Code != http.StatusBadRequest {
+		t.Fatalf("limit=0 = %d", w.Code)
+	}
+	var out struct {
+		Items   []orderMismatchOut `json:"items"`
+		Total   int                `json:"total"`
+		Checked int64              `json:"checked"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/admin/orders/verify", nil, env.admin()), &out)
+	if out.Checked != 2 || out.Total != 1 || out.Items[0].OrderID != 900 || out.Items[0].ItemsSum != 500 || out.Items[0].Diff != 200 {
+		t.Fatalf("verify = %+v", out)
+	}
+}
+
+func TestE2ESplitOrderByStore(t *testing.T) {
+	env := newTestEnv(t)
+	env.h.notifier.window = 50 * time.Millisecond
+	env.seedStore("samal3", "Самал-3")
+	env.seedStore("aksai", "Аксай")
+	local := env.seedProduct("Картофель", "vegetables", 250, "samal3")
+	global := env.seedProduct("Соль", "grocery", 100, "")
+	foreign := env.seedProduct("Укроп", "greens", 300, "aksai")
+	env.seedUser(555, "samal3")
+	env.exec(`INSERT INTO store_managers (store_code, telegram_id) VALUES ('aksai', 777)`)
+
+	confirm := func(split bool) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+			"telegram_id":    "555",
+			"payment_method": "kaspi_transfer",
+			"split_stores":   split,
+			"items": []map[string]any{
+				{"product_id": local, "name": "Картофель", "qty": 2, "unit": "кг", "price": 250},
+				{"product_id": foreign, "name": "Укроп", "qty": 1, "unit": "кг", "price": 300},
+				{"product_id": global, "name": "Соль", "qty": 1, "unit": "кг", "price": 100},
+			},
+			"delivery": map[string]any{"type": "pickup"},
+		}, nil)
+	}
+	// без согласия клиента — как раньше, 400
+	if w := confirm(false); w.Code != http.StatusBadRequest {
+		t.Fatalf("without split_stores = %d", w.Code)
+	}
+
+	w := confirm(true)
+	var out struct {
+		OrderID   int64         `json:"order_id"`
+		Total     int64         `json:"total"`
+		SubOrders []subOrderOut `json:"sub_orders"`
+	}
+	decode(t, w, &out)
+	// общие товары — в часть выбранной точки
+	if w.Code != http.StatusOK || out.Total != 900 || len(out.SubOrders) != 2 ||
+		out.SubOrders[0].StoreCode != "samal3" || out.SubOrders[0].Total != 600 ||
+		out.SubOrders[1].StoreCode != "aksai" || out.SubOrders[1].Total != 300 {
+		t.Fatalf("confirm = %d %+v", w.Code, out)
+	}
+	child := out.SubOrders[1].OrderID
+	var parent int64
+	_ = env.h.db.QueryRow(`SELECT parent_order_id FROM orders WHERE id = ?`, child).Scan(&parent)
+	if parent != out.OrderID {
+		t.Fatalf("parent_order_id = %d, want %d", parent, out.OrderID)
+	}
+
+	// чек один, с частями; управляющий Аксая видит только свою часть
+	receipts := env.sender.MessagesTo(555)
+	if len(receipts) != 1 || !strings.Contains(receipts[0], "Заказ соберут 2 точки") ||
+		!strings.Contains(receipts[0], fmt.Sprintf("№%d — Аксай: 300 ₸", child)) {
+		t.Fatalf("receipt = %q", receipts)
+	}
+	manager := waitMessages(t, env.sender, 777, 1)
+	if !strings.Contains(manager[0], fmt.Sprintf("часть заказа №%d", out.OrderID)) ||
+		!strings.Contains(manager[0], "Укроп") || strings.Contains(manager[0], "Картофель") {
+		t.Fatalf("manager notice = %q", manager)
+	}
+
+	// в истории клиента — только общий заказ, ему же идёт чек
+	orders, err := env.h.orderRepo.ListByUser(context.Background(), 555, 10)
+	if err != nil || len(orders) != 1 || orders[0].ID != out.OrderID {
+		t.Fatalf("history = %+v, %v", orders, err)
+	}
+
+	// подтверждение оплаты общего заказа оплачивает и части
+	env.h.PaymentCallbackHandler(context.Background(), nil, &models.Update{CallbackQuery: &models.CallbackQuery{
+		ID: "cb-split", From: models.User{ID: testAdminID, Username: "owner"}, Data: fmt.Sprintf("pay_ok:%d:555", out.OrderID),
+		Message: models.MaybeInaccessibleMessage{Message: &models.Message{ID: 1, Chat: models.Chat{ID: testAdminID}}},
+	}})
+	var paid int
+	_ = env.h.db.QueryRow(`SELECT COUNT(1) FROM orders WHERE status = 'paid'`).Scan(&paid)
+	if paid != 3 {
+		t.Fatalf("paid orders = %d, want parent and 2 parts", paid)
+	}
+}
+
+func TestE2EAdminOrderDetail(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	env.seedUser(555, "samal3")
+	pid := env.seedProduct("Картофель", "vegetables", 250, "samal3")
+
+	var order struct {
+		OrderID int64 `json:"order_id"`
+	}
+	decode(t, env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+		"telegram_id":    "555",
+		"payment_method": "kaspi_transfer",
+		"items":          []map[string]any{{"product_id": pid, "name": "Картофель", "qty": 2, "unit": "кг", "price": 250, "note": "покрупнее"}},
+		"delivery":       map[string]any{"type": "pickup"},
+	}, nil), &order)
+	env.exec(`INSERT INTO payments (kind, target_id, user_id, file_id, amount, created_at) VALUES ('order', ?, 555, 'doc-1', 500, CURRENT_TIMESTAMP)`, order.OrderID)
+	env.h.PaymentCallbackHandler(context.Background(), nil, &models.Update{CallbackQuery: &models.CallbackQuery{
+		ID: "cb-detail", From: models.User{ID: testAdminID, Username: "owner"}, Data: fmt.Sprintf("pay_ok:%d:555", order.OrderID),
+		Message: models.MaybeInaccessibleMessage{Message: &models.Message{ID: 1, Chat: models.Chat{ID: testAdminID}}},
+	}})
+	if w := env.do(http.MethodPost, "/api/admin/orders/status", map[string]any{"order_id": order.OrderID, "status": orderPreparing}, env.admin()); w.Code != http.StatusOK {
+		t.Fatalf("set status = %d %s", w.Code, w.Body)
+	}
+
+	if w := env.do(http.MethodGet, "/api/admin/orders/detail?id=abc", nil, env.admin()); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad id = %d", w.Code)
+	}
+	var detail struct {
+		Store         *orderStoreOut         `json:"store"`
+		PaymentMethod string                 `json:"payment_method"`
+		PaymentProof  *orderPaymentProofOut  `json:"payment_proof"`
+		StatusHistory []orderStatusChangeOut `json:"status_history"`
+		Delivery      map[string]any         `json:"delivery"`
+		Items         []adminOrderItemOut    `json:"items"`
+	}
+	decode(t, env.do(http.MethodGet, fmt.Sprintf("/api/admin/orders/detail?id=%d", order.OrderID), nil, env.admin()), &detail)
+	if detail.Store == nil || detail.Store.Name != "Самал-3" || detail.PaymentMethod != paymentKaspiTransfer ||
+		detail.Delivery["type"] != "pickup" || len(detail.Items) != 1 || detail.Items[0].Note != "покрупнее" {
+		t.Fatalf("detail = %+v", detail)
+	}
+	if detail.PaymentProof == nil || detail.PaymentProof.FileID != "doc-1" || detail.PaymentProof.ResendURL == "" {
+		t.Fatalf("payment proof = %+v", detail.PaymentProof)
+	}
+	h := detail.StatusHistory
+	if len(h) != 3 || h[0].To != "new" || h[1].To != "paid" || h[1].AdminID != testAdminID || h[2].From != "paid" || h[2].To != orderPreparing {
+		t.Fatalf("status history = %+v", h)
+	}
+}
+
+func TestE2EStaticPages(t *testing.T) {
+	env := newTestEnv(t)
+
+	// по умолчанию страницы вшиты в бинарник — каталог ./static не нужен
+	for _, path := range []string{"/", "/catalog", "/order-confirm", "/admin-show-catalog", "/map-view", "/store-select"} {
+		w := env.do(http.MethodGet, path, nil, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Fatalf("GET %s: content-type %q", path, ct)
+		}
+	}
+
+	// STATIC_DIR: страницы с диска, недостающая — фирменная 404 (вшитая, если
+	// в каталоге её нет)
+	env.h.staticFS = fstest.MapFS{"catalog.html": {Data: []byte("<html>local catalog</html>")}}
+	w := env.do(http.MethodGet, "/catalog", nil, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "local catalog") {
+		t.Fatalf("override: status %d, body %q", w.Code, w.Body.String())
+	}
+	for _, path := range []string{"/map-view", "/no-such-page"} {
+		w = env.do(http.MethodGet, path, nil, nil)
+		if w.Code != http.StatusNotFound || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") ||
+			!strings.Contains(w.Body.String(), "Страница не найдена") {
+			t.Fatalf("GET %s: status %d, content-type %q", path, w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+
+	// неизвестная ручка API — 404 в формате ошибок API, а не HTML
+	w = env.do(http.MethodGet, "/api/no-such-endpoint", nil, nil)
+	var e struct {
+		Error string `json:"error"`
+	}
+	decode(t, w, &e)
+	if w.Code != http.StatusNotFound || e.Error != "endpoint not found" {
+		t.Fatalf("unknown api: status %d, error %q", w.Code, e.Error)
+	}
+}
+
+func TestE2EPriceDropNotifications(t *testing.T) {
+	env := newTestEnv(t)
+	env.h.cfg.PriceDropNotifyPercent = 10
+	env.seedStore("samal3", "Самал-3")
+	potato := env.seedProduct("Картофель", "veg", 200, "samal3")
+	onion := env.seedProduct("Лук", "veg", 100, "samal3")
+	env.exec(`UPDATE products SET emoji = '🥔', price_per = 'kg' WHERE id = ?`, potato)
+	for i, uid := range []int64{901, 902, 903} {
+		env.seedUser(uid, "samal3")
+		env.exec(`INSERT INTO orders (id, user_id, store_code, total_amount, status) VALUES (?, ?, 'samal3', 400, 'done')`, 100+i, uid)
+		env.exec(`INSERT INTO order_items (order_id, product_id, name, unit, qty, price, amount) VALUES (?, ?, 'Картофель', 'кг', 2, 200, 400)`, 100+i, potato)
+	}
+	// 903 заказывал давно, 902 отписался от рассылок
+	env.exec(`UPDATE orders SET created_at = ? WHERE id = 102`, time.Now().UTC().AddDate(0, 0, -40).Format(dbTimeLayout))
+	optOut := func(uid int64, headerID int64) int {
+		return env.do(http.MethodPost, "/api/user/marketing",
+			map[string]any{"telegram_id": uid, "opt_out": true},
+			map[string]string{"X-Telegram-Id": fmt.Sprint(headerID)}).Code
+	}
+	if code := optOut(902, 901); code != http.StatusForbidden {
+		t.Fatalf("opt-out for someone else: status %d", code)
+	}
+	if code := optOut(902, 902); code != http.StatusOK {
+		t.Fatalf("opt-out: status %d", code)
+	}
+
+	w := env.do(http.MethodPost, "/api/admin/products/bulk-price", map[string]any{
+		"prices": []map[string]int64{{"product_id": potato, "price": 150}, {"product_id": onion, "price": 95}},
+	}, env.admin())
+	if w.Code != http.StatusOK {
+		t.Fatalf("bulk price: %d %s", w.Code, w.Body.String())
+	}
+	msgs := waitMessages(t, env.sender, 901, 1)
+	if len(msgs) != 1 || !strings.HasPrefix(msgs[0], "📉 Цены снизились:") ||
+		!strings.Contains(msgs[0], "🥔 Картофель 200→150") || strings.Contains(msgs[0], "Лук") {
+		t.Fatalf("messages to 901 = %q", msgs)
+	}
+	var queued int
+	_ = env.h.db.QueryRow(`SELECT COUNT(1) FROM notification_outbox`).Scan(&queued)
+	if queued != 1 {
+		t.Fatalf("queued = %d, want 1 (opted-out and old customers are skipped)", queued)
+	}
+
+	// второе снижение в те же сутки — без сообщения
+	env.h.notifyPriceDrops(context.Background(), []priceDrop{{ProductID: potato, OldPrice: 150, NewPrice: 100}})
+	_ = env.h.db.QueryRow(`SELECT COUNT(1) FROM notification_outbox`).Scan(&queued)
+	if queued != 1 {
+		t.Fatalf("queued = %d after second drop, want 1", queued)
+	}
+
+	// сутки прошли, но снижение меньше порога
+	env.exec(`UPDATE users SET price_drop_notified_at = NULL`)
+	env.h.notifyPriceDrops(context.Background(), []priceDrop{{ProductID: potato, OldPrice: 150, NewPrice: 140}})
+	_ = env.h.db.QueryRow(`SELECT COUNT(1) FROM notification_outbox`).Scan(&queued)
+	if queued != 1 {
+		t.Fatalf("queued = %d after small drop, want 1", queued)
+	}
+}
+
+func TestE2EAddressSuggest(t *testing.T) {
+	env := newTestEnv(t)
+	var hits int
+	var got url.Values
+	yandex := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		got = r.URL.Query()
+		var results []string
+		for i := 1; i <= 6; i++ {
+			results = append(results, fmt.Sprintf(`{"title":{"text":"проспект Абая, %d"},"subtitle":{"text":"Алматы, Казахстан"},"uri":"ymapsbm1://geo?id=%d"}`, i, i))
+		}
+		fmt.Fprintf(w, `{"results":[%s]}`, strings.Join(results, ","))
+	}))
+	defer yandex.Close()
+	env.h.suggestURL = yandex.URL
+
+	if w := env.do(http.MethodGet, "/api/address/suggest?q=Алматы+Абая", nil, nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("without api key: status %d", w.Code)
+	}
+	env.h.cfg.YandexAPIKey = "yandex-key"
+
+	type suggestion struct {
+		Title    string `json:"title"`
+		Subtitle string `json:"subtitle"`
+		URI      string `json:"uri"`
+	}
+	var out []suggestion
+	w := env.do(http.MethodGet, "/api/address/suggest?q=Алматы+Абая", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("suggest: %d %s", w.Code, w.Body.String())
+	}
+	decode(t, w, &out)
+	if len(out) != 5 || out[0].Title != "проспект Абая, 1" || out[0].Subtitle != "Алматы, Казахстан" || out[0].URI != "ymapsbm1://geo?id=1" {
+		t.Fatalf("suggestions = %+v", out)
+	}
+	if got.Get("apikey") != "yandex-key" || got.Get("text") != "алматы абая" || got.Get("results") != "5" {
+		t.Fatalf("yandex query = %v", got)
+	}
+
+	// тот же запрос в другом регистре и с пробелами — из кэша
+	w = env.do(http.MethodGet, "/api/address/suggest?q="+url.QueryEscape("  алматы   АБАЯ "), nil, nil)
+	decode(t, w, &out)
+	if w.Code != http.StatusOK || len(out) != 5 || hits != 1 {
+		t.Fatalf("cached suggest: status %d, %d items, %d upstream hits", w.Code, len(out), hits)
+	}
+	if ttl := env.redis.TTL(repository.SuggestCacheKey("алматы абая")); ttl != time.Hour {
+		t.Fatalf("cache ttl = %v", ttl)
+	}
+
+	// пара букв — пустой список без похода в Яндекс
+	w = env.do(http.MethodGet, "/api/address/suggest?q=Ал", nil, nil)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" || hits != 1 {
+		t.Fatalf("short query: status %d, body %q, hits %d", w.Code, w.Body.String(), hits)
+	}
+}
+
+func TestE2EOrderSizeLimits(t *testing.T) {
+	env := newTestEnv(t)
+	env.h.notifier.window = 50 * time.Millisecond
+	env.h.cfg.OrderMaxItemQty = 100
+	env.h.cfg.OrderMaxItems = 3
+	env.h.cfg.OrderMaxTotal = 100_000
+	env.h.cfg.OrderReviewItemQty = 30
+	env.h.cfg.OrderReviewTotal = 50_000
+	env.seedStore("samal3", "Самал-3")
+	potato := env.seedProduct("Картофель", "vegetables", 250, "samal3")
+	dill := env.seedProduct("Укроп", "greens", 300, "samal3")
+	honey := env.seedProduct("Мёд", "honey", 1100, "samal3")
+	env.seedUser(555, "samal3")
+	env.exec(`UPDATE products SET max_qty = 5 WHERE id = ?`, dill)
+
+	confirm := func(items ...map[string]any) *httptest.ResponseRecorder {
+		return env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+			"telegram_id":    "555",
+			"payment_method": "kaspi_transfer",
+			"items":          items,
+			"delivery":       map[string]any{"type": "pickup"},
+		}, nil)
+	}
+	item := func(id int64, name string, qty float64, price int64) map[string]any {
+		return map[string]any{"product_id": id, "name": name, "qty": qty, "unit": "кг", "price": price}
+	}
+	rejected := func(w *httptest.ResponseRecorder, limit string) {
+		t.Helper()
+		var out struct {
+			Code  string `json:"code"`
+			Limit string `json:"limit"`
+		}
+		decode(t, w, &out)
+		if w.Code != http.StatusBadRequest || out.Code != "order_limit_exceeded" || out.Limit != limit {
+			t.Fatalf("%s limit = %d %s", limit, w.Code, w.Body.String())
+		}
+	}
+
+	// 500 кг картофеля — опечатка, а не заказ
+	rejected(confirm(item(potato, "Картофель", 500, 250)), "item_qty")
+	// у укропа свой предел — 5 кг
+	rejected(confirm(item(dill, "Укроп", 6, 300)), "item_qty")
+	rejected(confirm(
+		item(potato, "Картофель", 1, 250),
+		map[string]any{"name": "Соль", "qty": 1, "unit": "кг", "price": 100},
+		map[string]any{"name": "Сахар", "qty": 1, "unit": "кг", "price": 100},
+		item(dill, "Укроп", 1, 300),
+	), "items")
+	rejected(confirm(item(honey, "Мёд", 99, 1100)), "total")
+	var orders int
+	_ = env.h.db.QueryRow(`SELECT COUNT(1) FROM orders`).Scan(&orders)
+	if orders != 0 {
+		t.Fatalf("rejected orders were saved: %d", orders)
+	}
+
+	// 40 кг — больше мягкого предела: заказ ждёт проверки наличия
+	w := confirm(item(potato, "Картофель", 40, 250))
+	var out struct {
+		OrderID     int64  `json:"order_id"`
+		OrderStatus string `json:"order_status"`
+	}
+	decode(t, w, &out)
+	if w.Code != http.StatusOK || out.OrderStatus != orderReview {
+		t.Fatalf("review order = %d %s", w.Code, w.Body.String())
+	}
+	var status string
+	_ = env.h.db.QueryRow(`SELECT status FROM orders WHERE id = ?`, out.OrderID).Scan(&status)
+	if status != orderReview {
+		t.Fatalf("status = %q, want review", status)
+	}
+	if msgs := env.sender.MessagesTo(555); len(msgs) != 1 || !strings.Contains(msgs[0], "проверит наличие") {
+		t.Fatalf("customer messages = %q", msgs)
+	}
+	admin := waitMessages(t, env.sender, testAdminID, 1)
+	if !strings.Contains(admin[0], "нужна проверка наличия") || !strings.Contains(admin[0], "Картофель — 40 кг") {
+		t.Fatalf("admin card = %q", admin)
+	}
+	var confirmButton bool
+	for _, m := range env.sender.Messages {
+		if kb, ok := m.ReplyMarkup.(*models.InlineKeyboardMarkup); ok && m.ChatID == testAdminID {
+			for _, row := range kb.InlineKeyboard {
+				for _, b := range row {
+					confirmButton = confirmButton || b.CallbackData == fmt.Sprintf("ord_new:%d", out.OrderID)
+				}
+			}
+		}
+	}
+	if !confirmButton {
+		t.Fatal("admin card has no «Подтвердить наличие» button")
+	}
+
+	// админ подтвердил наличие — покупатель получает чек с реквизитами
+	w = env.do(http.MethodPost, "/api/admin/orders/status", map[string]any{"order_id": out.OrderID, "status": "new"}, env.admin())
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm availability = %d %s", w.Code, w.Body.String())
+	}
+	if msgs := env.sender.MessagesTo(555); len(msgs) != 2 || !strings.Contains(msgs[1], "Итого к оплате: "+formatMoney(10_000)) {
+		t.Fatalf("receipt after review = %q", msgs)
+	}
+}
+
+func TestE2EOrderHistoryCurrentPrices(t *testing.T) {
+	env := newTestEnv(t)
+	clock := &fakeClock{t: time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC)}
+	env.h.SetClock(clock)
+	env.seedStore("samal3", "Самал-3")
+	env.seedUser(901, "samal3")
+	tomato := env.seedProduct("Томаты", "veg", 600, "samal3")
+	cucumber := env.seedProduct("Огурцы", "veg", 400, "samal3")
+	dill := env.seedProduct("Укроп", "greens", 300, "samal3")
+	potato := env.seedProduct("Картофель", "veg", 250, "samal3")
+	env.exec(`INSERT INTO orders (id, user_id, store_code, total_amount, status) VALUES (90, 901, 'samal3', 1550, 'done')`)
+	env.exec(`INSERT INTO order_items (order_id, product_id, name, unit, qty, price, amount) VALUES
+		(90, ?, 'Томаты', 'кг', 1, 600, 600),
+		(90, ?, 'Огурцы', 'кг', 1, 400, 400),
+		(90, ?, 'Укроп', 'кг', 1, 300, 300),
+		(90, ?, 'Картофель', 'кг', 1, 250, 250)`, tomato, cucumber, dill, potato)
+
+	// томаты подорожали, на огурцы акция, укроп сняли с продажи, картофель как был
+	env.exec(`UPDATE products SET price = 650 WHERE id = ?`, tomato)
+	env.exec(`INSERT INTO promotions (product_id, promo_price, starts_at, ends_at) VALUES (?, 350, '2025-04-01 00:00:00', '2025-04-20 00:00:00')`, cucumber)
+	env.exec(`UPDATE products SET active = 0 WHERE id = ?`, dill)
+
+	type item struct {
+		Name         string `json:"name"`
+		Price        int64  `json:"price"`
+		PriceAtOrder int64  `json:"price_at_order"`
+		CurrentPrice *int64 `json:"current_price"`
+		PriceChanged bool   `json:"price_changed"`
+	}
+	var history []struct {
+		Items []item `json:"items"`
+	}
+	decode(t, env.do(http.MethodGet, "/api/user/orders", nil, map[string]string{"X-Telegram-Id": "901"}), &history)
+	if len(history) != 1 || len(history[0].Items) != 4 {
+		t.Fatalf("history = %+v", history)
+	}
+	price := func(v int64) *int64 { return &v }
+	type current struct {
+		price   *int64
+		changed bool
+	}
+	want := map[string]current{
+		"Томаты":    {price(650), true},
+		"Огурцы":    {price(350), true},
+		"Укроп":     {nil, false},
+		"Картофель": {price(250), false},
+	}
+	check := func() {
+		t.Helper()
+		for _, it := range history[0].Items {
+			w := want[it.Name]
+			if it.PriceAtOrder != it.Price || it.PriceChanged != w.changed ||
+				(it.CurrentPrice == nil) != (w.price == nil) || (w.price != nil && *it.CurrentPrice != *w.price) {
+				t.Errorf("%s = %+v", it.Name, it)
+			}
+		}
+	}
+	check()
+
+	// без подписки — цены гостя: розничная у картофеля, томаты только по подписке
+	env.exec(`UPDATE products SET retail_price = 320 WHERE id = ?`, potato)
+	env.exec(`UPDATE products SET subscriber_only = 1 WHERE id = ?`, tomato)
+	decode(t, env.do(http.MethodGet, "/api/user/orders", nil, map[string]string{"X-Telegram-Id": "901"}), &history)
+	want["Томаты"] = current{nil, false}
+	want["Картофель"] = current{price(320), true}
+	check()
+
+	// подписчик видит оптовые цены
+	env.exec(`UPDATE users SET sub_status = 'active', sub_until = ? WHERE user_id = 901`, clock.Now().Add(72*time.Hour))
+	decode(t, env.do(http.MethodGet, "/api/user/orders", nil, map[string]string{"X-Telegram-Id": "901"}), &history)
+	want["Томаты"] = current{price(650), true}
+	want["Картофель"] = current{price(250), false}
+	check()
+}
+
+func TestE2EAdminActAs(t *testing.T) {
+	env := newTestEnv(t)
+	env.h.notifier.window = 50 * time.Millisecond
+	env.seedStore("samal3", "Самал-3")
+	env.seedStore("aksai", "Аксай")
+	potato := env.seedProduct("Картофель", "vegetables", 250, "samal3")
+	env.seedProduct("Укроп", "greens", 300, "aksai")
+	env.seedUser(555, "samal3")
+	env.seedUser(777, "aksai")
+	actAs := func(caller int64) map[string]string {
+		return map[string]string{"X-Telegram-Id": fmt.Sprint(caller), "X-Act-As": "555"}
+	}
+
+	// только суперадмин и только пользовательские ручки
+	if w := env.do(http.MethodGet, "/api/products", nil, actAs(777)); w.Code != http.StatusForbidden {
+		t.Fatalf("customer acting as another = %d", w.Code)
+	}
+	if w := env.do(http.MethodGet, "/api/admin/orders", nil, actAs(testAdminID)); w.Code != http.StatusBadRequest {
+		t.Fatalf("act as on admin endpoint = %d", w.Code)
+	}
+	var errBody struct{ Code string }
+	w := env.do(http.MethodPost, "/api/user/marketing", map[string]any{"telegram_id": 555, "opt_out": true}, actAs(testAdminID))
+	decode(t, w, &errBody)
+	if w.Code != http.StatusForbidden || errBody.Code != "act_as_read_only" {
+		t.Fatalf("write as customer = %d %+v", w.Code, errBody)
+	}
+
+	// каталог — точки покупателя, а не админа
+	var products []productOut
+	decode(t, env.do(http.MethodGet, "/api/products", nil, actAs(testAdminID)), &products)
+	if len(products) != 1 || products[0].ID != potato {
+		t.Fatalf("catalog as customer = %+v", products)
+	}
+
+	// заказ проходит все проверки, но не сохраняется и никого не уведомляет
+	w = env.do(http.MethodPost, "/api/orders/confirm", map[string]any{
+		"telegram_id":    fmt.Sprint(testAdminID),
+		"payment_method": "kaspi_transfer",
+		"items":          []map[string]any{{"product_id": potato, "name": "Картофель", "qty": 3, "unit": "кг", "price": 250}},
+		"delivery":       map[string]any{"type": "pickup"},
+	}, actAs(testAdminID))
+	var out struct {
+		DryRun  bool   `json:"dry_run"`
+		OrderID int64  `json:"order_id"`
+		Total   int64  `json:"total"`
+		Status  string `json:"order_status"`
+	}
+	decode(t, w, &out)
+	if w.Code != http.StatusOK || !out.DryRun || out.OrderID != 0 || out.Total != 750 || out.Status != "new" {
+		t.Fatalf("dry run confirm = %d %s", w.Code, w.Body.String())
+	}
+	w = env.do(http.MethodPost, "/api/orders/create", map[string]any{
+		"telegram_id": 555,
+		"items":       []map[string]any{{"product_id": potato, "name": "Картофель", "qty": 1, "unit": "кг", "price": 250}},
+	}, actAs(testAdminID))
+	decode(t, w, &out)
+	if w.Code != http.StatusOK || !out.DryRun || out.Total != 250 {
+		t.Fatalf("dry run create = %d %s", w.Code, w.Body.String())
+	}
+	var orders int
+	_ = env.h.db.QueryRow(`SELECT COUNT(1) FROM orders`).Scan(&orders)
+	if orders != 0 {
+		t.Fatalf("dry run saved %d orders", orders)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if msgs := env.sender.MessagesTo(555); len(msgs) != 0 {
+		t.Fatalf("customer notified: %q", msgs)
+	}
+	if msgs := env.sender.MessagesTo(testAdminID); len(msgs) != 0 {
+		t.Fatalf("admin notified: %q", msgs)
+	}
+	if st, _ := env.h.redisClient.GetUserState(context.Background(), 555); st != nil && st.State == stateWaitingPayment {
+		t.Fatalf("user state = %+v", st)
+	}
+
+	// каждый запрос от имени покупателя — в журнале с обоими ID
+	var audits int
+	_ = env.h.db.QueryRow(`SELECT COUNT(1) FROM audit_log WHERE action = 'user.act_as' AND admin_id = ? AND target = 'user:555'`, testAdminID).Scan(&audits)
+	if audits != 3 {
+		t.Fatalf("act as audit entries = %d, want 3", audits)
+	}
+}
+
+func TestE2EProductMargins(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedStore("samal3", "Самал-3")
+	post := func(path string, fields map[string]string) *httptest.ResponseRecorder {
+		body, ct := env.multipart(fields)
+		h := env.admin()
+		h["Content-Type"] = ct
+		return env.do(http.MethodPost, path, body, h)
+	}
+	add := func(name, cat, price, cost string) {
+		t.Helper()
+		fields := map[string]string{"name": name, "category": cat, "unit": "кг", "price": price, "store_code": "samal3"}
+		if cost != "" {
+			fields["cost_price"] = cost
+		}
+		if w := post("/api/admin/products/add", fields); w.Code != http.StatusOK {
+			t.Fatalf("add %s = %d %s", name, w.Code, w.Body.String())
+		}
+	}
+	if w := post("/api/admin/products/add", map[string]string{
+		"name": "Лук", "category": "vegetables", "unit": "кг", "price": "200", "store_code": "samal3", "cost_price": "-1",
+	}); w.Code != http.StatusBadRequest {
+		t.Fatalf("negative cost_price = %d", w.Code)
+	}
+	add("Картофель", "vegetables", "300", "200")
+	add("Морковь", "vegetables", "300", "270")
+	add("Лук", "vegetables", "150", "")
+	add("Укроп", "greens", "400", "100")
+
+	type product struct {
+		ID        int64    `json:"id"`
+		Name      string   `json:"name"`
+		CostPrice *int64   `json:"cost_price"`
+		MarginKZT *int64   `json:"margin_kzt"`
+		MarginPct *float64 `json:"margin_pct"`
+	}
+	var list []product
+	decode(t, env.do(http.MethodGet, "/api/admin/products", nil, env.admin()), &list)
+	byName := map[string]product{}
+	for _, p := range list {
+		byName[p.Name] = p
+	}
+	if p := byName["Картофель"]; p.CostPrice == nil || *p.CostPrice != 200 || *p.MarginKZT != 100 || *p.MarginPct != 33.3 {
+		t.Fatalf("potato = %+v", p)
+	}
+	if p := byName["Лук"]; p.CostPrice != nil || p.MarginKZT != nil || p.MarginPct != nil {
+		t.Fatalf("onion without cost = %+v", p)
+	}
+
+	// форма без cost_price закупочную цену не сбрасывает, пустое поле — сбрасывает
+	carrot := byName["Морковь"].ID
+	fields := map[string]string{"id": fmt.Sprint(carrot), "name": "Морковь", "category": "vegetables", "unit": "кг", "price": "360", "store_code": "samal3"}
+	if w := post("/api/admin/products/update", fields); w.Code != http.StatusOK {
+		t.Fatalf("update = %d %s", w.Code, w.Body.String())
+	}
+	var got product
+	decode(t, env.do(http.MethodGet, fmt.Sprintf("/api/admin/products/get?id=%d", carrot), nil, env.admin()), &got)
+	if got.CostPrice == nil || *got.CostPrice != 270 || *got.MarginKZT != 90 || *got.MarginPct != 25 {
+		t.Fatalf("carrot after update = %+v", got)
+	}
+
+	// закупочная цена — только для админки
+	w := env.do(http.MethodGet, "/api/products", nil, nil)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "cost_price") || strings.Contains(w.Body.String(), "margin") {
+		t.Fatalf("public catalog leaks cost price: %s", w.Body.String())
+	}
+
+	var margins []categoryMarginOut
+	if w := env.do(http.MethodGet, "/api/admin/stats/margins", nil, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("margins without admin = %d", w.Code)
+	}
+	decode(t, env.do(http.MethodGet, "/api/admin/stats/margins", nil, env.admin()), &margins)
+	want := []categoryMarginOut{
+		{Category: "greens", ProductCount: 1, AvgMarginKZT: 300, AvgMarginPct: 75, MinMarginPct: 75},
+		// (33.3 + 25) / 2; Лук без закупочной цены в среднее не входит
+		{Category: "vegetables", ProductCount: 2, AvgMarginKZT: 95, AvgMarginPct: 29.2, MinMarginPct: 25, WithoutCost: 1},
+	}
+	if !slices.Equal(margins, want) {
+		t.Fatalf("margins = %+v", margins)
+	}
+	decode(t, env.do(http.MethodGet, "/api/admin/stats/margins?category=greens", nil, env.admin()), &margins)
+	if len(margins) != 1 || margins[0].Category != "greens" {
+		t.Fatalf("greens margins = %+v", margins)
+	}
+}
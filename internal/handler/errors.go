@@ -0,0 +1,109 @@
+// handler/errors.go
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// AppError — ошибка HTTP-ручки: код ответа, сообщение для клиента и подробности.
+// Detail (обычно текст Go-ошибки) отдаётся клиенту только при LOG_LEVEL=debug.
+type AppError struct {
+	Code    int
+	Message string
+	Detail  string
+	ErrCode string // машинный код для мини-аппа, например unpaid_order_in_store
+	Fields  map[string]any
+}
+
+func (e *AppError) Error() string {
+	if e.Detail == "" {
+		return e.Message
+	}
+	return e.Message + ": " + e.Detail
+}
+
+// Wrap добавляет к ошибке исходную Go-ошибку как Detail.
+func (e *AppError) Wrap(err error) *AppError {
+	if err != nil {
+		e.Detail = err.Error()
+	}
+	return e
+}
+
+// WithCode задаёт машинный код ошибки (поле "code" в ответе).
+func (e *AppError) WithCode(code string) *AppError {
+	e.ErrCode = code
+	return e
+}
+
+// WithField добавляет в ответ поле с подробностями для мини-аппа
+// (например, список позиций, которые нельзя заказать).
+func (e *AppError) WithField(key string, v any) *AppError {
+	if e.Fields == nil {
+		e.Fields = map[string]any{}
+	}
+	e.Fields[key] = v
+	return e
+}
+
+func ErrBadRequest(msg string) *AppError {
+	return &AppError{Code: http.StatusBadRequest, Message: msg}
+}
+
+// ErrNotFound — "<entity> not found".
+func ErrNotFound(entity string) *AppError {
+	return &AppError{Code: http.StatusNotFound, Message: entity + " not found"}
+}
+
+// ErrInternal — внутренняя ошибка (БД и т.п.); текст err виден только в debug.
+func ErrInternal(err error) *AppError {
+	return (&AppError{Code: http.StatusInternalServerError, Message: "internal error"}).Wrap(err)
+}
+
+func ErrForbidden() *AppError {
+	return &AppError{Code: http.StatusForbidden, Message: "forbidden"}
+}
+
+func ErrUnauthorized(msg string) *AppError {
+	return &AppError{Code: http.StatusUnauthorized, Message: msg}
+}
+
+func ErrConflict(msg string) *AppError {
+	return &AppError{Code: http.StatusConflict, Message: msg}
+}
+
+// ErrServiceUnavailable — приём временно остановлен (например, режим обслуживания).
+func ErrServiceUnavailable(msg string) *AppError {
+	return &AppError{Code: http.StatusServiceUnavailable, Message: msg}
+}
+
+// ErrTooManyRequests — повторный запрос раньше, чем разрешено.
+func ErrTooManyRequests(msg string) *AppError {
+	return &AppError{Code: http.StatusTooManyRequests, Message: msg}
+}
+
+func ErrMethodNotAllowed() *AppError {
+	return &AppError{Code: http.StatusMethodNotAllowed, Message: "method not allowed"}
+}
+
+// exposeErrorDetail включается в NewHandler при LOG_LEVEL=debug.
+var exposeErrorDetail atomic.Bool
+
+// writeError отвечает JSON {error, code, detail, ...Fields}; в production detail не отдаётся.
+func writeError(w http.ResponseWriter, err *AppError) {
+	body := map[string]any{"error": err.Message}
+	for k, v := range err.Fields {
+		body[k] = v
+	}
+	if err.ErrCode != "" {
+		body["code"] = err.ErrCode
+	}
+	if exposeErrorDetail.Load() && err.Detail != "" {
+		body["detail"] = err.Detail
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(err.Code)
+	_ = json.NewEncoder(w).Encode(body)
+}
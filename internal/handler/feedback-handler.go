@@ -0,0 +1,213 @@
+// handler/feedback-handler.go
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+var (
+	// errFeedbackExists — отзыв на этот заказ уже оставлен.
+	errFeedbackExists = errors.New("feedback already submitted")
+	// errOrderNotRateable — заказа нет, он чужой или ещё не выполнен.
+	errOrderNotRateable = errors.New("only your own completed order can be rated")
+)
+
+type orderFeedbackIn struct {
+	TelegramID json.RawMessage `json:"telegram_id"`
+	OrderID    int64           `json:"order_id"`
+	Rating     int             `json:"rating"`
+	Comment    string          `json:"comment"`
+}
+
+// saveOrderFeedback сохраняет оценку 1–5 на выполненный заказ пользователя.
+// Один заказ — один отзыв (order_id — первичный ключ order_feedback).
+func (h *Handler) saveOrderFeedback(userID, orderID int64, rating int, comment string) error {
+	var owner int64
+	var status string
+	err := h.db.QueryRow(`SELECT user_id, status FROM orders WHERE id = ?`, orderID).Scan(&owner, &status)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && (owner != userID || status != orderDone)) {
+		return errOrderNotRateable
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := h.db.Exec(`
+		INSERT INTO order_feedback (order_id, rating, comment) VALUES (?, ?, ?) ON CONFLICT DO NOTHING
+	`, orderID, rating, nullString(strings.TrimSpace(comment)))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errFeedbackExists
+	}
+	return nil
+}
+
+// POST /api/orders/feedback — оценка выполненного заказа.
+func (h *Handler) handleOrderFeedback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, ErrMethodNotAllowed())
+		return
+	}
+	var in orderFeedbackIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	tgID, err := parseTelegramID(in.TelegramID)
+	if err != nil || in.OrderID <= 0 {
+		writeError(w, ErrBadRequest("telegram_id and order_id are required"))
+		return
+	}
+	if in.Rating < 1 || in.Rating > 5 {
+		writeError(w, ErrBadRequest("rating must be 1..5"))
+		return
+	}
+	if len([]rune(in.Comment)) > 1000 {
+		writeError(w, ErrBadRequest("comment is too long"))
+		return
+	}
+
+	err = h.saveOrderFeedback(tgID, in.OrderID, in.Rating, in.Comment)
+	switch {
+	case errors.Is(err, errOrderNotRateable):
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	case errors.Is(err, errFeedbackExists):
+		writeError(w, ErrConflict(err.Error()))
+		return
+	case err != nil:
+		h.logger.Error("save order feedback", zap.Int64("order_id", in.OrderID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, map[string]string{"status": "ok"})
+}
+
+// feedbackMarkup — звёзды под сообщением «Заказ выполнен» (fb_<orderID>:<rating>).
+func feedbackMarkup(orderID int64) *models.InlineKeyboardMarkup {
+	row := make([]models.InlineKeyboardButton, 0, 5)
+	for i := 1; i <= 5; i++ {
+		row = append(row, models.InlineKeyboardButton{
+			Text:         strings.Repeat("⭐", i),
+			CallbackData: fmt.Sprintf("fb_%d:%d", orderID, i),
+		})
+	}
+	// по одной оценке в строке: пять звёзд в ряд не помещаются
+	kb := &models.InlineKeyboardMarkup{}
+	for _, b := range row {
+		kb.InlineKeyboard = append(kb.InlineKeyboard, []models.InlineKeyboardButton{b})
+	}
+	return kb
+}
+
+// FeedbackCallbackHandler — оценка заказа кнопкой из чата.
+// Регистрация:
+// bot.WithCallbackQueryDataHandler("fb_", bot.MatchTypePrefix, handl.FeedbackCallbackHandler),
+func (h *Handler) FeedbackCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	cq := update.CallbackQuery
+	if cq == nil {
+		return
+	}
+	idStr, ratingStr, ok := strings.Cut(strings.TrimPrefix(cq.Data, "fb_"), ":")
+	orderID, err1 := strconv.ParseInt(idStr, 10, 64)
+	rating, err2 := strconv.Atoi(ratingStr)
+	if !ok || err1 != nil || err2 != nil || rating < 1 || rating > 5 {
+		return
+	}
+
+	text := "Спасибо за оценку! 🙏"
+	err := h.saveOrderFeedback(cq.From.ID, orderID, rating, "")
+	switch {
+	case errors.Is(err, errFeedbackExists):
+		text = "Вы уже оценили этот заказ"
+	case errors.Is(err, errOrderNotRateable):
+		text = "Этот заказ нельзя оценить"
+	case err != nil:
+		h.logger.Error("save order feedback", zap.Int64("order_id", orderID), zap.Error(err))
+		text = "Не удалось сохранить оценку, попробуйте ещё раз"
+	}
+	_, _ = h.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		CallbackQueryID: cq.ID,
+		Text:            text,
+	})
+
+	// убираем звёзды, чтобы не оценивать повторно
+	if msg := cq.Message.Message; msg != nil && (err == nil || errors.Is(err, errFeedbackExists)) {
+		_, err := h.sender.EditMessageReplyMarkup(ctx, &bot.EditMessageReplyMarkupParams{
+			ChatID:      msg.Chat.ID,
+			MessageID:   msg.ID,
+			ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{}},
+		})
+		if err != nil {
+			h.logger.Warn("remove feedback buttons", zap.Int64("order_id", orderID), zap.Error(err))
+		}
+	}
+}
+
+// GET /api/admin/stats — заказы, средняя оценка и уходы на другую точку по точкам.
+func (h *Handler) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	rows, err := h.db.Query(`
+		SELECT COALESCE(o.store_code, ''), COALESCE(s.name, ''),
+		       COUNT(o.id),
+		       SUM(CASE WHEN o.status = 'done' THEN 1 ELSE 0 END),
+		       COUNT(f.order_id),
+		       AVG(f.rating),
+		       (SELECT COUNT(1) FROM users u WHERE u.previous_store = o.store_code)
+		FROM orders o
+		LEFT JOIN stores s ON s.code = o.store_code
+		LEFT JOIN order_feedback f ON f.order_id = o.id
+		GROUP BY o.store_code, s.name
+		ORDER BY o.store_code
+	`)
+	if err != nil {
+		h.logger.Error("admin stats", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer rows.Close()
+
+	type storeStats struct {
+		StoreCode string   `json:"store_code"`
+		StoreName string   `json:"store_name"`
+		Orders    int64    `json:"orders"`
+		Done      int64    `json:"done"`
+		Ratings   int64    `json:"ratings"`
+		AvgRating *float64 `json:"avg_rating"`    // null — оценок ещё нет
+		Switched  int64    `json:"switched_away"` // пользователей, сменивших эту точку на другую
+	}
+	stores := []storeStats{}
+	for rows.Next() {
+		var (
+			s   storeStats
+			avg sql.NullFloat64
+		)
+		if err := rows.Scan(&s.StoreCode, &s.StoreName, &s.Orders, &s.Done, &s.Ratings, &avg, &s.Switched); err != nil {
+			h.logger.Error("scan admin stats", zap.Error(err))
+			continue
+		}
+		if avg.Valid {
+			v := math.Round(avg.Float64*100) / 100
+			s.AvgRating = &v
+		}
+		stores = append(stores, s)
+	}
+	jsonOK(w, map[string]any{"stores": stores})
+}
@@ -0,0 +1,93 @@
+// handler/format.go
+package handler
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// nbsp — неразрывный пробел: «125 000 ₸» не переносится по строкам в Telegram.
+const nbsp = " "
+
+// displayFormat — локаль и часовой пояс для текстов пользователю и админу.
+type displayFormat struct {
+	loc          *time.Location
+	dateLayout   string
+	thousandsSep string
+}
+
+// форматы по локали (LOCALE); неизвестная локаль — как ru
+var displayFormats = map[string]displayFormat{
+	"ru": {dateLayout: "02.01.2006", thousandsSep: nbsp},
+	"kk": {dateLayout: "02.01.2006", thousandsSep: nbsp},
+	"en": {dateLayout: "Jan 2, 2006", thousandsSep: ","},
+}
+
+var currentDisplay atomic.Pointer[displayFormat]
+
+// setDisplayFormat вызывается из NewHandler по cfg.Locale / cfg.Timezone.
+func setDisplayFormat(locale, timezone string) {
+	f, ok := displayFormats[strings.ToLower(strings.TrimSpace(locale))]
+	if !ok {
+		f = displayFormats["ru"]
+	}
+	f.loc = time.Local
+	if timezone != "" {
+		if loc, err := time.LoadLocation(timezone); err == nil {
+			f.loc = loc
+		}
+	}
+	currentDisplay.Store(&f)
+}
+
+func display() displayFormat {
+	if f := currentDisplay.Load(); f != nil {
+		return *f
+	}
+	f := displayFormats["ru"]
+	f.loc = time.Local
+	return f
+}
+
+// formatMoney — сумма в тенге с разбивкой на тысячи: 125000 → «125 000 ₸».
+func formatMoney(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	digits := strconv.FormatInt(amount, 10)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(display().thousandsSep)
+		}
+		b.WriteRune(d)
+	}
+	return sign + b.String() + nbsp + "₸"
+}
+
+// formatDate — дата в часовом поясе и формате настроенной локали.
+func formatDate(t time.Time) string {
+	f := display()
+	return t.In(f.loc).Format(f.dateLayout)
+}
+
+// ruPlural выбирает форму слова для числа n: 1 заказ, 2 заказа, 5 заказов.
+func ruPlural(n int, one, few, many string) string {
+	n %= 100
+	if n < 0 {
+		n = -n
+	}
+	switch {
+	case n >= 11 && n <= 14:
+		return many
+	case n%10 == 1:
+		return one
+	case n%10 >= 2 && n%10 <= 4:
+		return few
+	default:
+		return many
+	}
+}
@@ -5,19 +5,28 @@ import (
 	"agro/config"
 	"agro/internal/domain"
 	"agro/internal/repository"
+	"agro/static"
+	"agro/traits/database"
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"io"
-	"log"
+	"io/fs"
+	"math"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-telegram/bot"
@@ -33,6 +42,9 @@ const (
 	paymentCash          = "cash"
 )
 
+// цена месячной подписки, ₸ (заявка через /api/subscribe/request-invoice)
+const subscriptionMonthlyPrice = 3000
+
 // реквизиты Kaspi Gold (можно поменять под себя)
 const (
 	kaspiGoldNumber    = "4400 4301 1234 5678"
@@ -52,30 +64,94 @@ type confirmOrderIn struct {
 	Items         []orderItemIn   `json:"items"`
 	Delivery      deliveryIn      `json:"delivery"`
 	PaymentMethod string          `json:"payment_method"` // kaspi_link | kaspi_transfer | cash
+	// товары других точек не отклонять, а разделить заказ на части по точкам
+	// (общий чек и одна оплата); без флага такая корзина — 400 items_not_in_store
+	SplitStores bool `json:"split_stores"`
 }
 
 type Handler struct {
-	logger      *zap.Logger
-	cfg         *config.Config
-	bot         *bot.Bot
-	ctx         context.Context
-	userRepo    *repository.UserRepository
-	redisClient *repository.ChatRepository
-	db          *sql.DB
+	logger        *zap.Logger
+	cfg           *config.Config
+	sender        Sender
+	clock         Clock
+	ctx           context.Context
+	userRepo      *repository.UserRepository
+	orderRepo     *repository.OrderRepository
+	redisClient   *repository.ChatRepository
+	db            *sql.DB
+	locks         *keyedMutex
+	orderEvents   *orderBroker
+	notifier      *adminNotifier
+	botReady      atomic.Bool  // отправитель подключён: сообщения уходят сразу, а не в outbox
+	telegram      *retrySender // повторы и предохранитель вокруг настоящего бота; nil в DRY_RUN и тестах
+	httpClient    *http.Client // исходящие запросы к геокодеру, с таймаутом HTTP_CLIENT_TIMEOUT_SECONDS
+	webhookClient *http.Client // вебхуки: тот же таймаут, но без соединений с внутренними адресами
+	staticFS      fs.FS        // страницы мини-аппа: вшитые в бинарник или каталог STATIC_DIR
+	suggestURL    string       // Yandex Suggest API; в тестах — httptest-сервер
 }
 
+// defaultHTTPClientTimeout — таймаут исходящих запросов, если в конфиге он не задан.
+const defaultHTTPClientTimeout = 10 * time.Second
+
 func NewHandler(logger *zap.Logger, cfg *config.Config, ctx context.Context, db *sql.DB, redisClient *repository.ChatRepository) *Handler {
+	exposeErrorDetail.Store(cfg != nil && strings.EqualFold(cfg.LogLevel, "debug"))
+	httpTimeout := defaultHTTPClientTimeout
+	var pages fs.FS = static.FS
+	if cfg != nil {
+		if cfg.StaticDir != "" {
+			pages = os.DirFS(cfg.StaticDir)
+		}
+		setDisplayFormat(cfg.Locale, cfg.Timezone)
+		if cfg.HTTPClientTimeout > 0 {
+			httpTimeout = cfg.HTTPClientTimeout
+		}
+	}
 	return &Handler{
-		logger:      logger,
-		cfg:         cfg,
-		ctx:         ctx,
-		userRepo:    repository.NewUserRepository(db),
-		redisClient: redisClient,
-		db:          db,
+		logger:        logger,
+		cfg:           cfg,
+		clock:         realClock{},
+		ctx:           ctx,
+		userRepo:      repository.NewUserRepository(db),
+		orderRepo:     repository.NewOrderRepository(db),
+		redisClient:   redisClient,
+		db:            db,
+		locks:         newKeyedMutex(),
+		orderEvents:   newOrderBroker(),
+		notifier:      newAdminNotifier(),
+		httpClient:    &http.Client{Timeout: httpTimeout},
+		webhookClient: newWebhookClient(httpTimeout),
+		staticFS:      pages,
+		suggestURL:    yandexSuggestURL,
+	}
+}
+
+// SetBot подключает настоящего бота (через retrySender); при DRY_RUN=true вместо него
+// ставится logSender, который только пишет в лог.
+// Вызывается до запуска веб-сервера и бота: h.sender потом только читается.
+// Накопленное в outbox, пока бота не было, отправляется в фоне.
+func (h *Handler) SetBot(b *bot.Bot) {
+	switch {
+	case h.cfg != nil && h.cfg.DryRun:
+		h.sender = NewLogSender(h.logger)
+	case b == nil:
+		h.sender = nil
+	default:
+		tg := newRetrySender(b, h.logger)
+		tg.onRecover = func() { h.flushOutboxQuiet(h.ctx) }
+		h.telegram = tg
+		h.sender = tg
+	}
+	h.botReady.Store(h.sender != nil)
+	if h.sender != nil {
+		go h.flushOutboxQuiet(h.ctx)
 	}
 }
 
-func (h *Handler) SetBot(b *bot.Bot) { h.bot = b }
+// SetSender подменяет отправителя сообщений (например, RecordingSender в тестах).
+func (h *Handler) SetSender(s Sender) {
+	h.sender = s
+	h.botReady.Store(s != nil)
+}
 
 // ======================== TELEGRAM HANDLERS ========================
 
@@ -101,46 +177,92 @@ func (h *Handler) DefaultHandler(ctx context.Context, b *bot.Bot, update *models
 		}
 	}
 
-	// 2) Обычное приветствие + кнопка mini-app
+	// 2) Пользователь поделился контактом — сохраняем номер вместо приветствия
+	if update.Message.Contact != nil && update.Message.From != nil {
+		h.handleSharedContact(ctx, update.Message)
+		return
+	}
+
+	// 3) Приветствие + кнопка mini-app; постоянным покупателям — по имени и с числом заказов
+	nickname, orders := h.welcomeUser(update.Message.From)
+
 	text := "👋 Привет! Добро пожаловать в «АГРО Клуб Оптовых Цен».\n" +
 		"Нажмите кнопку ниже, чтобы открыть мини-приложение и увидеть оптовые цены, оформить подписку и сделать заказ."
-
-	kb := &models.InlineKeyboardMarkup{
-		InlineKeyboard: [][]models.InlineKeyboardButton{
-			{
-				{
-					Text:   "🚀 Открыть мини-апп",
-					WebApp: &models.WebAppInfo{URL: h.cfg.MiniAppUrl},
-				},
-			},
-		},
+	openText := "🚀 Открыть мини-апп"
+	if orders > 0 {
+		text = fmt.Sprintf("👋 Добро пожаловать обратно, %s! У вас %d %s.\n"+
+			"Нажмите кнопку ниже, чтобы открыть мини-приложение и сделать новый заказ.",
+			nickname, orders, ruPlural(orders, "заказ", "заказа", "заказов"))
+		openText = fmt.Sprintf("🚀 Открыть мини-апп · заказов: %d", orders)
+	}
+	if on, msg := h.maintenanceState(); on {
+		text = msg + "\n\n" + text
 	}
 
-	if update.Message.From.ID == h.cfg.AdminID {
-		kb = &models.InlineKeyboardMarkup{
-			InlineKeyboard: [][]models.InlineKeyboardButton{
-				{
-					{
-						Text:   "🚀 Открыть мини-апп",
-						WebApp: &models.WebAppInfo{URL: h.cfg.MiniAppUrl},
-					},
-					{
-						Text:   "🛠 Admin",
-						WebApp: &models.WebAppInfo{URL: h.cfg.MiniAppUrlAdmin},
-					},
-				},
-			},
-		}
+	row := []models.InlineKeyboardButton{
+		{Text: openText, WebApp: &models.WebAppInfo{URL: h.cfg.MiniAppUrl}},
+	}
+	if h.cfg.IsSuperAdmin(update.Message.From.ID) {
+		row = append(row, models.InlineKeyboardButton{
+			Text:   "🛠 Admin",
+			WebApp: &models.WebAppInfo{URL: h.cfg.MiniAppUrlAdmin},
+		})
 	}
 
-	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+	_, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID:      update.Message.Chat.ID,
 		Text:        text,
-		ReplyMarkup: kb,
+		ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{row}},
 	})
 	if err != nil {
 		h.logger.Error("send welcome miniapp button", zap.Error(err))
 	}
+
+	// 4) Постоянное меню внизу чата: у сообщения одна клавиатура, поэтому отдельным сообщением
+	if _, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      update.Message.Chat.ID,
+		Text:        menuKeyboardHint,
+		ReplyMarkup: userMenuKeyboard(),
+	}); err != nil {
+		h.logger.Error("send user menu keyboard", zap.Error(err))
+	}
+}
+
+// welcomeUser заводит пользователя при первом сообщении (или проставляет ник,
+// если его ещё нет) и возвращает имя для приветствия и число его заказов.
+func (h *Handler) welcomeUser(from *models.User) (string, int) {
+	if from == nil {
+		return "", 0
+	}
+	nick := strings.TrimSpace(from.Username)
+	if nick == "" {
+		nick = strings.TrimSpace(from.FirstName)
+	}
+	if nick == "" {
+		nick = "user"
+	}
+
+	_, err := h.db.Exec(`
+		INSERT INTO users (id, user_id, nickname)
+		VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+		  nickname = CASE WHEN users.nickname IS NULL OR users.nickname IN ('', 'user')
+		                  THEN excluded.nickname ELSE users.nickname END
+	`, uuid.New().String(), from.ID, nick)
+	if err != nil {
+		h.logger.Warn("upsert user nickname", zap.Int64("user_id", from.ID), zap.Error(err))
+	}
+
+	var (
+		stored string
+		orders int
+	)
+	_ = h.db.QueryRow(`SELECT COALESCE(nickname, '') FROM users WHERE user_id = ?`, from.ID).Scan(&stored)
+	_ = h.db.QueryRow(`SELECT COUNT(*) FROM orders WHERE user_id = ? AND parent_order_id IS NULL`, from.ID).Scan(&orders)
+	if stored == "" || stored == "user" {
+		stored = nick
+	}
+	return stored, orders
 }
 
 // Хендлер callback-ов от админа по оплатам (заказы + подписки)
@@ -159,6 +281,9 @@ func (h *Handler) PaymentCallbackHandler(ctx context.Context, b *bot.Bot, update
 
 	var action string
 	switch {
+	case strings.HasPrefix(data, "pay_method:"):
+		h.handlePayMethodCallback(ctx, update.CallbackQuery)
+		return
 	case strings.HasPrefix(data, "pay_ok:"):
 		action = "pay_ok"
 	case strings.HasPrefix(data, "pay_reject:"):
@@ -181,67 +306,82 @@ func (h *Handler) PaymentCallbackHandler(ctx context.Context, b *bot.Bot, update
 	mainID, _ := strconv.ParseInt(idStr, 10, 64)
 	userID, _ := strconv.ParseInt(userIDStr, 10, 64)
 
+	// не даём подтверждению пересечься с заказом/чеком этого же пользователя
+	// и с нажатием другого админа под тем же чеком
+	if userID != 0 {
+		unlock, err := h.lockUser(ctx, userID)
+		if err != nil {
+			_, _ = h.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+				CallbackQueryID: update.CallbackQuery.ID,
+				Text:            "⏳ По этому пользователю идёт другая операция, попробуйте ещё раз",
+				ShowAlert:       true,
+			})
+			return
+		}
+		defer unlock()
+	}
+
+	cq := update.CallbackQuery
+	decider := paymentDeciderOf(cq.From)
+	alert := func(text string) {
+		_, _ = h.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: cq.ID,
+			Text:            text,
+			ShowAlert:       true,
+		})
+	}
+
 	switch action {
 	// --------- Подтверждение оплаты заказа ----------
 	case "pay_ok":
-		// отмечаем заказ как оплаченный
-		if mainID > 0 {
-			_, err := h.db.Exec(`UPDATE orders SET status = 'paid', updated_at = CURRENT_TIMESTAMP WHERE id = ?`, mainID)
-			if err != nil {
-				h.logger.Error("update order status paid", zap.Error(err))
-			}
-		}
-
-		// обновляем состояние пользователя
-		if h.redisClient != nil && userID != 0 {
-			state, err := h.redisClient.GetUserState(ctx, userID)
-			if err != nil {
-				h.logger.Warn("get user state for update", zap.Error(err))
-			}
-			if state == nil {
-				state = &domain.UserState{}
-			}
-			state.State = stateStart
-			state.IsPaid = true
-			if err := h.redisClient.SaveUserState(ctx, userID, state); err != nil {
-				h.logger.Warn("save user state after paid", zap.Error(err))
+		// отмечаем заказ как оплаченный — только если по чеку ещё не решали
+		if err := h.confirmOrderPayment(ctx, mainID, userID, decider); err != nil {
+			if !errors.Is(err, errPaymentDecided) && !errors.Is(err, repository.ErrOrderNotFound) {
+				h.logger.Error("update order status paid", zap.Int64("order_id", mainID), zap.Error(err))
+				alert("Не удалось подтвердить оплату, попробуйте ещё раз")
+				return
 			}
+			alert(h.orderDecisionText(mainID, decider.ID))
+			return
 		}
 
 		// ответ на callback админу
-		_, _ = b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
-			CallbackQueryID: update.CallbackQuery.ID,
+		_, _ = h.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: cq.ID,
 			Text:            "Оплата заказа подтверждена ✅",
 			ShowAlert:       false,
 		})
+		h.markPaymentDecided(ctx, cq, "✅ Подтвердил", decider)
 
-		// уведомляем пользователя
-		if userID != 0 {
-			text := fmt.Sprintf("✅ Ваша оплата по заказу №%d подтверждена! Спасибо за заказ.", mainID)
-			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
-				ChatID: userID,
-				Text:   text,
-			})
-			if err != nil {
-				h.logger.Warn("send paid confirmation to user", zap.Error(err))
+	// --------- Отклонение оплаты заказа ----------
+	case "pay_reject":
+		prev, err := h.decideOrderPayment(ctx, mainID, "rejected", decider)
+		if err != nil {
+			if !errors.Is(err, errPaymentDecided) && !errors.Is(err, repository.ErrOrderNotFound) {
+				h.logger.Error("update order status rejected", zap.Int64("order_id", mainID), zap.Error(err))
+				alert("Не удалось отклонить оплату, попробуйте ещё раз")
+				return
 			}
+			alert(h.orderDecisionText(mainID, decider.ID))
+			return
 		}
+		h.emitOrderEvent(webhookOrderStatusChanged, mainID, prev)
+		h.emitSubOrderEvents(ctx, mainID, webhookOrderStatusChanged, prev)
 
-	// --------- Отклонение оплаты заказа ----------
-	case "pay_reject":
-		_, _ = b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
-			CallbackQueryID: update.CallbackQuery.ID,
+		_, _ = h.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: cq.ID,
 			Text:            "Оплата заказа отклонена ❌",
 			ShowAlert:       false,
 		})
+		h.markPaymentDecided(ctx, cq, "❌ Отклонил", decider)
 
 		if userID != 0 {
-			text := fmt.Sprintf(
+			text := h.orderStatusText("rejected", mainID, fmt.Sprintf(
 				"❌ Оплата по заказу №%d не прошла проверку.\n"+
 					"Пожалуйста, свяжитесь с администратором или отправьте корректный чек ещё раз.",
 				mainID,
-			)
-			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			))
+			_, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{
 				ChatID: userID,
 				Text:   text,
 			})
@@ -254,88 +394,47 @@ func (h *Handler) PaymentCallbackHandler(ctx context.Context, b *bot.Bot, update
 	case "sub_ok":
 		// mainID — это id из таблицы subscriptions
 		if mainID > 0 && userID != 0 {
-			now := time.Now()
-			validUntil := now.AddDate(0, 1, 0) // +1 месяц
-
-			// активируем подписку
-			_, err := h.db.Exec(`
-				UPDATE subscriptions
-				SET status = 'active', valid_until = ?
-				WHERE id = ?
-			`, validUntil, mainID)
-			if err != nil {
-				h.logger.Error("update subscription active", zap.Error(err))
-			}
-
-			// проставляем статусы в users
-			userIDStr := fmt.Sprint(userID)
-			_, err = h.db.Exec(`
-				UPDATE users
-				SET sub_status = 'active', sub_until = ?
-				WHERE user_id = ?
-			`, validUntil, userIDStr)
-			if err != nil {
-				h.logger.Error("update user sub_status active", zap.Error(err))
-			}
-
-			// сбрасываем состояние пользователя в Redis
-			if h.redisClient != nil {
-				state, err := h.redisClient.GetUserState(ctx, userID)
-				if err != nil {
-					h.logger.Warn("get user state for sub_ok", zap.Error(err))
-				}
-				if state == nil {
-					state = &domain.UserState{}
-				}
-				state.State = stateStart
-				state.IsPaid = true
-				if err := h.redisClient.SaveUserState(ctx, userID, state); err != nil {
-					h.logger.Warn("save user state after sub_ok", zap.Error(err))
+			// активируем только pending-подписку: повторное нажатие ничего не меняет
+			if err := h.confirmSubscriptionPayment(ctx, mainID, userID, decider); err != nil {
+				text := h.subscriptionStatusText(mainID, decider.ID)
+				if !errors.Is(err, errSubscriptionNotPending) {
+					h.logger.Error("activate subscription", zap.Int64("subscription_id", mainID), zap.Error(err))
+					text = "Не удалось активировать подписку, попробуйте ещё раз"
 				}
+				alert(text)
+				return
 			}
 
 			// ответ админу
-			_, _ = b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
-				CallbackQueryID: update.CallbackQuery.ID,
+			_, _ = h.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+				CallbackQueryID: cq.ID,
 				Text:            "Подписка активирована ✅",
 				ShowAlert:       false,
 			})
-
-			// сообщение пользователю
-			_, err = b.SendMessage(ctx, &bot.SendMessageParams{
-				ChatID: userID,
-				Text: fmt.Sprintf(
-					"✅ Ваша подписка на «АГРО Клуб Оптовых Цен» активирована!\n"+
-						"Доступ к оптовым ценам до: %s.",
-					validUntil.Format("2006-01-02"),
-				),
-			})
-			if err != nil {
-				h.logger.Warn("send sub active to user", zap.Error(err))
-			}
+			h.markPaymentDecided(ctx, cq, "✅ Подтвердил", decider)
 		}
 
 	// --------- Отклонение оплаты ПОДПИСКИ ----------
 	case "sub_reject":
-		if mainID > 0 {
-			_, err := h.db.Exec(`
-				UPDATE subscriptions
-				SET status = 'rejected'
-				WHERE id = ?
-			`, mainID)
-			if err != nil {
-				h.logger.Error("update subscription rejected", zap.Error(err))
+		if err := h.rejectSubscription(ctx, mainID, decider); err != nil {
+			text := h.subscriptionStatusText(mainID, decider.ID)
+			if !errors.Is(err, errSubscriptionNotPending) {
+				h.logger.Error("update subscription rejected", zap.Int64("subscription_id", mainID), zap.Error(err))
+				text = "Не удалось отклонить оплату, попробуйте ещё раз"
 			}
+			alert(text)
+			return
 		}
 
-		_, _ = b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
-			CallbackQueryID: update.CallbackQuery.ID,
+		_, _ = h.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: cq.ID,
 			Text:            "Оплата подписки отклонена ❌",
 			ShowAlert:       false,
 		})
+		h.markPaymentDecided(ctx, cq, "❌ Отклонил", decider)
 
 		if userID != 0 {
-			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			_, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{
 				ChatID: userID,
 				Text: "❌ Оплата подписки не прошла проверку.\n" +
 					"Пожалуйста, свяжитесь с администратором или отправьте корректный чек ещё раз.",
@@ -361,6 +460,16 @@ func (h *Handler) handlePaymentDocument(ctx context.Context, b *bot.Bot, update
 	chatID := update.Message.Chat.ID
 	userIDStr := fmt.Sprint(userID)
 
+	unlock, err := h.lockUser(ctx, userID)
+	if err != nil {
+		_, _ = h.sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "⏳ Предыдущий запрос ещё обрабатывается. Отправьте чек ещё раз через несколько секунд.",
+		})
+		return err
+	}
+	defer unlock()
+
 	// 1) Проверяем, есть ли "ожидающая" подписка для этого пользователя
 	var (
 		subID      int64
@@ -369,7 +478,7 @@ func (h *Handler) handlePaymentDocument(ctx context.Context, b *bot.Bot, update
 		subStatus  string
 		validUntil sql.NullTime
 	)
-	err := h.db.QueryRow(`
+	err = h.db.QueryRow(`
 		SELECT id, amount, phone, status, valid_until
 		FROM subscriptions
 		WHERE user_id = ? AND status = 'pending'
@@ -384,32 +493,22 @@ func (h *Handler) handlePaymentDocument(ctx context.Context, b *bot.Bot, update
 			"💳 Подтверждение оплаты ПОДПИСКИ\n\n"+
 				"👤 Пользователь: @%s (ID: %d)\n"+
 				"📞 Телефон (из подписки): %s\n"+
-				"💰 Сумма: %d ₸\n"+
+				"💰 Сумма: %s\n"+
 				"📌 Статус в БД: %s\n\n"+
 				"Проверьте чек и подтвердите или отклоните оплату подписки.\n",
 			userName,
 			userID,
 			subPhone,
-			subAmount,
+			formatMoney(subAmount),
 			subStatus,
 		)
 
 		caption := sb.String()
 
-		cbOK := fmt.Sprintf("sub_ok:%d:%d", subID, userID)
-		cbReject := fmt.Sprintf("sub_reject:%d:%d", subID, userID)
-
-		kb := &models.InlineKeyboardMarkup{
-			InlineKeyboard: [][]models.InlineKeyboardButton{
-				{
-					{Text: "✅ Активировать подписку", CallbackData: cbOK},
-					{Text: "❌ Отклонить", CallbackData: cbReject},
-				},
-			},
-		}
+		kb := paymentReviewKeyboard(paymentKindSubscription, subID, userID)
 
 		// копируем сообщение с документом админу
-		_, err := b.CopyMessage(ctx, &bot.CopyMessageParams{
+		_, err := h.sender.CopyMessage(ctx, &bot.CopyMessageParams{
 			ChatID:      h.cfg.AdminID,
 			FromChatID:  fmt.Sprint(chatID),
 			MessageID:   update.Message.ID,
@@ -420,9 +519,10 @@ func (h *Handler) handlePaymentDocument(ctx context.Context, b *bot.Bot, update
 			h.logger.Error("copy subscription payment doc to admin", zap.Error(err))
 			return err
 		}
+		h.recordPaymentReceipt(ctx, paymentKindSubscription, subID, userID, update.Message.Document.FileID, subAmount)
 
 		// уведомляем пользователя
-		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		_, err = h.sender.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: chatID,
 			Text:   "✅ Чек по подписке отправлен администратору. Мы проверим оплату и сообщим о результате.",
 		})
@@ -437,53 +537,44 @@ func (h *Handler) handlePaymentDocument(ctx context.Context, b *bot.Bot, update
 	// ищем последний заказ пользователя
 	var orderID, totalAmount int64
 
-	err = h.db.QueryRow(`SELECT id, total_amount FROM orders WHERE user_id = ? ORDER BY id DESC LIMIT 1`, userIDStr).
-		Scan(&orderID, &totalAmount)
+	// части заказа из нескольких точек оплачиваются общим заказом
+	err = h.db.QueryRow(`SELECT id FROM orders WHERE user_id = ? AND parent_order_id IS NULL ORDER BY id DESC LIMIT 1`, userIDStr).Scan(&orderID)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		h.logger.Warn("select last order for payment", zap.Error(err))
 	}
 
+	// новый чек по отклонённому заказу снова ждёт решения админа
+	if orderID > 0 {
+		if _, err := h.orderRepo.UpdateStatus(ctx, orderID, "rejected", "checking"); err != nil {
+			h.logger.Warn("reset rejected order to checking", zap.Int64("order_id", orderID), zap.Error(err))
+		}
+	}
+
 	payMethod := state.BroadCastType
 	if payMethod == "" {
 		payMethod = paymentKaspiLink
 	}
 
-	// --- Тянем позиции заказа для админа ---
+	// --- Тянем заказ с позициями для админа ---
 	var itemsText string
 	if orderID > 0 {
-		rows, errItems := h.db.Query(`
-			SELECT name, unit, qty, price, amount
-			FROM order_items
-			WHERE order_id = ?
-			ORDER BY id
-		`, orderID)
-		if errItems != nil {
-			h.logger.Warn("select order items for payment", zap.Error(errItems))
+		order, items, err := h.orderRepo.GetOrderWithItems(ctx, orderID)
+		if err != nil {
+			h.logger.Warn("select order with items for payment", zap.Error(err))
 		} else {
-			defer rows.Close()
+			totalAmount = order.TotalAmount
 			var sbItems strings.Builder
-			var sumItems int64
-
-			for rows.Next() {
-				var (
-					name   string
-					unit   string
-					qty    float64
-					price  int64
-					amount int64
-				)
-				if err := rows.Scan(&name, &unit, &qty, &price, &amount); err != nil {
-					h.logger.Warn("scan order item for payment", zap.Error(err))
+			for _, it := range items {
+				if it.IsDelivery() {
 					continue
 				}
-				sumItems += amount
-				fmt.Fprintf(&sbItems, "• %s — %.2f %s × %d ₸ = %d ₸\n",
-					name, qty, unit, price, amount)
+				fmt.Fprintf(&sbItems, "• %s — %s = %s\n",
+					itemLabel(it.Emoji, it.Name), qtyTimesPrice(orderItemsIn([]domain.OrderItem{it})[0]), formatMoney(it.Amount))
 			}
 
 			if sbItems.Len() > 0 {
-				itemsText = "\n🛒 Позиции заказа:\n" + sbItems.String() +
-					fmt.Sprintf("💰 Сумма по позициям: %d ₸\n", sumItems)
+				writeOrderTotals(&sbItems, order.GoodsTotal, order.DeliveryPrice, "Сумма по позициям")
+				itemsText = "\n🛒 Позиции заказа:\n" + sbItems.String() + "\n"
 			}
 		}
 	}
@@ -493,14 +584,14 @@ func (h *Handler) handlePaymentDocument(ctx context.Context, b *bot.Bot, update
 		"💳 Подтверждение оплаты по заказу №%d\n\n"+
 			"👤 Пользователь: @%s (ID: %d)\n"+
 			"📞 Телефон: %s\n"+
-			"💰 Сумма из orders.total_amount: %d ₸\n"+
+			"💰 Сумма из orders.total_amount: %s\n"+
 			"💳 Способ оплаты: %s\n\n"+
 			"Проверьте чек и подтвердите или отклоните оплату.\n",
 		orderID,
 		userName,
 		userID,
 		state.Contact,
-		totalAmount,
+		formatMoney(totalAmount),
 		humanPaymentMethod(payMethod),
 	)
 
@@ -512,20 +603,10 @@ func (h *Handler) handlePaymentDocument(ctx context.Context, b *bot.Bot, update
 
 	caption := sb.String()
 
-	cbOK := fmt.Sprintf("pay_ok:%d:%d", orderID, userID)
-	cbReject := fmt.Sprintf("pay_reject:%d:%d", orderID, userID)
-
-	kb := &models.InlineKeyboardMarkup{
-		InlineKeyboard: [][]models.InlineKeyboardButton{
-			{
-				{Text: "✅ Подтвердить оплату", CallbackData: cbOK},
-				{Text: "❌ Отклонить", CallbackData: cbReject},
-			},
-		},
-	}
+	kb := paymentReviewKeyboard(paymentKindOrder, orderID, userID)
 
 	// копируем сообщение с документом админу
-	_, err = b.CopyMessage(ctx, &bot.CopyMessageParams{
+	_, err = h.sender.CopyMessage(ctx, &bot.CopyMessageParams{
 		ChatID:      h.cfg.AdminID,
 		FromChatID:  fmt.Sprint(chatID),
 		MessageID:   update.Message.ID,
@@ -536,9 +617,12 @@ func (h *Handler) handlePaymentDocument(ctx context.Context, b *bot.Bot, update
 		h.logger.Error("copy payment doc to admin", zap.Error(err))
 		return err
 	}
+	if orderID > 0 {
+		h.recordPaymentReceipt(ctx, paymentKindOrder, orderID, userID, update.Message.Document.FileID, totalAmount)
+	}
 
 	// уведомляем пользователя
-	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+	_, err = h.sender.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: chatID,
 		Text:   "✅ Чек отправлен администратору. Мы проверим оплату и сообщим о результате.",
 	})
@@ -551,12 +635,32 @@ func (h *Handler) handlePaymentDocument(ctx context.Context, b *bot.Bot, update
 
 // ======================== HTTP / MINI-APP ========================
 
+// corsMiddleware разрешает кросс-доменные запросы только источникам из CORS_ORIGINS:
+// Origin возвращается как есть и с Allow-Credentials. "*" в списке — любой источник,
+// но без credentials (для локальной разработки). Запросы без Origin не трогаем.
 func (h *Handler) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Telegram-Id")
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		allowed, wildcard := h.corsAllowed(origin)
+		switch {
+		case allowed:
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		case wildcard:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case r.Method == http.MethodOptions:
+			writeError(w, ErrForbidden())
+			return
+		}
 		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Telegram-Id, X-Request-Signature, X-Telegram-Init-Data, X-Act-As")
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -564,52 +668,165 @@ func (h *Handler) corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// corsAllowed — есть ли origin в списке (allowed) или список открыт звёздочкой (wildcard).
+func (h *Handler) corsAllowed(origin string) (allowed, wildcard bool) {
+	for _, o := range h.cfg.CORSOrigins {
+		switch {
+		case o == "*":
+			wildcard = true
+		case strings.EqualFold(o, origin):
+			return true, false
+		}
+	}
+	return false, wildcard
+}
+
+// пути, POST-запросы к которым подписываются мини-аппом
+var signedPathPrefixes = []string{"/api/orders/", "/api/subscribe/"}
+
+// signatureMiddleware проверяет X-Request-Signature = hex(HMAC-SHA256(method+path+body))
+// с ключом = токен бота (серверные клиенты). Мини-апп токена не знает и вместо
+// подписи присылает X-Telegram-Init-Data = Telegram.WebApp.initData.
+// Без обоих заголовков запрос пропускается, если не включён REQUIRE_REQUEST_SIGNATURE.
+func (h *Handler) signatureMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !hasAnyPrefix(r.URL.Path, signedPathPrefixes) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if initData := strings.TrimSpace(r.Header.Get("X-Telegram-Init-Data")); initData != "" {
+			if !validInitData(h.cfg.Token, initData, h.clock.Now()) {
+				h.logger.Warn("invalid telegram init data", zap.String("path", r.URL.Path))
+				writeError(w, ErrUnauthorized("invalid init data"))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sig := strings.TrimSpace(r.Header.Get("X-Request-Signature"))
+		if sig == "" {
+			if h.cfg.RequireRequestSignature {
+				writeError(w, ErrUnauthorized("signature required"))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, ErrBadRequest("cannot read body"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if !validRequestSignature(h.cfg.Token, r.Method, r.URL.Path, body, sig) {
+			h.logger.Warn("invalid request signature", zap.String("path", r.URL.Path))
+			writeError(w, ErrUnauthorized("invalid signature"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validRequestSignature(key, method, path string, body []byte, sig string) bool {
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(method + path))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// escapeLike экранирует спецсимволы LIKE (\, %, _), чтобы пользовательский
+// текст сравнивался буквально. Использовать вместе с ESCAPE '\'.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// GET /api/delivery/price?store=samal3&lat=..&lng=.. — цена доставки.
+// Без точки или координат — плоская ставка.
 func (h *Handler) handleDeliveryPrice(w http.ResponseWriter, r *http.Request) {
-	// В будущем можно учитывать расстояние, время и т.д.
-	// Сейчас — плоская ставка.
-	price := int64(1000) // 1000 ₸
+	q := r.URL.Query()
+	d := deliveryIn{Type: "delivery"}
+	d.Lat, _ = strconv.ParseFloat(q.Get("lat"), 64)
+	d.Lng, _ = strconv.ParseFloat(q.Get("lng"), 64)
+
+	price, tier, err := h.deliveryQuote(strings.TrimSpace(q.Get("store")), d)
+	if appErr := deliveryAppError(err); appErr != nil {
+		writeError(w, appErr)
+		return
+	}
+	if err != nil {
+		h.logger.Error("delivery quote", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
 	jsonOK(w, map[string]any{
 		"price":    price,
+		"tier":     tier,
 		"currency": "KZT",
 	})
 }
 
 func (h *Handler) StartWebServer(ctx context.Context, b *bot.Bot) {
-	h.SetBot(b)
+	// бот подключается до первого запроса; повторный SetBot после main.go
+	// гонялся бы с хендлерами бота, которые уже читают h.sender
+	if !h.botReady.Load() {
+		h.SetBot(b)
+	}
+
+	handler := h.Routes()
+	addr := fmt.Sprintf(":%s", h.cfg.Port)
+	h.logger.Info("Web server listening", zap.String("address", addr))
+
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		h.logger.Info("Shutting down web server...")
+		_ = server.Shutdown(context.Background())
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		h.logger.Error("Web server error", zap.Error(err))
+	}
+}
 
+// Routes собирает все HTTP-маршруты мини-аппа вместе с middleware.
+// Вынесено из StartWebServer, чтобы тесты могли гонять запросы через httptest.
+func (h *Handler) Routes() http.Handler {
 	mux := http.NewServeMux()
 
 	// STATIC pages
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./static/welcome.html")
-	})
-	mux.HandleFunc("/catalog", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./static/catalog.html")
-	})
-	mux.HandleFunc("/order-confirm", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./static/order-confirm.html")
-	})
+	mux.HandleFunc("/{$}", h.serveStatic("welcome.html"))
+	mux.HandleFunc("/", h.handleNotFound)
+	mux.HandleFunc("/catalog", h.serveStatic("catalog.html"))
+	mux.HandleFunc("/order-confirm", h.serveStatic("order-confirm.html"))
 
-	mux.HandleFunc("/admin-add", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./static/admin-add.html")
-	})
-	mux.HandleFunc("/admin-add-store", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./static/admin-add-store.html")
-	})
-	mux.HandleFunc("/admin-show-catalog", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./static/admin-show-catalog.html")
-	})
-	mux.HandleFunc("/admin-edit-product", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./static/admin-edit-product.html")
-	})
+	mux.HandleFunc("/admin-add", h.serveStatic("admin-add.html"))
+	mux.HandleFunc("/admin-add-store", h.serveStatic("admin-add-store.html"))
+	mux.HandleFunc("/admin-show-catalog", h.serveStatic("admin-show-catalog.html"))
+	mux.HandleFunc("/admin-edit-product", h.serveStatic("admin-edit-product.html"))
 
-	// ❗️Было: "./static/maFp-view.html" (опечатка)
-	mux.HandleFunc("/map-view", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./static/map-view.html")
-	})
-	mux.HandleFunc("/store-select", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./static/store-select.html")
-	})
+	mux.HandleFunc("/map-view", h.serveStatic("map-view.html"))
+	mux.HandleFunc("/store-select", h.serveStatic("store-select.html"))
+
+	// готовность для балансировщика/оркестратора: бот подключён, база отвечает
+	mux.HandleFunc("GET /readyz", h.handleReadyz)
 
 	// simple admin id source
 	mux.HandleFunc("/admin-id", func(w http.ResponseWriter, r *http.Request) {
@@ -619,16 +836,36 @@ func (h *Handler) StartWebServer(ctx context.Context, b *bot.Bot) {
 	// STORES
 	mux.HandleFunc("/api/stores", h.handleListStores)
 	mux.HandleFunc("/api/admin/stores/add", h.handleAddStore)
+	mux.HandleFunc("GET /api/stores/zones", h.handleDeliveryZones)
+	mux.HandleFunc("/api/admin/stores/zone", h.handleAdminSetZone)
+	mux.HandleFunc("POST /api/admin/stores/delivery", h.handleAdminSetStoreDelivery)
+	mux.HandleFunc("GET /api/admin/delivery/tiers", h.handleAdminListDeliveryTiers)
+	mux.HandleFunc("/api/admin/delivery/tiers/set", h.handleAdminSetDeliveryTiers)
 
 	// USER / SHOP API
 	mux.HandleFunc("/api/user/subscription-status", h.handleGetSubStatus)
 	mux.HandleFunc("/api/subscribe/request-invoice", h.handleRequestInvoice)
+	mux.HandleFunc("POST /api/payments/kaspi-callback", h.handleKaspiCallback)
+	mux.HandleFunc("GET /api/admin/payments/pending", h.handleAdminPendingPayments)
+	mux.HandleFunc("POST /api/admin/payments/resend", h.handleAdminResendReceipt)
+	mux.HandleFunc("GET /api/subscription-plans/compare", h.handleCompareSubscriptionPlans)
 	mux.HandleFunc("/api/user/set-store", h.handleSetStore)
+	mux.HandleFunc("/api/user/notify-stock", h.handleNotifyStock)
+	mux.HandleFunc("POST /api/user/update-phone", h.handleUpdatePhone)
+	mux.HandleFunc("POST /api/user/marketing", h.handleUserMarketing)
+	mux.HandleFunc("GET /api/user/orders", h.handleUserOrders)
 	mux.HandleFunc("/api/products", h.handleGetProducts)
+	mux.HandleFunc("GET /api/categories", h.handleGetCategories)
+	mux.HandleFunc("GET /api/products/featured", h.handleGetFeaturedProducts)
+	mux.HandleFunc("GET /api/products/changes", h.handleProductChanges)
+	mux.HandleFunc("GET /api/products/{id}", h.handleGetProduct)
 
 	// ❗️Оба эндпоинта заказов:
 	mux.HandleFunc("/api/orders/create", h.handleCreateOrder)
 	mux.HandleFunc("/api/orders/confirm", h.handleConfirmOrder)
+	mux.HandleFunc("/api/orders/quote", h.handleQuoteOrder)
+	mux.HandleFunc("/api/orders/feedback", h.handleOrderFeedback)
+	mux.HandleFunc("/api/orders/resend-receipt", h.handleResendReceipt)
 
 	// ADMIN: products
 	mux.HandleFunc("/api/admin/products", h.handleAdminListProducts)
@@ -636,66 +873,147 @@ func (h *Handler) StartWebServer(ctx context.Context, b *bot.Bot) {
 	mux.HandleFunc("/api/admin/products/add", h.handleAdminAddProduct)
 	mux.HandleFunc("/api/admin/products/update", h.handleAdminUpdateProduct)
 	mux.HandleFunc("/api/admin/products/delete", h.handleAdminDeleteProduct)
+	mux.HandleFunc("POST /api/admin/products/bulk-price-update", h.handleAdminBulkPriceUpdate)
+	mux.HandleFunc("POST /api/admin/products/bulk-price", h.handleAdminBulkPrice)
+	mux.HandleFunc("GET /api/admin/catalog/export", h.handleAdminCatalogExport)
+	mux.HandleFunc("POST /api/admin/catalog/import", h.handleAdminCatalogImport)
 
 	// Delivery price
 	mux.HandleFunc("/api/delivery/price", h.handleDeliveryPrice)
+	mux.HandleFunc("GET /api/address/suggest", h.handleAddressSuggest)
+
+	// ADMIN: orders
+	mux.HandleFunc("POST /api/admin/orders/status", h.handleAdminSetOrderStatus)
+	mux.HandleFunc("GET /api/admin/orders/verify", h.handleAdminVerifyOrders)
+	mux.HandleFunc("POST /api/admin/orders/resend-payment", h.handleAdminResendPayment)
+	mux.HandleFunc("GET /api/admin/order-status-messages", h.handleAdminListOrderStatusMessages)
+	mux.HandleFunc("POST /api/admin/order-status-messages", h.handleAdminSetOrderStatusMessage)
+	mux.HandleFunc("GET /api/admin/orders/detail", h.handleAdminGetOrder)
+	mux.HandleFunc("GET /api/admin/orders/{id}", h.handleAdminGetOrder)
+	mux.HandleFunc("GET /api/admin/orders", h.handleAdminListOrders)
+	mux.HandleFunc("GET /api/admin/orders/stream", h.handleAdminOrdersStream)
+	mux.HandleFunc("GET /api/admin/notifications/stats", h.handleAdminNotifierStats)
+	mux.HandleFunc("GET /api/admin/store-managers", h.handleAdminListStoreManagers)
+	mux.HandleFunc("POST /api/admin/store-managers/add", h.handleAdminAddStoreManager)
+	mux.HandleFunc("POST /api/admin/store-managers/delete", h.handleAdminDeleteStoreManager)
+	mux.HandleFunc("GET /api/admin/stats", h.handleAdminStats)
+	mux.HandleFunc("GET /api/admin/revenue", h.handleAdminRevenue)
+	mux.HandleFunc("GET /api/admin/stats/margins", h.handleAdminMargins)
+
+	// ADMIN: users
+	mux.HandleFunc("GET /api/admin/users", h.handleAdminListUsers)
+	mux.HandleFunc("GET /api/admin/users/export", h.handleAdminExportUser)
+	mux.HandleFunc("POST /api/admin/send-message", h.handleAdminSendMessage)
+
+	// ADMIN: subscriptions
+	mux.HandleFunc("GET /api/admin/subscriptions", h.handleAdminListSubscriptions)
+	mux.HandleFunc("/api/admin/subscriptions/set", h.handleAdminSetSubscription)
+	mux.HandleFunc("POST /api/admin/subscriptions/gift", h.handleAdminGiftSubscription)
+
+	// ADMIN: database
+	mux.HandleFunc("/api/admin/db/check", h.handleAdminDBCheck)
+
+	// ADMIN: журнал действий
+	mux.HandleFunc("GET /api/admin/audit", h.handleAdminListAudit)
+
+	// ADMIN: promotions
+	mux.HandleFunc("GET /api/admin/promotions", h.handleAdminListPromotions)
+	mux.HandleFunc("POST /api/admin/promotions/add", h.handleAdminAddPromotion)
+	mux.HandleFunc("POST /api/admin/promotions/update", h.handleAdminUpdatePromotion)
+	mux.HandleFunc("POST /api/admin/promotions/delete", h.handleAdminDeletePromotion)
+
+	// ADMIN: webhooks
+	mux.HandleFunc("GET /api/admin/webhooks", h.handleAdminListWebhooks)
+	mux.HandleFunc("GET /api/admin/webhooks/deliveries", h.handleAdminWebhookDeliveries)
+	mux.HandleFunc("POST /api/admin/webhooks/add", h.handleAdminAddWebhook)
+	mux.HandleFunc("POST /api/admin/webhooks/update", h.handleAdminUpdateWebhook)
+	mux.HandleFunc("POST /api/admin/webhooks/delete", h.handleAdminDeleteWebhook)
+	mux.HandleFunc("POST /api/admin/webhooks/ping", h.handleAdminPingWebhook)
+
+	// Режим обслуживания
+	mux.HandleFunc("GET /api/maintenance", h.handleMaintenanceStatus)
+	mux.HandleFunc("POST /api/admin/maintenance", h.handleAdminSetMaintenance)
 
 	// uploads static
 	mux.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("./uploads"))))
 
-	handler := h.corsMiddleware(mux)
-	addr := fmt.Sprintf(":%s", h.cfg.Port)
-	h.logger.Info("Web server listening", zap.String("address", addr))
-
-	server := &http.Server{Addr: addr, Handler: handler}
-	go func() {
-		<-ctx.Done()
-		h.logger.Info("Shutting down web server...")
-		_ = server.Shutdown(context.Background())
-	}()
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		h.logger.Error("Web server error", zap.Error(err))
-	}
+	return h.corsMiddleware(h.signatureMiddleware(h.actAsMiddleware(mux)))
 }
 
 // =============== Admin helpers ===============
 func (h *Handler) isAdminRequest(r *http.Request) bool {
-	tgid := strings.TrimSpace(r.Header.Get("X-Telegram-Id"))
-	if tgid == "" {
+	tgid, err := strconv.ParseInt(strings.TrimSpace(r.Header.Get("X-Telegram-Id")), 10, 64)
+	if err != nil {
 		return false
 	}
-	return tgid == fmt.Sprint(h.cfg.AdminID)
+	return h.cfg.IsSuperAdmin(tgid)
+}
+
+// ========================= ADMIN DB =========================
+
+// handleAdminDBCheck — полная проверка PRAGMA integrity_check + проверка схемы.
+func (h *Handler) handleAdminDBCheck(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+
+	problems, err := database.IntegrityCheck(r.Context(), h.db, true)
+	if err != nil {
+		h.logger.Error("integrity check", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	schema := "ok"
+	if err := database.VerifySchema(h.db); err != nil {
+		schema = err.Error()
+	}
+
+	jsonOK(w, map[string]any{
+		"ok":        len(problems) == 0 && schema == "ok",
+		"integrity": problems,
+		"schema":    schema,
+	})
 }
 
 // ========================= STORES =========================
 
 type storeIn struct {
-	Code    string `json:"code"`
-	Name    string `json:"name"`
-	Address string `json:"address"`
+	Code         string `json:"code"`
+	Name         string `json:"name"`
+	Address      string `json:"address"`
+	WorkingHours string `json:"working_hours"`
 }
 
 func (h *Handler) handleListStores(w http.ResponseWriter, r *http.Request) {
-	rows, err := h.db.Query(`SELECT code, name, COALESCE(address,'') FROM stores ORDER BY name`)
+	rows, err := h.db.Query(`SELECT code, name, COALESCE(address,''), delivers, delivery_radius_km FROM stores ORDER BY name`)
 	if err != nil {
 		h.logger.Error("list stores", zap.Error(err))
-		jsonErr(w, 500, "db error")
+		writeError(w, ErrInternal(err))
 		return
 	}
 	defer rows.Close()
 
+	// delivers=false — мини-апп прячет доставку; delivery_radius_km: null — без ограничения
 	type store struct {
-		Code    string `json:"code"`
-		Name    string `json:"name"`
-		Address string `json:"address"`
+		Code             string   `json:"code"`
+		Name             string   `json:"name"`
+		Address          string   `json:"address"`
+		Delivers         bool     `json:"delivers"`
+		DeliveryRadiusKm *float64 `json:"delivery_radius_km"`
 	}
 	var out []store
 	for rows.Next() {
-		var s store
-		if err := rows.Scan(&s.Code, &s.Name, &s.Address); err != nil {
+		var (
+			s        store
+			delivery storeDelivery
+		)
+		if err := rows.Scan(&s.Code, &s.Name, &s.Address, &delivery.Delivers, &delivery.RadiusKm); err != nil {
 			h.logger.Error("scan store", zap.Error(err))
 			continue
 		}
+		s.Delivers, s.DeliveryRadiusKm = delivery.Delivers, delivery.radiusOut(h.cfg.DeliveryRadiusKm)
 		out = append(out, s)
 	}
 	jsonOK(w, out)
@@ -708,7 +1026,7 @@ func (h *Handler) geocodeAddress(addr string) (lng, lat float64, formatted strin
 	}
 	url := fmt.Sprintf("https://geocode-maps.yandex.ru/1.x/?apikey=%s&geocode=%s&format=json&lang=ru_RU&results=1",
 		h.cfg.YandexAPIKey, urlQueryEscape(addr)) // urlQueryEscape = url.QueryEscape
-	resp, e := http.Get(url)
+	resp, e := h.httpClient.Get(url)
 	if e != nil {
 		return 0, 0, "", e
 	}
@@ -772,24 +1090,25 @@ func dig(m any, path ...string) any {
 
 func (h *Handler) handleAddStore(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		writeError(w, ErrMethodNotAllowed())
 		return
 	}
 	if !h.isAdminRequest(r) {
-		jsonErr(w, http.StatusForbidden, "forbidden")
+		writeError(w, ErrForbidden())
 		return
 	}
 
 	var in storeIn
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		jsonErr(w, 400, "invalid json")
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
 		return
 	}
 	in.Code = strings.TrimSpace(in.Code)
 	in.Name = strings.TrimSpace(in.Name)
 	in.Address = strings.TrimSpace(in.Address)
+	in.WorkingHours = strings.TrimSpace(in.WorkingHours)
 	if in.Code == "" || in.Name == "" {
-		jsonErr(w, 400, "code and name are required")
+		writeError(w, ErrBadRequest("code and name are required"))
 		return
 	}
 
@@ -803,36 +1122,34 @@ func (h *Handler) handleAddStore(w http.ResponseWriter, r *http.Request) {
 	}
 
 	_, err := h.db.Exec(`
-        INSERT INTO stores(code,name,address,longitude,latitude,address_formatted)
-        VALUES(?,?,?,?,?,?)
+        INSERT INTO stores(code,name,address,longitude,latitude,address_formatted,working_hours)
+        VALUES(?,?,?,?,?,?,?)
         ON CONFLICT(code) DO UPDATE SET
            name=excluded.name,
            address=excluded.address,
            longitude=excluded.longitude,
            latitude=excluded.latitude,
-           address_formatted=excluded.address_formatted
-    `, in.Code, in.Name, in.Address, nullIfZero(lng), nullIfZero(lat), sql.NullString{String: formatted, Valid: formatted != ""})
+           address_formatted=excluded.address_formatted,
+           working_hours=excluded.working_hours
+    `, in.Code, in.Name, in.Address, nullFloat(lng), nullFloat(lat), sql.NullString{String: formatted, Valid: formatted != ""}, nullString(in.WorkingHours))
 	if err != nil {
 		h.logger.Error("insert store", zap.Error(err))
-		jsonErr(w, 500, "db error")
+		writeError(w, ErrInternal(err))
 		return
 	}
+	h.auditQuiet(h.auditActor(r), "store.save", in.Code, in)
 	jsonOK(w, map[string]string{"status": "ok"})
 }
 
-func nullIfZero(v float64) any {
-	if v == 0 {
-		return nil
-	}
-	return v
-}
-
 // ========================= API HANDLERS =========================
 
 func (h *Handler) handleConfirmOrder(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInMaintenance(w) {
+		return
+	}
 	var in confirmOrderIn
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		jsonErr(w, http.StatusBadRequest, "invalid json")
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
 		return
 	}
 
@@ -846,8 +1163,8 @@ func (h *Handler) handleConfirmOrder(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	tgStr = strings.TrimSpace(tgStr)
-	if tgStr == "" || len(in.Items) == 0 {
-		jsonErr(w, http.StatusBadRequest, "telegram_id and items are required")
+	if _, err := strconv.ParseInt(tgStr, 10, 64); err != nil || len(in.Items) == 0 {
+		writeError(w, ErrBadRequest("telegram_id and items are required"))
 		return
 	}
 
@@ -856,80 +1173,119 @@ func (h *Handler) handleConfirmOrder(w http.ResponseWriter, r *http.Request) {
 		payMethod = paymentKaspiLink
 	}
 
+	unlock, ok := h.lockUserHTTP(w, r, tgStr)
+	if !ok {
+		return
+	}
+	defer unlock()
+
 	// Проверим выбранный магазин (как и в handleCreateOrder)
 	var store sql.NullString
 	_ = h.db.QueryRow(`SELECT selected_store FROM users WHERE user_id = ?`, tgStr).Scan(&store)
 
-	// Базовая сумма
-	var goodsTotal int64
-	for _, it := range in.Items {
-		if it.Qty <= 0 || it.Price < 0 {
-			jsonErr(w, http.StatusBadRequest, "bad item qty/price")
+	// Доставка — только от доставляющей точки и в её зону (полигон или радиус)
+	if strings.EqualFold(in.Delivery.Type, "delivery") {
+		// мини-апп не прислал координаты — радиус считаем по геокодированному адресу
+		if !validCoords(in.Delivery.Lat, in.Delivery.Lng) && strings.TrimSpace(in.Delivery.Address) != "" {
+			if lng, lat, _, err := h.geocodeAddress(in.Delivery.Address); err == nil && validCoords(lat, lng) {
+				in.Delivery.Lat, in.Delivery.Lng = lat, lng
+			}
+		}
+		if err := h.checkDeliveryZone(store.String, in.Delivery.Lat, in.Delivery.Lng); err != nil {
+			if appErr := deliveryAppError(err); appErr != nil {
+				writeError(w, appErr)
+				return
+			}
+			h.logger.Error("check delivery zone", zap.Error(err))
+			writeError(w, ErrInternal(err))
 			return
 		}
-		goodsTotal += int64(it.Qty * float64(it.Price))
 	}
 
-	// Цена доставки (если выбрана доставка)
-	var deliveryPrice int64
-	if strings.EqualFold(in.Delivery.Type, "delivery") {
-		// сейчас — плоская ставка 1000 ₸ (как в /api/delivery/price)
-		deliveryPrice = 1000
-		// добавим как строку заказа «Доставка»
-		in.Items = append(in.Items, orderItemIn{
-			ProductID: 0,
-			Name:      "Доставка",
-			Qty:       1,
-			Unit:      "услуга",
-			Price:     deliveryPrice,
-		})
+	// Сумма и доставка — та же логика, что и в /api/orders/quote
+	deliveryPrice, _, err := h.deliveryQuote(store.String, in.Delivery)
+	if appErr := deliveryAppError(err); appErr != nil {
+		writeError(w, appErr)
+		return
 	}
-
-	total := goodsTotal + deliveryPrice
-
-	// Транзакция
-	tx, err := h.db.Begin()
 	if err != nil {
-		h.logger.Error("tx begin", zap.Error(err))
-		jsonErr(w, 500, "db error")
+		h.logger.Error("delivery quote", zap.Error(err))
+		writeError(w, ErrInternal(err))
 		return
 	}
-	defer func() { _ = tx.Rollback() }()
-
-	res, err := tx.Exec(`
-		INSERT INTO orders (user_id, store_code, total_amount, status)
-		VALUES (?, ?, ?, 'new')
-	`, tgStr, nullIfEmpty(store.String), total)
+	// цены — из каталога: гостю розничные, subscriber_only только подписчикам
+	products, appErr, err := h.priceOrderItems(in.Items, h.hasSubscription(tgStr))
 	if err != nil {
-		h.logger.Error("insert order", zap.Error(err))
-		jsonErr(w, 500, "db error")
+		h.logger.Error("price order items", zap.Error(err))
+		writeError(w, ErrInternal(err))
 		return
 	}
-	orderID, _ := res.LastInsertId()
-
-	stmt, err := tx.Prepare(`
-		INSERT INTO order_items (order_id, product_id, name, unit, qty, price, amount)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`)
+	if appErr != nil {
+		writeError(w, appErr)
+		return
+	}
+	q, err := quoteOrder(in.Items, in.Delivery, deliveryPrice)
 	if err != nil {
-		h.logger.Error("prepare order items", zap.Error(err))
-		jsonErr(w, 500, "db error")
+		writeError(w, ErrBadRequest(err.Error()))
 		return
 	}
-	defer stmt.Close()
-
-	for _, it := range in.Items {
-		amount := int64(it.Qty * float64(it.Price))
-		if _, err := stmt.Exec(orderID, it.ProductID, it.Name, it.Unit, it.Qty, it.Price, amount); err != nil {
-			h.logger.Error("insert order item", zap.Error(err))
-			jsonErr(w, 500, "db error")
-			return
+	// товары другой точки (пользователь сменил магазин, а корзина осталась) не принимаем
+	storeMatch, foreign := checkItemStores(store.String, in.Items, products)
+	if len(foreign) > 0 && !in.SplitStores {
+		writeError(w, h.foreignItemsError(store.String, foreign))
+		return
+	}
+	review, appErr := h.checkOrderLimits(q.Items, products, q.Total)
+	if appErr != nil {
+		writeError(w, appErr)
+		return
+	}
+	in.Items = q.Items
+	goodsTotal, total := q.GoodsTotal, q.Total
+
+	// X-Act-As: админ проверяет оформление за покупателя — его настоящий
+	// недавний заказ за дубль не считаем, новый не сохраняем
+	_, dryRun := actingAs(r.Context())
+	if orderID, dup := h.recentOrder(tgStr, total); dup && !dryRun {
+		// отвечаем суммами уже сохранённого заказа
+		if order, _, err := h.orderRepo.GetOrderWithItems(r.Context(), orderID); err == nil {
+			goodsTotal, deliveryPrice, total = order.GoodsTotal, order.DeliveryPrice, order.TotalAmount
+		} else {
+			h.logger.Warn("select duplicate order", zap.Int64("order_id", orderID), zap.Error(err))
 		}
+		jsonOK(w, map[string]any{
+			"status":         "ok",
+			"order_id":       orderID,
+			"goods_total":    goodsTotal,
+			"delivery_price": deliveryPrice,
+			"total":          total,
+			"duplicate":      true,
+		})
+		return
 	}
 
-	if err := tx.Commit(); err != nil {
-		h.logger.Error("tx commit", zap.Error(err))
-		jsonErr(w, 500, "db error")
+	// Заказ и позиции (и части заказа по точкам) — одной транзакцией в OrderRepository
+	snapshotOrderItems(in.Items, products)
+	order := newOrder(tgStr, store.String, payMethod, total, in.Items)
+	var children []*domain.Order
+	if len(foreign) > 0 {
+		// корзина из нескольких точек: каждая собирает свою часть
+		if groups := splitItemsByStore(store.String, in.Items, productStores(products)); len(groups) > 1 {
+			children = subOrders(order, groups)
+		}
+	}
+	// проверку наличия ждёт общий заказ (оплата — по нему), части — как обычно
+	if len(review) > 0 {
+		order.Status = orderReview
+	}
+	if dryRun {
+		h.dryRunOrder(w, r, order, children)
+		return
+	}
+	orderID, err := h.orderRepo.CreateSplit(r.Context(), order, children)
+	if err != nil {
+		h.logger.Error("create order", zap.Error(err))
+		writeError(w, ErrInternal(err))
 		return
 	}
 
@@ -949,58 +1305,190 @@ func (h *Handler) handleConfirmOrder(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// ⚠️ Уведомление админу с деталями доставки
-	{
-		var b strings.Builder
-		fmt.Fprintf(&b, "🧾 Новый заказ (подтверждён)\n\n")
-		fmt.Fprintf(&b, "👤 Telegram ID: %s\n", tgStr)
-
-		if store.Valid && store.String != "" {
-			var name, addr sql.NullString
-			_ = h.db.QueryRow(`SELECT name, address FROM stores WHERE code = ?`, store.String).Scan(&name, &addr)
-			if name.Valid {
-				fmt.Fprintf(&b, "🏪 Точка: %s\n", name.String)
-			}
-			if addr.Valid {
-				fmt.Fprintf(&b, "📍 Адрес точки: %s\n", addr.String)
-			}
-		}
-
-		fmt.Fprintf(&b, "💳 Способ оплаты: %s\n", humanPaymentMethod(payMethod))
+	h.emitOrderEvent(webhookOrderCreated, orderID, "")
+	for _, c := range children {
+		h.emitOrderEvent(webhookOrderCreated, c.ID, "")
+	}
 
-		if strings.EqualFold(in.Delivery.Type, "delivery") {
-			fmt.Fprintf(&b, "🚚 Доставка на дом\n")
-			if strings.TrimSpace(in.Delivery.Address) != "" {
-				fmt.Fprintf(&b, "📬 Адрес клиента: %s\n", in.Delivery.Address)
-			}
-		} else {
-			fmt.Fprintf(&b, "🏃 Самовывоз\n")
-		}
-		if strings.TrimSpace(in.Delivery.Phone) != "" {
-			fmt.Fprintf(&b, "📞 Телефон клиента: %s\n", in.Delivery.Phone)
-		}
+	// телефон доставки — в карточку пользователя (если пусто) и в историю номеров
+	if uid, err := strconv.ParseInt(tgStr, 10, 64); err == nil {
+		h.rememberPhoneQuiet(uid, in.Delivery.Phone, phoneSourceOrder)
+	}
 
-		fmt.Fprintf(&b, "\n🛒 Позиции:\n")
-		for _, it := range in.Items {
-			fmt.Fprintf(&b, "• %s — %.2f (%s) × %d ₸\n", it.Name, it.Qty, it.Unit, it.Price)
+	// ⚠️ Уведомление админу с деталями доставки; заказ из нескольких точек —
+	// каждой точке своя часть, а крупный — ещё и карточка общего заказа
+	// с кнопкой «✅ Подтвердить наличие»
+	if len(children) == 0 || len(review) > 0 {
+		title := fmt.Sprintf("🧾 Новый заказ №%d (подтверждён)", orderID)
+		if len(review) > 0 {
+			title = fmt.Sprintf("🔎 Крупный заказ №%d — нужна проверка наличия", orderID)
 		}
-		fmt.Fprintf(&b, "💰 Сумма (включая доставку): %d ₸", total)
-
-		h.notifyAdmin(b.String())
+		text := h.newOrderNoticeText(title, tgStr, store.String, payMethod, storeMatch.storeMatchLine(),
+			in.Delivery, in.Items, goodsTotal, deliveryPrice) + orderReviewNote(review)
+		h.notifyAdminOrder(text, orderID, store.String, in.Delivery)
+	}
+	for _, c := range children {
+		title := fmt.Sprintf("🧾 Новый заказ №%d (подтверждён) — часть заказа №%d из %d точек", c.ID, orderID, len(children))
+		text := h.newOrderNoticeText(title, tgStr, c.StoreCode, payMethod, "", in.Delivery,
+			orderItemsIn(c.Items), c.GoodsTotal, c.DeliveryPrice)
+		h.notifyAdminOrder(text, c.ID, c.StoreCode, in.Delivery)
 	}
 
-	// Чек пользователю
-	if err := h.sendOrderReceiptToUser(tgStr, orderID, in.Items, total, store.String, payMethod); err != nil {
+	// Чек пользователю; крупному заказу — после проверки наличия
+	if len(review) > 0 {
+		h.notifyOrderReview(r.Context(), order.UserID, orderID)
+	} else if err := h.sendOrderReceiptToUser(orderID); err != nil {
 		h.logger.Warn("send receipt to user", zap.Error(err))
 	}
 
-	jsonOK(w, map[string]any{
+	out := map[string]any{
 		"status":         "ok",
 		"order_id":       orderID,
 		"goods_total":    goodsTotal,
 		"delivery_price": deliveryPrice,
 		"total":          total,
-	})
+	}
+	if len(review) > 0 {
+		out["order_status"] = orderReview
+	}
+	if len(children) > 0 {
+		out["sub_orders"] = subOrdersOut(children)
+	}
+	jsonOK(w, out)
+}
+
+// newOrderNoticeText — карточка нового заказа для админа и управляющих точки:
+// точка, оплата, доставка, позиции и итог. matchLine — сверка позиций с точкой.
+func (h *Handler) newOrderNoticeText(title, tgStr, storeCode, payMethod, matchLine string, d deliveryIn,
+	items []orderItemIn, goodsTotal, deliveryPrice int64) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", title)
+	fmt.Fprintf(&b, "👤 Telegram ID: %s\n", tgStr)
+
+	if storeCode != "" {
+		var name, addr sql.NullString
+		_ = h.db.QueryRow(`SELECT name, address FROM stores WHERE code = ?`, storeCode).Scan(&name, &addr)
+		if name.Valid {
+			fmt.Fprintf(&b, "🏪 Точка: %s\n", name.String)
+		}
+		if addr.Valid {
+			fmt.Fprintf(&b, "📍 Адрес точки: %s\n", addr.String)
+		}
+	}
+
+	fmt.Fprintf(&b, "💳 Способ оплаты: %s\n", humanPaymentMethod(payMethod))
+	b.WriteString(matchLine)
+
+	if strings.EqualFold(d.Type, "delivery") {
+		fmt.Fprintf(&b, "🚚 Доставка на дом\n")
+		if strings.TrimSpace(d.Address) != "" {
+			fmt.Fprintf(&b, "📬 Адрес клиента: %s\n", d.Address)
+		}
+	} else {
+		fmt.Fprintf(&b, "🏃 Самовывоз\n")
+	}
+	if strings.TrimSpace(d.Phone) != "" {
+		fmt.Fprintf(&b, "📞 Телефон клиента: %s\n", d.Phone)
+	}
+
+	fmt.Fprintf(&b, "\n🛒 Позиции:\n")
+	for _, it := range items {
+		if it.ProductID == 0 && it.Name == domain.DeliveryItemName {
+			continue // доставка — отдельной строкой в итоге
+		}
+		fmt.Fprintf(&b, "• %s — %s\n", itemLabel(it.Emoji, it.Name), qtyTimesPrice(it))
+		writeItemPrefs(&b, it)
+	}
+	writeOrderTotals(&b, goodsTotal, deliveryPrice, "Сумма")
+	return b.String()
+}
+
+// handleQuoteOrder — «сухой» расчёт заказа: тот же JSON, что и в /api/orders/confirm,
+// но заказ не создаётся. Мини-апп показывает эту сумму на экране подтверждения.
+func (h *Handler) handleQuoteOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, ErrMethodNotAllowed())
+		return
+	}
+	var in confirmOrderIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	if len(in.Items) == 0 {
+		writeError(w, ErrBadRequest("items are required"))
+		return
+	}
+
+	// тариф доставки зависит от выбранной точки пользователя
+	var (
+		store      sql.NullString
+		subscriber bool
+	)
+	if tgID, err := parseTelegramID(in.TelegramID); err == nil {
+		_ = h.db.QueryRow(`SELECT selected_store FROM users WHERE user_id = ?`, tgID).Scan(&store)
+		subscriber = h.hasSubscription(strconv.FormatInt(tgID, 10))
+	}
+	deliveryPrice, tier, err := h.deliveryQuote(store.String, in.Delivery)
+	if appErr := deliveryAppError(err); appErr != nil {
+		writeError(w, appErr)
+		return
+	}
+	if err != nil {
+		h.logger.Error("delivery quote", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	// цены — из каталога: гостю розничные, subscriber_only только подписчикам
+	products, appErr, err := h.priceOrderItems(in.Items, subscriber)
+	if err != nil {
+		h.logger.Error("price order items", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	if appErr != nil {
+		writeError(w, appErr)
+		return
+	}
+
+	q, err := quoteOrder(in.Items, in.Delivery, deliveryPrice)
+	if err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
+	// пределы размера заказа — те же, что при подтверждении
+	review, appErr := h.checkOrderLimits(q.Items, products, q.Total)
+	if appErr != nil {
+		writeError(w, appErr)
+		return
+	}
+
+	type line struct {
+		ProductID int64   `json:"product_id"`
+		Name      string  `json:"name"`
+		Qty       float64 `json:"qty"`
+		Unit      string  `json:"unit"`
+		Price     int64   `json:"price"`
+		PricePer  string  `json:"price_per"`
+		Amount    int64   `json:"amount"`
+	}
+	lines := make([]line, 0, len(q.Items))
+	for _, it := range q.Items {
+		lines = append(lines, line{it.ProductID, it.Name, it.Qty, it.Unit, it.Price, it.PricePer, lineAmount(it)})
+	}
+
+	out := map[string]any{
+		"items":          lines,
+		"goods_total":    q.GoodsTotal,
+		"delivery_price": q.DeliveryPrice,
+		"delivery_tier":  tier, // null — плоская ставка
+		"total":          q.Total,
+		"needs_review":   len(review) > 0, // реквизиты придут после проверки наличия
+	}
+	if _, ok := actingAs(r.Context()); ok {
+		out["dry_run"] = true
+	}
+	jsonOK(w, out)
 }
 
 func (h *Handler) handleGetSubStatus(w http.ResponseWriter, r *http.Request) {
@@ -1009,67 +1497,166 @@ func (h *Handler) handleGetSubStatus(w http.ResponseWriter, r *http.Request) {
 		r.Header.Get("X-Telegram-Id"),
 	)
 	if telegramID == "" {
-		jsonErr(w, http.StatusBadRequest, "telegram_id is required")
+		writeError(w, ErrBadRequest("telegram_id is required"))
 		return
 	}
 
-	var subStatus string
-	var subUntil sql.NullTime
-	var selectedStore sql.NullString
+	sub, err := h.subscriptionState(telegramID)
+	if err != nil {
+		h.logger.Error("select users sub", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	selectedStore := sub.store
+	// until / grace_until — ISO для кода, *_text — для показа пользователю
+	until, graceUntil, untilText, graceUntilText := "", "", "", ""
+	if sub.active {
+		until = sub.until.Format("2006-01-02")
+		untilText = formatDate(sub.until)
+	}
+	if sub.grace {
+		graceUntil = h.graceUntil(sub.until).Format("2006-01-02")
+		graceUntilText = formatDate(h.graceUntil(sub.until))
+	}
 
+	var storeName, storeAddr sql.NullString
+	var storeLng, storeLat sql.NullFloat64
+	var addrFmt sql.NullString
+	delivery := storeDelivery{Delivers: true}
+
+	if selectedStore != "" {
+		_ = h.db.QueryRow(`
+            SELECT name, COALESCE(address,''), longitude, latitude, COALESCE(address_formatted,''),
+                   delivers, delivery_radius_km
+            FROM stores WHERE code = ?`,
+			selectedStore,
+		).Scan(&storeName, &storeAddr, &storeLng, &storeLat, &addrFmt, &delivery.Delivers, &delivery.RadiusKm)
+	}
+
+	jsonOK(w, map[string]any{
+		"active":           sub.active,
+		"until":            until,
+		"until_text":       untilText,
+		"grace":            sub.grace,
+		"grace_until":      graceUntil,
+		"grace_until_text": graceUntilText,
+		"store_code":       selectedStore,
+		"store_name":       storeName.String,
+		"store_address":    firstNonEmpty(addrFmt.String, storeAddr.String),
+		"store_lng":        storeLng.Float64,
+		"store_lat":        storeLat.Float64,
+		// без доставки мини-апп показывает только самовывоз
+		"store_delivers":           delivery.Delivers,
+		"store_delivery_radius_km": delivery.radiusOut(h.cfg.DeliveryRadiusKm),
+	})
+}
+
+// subState — подписка пользователя на момент clock.Now().
+type subState struct {
+	active bool      // подписка действует (в том числе в льготный период)
+	grace  bool      // срок истёк, но идёт льготный период cfg.SubGraceDays
+	until  time.Time // оплачено до; нулевое, если подписки нет
+	store  string    // selected_store пользователя
+}
+
+// subscriptionState — статус из users, а если там пусто или истёк — последняя
+// активная запись в subscriptions. Общая логика /api/user/subscription-status и меню бота.
+func (h *Handler) subscriptionState(telegramID string) (subState, error) {
+	var (
+		st            subState
+		subStatus     sql.NullString
+		subUntil      sql.NullTime
+		selectedStore sql.NullString
+	)
 	err := h.db.QueryRow(`
 		SELECT sub_status, sub_until, selected_store
 		FROM users
 		WHERE user_id = ?
 	`, telegramID).Scan(&subStatus, &subUntil, &selectedStore)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		h.logger.Error("select users sub", zap.Error(err))
-		jsonErr(w, http.StatusInternalServerError, "db error")
-		return
+		return st, err
 	}
+	st.store = selectedStore.String
 
-	active := false
-	until := ""
-	now := time.Now()
-	if subStatus == "active" && subUntil.Valid && subUntil.Time.After(now) {
-		active = true
-		until = subUntil.Time.Format("2006-01-02")
-	} else {
-		// смотрим последнюю активную подписку в subscriptions
+	// после окончания оплаченного срока доступ сохраняется ещё cfg.SubGraceDays дней
+	now := h.clock.Now()
+	check := func(t sql.NullTime) {
+		if !t.Valid {
+			return
+		}
+		switch {
+		case t.Time.After(now):
+			st.active = true
+		case h.graceUntil(t.Time).After(now):
+			st.active, st.grace = true, true
+		default:
+			return
+		}
+		st.until = t.Time
+	}
+
+	if subStatus.String == "active" || subStatus.String == "grace" {
+		check(subUntil)
+	}
+	if !st.active {
+		// смотрим последнюю активную (или льготную) подписку в subscriptions
+		var last sql.NullTime
 		_ = h.db.QueryRow(`
 			SELECT valid_until
 			FROM subscriptions
-			WHERE user_id = ? AND status = 'active'
+			WHERE user_id = ? AND status IN ('active', 'grace')
 			ORDER BY valid_until DESC
 			LIMIT 1
-		`, telegramID).Scan(&subUntil)
-		if subUntil.Valid && subUntil.Time.After(now) {
-			active = true
-			until = subUntil.Time.Format("2006-01-02")
-		}
+		`, telegramID).Scan(&last)
+		check(last)
 	}
+	return st, nil
+}
 
-	var storeName, storeAddr sql.NullString
-	var storeLng, storeLat sql.NullFloat64
-	var addrFmt sql.NullString
+// handleCompareSubscriptionPlans — активные тарифы рядом друг с другом:
+// цена за день и флаг best_value у самого выгодного.
+func (h *Handler) handleCompareSubscriptionPlans(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.db.Query(`
+		SELECT id, code, name, price, duration_months
+		FROM subscription_plans
+		WHERE active = 1 AND duration_months > 0
+		ORDER BY sort_order, duration_months
+	`)
+	if err != nil {
+		h.logger.Error("select subscription plans", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer rows.Close()
 
-	if selectedStore.Valid && selectedStore.String != "" {
-		_ = h.db.QueryRow(`
-            SELECT name, COALESCE(address,''), longitude, latitude, COALESCE(address_formatted,'')
-            FROM stores WHERE code = ?`,
-			selectedStore.String,
-		).Scan(&storeName, &storeAddr, &storeLng, &storeLat, &addrFmt)
+	type plan struct {
+		ID             int64   `json:"id"`
+		Code           string  `json:"code"`
+		Name           string  `json:"name"`
+		Price          int64   `json:"price"`
+		DurationMonths int64   `json:"duration_months"`
+		PricePerDay    float64 `json:"price_per_day"`
+		BestValue      bool    `json:"best_value"`
+	}
+	out := []plan{}
+	best := -1
+	for rows.Next() {
+		var p plan
+		if err := rows.Scan(&p.ID, &p.Code, &p.Name, &p.Price, &p.DurationMonths); err != nil {
+			h.logger.Error("scan subscription plan", zap.Error(err))
+			continue
+		}
+		p.PricePerDay = math.Round(float64(p.Price)/float64(p.DurationMonths)/30*100) / 100
+		out = append(out, p)
+		if best < 0 || p.PricePerDay < out[best].PricePerDay {
+			best = len(out) - 1
+		}
+	}
+	if best >= 0 {
+		out[best].BestValue = true
 	}
 
-	jsonOK(w, map[string]any{
-		"active":        active,
-		"until":         until,
-		"store_code":    selectedStore.String,
-		"store_name":    storeName.String,
-		"store_address": firstNonEmpty(addrFmt.String, storeAddr.String),
-		"store_lng":     storeLng.Float64,
-		"store_lat":     storeLat.Float64,
-	})
+	jsonOK(w, out)
 }
 
 type requestInvoiceIn struct {
@@ -1078,42 +1665,74 @@ type requestInvoiceIn struct {
 }
 
 func (h *Handler) handleRequestInvoice(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInMaintenance(w) {
+		return
+	}
 	var in requestInvoiceIn
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		jsonErr(w, http.StatusBadRequest, "invalid json")
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
 		return
 	}
 	in.TelegramID = strings.TrimSpace(in.TelegramID)
 	in.Phone = strings.TrimSpace(in.Phone)
 	if in.TelegramID == "" || in.Phone == "" {
-		jsonErr(w, http.StatusBadRequest, "telegram_id and phone are required")
+		writeError(w, ErrBadRequest("telegram_id and phone are required"))
 		return
 	}
 
-	// upsert user + помечаем sub_status = pending
+	// действующая подписка: до окончания больше subReminderDays — повторно не оформляем;
+	// ближе к концу (или в льготный период) — продление, доступ не отнимаем
+	var (
+		subStatus sql.NullString
+		subUntil  sql.NullTime
+	)
+	_ = h.db.QueryRow(`SELECT sub_status, sub_until FROM users WHERE user_id = ?`, in.TelegramID).Scan(&subStatus, &subUntil)
+	now := h.clock.Now()
+	renewal := false
+	switch subStatus.String {
+	case "active":
+		if subUntil.Valid && subUntil.Time.After(now.AddDate(0, 0, subReminderDays)) {
+			jsonOK(w, map[string]string{
+				"status":     "already_active",
+				"until":      subUntil.Time.Format("2006-01-02"),
+				"until_text": formatDate(subUntil.Time),
+			})
+			return
+		}
+		renewal = true
+	case "grace":
+		renewal = true
+	}
+
+	// upsert user; sub_status = pending, только если доступа сейчас нет
 	uid := uuid.New().String()
 	_, err := h.db.Exec(`
 		INSERT INTO users (id, user_id, nickname, phone, sub_status)
 		VALUES (?, ?, COALESCE((SELECT nickname FROM users WHERE user_id = ?),'user'), ?, 'pending')
 		ON CONFLICT(user_id) DO UPDATE SET
 		  phone = excluded.phone,
-		  sub_status = 'pending',
+		  sub_status = CASE WHEN ? THEN users.sub_status ELSE 'pending' END,
 		  updated_at = CURRENT_TIMESTAMP
-	`, uid, in.TelegramID, in.TelegramID, in.Phone)
+	`, uid, in.TelegramID, in.TelegramID, in.Phone, renewal)
 	if err != nil {
 		h.logger.Error("upsert users phone", zap.Error(err))
-		jsonErr(w, http.StatusInternalServerError, "db error")
+		writeError(w, ErrInternal(err))
 		return
 	}
 
+	// номер Kaspi уже записан в users.phone выше, здесь — только история номеров
+	if tgid, err := strconv.ParseInt(in.TelegramID, 10, 64); err == nil {
+		h.rememberPhoneQuiet(tgid, in.Phone, phoneSourceSubscription)
+	}
+
 	// создаём запись в subscriptions
 	_, err = h.db.Exec(`
 		INSERT INTO subscriptions (user_id, phone, status, amount)
-		VALUES (?, ?, 'pending', 3000)
-	`, in.TelegramID, in.Phone)
+		VALUES (?, ?, 'pending', ?)
+	`, in.TelegramID, in.Phone, subscriptionMonthlyPrice)
 	if err != nil {
 		h.logger.Error("insert subscription", zap.Error(err))
-		jsonErr(w, http.StatusInternalServerError, "db error")
+		writeError(w, ErrInternal(err))
 		return
 	}
 
@@ -1133,43 +1752,60 @@ func (h *Handler) handleRequestInvoice(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// отправляем админу уведомление
+	title := "🧾 Заявка на подписку"
+	if renewal {
+		title = "🔁 Заявка на продление подписки (текущая до " + formatDate(subUntil.Time) + ")"
+	}
 	h.notifyAdmin(fmt.Sprintf(
-		"🧾 Заявка на подписку\n\n👤 Telegram ID: %s\n📞 Телефон: %s\nСумма: 3000 ₸\n\nПользователь получил ссылку Kaspi Pay и должен прислать чек. После чека — подтвердите подписку.",
-		in.TelegramID, in.Phone,
+		"%s\n\n👤 Telegram ID: %s\n📞 Телефон: %s\nСумма: %s\n\nПользователь получил ссылку Kaspi Pay и должен прислать чек. После чека — подтвердите подписку.",
+		title, in.TelegramID, in.Phone, formatMoney(subscriptionMonthlyPrice),
 	))
 
-	// отправляем пользователю ссылку на оплату через бота
-	if h.bot != nil {
-		if tgid, err := strconv.ParseInt(in.TelegramID, 10, 64); err == nil {
-			kaspiURL := strings.TrimSpace(h.cfg.KaspiPayURL)
-			if kaspiURL == "" {
-				kaspiURL = "https://pay.kaspi.kz/pay/e96vsxbs"
-			}
-
-			text := "💳 Подписка АГРО Клуб — 3000 ₸/мес.\n\n" +
-				"Перейдите по ссылке Kaspi Pay и оплатите подписку, затем отправьте сюда чек (PDF или скриншот), " +
-				"чтобы администратор подтвердил оплату.\n"
+	// отправляем пользователю ссылку на оплату через бота. Если сообщение не ушло
+	// (бот ещё не подключён или Telegram ответил ошибкой) — не говорим «ok»:
+	// мини-апп покажет ссылку сам по status=degraded.
+	kaspiURL := h.kaspiPayLink(0)
+	degraded := ""
+	if tgid, err := strconv.ParseInt(in.TelegramID, 10, 64); err != nil {
+		degraded = "bad_telegram_id"
+	} else {
+		text := "💳 Подписка АГРО Клуб — " + formatMoney(subscriptionMonthlyPrice) + "/мес.\n\n" +
+			"Перейдите по ссылке Kaspi Pay и оплатите подписку, затем отправьте сюда чек (PDF или скриншот), " +
+			"чтобы администратор подтвердил оплату.\n"
 
-			kb := &models.InlineKeyboardMarkup{
-				InlineKeyboard: [][]models.InlineKeyboardButton{
-					{
-						{Text: "💳 Оплатить подписку", URL: kaspiURL},
-					},
+		kb := &models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{
+				{
+					{Text: "💳 Оплатить подписку", URL: kaspiURL},
 				},
-			}
+			},
+		}
 
-			_, err = h.bot.SendMessage(h.ctx, &bot.SendMessageParams{
-				ChatID:      tgid,
-				Text:        text,
-				ReplyMarkup: kb,
-			})
-			if err != nil {
-				h.logger.Warn("send kaspi link to user", zap.Error(err))
-			}
+		queued, err := h.sendOrQueue(h.ctx, &bot.SendMessageParams{
+			ChatID:      tgid,
+			Text:        text,
+			ReplyMarkup: kb,
+		})
+		switch {
+		case err != nil:
+			h.logger.Warn("send kaspi link to user", zap.Error(err))
+			degraded = "send_failed"
+		case queued:
+			degraded = "bot_not_ready"
 		}
 	}
 
-	jsonOK(w, map[string]string{"status": "ok"})
+	if degraded != "" {
+		jsonOK(w, map[string]any{
+			"status":      "degraded",
+			"renewal":     renewal,
+			"reason":      degraded,
+			"payment_url": kaspiURL,
+			"message":     "Заявка принята, но ссылку на оплату не удалось отправить в Telegram. Оплатите по ссылке и пришлите чек боту.",
+		})
+		return
+	}
+	jsonOK(w, map[string]any{"status": "ok", "renewal": renewal})
 }
 
 type setStoreIn struct {
@@ -1177,104 +1813,431 @@ type setStoreIn struct {
 	Store      string `json:"store"`
 }
 
+// storeOut — точка в ответе /api/user/set-store.
+type storeOut struct {
+	Code             string   `json:"code"`
+	Name             string   `json:"name"`
+	Address          string   `json:"address"`
+	AddressFormatted string   `json:"address_formatted"`
+	Lat              *float64 `json:"lat"`
+	Lng              *float64 `json:"lng"`
+	WorkingHours     string   `json:"working_hours"`
+}
+
+// неоплаченные заказы держат пользователя на точке, где их собирают
+const unpaidOrderStatuses = `'review', 'new', 'checking', 'invoiced'`
+
 func (h *Handler) handleSetStore(w http.ResponseWriter, r *http.Request) {
 	var in setStoreIn
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		jsonErr(w, http.StatusBadRequest, "invalid json")
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
 		return
 	}
 	in.TelegramID = strings.TrimSpace(in.TelegramID)
 	in.Store = strings.TrimSpace(in.Store)
 	if in.TelegramID == "" || in.Store == "" {
-		jsonErr(w, http.StatusBadRequest, "telegram_id and store are required")
+		writeError(w, ErrBadRequest("telegram_id and store are required"))
 		return
 	}
 
-	// ensure store exists
-	var cnt int
-	_ = h.db.QueryRow(`SELECT COUNT(1) FROM stores WHERE code = ? OR name = ?`, in.Store, in.Store).Scan(&cnt)
-	if cnt == 0 {
-		jsonErr(w, 400, "store not found")
+	// точку можно передать кодом или названием — дальше работаем с кодом;
+	// название сравнивается через LIKE без шаблонов: % и _ ищутся буквально
+	var (
+		st       storeOut
+		lat, lng sql.NullFloat64
+	)
+	err := h.db.QueryRow(`
+		SELECT code, name, COALESCE(address,''), COALESCE(address_formatted,''), latitude, longitude, COALESCE(working_hours,'')
+		FROM stores WHERE code = ? OR name LIKE ? ESCAPE '\'
+		ORDER BY code = ? DESC
+		LIMIT 1
+	`, in.Store, escapeLike(in.Store), in.Store).Scan(&st.Code, &st.Name, &st.Address, &st.AddressFormatted, &lat, &lng, &st.WorkingHours)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, ErrBadRequest("store not found"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("select store", zap.Error(err))
+		writeError(w, ErrInternal(err))
 		return
 	}
+	if lat.Valid && lng.Valid {
+		st.Lat, st.Lng = &lat.Float64, &lng.Float64
+	}
+
+	var prev sql.NullString
+	_ = h.db.QueryRow(`SELECT selected_store FROM users WHERE user_id = ?`, in.TelegramID).Scan(&prev)
 
+	if prev.String != "" && prev.String != st.Code {
+		var orderID int64
+		err := h.db.QueryRow(`
+			SELECT id FROM orders
+			WHERE user_id = ? AND store_code = ? AND status IN (`+unpaidOrderStatuses+`)
+			ORDER BY id DESC LIMIT 1
+		`, in.TelegramID, prev.String).Scan(&orderID)
+		if err == nil {
+			writeError(w, ErrConflict(fmt.Sprintf("order #%d at the current store is not paid yet", orderID)).
+				WithCode("unpaid_order_in_store"))
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			h.logger.Error("select unpaid order", zap.Error(err))
+			writeError(w, ErrInternal(err))
+			return
+		}
+	}
+
+	// previous_store / store_changed_at меняются только при реальной смене точки
 	uid := uuid.New().String()
-	_, err := h.db.Exec(`
+	_, err = h.db.Exec(`
 		INSERT INTO users (id, user_id, nickname, selected_store)
 		VALUES (?, ?, COALESCE((SELECT nickname FROM users WHERE user_id = ?),'user'), ?)
 		ON CONFLICT(user_id) DO UPDATE SET
+		  previous_store = CASE WHEN users.selected_store <> excluded.selected_store
+		                        THEN users.selected_store ELSE users.previous_store END,
+		  store_changed_at = CASE WHEN users.selected_store <> excluded.selected_store
+		                          THEN CURRENT_TIMESTAMP ELSE users.store_changed_at END,
 		  selected_store = excluded.selected_store,
 		  updated_at = CURRENT_TIMESTAMP
-	`, uid, in.TelegramID, in.TelegramID, in.Store)
+	`, uid, in.TelegramID, in.TelegramID, st.Code)
 	if err != nil {
 		h.logger.Error("update selected_store", zap.Error(err))
-		jsonErr(w, http.StatusInternalServerError, "db error")
+		writeError(w, ErrInternal(err))
 		return
 	}
 
-	jsonOK(w, map[string]string{"status": "ok"})
+	// каталог прежней точки больше не актуален
+	if h.redisClient != nil && prev.String != st.Code {
+		if tgID, err := strconv.ParseInt(in.TelegramID, 10, 64); err == nil {
+			if err := h.redisClient.DeleteProductCache(r.Context(), tgID); err != nil {
+				h.logger.Warn("invalidate product cache", zap.Int64("user_id", tgID), zap.Error(err))
+			}
+		}
+	}
+
+	jsonOK(w, map[string]any{
+		"status":         "ok",
+		"store":          st,
+		"previous_store": prev.String,
+	})
 }
 
-func (h *Handler) handleGetProducts(w http.ResponseWriter, r *http.Request) {
-	// опционально фильтруем по store_code, если у пользователя выбран магазин (X-Telegram-Id)
+// selectedStore возвращает код магазина, выбранного пользователем из X-Telegram-Id (или "").
+func (h *Handler) selectedStore(r *http.Request) string {
 	tgid := strings.TrimSpace(r.Header.Get("X-Telegram-Id"))
+	if tgid == "" {
+		return ""
+	}
 	var store sql.NullString
-	if tgid != "" {
-		_ = h.db.QueryRow(`SELECT selected_store FROM users WHERE user_id = ?`, tgid).Scan(&store)
-	}
-
-	var rows *sql.Rows
-	var err error
-	if store.Valid && store.String != "" {
-		rows, err = h.db.Query(`
-			SELECT id, name, COALESCE(emoji,''), category_slug, unit, price, COALESCE(photo_path,''), COALESCE(store_code,'')
-			FROM products
-			WHERE active = 1 AND (store_code = ? OR store_code IS NULL OR store_code = '')
-			ORDER BY category_slug, name
-		`, store.String)
-	} else {
-		rows, err = h.db.Query(`
-			SELECT id, name, COALESCE(emoji,''), category_slug, unit, price, COALESCE(photo_path,''), COALESCE(store_code,'')
-			FROM products
-			WHERE active = 1
-			ORDER BY category_slug, name
-		`)
+	_ = h.db.QueryRow(`SELECT selected_store FROM users WHERE user_id = ?`, tgid).Scan(&store)
+	return store.String
+}
+
+func (h *Handler) handleGetProducts(w http.ResponseWriter, r *http.Request) {
+	// опционально фильтруем по store_code, если у пользователя выбран магазин (X-Telegram-Id)
+	store := h.selectedStore(r)
+	// ?tag=promo — только товары с этим ярлыком
+	tag := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("tag")))
+	// ?category=vegetables — категория вместе с её подкатегориями
+	category := strings.TrimSpace(r.URL.Query().Get("category"))
+
+	// подписчик видит оптовые цены, остальные — розничные и «🔒» вместо скрытых
+	subscriber := h.isSubscriber(r)
+	key := repository.ProductsCacheKey(store, tag)
+	if subscriber {
+		key = repository.SubscriberProductsCacheKey(store, tag)
+	}
+	if category != "" {
+		key += ":category:" + category
+	}
+	if data := h.cachedProducts(r.Context(), key); data != nil {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = w.Write(data)
+		return
+	}
+
+	var categories []string
+	if category != "" {
+		var err error
+		if categories, err = h.categoryWithChildren(category); err != nil {
+			h.logger.Error("select sub-categories", zap.String("category", category), zap.Error(err))
+			writeError(w, ErrInternal(err))
+			return
+		}
 	}
+	out, err := h.listProducts(store, tag, categories...)
 	if err != nil {
 		h.logger.Error("select products", zap.Error(err))
-		jsonErr(w, http.StatusInternalServerError, "db error")
+		writeError(w, ErrInternal(err))
 		return
 	}
+	if !subscriber {
+		out = guestPrices(out)
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		writeError(w, ErrInternal(err))
+		return
+	}
+	data = append(data, '\n')
+	h.cacheProducts(r.Context(), key, data)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, _ = w.Write(data)
+}
+
+type productOut struct {
+	ID             int64      `json:"id"`
+	Name           string     `json:"name"`
+	Emoji          string     `json:"emoji"`
+	Category       string     `json:"category"`
+	Unit           string     `json:"unit"`
+	Price          int64      `json:"price"`
+	PricePer       string     `json:"price_per"` // kg | 100g | piece | bundle; пусто — за unit
+	RetailPrice    *int64     `json:"retail_price"`
+	SubscriberOnly bool       `json:"subscriber_only"`
+	Locked         bool       `json:"locked"`                // оптовая цена скрыта — нужна подписка
+	LockedText     string     `json:"locked_text,omitempty"` // что показать вместо неё
+	PromoPrice     *int64     `json:"promo_price"`           // цена по действующей акции
+	Badge          string     `json:"badge,omitempty"`       // ярлык акции
+	PromoEndsAt    *time.Time `json:"promo_ends_at,omitempty"`
+	Photo          string     `json:"photo"`
+	Store          string     `json:"store_code"`
+	Tags           []string   `json:"tags"`
+}
+
+// listProducts — активные товары для мини-аппа с фильтром по точке и ярлыку;
+// categories, если переданы, — только товары этих категорий.
+func (h *Handler) listProducts(store, tag string, categories ...string) ([]productOut, error) {
+	query := `
+		SELECT id, name, COALESCE(emoji,''), category_slug, unit, price, COALESCE(price_per,''), retail_price, subscriber_only,
+		       COALESCE(photo_path,''), COALESCE(store_code,'')
+		FROM products
+		WHERE active = 1`
+	var args []any
+	if store != "" {
+		query += ` AND (store_code = ? OR store_code IS NULL OR store_code = '')`
+		args = append(args, store)
+	}
+	if tag != "" {
+		query += ` AND id IN (SELECT product_id FROM product_tags WHERE tag = ?)`
+		args = append(args, tag)
+	}
+	if len(categories) > 0 {
+		query += ` AND category_slug IN (?` + strings.Repeat(", ?", len(categories)-1) + `)`
+		for _, c := range categories {
+			args = append(args, c)
+		}
+	}
+	query += ` ORDER BY category_slug, sort_order, name`
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
+	var out []productOut
+	for rows.Next() {
+		var p productOut
+		if err := rows.Scan(&p.ID, &p.Name, &p.Emoji, &p.Category, &p.Unit, &p.Price, &p.PricePer, &p.RetailPrice, &p.SubscriberOnly, &p.Photo, &p.Store); err != nil {
+			h.logger.Error("scan product", zap.Error(err))
+			continue
+		}
+		out = append(out, p)
+	}
+
+	tags := h.loadProductTags()
+	for i := range out {
+		out[i].Tags = tags[out[i].ID]
+		if out[i].Tags == nil {
+			out[i].Tags = []string{}
+		}
+	}
+
+	now := h.clock.Now()
+	promos, err := h.loadPromotions(now)
+	if err != nil {
+		return nil, err
+	}
+	promos.applyPromotions(out, now)
+	return out, nil
+}
+
+// сколько избранных товаров отдаём в карусель: по умолчанию и максимум (?limit=)
+const (
+	featuredDefaultLimit = 10
+	featuredMaxLimit     = 30
+)
+
+// handleGetFeaturedProducts — избранные товары для карусели на главной:
+// GET /api/products/featured?limit=10, с учётом выбранного магазина.
+func (h *Handler) handleGetFeaturedProducts(w http.ResponseWriter, r *http.Request) {
+	limit := featuredDefaultLimit
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = min(v, featuredMaxLimit)
+	}
+
+	query := `
+		SELECT id, name, COALESCE(emoji,''), category_slug, unit, price, COALESCE(price_per,''), retail_price, subscriber_only,
+		       COALESCE(photo_path,''), COALESCE(store_code,'')
+		FROM products
+		WHERE active = 1 AND featured = 1`
+	var args []any
+	if store := h.selectedStore(r); store != "" {
+		query += ` AND (store_code = ? OR store_code IS NULL OR store_code = '')`
+		args = append(args, store)
+	}
+	query += ` ORDER BY sort_order, name LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		h.logger.Error("select featured products", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer rows.Close()
+
+	var products []productOut
+	for rows.Next() {
+		var p productOut
+		if err := rows.Scan(&p.ID, &p.Name, &p.Emoji, &p.Category, &p.Unit, &p.Price, &p.PricePer, &p.RetailPrice, &p.SubscriberOnly, &p.Photo, &p.Store); err != nil {
+			h.logger.Error("scan featured product", zap.Error(err))
+			continue
+		}
+		products = append(products, p)
+	}
+	// гостю — розничная цена или замок, как в /api/products
+	if !h.isSubscriber(r) {
+		products = guestPrices(products)
+	}
+
 	type product struct {
-		ID       int64  `json:"id"`
-		Name     string `json:"name"`
-		Emoji    string `json:"emoji"`
-		Category string `json:"category"`
-		Unit     string `json:"unit"`
-		Price    int64  `json:"price"`
-		Photo    string `json:"photo"`
-		Store    string `json:"store_code"`
+		ID         int64  `json:"id"`
+		Name       string `json:"name"`
+		Emoji      string `json:"emoji"`
+		Category   string `json:"category"`
+		Unit       string `json:"unit"`
+		Price      int64  `json:"price"`
+		PricePer   string `json:"price_per"`
+		Locked     bool   `json:"locked"`
+		LockedText string `json:"locked_text,omitempty"`
+		Photo      string `json:"photo"`
+		Store      string `json:"store_code"`
+	}
+	out := make([]product, 0, len(products))
+	for _, p := range products {
+		out = append(out, product{p.ID, p.Name, p.Emoji, p.Category, p.Unit, p.Price, p.PricePer, p.Locked, p.LockedText, p.Photo, p.Store})
+	}
+	jsonOK(w, out)
+}
+
+// handleGetProduct — карточка одного товара для мини-аппа: GET /api/products/{id}
+func (h *Handler) handleGetProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, ErrBadRequest("bad id"))
+		return
+	}
+
+	type pricePoint struct {
+		Date  string `json:"date"`
+		Price int64  `json:"price"`
+	}
+	var p struct {
+		ID                 int64        `json:"id"`
+		Name               string       `json:"name"`
+		Emoji              string       `json:"emoji"`
+		Category           string       `json:"category"`
+		Unit               string       `json:"unit"`
+		Price              int64        `json:"price"`
+		PricePer           string       `json:"price_per"`
+		Locked             bool         `json:"locked"`
+		LockedText         string       `json:"locked_text,omitempty"`
+		Photo              string       `json:"photo"`
+		Store              string       `json:"store_code"`
+		DescriptionHTML    string       `json:"description_html"`
+		Photos             []string     `json:"photos"`
+		PriceHistory7d     []pricePoint `json:"price_history_7d"`
+		StorePriceOverride *int64       `json:"store_price_override"`
+		Tags               []string     `json:"tags"`
+	}
+	var (
+		desc    string
+		pricing productOut
+	)
+	err = h.db.QueryRow(`
+		SELECT id, name, COALESCE(emoji,''), category_slug, unit, price, COALESCE(price_per,''), retail_price, subscriber_only,
+		       COALESCE(photo_path,''), COALESCE(store_code,''), COALESCE(description,'')
+		FROM products
+		WHERE id = ? AND active = 1
+	`, id).Scan(&p.ID, &p.Name, &p.Emoji, &p.Category, &p.Unit, &p.Price, &p.PricePer, &pricing.RetailPrice, &pricing.SubscriberOnly,
+		&p.Photo, &p.Store, &desc)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, ErrNotFound("product"))
+			return
+		}
+		h.logger.Error("get product", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	// гостю — розничная цена или замок, как в /api/products
+	if pricing.Price = p.Price; !h.isSubscriber(r) {
+		pricing = guestPrices([]productOut{pricing})[0]
+		p.Price, p.Locked, p.LockedText = pricing.Price, pricing.Locked, pricing.LockedText
+	}
+
+	p.DescriptionHTML = strings.ReplaceAll(html.EscapeString(desc), "\n", "<br>")
+	p.Photos = []string{}
+	if p.Photo != "" {
+		p.Photos = append(p.Photos, p.Photo)
+	}
+	p.Tags = h.productTags(p.ID)
+	if p.Tags == nil {
+		p.Tags = []string{}
+	}
+	p.PriceHistory7d = []pricePoint{}
+	if p.Locked {
+		// история — оптовые цены: при закрытой цене не показываем
+		jsonOK(w, p)
+		return
 	}
 
-	var out []product
-	for rows.Next() {
-		var p product
-		if err := rows.Scan(&p.ID, &p.Name, &p.Emoji, &p.Category, &p.Unit, &p.Price, &p.Photo, &p.Store); err != nil {
-			h.logger.Error("scan product", zap.Error(err))
-			continue
+	rows, err := h.db.Query(`
+		SELECT price_date, price
+		FROM price_feed
+		WHERE product_id = ? AND price_date >= ?
+		ORDER BY price_date
+	`, id, time.Now().UTC().AddDate(0, 0, -7).Format("2006-01-02"))
+	if err != nil {
+		h.logger.Warn("select price history", zap.Error(err))
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var pp pricePoint
+			var d time.Time
+			if err := rows.Scan(&d, &pp.Price); err != nil {
+				h.logger.Warn("scan price history", zap.Error(err))
+				continue
+			}
+			pp.Date = d.Format("2006-01-02")
+			p.PriceHistory7d = append(p.PriceHistory7d, pp)
 		}
-		out = append(out, p)
 	}
 
-	jsonOK(w, out)
+	jsonOK(w, p)
 }
 
 func (h *Handler) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
+	if h.rejectInMaintenance(w) {
+		return
+	}
 	var in createOrderIn
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		jsonErr(w, http.StatusBadRequest, "invalid json")
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
 		return
 	}
 
@@ -1288,74 +2251,85 @@ func (h *Handler) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	tgStr = strings.TrimSpace(tgStr)
-	if tgStr == "" || len(in.Items) == 0 {
-		jsonErr(w, http.StatusBadRequest, "telegram_id and items are required")
+	if _, err := strconv.ParseInt(tgStr, 10, 64); err != nil || len(in.Items) == 0 {
+		writeError(w, ErrBadRequest("telegram_id and items are required"))
+		return
+	}
+
+	unlock, ok := h.lockUserHTTP(w, r, tgStr)
+	if !ok {
 		return
 	}
+	defer unlock()
 
 	// Получим магазин пользователя
 	var store sql.NullString
 	_ = h.db.QueryRow(`SELECT selected_store FROM users WHERE user_id = ?`, tgStr).Scan(&store)
 
-	// Транзакция создания заказа
-	tx, err := h.db.Begin()
+	if err := checkItemNotes(in.Items); err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
+	// цены — из каталога: гостю розничные, subscriber_only только подписчикам;
+	// цена товаров по акции — по серверному времени, а не из корзины
+	products, appErr, err := h.priceOrderItems(in.Items, h.hasSubscription(tgStr))
 	if err != nil {
-		h.logger.Error("tx begin", zap.Error(err))
-		jsonErr(w, http.StatusInternalServerError, "db error")
+		h.logger.Error("price order items", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	if appErr != nil {
+		writeError(w, appErr)
 		return
 	}
-	defer func() { _ = tx.Rollback() }()
-
 	var total int64
 	for _, it := range in.Items {
-		if it.Qty <= 0 || it.Price < 0 {
-			jsonErr(w, http.StatusBadRequest, "bad item qty/price")
+		if err := checkItemQty(it); err != nil {
+			writeError(w, ErrBadRequest(err.Error()))
 			return
 		}
-		total += int64(it.Qty * float64(it.Price))
+		total += lineAmount(it)
 	}
-
-	res, err := tx.Exec(`
-		INSERT INTO orders (user_id, store_code, total_amount, status)
-		VALUES (?, ?, ?, 'new')
-	`, tgStr, nullIfEmpty(store.String), total)
-	if err != nil {
-		h.logger.Error("insert order", zap.Error(err))
-		jsonErr(w, http.StatusInternalServerError, "db error")
+	review, appErr := h.checkOrderLimits(in.Items, products, total)
+	if appErr != nil {
+		writeError(w, appErr)
 		return
 	}
-	orderID, _ := res.LastInsertId()
 
-	stmt, err := tx.Prepare(`
-		INSERT INTO order_items (order_id, product_id, name, unit, qty, price, amount)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`)
-	if err != nil {
-		h.logger.Error("prepare order items", zap.Error(err))
-		jsonErr(w, http.StatusInternalServerError, "db error")
+	_, dryRun := actingAs(r.Context()) // X-Act-As — как в handleConfirmOrder
+	if orderID, dup := h.recentOrder(tgStr, total); dup && !dryRun {
+		jsonOK(w, map[string]any{"status": "ok", "order_id": orderID, "total": total, "duplicate": true})
 		return
 	}
-	defer stmt.Close()
 
-	for _, it := range in.Items {
-		amount := int64(it.Qty * float64(it.Price))
-		if _, err := stmt.Exec(orderID, it.ProductID, it.Name, it.Unit, it.Qty, it.Price, amount); err != nil {
-			h.logger.Error("insert order item", zap.Error(err))
-			jsonErr(w, http.StatusInternalServerError, "db error")
-			return
-		}
+	// Заказ и позиции — одной транзакцией в OrderRepository.Create
+	// способ оплаты покупатель выберет кнопками в боте (payment-method.go)
+	snapshotOrderItems(in.Items, products)
+	order := newOrder(tgStr, store.String, paymentPending, total, in.Items)
+	if len(review) > 0 {
+		order.Status = orderReview
 	}
-
-	if err := tx.Commit(); err != nil {
-		h.logger.Error("tx commit", zap.Error(err))
-		jsonErr(w, http.StatusInternalServerError, "db error")
+	if dryRun {
+		h.dryRunOrder(w, r, order, nil)
+		return
+	}
+	orderID, err := h.orderRepo.Create(r.Context(), order)
+	if err != nil {
+		h.logger.Error("create order", zap.Error(err))
+		writeError(w, ErrInternal(err))
 		return
 	}
 
+	h.emitOrderEvent(webhookOrderCreated, orderID, "")
+
 	// Уведомление админу
 	{
 		var b strings.Builder
-		fmt.Fprintf(&b, "🧾 Новый заказ\n\n")
+		if len(review) > 0 {
+			fmt.Fprintf(&b, "🔎 Крупный заказ №%d — нужна проверка наличия\n\n", orderID)
+		} else {
+			fmt.Fprintf(&b, "🧾 Новый заказ №%d\n\n", orderID)
+		}
 		fmt.Fprintf(&b, "👤 Telegram ID: %s\n", tgStr)
 		if store.Valid && store.String != "" {
 			var name, addr sql.NullString
@@ -1369,36 +2343,39 @@ func (h *Handler) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 		}
 		fmt.Fprintf(&b, "🛒 Позиции:\n")
 		for _, it := range in.Items {
-			fmt.Fprintf(&b, "• %s — %.2f (%s) × %d ₸\n", it.Name, it.Qty, it.Unit, it.Price)
+			fmt.Fprintf(&b, "• %s — %s\n", itemLabel(it.Emoji, it.Name), qtyTimesPrice(it))
+			writeItemPrefs(&b, it)
 		}
-		fmt.Fprintf(&b, "💰 Сумма: %d ₸", total)
+		fmt.Fprintf(&b, "💰 Сумма: %s", formatMoney(total))
+		b.WriteString(orderReviewNote(review))
 
-		h.notifyAdmin(b.String())
+		// /api/orders/create — без доставки: навигация до точки самовывоза
+		h.notifyAdminOrder(b.String(), orderID, store.String, deliveryIn{Type: "pickup"})
 	}
 
-	// Чек пользователю с кнопкой Kaspi Pay (по умолчанию kaspi_link)
-	if err := h.sendOrderReceiptToUser(tgStr, orderID, in.Items, total, store.String, paymentKaspiLink); err != nil {
-		h.logger.Warn("send receipt to user", zap.Error(err))
+	// Выбор способа оплаты; чек уйдёт после выбора (или через 10 минут с kaspi_link).
+	// Крупный заказ — после проверки наличия
+	if len(review) > 0 {
+		h.notifyOrderReview(r.Context(), order.UserID, orderID)
+		jsonOK(w, map[string]any{"status": "ok", "order_id": orderID, "total": total, "order_status": orderReview})
+		return
 	}
+	h.askPaymentMethod(r.Context(), order.UserID, orderID)
 
 	jsonOK(w, map[string]any{"status": "ok", "order_id": orderID, "total": total})
 }
 
 // Формирует и отправляет пользователю сообщение с позициями, суммой и способом оплаты.
-func (h *Handler) sendOrderReceiptToUser(telegramID string, orderID int64, items []orderItemIn, total int64, storeCode string, paymentMethod string) error {
-	if h.bot == nil {
-		return fmt.Errorf("bot is nil")
-	}
-
-	// 1) Преобразуем telegramID -> int64 ChatID
-	tgStr := strings.TrimSpace(telegramID)
-	if tgStr == "" {
-		return fmt.Errorf("empty telegram id")
-	}
-	tgid, err := strconv.ParseInt(tgStr, 10, 64)
+// Всё берётся из БД, поэтому чек можно переотправить в любой момент (/api/orders/resend-receipt).
+// Пока бот не подключён, чек ждёт в outbox.
+func (h *Handler) sendOrderReceiptToUser(orderID int64) error {
+	// 1) Заказ: кому отправлять, точка, сумма, способ оплаты
+	order, err := h.orderRepo.Get(h.ctx, orderID)
 	if err != nil {
-		return fmt.Errorf("bad telegram id: %w", err)
+		return fmt.Errorf("select order: %w", err)
 	}
+	tgid, storeCode, total, paymentMethod := order.UserID, order.StoreCode, order.TotalAmount, order.PaymentMethod
+	items := orderItemsIn(order.Items)
 
 	// 2) Достанем информацию о точке (если есть)
 	var storeName, storeAddr string
@@ -1415,7 +2392,9 @@ func (h *Handler) sendOrderReceiptToUser(telegramID string, orderID int64, items
 
 	// 3) Сформируем текст чека
 	var b strings.Builder
-	fmt.Fprintf(&b, "✅ Заказ №%d принят!\n\n", orderID)
+	// первая строка — настраиваемый автоответ статуса "new" (order_status_messages)
+	b.WriteString(h.orderStatusText("new", orderID, fmt.Sprintf("✅ Заказ №%d принят!", orderID)))
+	b.WriteString("\n\n")
 	if storeName != "" {
 		fmt.Fprintf(&b, "🏪 Точка: %s\n", storeName)
 	}
@@ -1427,55 +2406,47 @@ func (h *Handler) sendOrderReceiptToUser(telegramID string, orderID int64, items
 
 	b.WriteString("🛒 Позиции:\n")
 
-	var calcTotal int64
+	var goods, delivery int64
 	for _, it := range items {
-		if it.Qty <= 0 || it.Price < 0 {
+		if checkItemQty(it) != nil {
 			continue
 		}
-		lineAmount := int64(it.Qty * float64(it.Price))
-		calcTotal += lineAmount
+		amount := lineAmount(it)
+		if it.ProductID == 0 && it.Name == domain.DeliveryItemName {
+			delivery += amount
+			continue
+		}
+		goods += amount
 
-		fmt.Fprintf(&b, "• %s — %.2f %s × %d ₸ = %d ₸\n",
-			it.Name, it.Qty, it.Unit, it.Price, lineAmount)
+		fmt.Fprintf(&b, "• %s — %s = %s\n", itemLabel(it.Emoji, it.Name), qtyTimesPrice(it), formatMoney(amount))
 	}
 
-	if calcTotal == 0 && total > 0 {
-		calcTotal = total
+	if goods+delivery == 0 && total > 0 {
+		goods, delivery = order.GoodsTotal, order.DeliveryPrice
 	}
+	calcTotal := goods + delivery
 
-	fmt.Fprintf(&b, "\n💰 Итого к оплате: %d ₸\n", calcTotal)
+	b.WriteString("\n")
+	writeOrderTotals(&b, goods, delivery, "Итого к оплате")
+	b.WriteString("\n")
+	// заказ из нескольких точек: какие точки собирают и что оплата одна
+	h.writeSubOrders(&b, orderID)
 
 	// ReplyMarkup
 	var kb models.ReplyMarkup
 	switch paymentMethod {
-	case paymentKaspiLink:
-		kaspiURL := h.cfg.KaspiPayURL
-		if strings.TrimSpace(kaspiURL) == "" {
-			kaspiURL = "https://pay.kaspi.kz/pay/e96vsxbs"
-		}
-		kb = &models.InlineKeyboardMarkup{
-			InlineKeyboard: [][]models.InlineKeyboardButton{
-				{
-					{Text: "💳 Оплатить в Kaspi", URL: kaspiURL},
-				},
-			},
-		}
 	case paymentKaspiTransfer:
 		b.WriteString("\n📌 Реквизиты для перевода на Kaspi Gold:\n")
 		fmt.Fprintf(&b, "Номер карты: %s\n", kaspiGoldNumber)
 		fmt.Fprintf(&b, "Получатель: %s\n\n", kaspiGoldOwnerName)
 		b.WriteString("После оплаты, пожалуйста, отправьте сюда PDF или скрин чека, чтобы мы могли подтвердить платеж ✅.\n")
 	case paymentCash:
-		b.WriteString("\n💵 Оплата наличными при получении заказа.\n")
-	default:
-		kaspiURL := h.cfg.KaspiPayURL
-		if strings.TrimSpace(kaspiURL) == "" {
-			kaspiURL = "https://pay.kaspi.kz/pay/e96vsxbs"
-		}
+		b.WriteString("\n💵 Оплата наличными при получении заказа — сейчас ничего делать не нужно.\n")
+	default: // paymentKaspiLink
 		kb = &models.InlineKeyboardMarkup{
 			InlineKeyboard: [][]models.InlineKeyboardButton{
 				{
-					{Text: "💳 Оплатить в Kaspi", URL: kaspiURL},
+					{Text: "💳 Оплатить в Kaspi", URL: h.kaspiPayLink(calcTotal)},
 				},
 			},
 		}
@@ -1490,7 +2461,7 @@ func (h *Handler) sendOrderReceiptToUser(telegramID string, orderID int64, items
 		params.ReplyMarkup = kb
 	}
 
-	_, err = h.bot.SendMessage(h.ctx, params)
+	_, err = h.sendOrQueue(h.ctx, params)
 	return err
 }
 
@@ -1498,17 +2469,18 @@ func (h *Handler) sendOrderReceiptToUser(telegramID string, orderID int64, items
 
 func (h *Handler) handleAdminListProducts(w http.ResponseWriter, r *http.Request) {
 	if !h.isAdminRequest(r) {
-		jsonErr(w, http.StatusForbidden, "forbidden")
+		writeError(w, ErrForbidden())
 		return
 	}
 	rows, err := h.db.Query(`
-		SELECT id, name, category_slug, unit, price, active, COALESCE(photo_path,''), COALESCE(description,''), COALESCE(store_code,'')
+		SELECT id, name, category_slug, unit, price, active, COALESCE(photo_path,''), COALESCE(description,''), COALESCE(store_code,''), featured, sort_order, stock_qty,
+		       subscriber_only, retail_price, COALESCE(price_per,''), cost_price
 		FROM products
-		ORDER BY category_slug, name
+		ORDER BY category_slug, sort_order, name
 	`)
 	if err != nil {
 		h.logger.Error("admin list products", zap.Error(err))
-		jsonErr(w, 500, "db error")
+		writeError(w, ErrInternal(err))
 		return
 	}
 	defer rows.Close()
@@ -1523,27 +2495,44 @@ func (h *Handler) handleAdminListProducts(w http.ResponseWriter, r *http.Request
 		Photo       string `json:"photo"`
 		Description string `json:"description"`
 		Store       string `json:"store_code"`
+		Featured    int64  `json:"featured"`
+		SortOrder   int64  `json:"sort_order"`
+		StockQty    *int64 `json:"stock_qty"`
+		// 1 — цена только для подписчиков; retail_price — цена без подписки
+		SubscriberOnly int64  `json:"subscriber_only"`
+		RetailPrice    *int64 `json:"retail_price"`
+		PricePer       string `json:"price_per"`
+		// закупочная цена и валовая маржа (price − cost_price); null — закупочной цены нет
+		CostPrice *int64   `json:"cost_price"`
+		MarginKZT *int64   `json:"margin_kzt"`
+		MarginPct *float64 `json:"margin_pct"`
+		Tags      []string `json:"tags"`
 	}
 	var out []product
 	for rows.Next() {
 		var p product
-		if err := rows.Scan(&p.ID, &p.Name, &p.Category, &p.Unit, &p.Price, &p.Active, &p.Photo, &p.Description, &p.Store); err != nil {
+		if err := rows.Scan(&p.ID, &p.Name, &p.Category, &p.Unit, &p.Price, &p.Active, &p.Photo, &p.Description, &p.Store, &p.Featured, &p.SortOrder, &p.StockQty, &p.SubscriberOnly, &p.RetailPrice, &p.PricePer, &p.CostPrice); err != nil {
 			h.logger.Error("scan product", zap.Error(err))
 			continue
 		}
+		p.MarginKZT, p.MarginPct = productMargin(p.Price, p.CostPrice)
 		out = append(out, p)
 	}
+	tags := h.loadProductTags()
+	for i := range out {
+		out[i].Tags = tags[out[i].ID]
+	}
 	jsonOK(w, out)
 }
 
 func (h *Handler) handleAdminGetProduct(w http.ResponseWriter, r *http.Request) {
 	if !h.isAdminRequest(r) {
-		jsonErr(w, http.StatusForbidden, "forbidden")
+		writeError(w, ErrForbidden())
 		return
 	}
 	idStr := strings.TrimSpace(r.URL.Query().Get("id"))
 	if idStr == "" {
-		jsonErr(w, 400, "id required")
+		writeError(w, ErrBadRequest("id required"))
 		return
 	}
 	id, _ := strconv.ParseInt(idStr, 10, 64)
@@ -1557,41 +2546,58 @@ func (h *Handler) handleAdminGetProduct(w http.ResponseWriter, r *http.Request)
 		Photo       string `json:"photo"`
 		Description string `json:"description"`
 		Store       string `json:"store_code"`
+		Featured    int64  `json:"featured"`
+		SortOrder   int64  `json:"sort_order"`
+		StockQty    *int64 `json:"stock_qty"`
+		// 1 — цена только для подписчиков; retail_price — цена без подписки
+		SubscriberOnly int64    `json:"subscriber_only"`
+		RetailPrice    *int64   `json:"retail_price"`
+		PricePer       string   `json:"price_per"`
+		MaxQty         *float64 `json:"max_qty"` // null — общий предел ORDER_MAX_ITEM_QTY
+		CostPrice      *int64   `json:"cost_price"`
+		MarginKZT      *int64   `json:"margin_kzt"`
+		MarginPct      *float64 `json:"margin_pct"`
+		Tags           []string `json:"tags"`
 	}
 	err := h.db.QueryRow(`
-		SELECT id, name, category_slug, unit, price, active, COALESCE(photo_path,''), COALESCE(description,''), COALESCE(store_code,'')
+		SELECT id, name, category_slug, unit, price, active, COALESCE(photo_path,''), COALESCE(description,''), COALESCE(store_code,''), featured, sort_order, stock_qty,
+		       subscriber_only, retail_price, COALESCE(price_per,''), max_qty, cost_price
 		FROM products WHERE id = ?`, id).Scan(
-		&p.ID, &p.Name, &p.Category, &p.Unit, &p.Price, &p.Active, &p.Photo, &p.Description, &p.Store,
+		&p.ID, &p.Name, &p.Category, &p.Unit, &p.Price, &p.Active, &p.Photo, &p.Description, &p.Store, &p.Featured, &p.SortOrder, &p.StockQty, &p.SubscriberOnly, &p.RetailPrice, &p.PricePer, &p.MaxQty, &p.CostPrice,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			jsonErr(w, 404, "not found")
+			writeError(w, ErrNotFound("product"))
 			return
 		}
 		h.logger.Error("get product", zap.Error(err))
-		jsonErr(w, 500, "db error")
+		writeError(w, ErrInternal(err))
 		return
 	}
+	p.MarginKZT, p.MarginPct = productMargin(p.Price, p.CostPrice)
+	p.Tags = h.productTags(p.ID)
 	jsonOK(w, p)
 }
 
 func (h *Handler) handleAdminUpdateProduct(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		writeError(w, ErrMethodNotAllowed())
 		return
 	}
-	if !h.isAdminRequest(r) {
-		jsonErr(w, http.StatusForbidden, "forbidden")
+	// управляющий точки меняет только товары своей точки
+	scope, ok := h.storeAccess(r)
+	if !ok {
+		writeError(w, ErrForbidden())
 		return
 	}
 	if err := r.ParseMultipartForm(10 << 20); err != nil {
-		jsonErr(w, 400, "invalid multipart form")
+		writeError(w, ErrBadRequest("invalid multipart form"))
 		return
 	}
 
 	idStr := strings.TrimSpace(r.FormValue("id"))
 	if idStr == "" {
-		jsonErr(w, 400, "id required")
+		writeError(w, ErrBadRequest("id required"))
 		return
 	}
 	id, _ := strconv.ParseInt(idStr, 10, 64)
@@ -1604,9 +2610,64 @@ func (h *Handler) handleAdminUpdateProduct(w http.ResponseWriter, r *http.Reques
 	desc := strings.TrimSpace(r.FormValue("description"))
 	storeCode := strings.TrimSpace(r.FormValue("store_code"))
 	removePhoto := strings.TrimSpace(r.FormValue("remove_photo")) == "1"
+	tags := parseTags(r.FormValue("tags"))
+	_, tagsSet := r.Form["tags"]
+	featured, sortOrder := parseFeatured(r)
+	_, featuredSet := r.Form["featured"]
+	_, sortOrderSet := r.Form["sort_order"]
+	stock, err := parseStockQty(r)
+	if err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
+	_, stockSet := r.Form["stock_qty"]
+	subscriberOnly, subscriberOnlySet, retailPrice, retailPriceSet, err := parseSubscriberPricing(r)
+	if err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
+	pricePer, pricePerSet, err := parsePricePer(r)
+	if err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
+	maxQty, maxQtySet, err := parseMaxQty(r)
+	if err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
+	costPrice, costPriceSet, err := parseCostPrice(r)
+	if err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
 
 	if name == "" || cat == "" || unit == "" || priceStr == "" || storeCode == "" {
-		jsonErr(w, 400, "name, category, unit, price, store_code are required")
+		writeError(w, ErrBadRequest("name, category, unit, price, store_code are required"))
+		return
+	}
+	// чужой товар и перенос товара в чужую точку — 403
+	allowed, err := h.canEditProduct(scope, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, ErrNotFound("product"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("select product store", zap.Int64("id", id), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	if !allowed || !scope.allows(storeCode) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	if err := h.checkLeafCategory(cat); err != nil {
+		if errors.Is(err, errCategoryNotLeaf) {
+			writeError(w, ErrBadRequest(err.Error()))
+			return
+		}
+		h.logger.Error("check product category", zap.String("category", cat), zap.Error(err))
+		writeError(w, ErrInternal(err))
 		return
 	}
 
@@ -1614,13 +2675,13 @@ func (h *Handler) handleAdminUpdateProduct(w http.ResponseWriter, r *http.Reques
 	var cnt int
 	_ = h.db.QueryRow(`SELECT COUNT(1) FROM stores WHERE code = ?`, storeCode).Scan(&cnt)
 	if cnt == 0 {
-		jsonErr(w, 400, "store not found")
+		writeError(w, ErrBadRequest("store not found"))
 		return
 	}
 
 	price, _ := strconv.ParseInt(priceStr, 10, 64)
 	if price < 0 {
-		jsonErr(w, 400, "price must be >= 0")
+		writeError(w, ErrBadRequest("price must be >= 0"))
 		return
 	}
 	active := int64(1)
@@ -1628,41 +2689,102 @@ func (h *Handler) handleAdminUpdateProduct(w http.ResponseWriter, r *http.Reques
 		active = 0
 	}
 
-	// Load current photo
-	var oldPhoto sql.NullString
-	_ = h.db.QueryRow(`SELECT photo_path FROM products WHERE id = ?`, id).Scan(&oldPhoto)
+	// Load current photo, stock and price
+	var (
+		oldPhoto sql.NullString
+		oldStock sql.NullInt64
+		oldPrice int64
+	)
+	_ = h.db.QueryRow(`SELECT photo_path, stock_qty, price FROM products WHERE id = ?`, id).Scan(&oldPhoto, &oldStock, &oldPrice)
 
 	// If new photo uploaded
 	newPhoto := oldPhoto.String
+	uploaded := ""
 	file, header, err := r.FormFile("photo")
 	if err == nil && header != nil {
 		defer file.Close()
 		if path, e := saveUpload(file, header); e == nil {
-			newPhoto = path
-			if oldPhoto.Valid && oldPhoto.String != "" {
-				_ = os.Remove("." + oldPhoto.String)
-			}
+			newPhoto, uploaded = path, path
 		}
 	}
 	// If remove flag set
 	if removePhoto {
-		if oldPhoto.Valid && oldPhoto.String != "" {
+		newPhoto = ""
+	}
+	// старый файл удаляем только после коммита, новый — если запись не удалась
+	committed := false
+	defer func() {
+		switch {
+		case !committed && uploaded != "":
+			_ = os.Remove("." + uploaded)
+		case committed && oldPhoto.String != "" && oldPhoto.String != newPhoto:
 			_ = os.Remove("." + oldPhoto.String)
 		}
-		newPhoto = ""
+	}()
+
+	// Необязательные поля пишем, только если они пришли в форме: старая
+	// админка без них не должна сбрасывать витрину, остаток, ярлыки,
+	// доступ по подписке, единицу цены, лимит и себестоимость.
+	sets := []string{
+		"name = ?", "category_slug = ?", "unit = ?", "price = ?", "active = ?",
+		"description = ?", "photo_path = ?", "store_code = ?",
+	}
+	args := []any{name, cat, unit, price, active, desc, newPhoto, storeCode}
+	optional := func(set bool, column string, value any) {
+		if set {
+			sets = append(sets, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	optional(featuredSet, "featured", featured)
+	optional(sortOrderSet, "sort_order", sortOrder)
+	optional(stockSet, "stock_qty", stock)
+	optional(subscriberOnlySet, "subscriber_only", subscriberOnly)
+	optional(retailPriceSet, "retail_price", retailPrice)
+	optional(pricePerSet, "price_per", nullString(pricePer))
+	optional(maxQtySet, "max_qty", maxQty)
+	optional(costPriceSet, "cost_price", costPrice)
+	args = append(args, id)
+
+	details := map[string]any{
+		"name": name, "category": cat, "unit": unit, "price": price, "active": active, "store_code": storeCode,
+		"stock_qty": stock, "subscriber_only": subscriberOnly, "retail_price": retailPrice, "price_per": pricePer,
+		"max_qty": maxQty, "cost_price": costPrice,
+	}
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	_, err = h.db.Exec(`
-		UPDATE products SET
-		  name = ?, category_slug = ?, unit = ?, price = ?, active = ?, description = ?, photo_path = ?, store_code = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?`,
-		name, cat, unit, price, active, desc, newPhoto, storeCode, id,
-	)
+	_, err = tx.Exec(`UPDATE products SET `+strings.Join(sets, ", ")+`, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, args...)
+	if err == nil && tagsSet {
+		err = replaceProductTags(tx, id, tags)
+	}
+	if err == nil {
+		err = h.writeAudit(tx, h.auditActor(r), "product.update", fmt.Sprint(id), "", details)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		h.logger.Error("update product", zap.Error(err))
-		jsonErr(w, 500, "db error")
+		writeError(w, ErrInternal(err))
 		return
 	}
+	committed = true
+	h.invalidateProducts()
+
+	// товар снова в наличии — сообщаем тем, кто ждал
+	if oldStock.Valid && oldStock.Int64 == 0 && stock != nil && *stock > 0 {
+		go h.notifyBackInStock(h.ctx, id)
+	}
+	// подешевело — сообщаем тем, кто его заказывал
+	if price < oldPrice {
+		go h.notifyPriceDrops(h.ctx, []priceDrop{{ProductID: id, OldPrice: oldPrice, NewPrice: price}})
+	}
 
 	jsonOK(w, map[string]string{"status": "ok"})
 }
@@ -1673,30 +2795,49 @@ type delReq struct {
 
 func (h *Handler) handleAdminDeleteProduct(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		writeError(w, ErrMethodNotAllowed())
 		return
 	}
-	if !h.isAdminRequest(r) {
-		jsonErr(w, http.StatusForbidden, "forbidden")
+	scope, ok := h.storeAccess(r)
+	if !ok {
+		writeError(w, ErrForbidden())
 		return
 	}
 	var in delReq
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.ID <= 0 {
-		jsonErr(w, 400, "invalid json")
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	allowed, err := h.canEditProduct(scope, in.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, ErrNotFound("product"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("select product store", zap.Int64("id", in.ID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	if !allowed {
+		writeError(w, ErrForbidden())
 		return
 	}
-	// remove photo file if exists
 	var photo sql.NullString
 	_ = h.db.QueryRow(`SELECT photo_path FROM products WHERE id = ?`, in.ID).Scan(&photo)
+	if err := h.deleteProduct(r.Context(), in.ID); err != nil {
+		h.logger.Error("delete product", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	// файл фото удаляем только после того, как товар удалён из базы
 	if photo.Valid && photo.String != "" {
 		_ = os.Remove("." + photo.String)
 	}
-	_, err := h.db.Exec(`DELETE FROM products WHERE id = ?`, in.ID)
-	if err != nil {
-		h.logger.Error("delete product", zap.Error(err))
-		jsonErr(w, 500, "db error")
-		return
+	if err := h.saveProductTags(in.ID, nil); err != nil {
+		h.logger.Warn("delete product tags", zap.Error(err))
 	}
+	h.invalidateProducts()
+	h.auditQuiet(h.auditActor(r), "product.delete", fmt.Sprint(in.ID), nil)
 	jsonOK(w, map[string]string{"status": "ok"})
 }
 
@@ -1704,16 +2845,17 @@ func (h *Handler) handleAdminDeleteProduct(w http.ResponseWriter, r *http.Reques
 
 func (h *Handler) handleAdminAddProduct(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		writeError(w, ErrMethodNotAllowed())
 		return
 	}
-	if !h.isAdminRequest(r) {
-		jsonErr(w, http.StatusForbidden, "forbidden")
+	scope, ok := h.storeAccess(r)
+	if !ok {
+		writeError(w, ErrForbidden())
 		return
 	}
 
 	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB
-		jsonErr(w, http.StatusBadRequest, "invalid multipart form")
+		writeError(w, ErrBadRequest("invalid multipart form"))
 		return
 	}
 
@@ -1725,9 +2867,53 @@ func (h *Handler) handleAdminAddProduct(w http.ResponseWriter, r *http.Request)
 	activeStr := strings.TrimSpace(r.FormValue("active"))
 	desc := strings.TrimSpace(r.FormValue("description"))
 	storeCode := strings.TrimSpace(r.FormValue("store_code"))
+	tags := parseTags(r.FormValue("tags"))
+	featured, sortOrder := parseFeatured(r)
+	stock, err := parseStockQty(r)
+	if err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
+	subscriberOnly, _, retailPrice, _, err := parseSubscriberPricing(r)
+	if err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
+	pricePer, _, err := parsePricePer(r)
+	if err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
+	maxQty, _, err := parseMaxQty(r)
+	if err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
+	costPrice, _, err := parseCostPrice(r)
+	if err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
 
 	if name == "" || cat == "" || unit == "" || priceStr == "" || storeCode == "" {
-		jsonErr(w, http.StatusBadRequest, "name, category, unit, price, store_code are required")
+		writeError(w, ErrBadRequest("name, category, unit, price, store_code are required"))
+		return
+	}
+	if err := validateEmoji(emoji); err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
+	if !scope.allows(storeCode) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	if err := h.checkLeafCategory(cat); err != nil {
+		if errors.Is(err, errCategoryNotLeaf) {
+			writeError(w, ErrBadRequest(err.Error()))
+			return
+		}
+		h.logger.Error("check product category", zap.String("category", cat), zap.Error(err))
+		writeError(w, ErrInternal(err))
 		return
 	}
 
@@ -1735,13 +2921,13 @@ func (h *Handler) handleAdminAddProduct(w http.ResponseWriter, r *http.Request)
 	var cnt int
 	_ = h.db.QueryRow(`SELECT COUNT(1) FROM stores WHERE code = ?`, storeCode).Scan(&cnt)
 	if cnt == 0 {
-		jsonErr(w, 400, "store not found")
+		writeError(w, ErrBadRequest("store not found"))
 		return
 	}
 
 	price, _ := strconv.ParseInt(priceStr, 10, 64)
 	if price < 0 {
-		jsonErr(w, http.StatusBadRequest, "price must be >= 0")
+		writeError(w, ErrBadRequest("price must be >= 0"))
 		return
 	}
 	active := int64(1)
@@ -1759,23 +2945,121 @@ func (h *Handler) handleAdminAddProduct(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	_, err = h.db.Exec(`
-		INSERT INTO products (name, emoji, category_slug, unit, price, active, description, photo_path, store_code)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, name, emoji, cat, unit, price, active, desc, photoPath, storeCode)
+	id, err := database.InsertID(r.Context(), h.db, `
+		INSERT INTO products (name, emoji, category_slug, unit, price, active, description, photo_path, store_code, featured, sort_order, stock_qty,
+		                      subscriber_only, retail_price, price_per, max_qty, cost_price)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, name, emoji, cat, unit, price, active, desc, photoPath, storeCode, featured, sortOrder, stock, subscriberOnly, retailPrice,
+		nullString(pricePer), maxQty, costPrice)
 	if err != nil {
 		h.logger.Error("insert product", zap.Error(err))
-		jsonErr(w, http.StatusInternalServerError, "db error")
+		writeError(w, ErrInternal(err))
 		return
 	}
+	if err := h.saveProductTags(id, tags); err != nil {
+		h.logger.Error("save product tags", zap.Error(err))
+	}
+
+	h.invalidateProducts()
+	h.auditQuiet(h.auditActor(r), "product.add", fmt.Sprint(id), map[string]any{
+		"name": name, "category": cat, "unit": unit, "price": price, "active": active, "store_code": storeCode,
+		"stock_qty": stock, "subscriber_only": subscriberOnly, "retail_price": retailPrice, "price_per": pricePer,
+		"max_qty": maxQty, "cost_price": costPrice,
+	})
 
-	h.notifyAdmin(fmt.Sprintf("➕ Добавлен товар\n\n%s %s\nКатегория: %s\nЦена: %d %s\nТочка: %s",
-		emoji, name, cat, price, unit, storeCode,
+	h.notifyAdmin(fmt.Sprintf("➕ Добавлен товар\n\n%s %s\nКатегория: %s\nЦена: %s / %s\nТочка: %s",
+		emoji, name, cat, formatMoney(price), unit, storeCode,
 	))
 
 	jsonOK(w, map[string]string{"status": "ok"})
 }
 
+// parseFeatured читает из формы флаг featured (1/0) и sort_order.
+func parseFeatured(r *http.Request) (featured, sortOrder int64) {
+	if strings.TrimSpace(r.FormValue("featured")) == "1" {
+		featured = 1
+	}
+	sortOrder, _ = strconv.ParseInt(strings.TrimSpace(r.FormValue("sort_order")), 10, 64)
+	return featured, sortOrder
+}
+
+// ========================= PRODUCT TAGS =========================
+
+// parseTags разбирает "promo, new,hit" → [promo new hit] (нижний регистр, без дублей).
+func parseTags(raw string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, t := range strings.Split(raw, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	return out
+}
+
+// saveProductTags полностью заменяет ярлыки товара.
+func (h *Handler) saveProductTags(productID int64, tags []string) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`DELETE FROM product_tags WHERE product_id = ?`, productID); err != nil {
+		return err
+	}
+	for _, t := range tags {
+		if _, err := tx.Exec(`INSERT INTO product_tags (product_id, tag) VALUES (?, ?)`, productID, t); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// loadProductTags возвращает ярлыки всех товаров: product_id → tags.
+func (h *Handler) loadProductTags() map[int64][]string {
+	out := map[int64][]string{}
+	rows, err := h.db.Query(`SELECT product_id, tag FROM product_tags ORDER BY product_id, tag`)
+	if err != nil {
+		h.logger.Warn("select product tags", zap.Error(err))
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			h.logger.Warn("scan product tag", zap.Error(err))
+			continue
+		}
+		out[id] = append(out[id], tag)
+	}
+	return out
+}
+
+// productTags возвращает ярлыки одного товара.
+func (h *Handler) productTags(productID int64) []string {
+	rows, err := h.db.Query(`SELECT tag FROM product_tags WHERE product_id = ? ORDER BY tag`, productID)
+	if err != nil {
+		h.logger.Warn("select product tags", zap.Int64("product_id", productID), zap.Error(err))
+		return nil
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			h.logger.Warn("scan product tag", zap.Error(err))
+			continue
+		}
+		out = append(out, tag)
+	}
+	return out
+}
+
 // ========================= ORDERS =========================
 
 type orderItemIn struct {
@@ -1784,6 +3068,140 @@ type orderItemIn struct {
 	Qty       float64 `json:"qty"`
 	Unit      string  `json:"unit"`
 	Price     int64   `json:"price"`
+
+	// пожелание к позиции («только спелые») и можно ли заменить похожим товаром
+	Note              string `json:"note"`
+	AllowSubstitution bool   `json:"allow_substitution"`
+
+	// эмодзи и фото товара — снимок из каталога на момент заказа
+	// (snapshotOrderItems), от клиента не принимаются
+	Emoji string `json:"-"`
+	Photo string `json:"-"`
+
+	// за что цена товара (catalogPricePer) — тоже из каталога, не от клиента
+	PricePer string `json:"-"`
+}
+
+// максимальное количество в одной позиции (кг, шт): больше с мини-аппа
+// не заказывают, а мусор от клиента не должен раздувать суммы
+const maxItemQty = 1000
+
+// checkItemQty проверяет количество и цену позиции. NaN и ±Inf проходят
+// сравнение «<= 0», поэтому ловим их явно.
+func checkItemQty(it orderItemIn) error {
+	switch {
+	case math.IsNaN(it.Qty) || math.IsInf(it.Qty, 0):
+		return errors.New("bad item qty/price: qty is not a finite number")
+	case it.Qty <= 0 || it.Qty > maxItemQty:
+		return fmt.Errorf("bad item qty/price: qty must be > 0 and <= %d", maxItemQty)
+	case it.Price < 0:
+		return errors.New("bad item qty/price: price must be >= 0")
+	}
+	return nil
+}
+
+// максимальная длина пожелания к позиции, символов
+const maxItemNoteLen = 200
+
+// checkItemNotes обрезает пробелы в пожеланиях и проверяет их длину.
+func checkItemNotes(items []orderItemIn) error {
+	for i := range items {
+		items[i].Note = strings.TrimSpace(items[i].Note)
+		if len([]rune(items[i].Note)) > maxItemNoteLen {
+			return fmt.Errorf("item note is too long (max %d characters)", maxItemNoteLen)
+		}
+	}
+	return nil
+}
+
+// writeItemPrefs дописывает под строкой позиции пожелание и разрешение на замену.
+func writeItemPrefs(b *strings.Builder, it orderItemIn) {
+	if it.Note != "" {
+		fmt.Fprintf(b, "   📝 %s\n", it.Note)
+	}
+	if it.AllowSubstitution {
+		fmt.Fprintf(b, "   🔁 можно заменить похожим\n")
+	}
+}
+
+// плоская ставка доставки, ₸ (как в /api/delivery/price);
+// действует, если у точки нет ступеней delivery_tiers
+const deliveryFlatPrice = 1000
+
+// orderQuote — результат серверного расчёта заказа.
+type orderQuote struct {
+	Items         []orderItemIn // позиции, включая строку «Доставка»
+	GoodsTotal    int64
+	DeliveryPrice int64
+	Total         int64
+}
+
+// quoteOrder проверяет позиции и считает сумму товаров и доставки.
+// Используется и при подтверждении заказа, и в /api/orders/quote;
+// deliveryPrice считается заранее в deliveryQuote.
+func quoteOrder(items []orderItemIn, d deliveryIn, deliveryPrice int64) (orderQuote, error) {
+	var q orderQuote
+	for _, it := range items {
+		if err := checkItemQty(it); err != nil {
+			return orderQuote{}, err
+		}
+		q.GoodsTotal += lineAmount(it)
+	}
+	q.Items = append(q.Items, items...)
+	if err := checkItemNotes(q.Items); err != nil {
+		return orderQuote{}, err
+	}
+
+	if strings.EqualFold(d.Type, "delivery") {
+		q.DeliveryPrice = deliveryPrice
+		// добавим как строку заказа «Доставка»
+		q.Items = append(q.Items, orderItemIn{
+			ProductID: 0, // в order_items пишется как NULL
+			Name:      domain.DeliveryItemName,
+			Qty:       1,
+			Unit:      "услуга",
+			Price:     q.DeliveryPrice,
+		})
+	}
+
+	q.Total = q.GoodsTotal + q.DeliveryPrice
+	return q, nil
+}
+
+// newOrder собирает domain.Order из позиций запроса мини-аппа для OrderRepository.Create.
+// tgStr уже проверен хендлером как число.
+func newOrder(tgStr, storeCode, paymentMethod string, total int64, items []orderItemIn) *domain.Order {
+	userID, _ := strconv.ParseInt(tgStr, 10, 64)
+	o := &domain.Order{
+		UserID:        userID,
+		StoreCode:     storeCode,
+		TotalAmount:   total,
+		PaymentMethod: paymentMethod,
+		Items:         make([]domain.OrderItem, 0, len(items)),
+	}
+	for _, it := range items {
+		o.Items = append(o.Items, domain.OrderItem{
+			ProductID:         it.ProductID,
+			Name:              it.Name,
+			Unit:              it.Unit,
+			Qty:               it.Qty,
+			Price:             it.Price,
+			Amount:            lineAmount(it),
+			Note:              it.Note,
+			AllowSubstitution: it.AllowSubstitution,
+			Emoji:             it.Emoji,
+			Photo:             it.Photo,
+			PricePer:          it.PricePer,
+		})
+	}
+	return o
+}
+
+// lineAmount — сумма строки с округлением до тенге
+// (раньше дробная часть просто отбрасывалась). Количество сначала переводится
+// в единицы цены (pricingQty): 0.3 кг по 450 ₸/100 г — это 3 × 450.
+func lineAmount(it orderItemIn) int64 {
+	return int64(math.Round(pricingQty(it) * float64(it.Price)))
 }
 
 type createOrderIn struct {
@@ -1793,19 +3211,9 @@ type createOrderIn struct {
 
 // ========================= HELPERS =========================
 
+// notifyAdmin ставит текст в очередь уведомлений админу (см. admin-notifier.go).
 func (h *Handler) notifyAdmin(text string) {
-	if h.bot == nil || h.cfg == nil || h.cfg.AdminID == 0 {
-		return
-	}
-	go func() {
-		_, err := h.bot.SendMessage(h.ctx, &bot.SendMessageParams{
-			ChatID: h.cfg.AdminID,
-			Text:   text,
-		})
-		if err != nil {
-			log.Println("notifyAdmin error:", err)
-		}
-	}()
+	h.enqueueAdmin(adminNotice{text: text})
 }
 
 func saveUpload(file multipart.File, header *multipart.FileHeader) (string, error) {
@@ -1836,14 +3244,6 @@ func jsonOK(w http.ResponseWriter, v any) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
-func jsonErr(w http.ResponseWriter, code int, msg string) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(code)
-	_ = json.NewEncoder(w).Encode(map[string]any{
-		"error": msg,
-	})
-}
-
 func firstNonEmpty(ss ...string) string {
 	for _, s := range ss {
 		if strings.TrimSpace(s) != "" {
@@ -1853,13 +3253,28 @@ func firstNonEmpty(ss ...string) string {
 	return ""
 }
 
-func nullIfEmpty(s string) any {
+// nullString / nullInt / nullFloat — пустое или нулевое значение пишем в БД как NULL.
+func nullString(s string) any {
 	if strings.TrimSpace(s) == "" {
 		return nil
 	}
 	return s
 }
 
+func nullInt(v int64) any {
+	if v == 0 {
+		return nil
+	}
+	return v
+}
+
+func nullFloat(v float64) any {
+	if v == 0 {
+		return nil
+	}
+	return v
+}
+
 func humanPaymentMethod(m string) string {
 	switch m {
 	case paymentKaspiTransfer:
@@ -0,0 +1,1214 @@
+package handler
+
+import (
+	"agro/config"
+	"agro/internal/repository"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+func sign(key, method, path, body string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(method + path + body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// initData собирает Telegram.WebApp.initData, подписанный токеном бота.
+func initData(token string, authDate time.Time) string {
+	v := url.Values{}
+	v.Set("auth_date", strconv.FormatInt(authDate.Unix(), 10))
+	v.Set("query_id", "AAHdF6IQAAAAAN0XohDhrOrc")
+	v.Set("user", `{"id":555,"first_name":"Айгерим"}`)
+	pairs := []string{}
+	for k := range v {
+		pairs = append(pairs, k+"="+v.Get(k))
+	}
+	sort.Strings(pairs)
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(token))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	v.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+	return v.Encode()
+}
+
+func TestSignatureMiddleware(t *testing.T) {
+	const token = "test-token"
+	const body = `{"telegram_id":"1"}`
+	now := time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		require  bool
+		method   string
+		path     string
+		sig      string
+		initData string
+		wantCode int
+	}{
+		{"optional: no header passes", false, http.MethodPost, "/api/orders/confirm", "", "", http.StatusOK},
+		{"optional: valid header passes", false, http.MethodPost, "/api/orders/confirm", sign(token, "POST", "/api/orders/confirm", body), "", http.StatusOK},
+		{"optional: invalid header rejected", false, http.MethodPost, "/api/orders/confirm", "deadbeef", "", http.StatusUnauthorized},
+		{"required: no header rejected", true, http.MethodPost, "/api/subscribe/request-invoice", "", "", http.StatusUnauthorized},
+		{"required: valid header passes", true, http.MethodPost, "/api/subscribe/request-invoice", sign(token, "POST", "/api/subscribe/request-invoice", body), "", http.StatusOK},
+		{"required: wrong path in signature rejected", true, http.MethodPost, "/api/orders/create", sign(token, "POST", "/api/orders/confirm", body), "", http.StatusUnauthorized},
+		{"required: unsigned path passes", true, http.MethodPost, "/api/user/set-store", "", "", http.StatusOK},
+		{"required: GET passes", true, http.MethodGet, "/api/orders/confirm", "", "", http.StatusOK},
+		// мини-апп: вместо подписи — initData от Telegram
+		{"required: mini-app init data passes", true, http.MethodPost, "/api/orders/confirm", "", initData(token, now.Add(-time.Hour)), http.StatusOK},
+		{"required: init data of another bot rejected", true, http.MethodPost, "/api/orders/confirm", "", initData("other-token", now.Add(-time.Hour)), http.StatusUnauthorized},
+		{"required: stale init data rejected", true, http.MethodPost, "/api/orders/quote", "", initData(token, now.Add(-48*time.Hour)), http.StatusUnauthorized},
+		{"required: tampered init data rejected", true, http.MethodPost, "/api/orders/confirm", "", strings.Replace(initData(token, now), "555", "556", 1), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{
+				logger: zap.NewNop(),
+				cfg:    &config.Config{Token: token, RequireRequestSignature: tt.require},
+				clock:  &fakeClock{t: now},
+			}
+			var gotBody string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				buf := new(strings.Builder)
+				_, _ = io.Copy(buf, r.Body)
+				gotBody = buf.String()
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(body))
+			if tt.sig != "" {
+				req.Header.Set("X-Request-Signature", tt.sig)
+			}
+			if tt.initData != "" {
+				req.Header.Set("X-Telegram-Init-Data", tt.initData)
+			}
+			rec := httptest.NewRecorder()
+			h.signatureMiddleware(next).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusOK && gotBody != body {
+				t.Fatalf("body not restored: %q", gotBody)
+			}
+		})
+	}
+}
+
+func TestLineAmountPricePer(t *testing.T) {
+	tests := []struct {
+		it      orderItemIn
+		amount  int64
+		receipt string
+	}{
+		{orderItemIn{Qty: 0.3, Unit: "кг", Price: 4500}, 1350, "0.30 кг × 4\u00a0500\u00a0₸"},
+		{orderItemIn{Qty: 0.3, Unit: "кг", Price: 4500, PricePer: pricePerKg}, 1350, "0.30 кг × 4\u00a0500\u00a0₸/кг"},
+		{orderItemIn{Qty: 300, Unit: "г", Price: 4500, PricePer: pricePerKg}, 1350, "300.00 г × 4\u00a0500\u00a0₸/кг"},
+		{orderItemIn{Qty: 0.3, Unit: "кг", Price: 450, PricePer: pricePer100g}, 1350, "3 × 450\u00a0₸/100 г"},
+		{orderItemIn{Qty: 250, Unit: "г", Price: 450, PricePer: pricePer100g}, 1125, "2.5 × 450\u00a0₸/100 г"},
+		{orderItemIn{Qty: 3, Unit: "100 г", Price: 450, PricePer: pricePer100g}, 1350, "3 × 450\u00a0₸/100 г"},
+		{orderItemIn{Qty: 2, Unit: "пучок", Price: 199, PricePer: pricePerBundle}, 398, "2 × 199\u00a0₸/пучок"},
+	}
+	for _, tt := range tests {
+		if got := lineAmount(tt.it); got != tt.amount {
+			t.Errorf("lineAmount(%+v) = %d, want %d", tt.it, got, tt.amount)
+		}
+		if got := qtyTimesPrice(tt.it); got != tt.receipt {
+			t.Errorf("qtyTimesPrice(%+v) = %q, want %q", tt.it, got, tt.receipt)
+		}
+	}
+}
+
+func TestQuoteOrder(t *testing.T) {
+	items := []orderItemIn{
+		{ProductID: 1, Name: "Картофель", Qty: 1.5, Unit: "кг", Price: 333},
+		{ProductID: 2, Name: "Лук", Qty: 2, Unit: "кг", Price: 150},
+	}
+
+	q, err := quoteOrder(items, deliveryIn{Type: "pickup"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1.5 × 333 = 499.5 → 500
+	if q.GoodsTotal != 800 || q.DeliveryPrice != 0 || q.Total != 800 {
+		t.Fatalf("pickup quote = %+v", q)
+	}
+
+	q, err = quoteOrder(items, deliveryIn{Type: "delivery"}, deliveryFlatPrice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.DeliveryPrice != deliveryFlatPrice || q.Total != 800+deliveryFlatPrice || len(q.Items) != 3 {
+		t.Fatalf("delivery quote = %+v", q)
+	}
+	if len(items) != 2 {
+		t.Fatalf("input items mutated")
+	}
+
+	if _, err := quoteOrder([]orderItemIn{{Qty: 0, Price: 10}}, deliveryIn{}, 0); err == nil {
+		t.Fatal("expected error for zero qty")
+	}
+	// NaN и Inf проходят сравнение «<= 0» — проверяются отдельно
+	for _, qty := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), maxItemQty + 1} {
+		if _, err := quoteOrder([]orderItemIn{{Qty: qty, Price: 10}}, deliveryIn{}, 0); err == nil {
+			t.Errorf("qty %v accepted", qty)
+		}
+	}
+}
+
+func TestHandleConfirmOrderRejectsJunkQty(t *testing.T) {
+	h, rec := newTestHandler(t)
+	if _, err := h.db.Exec(`INSERT INTO stores (code, name, address) VALUES ('samal3', 'Самал-3', 'ул. Тестовая, 1')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.db.Exec(`INSERT INTO users (id, user_id, nickname, selected_store) VALUES ('u1', 555, 'tester', 'samal3')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.db.Exec(`INSERT INTO products (id, name, category_slug, unit, price) VALUES (1, 'Картофель', 'vegetables', 'кг', 250)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, qty := range []string{"1e999", "-1e999", "NaN", `"NaN"`, "0", "-2", "1000.5"} {
+		body := `{"telegram_id": 555, "payment_method": "cash",
+			"items": [{"product_id": 1, "name": "Картофель", "qty": ` + qty + `, "unit": "кг", "price": 250}],
+			"delivery": {"type": "pickup"}}`
+		for path, handle := range map[string]http.HandlerFunc{
+			"/api/orders/confirm": h.handleConfirmOrder,
+			"/api/orders/quote":   h.handleQuoteOrder,
+		} {
+			w := httptest.NewRecorder()
+			handle(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s qty=%s: status = %d, body = %s", path, qty, w.Code, w.Body.String())
+			}
+		}
+	}
+	// та же корзина с нормальным количеством считается
+	w := httptest.NewRecorder()
+	h.handleQuoteOrder(w, httptest.NewRequest(http.MethodPost, "/api/orders/quote", strings.NewReader(`{"telegram_id": 555,
+		"items": [{"product_id": 1, "name": "Картофель", "qty": 2, "unit": "кг", "price": 250}], "delivery": {"type": "pickup"}}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("valid quote = %d %s", w.Code, w.Body.String())
+	}
+
+	var orders int
+	h.db.QueryRow(`SELECT COUNT(1) FROM orders`).Scan(&orders)
+	if orders != 0 || len(rec.Messages) != 0 {
+		t.Fatalf("orders = %d, messages = %d", orders, len(rec.Messages))
+	}
+}
+
+func TestHandleConfirmOrder(t *testing.T) {
+	h, rec := newTestHandler(t)
+	const userID = int64(555)
+
+	if _, err := h.db.Exec(`INSERT INTO stores (code, name, address) VALUES ('samal3', 'Самал-3', 'ул. Тестовая, 1')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.db.Exec(`INSERT INTO users (id, user_id, nickname, selected_store) VALUES ('u1', ?, 'tester', 'samal3')`, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.db.Exec(`INSERT INTO products (id, name, category_slug, unit, price) VALUES (1, 'Картофель', 'vegetables', 'кг', 250)`); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"telegram_id": 555, "payment_method": "kaspi_transfer",
+		"items": [{"product_id": 1, "name": "Картофель", "qty": 2, "unit": "кг", "price": 250}],
+		"delivery": {"type": "delivery", "address": "мкр. Самал-3, 5", "phone": "+77010000000"}}`
+	req := httptest.NewRequest(http.MethodPost, "/api/orders/confirm", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.handleConfirmOrder(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	userMsgs := rec.MessagesTo(userID)
+	if len(userMsgs) != 1 {
+		t.Fatalf("user messages = %d, want 1", len(userMsgs))
+	}
+	for _, want := range []string{"Заказ №1", "Самал-3", "Kaspi Gold", "Товары: " + formatMoney(500),
+		"Доставка: " + formatMoney(1000), "Итого к оплате: " + formatMoney(1500)} {
+		if !strings.Contains(userMsgs[0], want) {
+			t.Errorf("receipt missing %q:\n%s", want, userMsgs[0])
+		}
+	}
+
+	adminMsgs := waitMessages(t, rec, testAdminID, 1)
+	if len(adminMsgs) != 1 {
+		t.Fatalf("admin messages = %d, want 1", len(adminMsgs))
+	}
+	for _, want := range []string{"Новый заказ", "555", "Доставка на дом", formatMoney(1500)} {
+		if !strings.Contains(adminMsgs[0], want) {
+			t.Errorf("admin notification missing %q:\n%s", want, adminMsgs[0])
+		}
+	}
+
+	var total, goods, delivery int64
+	if err := h.db.QueryRow(`SELECT total_amount, goods_total, delivery_price FROM orders WHERE id = 1`).Scan(&total, &goods, &delivery); err != nil ||
+		total != 1500 || goods != 500 || delivery != 1000 {
+		t.Fatalf("order totals = %d (%d + %d), err = %v", total, goods, delivery, err)
+	}
+	var deliveryProduct sql.NullInt64
+	if err := h.db.QueryRow(`SELECT product_id FROM order_items WHERE order_id = 1 AND name = 'Доставка'`).Scan(&deliveryProduct); err != nil || deliveryProduct.Valid {
+		t.Fatalf("delivery line product_id = %v, err = %v, want NULL", deliveryProduct, err)
+	}
+}
+
+func TestPaymentCallbackHandler(t *testing.T) {
+	t.Run("sub_ok activates subscription", func(t *testing.T) {
+		h, rec := newTestHandler(t)
+		if _, err := h.db.Exec(`INSERT INTO users (id, user_id, nickname, sub_status) VALUES ('u1', 555, 'tester', 'pending')`); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h.db.Exec(`INSERT INTO subscriptions (user_id, phone, status) VALUES (555, '+7701', 'pending')`); err != nil {
+			t.Fatal(err)
+		}
+
+		h.PaymentCallbackHandler(context.Background(), nil, &models.Update{
+			CallbackQuery: &models.CallbackQuery{ID: "cb1", Data: "sub_ok:1:555"},
+		})
+
+		if len(rec.Callbacks) != 1 || rec.Callbacks[0].Text != "Подписка активирована ✅" {
+			t.Fatalf("callbacks = %+v", rec.Callbacks)
+		}
+		msgs := rec.MessagesTo(555)
+		if len(msgs) != 1 || !strings.Contains(msgs[0], "подписка на «АГРО Клуб Оптовых Цен» активирована") {
+			t.Fatalf("user messages = %q", msgs)
+		}
+
+		var subStatus, userStatus string
+		_ = h.db.QueryRow(`SELECT status FROM subscriptions WHERE id = 1`).Scan(&subStatus)
+		_ = h.db.QueryRow(`SELECT sub_status FROM users WHERE user_id = 555`).Scan(&userStatus)
+		if subStatus != "active" || userStatus != "active" {
+			t.Fatalf("statuses = %q / %q, want active", subStatus, userStatus)
+		}
+	})
+
+	t.Run("sub_ok twice does not reset validity", func(t *testing.T) {
+		h, rec := newTestHandler(t)
+		clk := &fakeClock{t: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)}
+		h.SetClock(clk)
+		if _, err := h.db.Exec(`INSERT INTO users (id, user_id, nickname) VALUES ('u1', 555, 'tester')`); err != nil {
+			t.Fatal(err)
+		}
+		// действующая подписка до 20 марта и новая оплата на продление
+		if _, err := h.db.Exec(`INSERT INTO subscriptions (user_id, status, valid_until) VALUES (555, 'active', ?)`,
+			time.Date(2025, 3, 20, 12, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h.db.Exec(`INSERT INTO subscriptions (user_id, status) VALUES (555, 'pending')`); err != nil {
+			t.Fatal(err)
+		}
+
+		click := func() {
+			h.PaymentCallbackHandler(context.Background(), nil, &models.Update{
+				CallbackQuery: &models.CallbackQuery{ID: "cb", Data: "sub_ok:2:555"},
+			})
+		}
+		click()
+		clk.t = clk.t.Add(time.Hour)
+		click()
+
+		var until time.Time
+		_ = h.db.QueryRow(`SELECT valid_until FROM subscriptions WHERE id = 2`).Scan(&until)
+		if want := time.Date(2025, 4, 20, 12, 0, 0, 0, time.UTC); !until.Equal(want) {
+			t.Fatalf("valid_until = %v, want %v (extended from current subscription)", until, want)
+		}
+		if len(rec.Callbacks) != 2 || rec.Callbacks[1].Text != "Подписка уже активна до 20.04.2025" {
+			t.Fatalf("callbacks = %+v", rec.Callbacks)
+		}
+		if msgs := rec.MessagesTo(555); len(msgs) != 1 {
+			t.Fatalf("user messages = %q, want exactly one", msgs)
+		}
+	})
+
+	t.Run("pay_reject notifies user", func(t *testing.T) {
+		h, rec := newTestHandler(t)
+		if _, err := h.db.Exec(`INSERT INTO orders (id, user_id, status) VALUES (7, 555, 'checking')`); err != nil {
+			t.Fatal(err)
+		}
+
+		h.PaymentCallbackHandler(context.Background(), nil, &models.Update{
+			CallbackQuery: &models.CallbackQuery{ID: "cb2", Data: "pay_reject:7:555"},
+		})
+
+		if len(rec.Callbacks) != 1 || rec.Callbacks[0].Text != "Оплата заказа отклонена ❌" {
+			t.Fatalf("callbacks = %+v", rec.Callbacks)
+		}
+		msgs := rec.MessagesTo(555)
+		if len(msgs) != 1 || !strings.Contains(msgs[0], "заказу №7 не прошла проверку") {
+			t.Fatalf("user messages = %q", msgs)
+		}
+	})
+}
+
+func TestSubCommandHandler(t *testing.T) {
+	h, rec := newTestHandler(t)
+	if _, err := h.db.Exec(`INSERT INTO users (id, user_id, nickname, sub_status) VALUES ('u1', 555, 'tester', 'active')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.db.Exec(`INSERT INTO subscriptions (user_id, status) VALUES (555, 'active')`); err != nil {
+		t.Fatal(err)
+	}
+	send := func(from int64, text string) {
+		h.SubCommandHandler(context.Background(), nil, &models.Update{Message: &models.Message{
+			Text: text, From: &models.User{ID: from}, Chat: models.Chat{ID: from},
+		}})
+	}
+
+	send(555, "/sub 555 active 30")
+	if msgs := rec.MessagesTo(555); len(msgs) != 1 || !strings.Contains(msgs[0], "только администратору") {
+		t.Fatalf("non-admin reply = %q", msgs)
+	}
+
+	send(testAdminID, "/sub 555 paused")
+	if msgs := rec.MessagesTo(testAdminID); len(msgs) != 1 || !strings.Contains(msgs[0], "active или expired") {
+		t.Fatalf("validation reply = %q", msgs)
+	}
+
+	send(testAdminID, "/sub 555 expired")
+	var subStatus, userStatus string
+	_ = h.db.QueryRow(`SELECT status FROM subscriptions WHERE user_id = 555 ORDER BY id DESC LIMIT 1`).Scan(&subStatus)
+	_ = h.db.QueryRow(`SELECT sub_status FROM users WHERE user_id = 555`).Scan(&userStatus)
+	if subStatus != "expired" || userStatus != "expired" {
+		t.Fatalf("statuses = %q / %q, want expired", subStatus, userStatus)
+	}
+	if msgs := rec.MessagesTo(555); len(msgs) != 2 || !strings.Contains(msgs[1], "отключена администратором") {
+		t.Fatalf("user notice = %q", msgs)
+	}
+
+	send(testAdminID, "/sub 999 active")
+	if msgs := rec.MessagesTo(testAdminID); !strings.Contains(msgs[len(msgs)-1], "не найден") {
+		t.Fatalf("unknown user reply = %q", msgs)
+	}
+
+	// второй суперадмин из ADMIN_IDS — тоже может, в audit_log — он сам
+	h.cfg.AdminIDs = []int64{888}
+	send(888, "/sub 555 active 10")
+	_ = h.db.QueryRow(`SELECT sub_status FROM users WHERE user_id = 555`).Scan(&userStatus)
+	var actor int64
+	_ = h.db.QueryRow(`SELECT admin_id FROM audit_log WHERE action = 'subscription.set' ORDER BY id DESC LIMIT 1`).Scan(&actor)
+	if userStatus != "active" || actor != 888 {
+		t.Fatalf("second admin: status = %q, audit actor = %d", userStatus, actor)
+	}
+}
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.t }
+
+func TestSubscriptionExpiryAndReminders(t *testing.T) {
+	h, rec := newTestHandler(t)
+	clock := &fakeClock{t: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)}
+	h.SetClock(clock)
+	ctx := context.Background()
+
+	// активируем подписку через кнопку админа — valid_until = now + 1 месяц
+	if _, err := h.db.Exec(`INSERT INTO users (id, user_id, nickname) VALUES ('u1', 555, 'tester')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.db.Exec(`INSERT INTO subscriptions (user_id, status) VALUES (555, 'pending')`); err != nil {
+		t.Fatal(err)
+	}
+	h.PaymentCallbackHandler(ctx, nil, &models.Update{
+		CallbackQuery: &models.CallbackQuery{ID: "cb", Data: "sub_ok:1:555"},
+	})
+	if msgs := rec.MessagesTo(555); len(msgs) != 1 || !strings.Contains(msgs[0], "01.04.2025") {
+		t.Fatalf("activation message = %q", msgs)
+	}
+
+	statuses := func() (string, string) {
+		var sub, user string
+		_ = h.db.QueryRow(`SELECT status FROM subscriptions WHERE id = 1`).Scan(&sub)
+		_ = h.db.QueryRow(`SELECT sub_status FROM users WHERE user_id = 555`).Scan(&user)
+		return sub, user
+	}
+	run := func() {
+		h.checkAndExpireSubscriptions(ctx)
+		h.remindExpiringSubscriptions(ctx)
+	}
+
+	// за 10 дней до конца — ни напоминания, ни истечения
+	clock.t = time.Date(2025, 3, 22, 12, 0, 0, 0, time.UTC)
+	run()
+	if n := len(rec.MessagesTo(555)); n != 1 {
+		t.Fatalf("unexpected reminder, messages = %d", n)
+	}
+
+	// за 2 дня — одно напоминание, повторный прогон не дублирует
+	clock.t = time.Date(2025, 3, 30, 12, 0, 0, 0, time.UTC)
+	run()
+	run()
+	msgs := rec.MessagesTo(555)
+	if len(msgs) != 2 || !strings.Contains(msgs[1], "заканчивается 01.04.2025") {
+		t.Fatalf("reminder messages = %q", msgs)
+	}
+	if sub, user := statuses(); sub != "active" || user != "active" {
+		t.Fatalf("statuses before expiry = %q / %q", sub, user)
+	}
+
+	// после valid_until — статусы переключаются в expired
+	clock.t = time.Date(2025, 4, 1, 12, 0, 1, 0, time.UTC)
+	run()
+	if sub, user := statuses(); sub != "expired" || user != "expired" {
+		t.Fatalf("statuses after expiry = %q / %q", sub, user)
+	}
+	if n := len(rec.MessagesTo(555)); n != 2 {
+		t.Fatalf("messages after expiry = %d, want 2", n)
+	}
+}
+
+func TestSubscriptionGracePeriod(t *testing.T) {
+	h, rec := newTestHandler(t)
+	h.cfg.SubGraceDays = 3
+	clock := &fakeClock{t: time.Date(2025, 4, 2, 12, 0, 0, 0, time.UTC)}
+	h.SetClock(clock)
+	ctx := context.Background()
+
+	until := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	if _, err := h.db.Exec(`INSERT INTO users (id, user_id, nickname, sub_status, sub_until) VALUES ('u1', 555, 'tester', 'active', ?)`, until); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.db.Exec(`INSERT INTO subscriptions (user_id, status, valid_until) VALUES (555, 'active', ?)`, until); err != nil {
+		t.Fatal(err)
+	}
+	statuses := func() (string, string) {
+		var sub, user string
+		_ = h.db.QueryRow(`SELECT status FROM subscriptions WHERE id = 1`).Scan(&sub)
+		_ = h.db.QueryRow(`SELECT sub_status FROM users WHERE user_id = 555`).Scan(&user)
+		return sub, user
+	}
+	subStatus := func() (out struct {
+		Active     bool   `json:"active"`
+		Grace      bool   `json:"grace"`
+		GraceUntil string `json:"grace_until"`
+	}) {
+		w := httptest.NewRecorder()
+		h.handleGetSubStatus(w, httptest.NewRequest(http.MethodGet, "/api/user/subscription-status?telegram_id=555", nil))
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	run := func() {
+		h.checkAndExpireSubscriptions(ctx)
+		h.remindGraceSubscriptions(ctx)
+	}
+
+	// на следующий день после окончания — льготный период, одно напоминание в день
+	run()
+	run()
+	if sub, user := statuses(); sub != "grace" || user != "grace" {
+		t.Fatalf("statuses in grace = %q / %q", sub, user)
+	}
+	if st := subStatus(); !st.Active || !st.Grace || st.GraceUntil != "2025-04-04" {
+		t.Fatalf("status in grace = %+v", st)
+	}
+	msgs := rec.MessagesTo(555)
+	if len(msgs) != 1 || !strings.Contains(msgs[0], "сохранится до 04.04.2025") {
+		t.Fatalf("grace reminders = %q", msgs)
+	}
+
+	clock.t = clock.t.AddDate(0, 0, 1)
+	run()
+	if n := len(rec.MessagesTo(555)); n != 2 {
+		t.Fatalf("grace reminders on day 2 = %d, want 2", n)
+	}
+
+	// после льготного периода — обычное истечение
+	clock.t = time.Date(2025, 4, 4, 12, 0, 1, 0, time.UTC)
+	run()
+	if sub, user := statuses(); sub != "expired" || user != "expired" {
+		t.Fatalf("statuses after grace = %q / %q", sub, user)
+	}
+	if st := subStatus(); st.Active || st.Grace {
+		t.Fatalf("status after grace = %+v", st)
+	}
+	if n := len(rec.MessagesTo(555)); n != 2 {
+		t.Fatalf("messages after grace = %d, want 2", n)
+	}
+}
+
+func TestHandleAdminSetSubscription(t *testing.T) {
+	h, rec := newTestHandler(t)
+	h.SetClock(&fakeClock{t: time.Date(2025, 1, 10, 9, 0, 0, 0, time.Local)})
+	if _, err := h.db.Exec(`INSERT INTO users (id, user_id, nickname) VALUES ('u1', 555, 'tester')`); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(tgID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/subscriptions/set", strings.NewReader(body))
+		req.Header.Set("X-Telegram-Id", tgID)
+		w := httptest.NewRecorder()
+		h.handleAdminSetSubscription(w, req)
+		return w
+	}
+
+	if w := post("555", `{"user_id":555,"status":"active","valid_until":"2025-12-31"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin status = %d", w.Code)
+	}
+	if w := post("1", `{"user_id":555,"status":"active","valid_until":"2024-12-31"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("past date status = %d", w.Code)
+	}
+
+	w := post("1", `{"user_id":555,"status":"active","valid_until":"2025-12-31","reason":"подарок"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("activate status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var userStatus string
+	var subs int
+	_ = h.db.QueryRow(`SELECT sub_status FROM users WHERE user_id = 555`).Scan(&userStatus)
+	_ = h.db.QueryRow(`SELECT COUNT(1) FROM subscriptions WHERE user_id = 555 AND status = 'active'`).Scan(&subs)
+	if userStatus != "active" || subs != 1 {
+		t.Fatalf("after activate: sub_status = %q, active rows = %d", userStatus, subs)
+	}
+	if msgs := rec.MessagesTo(555); len(msgs) != 1 || !strings.Contains(msgs[0], "31.12.2025") {
+		t.Fatalf("user notice = %q", msgs)
+	}
+
+	if w := post("1", `{"user_id":555,"status":"inactive","reason":"возврат"}`); w.Code != http.StatusOK {
+		t.Fatalf("deactivate status = %d", w.Code)
+	}
+	_ = h.db.QueryRow(`SELECT sub_status FROM users WHERE user_id = 555`).Scan(&userStatus)
+	if userStatus != "inactive" {
+		t.Fatalf("after deactivate: sub_status = %q", userStatus)
+	}
+
+	var audits int
+	var reason string
+	_ = h.db.QueryRow(`SELECT COUNT(1) FROM audit_log WHERE action = 'subscription.set' AND admin_id = 1`).Scan(&audits)
+	_ = h.db.QueryRow(`SELECT reason FROM audit_log ORDER BY id DESC LIMIT 1`).Scan(&reason)
+	if audits != 2 || reason != "возврат" {
+		t.Fatalf("audit rows = %d, last reason = %q", audits, reason)
+	}
+}
+
+func TestLockUser(t *testing.T) {
+	env := newTestEnv(t)
+	h := env.h
+
+	unlock, err := h.lockUser(context.Background(), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := h.lockUser(ctx, 42); !errors.Is(err, errUserBusy) {
+		t.Fatalf("second lock err = %v, want errUserBusy", err)
+	}
+	if u, err := h.lockUser(context.Background(), 43); err != nil {
+		t.Fatalf("other user lock: %v", err)
+	} else {
+		u()
+	}
+	unlock()
+	if u, err := h.lockUser(context.Background(), 42); err != nil {
+		t.Fatalf("lock after unlock: %v", err)
+	} else {
+		u()
+	}
+
+	// Redis недоступен — работает локальный мьютекс
+	env.redis.Close()
+	unlock, err = h.lockUser(context.Background(), 42)
+	if err != nil {
+		t.Fatalf("local lock: %v", err)
+	}
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel2()
+	if _, err := h.lockUser(ctx2, 42); !errors.Is(err, errUserBusy) {
+		t.Fatalf("second local lock err = %v, want errUserBusy", err)
+	}
+	unlock()
+}
+
+func TestParseZoneContains(t *testing.T) {
+	// квадрат 0..10 с дыркой 4..6
+	zone, err := parseZone(`{"type":"MultiPolygon","coordinates":[[[[0,0],[10,0],[10,10],[0,10],[0,0]],[[4,4],[6,4],[6,6],[4,6],[4,4]]]]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		lng, lat float64
+		want     bool
+	}{
+		{1, 1, true},
+		{5, 5, false}, // в дырке
+		{11, 5, false},
+		{9.9, 9.9, true},
+	}
+	for _, c := range cases {
+		if got := zone[0].contains(c.lng, c.lat); got != c.want {
+			t.Errorf("contains(%v, %v) = %v, want %v", c.lng, c.lat, got, c.want)
+		}
+	}
+
+	if _, err := parseZone(`{"type":"Point","coordinates":[1,2]}`); err == nil {
+		t.Error("Point accepted as delivery zone")
+	}
+}
+
+func TestWriteErrorDetail(t *testing.T) {
+	appErr := ErrInternal(errors.New("no such table: orders"))
+
+	w := httptest.NewRecorder()
+	writeError(w, appErr)
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "no such table") {
+		t.Fatalf("production response = %d %s", w.Code, w.Body.String())
+	}
+
+	exposeErrorDetail.Store(true)
+	defer exposeErrorDetail.Store(false)
+	w = httptest.NewRecorder()
+	writeError(w, appErr)
+	var body struct{ Error, Detail string }
+	_ = json.Unmarshal(w.Body.Bytes(), &body)
+	if body.Error != "internal error" || body.Detail != "no such table: orders" {
+		t.Fatalf("debug response = %+v", body)
+	}
+}
+
+func TestSendAdminOrderNavigation(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.seedStore("aksai", "Аксай")
+	env.exec(`UPDATE stores SET latitude = 43.2, longitude = 76.9, address = 'мкр. Аксай-4, 12' WHERE code = 'aksai'`)
+
+	navRow := func(i int) []models.InlineKeyboardButton {
+		kb := env.sender.Messages[i].ReplyMarkup.(*models.InlineKeyboardMarkup)
+		return kb.InlineKeyboard[len(kb.InlineKeyboard)-1]
+	}
+
+	// доставка с координатами — маршрут до клиента и точка на карте
+	env.h.sendAdminOrder(ctx, "заказ 1", 1, "aksai", deliveryIn{Type: "delivery", Lat: 43.25, Lng: 76.95})
+	if row := navRow(0); len(row) != 2 || !strings.Contains(row[0].URL, "rtext=~43.250000,76.950000") || !strings.Contains(row[1].URL, "76.950000,43.250000") {
+		t.Fatalf("delivery nav = %+v", row)
+	}
+	if len(env.sender.Locations) != 1 || env.sender.Locations[0].Latitude != 43.25 {
+		t.Fatalf("locations = %+v", env.sender.Locations)
+	}
+
+	// самовывоз — координаты точки
+	env.h.sendAdminOrder(ctx, "заказ 2", 2, "aksai", deliveryIn{Type: "pickup"})
+	if len(env.sender.Locations) != 2 || env.sender.Locations[1].Latitude != 43.2 || env.sender.Locations[1].Longitude != 76.9 {
+		t.Fatalf("pickup location = %+v", env.sender.Locations[1])
+	}
+
+	// без координат и без геокодера — поиск по адресу, без SendLocation
+	env.h.sendAdminOrder(ctx, "заказ 3", 3, "aksai", deliveryIn{Type: "delivery", Address: "Абая 10"})
+	if row := navRow(2); !strings.Contains(row[0].URL, "text=%D0%90%D0%B1%D0%B0%D1%8F+10") {
+		t.Fatalf("address fallback nav = %+v", row)
+	}
+	if len(env.sender.Locations) != 2 {
+		t.Fatalf("location sent without coordinates: %+v", env.sender.Locations)
+	}
+
+	if validCoords(0, 0) || validCoords(91, 10) || !validCoords(43.2, 76.9) {
+		t.Fatal("validCoords")
+	}
+}
+
+func TestFormatMoneyAndDate(t *testing.T) {
+	for amount, want := range map[int64]string{
+		0:       "0 ₸",
+		950:     "950 ₸",
+		1000:    "1 000 ₸",
+		125000:  "125 000 ₸",
+		1234567: "1 234 567 ₸",
+		-4500:   "-4 500 ₸",
+	} {
+		want = strings.ReplaceAll(want, " ", nbsp)
+		if got := formatMoney(amount); got != want {
+			t.Errorf("formatMoney(%d) = %q, want %q", amount, got, want)
+		}
+	}
+
+	setDisplayFormat("ru", "Asia/Almaty")
+	defer setDisplayFormat("", "")
+	// 20:30 UTC 31 марта — в Алматы (UTC+5) уже 1 апреля
+	if got := formatDate(time.Date(2025, 3, 31, 20, 30, 0, 0, time.UTC)); got != "01.04.2025" {
+		t.Fatalf("formatDate = %q", got)
+	}
+}
+
+func TestOrderBroker(t *testing.T) {
+	b := newOrderBroker()
+	for i := range orderEventsReplay + 5 {
+		b.publish(orderEvent{OrderID: int64(i)})
+	}
+	if len(b.ring) != orderEventsReplay || b.ring[0].ID != 6 {
+		t.Fatalf("ring = %d events from id %d", len(b.ring), b.ring[0].ID)
+	}
+
+	_, replay, cancel := b.subscribe(b.lastID - 2)
+	cancel()
+	if len(replay) != 2 || replay[0].ID != b.lastID-1 {
+		t.Fatalf("replay = %+v", replay)
+	}
+	// id из будущего (после перезапуска) — весь буфер
+	_, replay, cancel = b.subscribe(b.lastID + 100)
+	cancel()
+	if len(replay) != orderEventsReplay {
+		t.Fatalf("stale replay = %d", len(replay))
+	}
+
+	// клиент не читает — publish не блокируется, канал закрывается
+	slow, _, cancel := b.subscribe(0)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		for range orderEventsBuffer + 1 {
+			b.publish(orderEvent{})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on a slow subscriber")
+	}
+	n := 0
+	for range slow {
+		n++
+	}
+	if n != orderEventsBuffer {
+		t.Fatalf("slow client got %d events before drop", n)
+	}
+}
+
+// rateLimitedSender отвечает 429 на первые fails отправок сообщений.
+type rateLimitedSender struct {
+	*RecordingSender
+	fails atomic.Int32
+}
+
+func (s *rateLimitedSender) SendMessage(ctx context.Context, p *bot.SendMessageParams) (*models.Message, error) {
+	if s.fails.Add(-1) >= 0 {
+		return nil, &bot.TooManyRequestsError{Message: "Too Many Requests", RetryAfter: 1}
+	}
+	return s.RecordingSender.SendMessage(ctx, p)
+}
+
+func TestAdminNotifier(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.h.notifier.window = 200 * time.Millisecond
+	env.seedUser(701, "")
+	env.exec(`INSERT INTO orders (id, user_id, total_amount, status) VALUES (41, 701, 1500, 'new')`)
+
+	// первое уведомление после затишья уходит сразу, повтор того же текста — нет
+	env.h.notifyAdmin("⚠️ бэкап упал")
+	env.h.notifyAdmin("⚠️ бэкап упал")
+	if msgs := waitMessages(t, env.sender, testAdminID, 1); len(msgs) != 1 {
+		t.Fatalf("first notice = %v", msgs)
+	}
+
+	// три заказа в окне — одна сводка с кнопкой на каждый заказ
+	for _, id := range []int64{41, 42, 43} {
+		env.h.notifyAdminOrder(fmt.Sprintf("🧾 Новый заказ №%d", id), id, "", deliveryIn{Type: "pickup"})
+	}
+	msgs := waitMessages(t, env.sender, testAdminID, 2)
+	if len(msgs) != 2 || !strings.HasPrefix(msgs[1], "🛒 3 новых заказа: №41, №42, №43") || !strings.Contains(msgs[1], "🧾 Новый заказ №43") {
+		t.Fatalf("digest = %v", msgs)
+	}
+	kb := env.sender.Messages[1].ReplyMarkup.(*models.InlineKeyboardMarkup)
+	if len(kb.InlineKeyboard) != 1 || len(kb.InlineKeyboard[0]) != 3 || kb.InlineKeyboard[0][0].CallbackData != "ord_show:41" {
+		t.Fatalf("digest keyboard = %+v", kb.InlineKeyboard)
+	}
+
+	// кнопка из сводки — карточка заказа с кнопками статусов
+	env.h.OrderStatusCallbackHandler(ctx, nil, &models.Update{CallbackQuery: &models.CallbackQuery{
+		ID: "cb", From: models.User{ID: testAdminID}, Data: "ord_show:41",
+	}})
+	msgs = env.sender.MessagesTo(testAdminID)
+	if len(msgs) != 3 || !strings.Contains(msgs[2], "Заказ №41") {
+		t.Fatalf("order card = %v", msgs)
+	}
+	if kb := env.sender.Messages[2].ReplyMarkup.(*models.InlineKeyboardMarkup); kb.InlineKeyboard[0][0].CallbackData != "ord_preparing:41" {
+		t.Fatalf("order card keyboard = %+v", kb.InlineKeyboard)
+	}
+
+	n := env.h.notifier
+	if n.queued.Load() != 4 || n.sent.Load() != 4 || n.dropped.Load() != 1 || n.batches.Load() != 1 {
+		t.Fatalf("counters queued=%d sent=%d dropped=%d batches=%d",
+			n.queued.Load(), n.sent.Load(), n.dropped.Load(), n.batches.Load())
+	}
+	w := env.do(http.MethodGet, "/api/admin/notifications/stats", nil, env.admin())
+	var stats map[string]int64
+	decode(t, w, &stats)
+	if stats["sent"] != 4 || stats["dropped"] != 1 {
+		t.Fatalf("stats = %v", stats)
+	}
+	if w := env.do(http.MethodGet, "/api/admin/notifications/stats", nil, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("stats without admin = %d", w.Code)
+	}
+}
+
+func TestAdminNotifierRetryAfter(t *testing.T) {
+	env := newTestEnv(t)
+	limited := &rateLimitedSender{RecordingSender: env.sender}
+	limited.fails.Store(1)
+	env.h.SetSender(limited)
+
+	start := time.Now()
+	env.h.notifyAdmin("🧾 Заявка на подписку")
+	if msgs := waitMessages(t, env.sender, testAdminID, 1); len(msgs) != 1 {
+		t.Fatalf("message after 429 = %v", msgs)
+	}
+	if time.Since(start) < time.Second {
+		t.Fatal("retry did not honour retry_after")
+	}
+	if env.h.notifier.sent.Load() != 1 || env.h.notifier.dropped.Load() != 0 {
+		t.Fatalf("sent=%d dropped=%d", env.h.notifier.sent.Load(), env.h.notifier.dropped.Load())
+	}
+}
+
+func TestAdminNotifierRedisDedup(t *testing.T) {
+	env := newTestEnv(t)
+	env.h.notifier.window = 50 * time.Millisecond
+
+	env.h.notifyAdmin("⚠️ бэкап упал")
+	if msgs := waitMessages(t, env.sender, testAdminID, 1); len(msgs) != 1 {
+		t.Fatalf("first notice = %v", msgs)
+	}
+	if !env.redis.Exists(repository.NotifyDedupKey("⚠️ бэкап упал")) {
+		t.Fatal("dedup key is not set")
+	}
+
+	// второй экземпляр бота: своей памяти о тексте нет, но ключ в Redis общий
+	env.h.notifier.mu.Lock()
+	env.h.notifier.seen = map[string]time.Time{}
+	env.h.notifier.mu.Unlock()
+	env.h.notifyAdmin("⚠️ бэкап упал")
+	env.h.notifyAdmin("⚠️ диск заполнен")
+	if msgs := waitMessages(t, env.sender, testAdminID, 2); len(msgs) != 2 || msgs[1] != "⚠️ диск заполнен" {
+		t.Fatalf("messages = %v", msgs)
+	}
+	if env.h.notifier.dropped.Load() != 1 {
+		t.Fatalf("dropped = %d", env.h.notifier.dropped.Load())
+	}
+
+	// первые 100 символов совпадают — это тот же текст
+	long := strings.Repeat("я", 100)
+	if repository.NotifyDedupKey(long+"1") != repository.NotifyDedupKey(long+"2") {
+		t.Fatal("key must depend only on the first 100 characters")
+	}
+}
+
+func TestDetectCSVLocale(t *testing.T) {
+	for _, tc := range []struct {
+		headers []string
+		want    string
+	}{
+		{[]string{"name", "emoji", "category", "unit", "price"}, "en"},
+		{[]string{"\ufeffНазвание", "Emoji", "Категория", "Единица", "Цена", "Код магазина"}, "ru"},
+		{[]string{"Emoji"}, "en"},
+		{nil, "en"},
+	} {
+		if got := detectCSVLocale(tc.headers); got != tc.want {
+			t.Errorf("detectCSVLocale(%q) = %q, want %q", tc.headers, got, tc.want)
+		}
+	}
+}
+
+func TestPackTexts(t *testing.T) {
+	chunks := packTexts([]string{"aaaa", "bbbb", "cccc"}, "|", 9)
+	if len(chunks) != 2 || chunks[0].text != "aaaa|bbbb" || chunks[0].count != 2 || chunks[1].text != "cccc" {
+		t.Fatalf("chunks = %+v", chunks)
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	for raw, want := range map[string]string{
+		"8 701 123 45 67":    "+77011234567",
+		"+7 (701) 123-45-67": "+77011234567",
+		"7011234567":         "+77011234567",
+		"77011234567":        "+77011234567",
+		"12345":              "",
+		"+1 202 555 0100":    "",
+		"":                   "",
+	} {
+		if got := normalizePhone(raw); got != want {
+			t.Errorf("normalizePhone(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestRunDailyChecks(t *testing.T) {
+	h, _ := newTestHandler(t)
+	setDisplayFormat("ru", "Asia/Almaty")
+	defer setDisplayFormat("", "")
+	h.cfg.SubCheckTime = "09:00"
+	h.cfg.SubCheckRetry = 15 * time.Minute
+	// 12:00 по Алматы (UTC+5)
+	clock := &fakeClock{t: time.Date(2025, 3, 1, 7, 0, 0, 0, time.UTC)}
+	h.SetClock(clock)
+	ctx := context.Background()
+
+	expire := func(userID int64) {
+		t.Helper()
+		if _, err := h.db.Exec(`INSERT INTO subscriptions (user_id, status, valid_until) VALUES (?, 'active', ?)`,
+			userID, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status := func(userID int64) string {
+		var s string
+		_ = h.db.QueryRow(`SELECT status FROM subscriptions WHERE user_id = ?`, userID).Scan(&s)
+		return s
+	}
+
+	// первый запуск: прогонов ещё не было — проверяем сразу, следующий завтра в 09:00
+	expire(1)
+	if wait := h.runDailyChecks(ctx); wait != 21*time.Hour || status(1) != "expired" {
+		t.Fatalf("first run: wait = %v, status = %s", wait, status(1))
+	}
+
+	// рестарт в тот же день — повторно не проверяем
+	expire(2)
+	clock.t = clock.t.Add(time.Hour)
+	if wait := h.runDailyChecks(ctx); wait != 20*time.Hour || status(2) != "active" {
+		t.Fatalf("restart: wait = %v, status = %s", wait, status(2))
+	}
+
+	// процесс лежал сутки с лишним — после старта наверстываем пропущенный прогон
+	clock.t = time.Date(2025, 3, 3, 4, 30, 0, 0, time.UTC) // 09:30 по Алматы
+	if wait := h.runDailyChecks(ctx); wait != 23*time.Hour+30*time.Minute || status(2) != "expired" {
+		t.Fatalf("catch up: wait = %v, status = %s", wait, status(2))
+	}
+
+	// ошибка — повтор через SUB_CHECK_RETRY, время прогона не сохраняется
+	clock.t = clock.t.AddDate(0, 0, 1)
+	if _, err := h.db.Exec(`ALTER TABLE subscriptions RENAME TO subscriptions_old`); err != nil {
+		t.Fatal(err)
+	}
+	if wait := h.runDailyChecks(ctx); wait != 15*time.Minute {
+		t.Fatalf("failed run: wait = %v", wait)
+	}
+	if last, _ := h.lastExpiryRun(); !last.Before(clock.t) {
+		t.Fatalf("last run saved after failure: %v", last)
+	}
+}
+
+// flakySender отдаёт ошибки из errs по очереди, потом отправляет как RecordingSender.
+type flakySender struct {
+	RecordingSender
+	errs  []error
+	calls int
+}
+
+func (s *flakySender) SendMessage(ctx context.Context, p *bot.SendMessageParams) (*models.Message, error) {
+	s.calls++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return nil, err
+	}
+	return s.RecordingSender.SendMessage(ctx, p)
+}
+
+func TestRetrySender(t *testing.T) {
+	server502 := errors.New("error response from telegram for method sendMessage, 502 Bad Gateway")
+	newSender := func(errs ...error) (*retrySender, *flakySender, *[]time.Duration, *fakeClock) {
+		next := &flakySender{errs: errs}
+		clock := &fakeClock{t: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)}
+		var slept []time.Duration
+		s := newRetrySender(next, zap.NewNop())
+		s.now = clock.Now
+		s.sleep = func(_ context.Context, d time.Duration) error {
+			if d > 0 {
+				slept = append(slept, d)
+			}
+			return nil
+		}
+		return s, next, &slept, clock
+	}
+	ctx := context.Background()
+	msg := &bot.SendMessageParams{ChatID: int64(7), Text: "hi"}
+
+	// 429: ждём retry_after, 5xx — экспоненциальная пауза
+	s, next, slept, _ := newSender(&bot.TooManyRequestsError{Message: "too many requests", RetryAfter: 3}, server502, server502)
+	if _, err := s.SendMessage(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{3 * time.Second, time.Second, 2 * time.Second}
+	if next.calls != 4 || fmt.Sprint(*slept) != fmt.Sprint(want) {
+		t.Fatalf("calls = %d, slept = %v, want %v", next.calls, *slept, want)
+	}
+	if st := s.stats(); st["retried"] != int64(3) || st["failed"] != int64(0) || st["breaker_state"] != "closed" {
+		t.Fatalf("stats = %v", st)
+	}
+
+	// 403 (бот заблокирован) не повторяем
+	s, next, _, _ = newSender(fmt.Errorf("%w, bot was blocked by the user", bot.ErrorForbidden))
+	if _, err := s.SendMessage(ctx, msg); !errors.Is(err, bot.ErrorForbidden) || next.calls != 1 {
+		t.Fatalf("forbidden: err = %v, calls = %d", err, next.calls)
+	}
+
+	// интервал между сообщениями в один чат; другой чат не ждёт
+	s, _, slept, _ = newSender()
+	for _, chat := range []int64{7, 7, 8} {
+		if _, err := s.SendMessage(ctx, &bot.SendMessageParams{ChatID: chat, Text: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(*slept) != fmt.Sprint([]time.Duration{time.Second}) {
+		t.Fatalf("chat interval: slept = %v", *slept)
+	}
+
+	// Telegram лежит: после telegramBreakerFailures неудачных вызовов не ходим
+	var errs []error
+	for range telegramBreakerFailures * (telegramMaxRetries + 1) {
+		errs = append(errs, server502)
+	}
+	s, next, _, clock := newSender(errs...)
+	s.chatInterval = 0
+	recovered := make(chan struct{})
+	s.onRecover = func() { close(recovered) }
+	for range telegramBreakerFailures {
+		if _, err := s.SendMessage(ctx, msg); err == nil {
+			t.Fatal("expected 502")
+		}
+	}
+	calls := next.calls
+	if _, err := s.SendMessage(ctx, msg); !errors.Is(err, errTelegramUnavailable) || next.calls != calls {
+		t.Fatalf("open breaker: err = %v, calls %d → %d", err, calls, next.calls)
+	}
+	if st := s.stats(); st["breaker_state"] != "open" || st["short_circuited"] != int64(1) || st["breaker_opens"] != int64(1) {
+		t.Fatalf("stats = %v", st)
+	}
+
+	// после cooldown пропускается один пробный вызов: пока он идёт,
+	// остальные получают errTelegramUnavailable
+	clock.t = clock.t.Add(telegramBreakerCooldown)
+	if ok, probe := s.allow(); !ok || !probe {
+		t.Fatalf("half-open: allow = %v, %v", ok, probe)
+	}
+	if ok, _ := s.allow(); ok {
+		t.Fatal("second call allowed while probe is in flight")
+	}
+	s.endProbe()
+
+	// проба проходит и замыкает предохранитель
+	if _, err := s.SendMessage(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-recovered:
+	case <-time.After(time.Second):
+		t.Fatal("onRecover was not called")
+	}
+	if st := s.stats(); st["breaker_state"] != "closed" {
+		t.Fatalf("stats = %v", st)
+	}
+}
+
+func TestSendOrQueueWhenTelegramDown(t *testing.T) {
+	h, _ := newTestHandler(t)
+	tg := newRetrySender(&RecordingSender{}, zap.NewNop())
+	tg.openUntil = time.Now().Add(time.Hour)
+	h.SetSender(tg)
+
+	queued, err := h.sendOrQueue(context.Background(), &bot.SendMessageParams{ChatID: int64(42), Text: "заказ принят"})
+	if err != nil || !queued {
+		t.Fatalf("queued = %v, err = %v", queued, err)
+	}
+	// flushOutbox не тратит попытки, пока предохранитель разомкнут
+	if sent, err := h.flushOutbox(context.Background()); err != nil || sent != 0 {
+		t.Fatalf("flush: sent = %d, err = %v", sent, err)
+	}
+	var attempts int
+	if err := h.db.QueryRow(`SELECT attempts FROM notification_outbox WHERE chat_id = 42`).Scan(&attempts); err != nil || attempts != 0 {
+		t.Fatalf("attempts = %d, err = %v", attempts, err)
+	}
+}
+
+func TestBroadcastMediaGoesThroughSender(t *testing.T) {
+	h, rec := newTestHandler(t)
+	ctx := context.Background()
+	for _, typ := range []string{"text", "photo", "video", "document", "video_note", "audio"} {
+		if err := h.sendToUser(ctx, 42, typ, "file-"+typ, "подпись"); err != nil {
+			t.Fatalf("%s: %v", typ, err)
+		}
+	}
+	if len(rec.Messages) != 1 || len(rec.Photos) != 1 || len(rec.Videos) != 1 ||
+		len(rec.Documents) != 1 || len(rec.Notes) != 1 || len(rec.Audios) != 1 {
+		t.Fatalf("recorded: messages=%d photos=%d videos=%d documents=%d notes=%d audios=%d",
+			len(rec.Messages), len(rec.Photos), len(rec.Videos), len(rec.Documents), len(rec.Notes), len(rec.Audios))
+	}
+	if !rec.Videos[0].ProtectContent || rec.Videos[0].Caption != "подпись" {
+		t.Fatalf("video = %+v", rec.Videos[0])
+	}
+}
+
+func TestValidateEmoji(t *testing.T) {
+	valid := []string{
+		"🥔", "🥕", "🍅", "🍎", "🍌", "🥒", "🌽", "🧅", "🧄", "🥬",
+		"🍞", "🧀", "🥚", "🍯", "🐟", "🍗", "☕", "🫐", "❤️", "👨‍🌾",
+		"⌚", "⌛", "©️", "®", "🅰️", "🆕", "🆓", "↔️", "↩️",
+		"1️⃣", "#️⃣", "*️⃣", "5\u20e3", // клавиши, в том числе без U+FE0F
+	}
+	for _, e := range valid {
+		if err := validateEmoji(e); err != nil {
+			t.Errorf("validateEmoji(%q) = %v, want ok", e, err)
+		}
+	}
+	invalid := []string{
+		"ab",
+		"A",
+		"Я",
+		"картофель",
+		"🥔🥕",      // два эмодзи
+		"🇷🇺",      // флаг из региональных индикаторов
+		"🥔 ",      // эмодзи и пробел
+		"\u200d🥔", // ZWJ в начале
+		"🥔\u200d", // висячий ZWJ
+		"🥔\u200d🥔\u200d🥔\u200d🥔\u200d🥔", // слишком длинная ZWJ-цепочка
+		"🇷",        // одиночный региональный индикатор
+		"1",        // цифра без рамки клавиши
+		"12\u20e3", // две цифры в рамке
+		"\u20e3",   // рамка без клавиши
+		"↚",        // стрелка между ↙ и ↩ — не эмодзи
+		"ª",        // Latin-1 рядом с © и ®
+	}
+	for _, e := range invalid {
+		if err := validateEmoji(e); err == nil {
+			t.Errorf("validateEmoji(%q) = nil, want error", e)
+		}
+	}
+}
+
+func TestWebhookClientBlocksInternalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("webhook reached a loopback address")
+	}))
+	defer srv.Close()
+
+	// имя могло пройти validWebhookURL, но резолвится в 127.0.0.1
+	_, err := newWebhookClient(time.Second).Post(srv.URL, "application/json", strings.NewReader("{}"))
+	if err == nil || !strings.Contains(err.Error(), "is not public") {
+		t.Fatalf("post to loopback: err = %v", err)
+	}
+}
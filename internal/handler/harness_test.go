@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"agro/config"
+	"agro/internal/repository"
+	"agro/traits/database"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const testAdminID = int64(1)
+
+var testDBSeq atomic.Int64
+
+// testEnv — Handler на in-memory SQLite, miniredis и RecordingSender.
+// Запросы идут через тот же mux, что и в StartWebServer.
+type testEnv struct {
+	t      testing.TB
+	h      *Handler
+	sender *RecordingSender
+	redis  *miniredis.Miniredis
+	srv    http.Handler
+}
+
+func newTestEnv(t testing.TB) *testEnv {
+	t.Helper()
+
+	// у каждого теста своя именованная in-memory база; cache=shared —
+	// чтобы все соединения пула видели одни и те же таблицы
+	dsn := fmt.Sprintf("file:agro_test_%d?mode=memory&cache=shared", testDBSeq.Add(1))
+	db, err := database.InitDatabase(database.DriverSQLite, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	cfg := &config.Config{
+		Token:       "test-token",
+		AdminID:     testAdminID,
+		KaspiPayURL: "https://pay.example/kaspi",
+	}
+	h := NewHandler(zap.NewNop(), cfg, context.Background(), db, repository.NewRedisClient(rdb))
+	rec := &RecordingSender{}
+	h.SetSender(rec)
+
+	return &testEnv{t: t, h: h, sender: rec, redis: mr, srv: h.Routes()}
+}
+
+// newTestHandler — короткая форма для тестов, которым нужен только Handler.
+func newTestHandler(t *testing.T) (*Handler, *RecordingSender) {
+	env := newTestEnv(t)
+	return env.h, env.sender
+}
+
+// do выполняет запрос через mux. body: string — как есть, иначе JSON.
+func (e *testEnv) do(method, path string, body any, headers map[string]string) *httptest.ResponseRecorder {
+	e.t.Helper()
+	var rdr io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		rdr = strings.NewReader(b)
+	case io.Reader:
+		rdr = b
+	default:
+		raw, err := json.Marshal(b)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		rdr = bytes.NewReader(raw)
+	}
+	req := httptest.NewRequest(method, path, rdr)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	e.srv.ServeHTTP(w, req)
+	return w
+}
+
+// admin — заголовки запроса от администратора.
+func (e *testEnv) admin() map[string]string {
+	return map[string]string{"X-Telegram-Id": fmt.Sprint(testAdminID)}
+}
+
+// multipart собирает multipart/form-data тело из полей формы.
+func (e *testEnv) multipart(fields map[string]string) (io.Reader, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		_ = mw.WriteField(k, v)
+	}
+	_ = mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+func (e *testEnv) exec(q string, args ...any) {
+	e.t.Helper()
+	if _, err := e.h.db.Exec(q, args...); err != nil {
+		e.t.Fatalf("exec %q: %v", q, err)
+	}
+}
+
+func (e *testEnv) seedStore(code, name string) {
+	e.exec(`INSERT INTO stores (code, name, address) VALUES (?, ?, ?)`, code, name, "адрес "+name)
+}
+
+func (e *testEnv) seedProduct(name, category string, price int64, storeCode string) int64 {
+	e.t.Helper()
+	res, err := e.h.db.Exec(`
+		INSERT INTO products (name, category_slug, unit, price, store_code)
+		VALUES (?, ?, 'кг', ?, ?)
+	`, name, category, price, nullString(storeCode))
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+func (e *testEnv) seedUser(telegramID int64, selectedStore string) {
+	e.exec(`INSERT INTO users (id, user_id, nickname, selected_store) VALUES (?, ?, 'tester', ?)`,
+		fmt.Sprintf("u%d", telegramID), telegramID, nullString(selectedStore))
+}
+
+// decode разбирает JSON-ответ в v.
+func decode(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+}
+
+// waitMessages ждёт асинхронные уведомления (notifyAdmin шлёт в горутине).
+func waitMessages(t *testing.T, rec *RecordingSender, chatID int64, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		msgs := rec.MessagesTo(chatID)
+		if len(msgs) >= n || time.Now().After(deadline) {
+			return msgs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,55 @@
+// handler/init-data.go
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// initDataMaxAge — сколько живёт initData мини-аппа. Telegram выдаёт его при
+// открытии мини-аппа, поэтому сутки с запасом покрывают одну сессию.
+const initDataMaxAge = 24 * time.Hour
+
+// validInitData проверяет Telegram.WebApp.initData по правилам Telegram:
+// hash = hex(HMAC-SHA256(data_check_string)) с ключом HMAC-SHA256("WebAppData", token).
+// Так мини-апп подтверждает запрос, не зная токена бота.
+func validInitData(token, raw string, now time.Time) bool {
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return false
+	}
+	hash := values.Get("hash")
+	if hash == "" {
+		return false
+	}
+	got, err := hex.DecodeString(hash)
+	if err != nil {
+		return false
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil || now.Sub(time.Unix(authDate, 0)) > initDataMaxAge {
+		return false
+	}
+
+	pairs := make([]string, 0, len(values))
+	for k, v := range values {
+		if k == "hash" || len(v) == 0 {
+			continue
+		}
+		pairs = append(pairs, k+"="+v[0])
+	}
+	sort.Strings(pairs)
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(token))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	return hmac.Equal(got, mac.Sum(nil))
+}
@@ -0,0 +1,162 @@
+// handler/inline-search.go
+package handler
+
+import (
+	"agro/internal/repository"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+const (
+	inlineSearchLimit    = 20 // Telegram показывает не больше 50 результатов
+	inlineSearchMinLen   = 2  // «п» — слишком широкий запрос, ждём ещё букву
+	inlineQueryCacheTime = 30 // секунд; результаты персональные (зависят от точки)
+)
+
+// inlineHit — товар в результатах inline-поиска (то, что кладём в кэш).
+type inlineHit struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Emoji string `json:"emoji"`
+	Unit  string `json:"unit"`
+	Price int64  `json:"price"`
+	Photo string `json:"photo"`
+	// оптовая цена скрыта: Price — розничная, а у товаров для подписчиков 0
+	Locked bool `json:"locked,omitempty"`
+}
+
+// IsInlineQuery — фильтр для регистрации InlineQueryHandler:
+// b.RegisterHandlerMatchFunc(handler.IsInlineQuery, handl.InlineQueryHandler)
+func IsInlineQuery(update *models.Update) bool {
+	return update.InlineQuery != nil
+}
+
+// InlineQueryHandler — поиск товаров прямо из строки ввода: «@agrobot помидор».
+// Ищем по названию среди товаров выбранной пользователем точки (и общих),
+// в ответ — карточки с ценой и ссылкой в мини-апп.
+func (h *Handler) InlineQueryHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	q := update.InlineQuery
+	if q == nil {
+		return
+	}
+	query := strings.ToLower(strings.Join(strings.Fields(q.Query), " "))
+
+	var store string
+	if q.From != nil {
+		_ = h.db.QueryRow(`SELECT COALESCE(selected_store, '') FROM users WHERE user_id = ?`, q.From.ID).Scan(&store)
+	}
+
+	params := &bot.AnswerInlineQueryParams{
+		InlineQueryID: q.ID,
+		Results:       []models.InlineQueryResult{},
+		CacheTime:     inlineQueryCacheTime,
+		IsPersonal:    true,
+		Button: &models.InlineQueryResultsButton{
+			Text:   "🛒 Открыть каталог",
+			WebApp: &models.WebAppInfo{URL: h.miniAppSearchURL(query)},
+		},
+	}
+	if len([]rune(query)) >= inlineSearchMinLen {
+		hits, err := h.inlineSearch(ctx, store, query)
+		if err != nil {
+			h.logger.Error("inline product search", zap.String("query", query), zap.Error(err))
+		}
+		for _, p := range hits {
+			params.Results = append(params.Results, h.inlineArticle(p))
+		}
+	}
+
+	if _, err := h.sender.AnswerInlineQuery(ctx, params); err != nil {
+		h.logger.Warn("answer inline query", zap.String("query", query), zap.Error(err))
+	}
+}
+
+// inlineSearch — товары точки, в названии которых есть query. Ищем в Go, а не
+// через LIKE: LOWER() в SQLite не понижает регистр кириллицы. Результат
+// кэшируется в Redis вместе с каталогом и сбрасывается при его изменении.
+func (h *Handler) inlineSearch(ctx context.Context, store, query string) ([]inlineHit, error) {
+	key := repository.InlineSearchCacheKey(store, query)
+	if data := h.cachedProducts(ctx, key); data != nil {
+		var hits []inlineHit
+		if err := json.Unmarshal(data, &hits); err == nil {
+			return hits, nil
+		}
+	}
+
+	products, err := h.listProducts(store, "")
+	if err != nil {
+		return nil, err
+	}
+	// карточку можно переслать в любой чат — оптовые цены в ней не показываем
+	products = guestPrices(products)
+	hits := []inlineHit{}
+	for _, p := range products {
+		if !strings.Contains(strings.ToLower(p.Name), query) {
+			continue
+		}
+		hits = append(hits, inlineHit{ID: p.ID, Name: p.Name, Emoji: p.Emoji, Unit: p.Unit, Price: p.Price, Photo: p.Photo, Locked: p.Locked})
+		if len(hits) == inlineSearchLimit {
+			break
+		}
+	}
+	if data, err := json.Marshal(hits); err == nil {
+		h.cacheProducts(ctx, key, data)
+	}
+	return hits, nil
+}
+
+func (h *Handler) inlineArticle(p inlineHit) *models.InlineQueryResultArticle {
+	title := strings.TrimSpace(p.Emoji + " " + p.Name)
+	price := fmt.Sprintf("%s / %s", formatMoney(p.Price), p.Unit)
+	text := fmt.Sprintf("%s\n💰 %s — оптовая цена «АГРО Клуба»", title, price)
+	switch {
+	case p.Locked && p.Price == 0:
+		price = lockedPriceText
+		text = fmt.Sprintf("%s\n%s", title, price)
+	case p.Locked:
+		text = fmt.Sprintf("%s\n💰 %s\n%s", title, price, lockedPriceText)
+	}
+	link := h.inlineProductLink(p.ID)
+	a := &models.InlineQueryResultArticle{
+		ID:          strconv.FormatInt(p.ID, 10),
+		Title:       title,
+		Description: price,
+		InputMessageContent: &models.InputTextMessageContent{
+			MessageText: text,
+		},
+		ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{{
+			{Text: "🛒 Заказать", URL: link},
+		}}},
+	}
+	if strings.HasPrefix(p.Photo, "http") {
+		a.ThumbnailURL = p.Photo
+	}
+	return a
+}
+
+// inlineProductLink — ссылка «Заказать» под карточкой. Сообщение из inline-режима
+// уходит в любой чат, а web_app-кнопки работают только в чате с ботом, поэтому
+// при заданном BOT_USERNAME — t.me/<bot>?startapp=product_<id>, иначе productDeepLink.
+func (h *Handler) inlineProductLink(productID int64) string {
+	if h.cfg.BotUsername != "" {
+		return fmt.Sprintf("https://t.me/%s?startapp=product_%d", h.cfg.BotUsername, productID)
+	}
+	return h.productDeepLink(productID)
+}
+
+// miniAppSearchURL — мини-апп с уже введённым поиском (кнопка над результатами).
+func (h *Handler) miniAppSearchURL(query string) string {
+	base := strings.TrimRight(h.cfg.MiniAppUrl, "/") + "/catalog"
+	if query == "" {
+		return base
+	}
+	return base + "?q=" + url.QueryEscape(query)
+}
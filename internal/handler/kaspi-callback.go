@@ -0,0 +1,148 @@
+// handler/kaspi-callback.go
+package handler
+
+import (
+	"agro/internal/repository"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const kaspiCallbackMaxBody = 64 << 10
+
+// kaspiDecider — от чьего имени подтверждается оплата по callback'у Kaspi:
+// в заказе/подписке и журнале вместо админа будет «Kaspi Pay».
+var kaspiDecider = paymentDecider{Name: "Kaspi Pay"}
+
+// kaspiCallback — тело серверного уведомления Kaspi Pay об оплате.
+// Счёт указывается одним из полей: order_id (заказ) или subscription_id.
+type kaspiCallback struct {
+	TxnID          string `json:"txn_id"`
+	OrderID        int64  `json:"order_id"`
+	SubscriptionID int64  `json:"subscription_id"`
+	Amount         int64  `json:"amount"` // ₸
+	Status         string `json:"status"` // success | failed | cancelled
+}
+
+// validKaspiSignature — X-Kaspi-Signature = hex(HMAC-SHA256(тело, KASPI_WEBHOOK_SECRET)),
+// допускается префикс "sha256=".
+func validKaspiSignature(secret string, body []byte, sig string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(sig), "sha256="))
+	if err != nil || len(got) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// POST /api/payments/kaspi-callback — Kaspi Pay сообщает об оплате счёта.
+// Подписанный успешный платёж подтверждает заказ или подписку так же, как
+// кнопка «✅ Подтвердить» под чеком, без участия админа. Без KASPI_WEBHOOK_SECRET
+// callback'и отклоняются (503) — оплату, как и раньше, подтверждает админ.
+// Повторный callback по уже решённому счёту — 200 с already_decided.
+func (h *Handler) handleKaspiCallback(w http.ResponseWriter, r *http.Request) {
+	secret := h.cfg.KaspiWebhookSecret
+	if secret == "" {
+		writeError(w, ErrServiceUnavailable("kaspi webhook is not configured"))
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, kaspiCallbackMaxBody))
+	if err != nil {
+		writeError(w, ErrBadRequest("cannot read body"))
+		return
+	}
+	if !validKaspiSignature(secret, body, r.Header.Get("X-Kaspi-Signature")) {
+		h.logger.Warn("invalid kaspi callback signature", zap.String("remote", r.RemoteAddr))
+		writeError(w, ErrUnauthorized("invalid signature"))
+		return
+	}
+
+	var cb kaspiCallback
+	if err := json.Unmarshal(body, &cb); err != nil {
+		writeError(w, ErrBadRequest("bad json"))
+		return
+	}
+	if cb.TxnID == "" || (cb.OrderID > 0) == (cb.SubscriptionID > 0) {
+		writeError(w, ErrBadRequest("txn_id and exactly one of order_id, subscription_id are required"))
+		return
+	}
+
+	kind, id, table, amountCol := "order", cb.OrderID, "orders", "total_amount"
+	if cb.SubscriptionID > 0 {
+		kind, id, table, amountCol = "subscription", cb.SubscriptionID, "subscriptions", "amount"
+	}
+	target := fmt.Sprintf("%s:%d", kind, id)
+	details := map[string]any{"txn_id": cb.TxnID, "amount": cb.Amount, "status": cb.Status}
+	h.auditQuiet(0, "payment.kaspi_callback", target, details)
+
+	if !strings.EqualFold(cb.Status, "success") {
+		// неуспешный платёж ничего не меняет: чек, если придёт, проверит админ
+		h.logger.Info("kaspi callback: payment not successful",
+			zap.String("target", target), zap.String("status", cb.Status), zap.String("txn_id", cb.TxnID))
+		jsonOK(w, map[string]any{"status": "ignored"})
+		return
+	}
+
+	var (
+		userID int64
+		amount int64
+	)
+	err = h.db.QueryRowContext(r.Context(),
+		`SELECT user_id, `+amountCol+` FROM `+table+` WHERE id = ?`, id).Scan(&userID, &amount)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, ErrNotFound(kind))
+		return
+	}
+	if err != nil {
+		h.logger.Error("select kaspi callback target", zap.String("target", target), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	if cb.Amount != amount {
+		h.logger.Warn("kaspi callback amount mismatch",
+			zap.String("target", target), zap.Int64("want", amount), zap.Int64("got", cb.Amount))
+		h.notifyAdmin(fmt.Sprintf("⚠️ Kaspi: сумма оплаты %s не совпадает со счётом (%s вместо %s), txn %s. Проверьте вручную.",
+			target, formatMoney(cb.Amount), formatMoney(amount), cb.TxnID))
+		writeError(w, ErrConflict("amount mismatch").WithField("expected", amount))
+		return
+	}
+
+	// не пересекаемся с админом, нажимающим кнопку под чеком этого же пользователя
+	unlock, ok := h.lockUserHTTP(w, r, fmt.Sprint(userID))
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	if kind == "order" {
+		err = h.confirmOrderPayment(r.Context(), id, userID, kaspiDecider)
+	} else {
+		err = h.confirmSubscriptionPayment(r.Context(), id, userID, kaspiDecider)
+	}
+	switch {
+	case errors.Is(err, errPaymentDecided), errors.Is(err, errSubscriptionNotPending):
+		jsonOK(w, map[string]any{"status": "already_decided"})
+		return
+	case errors.Is(err, repository.ErrOrderNotFound):
+		writeError(w, ErrNotFound(kind))
+		return
+	case err != nil:
+		h.logger.Error("confirm kaspi payment", zap.String("target", target), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	h.logger.Info("kaspi payment confirmed", zap.String("target", target), zap.String("txn_id", cb.TxnID))
+	h.notifyAdmin(fmt.Sprintf("💳 Kaspi подтвердил оплату: %s на %s (txn %s)", target, formatMoney(amount), cb.TxnID))
+	jsonOK(w, map[string]any{"status": "confirmed"})
+}
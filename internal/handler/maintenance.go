@@ -0,0 +1,128 @@
+// handler/maintenance.go
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ключи app_settings для режима обслуживания
+const (
+	settingMaintenance        = "maintenance"
+	settingMaintenanceMessage = "maintenance_message"
+)
+
+const defaultMaintenanceMessage = "🛠 Идёт обновление каталога. Приём заказов временно приостановлен — " +
+	"цены и товары можно смотреть как обычно. Пожалуйста, загляните чуть позже."
+
+// setting читает значение из app_settings; ok=false — ключ не задан.
+func (h *Handler) setting(key string) (value string, ok bool, err error) {
+	err = h.db.QueryRow(`SELECT value FROM app_settings WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return value, err == nil, err
+}
+
+// maintenanceState — включён ли режим обслуживания и текст для пользователей.
+// Значения из app_settings перекрывают MAINTENANCE / MAINTENANCE_MESSAGE из конфига.
+func (h *Handler) maintenanceState() (bool, string) {
+	on, msg := h.cfg.Maintenance, h.cfg.MaintenanceMessage
+	if v, ok, err := h.setting(settingMaintenance); err != nil {
+		h.logger.Warn("read maintenance flag", zap.Error(err))
+	} else if ok {
+		on = v == "1"
+	}
+	if v, ok, _ := h.setting(settingMaintenanceMessage); ok {
+		msg = v
+	}
+	if strings.TrimSpace(msg) == "" {
+		msg = defaultMaintenanceMessage
+	}
+	return on, msg
+}
+
+// rejectInMaintenance отвечает 503, если включён режим обслуживания.
+// Используется в ручках, которые создают заказы и заявки; чтение не блокируется.
+func (h *Handler) rejectInMaintenance(w http.ResponseWriter) bool {
+	on, msg := h.maintenanceState()
+	if !on {
+		return false
+	}
+	w.Header().Set("Retry-After", "600")
+	writeError(w, ErrServiceUnavailable(msg).WithCode("maintenance"))
+	return true
+}
+
+// GET /api/maintenance — состояние для баннера в мини-аппе.
+func (h *Handler) handleMaintenanceStatus(w http.ResponseWriter, r *http.Request) {
+	on, msg := h.maintenanceState()
+	jsonOK(w, map[string]any{"enabled": on, "message": msg})
+}
+
+type setMaintenanceIn struct {
+	Enabled bool    `json:"enabled"`
+	Message *string `json:"message"` // nil — текст не меняется, "" — вернуть стандартный
+}
+
+// POST /api/admin/maintenance — включить/выключить режим обслуживания.
+func (h *Handler) handleAdminSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in setMaintenanceIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	if in.Message != nil && len([]rune(*in.Message)) > 1000 {
+		writeError(w, ErrBadRequest("message is too long"))
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	flag := "0"
+	if in.Enabled {
+		flag = "1"
+	}
+	err = upsertSetting(tx, settingMaintenance, flag)
+	if err == nil && in.Message != nil {
+		err = upsertSetting(tx, settingMaintenanceMessage, strings.TrimSpace(*in.Message))
+	}
+	if err == nil {
+		err = h.writeAudit(tx, h.auditActor(r), "maintenance.set", "", "", in)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Error("set maintenance", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	on, msg := h.maintenanceState()
+	h.logger.Info("maintenance mode changed", zap.Bool("enabled", on))
+	jsonOK(w, map[string]any{"status": "ok", "enabled": on, "message": msg})
+}
+
+func upsertSetting(ex execer, key, value string) error {
+	_, err := ex.Exec(`
+		INSERT INTO app_settings (key, value, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
+	`, key, value)
+	return err
+}
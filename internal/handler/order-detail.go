@@ -0,0 +1,104 @@
+// handler/order-detail.go
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// orderStatusChangeOut — смена статуса заказа из журнала действий админов.
+type orderStatusChangeOut struct {
+	From       string    `json:"from,omitempty"`
+	To         string    `json:"to"`
+	StatusText string    `json:"status_text"`
+	AdminID    int64     `json:"admin_id,omitempty"` // 0 — создан клиентом
+	At         time.Time `json:"at"`
+}
+
+// orderStatusHistory — история статусов заказа: создание и записи audit_log
+// о смене статуса (order.status) и решении по чеку (order.payment_paid /
+// order.payment_rejected). Отдельной таблицы истории нет — журнал её заменяет.
+func (h *Handler) orderStatusHistory(ctx context.Context, orderID int64, createdAt time.Time) ([]orderStatusChangeOut, error) {
+	out := []orderStatusChangeOut{{To: "new", StatusText: humanOrderStatus("new"), At: createdAt}}
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT admin_id, COALESCE(details, ''), created_at
+		FROM audit_log
+		WHERE target = ? AND action IN ('order.status', 'order.payment_paid', 'order.payment_rejected')
+		ORDER BY id
+	`, fmt.Sprint(orderID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			c       orderStatusChangeOut
+			details string
+		)
+		if err := rows.Scan(&c.AdminID, &details, &c.At); err != nil {
+			return nil, err
+		}
+		var d struct{ From, To string }
+		if err := json.Unmarshal([]byte(details), &d); err != nil || d.To == "" {
+			continue // запись без статусов — в истории не показываем
+		}
+		c.From, c.To, c.StatusText = d.From, d.To, humanOrderStatus(d.To)
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// orderPaymentProofOut — последний чек клиента по заказу (таблица payments).
+type orderPaymentProofOut struct {
+	PaymentID  int64     `json:"payment_id"`
+	FileID     string    `json:"file_id"`
+	Amount     int64     `json:"amount"`
+	ReceivedAt time.Time `json:"received_at"`
+	// сообщение с чеком в чате админа не сохраняется — ссылки на него нет;
+	// бот пришлёт чек ещё раз через resend_url
+	ResendURL string `json:"resend_url"`
+}
+
+// orderPaymentProof — последний присланный чек; nil — чека не было.
+func (h *Handler) orderPaymentProof(ctx context.Context, orderID int64) (*orderPaymentProofOut, error) {
+	var p orderPaymentProofOut
+	err := h.db.QueryRowContext(ctx, `
+		SELECT id, file_id, amount, created_at FROM payments
+		WHERE kind = ? AND target_id = ?
+		ORDER BY id DESC LIMIT 1
+	`, paymentKindOrder, orderID).Scan(&p.PaymentID, &p.FileID, &p.Amount, &p.ReceivedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.ResendURL = fmt.Sprintf("/api/admin/payments/resend?payment_id=%d", p.PaymentID)
+	return &p, nil
+}
+
+// orderStoreOut — точка заказа для карточки в админке.
+type orderStoreOut struct {
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// orderStore — точка заказа; nil — у заказа нет точки или она удалена.
+func (h *Handler) orderStore(ctx context.Context, code string) *orderStoreOut {
+	if code == "" {
+		return nil
+	}
+	s := orderStoreOut{Code: code}
+	err := h.db.QueryRowContext(ctx, `
+		SELECT COALESCE(name, ''), COALESCE(address_formatted, address, '') FROM stores WHERE code = ?
+	`, code).Scan(&s.Name, &s.Address)
+	if err != nil {
+		return nil
+	}
+	return &s
+}
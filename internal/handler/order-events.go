@@ -0,0 +1,158 @@
+// handler/order-events.go
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	orderEventsReplay    = 100              // сколько последних событий помнит кольцевой буфер
+	orderEventsBuffer    = 32               // очередь одного клиента; переполнилась — клиент отключается
+	orderEventsKeepAlive = 15 * time.Second // комментарий-пинг, чтобы прокси не рвали соединение
+)
+
+// orderEvent — событие ленты заказов для админки (SSE).
+type orderEvent struct {
+	ID             int64     `json:"id"`
+	Type           string    `json:"type"` // order.created | order.paid | order.status_changed
+	OrderID        int64     `json:"order_id"`
+	UserID         int64     `json:"user_id"`
+	StoreCode      string    `json:"store_code"`
+	Status         string    `json:"status"`
+	StatusText     string    `json:"status_text"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	TotalAmount    int64     `json:"total_amount"`
+	At             time.Time `json:"at"`
+}
+
+// orderBroker — pub/sub на каналах для ленты заказов.
+// publish никогда не блокируется: медленный клиент отключается, а не тормозит заказы.
+type orderBroker struct {
+	mu     sync.Mutex
+	lastID int64
+	ring   []orderEvent // последние orderEventsReplay событий, по возрастанию id
+	subs   map[chan orderEvent]struct{}
+}
+
+func newOrderBroker() *orderBroker {
+	return &orderBroker{subs: map[chan orderEvent]struct{}{}}
+}
+
+func (b *orderBroker) publish(ev orderEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	ev.ID = b.lastID
+	b.ring = append(b.ring, ev)
+	if len(b.ring) > orderEventsReplay {
+		b.ring = b.ring[len(b.ring)-orderEventsReplay:]
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe подписывает клиента и возвращает пропущенные после lastSeen события.
+// lastSeen из будущего (сервер перезапускался) — отдаём весь буфер.
+func (b *orderBroker) subscribe(lastSeen int64) (ch chan orderEvent, replay []orderEvent, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if lastSeen > 0 {
+		if lastSeen > b.lastID {
+			lastSeen = 0
+		}
+		for _, ev := range b.ring {
+			if ev.ID > lastSeen {
+				replay = append(replay, ev)
+			}
+		}
+	}
+	ch = make(chan orderEvent, orderEventsBuffer)
+	b.subs[ch] = struct{}{}
+	return ch, replay, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// GET /api/admin/orders/stream — лента заказов (Server-Sent Events).
+// EventSource не умеет заголовки, поэтому Telegram ID можно передать и в ?telegram_id=.
+// Переподключение с Last-Event-ID досылает пропущенные события из буфера.
+// Управляющий точки получает только события своих точек.
+func (h *Handler) handleAdminOrdersStream(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Telegram-Id") == "" {
+		r.Header.Set("X-Telegram-Id", r.URL.Query().Get("telegram_id"))
+	}
+	scope, ok := h.storeAccess(r)
+	if !ok {
+		writeError(w, ErrForbidden())
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, ErrInternal(fmt.Errorf("streaming is not supported")))
+		return
+	}
+
+	lastSeen, _ := strconv.ParseInt(strings.TrimSpace(r.Header.Get("Last-Event-ID")), 10, 64)
+	events, replay, cancel := h.orderEvents.subscribe(lastSeen)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx не должен буферизовать поток
+	fmt.Fprint(w, "retry: 3000\n\n")
+	for _, ev := range replay {
+		if scope.allows(ev.StoreCode) {
+			writeOrderEvent(w, ev)
+		}
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(orderEventsKeepAlive)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.ctx.Done():
+			return
+		case ev, open := <-events:
+			if !open {
+				h.logger.Warn("orders stream: slow client dropped", zap.String("remote", r.RemoteAddr))
+				return
+			}
+			if !scope.allows(ev.StoreCode) {
+				continue
+			}
+			writeOrderEvent(w, ev)
+			flusher.Flush()
+		case <-ping.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeOrderEvent(w http.ResponseWriter, ev orderEvent) {
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+}
@@ -0,0 +1,163 @@
+// handler/order-history.go
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	userOrdersDefaultLimit = 10
+	userOrdersMaxLimit     = 30
+)
+
+// itemLabel — название позиции с эмодзи товара: «🍅 Томаты».
+// У старых заказов эмодзи нет — только название, как раньше.
+func itemLabel(emoji, name string) string {
+	if emoji = strings.TrimSpace(emoji); emoji == "" {
+		return name
+	}
+	return emoji + " " + name
+}
+
+// snapshotOrderItems запоминает в позициях эмодзи и фото товара из каталога:
+// чек и история заказа не меняются, если товар потом переименуют или удалят.
+func snapshotOrderItems(items []orderItemIn, products map[int64]orderProduct) {
+	for i, it := range items {
+		if p, ok := products[it.ProductID]; ok {
+			items[i].Emoji, items[i].Photo = p.Emoji, p.Photo
+		}
+	}
+}
+
+type userOrderItemOut struct {
+	ProductID int64   `json:"product_id"`
+	Name      string  `json:"name"`
+	Emoji     string  `json:"emoji"`
+	Photo     string  `json:"photo"`
+	Unit      string  `json:"unit"`
+	Qty       float64 `json:"qty"`
+	Price     int64   `json:"price"`
+	Amount    int64   `json:"amount"`
+	// PriceAtOrder — цена в заказе (order_items.price, по ней считан чек);
+	// CurrentPrice — цена в каталоге сейчас, с учётом акции; nil — товара
+	// больше нет. PriceChanged — при повторе заказа цена будет другой.
+	PriceAtOrder int64  `json:"price_at_order"`
+	CurrentPrice *int64 `json:"current_price"`
+	PriceChanged bool   `json:"price_changed"`
+}
+
+type userOrderOut struct {
+	ID         int64              `json:"id"`
+	Status     string             `json:"status"`
+	StatusText string             `json:"status_text"`
+	Total      int64              `json:"total"`
+	GoodsTotal int64              `json:"goods_total"`
+	Delivery   int64              `json:"delivery_price"`
+	CreatedAt  time.Time          `json:"created_at"`
+	Items      []userOrderItemOut `json:"items"`
+}
+
+// handleUserOrders — история заказов покупателя для мини-аппа, новые сверху,
+// с позициями (эмодзи, фото и цена — снимок на момент заказа, рядом —
+// текущая цена каталога, чтобы повтор заказа не удивил):
+// GET /api/user/orders?telegram_id=…&limit=10 (или заголовок X-Telegram-Id).
+func (h *Handler) handleUserOrders(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(firstNonEmpty(r.URL.Query().Get("telegram_id"), r.Header.Get("X-Telegram-Id")), 10, 64)
+	if err != nil || userID <= 0 {
+		writeError(w, ErrBadRequest("telegram_id is required"))
+		return
+	}
+	limit := userOrdersDefaultLimit
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = min(v, userOrdersMaxLimit)
+	}
+
+	orders, err := h.orderRepo.ListByUser(r.Context(), userID, limit)
+	if err != nil {
+		h.logger.Error("select user orders", zap.Int64("user_id", userID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	out := make([]userOrderOut, 0, len(orders))
+	current := map[int64]*int64{}
+	subscriber := h.isSubscriber(r)
+	for _, o := range orders {
+		_, items, err := h.orderRepo.GetOrderWithItems(r.Context(), o.ID)
+		if err != nil {
+			h.logger.Error("select order items", zap.Int64("order_id", o.ID), zap.Error(err))
+			writeError(w, ErrInternal(err))
+			return
+		}
+		uo := userOrderOut{
+			ID: o.ID, Status: o.Status, StatusText: humanOrderStatus(o.Status),
+			Total: o.TotalAmount, GoodsTotal: o.GoodsTotal, Delivery: o.DeliveryPrice, CreatedAt: o.CreatedAt,
+			Items: make([]userOrderItemOut, 0, len(items)),
+		}
+		for _, it := range items {
+			item := userOrderItemOut{
+				ProductID: it.ProductID, Name: it.Name, Emoji: it.Emoji, Photo: it.Photo,
+				Unit: it.Unit, Qty: it.Qty, Price: it.Price, Amount: it.Amount, PriceAtOrder: it.Price,
+			}
+			if it.ProductID > 0 {
+				price, seen := current[it.ProductID]
+				if !seen {
+					if price, err = h.currentPrice(it.ProductID, subscriber); err != nil {
+						h.logger.Error("select current price", zap.Int64("product_id", it.ProductID), zap.Error(err))
+						writeError(w, ErrInternal(err))
+						return
+					}
+					current[it.ProductID] = price
+				}
+				item.CurrentPrice = price
+				item.PriceChanged = price != nil && *price != it.Price
+			}
+			uo.Items = append(uo.Items, item)
+		}
+		out = append(out, uo)
+	}
+	jsonOK(w, out)
+}
+
+// currentPrice — сколько товар стоит в заказе сейчас: базовая цена каталога
+// или цена действующей акции (как посчитает promoOrderPrices при оформлении).
+// Покупателю без подписки — цена, которую он видит в каталоге (guestPrices).
+// nil — товар удалён или скрыт, повторить его нельзя, либо цена только по подписке.
+func (h *Handler) currentPrice(productID int64, subscriber bool) (*int64, error) {
+	items := []orderItemIn{{ProductID: productID}}
+	products, err := h.loadOrderProducts(items)
+	if err != nil {
+		return nil, err
+	}
+	cur, ok := products[productID]
+	if !ok || !cur.Active {
+		return nil, nil
+	}
+	p := productOut{Price: cur.Price, SubscriberOnly: cur.SubscriberOnly}
+	items[0].Price = p.Price
+	if err := h.promoOrderPrices(items, products); err != nil {
+		return nil, err
+	}
+	if subscriber {
+		return &items[0].Price, nil
+	}
+
+	if cur.Retail.Valid {
+		p.RetailPrice = &cur.Retail.Int64
+	}
+	if items[0].Price != p.Price {
+		p.PromoPrice = &items[0].Price
+	}
+	p = guestPrices([]productOut{p})[0]
+	switch {
+	case p.SubscriberOnly:
+		return nil, nil
+	case p.PromoPrice != nil:
+		return p.PromoPrice, nil
+	}
+	return &p.Price, nil
+}
@@ -0,0 +1,130 @@
+// handler/order-limits.go
+package handler
+
+import (
+	"agro/internal/domain"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"go.uber.org/zap"
+)
+
+// orderReview — заказ больше мягких пределов (ORDER_REVIEW_*): ждёт, пока админ
+// подтвердит наличие. Реквизиты для оплаты покупатель получает после этого.
+// («checking» занят — это проверка присланного чека.)
+const orderReview = "review"
+
+// parseMaxQty читает max_qty из формы админки: пусто — общий предел
+// ORDER_MAX_ITEM_QTY (nil). set=false — поля в форме нет: при редактировании
+// товара текущее значение не трогаем.
+func parseMaxQty(r *http.Request) (maxQty *float64, set bool, err error) {
+	if _, set = r.Form["max_qty"]; !set {
+		return nil, false, nil
+	}
+	raw := strings.TrimSpace(r.FormValue("max_qty"))
+	if raw == "" {
+		return nil, true, nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || !(v > 0) || v > maxItemQty {
+		return nil, true, fmt.Errorf("max_qty must be > 0 and <= %d", maxItemQty)
+	}
+	return &v, true, nil
+}
+
+// formatQty — количество без лишних нулей: 50, 2.5.
+func formatQty(q float64) string {
+	return strconv.FormatFloat(q, 'f', -1, 64)
+}
+
+// orderLimitError — заказ больше жёсткого предела: limit — какого
+// (item_qty, items, total), max — его значение.
+func orderLimitError(msg, limit string, maxValue any) *AppError {
+	return ErrBadRequest(msg).
+		WithCode("order_limit_exceeded").
+		WithField("limit", limit).
+		WithField("max", maxValue)
+}
+
+// checkOrderLimits сверяет заказ с пределами из конфига (строка «Доставка»
+// не считается). Жёсткий предел превышен — appErr, заказ не принимаем. Мягкий —
+// review: причины проверки наличия для карточки админа; пусто — проверка не нужна.
+func (h *Handler) checkOrderLimits(items []orderItemIn, products map[int64]orderProduct, total int64) (review []string, appErr *AppError) {
+	cfg := h.cfg
+	seen := map[int64]bool{}
+	lines := 0
+	for _, it := range items {
+		if it.ProductID == 0 && it.Name == domain.DeliveryItemName {
+			continue
+		}
+		if it.ProductID == 0 || !seen[it.ProductID] {
+			lines++
+		}
+		seen[it.ProductID] = true
+
+		limit := cfg.OrderMaxItemQty
+		if own := products[it.ProductID].MaxQty; own.Valid && own.Float64 > 0 {
+			limit = own.Float64
+		}
+		if limit > 0 && it.Qty > limit {
+			return nil, orderLimitError(fmt.Sprintf("%s: no more than %s %s per order", it.Name, formatQty(limit), it.Unit), "item_qty", limit).
+				WithField("product_id", it.ProductID)
+		}
+		if cfg.OrderReviewItemQty > 0 && it.Qty > cfg.OrderReviewItemQty {
+			review = append(review, fmt.Sprintf("крупная позиция: %s — %s %s", itemLabel(it.Emoji, it.Name), formatQty(it.Qty), it.Unit))
+		}
+	}
+	if cfg.OrderMaxItems > 0 && lines > cfg.OrderMaxItems {
+		return nil, orderLimitError(fmt.Sprintf("too many items in order (max %d)", cfg.OrderMaxItems), "items", cfg.OrderMaxItems)
+	}
+	if cfg.OrderMaxTotal > 0 && total > cfg.OrderMaxTotal {
+		return nil, orderLimitError(fmt.Sprintf("order total must not exceed %d", cfg.OrderMaxTotal), "total", cfg.OrderMaxTotal)
+	}
+	if cfg.OrderReviewTotal > 0 && total > cfg.OrderReviewTotal {
+		review = append(review, fmt.Sprintf("сумма больше %s", formatMoney(cfg.OrderReviewTotal)))
+	}
+	return review, nil
+}
+
+// orderReviewNote — блок для карточки админа: почему заказ ждёт проверки.
+func orderReviewNote(review []string) string {
+	if len(review) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\n⚠️ Проверьте наличие перед оплатой:\n")
+	for _, r := range review {
+		fmt.Fprintf(&b, "• %s\n", r)
+	}
+	b.WriteString("Реквизиты для оплаты клиент получит после «✅ Подтвердить наличие».")
+	return b.String()
+}
+
+// notifyOrderReview сообщает покупателю, что заказ ждёт проверки наличия.
+func (h *Handler) notifyOrderReview(ctx context.Context, userID, orderID int64) {
+	text := h.orderStatusText(orderReview, orderID, fmt.Sprintf(
+		"🕵️ Заказ №%d принят. Это большой заказ — администратор проверит наличие и пришлёт реквизиты для оплаты.", orderID))
+	h.sendOrQueueQuiet(ctx, &bot.SendMessageParams{ChatID: userID, Text: text}, "order review notice")
+}
+
+// sendPaymentInstructions — админ подтвердил наличие (review → new): покупатель
+// получает то, что обычный заказ получает сразу, — чек с реквизитами или выбор
+// способа оплаты (заказ из /api/orders/create).
+func (h *Handler) sendPaymentInstructions(ctx context.Context, userID, orderID int64) {
+	order, err := h.orderRepo.Get(ctx, orderID)
+	if err != nil {
+		h.logger.Warn("select order for payment instructions", zap.Int64("order_id", orderID), zap.Error(err))
+		return
+	}
+	if order.PaymentMethod == paymentPending {
+		h.askPaymentMethod(ctx, userID, orderID)
+		return
+	}
+	if err := h.sendOrderReceiptToUser(orderID); err != nil {
+		h.logger.Warn("send receipt after review", zap.Int64("order_id", orderID), zap.Error(err))
+	}
+}
@@ -0,0 +1,182 @@
+// handler/order-navigation.go
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+// geoPoint — координаты для кнопок навигации и SendLocation.
+type geoPoint struct {
+	Lat, Lng float64
+}
+
+// validCoords отсекает «пустые» (0,0), NaN и координаты вне диапазона.
+func validCoords(lat, lng float64) bool {
+	if math.IsNaN(lat) || math.IsNaN(lng) || (lat == 0 && lng == 0) {
+		return false
+	}
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+// navigationButtons — маршрут до точки в Яндекс.Картах и 2ГИС.
+func navigationButtons(p geoPoint) []models.InlineKeyboardButton {
+	return []models.InlineKeyboardButton{
+		{Text: "🗺 Яндекс.Карты", URL: fmt.Sprintf("https://yandex.kz/maps/?rtext=~%.6f,%.6f&rtt=auto", p.Lat, p.Lng)},
+		{Text: "🧭 2ГИС", URL: fmt.Sprintf("https://2gis.kz/directions/points/%%7C%.6f,%.6f", p.Lng, p.Lat)},
+	}
+}
+
+// addressSearchButtons — поиск адреса текстом, если координат нет совсем.
+func addressSearchButtons(addr string) []models.InlineKeyboardButton {
+	return []models.InlineKeyboardButton{
+		{Text: "🗺 Яндекс.Карты", URL: "https://yandex.kz/maps/?text=" + url.QueryEscape(addr)},
+		{Text: "🧭 2ГИС", URL: "https://2gis.kz/search/" + url.PathEscape(addr)},
+	}
+}
+
+// orderDestination — куда ехать по заказу: для доставки — точка клиента
+// (или геокодированный адрес), для самовывоза — координаты точки продаж.
+// Если координат нет, возвращается только адрес для текстового поиска.
+func (h *Handler) orderDestination(storeCode string, d deliveryIn) (*geoPoint, string) {
+	if strings.EqualFold(d.Type, "delivery") {
+		if validCoords(d.Lat, d.Lng) {
+			return &geoPoint{Lat: d.Lat, Lng: d.Lng}, d.Address
+		}
+		addr := strings.TrimSpace(d.Address)
+		if addr == "" {
+			return nil, ""
+		}
+		if lng, lat, _, err := h.geocodeAddress(addr); err == nil && validCoords(lat, lng) {
+			return &geoPoint{Lat: lat, Lng: lng}, addr
+		}
+		return nil, addr
+	}
+
+	if storeCode == "" {
+		return nil, ""
+	}
+	var lat, lng sql.NullFloat64
+	var addr sql.NullString
+	_ = h.db.QueryRow(`SELECT latitude, longitude, address FROM stores WHERE code = ?`, storeCode).Scan(&lat, &lng, &addr)
+	if lat.Valid && lng.Valid && validCoords(lat.Float64, lng.Float64) {
+		return &geoPoint{Lat: lat.Float64, Lng: lng.Float64}, addr.String
+	}
+	return nil, strings.TrimSpace(addr.String)
+}
+
+// notifyAdminOrder — уведомление о новом заказе: кнопки статусов, навигация
+// и сразу следом — точка на карте (SendLocation). Владельцу идёт через очередь
+// уведомлений: несколько заказов подряд склеиваются в одну сводку.
+// Управляющие точки заказа (store_managers) получают карточку сразу; владелец
+// при этом получает копию, если не включён ORDER_ADMIN_FALLBACK_ONLY.
+// Нет управляющих — заказ, как и раньше, только у владельца.
+func (h *Handler) notifyAdminOrder(text string, orderID int64, storeCode string, d deliveryIn) {
+	staff := h.storeStaff(storeCode)
+	for _, chatID := range staff {
+		if err := h.sendOrderNotice(h.ctx, chatID, text, orderID, storeCode, d); err != nil {
+			h.logger.Warn("send order to store manager",
+				zap.Int64("order_id", orderID), zap.Int64("manager", chatID), zap.Error(err))
+		}
+	}
+	if len(staff) > 0 && h.cfg.OrderAdminFallbackOnly {
+		return
+	}
+	h.enqueueAdmin(adminNotice{
+		text:    text,
+		orderID: orderID,
+		send: func(ctx context.Context) error {
+			return h.sendAdminOrder(ctx, text, orderID, storeCode, d)
+		},
+	})
+}
+
+func (h *Handler) sendAdminOrder(ctx context.Context, text string, orderID int64, storeCode string, d deliveryIn) error {
+	return h.sendOrderNotice(ctx, h.cfg.AdminID, text, orderID, storeCode, d)
+}
+
+// storeStaff — Telegram ID управляющих точки, кроме самого владельца.
+// Ошибку только логируем: заказ тогда уйдёт владельцу.
+func (h *Handler) storeStaff(storeCode string) []int64 {
+	if storeCode == "" {
+		return nil
+	}
+	rows, err := h.db.Query(`
+		SELECT telegram_id FROM store_managers WHERE store_code = ? AND telegram_id != ? ORDER BY telegram_id
+	`, storeCode, h.cfg.AdminID)
+	if err != nil {
+		h.logger.Error("select store staff", zap.String("store", storeCode), zap.Error(err))
+		return nil
+	}
+	defer rows.Close()
+	var out []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			h.logger.Error("scan store staff", zap.Error(err))
+			return nil
+		}
+		out = append(out, id)
+	}
+	return out
+}
+
+// sendOrderNotice — карточка нового заказа в чат chatID. Пока бот не подключён,
+// карточка ждёт в outbox, а точку на карте не отправляем.
+func (h *Handler) sendOrderNotice(ctx context.Context, chatID int64, text string, orderID int64, storeCode string, d deliveryIn) error {
+	point, addr := h.orderDestination(storeCode, d)
+
+	// новый заказ — «new», крупный — «review» (кнопка подтверждения наличия)
+	status := "new"
+	_ = h.db.QueryRowContext(ctx, `SELECT status FROM orders WHERE id = ?`, orderID).Scan(&status)
+	kb := orderActionsMarkup(orderID, status)
+	switch {
+	case point != nil:
+		kb.InlineKeyboard = append(kb.InlineKeyboard, navigationButtons(*point))
+	case addr != "":
+		kb.InlineKeyboard = append(kb.InlineKeyboard, addressSearchButtons(addr))
+	}
+
+	queued, err := h.sendOrQueue(ctx, &bot.SendMessageParams{
+		ChatID:      chatID,
+		Text:        text,
+		ReplyMarkup: kb,
+	})
+	if err != nil {
+		return err
+	}
+	if point == nil || queued {
+		return nil
+	}
+	if _, err := h.sender.SendLocation(ctx, &bot.SendLocationParams{
+		ChatID:    chatID,
+		Latitude:  point.Lat,
+		Longitude: point.Lng,
+	}); err != nil {
+		// само уведомление ушло — точку на карте не переотправляем
+		h.logger.Warn("send order location", zap.Int64("order_id", orderID), zap.Error(err))
+	}
+	return nil
+}
+
+// urlRows — ряды с URL-кнопками (навигация), которые надо сохранить при смене клавиатуры.
+func urlRows(kb *models.InlineKeyboardMarkup) [][]models.InlineKeyboardButton {
+	if kb == nil {
+		return nil
+	}
+	var out [][]models.InlineKeyboardButton
+	for _, row := range kb.InlineKeyboard {
+		if len(row) > 0 && row[0].URL != "" {
+			out = append(out, row)
+		}
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+// handler/order-products.go
+package handler
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// orderProduct — то, что оформлению заказа нужно знать о товаре из каталога:
+// цены, акция (по категории), точка, единица цены, лимит и снимок для чека.
+type orderProduct struct {
+	Active         bool
+	Price          int64
+	Retail         sql.NullInt64
+	SubscriberOnly bool
+	Category       string
+	StoreCode      string // "" — общий товар
+	PricePer       string
+	MaxQty         sql.NullFloat64
+	Emoji          string
+	Photo          string
+}
+
+// loadOrderProducts читает товары позиций заказа одним запросом: product_id → товар.
+// Служебных строк (без product_id) и удалённых из каталога товаров в ответе нет.
+func (h *Handler) loadOrderProducts(items []orderItemIn) (map[int64]orderProduct, error) {
+	var ids []any
+	for _, it := range items {
+		if it.ProductID > 0 {
+			ids = append(ids, it.ProductID)
+		}
+	}
+	products := make(map[int64]orderProduct, len(ids))
+	if len(ids) == 0 {
+		return products, nil
+	}
+
+	rows, err := h.db.Query(`
+		SELECT id, active, price, retail_price, subscriber_only, category_slug,
+		       COALESCE(store_code, ''), COALESCE(price_per, ''), max_qty,
+		       COALESCE(emoji, ''), COALESCE(photo_path, '')
+		FROM products
+		WHERE id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)
+	`, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id int64
+			p  orderProduct
+		)
+		if err := rows.Scan(&id, &p.Active, &p.Price, &p.Retail, &p.SubscriberOnly, &p.Category,
+			&p.StoreCode, &p.PricePer, &p.MaxQty, &p.Emoji, &p.Photo); err != nil {
+			return nil, err
+		}
+		products[id] = p
+	}
+	return products, rows.Err()
+}
+
+// priceOrderItems ставит позициям заказа цены и единицу цены из каталога:
+// базовую (orderBasePrices), затем акцию (promoOrderPrices) и price_per.
+// Возвращает прочитанные товары — их используют остальные проверки заказа.
+func (h *Handler) priceOrderItems(items []orderItemIn, subscriber bool) (map[int64]orderProduct, *AppError, error) {
+	products, err := h.loadOrderProducts(items)
+	if err != nil {
+		return nil, nil, err
+	}
+	if appErr := orderBasePrices(items, products, subscriber); appErr != nil {
+		return nil, appErr, nil
+	}
+	if err := h.promoOrderPrices(items, products); err != nil {
+		return nil, nil, err
+	}
+	catalogPricePer(items, products)
+	return products, nil, nil
+}
@@ -0,0 +1,115 @@
+// handler/order-receipt.go
+package handler
+
+import (
+	"agro/internal/domain"
+	"agro/internal/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// receiptResendInterval — не чаще одного повторного чека по заказу за это время.
+const receiptResendInterval = time.Minute
+
+// loadOrderItems — позиции заказа в порядке добавления, включая служебную
+// строку «Доставка» (product_id = 0).
+func (h *Handler) loadOrderItems(orderID int64) ([]orderItemIn, error) {
+	order, err := h.orderRepo.Get(h.ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	return orderItemsIn(order.Items), nil
+}
+
+// orderItemsIn — позиции из БД в виде позиций запроса (для текстов чека).
+func orderItemsIn(items []domain.OrderItem) []orderItemIn {
+	out := make([]orderItemIn, 0, len(items))
+	for _, it := range items {
+		out = append(out, orderItemIn{
+			ProductID:         it.ProductID,
+			Name:              it.Name,
+			Qty:               it.Qty,
+			Unit:              it.Unit,
+			Price:             it.Price,
+			Note:              it.Note,
+			AllowSubstitution: it.AllowSubstitution,
+			Emoji:             it.Emoji,
+			Photo:             it.Photo,
+			PricePer:          it.PricePer,
+		})
+	}
+	return out
+}
+
+// writeOrderTotals — итог заказа одинаково в чеке и уведомлениях админу:
+// товары и доставка отдельными строками (если доставка есть), затем сумма.
+func writeOrderTotals(b *strings.Builder, goods, delivery int64, totalLabel string) {
+	if delivery > 0 {
+		fmt.Fprintf(b, "🧺 Товары: %s\n🚚 Доставка: %s\n", formatMoney(goods), formatMoney(delivery))
+	}
+	fmt.Fprintf(b, "💰 %s: %s", totalLabel, formatMoney(goods+delivery))
+}
+
+type resendReceiptIn struct {
+	TelegramID json.RawMessage `json:"telegram_id"`
+	OrderID    int64           `json:"order_id"`
+}
+
+// POST /api/orders/resend-receipt — повторно прислать чек с кнопкой Kaspi
+// (пользователь удалил сообщение или очистил историю). Только свой заказ,
+// не чаще раза в receiptResendInterval.
+func (h *Handler) handleResendReceipt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, ErrMethodNotAllowed())
+		return
+	}
+	var in resendReceiptIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	tgID, err := parseTelegramID(in.TelegramID)
+	if err != nil || tgID <= 0 || in.OrderID <= 0 {
+		writeError(w, ErrBadRequest("telegram_id and order_id are required"))
+		return
+	}
+
+	order, _, err := h.orderRepo.GetOrderWithItems(r.Context(), in.OrderID)
+	switch {
+	case errors.Is(err, repository.ErrOrderNotFound) || (err == nil && order.UserID != tgID):
+		// чужой заказ неотличим от несуществующего
+		writeError(w, ErrNotFound("order"))
+		return
+	case err != nil:
+		h.logger.Error("select order", zap.Int64("order_id", in.OrderID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	if h.redisClient != nil {
+		key := fmt.Sprintf("receipt:resend:%d", in.OrderID)
+		allowed, left, err := h.redisClient.HitOnce(r.Context(), key, receiptResendInterval)
+		if err != nil {
+			h.logger.Warn("receipt resend limiter", zap.Error(err))
+		} else if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(left.Seconds()))))
+			writeError(w, ErrTooManyRequests("receipt was sent recently"))
+			return
+		}
+	}
+
+	if err := h.sendOrderReceiptToUser(in.OrderID); err != nil {
+		h.logger.Warn("resend receipt", zap.Int64("order_id", in.OrderID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,137 @@
+// handler/order-split.go
+package handler
+
+import (
+	"agro/internal/domain"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// storeItems — позиции заказа, которые собирает одна точка.
+type storeItems struct {
+	StoreCode string
+	Items     []orderItemIn
+}
+
+// subOrderOut — часть заказа из нескольких точек в ответе /api/orders/confirm.
+type subOrderOut struct {
+	OrderID    int64  `json:"order_id"`
+	StoreCode  string `json:"store_code"`
+	GoodsTotal int64  `json:"goods_total"`
+	Total      int64  `json:"total"`
+}
+
+// splitItemsByStore раскладывает позиции по точкам: товар точки — в её часть,
+// общие товары (без store_code), удалённые из каталога и строка «Доставка» —
+// в часть выбранной точки selected. Выбранная точка — первой, остальные — в
+// порядке появления в корзине. Одна группа — делить нечего.
+func splitItemsByStore(selected string, items []orderItemIn, stores map[int64]string) []storeItems {
+	groups := []storeItems{{StoreCode: selected}}
+	index := map[string]int{selected: 0}
+	for _, it := range items {
+		code := stores[it.ProductID]
+		if code == "" {
+			code = selected
+		}
+		i, ok := index[code]
+		if !ok {
+			i = len(groups)
+			index[code] = i
+			groups = append(groups, storeItems{StoreCode: code})
+		}
+		groups[i].Items = append(groups[i].Items, it)
+	}
+	// у выбранной точки могло не оказаться позиций
+	out := groups[:0]
+	for _, g := range groups {
+		if len(g.Items) > 0 {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// subOrders — части заказа для OrderRepository.CreateSplit: позиции своей
+// точки, суммы — по этим позициям. Доставка — в той части, где строка «Доставка».
+func subOrders(parent *domain.Order, groups []storeItems) []*domain.Order {
+	children := make([]*domain.Order, 0, len(groups))
+	for _, g := range groups {
+		var total int64
+		for _, it := range g.Items {
+			total += lineAmount(it)
+		}
+		child := newOrder(fmt.Sprint(parent.UserID), g.StoreCode, parent.PaymentMethod, total, g.Items)
+		child.Status = parent.Status
+		children = append(children, child)
+	}
+	return children
+}
+
+// subOrdersOut — части заказа для ответа клиенту.
+func subOrdersOut(children []*domain.Order) []subOrderOut {
+	out := make([]subOrderOut, 0, len(children))
+	for _, c := range children {
+		out = append(out, subOrderOut{OrderID: c.ID, StoreCode: c.StoreCode, GoodsTotal: c.GoodsTotal, Total: c.TotalAmount})
+	}
+	return out
+}
+
+// storeName — название точки по коду; неизвестная точка — её код.
+func (h *Handler) storeName(code string) string {
+	var name string
+	if err := h.db.QueryRow(`SELECT name FROM stores WHERE code = ?`, code).Scan(&name); err != nil || name == "" {
+		return code
+	}
+	return name
+}
+
+// writeSubOrders дописывает в чек клиенту, какие точки собирают заказ.
+// Обычный заказ (без частей) — ничего не пишет.
+func (h *Handler) writeSubOrders(b *strings.Builder, orderID int64) {
+	children, err := h.orderRepo.Children(h.ctx, orderID)
+	if err != nil {
+		h.logger.Warn("select sub-orders for receipt", zap.Int64("order_id", orderID), zap.Error(err))
+		return
+	}
+	if len(children) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "📦 Заказ соберут %d %s:\n", len(children), ruPlural(len(children), "точка", "точки", "точек"))
+	for _, c := range children {
+		fmt.Fprintf(b, "• №%d — %s: %s\n", c.ID, h.storeName(c.StoreCode), formatMoney(c.TotalAmount))
+	}
+	fmt.Fprintf(b, "Оплата одна — по заказу №%d.\n", orderID)
+}
+
+// cascadeOrderPayment — в транзакции решения по оплате общего заказа
+// переносит это решение на его части, которые ещё ждут оплаты: точки
+// начинают сборку только оплаченных частей.
+func cascadeOrderPayment(ctx context.Context, tx *sql.Tx, parentID int64, to string, d paymentDecider) error {
+	args := []any{to, nullInt(d.ID), nullString(d.Name), parentID}
+	for _, s := range payableOrderStatuses {
+		args = append(args, s)
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE orders
+		SET status = ?, payment_decided_by = ?, payment_decided_by_name = ?,
+		    payment_decided_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE parent_order_id = ? AND status IN (?`+strings.Repeat(", ?", len(payableOrderStatuses)-1)+`)
+	`, args...)
+	return err
+}
+
+// emitSubOrderEvents — события вебхуков для частей заказа после решения по оплате.
+func (h *Handler) emitSubOrderEvents(ctx context.Context, parentID int64, event, prev string) {
+	children, err := h.orderRepo.Children(ctx, parentID)
+	if err != nil {
+		h.logger.Warn("select sub-orders", zap.Int64("order_id", parentID), zap.Error(err))
+		return
+	}
+	for _, c := range children {
+		h.emitOrderEvent(event, c.ID, prev)
+	}
+}
@@ -0,0 +1,511 @@
+// handler/order-status-handler.go
+package handler
+
+import (
+	"agro/internal/repository"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+// Статусы выполнения заказа, которые выставляет администратор.
+const (
+	orderPreparing  = "preparing"
+	orderDelivering = "delivering"
+	orderDone       = "done"
+	orderCancelled  = "cancelled"
+)
+
+// orderTransitions — куда можно перевести заказ из текущего статуса.
+// done / cancelled / rejected — конечные: устаревшая кнопка их не откатит.
+// review → new — админ подтвердил наличие, заказ идёт дальше как обычный.
+var orderTransitions = map[string][]string{
+	orderReview:     {"new", orderCancelled},
+	"new":           {orderPreparing, orderDelivering, orderDone, orderCancelled},
+	"checking":      {orderPreparing, orderDelivering, orderDone, orderCancelled},
+	"invoiced":      {orderPreparing, orderDelivering, orderDone, orderCancelled},
+	"paid":          {orderPreparing, orderDelivering, orderDone, orderCancelled},
+	orderPreparing:  {orderDelivering, orderDone, orderCancelled},
+	orderDelivering: {orderDone, orderCancelled},
+}
+
+// кнопки быстрых действий в уведомлении админу, в порядке показа
+var orderActionButtons = []struct{ status, text string }{
+	{"new", "✅ Подтвердить наличие"},
+	{orderPreparing, "📦 Собирается"},
+	{orderDelivering, "🚚 Передан курьеру"},
+	{orderDone, "✅ Выполнен"},
+	{orderCancelled, "❌ Отменить"},
+}
+
+// errOrderTransition — переход из текущего статуса запрещён (или заказа нет).
+var errOrderTransition = errors.New("status transition not allowed")
+
+// orderStatusMarker отделяет исходный текст уведомления от строки статуса.
+const orderStatusMarker = "\n\n📌 Статус: "
+
+func canTransition(from, to string) bool {
+	for _, s := range orderTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// humanOrderStatus — статус заказа по-русски.
+func humanOrderStatus(status string) string {
+	switch status {
+	case orderReview:
+		return "проверка наличия"
+	case "new":
+		return "новый"
+	case "checking":
+		return "проверка оплаты"
+	case "invoiced":
+		return "выставлен счёт"
+	case "paid":
+		return "оплачен"
+	case orderPreparing:
+		return "собирается"
+	case orderDelivering:
+		return "передан курьеру"
+	case orderDone:
+		return "выполнен"
+	case orderCancelled:
+		return "отменён"
+	case "rejected":
+		return "оплата отклонена"
+	default:
+		return status
+	}
+}
+
+// orderActionsMarkup — клавиатура с переходами, доступными из status;
+// для конечного статуса — пустая (кнопки убираются).
+func orderActionsMarkup(orderID int64, status string) *models.InlineKeyboardMarkup {
+	var row []models.InlineKeyboardButton
+	for _, b := range orderActionButtons {
+		if canTransition(status, b.status) {
+			row = append(row, models.InlineKeyboardButton{
+				Text:         b.text,
+				CallbackData: fmt.Sprintf("ord_%s:%d", b.status, orderID),
+			})
+		}
+	}
+	kb := &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{}}
+	// по две кнопки в ряд, чтобы текст не обрезался
+	for i := 0; i < len(row); i += 2 {
+		kb.InlineKeyboard = append(kb.InlineKeyboard, row[i:min(i+2, len(row))])
+	}
+	return kb
+}
+
+// setOrderStatus переводит заказ в статус to, если переход разрешён.
+// UPDATE сравнивает прежний статус, поэтому два одновременных нажатия
+// не перезапишут друг друга. Возвращает Telegram ID покупателя и прежний статус.
+func (h *Handler) setOrderStatus(orderID int64, to string) (userID int64, from string, err error) {
+	order, _, err := h.orderRepo.GetOrderWithItems(h.ctx, orderID)
+	if errors.Is(err, repository.ErrOrderNotFound) {
+		return 0, "", errOrderTransition
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	userID, from = order.UserID, order.Status
+	if !canTransition(from, to) {
+		return userID, from, errOrderTransition
+	}
+	ok, err := h.orderRepo.UpdateStatus(h.ctx, orderID, from, to)
+	if err != nil {
+		return 0, "", err
+	}
+	if !ok {
+		return userID, from, errOrderTransition
+	}
+	h.emitOrderEvent(webhookOrderStatusChanged, orderID, from)
+	return userID, from, nil
+}
+
+// notifyOrderStatus сообщает покупателю новый статус заказа.
+func (h *Handler) notifyOrderStatus(ctx context.Context, userID, orderID int64, status string) {
+	if userID == 0 {
+		return
+	}
+	var (
+		text   string
+		markup models.ReplyMarkup
+	)
+	switch status {
+	case "new":
+		// наличие подтверждено — теперь реквизиты для оплаты
+		h.sendPaymentInstructions(ctx, userID, orderID)
+		return
+	case orderPreparing:
+		text = fmt.Sprintf("📦 Заказ №%d собирается.", orderID)
+	case orderDelivering:
+		text = fmt.Sprintf("🚚 Заказ №%d передан курьеру.", orderID)
+	case orderDone:
+		text = fmt.Sprintf("✅ Заказ №%d выполнен. Спасибо за покупку!\nОцените, пожалуйста, заказ:", orderID)
+		markup = feedbackMarkup(orderID)
+	case orderCancelled:
+		text = fmt.Sprintf("❌ Заказ №%d отменён. Если это ошибка — напишите администратору.", orderID)
+	default:
+		return
+	}
+	// текст из order_status_messages (настраивается в админке), встроенный — запасной
+	text = h.orderStatusText(status, orderID, text)
+	if _, err := h.sendOrQueue(ctx, &bot.SendMessageParams{ChatID: userID, Text: text, ReplyMarkup: markup}); err != nil {
+		h.logger.Warn("send order status to user", zap.Int64("order_id", orderID), zap.Error(err))
+	}
+}
+
+// OrderStatusCallbackHandler — кнопки под уведомлением о новом заказе (ord_<status>:<orderID>).
+// Регистрация:
+// bot.WithCallbackQueryDataHandler("ord_", bot.MatchTypePrefix, handl.OrderStatusCallbackHandler),
+func (h *Handler) OrderStatusCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	cq := update.CallbackQuery
+	if cq == nil {
+		return
+	}
+	answer := func(text string, alert bool) {
+		_, _ = h.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: cq.ID,
+			Text:            text,
+			ShowAlert:       alert,
+		})
+	}
+	status, idStr, ok := strings.Cut(strings.TrimPrefix(cq.Data, "ord_"), ":")
+	orderID, err := strconv.ParseInt(idStr, 10, 64)
+	if !ok || err != nil || orderID <= 0 {
+		return
+	}
+	if !h.canManageOrder(cq.From.ID, orderID) {
+		answer("Недостаточно прав", true)
+		return
+	}
+	if status == "show" { // кнопка «📋 №N» из сводки нескольких заказов
+		if err := h.sendOrderCard(ctx, orderID); err != nil {
+			h.logger.Warn("send order card", zap.Int64("order_id", orderID), zap.Error(err))
+			answer("Заказ не найден", true)
+			return
+		}
+		answer("", false)
+		return
+	}
+
+	userID, from, err := h.setOrderStatus(orderID, status)
+	current := status
+	switch {
+	case errors.Is(err, errOrderTransition):
+		current = from
+		answer(fmt.Sprintf("Заказ №%d уже в статусе «%s»", orderID, humanOrderStatus(from)), true)
+	case err != nil:
+		h.logger.Error("set order status", zap.Int64("order_id", orderID), zap.Error(err))
+		answer("Не удалось изменить статус, попробуйте ещё раз", true)
+		return
+	default:
+		answer(fmt.Sprintf("Статус: %s", humanOrderStatus(status)), false)
+		h.auditQuiet(cq.From.ID, "order.status", fmt.Sprint(orderID), map[string]any{"from": from, "to": status})
+		h.notifyOrderStatus(ctx, userID, orderID, status)
+	}
+
+	// обновляем само уведомление: строка статуса + оставшиеся кнопки
+	if msg := cq.Message.Message; msg != nil && current != "" {
+		text, _, _ := strings.Cut(msg.Text, orderStatusMarker)
+		kb := orderActionsMarkup(orderID, current)
+		kb.InlineKeyboard = append(kb.InlineKeyboard, urlRows(msg.ReplyMarkup)...) // кнопки навигации остаются
+		_, err := h.sender.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:      msg.Chat.ID,
+			MessageID:   msg.ID,
+			Text:        text + orderStatusMarker + humanOrderStatus(current),
+			ReplyMarkup: kb,
+		})
+		if err != nil {
+			h.logger.Warn("edit order notification", zap.Int64("order_id", orderID), zap.Error(err))
+		}
+	}
+}
+
+type setOrderStatusIn struct {
+	OrderID int64  `json:"order_id"`
+	Status  string `json:"status"`
+}
+
+// POST /api/admin/orders/status — сменить статус заказа (те же переходы, что и у кнопок).
+func (h *Handler) handleAdminSetOrderStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, ErrMethodNotAllowed())
+		return
+	}
+	scope, ok := h.storeAccess(r)
+	if !ok {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in setOrderStatusIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	if in.OrderID <= 0 || in.Status == "" {
+		writeError(w, ErrBadRequest("order_id and status are required"))
+		return
+	}
+	if !scope.root {
+		// управляющий меняет статус только заказов своей точки
+		var store sql.NullString
+		_ = h.db.QueryRow(`SELECT store_code FROM orders WHERE id = ?`, in.OrderID).Scan(&store)
+		if !scope.allows(store.String) {
+			writeError(w, ErrNotFound("order"))
+			return
+		}
+	}
+
+	userID, from, err := h.setOrderStatus(in.OrderID, in.Status)
+	if errors.Is(err, errOrderTransition) {
+		if from == "" {
+			writeError(w, ErrNotFound("order"))
+			return
+		}
+		writeError(w, ErrConflict(fmt.Sprintf("cannot change status from %s to %s", from, in.Status)))
+		return
+	}
+	if err != nil {
+		h.logger.Error("set order status", zap.Int64("order_id", in.OrderID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	h.auditQuiet(h.auditActor(r), "order.status", fmt.Sprint(in.OrderID), map[string]any{"from": from, "to": in.Status})
+	h.notifyOrderStatus(r.Context(), userID, in.OrderID, in.Status)
+	jsonOK(w, map[string]any{"status": "ok", "order_id": in.OrderID, "order_status": in.Status})
+}
+
+type adminOrderItemOut struct {
+	ProductID         int64   `json:"product_id"`
+	Name              string  `json:"name"`
+	Unit              string  `json:"unit"`
+	Qty               float64 `json:"qty"`
+	Price             int64   `json:"price"`
+	Amount            int64   `json:"amount"`
+	Note              string  `json:"note"`
+	AllowSubstitution bool    `json:"allow_substitution"`
+	Emoji             string  `json:"emoji"`
+	Photo             string  `json:"photo"`
+	PricePer          string  `json:"price_per"`
+}
+
+// GET /api/admin/orders/{id} (и /api/admin/orders/detail?id=42) — карточка
+// заказа: позиции с пожеланиями и разрешением на замену, точка, оплата и чек,
+// доставка, история статусов и части заказа из нескольких точек.
+func (h *Handler) handleAdminGetOrder(w http.ResponseWriter, r *http.Request) {
+	scope, ok := h.storeAccess(r)
+	if !ok {
+		writeError(w, ErrForbidden())
+		return
+	}
+	idStr := r.PathValue("id")
+	if idStr == "" {
+		idStr = r.URL.Query().Get("id")
+	}
+	orderID, err := strconv.ParseInt(strings.TrimSpace(idStr), 10, 64)
+	if err != nil || orderID <= 0 {
+		writeError(w, ErrBadRequest("bad order id"))
+		return
+	}
+	ctx := r.Context()
+	order, items, err := h.orderRepo.GetOrderWithItems(ctx, orderID)
+	if errors.Is(err, repository.ErrOrderNotFound) || (err == nil && !scope.allows(order.StoreCode)) {
+		writeError(w, ErrNotFound("order"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("get order", zap.Int64("order_id", orderID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	out := make([]adminOrderItemOut, 0, len(items))
+	for _, it := range items {
+		out = append(out, adminOrderItemOut(it))
+	}
+	var (
+		decidedBy   sql.NullInt64
+		decidedName sql.NullString
+		decidedAt   sql.NullTime
+	)
+	if err := h.db.QueryRowContext(ctx, `
+		SELECT payment_decided_by, payment_decided_by_name, payment_decided_at FROM orders WHERE id = ?
+	`, orderID).Scan(&decidedBy, &decidedName, &decidedAt); err != nil {
+		h.logger.Warn("select order payment decision", zap.Int64("order_id", orderID), zap.Error(err))
+	}
+	history, err := h.orderStatusHistory(ctx, orderID, order.CreatedAt)
+	if err != nil {
+		h.logger.Error("select order status history", zap.Int64("order_id", orderID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	proof, err := h.orderPaymentProof(ctx, orderID)
+	if err != nil {
+		h.logger.Error("select order payment proof", zap.Int64("order_id", orderID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	children, err := h.orderRepo.Children(ctx, orderID)
+	if err != nil {
+		h.logger.Error("select sub-orders", zap.Int64("order_id", orderID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	subOrders := make([]subOrderOut, 0, len(children))
+	for _, c := range children {
+		subOrders = append(subOrders, subOrderOut{OrderID: c.ID, StoreCode: c.StoreCode, GoodsTotal: c.GoodsTotal, Total: c.TotalAmount})
+	}
+	// адрес клиента в заказе не хранится — только способ получения
+	delivery := map[string]any{"type": "pickup", "price": order.DeliveryPrice}
+	if order.DeliveryPrice > 0 {
+		delivery["type"] = "delivery"
+	}
+
+	jsonOK(w, map[string]any{
+		"id":                  order.ID,
+		"user_id":             order.UserID,
+		"store_code":          order.StoreCode,
+		"store":               h.orderStore(ctx, order.StoreCode),
+		"status":              order.Status,
+		"status_text":         humanOrderStatus(order.Status),
+		"total_amount":        order.TotalAmount,
+		"goods_total":         order.GoodsTotal,
+		"delivery_price":      order.DeliveryPrice,
+		"delivery":            delivery,
+		"payment_method":      order.PaymentMethod,
+		"payment_method_text": humanPaymentMethod(order.PaymentMethod),
+		"payment_proof":       proof,
+		"created_at":          order.CreatedAt,
+		"payment_decision":    newPaymentDecision(decidedBy, decidedName, decidedAt),
+		"status_history":      history,
+		"parent_order_id":     order.ParentOrderID,
+		"sub_orders":          subOrders,
+		"items":               out,
+	})
+}
+
+const (
+	adminOrdersDefaultLimit = 50
+	adminOrdersMaxLimit     = 200
+)
+
+// handleAdminListOrders — заказы для админки, новые сверху:
+// GET /api/admin/orders?store_code=samal3&status=new&limit=50&offset=0
+// Управляющий видит только свои точки; чужой store_code — 403.
+func (h *Handler) handleAdminListOrders(w http.ResponseWriter, r *http.Request) {
+	scope, ok := h.storeAccess(r)
+	if !ok {
+		writeError(w, ErrForbidden())
+		return
+	}
+	q := r.URL.Query()
+
+	where := []string{"1=1"}
+	var args []any
+	if store := strings.TrimSpace(q.Get("store_code")); store != "" {
+		if !scope.allows(store) {
+			writeError(w, ErrForbidden())
+			return
+		}
+		where = append(where, "o.store_code = ?")
+		args = append(args, store)
+	} else if !scope.root {
+		where = append(where, "o.store_code IN (?"+strings.Repeat(", ?", len(scope.stores)-1)+")")
+		for _, s := range scope.stores {
+			args = append(args, s)
+		}
+	}
+	if s := strings.TrimSpace(q.Get("status")); s != "" {
+		where = append(where, "o.status = ?")
+		args = append(args, s)
+	}
+
+	limit := adminOrdersDefaultLimit
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		limit = min(v, adminOrdersMaxLimit)
+	}
+	offset := 0
+	if v, err := strconv.Atoi(q.Get("offset")); err == nil && v > 0 {
+		offset = v
+	}
+
+	cond := strings.Join(where, " AND ")
+	var total int64
+	if err := h.db.QueryRow(`SELECT COUNT(1) FROM orders o WHERE `+cond, args...).Scan(&total); err != nil {
+		h.logger.Error("count orders", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	rows, err := h.db.Query(`
+		SELECT o.id, o.user_id, COALESCE(u.nickname, ''), COALESCE(u.phone, ''), COALESCE(o.store_code, ''),
+		       o.status, o.total_amount, COALESCE(o.parent_order_id, 0), o.created_at,
+		       o.payment_decided_by, o.payment_decided_by_name, o.payment_decided_at
+		FROM orders o
+		LEFT JOIN users u ON u.user_id = o.user_id
+		WHERE `+cond+`
+		ORDER BY o.created_at DESC, o.id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, limit, offset)...)
+	if err != nil {
+		h.logger.Error("list orders", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer rows.Close()
+
+	type orderOut struct {
+		ID          int64  `json:"id"`
+		UserID      int64  `json:"user_id"`
+		Nickname    string `json:"nickname"`
+		Phone       string `json:"phone"`
+		StoreCode   string `json:"store_code"`
+		Status      string `json:"status"`
+		StatusText  string `json:"status_text"`
+		TotalAmount int64  `json:"total_amount"`
+		// часть заказа из нескольких точек: оплата — по заказу parent_order_id
+		ParentOrderID int64     `json:"parent_order_id,omitempty"`
+		CreatedAt     time.Time `json:"created_at"`
+		// кто из админов подтвердил или отклонил чек; null — решения ещё не было
+		PaymentDecision *paymentDecisionOut `json:"payment_decision"`
+	}
+	items := []orderOut{}
+	for rows.Next() {
+		var (
+			o           orderOut
+			decidedBy   sql.NullInt64
+			decidedName sql.NullString
+			decidedAt   sql.NullTime
+		)
+		if err := rows.Scan(&o.ID, &o.UserID, &o.Nickname, &o.Phone, &o.StoreCode, &o.Status, &o.TotalAmount, &o.ParentOrderID, &o.CreatedAt,
+			&decidedBy, &decidedName, &decidedAt); err != nil {
+			h.logger.Error("scan order", zap.Error(err))
+			continue
+		}
+		o.StatusText = humanOrderStatus(o.Status)
+		o.PaymentDecision = newPaymentDecision(decidedBy, decidedName, decidedAt)
+		items = append(items, o)
+	}
+	jsonOK(w, map[string]any{
+		"items":  items,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
@@ -0,0 +1,159 @@
+// handler/order-status-messages.go
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// orderIDPlaceholder в тексте уведомления заменяется номером заказа.
+const orderIDPlaceholder = "{order_id}"
+
+// orderStatuses — все статусы заказа, для которых можно настроить текст.
+var orderStatuses = []string{orderReview, "new", "checking", "invoiced", "paid", "rejected", orderPreparing, orderDelivering, orderDone, orderCancelled}
+
+// kazakhLocale — LOCALE=kk (или kz): уведомления берутся из user_message_kz.
+func (h *Handler) kazakhLocale() bool {
+	l := strings.ToLower(strings.TrimSpace(h.cfg.Locale))
+	return l == "kk" || l == "kz"
+}
+
+// orderStatusText — текст уведомления покупателю о статусе из order_status_messages
+// на языке LOCALE (казахского текста нет — русский). Строки нет или она пустая —
+// fallback. {order_id} заменяется номером заказа.
+func (h *Handler) orderStatusText(status string, orderID int64, fallback string) string {
+	var ru, kz sql.NullString
+	err := h.db.QueryRow(`
+		SELECT user_message_ru, user_message_kz FROM order_status_messages WHERE status = ?
+	`, status).Scan(&ru, &kz)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		h.logger.Warn("select order status message", zap.String("status", status), zap.Error(err))
+	}
+	text := strings.TrimSpace(ru.String)
+	if h.kazakhLocale() && strings.TrimSpace(kz.String) != "" {
+		text = strings.TrimSpace(kz.String)
+	}
+	if text == "" {
+		return fallback
+	}
+	return strings.ReplaceAll(text, orderIDPlaceholder, strconv.FormatInt(orderID, 10))
+}
+
+type orderStatusMessageOut struct {
+	Status        string `json:"status"`
+	StatusText    string `json:"status_text"`
+	UserMessageRU string `json:"user_message_ru"`
+	UserMessageKZ string `json:"user_message_kz"`
+}
+
+// GET /api/admin/order-status-messages — тексты уведомлений по всем статусам.
+func (h *Handler) handleAdminListOrderStatusMessages(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	stored := map[string]orderStatusMessageOut{}
+	rows, err := h.db.Query(`
+		SELECT status, COALESCE(user_message_ru, ''), COALESCE(user_message_kz, '') FROM order_status_messages
+	`)
+	if err != nil {
+		h.logger.Error("list order status messages", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m orderStatusMessageOut
+		if err := rows.Scan(&m.Status, &m.UserMessageRU, &m.UserMessageKZ); err != nil {
+			h.logger.Error("scan order status message", zap.Error(err))
+			continue
+		}
+		stored[m.Status] = m
+	}
+
+	items := make([]orderStatusMessageOut, 0, len(orderStatuses))
+	for _, s := range orderStatuses {
+		m := stored[s]
+		m.Status, m.StatusText = s, humanOrderStatus(s)
+		items = append(items, m)
+	}
+	jsonOK(w, map[string]any{"items": items, "placeholder": orderIDPlaceholder})
+}
+
+type setOrderStatusMessageIn struct {
+	Status        string  `json:"status"`
+	UserMessageRU *string `json:"user_message_ru"`
+	UserMessageKZ *string `json:"user_message_kz"`
+}
+
+// POST /api/admin/order-status-messages {status, user_message_ru, user_message_kz} —
+// поменять текст уведомления. Не переданное поле остаётся как было.
+func (h *Handler) handleAdminSetOrderStatusMessage(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in setOrderStatusMessageIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	in.Status = strings.TrimSpace(in.Status)
+	if !slices.Contains(orderStatuses, in.Status) {
+		writeError(w, ErrBadRequest("unknown status"))
+		return
+	}
+	if in.UserMessageRU == nil && in.UserMessageKZ == nil {
+		writeError(w, ErrBadRequest("user_message_ru or user_message_kz is required"))
+		return
+	}
+	if in.UserMessageRU != nil && strings.TrimSpace(*in.UserMessageRU) == "" {
+		// русский текст — запасной для всех языков, пустым его не оставляем
+		writeError(w, ErrBadRequest("user_message_ru must not be empty"))
+		return
+	}
+
+	var ru, kz any
+	if in.UserMessageRU != nil {
+		ru = strings.TrimSpace(*in.UserMessageRU)
+	}
+	if in.UserMessageKZ != nil {
+		kz = strings.TrimSpace(*in.UserMessageKZ)
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("begin tx", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(`
+		INSERT INTO order_status_messages (status, user_message_ru, user_message_kz, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(status) DO UPDATE SET
+		  user_message_ru = COALESCE(excluded.user_message_ru, order_status_messages.user_message_ru),
+		  user_message_kz = COALESCE(excluded.user_message_kz, order_status_messages.user_message_kz),
+		  updated_at = CURRENT_TIMESTAMP
+	`, in.Status, ru, kz)
+	if err == nil {
+		err = h.writeAudit(tx, h.auditActor(r), "order_status_message.set", in.Status, "", in)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Error("set order status message", zap.String("status", in.Status), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,79 @@
+// handler/order-store-check.go
+package handler
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// itemStoreError — позиция заказа, которой нет в ассортименте точки заказа.
+type itemStoreError struct {
+	ProductID int64  `json:"product_id"`
+	Name      string `json:"name"`
+	StoreCode string `json:"store_code"` // точка, к которой привязан товар
+}
+
+// itemStoreMatch — итог сверки позиций с точкой заказа (для уведомления админу).
+type itemStoreMatch struct {
+	Local  int // товары именно этой точки
+	Global int // товары без store_code — продаются везде
+}
+
+// checkItemStores сверяет товары заказа с точкой storeCode: товар с непустым
+// store_code, отличным от точки заказа, заказать нельзя (с split_stores такие
+// товары не отклоняются, а уходят в свою часть заказа). Товары без store_code
+// (общие) и служебные строки без product_id проходят как раньше.
+func checkItemStores(storeCode string, items []orderItemIn, products map[int64]orderProduct) (itemStoreMatch, []itemStoreError) {
+	var match itemStoreMatch
+	var bad []itemStoreError
+	for _, it := range items {
+		p, ok := products[it.ProductID]
+		switch {
+		case !ok:
+			// служебная строка или товар уже удалён из каталога — не наша проверка
+		case p.StoreCode == "":
+			match.Global++
+		case p.StoreCode == storeCode:
+			match.Local++
+		default:
+			bad = append(bad, itemStoreError{ProductID: it.ProductID, Name: it.Name, StoreCode: p.StoreCode})
+		}
+	}
+	return match, bad
+}
+
+// productStores — store_code товаров заказа по product_id ("" — общий товар).
+func productStores(products map[int64]orderProduct) map[int64]string {
+	stores := make(map[int64]string, len(products))
+	for id, p := range products {
+		stores[id] = p.StoreCode
+	}
+	return stores
+}
+
+// foreignItemsError — 400 со списком позиций другой точки (поле items).
+func (h *Handler) foreignItemsError(storeCode string, bad []itemStoreError) *AppError {
+	names := make([]string, 0, len(bad))
+	for _, b := range bad {
+		names = append(names, b.Name)
+	}
+	h.logger.Info("order items from another store",
+		zap.String("store", storeCode), zap.Strings("items", names))
+	return ErrBadRequest(fmt.Sprintf("products are not sold at the selected store: %s", strings.Join(names, ", "))).
+		WithCode("items_not_in_store").
+		WithField("items", bad)
+}
+
+// storeMatchLine — строка для уведомления админу о сверке позиций с точкой.
+func (m itemStoreMatch) storeMatchLine() string {
+	switch {
+	case m.Local == 0 && m.Global == 0:
+		return ""
+	case m.Global == 0:
+		return "✅ Все позиции — из ассортимента точки\n"
+	default:
+		return fmt.Sprintf("✅ Позиции точки: %d, общие для всех точек: %d\n", m.Local, m.Global)
+	}
+}
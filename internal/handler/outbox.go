@@ -0,0 +1,188 @@
+// handler/outbox.go
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+const (
+	outboxBatch        = 100
+	outboxMaxAttempts  = 5                // после стольких ошибок Telegram сообщение больше не пробуем
+	outboxPollInterval = 10 * time.Second // как часто RunOutbox разбирает очереди
+)
+
+// sendOrQueue отправляет сообщение, а пока бот не подключён (окно старта до
+// SetBot или веб-API запущен отдельно) или Telegram лежит (предохранитель
+// retrySender разомкнут) — кладёт его в notification_outbox: уйдёт при flushOutbox. queued=true — сообщение ждёт в outbox.
+// В outbox сохраняется только inline-клавиатура — других в этих сообщениях нет.
+func (h *Handler) sendOrQueue(ctx context.Context, p *bot.SendMessageParams) (queued bool, err error) {
+	if s := h.sender; s != nil {
+		_, err := s.SendMessage(ctx, p)
+		if !errors.Is(err, errTelegramUnavailable) {
+			return false, err
+		}
+		// Telegram лежит — не теряем сообщение, отправим после восстановления
+	}
+
+	var chatID int64
+	switch v := p.ChatID.(type) {
+	case int64:
+		chatID = v
+	case int:
+		chatID = int64(v)
+	default:
+		return false, fmt.Errorf("outbox: unsupported chat id %T", p.ChatID)
+	}
+	var markup []byte
+	if kb, ok := p.ReplyMarkup.(*models.InlineKeyboardMarkup); ok && kb != nil {
+		if markup, err = json.Marshal(kb); err != nil {
+			return false, err
+		}
+	}
+	_, err = h.db.ExecContext(ctx, `
+		INSERT INTO notification_outbox (chat_id, text, reply_markup) VALUES (?, ?, ?)
+	`, chatID, p.Text, nullString(string(markup)))
+	if err != nil {
+		return false, fmt.Errorf("queue to outbox: %w", err)
+	}
+	h.logger.Info("telegram is not available, message queued to outbox", zap.Int64("chat_id", chatID))
+	return true, nil
+}
+
+// sendOrQueueQuiet — sendOrQueue для уведомлений, где ошибка не меняет ответ.
+func (h *Handler) sendOrQueueQuiet(ctx context.Context, p *bot.SendMessageParams, what string) {
+	if _, err := h.sendOrQueue(ctx, p); err != nil {
+		h.logger.Warn("send "+what, zap.Any("chat_id", p.ChatID), zap.Error(err))
+	}
+}
+
+// flushOutbox отправляет накопленные в outbox сообщения, когда бот готов.
+// Ошибку Telegram записываем в строку и пробуем при следующем вызове.
+func (h *Handler) flushOutbox(ctx context.Context) (sent int, err error) {
+	s := h.sender
+	if s == nil {
+		return 0, nil
+	}
+	type pending struct {
+		id, chatID int64
+		text       string
+		markup     sql.NullString
+	}
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT id, chat_id, text, reply_markup
+		FROM notification_outbox
+		WHERE sent_at IS NULL AND attempts < ?
+		ORDER BY id
+		LIMIT ?
+	`, outboxMaxAttempts, outboxBatch)
+	if err != nil {
+		return 0, err
+	}
+	var list []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.chatID, &p.text, &p.markup); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		list = append(list, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, p := range list {
+		params := &bot.SendMessageParams{ChatID: p.chatID, Text: p.text}
+		if p.markup.Valid {
+			var kb models.InlineKeyboardMarkup
+			if err := json.Unmarshal([]byte(p.markup.String), &kb); err == nil {
+				params.ReplyMarkup = &kb
+			}
+		}
+		_, serr := s.SendMessage(ctx, params)
+		if errors.Is(serr, errTelegramUnavailable) {
+			// попытку не засчитываем: дошлём, когда предохранитель замкнётся
+			break
+		}
+		if serr != nil {
+			h.logger.Warn("send outbox message", zap.Int64("id", p.id), zap.Error(serr))
+			_, err = h.db.ExecContext(ctx, `
+				UPDATE notification_outbox SET attempts = attempts + 1, last_error = ? WHERE id = ?
+			`, serr.Error(), p.id)
+		} else {
+			sent++
+			_, err = h.db.ExecContext(ctx, `
+				UPDATE notification_outbox SET attempts = attempts + 1, sent_at = ? WHERE id = ?
+			`, h.clock.Now().UTC(), p.id)
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+	if sent > 0 {
+		h.logger.Info("outbox flushed", zap.Int("sent", sent))
+	}
+	return sent, nil
+}
+
+// RunOutbox — единственный воркер отложенной доставки: раз в outboxPollInterval
+// досылает сообщения Telegram из notification_outbox и вебхуки из
+// webhook_deliveries. Сразу после подключения бота и восстановления Telegram
+// outbox разбирается и без него (flushOutboxQuiet).
+func (h *Handler) RunOutbox(ctx context.Context) {
+	h.logger.Info("started outbox worker")
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.flushOutboxQuiet(ctx)
+			h.deliverWebhooks(ctx)
+		}
+	}
+}
+
+// flushOutboxQuiet — flushOutbox в фоне после подключения бота.
+func (h *Handler) flushOutboxQuiet(ctx context.Context) {
+	if _, err := h.flushOutbox(ctx); err != nil {
+		h.logger.Error("flush outbox", zap.Error(err))
+	}
+}
+
+// GET /readyz — готов ли сервис обслуживать мини-апп: бот подключён
+// (сообщения уходят сразу, а не в outbox), база и Redis отвечают. 503 — не готов.
+func (h *Handler) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	botReady := h.botReady.Load()
+	dbReady := h.db.PingContext(ctx) == nil
+	redisReady := h.redisClient == nil || h.redisClient.Ping(ctx) == nil
+	var pending int64
+	if dbReady {
+		if err := h.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM notification_outbox WHERE sent_at IS NULL`).Scan(&pending); err != nil {
+			h.logger.Warn("count outbox", zap.Error(err))
+		}
+	}
+
+	if !botReady || !dbReady || !redisReady {
+		writeError(w, ErrServiceUnavailable("not ready").
+			WithField("bot", botReady).WithField("db", dbReady).WithField("redis", redisReady).
+			WithField("outbox_pending", pending))
+		return
+	}
+	jsonOK(w, map[string]any{"status": "ready", "bot": true, "db": true, "redis": true, "outbox_pending": pending})
+}
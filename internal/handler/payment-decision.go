@@ -0,0 +1,227 @@
+// handler/payment-decision.go
+package handler
+
+import (
+	"agro/internal/domain"
+	"agro/internal/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+// errPaymentDecided — по чеку уже приняли решение (например, другой админ нажал раньше).
+var errPaymentDecided = errors.New("payment already decided")
+
+// paymentDecider — админ, нажавший «Подтвердить» или «Отклонить» под чеком.
+type paymentDecider struct {
+	ID   int64
+	Name string // @username, а без него — имя из профиля
+}
+
+func paymentDeciderOf(u models.User) paymentDecider {
+	name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if u.Username != "" {
+		name = "@" + u.Username
+	}
+	if name == "" {
+		name = fmt.Sprintf("ID %d", u.ID)
+	}
+	return paymentDecider{ID: u.ID, Name: name}
+}
+
+// decideOrderPayment переводит заказ (и его части, если он из нескольких точек)
+// в paid или rejected и запоминает, кто решил.
+// Обновление условное (AND status = прежний): из двух одновременных нажатий
+// проходит только первое, второе получает errPaymentDecided. Возвращает прежний статус.
+func (h *Handler) decideOrderPayment(ctx context.Context, orderID int64, to string, d paymentDecider) (string, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var prev string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM orders WHERE id = ?`, orderID).Scan(&prev)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", repository.ErrOrderNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if !slices.Contains(payableOrderStatuses, prev) || prev == to {
+		return prev, errPaymentDecided
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE orders
+		SET status = ?, payment_decided_by = ?, payment_decided_by_name = ?,
+		    payment_decided_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ?
+	`, to, nullInt(d.ID), nullString(d.Name), orderID, prev)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return prev, errPaymentDecided
+	}
+	// общий заказ из нескольких точек: его части оплачены (отклонены) вместе с ним
+	if err := cascadeOrderPayment(ctx, tx, orderID, to, d); err != nil {
+		return "", err
+	}
+	details := map[string]any{"from": prev, "to": to, "admin": d.Name}
+	if err := h.writeAudit(tx, d.ID, "order.payment_"+to, fmt.Sprint(orderID), "", details); err != nil {
+		return "", err
+	}
+	return prev, tx.Commit()
+}
+
+// rejectSubscription отклоняет оплату подписки, если она всё ещё pending.
+func (h *Handler) rejectSubscription(ctx context.Context, subID int64, d paymentDecider) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE subscriptions
+		SET status = 'rejected', decided_by = ?, decided_by_name = ?, decided_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = 'pending'
+	`, nullInt(d.ID), nullString(d.Name), subID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errSubscriptionNotPending
+	}
+	if err := h.writeAudit(tx, d.ID, "subscription.payment_rejected", fmt.Sprint(subID), "", map[string]any{"admin": d.Name}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// orderDecisionText — ответ на нажатие под уже обработанным чеком заказа.
+func (h *Handler) orderDecisionText(orderID, presser int64) string {
+	var (
+		status string
+		by     sql.NullInt64
+		name   sql.NullString
+	)
+	err := h.db.QueryRow(`
+		SELECT status, payment_decided_by, payment_decided_by_name FROM orders WHERE id = ?
+	`, orderID).Scan(&status, &by, &name)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return "Заказ не найден"
+	case err != nil:
+		return "Оплата заказа уже обработана"
+	}
+	return decidedByOtherText(fmt.Sprintf("Заказ №%d: %s", orderID, humanOrderStatus(status)), by, name, presser)
+}
+
+// decidedByOtherText добавляет к ответу, что чек уже обработал другой админ.
+func decidedByOtherText(text string, by sql.NullInt64, name sql.NullString, presser int64) string {
+	if !by.Valid || by.Int64 == presser {
+		return text
+	}
+	return fmt.Sprintf("Уже обработано другим админом (%s). %s", firstNonEmpty(name.String, fmt.Sprint(by.Int64)), text)
+}
+
+// markPaymentDecided дописывает под чеком «Подтвердил: @username» и убирает
+// кнопки, чтобы остальные админы видели, что решение уже принято.
+func (h *Handler) markPaymentDecided(ctx context.Context, cq *models.CallbackQuery, verdict string, d paymentDecider) {
+	msg := cq.Message.Message
+	if msg == nil {
+		return
+	}
+	caption := strings.TrimRight(msg.Caption, "\n") + "\n\n" + verdict + ": " + d.Name
+	_, err := h.sender.EditMessageCaption(ctx, &bot.EditMessageCaptionParams{
+		ChatID:      msg.Chat.ID,
+		MessageID:   msg.ID,
+		Caption:     caption,
+		ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{}},
+	})
+	if err != nil {
+		h.logger.Warn("edit payment caption", zap.Int("message_id", msg.ID), zap.Error(err))
+	}
+}
+
+// paymentDecisionOut — кто из админов подтвердил или отклонил чек заказа.
+type paymentDecisionOut struct {
+	AdminID   int64     `json:"admin_id"`
+	AdminName string    `json:"admin_name"`
+	DecidedAt time.Time `json:"decided_at"`
+}
+
+func newPaymentDecision(by sql.NullInt64, name sql.NullString, at sql.NullTime) *paymentDecisionOut {
+	if !at.Valid {
+		return nil
+	}
+	return &paymentDecisionOut{AdminID: by.Int64, AdminName: name.String, DecidedAt: at.Time}
+}
+
+// confirmOrderPayment — общая часть подтверждения оплаты заказа: нажатие
+// «✅ Подтвердить» админом и подписанный callback Kaspi. Заказ → paid,
+// события вебхуков, состояние пользователя и сообщение ему.
+func (h *Handler) confirmOrderPayment(ctx context.Context, orderID, userID int64, d paymentDecider) error {
+	prev, err := h.decideOrderPayment(ctx, orderID, "paid", d)
+	if err != nil {
+		return err
+	}
+	h.emitOrderEvent(webhookOrderPaid, orderID, prev)
+	h.emitOrderEvent(webhookOrderStatusChanged, orderID, prev)
+	h.emitSubOrderEvents(ctx, orderID, webhookOrderStatusChanged, prev)
+
+	if userID != 0 {
+		h.markUserPaid(ctx, userID)
+		text := h.orderStatusText("paid", orderID,
+			fmt.Sprintf("✅ Ваша оплата по заказу №%d подтверждена! Спасибо за заказ.", orderID))
+		h.sendOrQueueQuiet(ctx, &bot.SendMessageParams{ChatID: userID, Text: text}, "paid confirmation to user")
+	}
+	return nil
+}
+
+// confirmSubscriptionPayment — то же для подписки: pending → active и сообщение пользователю.
+func (h *Handler) confirmSubscriptionPayment(ctx context.Context, subID, userID int64, d paymentDecider) error {
+	validUntil, err := h.activateSubscription(ctx, subID, userID, d)
+	if err != nil {
+		return err
+	}
+	h.markUserPaid(ctx, userID)
+	h.sendOrQueueQuiet(ctx, &bot.SendMessageParams{
+		ChatID: userID,
+		Text: fmt.Sprintf(
+			"✅ Ваша подписка на «АГРО Клуб Оптовых Цен» активирована!\n"+
+				"Доступ к оптовым ценам до: %s.",
+			formatDate(validUntil),
+		),
+	}, "sub active to user")
+	return nil
+}
+
+// markUserPaid сбрасывает сценарий пользователя в Redis после подтверждённой оплаты.
+func (h *Handler) markUserPaid(ctx context.Context, userID int64) {
+	if h.redisClient == nil {
+		return
+	}
+	state, err := h.redisClient.GetUserState(ctx, userID)
+	if err != nil {
+		h.logger.Warn("get user state after payment", zap.Error(err))
+	}
+	if state == nil {
+		state = &domain.UserState{}
+	}
+	state.State = stateStart
+	state.IsPaid = true
+	if err := h.redisClient.SaveUserState(ctx, userID, state); err != nil {
+		h.logger.Warn("save user state after payment", zap.Error(err))
+	}
+}
@@ -3,77 +3,315 @@ package handler
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
 	"go.uber.org/zap"
 )
 
-// CheckPayment запускает фоновой цикл, который раз в сутки
-// проверяет просроченные подписки и помечает их как expired.
-func (h *Handler) CheckPayment(ctx context.Context) {
-	h.logger.Info("started check payment handler")
+// за сколько дней до окончания подписки напоминаем пользователю
+const subReminderDays = 3
+
+// settingLastExpiryRun — ключ app_settings: когда последний раз успешно прошла
+// ежедневная проверка подписок (RFC3339, UTC).
+const settingLastExpiryRun = "last_expiry_run"
 
-	// Сразу одна проверка при старте
-	h.checkAndExpireSubscriptions(ctx)
+// defaultSubCheckTime — время ежедневной проверки, если SUB_CHECK_TIME не разобрать.
+const defaultSubCheckTime = "09:00"
 
-	ticker := time.NewTicker(24 * time.Hour)
-	defer ticker.Stop()
+// CheckPayment запускает фоновой цикл, который раз в сутки в SUB_CHECK_TIME
+// (местное время) проверяет просроченные подписки и помечает их как expired.
+// Время последнего прогона хранится в БД: после рестарта пропущенный прогон
+// выполняется сразу, а уже сделанный сегодня — не повторяется.
+func (h *Handler) CheckPayment(ctx context.Context) {
+	h.logger.Info("started check payment handler", zap.String("run_at", h.cfg.SubCheckTime))
 
 	for {
+		timer := time.NewTimer(h.runDailyChecks(ctx))
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			h.logger.Info("stopping check payment handler", zap.Error(ctx.Err()))
 			return
-		case <-ticker.C:
-			h.logger.Info("checking payment date for each user")
-			h.checkAndExpireSubscriptions(ctx)
+		case <-timer.C:
 		}
 	}
 }
 
-// checkAndExpireSubscriptions находит все подписки, у которых valid_until < NOW(),
-// и помечает:
-//   - subscriptions.status = 'expired'
-//   - users.sub_status = 'expired', users.sub_until = NULL
-func (h *Handler) checkAndExpireSubscriptions(ctx context.Context) {
+// runDailyChecks выполняет проверку, если прогон за текущие сутки ещё не сделан,
+// и возвращает паузу до следующей попытки: до следующего запуска по расписанию
+// или, после ошибки, SUB_CHECK_RETRY.
+func (h *Handler) runDailyChecks(ctx context.Context) time.Duration {
+	now := h.clock.Now()
+	slot := dailySlot(now, h.subCheckTime(), display().loc)
+	next := slot.AddDate(0, 0, 1)
+
+	if last, ok := h.lastExpiryRun(); ok && !last.Before(slot) {
+		return next.Sub(now)
+	}
+
+	h.logger.Info("checking payment date for each user", zap.Time("slot", slot))
+	err := h.checkAndExpireSubscriptions(ctx)
+	h.remindExpiringSubscriptions(ctx)
+	h.remindGraceSubscriptions(ctx)
+	h.remindPendingReviews(ctx)
+	if err != nil {
+		retry := max(h.cfg.SubCheckRetry, time.Minute)
+		h.logger.Warn("subscription expiry run failed, will retry", zap.Duration("retry_in", retry), zap.Error(err))
+		return min(retry, next.Sub(now))
+	}
+
+	if err := upsertSetting(h.db, settingLastExpiryRun, now.UTC().Format(time.RFC3339)); err != nil {
+		h.logger.Error("save last expiry run", zap.Error(err))
+	}
+	return next.Sub(h.clock.Now())
+}
+
+// lastExpiryRun — время последнего успешного прогона; ok=false — прогонов ещё не было.
+func (h *Handler) lastExpiryRun() (time.Time, bool) {
+	v, ok, err := h.setting(settingLastExpiryRun)
+	if err != nil {
+		h.logger.Warn("read last expiry run", zap.Error(err))
+	}
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, err == nil
+}
+
+// subCheckTime — SUB_CHECK_TIME в виде смещения от полуночи.
+func (h *Handler) subCheckTime() time.Duration {
+	at, err := time.Parse("15:04", strings.TrimSpace(h.cfg.SubCheckTime))
+	if err != nil {
+		h.logger.Warn("invalid SUB_CHECK_TIME, using default",
+			zap.String("value", h.cfg.SubCheckTime), zap.String("default", defaultSubCheckTime))
+		at, _ = time.Parse("15:04", defaultSubCheckTime)
+	}
+	return time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+}
+
+// dailySlot — последний момент «сегодня в at» (в поясе loc), не позже now.
+func dailySlot(now time.Time, at time.Duration, loc *time.Location) time.Time {
+	local := now.In(loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	slot := midnight.Add(at)
+	if now.Before(slot) {
+		slot = time.Date(local.Year(), local.Month(), local.Day()-1, 0, 0, 0, 0, loc).Add(at)
+	}
+	return slot
+}
+
+// checkAndExpireSubscriptions переводит подписки, у которых valid_until < NOW():
+//   - в льготный период (cfg.SubGraceDays дней): status/sub_status = 'grace',
+//     доступ сохраняется, sub_until не трогаем;
+//   - после льготного периода: subscriptions.status = 'expired',
+//     users.sub_status = 'expired', users.sub_until = NULL.
+//
+// Ошибка одного шага не останавливает остальные; возвращаются все ошибки.
+func (h *Handler) checkAndExpireSubscriptions(ctx context.Context) error {
 	if h.db == nil {
-		h.logger.Warn("db is nil in checkAndExpireSubscriptions")
-		return
+		return errors.New("db is nil in checkAndExpireSubscriptions")
 	}
 
-	now := time.Now()
+	now := h.clock.Now()
+	graceEnd := now.AddDate(0, 0, -h.cfg.SubGraceDays) // всё, что закончилось раньше, — уже без льгот
 
-	// 1) Помечаем просроченные записи в subscriptions
-	resSub, err := h.db.ExecContext(ctx, `
-		UPDATE subscriptions
-		SET status = 'expired'
-		WHERE status = 'active'
-		  AND valid_until IS NOT NULL
-		  AND valid_until < ?
-	`, now)
+	steps := []struct {
+		name  string
+		query string
+		args  []any
+	}{
+		{"subscriptions grace", `
+			UPDATE subscriptions
+			SET status = 'grace'
+			WHERE status = 'active'
+			  AND valid_until IS NOT NULL
+			  AND valid_until < ?
+			  AND valid_until >= ?
+		`, []any{now, graceEnd}},
+		{"users sub_status grace", `
+			UPDATE users
+			SET sub_status = 'grace',
+			    updated_at = CURRENT_TIMESTAMP
+			WHERE sub_status = 'active'
+			  AND sub_until IS NOT NULL
+			  AND sub_until < ?
+			  AND sub_until >= ?
+		`, []any{now, graceEnd}},
+		{"subscriptions expired", `
+			UPDATE subscriptions
+			SET status = 'expired'
+			WHERE status IN ('active', 'grace')
+			  AND valid_until IS NOT NULL
+			  AND valid_until < ?
+		`, []any{graceEnd}},
+		{"users sub_status expired", `
+			UPDATE users
+			SET sub_status = 'expired',
+			    sub_until  = NULL,
+			    updated_at = CURRENT_TIMESTAMP
+			WHERE sub_status IN ('active', 'grace')
+			  AND sub_until IS NOT NULL
+			  AND sub_until < ?
+		`, []any{graceEnd}},
+	}
+	var errs []error
+	for _, st := range steps {
+		res, err := h.db.ExecContext(ctx, st.query, st.args...)
+		if err != nil {
+			h.logger.Error("update "+st.name, zap.Error(err))
+			errs = append(errs, fmt.Errorf("update %s: %w", st.name, err))
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			h.logger.Info(st.name+" updated", zap.Int64("count", n))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// remindGraceSubscriptions раз в день напоминает пользователям в льготном
+// периоде о продлении (кнопка открывает мини-приложение).
+func (h *Handler) remindGraceSubscriptions(ctx context.Context) {
+	if h.db == nil || h.cfg.SubGraceDays <= 0 {
+		return
+	}
+
+	now := h.clock.Now()
+	day := now.Format("2006-01-02")
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT s.id, s.user_id, s.valid_until
+		FROM subscriptions s
+		LEFT JOIN subscription_grace_reminders r ON r.subscription_id = s.id AND r.day = ?
+		WHERE s.status = 'grace'
+		  AND s.valid_until IS NOT NULL
+		  AND r.subscription_id IS NULL
+	`, day)
 	if err != nil {
-		h.logger.Error("expire subscriptions", zap.Error(err))
-	} else {
-		if n, _ := resSub.RowsAffected(); n > 0 {
-			h.logger.Info("expired subscriptions updated", zap.Int64("count", n))
+		h.logger.Error("select grace subscriptions", zap.Error(err))
+		return
+	}
+
+	type due struct {
+		subID      int64
+		userID     int64
+		validUntil time.Time
+	}
+	var list []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.subID, &d.userID, &d.validUntil); err != nil {
+			h.logger.Warn("scan grace subscription", zap.Error(err))
+			continue
 		}
+		list = append(list, d)
 	}
+	rows.Close()
 
-	// 2) Помечаем просроченные подписки в users
-	resUsers, err := h.db.ExecContext(ctx, `
-		UPDATE users
-		SET sub_status = 'expired',
-		    sub_until  = NULL,
-		    updated_at = CURRENT_TIMESTAMP
-		WHERE sub_status = 'active'
-		  AND sub_until IS NOT NULL
-		  AND sub_until < ?
-	`, now)
+	for _, d := range list {
+		res, err := h.db.ExecContext(ctx,
+			`INSERT INTO subscription_grace_reminders (subscription_id, day, sent_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`,
+			d.subID, day, now)
+		if err != nil {
+			h.logger.Error("insert grace reminder", zap.Error(err))
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		_, err = h.sendOrQueue(ctx, &bot.SendMessageParams{
+			ChatID: d.userID,
+			Text: fmt.Sprintf(
+				"⚠️ Ваша подписка на «АГРО Клуб Оптовых Цен» закончилась %s.\n"+
+					"Доступ к оптовым ценам сохранится до %s — продлите подписку, чтобы не потерять его.",
+				formatDate(d.validUntil),
+				formatDate(h.graceUntil(d.validUntil)),
+			),
+			ReplyMarkup: &models.InlineKeyboardMarkup{
+				InlineKeyboard: [][]models.InlineKeyboardButton{{
+					{Text: "🔄 Продлить подписку", WebApp: &models.WebAppInfo{URL: h.cfg.MiniAppUrl}},
+				}},
+			},
+		})
+		if err != nil {
+			h.logger.Warn("send grace reminder", zap.Int64("user", d.userID), zap.Error(err))
+		}
+	}
+}
+
+// graceUntil — последний момент доступа для подписки, оплаченной до validUntil.
+func (h *Handler) graceUntil(validUntil time.Time) time.Time {
+	return validUntil.AddDate(0, 0, h.cfg.SubGraceDays)
+}
+
+// remindExpiringSubscriptions напоминает пользователям, у которых активная подписка
+// заканчивается в ближайшие subReminderDays дней. Каждое напоминание отправляется
+// один раз на подписку (учёт в subscription_reminders).
+func (h *Handler) remindExpiringSubscriptions(ctx context.Context) {
+	if h.db == nil {
+		h.logger.Warn("db is nil in remindExpiringSubscriptions")
+		return
+	}
+
+	now := h.clock.Now()
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT s.id, s.user_id, s.valid_until
+		FROM subscriptions s
+		LEFT JOIN subscription_reminders r ON r.subscription_id = s.id
+		WHERE s.status = 'active'
+		  AND s.valid_until IS NOT NULL
+		  AND s.valid_until >= ?
+		  AND s.valid_until < ?
+		  AND r.subscription_id IS NULL
+	`, now, now.AddDate(0, 0, subReminderDays))
 	if err != nil {
-		h.logger.Error("expire user subscriptions", zap.Error(err))
-	} else {
-		if n, _ := resUsers.RowsAffected(); n > 0 {
-			h.logger.Info("users sub_status expired", zap.Int64("count", n))
+		h.logger.Error("select expiring subscriptions", zap.Error(err))
+		return
+	}
+
+	type due struct {
+		subID      int64
+		userID     int64
+		validUntil time.Time
+	}
+	var list []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.subID, &d.userID, &d.validUntil); err != nil {
+			h.logger.Warn("scan expiring subscription", zap.Error(err))
+			continue
+		}
+		list = append(list, d)
+	}
+	rows.Close()
+
+	for _, d := range list {
+		// сначала фиксируем, потом шлём — чтобы при сбое не напомнить дважды
+		res, err := h.db.ExecContext(ctx,
+			`INSERT INTO subscription_reminders (subscription_id, sent_at) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+			d.subID, now)
+		if err != nil {
+			h.logger.Error("insert subscription reminder", zap.Error(err))
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		_, err = h.sendOrQueue(ctx, &bot.SendMessageParams{
+			ChatID: d.userID,
+			Text: fmt.Sprintf(
+				"⏰ Ваша подписка на «АГРО Клуб Оптовых Цен» заканчивается %s.\n"+
+					"Продлите её в мини-приложении, чтобы не потерять доступ к оптовым ценам.",
+				formatDate(d.validUntil),
+			),
+		})
+		if err != nil {
+			h.logger.Warn("send subscription reminder", zap.Int64("user", d.userID), zap.Error(err))
 		}
 	}
 }
@@ -0,0 +1,162 @@
+// handler/payment-method.go
+package handler
+
+import (
+	"agro/internal/domain"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// paymentPending — заказ из /api/orders/create ждёт, пока покупатель выберет способ оплаты в боте
+	paymentPending = "pending"
+
+	payMethodChoiceTimeout = 10 * time.Minute // потом — kaspi_link по умолчанию
+	payMethodSweepInterval = time.Minute
+)
+
+// payMethodMarkup — выбор способа оплаты (pay_method:<orderID>:<method>).
+func payMethodMarkup(orderID int64) *models.InlineKeyboardMarkup {
+	btn := func(text, method string) models.InlineKeyboardButton {
+		return models.InlineKeyboardButton{Text: text, CallbackData: fmt.Sprintf("pay_method:%d:%s", orderID, method)}
+	}
+	return &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{
+		{btn("💳 Kaspi Pay", paymentKaspiLink), btn("🏦 Kaspi Transfer", paymentKaspiTransfer), btn("💵 Наличные", paymentCash)},
+	}}
+}
+
+// askPaymentMethod спрашивает у покупателя способ оплаты; не получилось
+// отправить вопрос — сразу kaspi_link и чек, как раньше.
+func (h *Handler) askPaymentMethod(ctx context.Context, userID, orderID int64) {
+	_, err := h.sendOrQueue(ctx, &bot.SendMessageParams{
+		ChatID: userID,
+		Text: fmt.Sprintf("🧾 Заказ №%d принят. Выберите способ оплаты:\n"+
+			"(если не выбрать за %d минут — будет Kaspi Pay по ссылке)", orderID, int(payMethodChoiceTimeout.Minutes())),
+		ReplyMarkup: payMethodMarkup(orderID),
+	})
+	if err != nil {
+		h.logger.Warn("ask payment method", zap.Int64("order_id", orderID), zap.Error(err))
+		h.applyPaymentMethod(ctx, orderID, userID, paymentKaspiLink)
+	}
+}
+
+// applyPaymentMethod фиксирует способ оплаты ожидающего заказа и отправляет чек.
+// false — способ уже выбран (повторное нажатие или сработал таймаут).
+func (h *Handler) applyPaymentMethod(ctx context.Context, orderID, userID int64, method string) bool {
+	ok, err := h.orderRepo.SetPaymentMethod(ctx, orderID, paymentPending, method)
+	if err != nil {
+		h.logger.Error("set payment method", zap.Int64("order_id", orderID), zap.Error(err))
+		return false
+	}
+	if !ok {
+		return false
+	}
+	// как после /api/orders/confirm: ждём оплату, чек-документ пойдёт админу
+	if h.redisClient != nil {
+		st := &domain.UserState{State: stateWaitingPayment, BroadCastType: method}
+		if err := h.redisClient.SaveUserState(ctx, userID, st); err != nil {
+			h.logger.Warn("save user state to redis", zap.Error(err))
+		}
+	}
+	if err := h.sendOrderReceiptToUser(orderID); err != nil {
+		h.logger.Warn("send receipt to user", zap.Int64("order_id", orderID), zap.Error(err))
+	}
+	return true
+}
+
+// handlePayMethodCallback — нажатие pay_method:<orderID>:<method>.
+// Вызывается из PaymentCallbackHandler: префикс pay_ занят им.
+func (h *Handler) handlePayMethodCallback(ctx context.Context, cq *models.CallbackQuery) {
+	answer := func(text string, alert bool) {
+		_, _ = h.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: cq.ID,
+			Text:            text,
+			ShowAlert:       alert,
+		})
+	}
+	parts := strings.Split(strings.TrimPrefix(cq.Data, "pay_method:"), ":")
+	if len(parts) != 2 {
+		return
+	}
+	orderID, err := strconv.ParseInt(parts[0], 10, 64)
+	method := parts[1]
+	if err != nil || orderID <= 0 || (method != paymentKaspiLink && method != paymentKaspiTransfer && method != paymentCash) {
+		return
+	}
+
+	order, _, err := h.orderRepo.GetOrderWithItems(ctx, orderID)
+	if err != nil || order.UserID != cq.From.ID {
+		answer("Заказ не найден", true)
+		return
+	}
+	if !h.applyPaymentMethod(ctx, orderID, order.UserID, method) {
+		if order, _, err = h.orderRepo.GetOrderWithItems(ctx, orderID); err == nil {
+			answer(fmt.Sprintf("Способ оплаты уже выбран: %s", humanPaymentMethod(order.PaymentMethod)), true)
+		}
+		return
+	}
+	answer(humanPaymentMethod(method), false)
+
+	// убираем кнопки, чтобы не нажали второй раз
+	if msg := cq.Message.Message; msg != nil {
+		_, err := h.sender.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:    msg.Chat.ID,
+			MessageID: msg.ID,
+			Text:      fmt.Sprintf("🧾 Заказ №%d\n💳 Способ оплаты: %s", orderID, humanPaymentMethod(method)),
+		})
+		if err != nil {
+			h.logger.Warn("edit payment method message", zap.Int64("order_id", orderID), zap.Error(err))
+		}
+	}
+}
+
+// RunPaymentMethodTimeouts — раз в минуту ставит kaspi_link заказам,
+// по которым способ оплаты не выбрали за payMethodChoiceTimeout.
+func (h *Handler) RunPaymentMethodTimeouts(ctx context.Context) {
+	ticker := time.NewTicker(payMethodSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.expirePaymentChoices(ctx)
+		}
+	}
+}
+
+func (h *Handler) expirePaymentChoices(ctx context.Context) {
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT id, user_id FROM orders
+		WHERE payment_method = ? AND created_at <= ?
+		ORDER BY id
+	`, paymentPending, time.Now().UTC().Add(-payMethodChoiceTimeout).Format(dbTimeLayout))
+	if err != nil {
+		h.logger.Error("select pending payment choices", zap.Error(err))
+		return
+	}
+	type pending struct{ orderID, userID int64 }
+	var list []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.orderID, &p.userID); err != nil {
+			h.logger.Error("scan pending payment choice", zap.Error(err))
+			continue
+		}
+		list = append(list, p)
+	}
+	rows.Close()
+
+	for _, p := range list {
+		if h.applyPaymentMethod(ctx, p.orderID, p.userID, paymentKaspiLink) {
+			h.logger.Info("payment method defaulted", zap.Int64("order_id", p.orderID))
+		}
+	}
+}
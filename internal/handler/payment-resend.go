@@ -0,0 +1,200 @@
+// handler/payment-resend.go
+package handler
+
+import (
+	"agro/internal/domain"
+	"agro/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+// defaultKaspiPayURL — ссылка Kaspi Pay, если KASPI_PAY_URL не задан.
+const defaultKaspiPayURL = "https://pay.kaspi.kz/pay/e96vsxbs"
+
+// payableOrderStatuses — статусы, в которых заказ ещё ждёт оплаты
+// (rejected — чек отклонили, покупатель платит заново).
+var payableOrderStatuses = []string{"new", "checking", "invoiced", "rejected"}
+
+// errPaymentNotDue — заказ уже оплачен, выполнен или отменён: платить нечего.
+var errPaymentNotDue = errors.New("order does not await payment")
+
+// kaspiPayLink — ссылка Kaspi Pay с подставленной суммой заказа.
+func (h *Handler) kaspiPayLink(amount int64) string {
+	link := strings.TrimSpace(h.cfg.KaspiPayURL)
+	if link == "" {
+		link = defaultKaspiPayURL
+	}
+	if amount <= 0 {
+		return link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	q := u.Query()
+	q.Set("amount", strconv.FormatInt(amount, 10))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// resendPaymentMessage повторно отправляет покупателю инструкцию по оплате
+// заказа — тот же чек, что sendOrderReceiptToUser, поэтому тексты не расходятся.
+// Способ оплаты ещё не выбран — снова спрашиваем его.
+func (h *Handler) resendPaymentMessage(ctx context.Context, order *domain.Order) error {
+	if !slices.Contains(payableOrderStatuses, order.Status) {
+		return fmt.Errorf("%w: status %s", errPaymentNotDue, order.Status)
+	}
+	if order.PaymentMethod == paymentPending {
+		h.askPaymentMethod(ctx, order.UserID, order.ID)
+		return nil
+	}
+	if err := h.sendOrderReceiptToUser(order.ID); err != nil {
+		return err
+	}
+	// документ с чеком снова должен уйти админу на проверку
+	if order.PaymentMethod != paymentCash && h.redisClient != nil {
+		st := &domain.UserState{State: stateWaitingPayment, BroadCastType: firstNonEmpty(order.PaymentMethod, paymentKaspiLink)}
+		if err := h.redisClient.SaveUserState(ctx, order.UserID, st); err != nil {
+			h.logger.Warn("save user state to redis", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+type resendPaymentIn struct {
+	OrderID int64 `json:"order_id"`
+}
+
+// POST /api/admin/orders/resend-payment {order_id} — покупатель потерял сообщение
+// со ссылкой Kaspi или реквизитами. Управляющий — только для заказов своей точки.
+func (h *Handler) handleAdminResendPayment(w http.ResponseWriter, r *http.Request) {
+	scope, ok := h.storeAccess(r)
+	if !ok {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in resendPaymentIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	if in.OrderID <= 0 {
+		writeError(w, ErrBadRequest("order_id is required"))
+		return
+	}
+
+	order, err := h.orderRepo.Get(r.Context(), in.OrderID)
+	switch {
+	case errors.Is(err, repository.ErrOrderNotFound) || (err == nil && !scope.allows(order.StoreCode)):
+		writeError(w, ErrNotFound("order"))
+		return
+	case err != nil:
+		h.logger.Error("select order", zap.Int64("order_id", in.OrderID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	err = h.resendPaymentMessage(r.Context(), order)
+	if errors.Is(err, errPaymentNotDue) {
+		writeError(w, ErrConflict(fmt.Sprintf("order is %s, payment is not expected", order.Status)))
+		return
+	}
+	if err != nil {
+		h.logger.Warn("resend payment message", zap.Int64("order_id", in.OrderID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, map[string]any{"status": "ok", "order_id": order.ID, "payment_method": order.PaymentMethod})
+}
+
+// ResendPaymentCommandHandler — админ-команда /resend <order_id>: то же, что
+// POST /api/admin/orders/resend-payment, прямо из чата с ботом.
+func (h *Handler) ResendPaymentCommandHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil || update.Message.From == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	reply := func(text string) {
+		if _, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text}); err != nil {
+			h.logger.Warn("send /resend reply", zap.Error(err))
+		}
+	}
+	if !h.cfg.IsSuperAdmin(update.Message.From.ID) {
+		h.logger.Warn("SomeOne is trying to get admin root", zap.Any("user_id", update.Message.From.ID))
+		reply("⛔️ Команда доступна только администратору.")
+		return
+	}
+
+	const usage = "Использование: /resend <номер заказа>"
+	fields := strings.Fields(update.Message.Text)
+	if len(fields) != 2 {
+		reply(usage)
+		return
+	}
+	orderID, err := strconv.ParseInt(strings.TrimPrefix(fields[1], "№"), 10, 64)
+	if err != nil || orderID <= 0 {
+		reply("❌ Некорректный номер заказа.\n" + usage)
+		return
+	}
+
+	order, err := h.orderRepo.Get(ctx, orderID)
+	if errors.Is(err, repository.ErrOrderNotFound) {
+		reply(fmt.Sprintf("❌ Заказ №%d не найден.", orderID))
+		return
+	}
+	if err != nil {
+		h.logger.Error("select order", zap.Int64("order_id", orderID), zap.Error(err))
+		reply("⚠️ Не удалось загрузить заказ, попробуйте позже.")
+		return
+	}
+	err = h.resendPaymentMessage(ctx, order)
+	switch {
+	case errors.Is(err, errPaymentNotDue):
+		reply(fmt.Sprintf("ℹ️ Заказ №%d: %s — оплата не требуется.", orderID, humanOrderStatus(order.Status)))
+	case err != nil:
+		h.logger.Warn("resend payment message", zap.Int64("order_id", orderID), zap.Error(err))
+		reply("⚠️ Не удалось отправить сообщение покупателю.")
+	case order.PaymentMethod == paymentPending:
+		reply(fmt.Sprintf("✅ Покупателю снова отправлен выбор способа оплаты заказа №%d.", orderID))
+	default:
+		reply(fmt.Sprintf("✅ Инструкция по оплате заказа №%d отправлена покупателю (%s).", orderID, humanPaymentMethod(order.PaymentMethod)))
+	}
+}
+
+// PayCommandHandler — /pay у покупателя: прислать заново оплату последнего
+// неоплаченного заказа, чтобы не писать в поддержку.
+func (h *Handler) PayCommandHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil || update.Message.From == nil {
+		return
+	}
+	userID := update.Message.From.ID
+	reply := func(text string) {
+		if _, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{ChatID: update.Message.Chat.ID, Text: text}); err != nil {
+			h.logger.Warn("send /pay reply", zap.Error(err))
+		}
+	}
+
+	order, err := h.orderRepo.LatestByStatus(ctx, userID, payableOrderStatuses...)
+	if errors.Is(err, repository.ErrOrderNotFound) {
+		reply("✅ Неоплаченных заказов нет.")
+		return
+	}
+	if err == nil {
+		err = h.resendPaymentMessage(ctx, order)
+	}
+	if err != nil {
+		h.logger.Warn("resend payment by /pay", zap.Int64("user_id", userID), zap.Error(err))
+		reply("⚠️ Не удалось найти оплату заказа, попробуйте позже.")
+	}
+}
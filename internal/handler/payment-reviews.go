@@ -0,0 +1,233 @@
+// handler/payment-reviews.go
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+const (
+	paymentKindOrder        = "order"
+	paymentKindSubscription = "subscription"
+
+	// paymentReviewNagAge — чеки старше этого попадают в ежедневное напоминание админу
+	paymentReviewNagAge = 12 * time.Hour
+)
+
+// paymentReviewKeyboard — кнопки решения по чеку: те же callback'и, что и у
+// копии чека, которую бот присылает админу при получении.
+func paymentReviewKeyboard(kind string, targetID, userID int64) *models.InlineKeyboardMarkup {
+	ok, reject := "pay_ok", "pay_reject"
+	okText := "✅ Подтвердить оплату"
+	if kind == paymentKindSubscription {
+		ok, reject = "sub_ok", "sub_reject"
+		okText = "✅ Активировать подписку"
+	}
+	return &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{
+				{Text: okText, CallbackData: fmt.Sprintf("%s:%d:%d", ok, targetID, userID)},
+				{Text: "❌ Отклонить", CallbackData: fmt.Sprintf("%s:%d:%d", reject, targetID, userID)},
+			},
+		},
+	}
+}
+
+// recordPaymentReceipt запоминает присланный чек. Ошибку только логируем:
+// чек уже у админа, список ожидающих проверки — вспомогательный.
+func (h *Handler) recordPaymentReceipt(ctx context.Context, kind string, targetID, userID int64, fileID string, amount int64) {
+	_, err := h.db.ExecContext(ctx, `
+		INSERT INTO payments (kind, target_id, user_id, file_id, amount, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, kind, targetID, userID, fileID, amount, h.clock.Now().UTC())
+	if err != nil {
+		h.logger.Warn("record payment receipt", zap.String("kind", kind), zap.Int64("target_id", targetID), zap.Error(err))
+	}
+}
+
+type pendingPaymentOut struct {
+	PaymentID  int64     `json:"payment_id"`
+	Kind       string    `json:"kind"` // order | subscription
+	TargetID   int64     `json:"target_id"`
+	UserID     int64     `json:"user_id"`
+	Nickname   string    `json:"nickname"`
+	Phone      string    `json:"phone"`
+	Amount     int64     `json:"amount"`
+	ReceivedAt time.Time `json:"received_at"`
+	AgeMinutes int64     `json:"age_minutes"`
+	ResendURL  string    `json:"resend_url"`
+}
+
+// pendingPayments — последние чеки по заказам и подпискам, по которым админ
+// ещё не принял решение. paymentID > 0 — только этот чек.
+func (h *Handler) pendingPayments(ctx context.Context, paymentID int64) ([]pendingPaymentOut, error) {
+	query := `
+		SELECT p.id, p.kind, p.target_id, p.user_id,
+		       COALESCE(u.nickname, ''), COALESCE(s.phone, u.phone, ''),
+		       p.amount, p.created_at
+		FROM payments p
+		LEFT JOIN users u ON u.user_id = p.user_id
+		LEFT JOIN orders o ON p.kind = 'order' AND o.id = p.target_id
+		LEFT JOIN subscriptions s ON p.kind = 'subscription' AND s.id = p.target_id
+		WHERE p.id IN (SELECT MAX(id) FROM payments GROUP BY kind, target_id)
+		  AND (o.status IN ('new', 'checking', 'invoiced') OR s.status = 'pending')`
+	var args []any
+	if paymentID > 0 {
+		query += ` AND p.id = ?`
+		args = append(args, paymentID)
+	}
+	query += ` ORDER BY p.created_at, p.id`
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := h.clock.Now()
+	out := []pendingPaymentOut{}
+	for rows.Next() {
+		var p pendingPaymentOut
+		if err := rows.Scan(&p.PaymentID, &p.Kind, &p.TargetID, &p.UserID,
+			&p.Nickname, &p.Phone, &p.Amount, &p.ReceivedAt); err != nil {
+			return nil, err
+		}
+		p.AgeMinutes = int64(now.Sub(p.ReceivedAt) / time.Minute)
+		p.ResendURL = fmt.Sprintf("/api/admin/payments/resend?payment_id=%d", p.PaymentID)
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// paymentTargetLabel — «заказ №12» / «подписка №3» для текстов админу.
+func paymentTargetLabel(p pendingPaymentOut) string {
+	if p.Kind == paymentKindSubscription {
+		return fmt.Sprintf("подписка №%d", p.TargetID)
+	}
+	return fmt.Sprintf("заказ №%d", p.TargetID)
+}
+
+// GET /api/admin/payments/pending — чеки, которые ждут решения админа, от
+// самых старых: возраст, сумма, телефон покупателя и ссылка, чтобы бот
+// прислал чек ещё раз (сообщение с ним могло затеряться в чате).
+func (h *Handler) handleAdminPendingPayments(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	items, err := h.pendingPayments(r.Context(), 0)
+	if err != nil {
+		h.logger.Error("select pending payments", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, map[string]any{"items": items, "total": len(items)})
+}
+
+// POST /api/admin/payments/resend?payment_id=N (или {"payment_id": N}) — бот
+// заново присылает админу чек по file_id с кнопками решения.
+func (h *Handler) handleAdminResendReceipt(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in struct {
+		PaymentID int64 `json:"payment_id"`
+	}
+	if v := strings.TrimSpace(r.URL.Query().Get("payment_id")); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeError(w, ErrBadRequest("payment_id must be a number"))
+			return
+		}
+		in.PaymentID = id
+	} else if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	if in.PaymentID <= 0 {
+		writeError(w, ErrBadRequest("payment_id is required"))
+		return
+	}
+
+	var fileID string
+	err := h.db.QueryRowContext(r.Context(), `SELECT file_id FROM payments WHERE id = ?`, in.PaymentID).Scan(&fileID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, ErrNotFound("payment"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("select payment", zap.Int64("payment_id", in.PaymentID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	items, err := h.pendingPayments(r.Context(), in.PaymentID)
+	if err != nil {
+		h.logger.Error("select pending payment", zap.Int64("payment_id", in.PaymentID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	if len(items) == 0 {
+		writeError(w, ErrConflict("payment is already reviewed"))
+		return
+	}
+	if h.sender == nil {
+		writeError(w, ErrServiceUnavailable("bot is not connected"))
+		return
+	}
+
+	p := items[0]
+	caption := fmt.Sprintf("🔁 Чек на проверке (%s)\n\n👤 Пользователь: %s (ID: %d)\n📞 Телефон: %s\n💰 Сумма: %s\n🕒 Получен: %s",
+		paymentTargetLabel(p), firstNonEmpty(p.Nickname, "—"), p.UserID, firstNonEmpty(p.Phone, "—"),
+		formatMoney(p.Amount), p.ReceivedAt.In(display().loc).Format("02.01.2006 15:04"))
+	_, err = h.sender.SendDocument(r.Context(), &bot.SendDocumentParams{
+		ChatID:      h.auditActor(r),
+		Document:    &models.InputFileString{Data: fileID},
+		Caption:     caption,
+		ReplyMarkup: paymentReviewKeyboard(p.Kind, p.TargetID, p.UserID),
+	})
+	if err != nil {
+		h.logger.Warn("resend payment receipt", zap.Int64("payment_id", p.PaymentID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, map[string]any{"status": "ok", "payment_id": p.PaymentID})
+}
+
+// remindPendingReviews — часть ежедневной проверки: напоминает админу о чеках,
+// которые ждут решения дольше paymentReviewNagAge.
+func (h *Handler) remindPendingReviews(ctx context.Context) {
+	if h.db == nil {
+		return
+	}
+	items, err := h.pendingPayments(ctx, 0)
+	if err != nil {
+		h.logger.Warn("select pending payments for reminder", zap.Error(err))
+		return
+	}
+	var sb strings.Builder
+	n := 0
+	for _, p := range items {
+		if time.Duration(p.AgeMinutes)*time.Minute < paymentReviewNagAge {
+			continue
+		}
+		n++
+		fmt.Fprintf(&sb, "• %s — %s, %d ч\n", paymentTargetLabel(p), formatMoney(p.Amount), p.AgeMinutes/60)
+	}
+	if n == 0 {
+		return
+	}
+	h.notifyAdmin(fmt.Sprintf("⏰ Чеки ждут проверки дольше %d ч: %d\n\n%s\nСписок и повторная отправка чека — в админке, раздел «Оплаты».",
+		int(paymentReviewNagAge.Hours()), n, sb.String()))
+}
@@ -0,0 +1,211 @@
+// handler/price-drop.go
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+const (
+	priceDropOrderWindow = 30 * 24 * time.Hour // кому сообщаем: заказывали товар за этот срок
+	priceDropInterval    = 24 * time.Hour      // не чаще одного сообщения «Цены снизились» в сутки
+)
+
+// priceDrop — снижение цены товара при правке из админки.
+type priceDrop struct {
+	ProductID int64
+	OldPrice  int64
+	NewPrice  int64
+}
+
+// significant — цена упала не меньше чем на percent процентов.
+func (d priceDrop) significant(percent float64) bool {
+	return percent > 0 && d.OldPrice > 0 && d.NewPrice < d.OldPrice &&
+		float64(d.OldPrice-d.NewPrice)*100 >= percent*float64(d.OldPrice)
+}
+
+// priceDropLine — «🥔 Картофель 180→150 ₸/кг».
+func priceDropLine(name, emoji, pricePer string, d priceDrop) string {
+	old := strings.TrimSuffix(formatMoney(d.OldPrice), nbsp+"₸")
+	return fmt.Sprintf("%s %s→%s", strings.TrimSpace(emoji+" "+name), old, unitPrice(d.NewPrice, pricePer))
+}
+
+// notifyPriceDrops кладёт в notification_outbox сообщение «Цены снизились»
+// каждому, кто заказывал подешевевшие товары за priceDropOrderWindow: одно
+// сообщение на покупателя со всеми его товарами, не чаще раза в priceDropInterval,
+// без отписавшихся от рассылок (users.marketing_opt_out). Избранного в базе
+// нет — получатели только по заказам. Доставляет flushOutbox.
+func (h *Handler) notifyPriceDrops(ctx context.Context, drops []priceDrop) {
+	byProduct := map[int64]priceDrop{}
+	var args []any
+	for _, d := range drops {
+		if d.significant(h.cfg.PriceDropNotifyPercent) {
+			byProduct[d.ProductID] = d
+			args = append(args, d.ProductID)
+		}
+	}
+	if len(args) == 0 {
+		return
+	}
+
+	now := h.clock.Now().UTC()
+	dayAgo := now.Add(-priceDropInterval).Format(dbTimeLayout)
+	args = append(args, now.Add(-priceDropOrderWindow).Format(dbTimeLayout), dayAgo)
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT DISTINCT o.user_id, p.id, p.name, COALESCE(p.emoji, ''), COALESCE(p.price_per, '')
+		FROM order_items i
+		JOIN orders o ON o.id = i.order_id
+		JOIN users u ON u.user_id = o.user_id
+		JOIN products p ON p.id = i.product_id
+		WHERE p.id IN (?`+strings.Repeat(", ?", len(byProduct)-1)+`)
+		  AND p.active = 1
+		  AND o.created_at >= ?
+		  AND u.marketing_opt_out = 0
+		  AND (u.price_drop_notified_at IS NULL OR u.price_drop_notified_at < ?)
+		ORDER BY o.user_id, p.id
+	`, args...)
+	if err != nil {
+		h.logger.Error("select price drop recipients", zap.Error(err))
+		return
+	}
+	lines := map[int64][]string{}
+	var users []int64
+	for rows.Next() {
+		var (
+			userID, productID     int64
+			name, emoji, pricePer string
+		)
+		if err := rows.Scan(&userID, &productID, &name, &emoji, &pricePer); err != nil {
+			h.logger.Error("scan price drop recipient", zap.Error(err))
+			continue
+		}
+		if _, ok := lines[userID]; !ok {
+			users = append(users, userID)
+		}
+		lines[userID] = append(lines[userID], priceDropLine(name, emoji, pricePer, byProduct[productID]))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		h.logger.Error("select price drop recipients", zap.Error(err))
+		return
+	}
+
+	markup, err := json.Marshal(&models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{{
+			{Text: "🛒 В каталог", WebApp: &models.WebAppInfo{URL: strings.TrimRight(h.cfg.MiniAppUrl, "/") + "/catalog"}},
+		}},
+	})
+	if err != nil {
+		h.logger.Error("marshal price drop keyboard", zap.Error(err))
+		return
+	}
+	var queued int
+	for _, userID := range users {
+		text := "📉 Цены снизились:\n" + strings.Join(lines[userID], "\n")
+		ok, err := h.queuePriceDrop(ctx, userID, text, string(markup), now.Format(dbTimeLayout), dayAgo)
+		if err != nil {
+			h.logger.Warn("queue price drop", zap.Int64("user_id", userID), zap.Error(err))
+			continue
+		}
+		if ok {
+			queued++
+		}
+	}
+	h.logger.Info("price drop notifications queued",
+		zap.Int("products", len(byProduct)), zap.Int("users", queued))
+	if queued > 0 {
+		h.flushOutboxQuiet(ctx)
+	}
+}
+
+// queuePriceDrop в одной транзакции отмечает время сообщения у покупателя и
+// кладёт его в outbox. ok=false — за сутки уже сообщали (параллельная правка
+// цен успела раньше) или покупатель отписался.
+func (h *Handler) queuePriceDrop(ctx context.Context, userID int64, text, markup, now, dayAgo string) (ok bool, err error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE users SET price_drop_notified_at = ?
+		WHERE user_id = ? AND marketing_opt_out = 0
+		  AND (price_drop_notified_at IS NULL OR price_drop_notified_at < ?)
+	`, now, userID, dayAgo)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO notification_outbox (chat_id, text, reply_markup) VALUES (?, ?, ?)
+	`, userID, text, markup)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+type marketingIn struct {
+	TelegramID json.RawMessage `json:"telegram_id"`
+	OptOut     bool            `json:"opt_out"`
+}
+
+// POST /api/user/marketing {"telegram_id", "opt_out"} — отписаться от рассылок
+// (и «Цены снизились») или подписаться снова. Только за себя: X-Telegram-Id
+// должен совпадать с telegram_id.
+func (h *Handler) handleUserMarketing(w http.ResponseWriter, r *http.Request) {
+	var in marketingIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	tgID, err := parseTelegramID(in.TelegramID)
+	if err != nil || tgID <= 0 {
+		writeError(w, ErrBadRequest("telegram_id is required"))
+		return
+	}
+	caller, err := strconv.ParseInt(strings.TrimSpace(r.Header.Get("X-Telegram-Id")), 10, 64)
+	if err != nil {
+		writeError(w, ErrUnauthorized("X-Telegram-Id is required"))
+		return
+	}
+	if caller != tgID {
+		writeError(w, ErrForbidden())
+		return
+	}
+
+	optOut := 0
+	if in.OptOut {
+		optOut = 1
+	}
+	res, err := h.db.ExecContext(r.Context(), `UPDATE users SET marketing_opt_out = ? WHERE user_id = ?`, optOut, tgID)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n == 0 {
+			err = sql.ErrNoRows
+		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, ErrNotFound("user"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("update marketing opt-out", zap.Int64("user_id", tgID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	h.logger.Info("marketing opt-out changed", zap.Int64("user_id", tgID), zap.Bool("opt_out", in.OptOut))
+	jsonOK(w, map[string]any{"opt_out": in.OptOut})
+}
@@ -0,0 +1,266 @@
+// handler/price-handler.go
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// допустимый диапазон массового изменения цен, %
+const (
+	bulkPriceMinPercent = -50
+	bulkPriceMaxPercent = 100
+)
+
+type bulkPriceUpdateIn struct {
+	StoreCode    string  `json:"store_code"`
+	CategorySlug string  `json:"category_slug"` // пусто — все категории точки
+	DeltaPercent float64 `json:"delta_percent"`
+}
+
+// POST /api/admin/products/bulk-price-update — поднять/снизить цены активных
+// товаров точки (и категории) на delta_percent. Старые и новые цены пишутся
+// в product_price_history в той же транзакции, новые — в price_feed.
+func (h *Handler) handleAdminBulkPriceUpdate(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in bulkPriceUpdateIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	in.StoreCode = strings.TrimSpace(in.StoreCode)
+	in.CategorySlug = strings.TrimSpace(in.CategorySlug)
+	if in.StoreCode == "" {
+		writeError(w, ErrBadRequest("store_code is required"))
+		return
+	}
+	if in.DeltaPercent < bulkPriceMinPercent || in.DeltaPercent > bulkPriceMaxPercent || in.DeltaPercent == 0 {
+		writeError(w, ErrBadRequest("delta_percent must be non-zero and within [-50, 100]"))
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	updated, drops, err := bulkPriceByPercent(tx, in.StoreCode, in.CategorySlug, in.DeltaPercent, h.cfg.AdminID)
+	if err == nil {
+		err = h.writeAudit(tx, h.auditActor(r), "products.bulk_price", in.StoreCode, "", map[string]any{
+			"category_slug": in.CategorySlug,
+			"delta_percent": in.DeltaPercent,
+			"updated":       updated,
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Error("bulk price update", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	h.invalidateProducts()
+	go h.notifyPriceDrops(h.ctx, drops)
+	h.logger.Info("bulk price update",
+		zap.String("store", in.StoreCode), zap.String("category", in.CategorySlug),
+		zap.Float64("delta_percent", in.DeltaPercent), zap.Int64("updated", updated))
+	jsonOK(w, map[string]any{"updated": updated})
+}
+
+// bulkPriceByPercent меняет цены активных товаров точки (и категории) на percent
+// процентов. Старые и новые цены пишутся в product_price_history, новые — ещё
+// и в price_feed (график цены в карточке товара). При снижении возвращает
+// подешевевшие товары — для notifyPriceDrops.
+func bulkPriceByPercent(tx *sql.Tx, storeCode, category string, percent float64, adminID int64) (int64, []priceDrop, error) {
+	where := ` WHERE store_code = ? AND active = 1`
+	args := []any{storeCode}
+	if category != "" {
+		where += ` AND category_slug = ?`
+		args = append(args, category)
+	}
+
+	var drops []priceDrop
+	if percent < 0 {
+		rows, err := tx.Query(`
+			SELECT id, price, CAST(ROUND(price * (1 + ? / 100.0)) AS INTEGER)
+			FROM products`+where,
+			append([]any{percent}, args...)...)
+		if err != nil {
+			return 0, nil, err
+		}
+		for rows.Next() {
+			var d priceDrop
+			if err := rows.Scan(&d.ProductID, &d.OldPrice, &d.NewPrice); err != nil {
+				rows.Close()
+				return 0, nil, err
+			}
+			drops = append(drops, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	// сначала история (там ещё старая цена), затем сама цена — той же формулой
+	_, err := tx.Exec(`
+		INSERT INTO product_price_history (product_id, old_price, new_price, source, admin_id)
+		SELECT id, price, CAST(ROUND(price * (1 + ? / 100.0)) AS INTEGER), 'bulk', ?
+		FROM products`+where,
+		append([]any{percent, adminID}, args...)...)
+	if err != nil {
+		return 0, nil, err
+	}
+	res, err := tx.Exec(`
+		UPDATE products
+		SET price = CAST(ROUND(price * (1 + ? / 100.0)) AS INTEGER)`+where,
+		append([]any{percent}, args...)...)
+	if err != nil {
+		return 0, nil, err
+	}
+	updated, _ := res.RowsAffected()
+	_, err = tx.Exec(`INSERT INTO price_feed (product_id, price) SELECT id, price FROM products`+where, args...)
+	return updated, drops, err
+}
+
+type bulkPriceItem struct {
+	ProductID int64 `json:"product_id"`
+	Price     int64 `json:"price"`
+}
+
+type bulkPriceIn struct {
+	Category      string          `json:"category"`
+	StoreCode     string          `json:"store_code"`
+	PercentChange float64         `json:"percent_change"`
+	Prices        []bulkPriceItem `json:"prices"` // явные цены вместо процента
+}
+
+// POST /api/admin/products/bulk-price — сезонная смена цен одним запросом:
+// {category, store_code, percent_change} — все активные товары категории точки
+// на процент (в тех же пределах, что и bulk-price-update, так что цена не
+// уходит в минус), либо {prices: [{product_id, price}]} — явные цены (>= 0);
+// с store_code товары должны быть этой точки. Всё в одной транзакции, каждое
+// изменение пишется в price_feed и product_price_history.
+func (h *Handler) handleAdminBulkPrice(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in bulkPriceIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	in.Category = strings.TrimSpace(in.Category)
+	in.StoreCode = strings.TrimSpace(in.StoreCode)
+
+	switch {
+	case len(in.Prices) > 0 && in.PercentChange != 0:
+		writeError(w, ErrBadRequest("use either percent_change or prices"))
+		return
+	case len(in.Prices) == 0 && (in.Category == "" || in.StoreCode == ""):
+		writeError(w, ErrBadRequest("category and store_code are required"))
+		return
+	case len(in.Prices) == 0 && (in.PercentChange < bulkPriceMinPercent || in.PercentChange > bulkPriceMaxPercent || in.PercentChange == 0):
+		writeError(w, ErrBadRequest("percent_change must be non-zero and within [-50, 100]"))
+		return
+	}
+	seen := map[int64]bool{}
+	for _, it := range in.Prices {
+		switch {
+		case it.ProductID <= 0:
+			writeError(w, ErrBadRequest("product_id is required"))
+			return
+		case it.Price < 0:
+			writeError(w, ErrBadRequest("price must be >= 0").WithField("product_id", it.ProductID))
+			return
+		case seen[it.ProductID]:
+			writeError(w, ErrBadRequest("duplicate product_id").WithField("product_id", it.ProductID))
+			return
+		}
+		seen[it.ProductID] = true
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	adminID := h.auditActor(r)
+	var (
+		updated int64
+		drops   []priceDrop
+	)
+	if len(in.Prices) == 0 {
+		updated, drops, err = bulkPriceByPercent(tx, in.StoreCode, in.Category, in.PercentChange, adminID)
+	}
+	for _, it := range in.Prices {
+		var (
+			oldPrice int64
+			store    sql.NullString
+		)
+		err = tx.QueryRow(`SELECT price, store_code FROM products WHERE id = ?`, it.ProductID).Scan(&oldPrice, &store)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && in.StoreCode != "" && store.String != in.StoreCode) {
+			writeError(w, ErrNotFound("product").WithField("product_id", it.ProductID))
+			return
+		}
+		if err == nil && oldPrice != it.Price {
+			_, err = tx.Exec(`UPDATE products SET price = ? WHERE id = ?`, it.Price, it.ProductID)
+			if err == nil {
+				_, err = tx.Exec(`
+					INSERT INTO product_price_history (product_id, old_price, new_price, source, admin_id)
+					VALUES (?, ?, ?, 'bulk', ?)
+				`, it.ProductID, oldPrice, it.Price, adminID)
+			}
+			if err == nil {
+				_, err = tx.Exec(`INSERT INTO price_feed (product_id, price) VALUES (?, ?)`, it.ProductID, it.Price)
+			}
+			if err == nil {
+				updated++
+				if it.Price < oldPrice {
+					drops = append(drops, priceDrop{ProductID: it.ProductID, OldPrice: oldPrice, NewPrice: it.Price})
+				}
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = h.writeAudit(tx, adminID, "products.bulk_price", firstNonEmpty(in.StoreCode, "*"), "", map[string]any{
+			"category":       in.Category,
+			"percent_change": in.PercentChange,
+			"prices":         len(in.Prices),
+			"updated":        updated,
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Error("bulk price", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	h.invalidateProducts()
+	go h.notifyPriceDrops(h.ctx, drops)
+	h.logger.Info("bulk price",
+		zap.String("store", in.StoreCode), zap.String("category", in.Category),
+		zap.Float64("percent_change", in.PercentChange), zap.Int("prices", len(in.Prices)), zap.Int64("updated", updated))
+	jsonOK(w, map[string]any{"updated": updated})
+}
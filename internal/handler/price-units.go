@@ -0,0 +1,102 @@
+// handler/price-units.go
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// products.price_per — за что указана цена. Пусто — за unit товара, как было
+// до появления поля: qty × price без пересчёта.
+const (
+	pricePerKg     = "kg"
+	pricePer100g   = "100g"
+	pricePerPiece  = "piece"
+	pricePerBundle = "bundle"
+)
+
+// pricePerLabels — подпись к цене в чеке: «450 ₸/100 г».
+var pricePerLabels = map[string]string{
+	pricePerKg:     "кг",
+	pricePer100g:   "100 г",
+	pricePerPiece:  "шт",
+	pricePerBundle: "пучок",
+}
+
+// parsePricePer читает price_per из формы админки. set=false — поля в форме
+// нет: при редактировании товара текущее значение не трогаем.
+func parsePricePer(r *http.Request) (pricePer string, set bool, err error) {
+	if _, set = r.Form["price_per"]; !set {
+		return "", false, nil
+	}
+	pricePer = strings.TrimSpace(r.FormValue("price_per"))
+	if _, ok := pricePerLabels[pricePer]; pricePer != "" && !ok {
+		return "", true, errors.New("price_per must be one of kg, 100g, piece, bundle")
+	}
+	return pricePer, true, nil
+}
+
+// weightUnit — единица количества в позиции: "kg", "g" или "" (штуки, пучки,
+// «100 г» и всё остальное — количество уже в единицах цены).
+func weightUnit(unit string) string {
+	u := strings.NewReplacer(" ", "", ".", "").Replace(strings.ToLower(unit))
+	switch u {
+	case "кг", "kg":
+		return "kg"
+	case "г", "гр", "g", "грамм":
+		return "g"
+	}
+	return ""
+}
+
+// pricingQty — количество позиции в единицах цены: 0.3 кг при цене за 100 г —
+// это 3. Для цены за штуку/пучок и товаров без price_per — qty как есть.
+func pricingQty(it orderItemIn) float64 {
+	switch it.PricePer {
+	case pricePerKg:
+		if weightUnit(it.Unit) == "g" {
+			return it.Qty / 1000
+		}
+	case pricePer100g:
+		switch weightUnit(it.Unit) {
+		case "kg":
+			return it.Qty * 10
+		case "g":
+			return it.Qty / 100
+		}
+	}
+	return it.Qty
+}
+
+// unitPrice — «4 500 ₸/кг»; без price_per — просто сумма, как раньше.
+func unitPrice(price int64, pricePer string) string {
+	if label, ok := pricePerLabels[pricePer]; ok {
+		return formatMoney(price) + "/" + label
+	}
+	return formatMoney(price)
+}
+
+// qtyTimesPrice — количество и цена позиции для чека и уведомлений:
+// «0.30 кг × 4 500 ₸/кг», «3 × 450 ₸/100 г». Без price_per — «0.30 кг × 4 500 ₸».
+func qtyTimesPrice(it orderItemIn) string {
+	switch it.PricePer {
+	case pricePer100g, pricePerPiece, pricePerBundle:
+		qty := strconv.FormatFloat(pricingQty(it), 'f', 2, 64)
+		qty = strings.TrimSuffix(strings.TrimRight(qty, "0"), ".")
+		return fmt.Sprintf("%s × %s", qty, unitPrice(it.Price, it.PricePer))
+	}
+	return fmt.Sprintf("%.2f %s × %s", it.Qty, it.Unit, unitPrice(it.Price, it.PricePer))
+}
+
+// catalogPricePer подставляет в позиции заказа price_per товара из каталога:
+// от клиента единицу цены не принимаем, иначе сумму можно уменьшить в 10 раз.
+func catalogPricePer(items []orderItemIn, products map[int64]orderProduct) {
+	for i, it := range items {
+		if p, ok := products[it.ProductID]; ok {
+			items[i].PricePer = p.PricePer
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// handler/product-cache.go
+package handler
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// productsCacheTimeout — сколько ждём Redis; дальше читаем каталог из БД.
+const productsCacheTimeout = 200 * time.Millisecond
+
+// cachedProducts возвращает JSON каталога из Redis или nil (нет кэша / Redis недоступен).
+func (h *Handler) cachedProducts(ctx context.Context, key string) []byte {
+	if h.redisClient == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, productsCacheTimeout)
+	defer cancel()
+	data, err := h.redisClient.GetProductsCache(ctx, key)
+	if err != nil {
+		h.logger.Warn("products cache get", zap.Error(err))
+		return nil
+	}
+	return data
+}
+
+func (h *Handler) cacheProducts(ctx context.Context, key string, data []byte) {
+	if h.redisClient == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, productsCacheTimeout)
+	defer cancel()
+	if err := h.redisClient.SaveProductsCache(ctx, key, data); err != nil {
+		h.logger.Warn("products cache save", zap.Error(err))
+	}
+}
+
+// invalidateProducts сбрасывает кэш каталога после изменения товаров.
+// Ошибку Redis только логируем: кэш сам истечёт через ProductsCacheTTL.
+func (h *Handler) invalidateProducts() {
+	if h.redisClient == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(h.ctx, productsCacheTimeout)
+	defer cancel()
+	if err := h.redisClient.InvalidateProductsCache(ctx); err != nil {
+		h.logger.Warn("products cache invalidate", zap.Error(err))
+	}
+}
@@ -0,0 +1,95 @@
+// handler/product-emoji.go
+package handler
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	zeroWidthJoiner   = '\u200d'
+	variationSelector = '\ufe0f' // эмодзи-представление символа: ☕ → ☕️
+	keycapMark        = "\u20e3" // рамка клавиши: 1 + U+FE0F + U+20E3 = 1️⃣
+	emojiMaxJoins     = 3        // 👨‍👩‍👧‍👦 — три ZWJ; длиннее цепочки — уже не иконка товара
+	emojiMaxLen       = 32       // байт: с запасом на самую длинную ZWJ-последовательность
+)
+
+// emojiRanges — блоки Unicode, из которых берём иконку товара. Региональных
+// индикаторов (🇦…🇿, U+1F1E6–1F1FF) здесь нет: из пары таких букв
+// складывается флаг страны, флагами товары не подписываем.
+var emojiRanges = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00a9, Hi: 0x00ae, Stride: 5}, // © ®
+		{Lo: 0x2194, Hi: 0x2199, Stride: 1}, // ↔ ↕ ↖ ↗ ↘ ↙
+		{Lo: 0x21a9, Hi: 0x21aa, Stride: 1}, // ↩ ↪ (стрелки между ними — не эмодзи)
+		{Lo: 0x231a, Hi: 0x231b, Stride: 1}, // ⌚ ⌛
+		{Lo: 0x2600, Hi: 0x27bf, Stride: 1}, // Miscellaneous Symbols, Dingbats: ☕ ❤
+		{Lo: 0x2b50, Hi: 0x2b55, Stride: 1}, // ⭐ ⭕
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f170, Hi: 0x1f1e5, Stride: 1}, // Enclosed Alphanumeric Supplement без флагов: 🅰 🆕
+		{Lo: 0x1f300, Hi: 0x1f5ff, Stride: 1}, // Miscellaneous Symbols and Pictographs: 🍅 🌽
+		{Lo: 0x1f600, Hi: 0x1f64f, Stride: 1}, // Emoticons
+		{Lo: 0x1f680, Hi: 0x1f6ff, Stride: 1}, // Transport and Map Symbols
+		{Lo: 0x1f900, Hi: 0x1f9ff, Stride: 1}, // Supplemental Symbols and Pictographs: 🥔 🧀
+		{Lo: 0x1fa70, Hi: 0x1faff, Stride: 1}, // Symbols and Pictographs Extended-A: 🫐
+	},
+	LatinOffset: 1,
+}
+
+// emojiSkinTones — модификаторы оттенка кожи 🏻…🏿: допустимы после эмодзи.
+var emojiSkinTones = &unicode.RangeTable{
+	R32: []unicode.Range32{{Lo: 0x1f3fb, Hi: 0x1f3ff, Stride: 1}},
+}
+
+var errInvalidEmoji = errors.New("emoji must be a single emoji character")
+
+// isKeycap — эмодзи-клавиша: цифра, # или * и рамка U+20E3 (1️⃣ #️⃣).
+func isKeycap(s string) bool {
+	if s == "" || !strings.ContainsRune("0123456789#*", rune(s[0])) {
+		return false
+	}
+	rest := strings.TrimPrefix(s[1:], string(variationSelector))
+	return rest == keycapMark
+}
+
+// validateEmoji проверяет иконку товара из админки: ровно один эмодзи из
+// emojiRanges — с вариационным селектором, оттенком кожи или ZWJ-цепочкой
+// (👨‍🌾) не длиннее emojiMaxJoins — или клавиша (1️⃣). Буквы, цифры,
+// несколько эмодзи подряд, флаги и висячие ZWJ не проходят. Пустая строка —
+// иконки нет, это можно.
+func validateEmoji(s string) error {
+	if s == "" || isKeycap(s) {
+		return nil
+	}
+	if len(s) > emojiMaxLen || !utf8.ValidString(s) {
+		return errInvalidEmoji
+	}
+	joins := 0
+	afterJoiner := true // первый символ — как после ZWJ: должен быть эмодзи
+	for _, r := range s {
+		switch {
+		case unicode.Is(emojiRanges, r) && !unicode.Is(emojiSkinTones, r):
+			if !afterJoiner {
+				return errInvalidEmoji // второй эмодзи без ZWJ — это уже два
+			}
+			afterJoiner = false
+		case afterJoiner:
+			return errInvalidEmoji // ZWJ/модификатор в начале или ZWJ подряд
+		case r == zeroWidthJoiner:
+			if joins++; joins > emojiMaxJoins {
+				return errInvalidEmoji
+			}
+			afterJoiner = true
+		case r == variationSelector, unicode.Is(emojiSkinTones, r):
+		default:
+			return errInvalidEmoji
+		}
+	}
+	if afterJoiner {
+		return errInvalidEmoji // висячий ZWJ в конце
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// handler/product-margins.go
+package handler
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// parseCostPrice читает cost_price (закупочная цена у поставщика, ₸) из формы
+// админки: пусто — не задана (nil). set=false — поля в форме нет: при
+// редактировании товара текущее значение не трогаем.
+func parseCostPrice(r *http.Request) (costPrice *int64, set bool, err error) {
+	if _, set = r.Form["cost_price"]; !set {
+		return nil, false, nil
+	}
+	raw := strings.TrimSpace(r.FormValue("cost_price"))
+	if raw == "" {
+		return nil, true, nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || v < 0 {
+		return nil, true, errors.New("cost_price must be >= 0")
+	}
+	return &v, true, nil
+}
+
+// productMargin — валовая маржа товара: в тенге (price − cost_price) и в
+// процентах от цены с одним знаком. Без закупочной цены — nil; процент
+// при нулевой цене тоже nil.
+func productMargin(price int64, costPrice *int64) (kzt *int64, pct *float64) {
+	if costPrice == nil {
+		return nil, nil
+	}
+	m := price - *costPrice
+	if price <= 0 {
+		return &m, nil
+	}
+	p := roundPct(float64(m) * 100 / float64(price))
+	return &m, &p
+}
+
+// roundPct — процент с одним знаком после запятой.
+func roundPct(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+type categoryMarginOut struct {
+	Category     string  `json:"category"`
+	ProductCount int64   `json:"product_count"`  // товары с закупочной ценой
+	AvgMarginKZT float64 `json:"avg_margin_kzt"` // средняя маржа в тенге
+	AvgMarginPct float64 `json:"avg_margin_pct"` // средний процент маржи по товарам
+	MinMarginPct float64 `json:"min_margin_pct"` // самый «тонкий» товар категории
+	WithoutCost  int64   `json:"without_cost"`   // активные товары без cost_price — в среднее не вошли
+}
+
+// GET /api/admin/stats/margins?category=vegetables — средняя валовая маржа
+// активных товаров по категориям. Считаются только товары с cost_price и
+// ненулевой ценой; сколько товаров без закупочной цены — в without_cost.
+func (h *Handler) handleAdminMargins(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	where, args := "active = 1", []any{}
+	if cat := strings.TrimSpace(r.URL.Query().Get("category")); cat != "" {
+		where += " AND category_slug = ?"
+		args = append(args, cat)
+	}
+	rows, err := h.db.QueryContext(r.Context(), `
+		SELECT category_slug,
+		       COUNT(CASE WHEN cost_price IS NOT NULL AND price > 0 THEN 1 END),
+		       COALESCE(AVG(CASE WHEN cost_price IS NOT NULL AND price > 0 THEN price - cost_price END), 0),
+		       COALESCE(AVG(CASE WHEN cost_price IS NOT NULL AND price > 0 THEN (price - cost_price) * 100.0 / price END), 0),
+		       COALESCE(MIN(CASE WHEN cost_price IS NOT NULL AND price > 0 THEN (price - cost_price) * 100.0 / price END), 0),
+		       COUNT(CASE WHEN cost_price IS NULL THEN 1 END)
+		FROM products
+		WHERE `+where+`
+		GROUP BY category_slug
+		ORDER BY category_slug
+	`, args...)
+	if err != nil {
+		h.logger.Error("select category margins", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer rows.Close()
+
+	out := []categoryMarginOut{}
+	for rows.Next() {
+		var c categoryMarginOut
+		if err := rows.Scan(&c.Category, &c.ProductCount, &c.AvgMarginKZT, &c.AvgMarginPct, &c.MinMarginPct, &c.WithoutCost); err != nil {
+			h.logger.Error("scan category margin", zap.Error(err))
+			writeError(w, ErrInternal(err))
+			return
+		}
+		c.AvgMarginKZT = math.Round(c.AvgMarginKZT)
+		c.AvgMarginPct, c.MinMarginPct = roundPct(c.AvgMarginPct), roundPct(c.MinMarginPct)
+		out = append(out, c)
+	}
+	if err := rows.Err(); err != nil {
+		h.logger.Error("select category margins", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, out)
+}
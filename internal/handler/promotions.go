@@ -0,0 +1,518 @@
+// handler/promotions.go
+package handler
+
+import (
+	"agro/traits/database"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// promotion — акция: промо-цена на товар или на категорию (вместе с её
+// подкатегориями) в окне [StartsAt, EndsAt).
+type promotion struct {
+	ID         int64
+	ProductID  int64
+	Category   string
+	PromoPrice int64
+	Badge      string
+	StartsAt   time.Time
+	EndsAt     time.Time
+}
+
+func (p promotion) liveAt(now time.Time) bool {
+	return !now.Before(p.StartsAt) && now.Before(p.EndsAt)
+}
+
+// promotionSet — акции и родители категорий, чтобы акция на «Овощи»
+// действовала и на «Корнеплоды».
+type promotionSet struct {
+	promos  []promotion
+	parents map[string]string
+}
+
+// loadPromotions — акции, которые заканчиваются позже since (нулевое время —
+// все, включая прошедшие). Прошедшие акции отсеиваются здесь же,
+// поэтому таблицу не нужно чистить.
+func (h *Handler) loadPromotions(since time.Time) (promotionSet, error) {
+	set := promotionSet{parents: map[string]string{}}
+	query := `
+		SELECT id, COALESCE(product_id, 0), COALESCE(category_slug, ''), promo_price, COALESCE(badge, ''), starts_at, ends_at
+		FROM promotions`
+	var args []any
+	if !since.IsZero() {
+		query += ` WHERE ends_at > ?`
+		args = append(args, since.UTC())
+	}
+	rows, err := h.db.Query(query+` ORDER BY starts_at, id`, args...)
+	if err != nil {
+		return set, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p promotion
+		if err := rows.Scan(&p.ID, &p.ProductID, &p.Category, &p.PromoPrice, &p.Badge, &p.StartsAt, &p.EndsAt); err != nil {
+			return set, err
+		}
+		set.promos = append(set.promos, p)
+	}
+	if err := rows.Err(); err != nil {
+		return set, err
+	}
+	if len(set.promos) == 0 {
+		return set, nil
+	}
+
+	crows, err := h.db.Query(`SELECT slug, COALESCE(parent_slug, '') FROM categories`)
+	if err != nil {
+		return set, err
+	}
+	defer crows.Close()
+	for crows.Next() {
+		var slug, parent string
+		if err := crows.Scan(&slug, &parent); err != nil {
+			return set, err
+		}
+		if parent != "" {
+			set.parents[slug] = parent
+		}
+	}
+	return set, crows.Err()
+}
+
+// match — действующая акция для товара: акция на сам товар важнее акции на
+// категорию, та — важнее акции на родительскую категорию; из нескольких
+// на одном уровне — самая низкая цена. covered — на товар вообще заводили
+// акцию (пусть и не действующую сейчас): его цену в заказе считает сервер.
+func (s promotionSet) match(productID int64, category string, now time.Time) (live *promotion, covered bool) {
+	levels := []func(promotion) bool{
+		func(p promotion) bool { return productID != 0 && p.ProductID == productID },
+		func(p promotion) bool { return category != "" && p.Category == category },
+		func(p promotion) bool { return s.parents[category] != "" && p.Category == s.parents[category] },
+	}
+	for _, onLevel := range levels {
+		for i, p := range s.promos {
+			if !onLevel(p) {
+				continue
+			}
+			covered = true
+			if p.liveAt(now) && (live == nil || p.PromoPrice < live.PromoPrice) {
+				live = &s.promos[i]
+			}
+		}
+		if live != nil {
+			return live, true
+		}
+	}
+	return nil, covered
+}
+
+// applyPromotions проставляет товарам каталога промо-цену и ярлык.
+func (s promotionSet) applyPromotions(products []productOut, now time.Time) {
+	for i := range products {
+		p, _ := s.match(products[i].ID, products[i].Category, now)
+		if p == nil {
+			continue
+		}
+		price, ends := p.PromoPrice, p.EndsAt
+		products[i].PromoPrice, products[i].Badge, products[i].PromoEndsAt = &price, p.Badge, &ends
+	}
+}
+
+// promoOrderPrices ставит позициям заказа промо-цену действующей акции.
+// Время — серверное; базовую цену (без акции) уже поставил orderBasePrices,
+// так что цена закончившейся акции из корзины сюда не доходит.
+func (h *Handler) promoOrderPrices(items []orderItemIn, products map[int64]orderProduct) error {
+	set, err := h.loadPromotions(time.Time{})
+	if err != nil || len(set.promos) == 0 {
+		return err
+	}
+	now := h.clock.Now()
+	for i, it := range items {
+		p, ok := products[it.ProductID]
+		if !ok {
+			continue
+		}
+		if live, _ := set.match(it.ProductID, p.Category, now); live != nil {
+			items[i].Price = live.PromoPrice
+		}
+	}
+	return nil
+}
+
+// promoTimeLayouts — в каком виде админка присылает начало и конец акции.
+// Без часового пояса время считается в поясе TIMEZONE.
+var promoTimeLayouts = []string{"2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02 15:04:05"}
+
+// parsePromoTime разбирает время акции. Дата без времени — начало дня,
+// а для конца акции (endOfDay) — конец дня: «до 19.10» включает воскресенье.
+func parsePromoTime(s string, endOfDay bool) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	loc := display().loc
+	for _, layout := range promoTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range []string{"2006-01-02", "02.01.2006"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			if endOfDay {
+				t = t.AddDate(0, 0, 1)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time %q, expected YYYY-MM-DD or YYYY-MM-DDTHH:MM", s)
+}
+
+type promotionIn struct {
+	ID         int64   `json:"id"`
+	ProductID  *int64  `json:"product_id"`
+	Category   *string `json:"category"`
+	PromoPrice *int64  `json:"promo_price"`
+	Badge      *string `json:"badge"`
+	StartsAt   *string `json:"starts_at"`
+	EndsAt     *string `json:"ends_at"`
+}
+
+// merge накладывает переданные поля на акцию и проверяет результат.
+func (in promotionIn) merge(p promotion, now time.Time) (promotion, error) {
+	if in.ProductID != nil {
+		p.ProductID = *in.ProductID
+	}
+	if in.Category != nil {
+		p.Category = strings.TrimSpace(*in.Category)
+	}
+	if in.PromoPrice != nil {
+		p.PromoPrice = *in.PromoPrice
+	}
+	if in.Badge != nil {
+		p.Badge = strings.TrimSpace(*in.Badge)
+	}
+	if in.StartsAt != nil {
+		t, err := parsePromoTime(*in.StartsAt, false)
+		if err != nil {
+			return p, err
+		}
+		p.StartsAt = t
+	}
+	if p.StartsAt.IsZero() {
+		p.StartsAt = now
+	}
+	if in.EndsAt != nil {
+		t, err := parsePromoTime(*in.EndsAt, true)
+		if err != nil {
+			return p, err
+		}
+		p.EndsAt = t
+	}
+
+	switch {
+	case (p.ProductID > 0) == (p.Category != ""):
+		return p, errors.New("exactly one of product_id and category is required")
+	case p.PromoPrice <= 0:
+		return p, errors.New("promo_price must be > 0")
+	case p.EndsAt.IsZero():
+		return p, errors.New("ends_at is required")
+	case !p.EndsAt.After(p.StartsAt):
+		return p, errors.New("ends_at must be after starts_at")
+	}
+	p.StartsAt, p.EndsAt = p.StartsAt.UTC().Truncate(time.Second), p.EndsAt.UTC().Truncate(time.Second)
+	return p, nil
+}
+
+// checkPromotionTarget — акцию заводят на существующий товар; категорию
+// не проверяем, как и у товаров (category_slug — свободная строка).
+func (h *Handler) checkPromotionTarget(p promotion) *AppError {
+	if p.ProductID == 0 {
+		return nil
+	}
+	var one int
+	err := h.db.QueryRow(`SELECT 1 FROM products WHERE id = ?`, p.ProductID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound("product")
+	}
+	if err != nil {
+		h.logger.Error("select promotion product", zap.Int64("product_id", p.ProductID), zap.Error(err))
+		return ErrInternal(err)
+	}
+	return nil
+}
+
+type promotionOut struct {
+	ID         int64     `json:"id"`
+	ProductID  *int64    `json:"product_id"`
+	Category   string    `json:"category,omitempty"`
+	PromoPrice int64     `json:"promo_price"`
+	Badge      string    `json:"badge"`
+	StartsAt   time.Time `json:"starts_at"`
+	EndsAt     time.Time `json:"ends_at"`
+	Status     string    `json:"status"` // live | scheduled | expired
+}
+
+func newPromotionOut(p promotion, now time.Time) promotionOut {
+	out := promotionOut{
+		ID: p.ID, Category: p.Category, PromoPrice: p.PromoPrice, Badge: p.Badge,
+		StartsAt: p.StartsAt.In(display().loc), EndsAt: p.EndsAt.In(display().loc),
+		Status: "live",
+	}
+	if p.ProductID > 0 {
+		id := p.ProductID
+		out.ProductID = &id
+	}
+	switch {
+	case now.Before(p.StartsAt):
+		out.Status = "scheduled"
+	case !now.Before(p.EndsAt):
+		out.Status = "expired"
+	}
+	return out
+}
+
+// promotionsDigest — блок «Акции» для сводки в канал: действующие акции
+// с ценой и сроком. Нет акций — пустая строка, блок не выводится.
+func (h *Handler) promotionsDigest(now time.Time) (string, error) {
+	set, err := h.loadPromotions(now)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, p := range set.promos {
+		if !p.liveAt(now) {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteString("🔥 Акции\n")
+		}
+		var name, emoji string
+		var base int64
+		if p.ProductID > 0 {
+			_ = h.db.QueryRow(`SELECT name, COALESCE(emoji, ''), price FROM products WHERE id = ?`, p.ProductID).Scan(&name, &emoji, &base)
+			name = strings.TrimSpace(emoji + " " + firstNonEmpty(name, fmt.Sprintf("Товар #%d", p.ProductID)))
+		} else {
+			_ = h.db.QueryRow(`SELECT name FROM categories WHERE slug = ?`, p.Category).Scan(&name)
+			name = "Категория «" + firstNonEmpty(name, p.Category) + "»"
+		}
+		fmt.Fprintf(&b, "• %s — %s", name, formatMoney(p.PromoPrice))
+		if base > p.PromoPrice {
+			fmt.Fprintf(&b, " вместо %s", formatMoney(base))
+		}
+		if p.Badge != "" {
+			fmt.Fprintf(&b, " [%s]", p.Badge)
+		}
+		// ends_at не включается: акция «до 20.10 00:00» идёт по 19.10
+		fmt.Fprintf(&b, ", до %s\n", formatDate(p.EndsAt.Add(-time.Second)))
+	}
+	return b.String(), nil
+}
+
+// GET /api/admin/promotions — все акции (со статусом live/scheduled/expired)
+// и текст блока «Акции» для сводки в канал.
+func (h *Handler) handleAdminListPromotions(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	now := h.clock.Now()
+	set, err := h.loadPromotions(time.Time{})
+	if err != nil {
+		h.logger.Error("select promotions", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	items := make([]promotionOut, 0, len(set.promos))
+	for _, p := range set.promos {
+		items = append(items, newPromotionOut(p, now))
+	}
+	digest, err := h.promotionsDigest(now)
+	if err != nil {
+		h.logger.Error("promotions digest", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, map[string]any{"items": items, "digest": digest})
+}
+
+// POST /api/admin/promotions/add {product_id | category, promo_price, badge, starts_at, ends_at}
+// — starts_at не передан — акция начинается сразу.
+func (h *Handler) handleAdminAddPromotion(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in promotionIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	p, err := in.merge(promotion{}, h.clock.Now())
+	if err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
+	if err := h.checkPromotionTarget(p); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	p.ID, err = database.InsertID(r.Context(), tx, `
+		INSERT INTO promotions (product_id, category_slug, promo_price, badge, starts_at, ends_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, nullInt(p.ProductID), nullString(p.Category), p.PromoPrice, nullString(p.Badge), p.StartsAt, p.EndsAt)
+	if err == nil {
+		err = h.writeAudit(tx, h.auditActor(r), "promotion.add", fmt.Sprint(p.ID), "", in)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Error("add promotion", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	h.invalidateProducts()
+	jsonOK(w, map[string]any{"status": "ok", "id": p.ID})
+}
+
+// POST /api/admin/promotions/update {id, ...} — отсутствующие поля не меняются.
+func (h *Handler) handleAdminUpdatePromotion(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in promotionIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	if in.ID <= 0 {
+		writeError(w, ErrBadRequest("id is required"))
+		return
+	}
+
+	cur := promotion{ID: in.ID}
+	err := h.db.QueryRow(`
+		SELECT COALESCE(product_id, 0), COALESCE(category_slug, ''), promo_price, COALESCE(badge, ''), starts_at, ends_at
+		FROM promotions WHERE id = ?
+	`, in.ID).Scan(&cur.ProductID, &cur.Category, &cur.PromoPrice, &cur.Badge, &cur.StartsAt, &cur.EndsAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, ErrNotFound("promotion"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("select promotion", zap.Int64("id", in.ID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	// переключение товар ↔ категория: второе поле сбрасываем
+	if in.ProductID != nil && *in.ProductID > 0 && in.Category == nil {
+		cur.Category = ""
+	}
+	if in.Category != nil && strings.TrimSpace(*in.Category) != "" && in.ProductID == nil {
+		cur.ProductID = 0
+	}
+	p, err := in.merge(cur, h.clock.Now())
+	if err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
+	if err := h.checkPromotionTarget(p); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.Exec(`
+		UPDATE promotions
+		SET product_id = ?, category_slug = ?, promo_price = ?, badge = ?, starts_at = ?, ends_at = ?,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, nullInt(p.ProductID), nullString(p.Category), p.PromoPrice, nullString(p.Badge), p.StartsAt, p.EndsAt, p.ID)
+	if err != nil {
+		h.logger.Error("update promotion", zap.Int64("id", in.ID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, ErrNotFound("promotion"))
+		return
+	}
+	err = h.writeAudit(tx, h.auditActor(r), "promotion.update", fmt.Sprint(p.ID), "", in)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Error("update promotion", zap.Int64("id", in.ID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	h.invalidateProducts()
+	jsonOK(w, map[string]string{"status": "ok"})
+}
+
+// POST /api/admin/promotions/delete {id} — снять акцию досрочно.
+func (h *Handler) handleAdminDeletePromotion(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in promotionIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.ID <= 0 {
+		writeError(w, ErrBadRequest("id is required"))
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.Exec(`DELETE FROM promotions WHERE id = ?`, in.ID)
+	if err != nil {
+		h.logger.Error("delete promotion", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, ErrNotFound("promotion"))
+		return
+	}
+	err = h.writeAudit(tx, h.auditActor(r), "promotion.delete", fmt.Sprint(in.ID), "", nil)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Error("delete promotion", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	h.invalidateProducts()
+	jsonOK(w, map[string]string{"status": "ok"})
+}
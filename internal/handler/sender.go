@@ -0,0 +1,228 @@
+// handler/sender.go
+package handler
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+// Sender — то подмножество Bot API, которым пользуются потоки заказов и оплат.
+// Реализуется *bot.Bot, logSender (DRY_RUN) и RecordingSender (тесты).
+type Sender interface {
+	SendMessage(ctx context.Context, params *bot.SendMessageParams) (*models.Message, error)
+	CopyMessage(ctx context.Context, params *bot.CopyMessageParams) (*models.MessageID, error)
+	AnswerCallbackQuery(ctx context.Context, params *bot.AnswerCallbackQueryParams) (bool, error)
+	EditMessageReplyMarkup(ctx context.Context, params *bot.EditMessageReplyMarkupParams) (*models.Message, error)
+	EditMessageText(ctx context.Context, params *bot.EditMessageTextParams) (*models.Message, error)
+	EditMessageCaption(ctx context.Context, params *bot.EditMessageCaptionParams) (*models.Message, error)
+	SendLocation(ctx context.Context, params *bot.SendLocationParams) (*models.Message, error)
+	SendPhoto(ctx context.Context, params *bot.SendPhotoParams) (*models.Message, error)
+	SendDocument(ctx context.Context, params *bot.SendDocumentParams) (*models.Message, error)
+	SendVideo(ctx context.Context, params *bot.SendVideoParams) (*models.Message, error)
+	SendVideoNote(ctx context.Context, params *bot.SendVideoNoteParams) (*models.Message, error)
+	SendAudio(ctx context.Context, params *bot.SendAudioParams) (*models.Message, error)
+	AnswerInlineQuery(ctx context.Context, params *bot.AnswerInlineQueryParams) (bool, error)
+}
+
+var _ Sender = (*bot.Bot)(nil)
+
+// logSender ничего не отправляет в Telegram, только пишет в лог.
+// Используется в staging при DRY_RUN=true, чтобы не писать реальным пользователям.
+type logSender struct {
+	logger *zap.Logger
+}
+
+func NewLogSender(logger *zap.Logger) Sender {
+	return &logSender{logger: logger}
+}
+
+func (s *logSender) SendMessage(_ context.Context, p *bot.SendMessageParams) (*models.Message, error) {
+	s.logger.Info("dry-run send message", zap.Any("chat_id", p.ChatID), zap.String("text", p.Text))
+	return &models.Message{}, nil
+}
+
+func (s *logSender) CopyMessage(_ context.Context, p *bot.CopyMessageParams) (*models.MessageID, error) {
+	s.logger.Info("dry-run copy message", zap.Any("chat_id", p.ChatID), zap.Int("message_id", p.MessageID), zap.String("caption", p.Caption))
+	return &models.MessageID{}, nil
+}
+
+func (s *logSender) AnswerCallbackQuery(_ context.Context, p *bot.AnswerCallbackQueryParams) (bool, error) {
+	s.logger.Info("dry-run answer callback", zap.String("callback_id", p.CallbackQueryID), zap.String("text", p.Text))
+	return true, nil
+}
+
+func (s *logSender) EditMessageReplyMarkup(_ context.Context, p *bot.EditMessageReplyMarkupParams) (*models.Message, error) {
+	s.logger.Info("dry-run edit reply markup", zap.Any("chat_id", p.ChatID), zap.Int("message_id", p.MessageID))
+	return &models.Message{}, nil
+}
+
+func (s *logSender) EditMessageText(_ context.Context, p *bot.EditMessageTextParams) (*models.Message, error) {
+	s.logger.Info("dry-run edit message text", zap.Any("chat_id", p.ChatID), zap.Int("message_id", p.MessageID), zap.String("text", p.Text))
+	return &models.Message{}, nil
+}
+
+func (s *logSender) EditMessageCaption(_ context.Context, p *bot.EditMessageCaptionParams) (*models.Message, error) {
+	s.logger.Info("dry-run edit message caption", zap.Any("chat_id", p.ChatID), zap.Int("message_id", p.MessageID), zap.String("caption", p.Caption))
+	return &models.Message{}, nil
+}
+
+func (s *logSender) SendLocation(_ context.Context, p *bot.SendLocationParams) (*models.Message, error) {
+	s.logger.Info("dry-run send location", zap.Any("chat_id", p.ChatID), zap.Float64("lat", p.Latitude), zap.Float64("lng", p.Longitude))
+	return &models.Message{}, nil
+}
+
+func (s *logSender) SendPhoto(_ context.Context, p *bot.SendPhotoParams) (*models.Message, error) {
+	s.logger.Info("dry-run send photo", zap.Any("chat_id", p.ChatID), zap.String("caption", p.Caption))
+	return &models.Message{}, nil
+}
+
+func (s *logSender) SendDocument(_ context.Context, p *bot.SendDocumentParams) (*models.Message, error) {
+	s.logger.Info("dry-run send document", zap.Any("chat_id", p.ChatID), zap.String("caption", p.Caption))
+	return &models.Message{}, nil
+}
+
+func (s *logSender) SendVideo(_ context.Context, p *bot.SendVideoParams) (*models.Message, error) {
+	s.logger.Info("dry-run send video", zap.Any("chat_id", p.ChatID), zap.String("caption", p.Caption))
+	return &models.Message{}, nil
+}
+
+func (s *logSender) SendVideoNote(_ context.Context, p *bot.SendVideoNoteParams) (*models.Message, error) {
+	s.logger.Info("dry-run send video note", zap.Any("chat_id", p.ChatID))
+	return &models.Message{}, nil
+}
+
+func (s *logSender) SendAudio(_ context.Context, p *bot.SendAudioParams) (*models.Message, error) {
+	s.logger.Info("dry-run send audio", zap.Any("chat_id", p.ChatID), zap.String("caption", p.Caption))
+	return &models.Message{}, nil
+}
+
+func (s *logSender) AnswerInlineQuery(_ context.Context, p *bot.AnswerInlineQueryParams) (bool, error) {
+	s.logger.Info("dry-run answer inline query", zap.String("inline_query_id", p.InlineQueryID), zap.Int("results", len(p.Results)))
+	return true, nil
+}
+
+// RecordingSender запоминает все вызовы — для тестов.
+type RecordingSender struct {
+	mu        sync.Mutex
+	Messages  []*bot.SendMessageParams
+	Copies    []*bot.CopyMessageParams
+	Callbacks []*bot.AnswerCallbackQueryParams
+	Markups   []*bot.EditMessageReplyMarkupParams
+	Edits     []*bot.EditMessageTextParams
+	Captions  []*bot.EditMessageCaptionParams
+	Locations []*bot.SendLocationParams
+	Photos    []*bot.SendPhotoParams
+	Documents []*bot.SendDocumentParams
+	Videos    []*bot.SendVideoParams
+	Notes     []*bot.SendVideoNoteParams
+	Audios    []*bot.SendAudioParams
+	Inline    []*bot.AnswerInlineQueryParams
+}
+
+func (s *RecordingSender) SendMessage(_ context.Context, p *bot.SendMessageParams) (*models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Messages = append(s.Messages, p)
+	return &models.Message{ID: len(s.Messages)}, nil
+}
+
+func (s *RecordingSender) CopyMessage(_ context.Context, p *bot.CopyMessageParams) (*models.MessageID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Copies = append(s.Copies, p)
+	return &models.MessageID{ID: len(s.Copies)}, nil
+}
+
+func (s *RecordingSender) AnswerCallbackQuery(_ context.Context, p *bot.AnswerCallbackQueryParams) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Callbacks = append(s.Callbacks, p)
+	return true, nil
+}
+
+func (s *RecordingSender) EditMessageReplyMarkup(_ context.Context, p *bot.EditMessageReplyMarkupParams) (*models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Markups = append(s.Markups, p)
+	return &models.Message{}, nil
+}
+
+func (s *RecordingSender) EditMessageText(_ context.Context, p *bot.EditMessageTextParams) (*models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Edits = append(s.Edits, p)
+	return &models.Message{}, nil
+}
+
+func (s *RecordingSender) EditMessageCaption(_ context.Context, p *bot.EditMessageCaptionParams) (*models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Captions = append(s.Captions, p)
+	return &models.Message{}, nil
+}
+
+func (s *RecordingSender) SendLocation(_ context.Context, p *bot.SendLocationParams) (*models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Locations = append(s.Locations, p)
+	return &models.Message{}, nil
+}
+
+func (s *RecordingSender) SendPhoto(_ context.Context, p *bot.SendPhotoParams) (*models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Photos = append(s.Photos, p)
+	return &models.Message{ID: len(s.Photos)}, nil
+}
+
+func (s *RecordingSender) SendDocument(_ context.Context, p *bot.SendDocumentParams) (*models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Documents = append(s.Documents, p)
+	return &models.Message{ID: len(s.Documents)}, nil
+}
+
+func (s *RecordingSender) SendVideo(_ context.Context, p *bot.SendVideoParams) (*models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Videos = append(s.Videos, p)
+	return &models.Message{ID: len(s.Videos)}, nil
+}
+
+func (s *RecordingSender) SendVideoNote(_ context.Context, p *bot.SendVideoNoteParams) (*models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Notes = append(s.Notes, p)
+	return &models.Message{ID: len(s.Notes)}, nil
+}
+
+func (s *RecordingSender) SendAudio(_ context.Context, p *bot.SendAudioParams) (*models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Audios = append(s.Audios, p)
+	return &models.Message{ID: len(s.Audios)}, nil
+}
+
+func (s *RecordingSender) AnswerInlineQuery(_ context.Context, p *bot.AnswerInlineQueryParams) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Inline = append(s.Inline, p)
+	return true, nil
+}
+
+// MessagesTo возвращает тексты сообщений, отправленных в чат chatID.
+func (s *RecordingSender) MessagesTo(chatID int64) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for _, m := range s.Messages {
+		if id, ok := m.ChatID.(int64); ok && id == chatID {
+			out = append(out, m.Text)
+		}
+	}
+	return out
+}
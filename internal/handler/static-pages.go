@@ -0,0 +1,50 @@
+// handler/static-pages.go
+package handler
+
+import (
+	"agro/static"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// notFoundPageName — фирменная страница 404 среди страниц мини-аппа.
+const notFoundPageName = "404.html"
+
+// serveStatic отдаёт страницу мини-аппа из h.staticFS (вшитые страницы или
+// STATIC_DIR — каталог задаётся только там). Нет файла (в STATIC_DIR забыли
+// положить страницу) — фирменная 404 вместо ответа файлового сервера.
+func (h *Handler) serveStatic(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if st, err := fs.Stat(h.staticFS, name); err != nil || st.IsDir() {
+			h.logger.Warn("static page is missing", zap.String("page", name), zap.Error(err))
+			h.notFoundPage(w)
+			return
+		}
+		http.ServeFileFS(w, r, h.staticFS, name)
+	}
+}
+
+// handleNotFound — всё, что не совпало с маршрутами: для /api/ — 404 в формате
+// ошибок API, для остального — фирменная страница 404.
+func (h *Handler) handleNotFound(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeError(w, ErrNotFound("endpoint"))
+		return
+	}
+	h.notFoundPage(w)
+}
+
+// notFoundPage пишет страницу 404. В STATIC_DIR её может не быть — тогда
+// берётся вшитая.
+func (h *Handler) notFoundPage(w http.ResponseWriter) {
+	page, err := fs.ReadFile(h.staticFS, notFoundPageName)
+	if err != nil {
+		page, _ = fs.ReadFile(static.FS, notFoundPageName)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write(page)
+}
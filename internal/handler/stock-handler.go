@@ -0,0 +1,155 @@
+// handler/stock-handler.go
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+// сколько хранить уже отправленные уведомления о поступлении
+const stockNotificationTTL = 30 * 24 * time.Hour
+
+type notifyStockIn struct {
+	TelegramID json.RawMessage `json:"telegram_id"`
+	ProductID  int64           `json:"product_id"`
+}
+
+// handleNotifyStock — POST /api/user/notify-stock: «сообщить, когда появится».
+func (h *Handler) handleNotifyStock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, ErrMethodNotAllowed())
+		return
+	}
+	var in notifyStockIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	tgID, err := parseTelegramID(in.TelegramID)
+	if err != nil || in.ProductID <= 0 {
+		writeError(w, ErrBadRequest("telegram_id and product_id are required"))
+		return
+	}
+
+	var active int64
+	err = h.db.QueryRow(`SELECT active FROM products WHERE id = ?`, in.ProductID).Scan(&active)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && active == 0) {
+		writeError(w, ErrNotFound("product"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("select product for stock notification", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	// повторная подписка на тот же товар ничего не добавляет (частичный UNIQUE-индекс)
+	_, err = h.db.Exec(`
+		INSERT INTO stock_notifications (user_id, product_id) VALUES (?, ?) ON CONFLICT DO NOTHING
+	`, tgID, in.ProductID)
+	if err != nil {
+		h.logger.Error("insert stock notification", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, map[string]string{"status": "ok"})
+}
+
+// notifyBackInStock рассылает ожидающим пользователям сообщение о поступлении
+// товара и помечает их записи как отправленные.
+func (h *Handler) notifyBackInStock(ctx context.Context, productID int64) {
+	var name string
+	if err := h.db.QueryRow(`SELECT name FROM products WHERE id = ?`, productID).Scan(&name); err != nil {
+		h.logger.Error("select product for back-in-stock", zap.Int64("product_id", productID), zap.Error(err))
+		return
+	}
+
+	rows, err := h.db.Query(`
+		SELECT id, user_id FROM stock_notifications
+		WHERE product_id = ? AND notified_at IS NULL
+	`, productID)
+	if err != nil {
+		h.logger.Error("select stock notifications", zap.Error(err))
+		return
+	}
+	type waiter struct{ id, userID int64 }
+	var waiters []waiter
+	for rows.Next() {
+		var w waiter
+		if err := rows.Scan(&w.id, &w.userID); err != nil {
+			h.logger.Error("scan stock notification", zap.Error(err))
+			continue
+		}
+		waiters = append(waiters, w)
+	}
+	rows.Close()
+
+	now := h.clock.Now()
+	for _, w := range waiters {
+		_, err := h.sendOrQueue(ctx, &bot.SendMessageParams{
+			ChatID: w.userID,
+			Text:   fmt.Sprintf("✅ «%s» снова в наличии!", name),
+			ReplyMarkup: &models.InlineKeyboardMarkup{
+				InlineKeyboard: [][]models.InlineKeyboardButton{{
+					{Text: "🛒 Купить", WebApp: &models.WebAppInfo{URL: h.productDeepLink(productID)}},
+				}},
+			},
+		})
+		if err != nil {
+			// не помечаем — попробуем при следующем поступлении
+			h.logger.Warn("send back-in-stock", zap.Int64("user_id", w.userID), zap.Error(err))
+			continue
+		}
+		if _, err := h.db.Exec(`UPDATE stock_notifications SET notified_at = ? WHERE id = ?`, now, w.id); err != nil {
+			h.logger.Error("mark stock notification", zap.Error(err))
+		}
+	}
+
+	if _, err := h.db.Exec(`
+		DELETE FROM stock_notifications WHERE notified_at IS NOT NULL AND notified_at < ?
+	`, now.Add(-stockNotificationTTL)); err != nil {
+		h.logger.Warn("cleanup stock notifications", zap.Error(err))
+	}
+}
+
+// productDeepLink — ссылка на карточку товара в мини-приложении.
+func (h *Handler) productDeepLink(productID int64) string {
+	return fmt.Sprintf("%s/catalog?product=%d", strings.TrimRight(h.cfg.MiniAppUrl, "/"), productID)
+}
+
+// parseStockQty читает stock_qty из формы: пусто — не отслеживается (nil).
+func parseStockQty(r *http.Request) (*int64, error) {
+	raw := strings.TrimSpace(r.FormValue("stock_qty"))
+	if raw == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || v < 0 {
+		return nil, errors.New("stock_qty must be >= 0")
+	}
+	return &v, nil
+}
+
+// parseTelegramID принимает telegram_id и строкой, и числом.
+func parseTelegramID(raw json.RawMessage) (int64, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	}
+	var n int64
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,131 @@
+// handler/store-delivery.go
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// errDeliveryUnavailable — точка не доставляет, доступен только самовывоз.
+var errDeliveryUnavailable = errors.New("store does not deliver")
+
+// storeDelivery — настройки доставки точки (stores.delivers, stores.delivery_radius_km).
+type storeDelivery struct {
+	Delivers bool
+	RadiusKm sql.NullFloat64 // NULL — общий DELIVERY_RADIUS_KM
+}
+
+// radiusKm — радиус доставки точки; 0 — без ограничения по радиусу.
+func (d storeDelivery) radiusKm(defaultKm float64) float64 {
+	if d.RadiusKm.Valid {
+		return d.RadiusKm.Float64
+	}
+	return defaultKm
+}
+
+// radiusOut — радиус для ответа мини-аппу: nil, если ограничения нет.
+func (d storeDelivery) radiusOut(defaultKm float64) *float64 {
+	if km := d.radiusKm(defaultKm); km > 0 {
+		return &km
+	}
+	return nil
+}
+
+// storeDeliverySettings читает настройки доставки точки. Неизвестная точка
+// считается доставляющей с общим радиусом — как и до появления настроек.
+func (h *Handler) storeDeliverySettings(storeCode string) (storeDelivery, error) {
+	d := storeDelivery{Delivers: true}
+	if storeCode == "" {
+		return d, nil
+	}
+	err := h.db.QueryRow(`SELECT delivers, delivery_radius_km FROM stores WHERE code = ?`, storeCode).
+		Scan(&d.Delivers, &d.RadiusKm)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storeDelivery{Delivers: true}, nil
+	}
+	return d, err
+}
+
+// deliveryAppError — ответ мини-аппу, когда доставку оформить нельзя: машинный
+// код и suggest=pickup, чтобы предложить самовывоз. Прочие ошибки — nil.
+func deliveryAppError(err error) *AppError {
+	switch {
+	case errors.Is(err, errDeliveryUnavailable):
+		return ErrBadRequest(err.Error()).WithCode("delivery_unavailable").WithField("suggest", "pickup")
+	case errors.Is(err, errOutsideDeliveryZone):
+		return ErrBadRequest(err.Error()).WithCode("outside_delivery_zone").WithField("suggest", "pickup")
+	}
+	return nil
+}
+
+type setStoreDeliveryIn struct {
+	StoreCode        string   `json:"store_code"`
+	Delivers         *bool    `json:"delivers"`
+	DeliveryRadiusKm *float64 `json:"delivery_radius_km"` // null — общий DELIVERY_RADIUS_KM
+}
+
+// POST /api/admin/stores/delivery {store_code, delivers, delivery_radius_km} —
+// доставляет ли точка и в каком радиусе. Радиус действует, пока у точки нет
+// полигона зоны доставки (/api/admin/stores/zone).
+func (h *Handler) handleAdminSetStoreDelivery(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in setStoreDeliveryIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	in.StoreCode = strings.TrimSpace(in.StoreCode)
+	if in.StoreCode == "" || in.Delivers == nil {
+		writeError(w, ErrBadRequest("store_code and delivers are required"))
+		return
+	}
+	if in.DeliveryRadiusKm != nil && *in.DeliveryRadiusKm <= 0 {
+		writeError(w, ErrBadRequest("delivery_radius_km must be positive"))
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.Exec(`UPDATE stores SET delivers = ?, delivery_radius_km = ? WHERE code = ?`,
+		*in.Delivers, in.DeliveryRadiusKm, in.StoreCode)
+	if err != nil {
+		h.logger.Error("update store delivery", zap.String("store", in.StoreCode), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, ErrNotFound("store"))
+		return
+	}
+	err = h.writeAudit(tx, h.auditActor(r), "store.delivery_set", in.StoreCode, "", map[string]any{
+		"delivers":           *in.Delivers,
+		"delivery_radius_km": in.DeliveryRadiusKm,
+	})
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Error("save store delivery", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, map[string]any{
+		"store_code":         in.StoreCode,
+		"delivers":           *in.Delivers,
+		"delivery_radius_km": in.DeliveryRadiusKm,
+	})
+}
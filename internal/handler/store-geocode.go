@@ -0,0 +1,70 @@
+// handler/store-geocode.go
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// storeGeocodePause — пауза между запросами к геокодеру, чтобы не упереться в лимит Яндекса.
+const storeGeocodePause = 200 * time.Millisecond
+
+// BackfillStoreCoords один раз при старте геокодирует точки с адресом, но без
+// координат: их заводили до появления stores.longitude/latitude или без
+// YANDEX_API_KEY. Без координат у точки не работают радиус доставки и навигация.
+func (h *Handler) BackfillStoreCoords(ctx context.Context) {
+	if h.cfg.YandexAPIKey == "" {
+		return
+	}
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT code, address FROM stores
+		WHERE address IS NOT NULL AND TRIM(address) <> '' AND longitude IS NULL
+		ORDER BY id
+	`)
+	if err != nil {
+		h.logger.Error("select stores without coords", zap.Error(err))
+		return
+	}
+	type store struct{ code, address string }
+	var stores []store
+	for rows.Next() {
+		var s store
+		if err := rows.Scan(&s.code, &s.address); err != nil {
+			h.logger.Error("scan store without coords", zap.Error(err))
+			continue
+		}
+		stores = append(stores, s)
+	}
+	rows.Close()
+
+	filled := 0
+	for i, s := range stores {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(storeGeocodePause):
+			}
+		}
+		lng, lat, formatted, err := h.geocodeAddress(s.address)
+		if err != nil || !validCoords(lat, lng) {
+			h.logger.Warn("geocode store", zap.String("store", s.code), zap.String("address", s.address), zap.Error(err))
+			continue
+		}
+		_, err = h.db.ExecContext(ctx, `
+			UPDATE stores SET longitude = ?, latitude = ?, address_formatted = COALESCE(address_formatted, ?)
+			WHERE code = ? AND longitude IS NULL
+		`, lng, lat, sql.NullString{String: formatted, Valid: formatted != ""}, s.code)
+		if err != nil {
+			h.logger.Error("save store coords", zap.String("store", s.code), zap.Error(err))
+			continue
+		}
+		filled++
+	}
+	if len(stores) > 0 {
+		h.logger.Info("store coords backfilled", zap.Int("stores", len(stores)), zap.Int("filled", filled))
+	}
+}
@@ -0,0 +1,207 @@
+// handler/store-manager.go
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// storeScope — доступ к админским ручкам заказов и товаров. Суперадмин
+// (ADMIN_ID, ADMIN_IDS) видит все точки, управляющий из store_managers — только свои.
+type storeScope struct {
+	root   bool
+	stores []string
+}
+
+// allows — можно ли работать с заказом точки store.
+func (s storeScope) allows(store string) bool {
+	return s.root || slices.Contains(s.stores, store)
+}
+
+// storeAccess определяет права запроса; ok=false — не админ и не управляющий.
+// Остальные админские ручки по-прежнему проверяют isAdminRequest (только суперадмины).
+func (h *Handler) storeAccess(r *http.Request) (storeScope, bool) {
+	if h.isAdminRequest(r) {
+		return storeScope{root: true}, true
+	}
+	tgID, err := strconv.ParseInt(strings.TrimSpace(r.Header.Get("X-Telegram-Id")), 10, 64)
+	if err != nil || tgID <= 0 {
+		return storeScope{}, false
+	}
+	stores, err := h.managedStores(tgID)
+	if err != nil {
+		h.logger.Error("select store managers", zap.Error(err))
+		return storeScope{}, false
+	}
+	return storeScope{stores: stores}, len(stores) > 0
+}
+
+// canManageOrder — может ли пользователь Telegram менять статус заказа кнопками:
+// суперадмин — любой заказ, управляющий — заказы своей точки.
+func (h *Handler) canManageOrder(tgID, orderID int64) bool {
+	if h.cfg.IsSuperAdmin(tgID) {
+		return true
+	}
+	var store sql.NullString
+	if err := h.db.QueryRow(`SELECT store_code FROM orders WHERE id = ?`, orderID).Scan(&store); err != nil || !store.Valid {
+		return false
+	}
+	stores, err := h.managedStores(tgID)
+	if err != nil {
+		h.logger.Error("select store managers", zap.Error(err))
+		return false
+	}
+	return slices.Contains(stores, store.String)
+}
+
+// canEditProduct — может ли scope менять товар id: управляющий — только товары
+// своей точки. Товар без точки меняет только суперадмин. Нет товара — sql.ErrNoRows.
+func (h *Handler) canEditProduct(scope storeScope, id int64) (bool, error) {
+	if scope.root {
+		return true, nil
+	}
+	var store sql.NullString
+	if err := h.db.QueryRow(`SELECT store_code FROM products WHERE id = ?`, id).Scan(&store); err != nil {
+		return false, err
+	}
+	return store.Valid && scope.allows(store.String), nil
+}
+
+func (h *Handler) managedStores(tgID int64) ([]string, error) {
+	rows, err := h.db.Query(`SELECT store_code FROM store_managers WHERE telegram_id = ? ORDER BY store_code`, tgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		out = append(out, code)
+	}
+	return out, rows.Err()
+}
+
+// ======================== ADMIN: STORE MANAGERS ========================
+
+type storeManagerIn struct {
+	StoreCode  string `json:"store_code"`
+	TelegramID int64  `json:"telegram_id"`
+}
+
+// GET /api/admin/store-managers — список управляющих точек.
+func (h *Handler) handleAdminListStoreManagers(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	rows, err := h.db.Query(`
+		SELECT m.store_code, m.telegram_id, COALESCE(u.nickname, ''), m.created_at
+		FROM store_managers m
+		LEFT JOIN users u ON u.user_id = m.telegram_id
+		ORDER BY m.store_code, m.telegram_id
+	`)
+	if err != nil {
+		h.logger.Error("select store managers", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer rows.Close()
+
+	type managerOut struct {
+		StoreCode  string    `json:"store_code"`
+		TelegramID int64     `json:"telegram_id"`
+		Nickname   string    `json:"nickname"`
+		CreatedAt  time.Time `json:"created_at"`
+	}
+	out := []managerOut{}
+	for rows.Next() {
+		var m managerOut
+		if err := rows.Scan(&m.StoreCode, &m.TelegramID, &m.Nickname, &m.CreatedAt); err != nil {
+			h.logger.Error("scan store manager", zap.Error(err))
+			continue
+		}
+		out = append(out, m)
+	}
+	jsonOK(w, out)
+}
+
+// POST /api/admin/store-managers/add — назначить управляющего точки.
+func (h *Handler) handleAdminAddStoreManager(w http.ResponseWriter, r *http.Request) {
+	in, ok := h.decodeStoreManager(w, r)
+	if !ok {
+		return
+	}
+	var cnt int
+	_ = h.db.QueryRow(`SELECT COUNT(1) FROM stores WHERE code = ?`, in.StoreCode).Scan(&cnt)
+	if cnt == 0 {
+		writeError(w, ErrBadRequest("store not found"))
+		return
+	}
+	h.changeStoreManager(w, in, "store_manager.add", `
+		INSERT INTO store_managers (store_code, telegram_id) VALUES (?, ?)
+		ON CONFLICT(store_code, telegram_id) DO NOTHING
+	`)
+}
+
+// POST /api/admin/store-managers/delete — снять управляющего с точки.
+func (h *Handler) handleAdminDeleteStoreManager(w http.ResponseWriter, r *http.Request) {
+	in, ok := h.decodeStoreManager(w, r)
+	if !ok {
+		return
+	}
+	h.changeStoreManager(w, in, "store_manager.delete",
+		`DELETE FROM store_managers WHERE store_code = ? AND telegram_id = ?`)
+}
+
+func (h *Handler) decodeStoreManager(w http.ResponseWriter, r *http.Request) (storeManagerIn, bool) {
+	var in storeManagerIn
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return in, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return in, false
+	}
+	in.StoreCode = strings.TrimSpace(in.StoreCode)
+	if in.StoreCode == "" || in.TelegramID <= 0 {
+		writeError(w, ErrBadRequest("store_code and telegram_id are required"))
+		return in, false
+	}
+	return in, true
+}
+
+func (h *Handler) changeStoreManager(w http.ResponseWriter, in storeManagerIn, action, query string) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(query, in.StoreCode, in.TelegramID)
+	if err == nil {
+		err = h.writeAudit(tx, h.cfg.AdminID, action, fmt.Sprint(in.TelegramID), "", in)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Error(action, zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, map[string]string{"status": "ok"})
+}
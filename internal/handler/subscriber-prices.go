@@ -0,0 +1,114 @@
+// handler/subscriber-prices.go
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// lockedPriceText — что видит покупатель без подписки вместо оптовой цены.
+const lockedPriceText = "🔒 Оптовая цена — по подписке"
+
+// isSubscriber — у пользователя из X-Telegram-Id действует подписка
+// (та же логика, что у /api/user/subscription-status, включая льготный период).
+// Без заголовка — гость.
+func (h *Handler) isSubscriber(r *http.Request) bool {
+	return h.hasSubscription(r.Header.Get("X-Telegram-Id"))
+}
+
+// hasSubscription — то же для Telegram ID из тела запроса (заказы).
+func (h *Handler) hasSubscription(tgid string) bool {
+	if tgid = strings.TrimSpace(tgid); tgid == "" {
+		return false
+	}
+	sub, err := h.subscriptionState(tgid)
+	if err != nil {
+		h.logger.Warn("subscription state for prices", zap.String("telegram_id", tgid), zap.Error(err))
+		return false
+	}
+	return sub.active
+}
+
+// orderBasePrices ставит позициям заказа цену из каталога, а не из корзины:
+// подписчику — price, остальным — retail_price, если она задана (как в
+// guestPrices). Акции — поверх, в promoOrderPrices. Товар subscriber_only без
+// подписки, удалённый или скрытый товар заказать нельзя — appErr.
+func orderBasePrices(items []orderItemIn, products map[int64]orderProduct, subscriber bool) *AppError {
+	var locked, missing []string
+	for i, it := range items {
+		if it.ProductID <= 0 {
+			continue
+		}
+		p, ok := products[it.ProductID]
+		switch {
+		case !ok || !p.Active:
+			missing = append(missing, it.Name)
+		case subscriber:
+			items[i].Price = p.Price
+		case p.SubscriberOnly:
+			locked = append(locked, it.Name)
+		case p.Retail.Valid:
+			items[i].Price = p.Retail.Int64
+		default:
+			items[i].Price = p.Price
+		}
+	}
+	switch {
+	case len(missing) > 0:
+		return ErrBadRequest(fmt.Sprintf("products are no longer available: %s", strings.Join(missing, ", "))).
+			WithCode("items_unavailable").
+			WithField("items", missing)
+	case len(locked) > 0:
+		msg := fmt.Sprintf("products are available to subscribers only: %s", strings.Join(locked, ", "))
+		return (&AppError{Code: http.StatusForbidden, Message: msg}).
+			WithCode("subscription_required").
+			WithField("items", locked)
+	}
+	return nil
+}
+
+// guestPrices — каталог для покупателя без подписки: у товаров с retail_price
+// показываем розничную цену, у subscriber_only цену скрываем совсем. В обоих
+// случаях locked=true и текст «по подписке» вместо оптовой цены. Цену акции
+// на товар только для подписчиков гостю тоже не показываем.
+func guestPrices(products []productOut) []productOut {
+	out := make([]productOut, len(products))
+	for i, p := range products {
+		switch {
+		case p.SubscriberOnly:
+			p.Price, p.RetailPrice, p.PromoPrice = 0, nil, nil
+			p.Locked, p.LockedText = true, lockedPriceText
+		case p.RetailPrice != nil:
+			p.Price = *p.RetailPrice
+			p.Locked, p.LockedText = true, lockedPriceText
+		}
+		out[i] = p
+	}
+	return out
+}
+
+// parseSubscriberPricing читает из формы админки subscriber_only=1 и retail_price
+// (пусто — розничной цены нет, гости видят price). onlySet/retailSet=false —
+// поля в форме нет: при редактировании товара текущее значение не трогаем.
+func parseSubscriberPricing(r *http.Request) (subscriberOnly int64, onlySet bool, retail *int64, retailSet bool, err error) {
+	if _, onlySet = r.Form["subscriber_only"]; onlySet && strings.TrimSpace(r.FormValue("subscriber_only")) == "1" {
+		subscriberOnly = 1
+	}
+	if _, retailSet = r.Form["retail_price"]; !retailSet {
+		return subscriberOnly, onlySet, nil, false, nil
+	}
+	raw := strings.TrimSpace(r.FormValue("retail_price"))
+	if raw == "" {
+		return subscriberOnly, onlySet, nil, true, nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || v < 0 {
+		return 0, onlySet, nil, true, errors.New("retail_price must be >= 0")
+	}
+	return subscriberOnly, onlySet, &v, true, nil
+}
@@ -0,0 +1,141 @@
+// handler/subscription-gift.go
+package handler
+
+import (
+	"agro/traits/database"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-telegram/bot"
+	"go.uber.org/zap"
+)
+
+// subscriptionGiftMaxMonths — дольше года подарок не оформляем: это почти наверняка опечатка.
+const subscriptionGiftMaxMonths = 12
+
+type subscriptionGiftIn struct {
+	FromUserID int64 `json:"from_user_id"`
+	ToUserID   int64 `json:"to_user_id"`
+	Months     int   `json:"months"` // по умолчанию 1
+}
+
+// POST /api/admin/subscriptions/gift {"from_user_id", "to_user_id", "months"} —
+// подарочная подписка (розыгрыши, корпоративные подарки). Получателю создаётся
+// активная подписка на months месяцев без оплаты; если у него уже есть
+// действующая, срок добавляется к её окончанию. Подписка дарителя не меняется.
+// Оба получают сообщение, подарок пишется в журнал действий.
+func (h *Handler) handleAdminGiftSubscription(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in subscriptionGiftIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	if in.Months == 0 {
+		in.Months = 1
+	}
+	switch {
+	case in.FromUserID <= 0 || in.ToUserID <= 0:
+		writeError(w, ErrBadRequest("from_user_id and to_user_id are required"))
+		return
+	case in.FromUserID == in.ToUserID:
+		writeError(w, ErrBadRequest("from_user_id and to_user_id must differ"))
+		return
+	case in.Months < 1 || in.Months > subscriptionGiftMaxMonths:
+		writeError(w, ErrBadRequest(fmt.Sprintf("months must be between 1 and %d", subscriptionGiftMaxMonths)))
+		return
+	}
+
+	// не пересекаемся с подтверждением оплаты подписки получателя
+	unlock, ok := h.lockUserHTTP(w, r, fmt.Sprint(in.ToUserID))
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// оба пользователя должны быть в базе; ник получателя — для сообщения дарителю
+	names := map[int64]string{}
+	for _, id := range []int64{in.FromUserID, in.ToUserID} {
+		var name string
+		err := tx.QueryRow(`SELECT COALESCE(nickname, '') FROM users WHERE user_id = ?`, id).Scan(&name)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, ErrNotFound("user").WithField("user_id", id))
+			return
+		}
+		if err != nil {
+			h.logger.Error("select gift user", zap.Int64("user_id", id), zap.Error(err))
+			writeError(w, ErrInternal(err))
+			return
+		}
+		names[id] = name
+	}
+
+	start, err := h.renewalStart(tx, in.ToUserID, 0)
+	if err != nil {
+		h.logger.Error("select current subscription", zap.Int64("user_id", in.ToUserID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	validUntil := start.AddDate(0, in.Months, 0)
+
+	subID, err := database.InsertID(r.Context(), tx, `
+		INSERT INTO subscriptions (user_id, status, amount, paid_at, valid_until)
+		VALUES (?, 'active', 0, ?, ?)
+	`, in.ToUserID, h.clock.Now(), validUntil)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE users SET sub_status = 'active', sub_until = ? WHERE user_id = ?`, validUntil, in.ToUserID)
+	}
+	if err == nil {
+		err = h.writeAudit(tx, h.auditActor(r), "subscription.gift", fmt.Sprint(in.ToUserID), "", map[string]any{
+			"from_user_id":    in.FromUserID,
+			"months":          in.Months,
+			"subscription_id": subID,
+			"valid_until":     validUntil.Format("2006-01-02"),
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Error("gift subscription", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	h.emitWebhook(webhookSubscriptionActivated, webhookSubscription{
+		SubscriptionID: subID,
+		UserID:         in.ToUserID,
+		ValidUntil:     validUntil.UTC(),
+		Source:         "gift",
+	})
+
+	period := fmt.Sprintf("%d %s", in.Months, ruPlural(in.Months, "месяц", "месяца", "месяцев"))
+	h.sendOrQueueQuiet(h.ctx, &bot.SendMessageParams{
+		ChatID: in.ToUserID,
+		Text:   fmt.Sprintf("🎁 Вам подарена подписка АГРО Клуб на %s! Доступ до: %s.", period, formatDate(validUntil)),
+	}, "subscription gift recipient")
+	h.sendOrQueueQuiet(h.ctx, &bot.SendMessageParams{
+		ChatID: in.FromUserID,
+		Text:   fmt.Sprintf("🎁 Подписка АГРО Клуб на %s подарена пользователю %s.", period, firstNonEmpty(names[in.ToUserID], fmt.Sprint(in.ToUserID))),
+	}, "subscription gift sender")
+
+	jsonOK(w, map[string]any{
+		"status":          "ok",
+		"subscription_id": subID,
+		"valid_until":     validUntil.Format("2006-01-02"),
+	})
+}
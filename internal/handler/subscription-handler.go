@@ -0,0 +1,360 @@
+// handler/subscription-handler.go
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"go.uber.org/zap"
+)
+
+type adminSetSubscriptionIn struct {
+	UserID     int64  `json:"user_id"`
+	Status     string `json:"status"`      // active | inactive
+	ValidUntil string `json:"valid_until"` // 2024-12-31, обязательно для active
+	Reason     string `json:"reason"`
+}
+
+// handleAdminSetSubscription — ручная активация/отключение подписки из админки
+// (подарок, возврат). Пишет запись в audit_log.
+func (h *Handler) handleAdminSetSubscription(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, ErrMethodNotAllowed())
+		return
+	}
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+
+	var in adminSetSubscriptionIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	in.Status = strings.ToLower(strings.TrimSpace(in.Status))
+	in.Reason = strings.TrimSpace(in.Reason)
+	if in.UserID <= 0 {
+		writeError(w, ErrBadRequest("user_id is required"))
+		return
+	}
+
+	var validUntil time.Time
+	switch in.Status {
+	case "active":
+		d, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(in.ValidUntil), time.Local)
+		if err != nil {
+			writeError(w, ErrBadRequest("valid_until must be YYYY-MM-DD"))
+			return
+		}
+		// подписка действует до конца указанного дня
+		validUntil = d.AddDate(0, 0, 1).Add(-time.Second)
+		if !validUntil.After(h.clock.Now()) {
+			writeError(w, ErrBadRequest("valid_until must be in the future"))
+			return
+		}
+	case "inactive":
+	default:
+		writeError(w, ErrBadRequest("status must be active or inactive"))
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var exists int
+	_ = tx.QueryRow(`SELECT COUNT(1) FROM users WHERE user_id = ?`, in.UserID).Scan(&exists)
+	if exists == 0 {
+		writeError(w, ErrNotFound("user"))
+		return
+	}
+
+	if in.Status == "active" {
+		_, err = tx.Exec(`
+			INSERT INTO subscriptions (user_id, status, amount, paid_at, valid_until)
+			VALUES (?, 'active', 0, ?, ?)
+		`, in.UserID, h.clock.Now(), validUntil)
+		if err == nil {
+			_, err = tx.Exec(`UPDATE users SET sub_status = 'active', sub_until = ? WHERE user_id = ?`, validUntil, in.UserID)
+		}
+	} else {
+		_, err = tx.Exec(`UPDATE subscriptions SET status = 'cancelled' WHERE user_id = ? AND status IN ('active', 'grace')`, in.UserID)
+		if err == nil {
+			_, err = tx.Exec(`UPDATE users SET sub_status = 'inactive', sub_until = NULL WHERE user_id = ?`, in.UserID)
+		}
+	}
+	if err != nil {
+		h.logger.Error("admin set subscription", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	if err := h.writeAudit(tx, h.auditActor(r), "subscription.set", fmt.Sprint(in.UserID), in.Reason, in); err != nil {
+		h.logger.Error("write audit log", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("tx commit", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	if in.Status == "active" {
+		h.emitWebhook(webhookSubscriptionActivated, webhookSubscription{
+			UserID:     in.UserID,
+			ValidUntil: validUntil.UTC(),
+			Source:     "admin",
+		})
+	}
+
+	text := "ℹ️ Ваша подписка на «АГРО Клуб Оптовых Цен» отключена администратором."
+	if in.Status == "active" {
+		text = fmt.Sprintf("🎁 Администратор активировал вашу подписку.\nДоступ к оптовым ценам до: %s.",
+			formatDate(validUntil))
+	}
+	h.sendOrQueueQuiet(h.ctx, &bot.SendMessageParams{ChatID: in.UserID, Text: text}, "manual subscription notice")
+
+	out := map[string]any{"status": "ok", "sub_status": in.Status}
+	if in.Status == "active" {
+		out["valid_until"] = validUntil.Format("2006-01-02")
+	}
+	jsonOK(w, out)
+}
+
+// errSubscriptionNotPending — подписка уже обработана (например, повторное нажатие sub_ok).
+var errSubscriptionNotPending = errors.New("subscription is not pending")
+
+// activateSubscription переводит pending-подписку в active на месяц.
+// Если у пользователя уже есть действующая подписка, новый месяц добавляется
+// к её окончанию, а не отсчитывается от текущего момента.
+// Повторный вызов для той же подписки возвращает errSubscriptionNotPending.
+// d — админ, подтвердивший чек: пишется в подписку и в audit_log.
+func (h *Handler) activateSubscription(ctx context.Context, subID, userID int64, d paymentDecider) (time.Time, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	start, err := h.renewalStart(tx, userID, subID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	validUntil := start.AddDate(0, 1, 0) // +1 месяц
+
+	res, err := tx.Exec(`
+		UPDATE subscriptions
+		SET status = 'active', valid_until = ?,
+		    decided_by = ?, decided_by_name = ?, decided_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = 'pending'
+	`, validUntil, nullInt(d.ID), nullString(d.Name), subID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return time.Time{}, errSubscriptionNotPending
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE users
+		SET sub_status = 'active', sub_until = ?
+		WHERE user_id = ?
+	`, validUntil, fmt.Sprint(userID)); err != nil {
+		return time.Time{}, err
+	}
+	if err := h.writeAudit(tx, d.ID, "subscription.payment_confirmed", fmt.Sprint(subID), "", map[string]any{"admin": d.Name}); err != nil {
+		return time.Time{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, err
+	}
+	h.emitWebhook(webhookSubscriptionActivated, webhookSubscription{
+		SubscriptionID: subID,
+		UserID:         userID,
+		ValidUntil:     validUntil.UTC(),
+		Source:         "payment",
+	})
+	return validUntil, nil
+}
+
+// renewalStart — с какого момента считать новый срок подписки пользователя:
+// конец действующей подписки (кроме exceptID), если она есть, иначе сейчас.
+func (h *Handler) renewalStart(tx *sql.Tx, userID, exceptID int64) (time.Time, error) {
+	now := h.clock.Now()
+	var current sql.NullTime
+	err := tx.QueryRow(`
+		SELECT valid_until FROM subscriptions
+		WHERE user_id = ? AND status = 'active' AND id != ? AND valid_until IS NOT NULL
+		ORDER BY valid_until DESC
+		LIMIT 1
+	`, userID, exceptID).Scan(&current)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, err
+	}
+	if current.Valid && current.Time.After(now) {
+		return current.Time, nil
+	}
+	return now, nil
+}
+
+// subscriptionStatusText — ответ админу, если подписку уже обработали
+// (другим админом — с его именем).
+func (h *Handler) subscriptionStatusText(subID, presser int64) string {
+	var (
+		status     string
+		validUntil sql.NullTime
+		by         sql.NullInt64
+		name       sql.NullString
+	)
+	err := h.db.QueryRow(`
+		SELECT status, valid_until, decided_by, decided_by_name FROM subscriptions WHERE id = ?
+	`, subID).Scan(&status, &validUntil, &by, &name)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return "Подписка не найдена"
+	case err != nil:
+		return "Подписка уже обработана"
+	case status == "active" && validUntil.Valid:
+		return decidedByOtherText(fmt.Sprintf("Подписка уже активна до %s", formatDate(validUntil.Time)), by, name, presser)
+	default:
+		return decidedByOtherText(fmt.Sprintf("Подписка уже обработана (статус: %s)", status), by, name, presser)
+	}
+}
+
+const (
+	adminSubsDefaultLimit = 50
+	adminSubsMaxLimit     = 200
+)
+
+// handleAdminListSubscriptions — история подписок для админки:
+// GET /api/admin/subscriptions?status=pending&user_id=&from=2025-01-01&to=2025-01-31&sort=created_at&limit=50&offset=0
+// from/to — даты создания (UTC, включительно); sort=valid_until — по окончанию, раньше — выше.
+func (h *Handler) handleAdminListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	q := r.URL.Query()
+
+	where := []string{"1=1"}
+	var args []any
+	if s := strings.TrimSpace(q.Get("status")); s != "" {
+		where = append(where, "s.status = ?")
+		args = append(args, s)
+	}
+	if v := strings.TrimSpace(q.Get("user_id")); v != "" {
+		uid, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeError(w, ErrBadRequest("user_id must be a number"))
+			return
+		}
+		where = append(where, "s.user_id = ?")
+		args = append(args, uid)
+	}
+	for _, p := range []struct {
+		name, op string
+		days     int
+	}{{"from", ">=", 0}, {"to", "<", 1}} {
+		v := strings.TrimSpace(q.Get(p.name))
+		if v == "" {
+			continue
+		}
+		d, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			writeError(w, ErrBadRequest(p.name+" must be YYYY-MM-DD"))
+			return
+		}
+		where = append(where, "s.created_at "+p.op+" ?")
+		args = append(args, d.AddDate(0, 0, p.days).Format("2006-01-02 15:04:05"))
+	}
+
+	order := "s.created_at DESC, s.id DESC"
+	switch q.Get("sort") {
+	case "", "created_at":
+	case "valid_until":
+		order = "s.valid_until IS NULL, s.valid_until ASC, s.id"
+	default:
+		writeError(w, ErrBadRequest("sort must be created_at or valid_until"))
+		return
+	}
+
+	limit := adminSubsDefaultLimit
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		limit = min(v, adminSubsMaxLimit)
+	}
+	offset := 0
+	if v, err := strconv.Atoi(q.Get("offset")); err == nil && v > 0 {
+		offset = v
+	}
+
+	cond := strings.Join(where, " AND ")
+	var total int64
+	if err := h.db.QueryRow(`SELECT COUNT(1) FROM subscriptions s WHERE `+cond, args...).Scan(&total); err != nil {
+		h.logger.Error("count subscriptions", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	rows, err := h.db.Query(`
+		SELECT s.id, s.user_id, COALESCE(u.nickname, ''), COALESCE(u.phone, s.phone, ''),
+		       COALESCE(s.invoice_no, ''), s.amount, s.status, s.valid_until, s.created_at
+		FROM subscriptions s
+		LEFT JOIN users u ON u.user_id = s.user_id
+		WHERE `+cond+`
+		ORDER BY `+order+`
+		LIMIT ? OFFSET ?
+	`, append(args, limit, offset)...)
+	if err != nil {
+		h.logger.Error("list subscriptions", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer rows.Close()
+
+	type subscriptionOut struct {
+		ID         int64      `json:"id"`
+		UserID     int64      `json:"user_id"`
+		Nickname   string     `json:"nickname"`
+		Phone      string     `json:"phone"`
+		InvoiceNo  string     `json:"invoice_no"`
+		Amount     int64      `json:"amount"`
+		Status     string     `json:"status"`
+		ValidUntil *time.Time `json:"valid_until"`
+		CreatedAt  time.Time  `json:"created_at"`
+	}
+	items := []subscriptionOut{}
+	for rows.Next() {
+		var (
+			s          subscriptionOut
+			validUntil sql.NullTime
+		)
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Nickname, &s.Phone, &s.InvoiceNo, &s.Amount, &s.Status, &validUntil, &s.CreatedAt); err != nil {
+			h.logger.Error("scan subscription", zap.Error(err))
+			continue
+		}
+		if validUntil.Valid {
+			s.ValidUntil = &validUntil.Time
+		}
+		items = append(items, s)
+	}
+	jsonOK(w, map[string]any{
+		"items":  items,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
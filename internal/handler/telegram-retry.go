@@ -0,0 +1,302 @@
+// handler/telegram-retry.go
+package handler
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+const (
+	telegramMaxRetries      = 3                      // повторы одного вызова после 429/5xx
+	telegramRetryBaseDelay  = 500 * time.Millisecond // пауза после 5xx, удваивается
+	telegramRetryMaxWait    = 30 * time.Second       // retry_after больше этого не ждём — вызов не должен висеть
+	telegramChatInterval    = time.Second            // Telegram: не больше сообщения в секунду в один чат
+	telegramBreakerFailures = 5                      // столько вызовов подряд упали — считаем, что Telegram лежит
+	telegramBreakerCooldown = time.Minute            // сколько не ходим в Telegram после размыкания
+)
+
+// errTelegramUnavailable — предохранитель разомкнут: Telegram недавно не
+// отвечал, вызов не делали. sendOrQueue в этом случае кладёт сообщение в outbox.
+var errTelegramUnavailable = errors.New("telegram is unavailable, circuit breaker is open")
+
+// telegram5xx — так go-telegram/bot оформляет ответы с кодом, для которого у
+// него нет отдельной ошибки (500, 502, 504…).
+var telegram5xx = regexp.MustCompile(`error response from telegram for method \S+, 5\d\d `)
+
+// retrySender — обёртка над Sender для настоящего бота: повторяет вызов после
+// 429 (ждёт retry_after) и 5xx/сетевых ошибок (экспоненциальная пауза),
+// выдерживает интервал между сообщениями в один чат и размыкает предохранитель,
+// если Telegram падает раз за разом. Места вызова об этом не знают.
+type retrySender struct {
+	next   Sender
+	logger *zap.Logger
+
+	maxRetries   int
+	baseDelay    time.Duration
+	chatInterval time.Duration
+	failures     int
+	cooldown     time.Duration
+	now          func() time.Time
+	sleep        func(ctx context.Context, d time.Duration) error
+	onRecover    func() // предохранитель снова замкнулся — можно разбирать outbox
+
+	chatMu   sync.Mutex
+	chatNext map[int64]time.Time // чат → когда можно отправлять следующее сообщение
+
+	mu        sync.Mutex
+	failed    int       // вызовов подряд упали с 429/5xx/сетью
+	openUntil time.Time // до этого момента предохранитель разомкнут
+	probing   bool      // после cooldown пробный вызов уже идёт — остальные ждут его исхода
+
+	retried, failedCalls, shortCircuited, breakerOpens atomic.Int64
+}
+
+var _ Sender = (*retrySender)(nil)
+
+func newRetrySender(next Sender, logger *zap.Logger) *retrySender {
+	return &retrySender{
+		next:         next,
+		logger:       logger,
+		maxRetries:   telegramMaxRetries,
+		baseDelay:    telegramRetryBaseDelay,
+		chatInterval: telegramChatInterval,
+		failures:     telegramBreakerFailures,
+		cooldown:     telegramBreakerCooldown,
+		now:          time.Now,
+		sleep:        sleepCtx,
+		chatNext:     map[int64]time.Time{},
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryDelay — сколько ждать перед повтором; ok=false — ошибка не временная
+// (400, 403, чат удалён…), повторять бессмысленно.
+func (s *retrySender) retryDelay(err error, attempt int) (time.Duration, bool) {
+	var tooMany *bot.TooManyRequestsError
+	if errors.As(err, &tooMany) {
+		wait := time.Duration(max(tooMany.RetryAfter, 1)) * time.Second
+		return wait, wait <= telegramRetryMaxWait
+	}
+	if transientTelegramError(err) {
+		return s.baseDelay << attempt, true
+	}
+	return 0, false
+}
+
+// transientTelegramError — 5xx от Telegram или сеть: стоит повторить позже.
+func transientTelegramError(err error) bool {
+	var netErr net.Error
+	return telegram5xx.MatchString(err.Error()) || errors.As(err, &netErr)
+}
+
+// allow — можно ли сейчас идти в Telegram. По истечении cooldown пропускает
+// один вызов на пробу (probe=true): удачный замкнёт предохранитель, неудачный
+// снова разомкнёт. Пока проба идёт, остальные вызовы не пропускаются.
+func (s *retrySender) allow() (ok, probe bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.openUntil.IsZero():
+		return true, false
+	case s.now().Before(s.openUntil), s.probing:
+		return false, false
+	}
+	s.probing = true
+	return true, true
+}
+
+// endProbe освобождает место пробного вызова при любом исходе, в том числе
+// если вызов отменён до ответа Telegram: иначе проб больше не было бы.
+func (s *retrySender) endProbe() {
+	s.mu.Lock()
+	s.probing = false
+	s.mu.Unlock()
+}
+
+// record учитывает исход вызова для предохранителя.
+func (s *retrySender) record(err error) {
+	s.mu.Lock()
+	if err == nil || !(bot.IsTooManyRequestsError(err) || transientTelegramError(err)) {
+		recovered := !s.openUntil.IsZero()
+		s.failed, s.openUntil = 0, time.Time{}
+		s.mu.Unlock()
+		if recovered {
+			s.logger.Info("telegram is back, circuit breaker closed")
+			if s.onRecover != nil {
+				go s.onRecover()
+			}
+		}
+		return
+	}
+	s.failed++
+	open := s.failed >= s.failures
+	if open {
+		s.openUntil = s.now().Add(s.cooldown)
+	}
+	s.mu.Unlock()
+	if open {
+		s.breakerOpens.Add(1)
+		s.logger.Warn("telegram keeps failing, circuit breaker opened",
+			zap.Duration("cooldown", s.cooldown), zap.Error(err))
+	}
+}
+
+// waitChat выдерживает интервал между сообщениями в один чат.
+func (s *retrySender) waitChat(ctx context.Context, chatID any) error {
+	var id int64
+	switch v := chatID.(type) {
+	case int64:
+		id = v
+	case int:
+		id = int64(v)
+	default:
+		return nil // @username и прочее — не ограничиваем
+	}
+	s.chatMu.Lock()
+	now := s.now()
+	at := s.chatNext[id]
+	if at.Before(now) {
+		at = now
+	}
+	s.chatNext[id] = at.Add(s.chatInterval)
+	for k, v := range s.chatNext {
+		if v.Before(now) {
+			delete(s.chatNext, k)
+		}
+	}
+	s.chatMu.Unlock()
+	return s.sleep(ctx, at.Sub(now))
+}
+
+// telegramCall выполняет вызов Telegram с повторами. chatID != nil — вызов
+// отправляет сообщение в чат и подчиняется интервалу между сообщениями.
+func telegramCall[T any](ctx context.Context, s *retrySender, method string, chatID any, fn func() (T, error)) (T, error) {
+	var zero T
+	ok, probe := s.allow()
+	if !ok {
+		s.shortCircuited.Add(1)
+		return zero, errTelegramUnavailable
+	}
+	if probe {
+		defer s.endProbe()
+	}
+	if chatID != nil {
+		if err := s.waitChat(ctx, chatID); err != nil {
+			return zero, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		res, err := fn()
+		if err == nil {
+			s.record(nil)
+			return res, nil
+		}
+		wait, retry := s.retryDelay(err, attempt)
+		if !retry || attempt >= s.maxRetries {
+			s.record(err)
+			s.failedCalls.Add(1)
+			return zero, err
+		}
+		s.retried.Add(1)
+		s.logger.Warn("telegram call failed, retrying", zap.String("method", method),
+			zap.Int("attempt", attempt+1), zap.Duration("delay", wait), zap.Error(err))
+		if serr := s.sleep(ctx, wait); serr != nil {
+			s.failedCalls.Add(1)
+			return zero, err
+		}
+	}
+}
+
+// stats — счётчики для мониторинга.
+func (s *retrySender) stats() map[string]any {
+	s.mu.Lock()
+	state := "closed"
+	if !s.openUntil.IsZero() {
+		state = "open"
+		if !s.now().Before(s.openUntil) {
+			state = "half_open"
+		}
+	}
+	s.mu.Unlock()
+	return map[string]any{
+		"retried":         s.retried.Load(),
+		"failed":          s.failedCalls.Load(),
+		"short_circuited": s.shortCircuited.Load(),
+		"breaker_opens":   s.breakerOpens.Load(),
+		"breaker_state":   state,
+	}
+}
+
+func (s *retrySender) SendMessage(ctx context.Context, p *bot.SendMessageParams) (*models.Message, error) {
+	return telegramCall(ctx, s, "sendMessage", p.ChatID, func() (*models.Message, error) { return s.next.SendMessage(ctx, p) })
+}
+
+func (s *retrySender) CopyMessage(ctx context.Context, p *bot.CopyMessageParams) (*models.MessageID, error) {
+	return telegramCall(ctx, s, "copyMessage", p.ChatID, func() (*models.MessageID, error) { return s.next.CopyMessage(ctx, p) })
+}
+
+func (s *retrySender) AnswerCallbackQuery(ctx context.Context, p *bot.AnswerCallbackQueryParams) (bool, error) {
+	return telegramCall(ctx, s, "answerCallbackQuery", nil, func() (bool, error) { return s.next.AnswerCallbackQuery(ctx, p) })
+}
+
+func (s *retrySender) EditMessageReplyMarkup(ctx context.Context, p *bot.EditMessageReplyMarkupParams) (*models.Message, error) {
+	return telegramCall(ctx, s, "editMessageReplyMarkup", nil, func() (*models.Message, error) { return s.next.EditMessageReplyMarkup(ctx, p) })
+}
+
+func (s *retrySender) EditMessageText(ctx context.Context, p *bot.EditMessageTextParams) (*models.Message, error) {
+	return telegramCall(ctx, s, "editMessageText", nil, func() (*models.Message, error) { return s.next.EditMessageText(ctx, p) })
+}
+
+func (s *retrySender) EditMessageCaption(ctx context.Context, p *bot.EditMessageCaptionParams) (*models.Message, error) {
+	return telegramCall(ctx, s, "editMessageCaption", nil, func() (*models.Message, error) { return s.next.EditMessageCaption(ctx, p) })
+}
+
+func (s *retrySender) SendLocation(ctx context.Context, p *bot.SendLocationParams) (*models.Message, error) {
+	return telegramCall(ctx, s, "sendLocation", p.ChatID, func() (*models.Message, error) { return s.next.SendLocation(ctx, p) })
+}
+
+func (s *retrySender) SendPhoto(ctx context.Context, p *bot.SendPhotoParams) (*models.Message, error) {
+	return telegramCall(ctx, s, "sendPhoto", p.ChatID, func() (*models.Message, error) { return s.next.SendPhoto(ctx, p) })
+}
+
+func (s *retrySender) SendDocument(ctx context.Context, p *bot.SendDocumentParams) (*models.Message, error) {
+	return telegramCall(ctx, s, "sendDocument", p.ChatID, func() (*models.Message, error) { return s.next.SendDocument(ctx, p) })
+}
+
+func (s *retrySender) SendVideo(ctx context.Context, p *bot.SendVideoParams) (*models.Message, error) {
+	return telegramCall(ctx, s, "sendVideo", p.ChatID, func() (*models.Message, error) { return s.next.SendVideo(ctx, p) })
+}
+
+func (s *retrySender) SendVideoNote(ctx context.Context, p *bot.SendVideoNoteParams) (*models.Message, error) {
+	return telegramCall(ctx, s, "sendVideoNote", p.ChatID, func() (*models.Message, error) { return s.next.SendVideoNote(ctx, p) })
+}
+
+func (s *retrySender) SendAudio(ctx context.Context, p *bot.SendAudioParams) (*models.Message, error) {
+	return telegramCall(ctx, s, "sendAudio", p.ChatID, func() (*models.Message, error) { return s.next.SendAudio(ctx, p) })
+}
+
+func (s *retrySender) AnswerInlineQuery(ctx context.Context, p *bot.AnswerInlineQueryParams) (bool, error) {
+	return telegramCall(ctx, s, "answerInlineQuery", nil, func() (bool, error) { return s.next.AnswerInlineQuery(ctx, p) })
+}
@@ -0,0 +1,397 @@
+// handler/user-data.go
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+// deletedNickname — ник анонимизированного пользователя (users.nickname NOT NULL).
+const deletedNickname = "deleted"
+
+const (
+	deleteMeConfirm = "delme_ok"
+	deleteMeCancel  = "delme_cancel"
+)
+
+// DeleteMeCommandHandler — /delete_me: пользователь просит удалить его данные.
+// Сначала спрашиваем подтверждение кнопкой — команду легко отправить случайно.
+func (h *Handler) DeleteMeCommandHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil || update.Message.From == nil {
+		return
+	}
+	_, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text: "🗑 Удалить ваши данные?\n\n" +
+			"Мы сотрём номер телефона и имя, отменим неоплаченные заявки на подписку и забудем незавершённые действия в боте. " +
+			"Заказы останутся в бухгалтерии, но без ваших контактов. Отменить удаление будет нельзя.",
+		ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{{
+			{Text: "🗑 Да, удалить", CallbackData: deleteMeConfirm},
+			{Text: "Отмена", CallbackData: deleteMeCancel},
+		}}},
+	})
+	if err != nil {
+		h.logger.Warn("send /delete_me confirmation", zap.Error(err))
+	}
+}
+
+// DeleteMeCallbackHandler — ответ на кнопки /delete_me (delme_ok / delme_cancel).
+func (h *Handler) DeleteMeCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	cq := update.CallbackQuery
+	if cq == nil {
+		return
+	}
+	text := "Удаление отменено, данные на месте."
+	if cq.Data == deleteMeConfirm {
+		text = "✅ Ваши данные удалены. Чтобы снова заказать, просто откройте мини-апп."
+		if err := h.anonymizeUser(ctx, cq.From.ID); err != nil {
+			h.logger.Error("anonymize user", zap.Int64("user_id", cq.From.ID), zap.Error(err))
+			text = "⚠️ Не удалось удалить данные, попробуйте позже или напишите в поддержку."
+		}
+	}
+	_, _ = h.sender.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: cq.ID})
+
+	// заменяем вопрос результатом, чтобы кнопки не нажали ещё раз
+	if msg := cq.Message.Message; msg != nil {
+		_, err := h.sender.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:    msg.Chat.ID,
+			MessageID: msg.ID,
+			Text:      text,
+		})
+		if err != nil {
+			h.logger.Warn("edit /delete_me message", zap.Error(err))
+		}
+	}
+}
+
+// anonymizeUser стирает персональные данные пользователя по его просьбе.
+// Заказы и оценки остаются для учёта, но без телефона, имени и текста отзывов;
+// заявки на подписку, которые ещё ждут оплаты, отменяются. Сами чеки лежат в
+// Telegram, в базе — только их file_id (payments), их стираем.
+func (h *Handler) anonymizeUser(ctx context.Context, userID int64) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	counts := map[string]int64{}
+	for _, step := range []struct {
+		name, query string
+	}{
+		{"users", `UPDATE users SET nickname = ?, phone = NULL WHERE user_id = ?`},
+		{"user_phones", `DELETE FROM user_phones WHERE user_id = ?`},
+		{"subscriptions_cancelled", `UPDATE subscriptions SET status = 'cancelled' WHERE user_id = ? AND status = 'pending'`},
+		{"subscriptions", `UPDATE subscriptions SET phone = NULL WHERE user_id = ?`},
+		{"order_feedback", `UPDATE order_feedback SET comment = NULL WHERE order_id IN (SELECT id FROM orders WHERE user_id = ?)`},
+		{"stock_notifications", `DELETE FROM stock_notifications WHERE user_id = ?`},
+		{"payments", `DELETE FROM payments WHERE user_id = ?`},
+		{"just", `DELETE FROM just WHERE id_user = ?`},
+	} {
+		args := []any{userID}
+		if step.name == "users" {
+			args = []any{deletedNickname, userID}
+		}
+		res, err := tx.ExecContext(ctx, step.query, args...)
+		if err != nil {
+			return fmt.Errorf("anonymize %s: %w", step.name, err)
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			counts[step.name] = n
+		}
+	}
+	if err := h.writeAudit(tx, userID, "user.delete", strconv.FormatInt(userID, 10), "user request", counts); err != nil {
+		return fmt.Errorf("write audit: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if h.redisClient != nil {
+		if err := h.redisClient.ClearAllUserStates(ctx, userID); err != nil {
+			h.logger.Warn("clear user states", zap.Int64("user_id", userID), zap.Error(err))
+		}
+		if err := h.redisClient.DeleteProductCache(ctx, userID); err != nil {
+			h.logger.Warn("delete product cache", zap.Int64("user_id", userID), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+type exportProfile struct {
+	ID            string     `json:"id"`
+	Nickname      string     `json:"nickname"`
+	Phone         string     `json:"phone"`
+	SubStatus     string     `json:"sub_status"`
+	SubUntil      *time.Time `json:"sub_until"`
+	SelectedStore string     `json:"selected_store"`
+	PreviousStore string     `json:"previous_store"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type exportPhone struct {
+	Phone       string    `json:"phone"`
+	Source      string    `json:"source"`
+	Uses        int64     `json:"uses"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+}
+
+type exportOrder struct {
+	ID        int64              `json:"id"`
+	StoreCode string             `json:"store_code"`
+	Status    string             `json:"status"`
+	Total     int64              `json:"total"`
+	CreatedAt time.Time          `json:"created_at"`
+	Items     []userOrderItemOut `json:"items"`
+}
+
+// exportPayment — оплата заказа: отдельной таблицы платежей нет,
+// способ оплаты и решение по чеку хранятся в orders.
+type exportPayment struct {
+	OrderID   int64      `json:"order_id"`
+	Amount    int64      `json:"amount"`
+	Method    string     `json:"method"`
+	Status    string     `json:"status"`
+	DecidedAt *time.Time `json:"decided_at"`
+}
+
+type exportSubscription struct {
+	ID         int64      `json:"id"`
+	Phone      string     `json:"phone"`
+	Status     string     `json:"status"`
+	InvoiceNo  string     `json:"invoice_no"`
+	Amount     int64      `json:"amount"`
+	PaidAt     *time.Time `json:"paid_at"`
+	ValidUntil *time.Time `json:"valid_until"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type exportRating struct {
+	OrderID   int64     `json:"order_id"`
+	Rating    int       `json:"rating"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportStockNotification struct {
+	ProductID  int64      `json:"product_id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	NotifiedAt *time.Time `json:"notified_at"`
+}
+
+type userExport struct {
+	TelegramID         int64                     `json:"telegram_id"`
+	ExportedAt         time.Time                 `json:"exported_at"`
+	Profile            exportProfile             `json:"profile"`
+	Phones             []exportPhone             `json:"phones"`
+	Orders             []exportOrder             `json:"orders"`
+	Payments           []exportPayment           `json:"payments"`
+	Subscriptions      []exportSubscription      `json:"subscriptions"`
+	Ratings            []exportRating            `json:"ratings"`
+	StockNotifications []exportStockNotification `json:"stock_notifications"`
+}
+
+var errExportUserNotFound = errors.New("user not found")
+
+// exportUser собирает всё, что хранится о пользователе.
+func (h *Handler) exportUser(ctx context.Context, userID int64) (*userExport, error) {
+	out := &userExport{
+		TelegramID: userID, ExportedAt: h.clock.Now().UTC(),
+		Phones: []exportPhone{}, Orders: []exportOrder{}, Payments: []exportPayment{},
+		Subscriptions: []exportSubscription{}, Ratings: []exportRating{}, StockNotifications: []exportStockNotification{},
+	}
+
+	var subUntil sql.NullTime
+	p := &out.Profile
+	err := h.db.QueryRowContext(ctx, `
+		SELECT id, nickname, COALESCE(phone, ''), COALESCE(sub_status, 'inactive'), sub_until,
+		       COALESCE(selected_store, ''), COALESCE(previous_store, ''), created_at
+		FROM users WHERE user_id = ?
+	`, userID).Scan(&p.ID, &p.Nickname, &p.Phone, &p.SubStatus, &subUntil, &p.SelectedStore, &p.PreviousStore, &p.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errExportUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("select user: %w", err)
+	}
+	if subUntil.Valid {
+		p.SubUntil = &subUntil.Time
+	}
+
+	err = h.scanRows(ctx, `
+		SELECT phone, source, uses, first_seen_at, last_used_at
+		FROM user_phones WHERE user_id = ? ORDER BY last_used_at DESC
+	`, userID, func(rows *sql.Rows) error {
+		var ph exportPhone
+		if err := rows.Scan(&ph.Phone, &ph.Source, &ph.Uses, &ph.FirstSeenAt, &ph.LastUsedAt); err != nil {
+			return err
+		}
+		out.Phones = append(out.Phones, ph)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("select user phones: %w", err)
+	}
+
+	// части заказа по точкам не выгружаем: их позиции и оплата — в общем заказе
+	err = h.scanRows(ctx, `
+		SELECT id, COALESCE(store_code, ''), status, total_amount, COALESCE(payment_method, ''),
+		       payment_decided_at, created_at
+		FROM orders WHERE user_id = ? AND parent_order_id IS NULL ORDER BY id
+	`, userID, func(rows *sql.Rows) error {
+		var (
+			o         exportOrder
+			method    string
+			decidedAt sql.NullTime
+		)
+		if err := rows.Scan(&o.ID, &o.StoreCode, &o.Status, &o.Total, &method, &decidedAt, &o.CreatedAt); err != nil {
+			return err
+		}
+		out.Orders = append(out.Orders, o)
+		if method != "" {
+			pay := exportPayment{OrderID: o.ID, Amount: o.Total, Method: method, Status: o.Status}
+			if decidedAt.Valid {
+				pay.DecidedAt = &decidedAt.Time
+			}
+			out.Payments = append(out.Payments, pay)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("select orders: %w", err)
+	}
+	for i, o := range out.Orders {
+		_, items, err := h.orderRepo.GetOrderWithItems(ctx, o.ID)
+		if err != nil {
+			return nil, fmt.Errorf("select order %d items: %w", o.ID, err)
+		}
+		out.Orders[i].Items = make([]userOrderItemOut, 0, len(items))
+		for _, it := range items {
+			out.Orders[i].Items = append(out.Orders[i].Items, userOrderItemOut{
+				ProductID: it.ProductID, Name: it.Name, Emoji: it.Emoji, Photo: it.Photo,
+				Unit: it.Unit, Qty: it.Qty, Price: it.Price, Amount: it.Amount,
+			})
+		}
+	}
+
+	err = h.scanRows(ctx, `
+		SELECT id, COALESCE(phone, ''), status, COALESCE(invoice_no, ''), amount, paid_at, valid_until, created_at
+		FROM subscriptions WHERE user_id = ? ORDER BY id
+	`, userID, func(rows *sql.Rows) error {
+		var (
+			s                  exportSubscription
+			paidAt, validUntil sql.NullTime
+		)
+		if err := rows.Scan(&s.ID, &s.Phone, &s.Status, &s.InvoiceNo, &s.Amount, &paidAt, &validUntil, &s.CreatedAt); err != nil {
+			return err
+		}
+		if paidAt.Valid {
+			s.PaidAt = &paidAt.Time
+		}
+		if validUntil.Valid {
+			s.ValidUntil = &validUntil.Time
+		}
+		out.Subscriptions = append(out.Subscriptions, s)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("select subscriptions: %w", err)
+	}
+
+	err = h.scanRows(ctx, `
+		SELECT f.order_id, f.rating, COALESCE(f.comment, ''), f.created_at
+		FROM order_feedback f
+		JOIN orders o ON o.id = f.order_id
+		WHERE o.user_id = ? ORDER BY f.order_id
+	`, userID, func(rows *sql.Rows) error {
+		var fb exportRating
+		if err := rows.Scan(&fb.OrderID, &fb.Rating, &fb.Comment, &fb.CreatedAt); err != nil {
+			return err
+		}
+		out.Ratings = append(out.Ratings, fb)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("select ratings: %w", err)
+	}
+
+	err = h.scanRows(ctx, `
+		SELECT product_id, created_at, notified_at
+		FROM stock_notifications WHERE user_id = ? ORDER BY id
+	`, userID, func(rows *sql.Rows) error {
+		var (
+			sn         exportStockNotification
+			notifiedAt sql.NullTime
+		)
+		if err := rows.Scan(&sn.ProductID, &sn.CreatedAt, &notifiedAt); err != nil {
+			return err
+		}
+		if notifiedAt.Valid {
+			sn.NotifiedAt = &notifiedAt.Time
+		}
+		out.StockNotifications = append(out.StockNotifications, sn)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("select stock notifications: %w", err)
+	}
+	return out, nil
+}
+
+// scanRows выполняет запрос по одному пользователю и передаёт строки в scan.
+func (h *Handler) scanRows(ctx context.Context, query string, userID int64, scan func(*sql.Rows) error) error {
+	rows, err := h.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// handleAdminExportUser — «выдайте мои данные»: всё, что хранится о пользователе,
+// одним JSON (профиль, номера, заказы с позициями, оплаты, подписки, оценки):
+// GET /api/admin/users/export?telegram_id=…
+func (h *Handler) handleAdminExportUser(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	userID, err := strconv.ParseInt(strings.TrimSpace(r.URL.Query().Get("telegram_id")), 10, 64)
+	if err != nil || userID <= 0 {
+		writeError(w, ErrBadRequest("telegram_id is required"))
+		return
+	}
+
+	export, err := h.exportUser(r.Context(), userID)
+	if errors.Is(err, errExportUserNotFound) {
+		writeError(w, ErrNotFound("user"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("export user data", zap.Int64("user_id", userID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	h.auditQuiet(h.auditActor(r), "user.export", strconv.FormatInt(userID, 10), map[string]int{
+		"orders": len(export.Orders), "subscriptions": len(export.Subscriptions),
+	})
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d.json"`, userID))
+	jsonOK(w, export)
+}
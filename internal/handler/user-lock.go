@@ -0,0 +1,147 @@
+// handler/user-lock.go
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	userLockTTL   = 30 * time.Second // страховка, если процесс упал с захваченной блокировкой
+	userLockWait  = 3 * time.Second  // сколько ждём, прежде чем ответить «try again»
+	userLockRetry = 50 * time.Millisecond
+
+	// повторный заказ с той же суммой в этом окне считаем двойным нажатием
+	duplicateOrderWindow = 10 * time.Second
+)
+
+// errUserBusy — по этому пользователю уже идёт другая операция.
+var errUserBusy = errors.New("try again")
+
+// lockUser сериализует операции с заказами и оплатами одного Telegram ID.
+// Основная блокировка — в Redis (SETNX с TTL); если Redis недоступен,
+// используется мьютекс внутри процесса. Вернёт errUserBusy, если за
+// userLockWait (или до отмены ctx) захватить не удалось.
+func (h *Handler) lockUser(ctx context.Context, userID int64) (unlock func(), err error) {
+	ctx, cancel := context.WithTimeout(ctx, userLockWait)
+	defer cancel()
+
+	if h.redisClient != nil {
+		unlock, err := h.lockUserRedis(ctx, userID)
+		if err == nil || errors.Is(err, errUserBusy) {
+			return unlock, err
+		}
+		h.logger.Warn("redis user lock unavailable, using local lock", zap.Int64("user_id", userID), zap.Error(err))
+	}
+	return h.locks.lock(ctx, userID)
+}
+
+// lockUserHTTP — lockUser для HTTP-ручек: на занятость отвечает 409 «try again».
+func (h *Handler) lockUserHTTP(w http.ResponseWriter, r *http.Request, telegramID string) (func(), bool) {
+	uid, err := strconv.ParseInt(telegramID, 10, 64)
+	if err != nil {
+		writeError(w, ErrBadRequest("invalid telegram_id"))
+		return nil, false
+	}
+	unlock, err := h.lockUser(r.Context(), uid)
+	if err != nil {
+		writeError(w, ErrConflict(err.Error()))
+		return nil, false
+	}
+	return unlock, true
+}
+
+// recentOrder ищет только что созданный заказ пользователя с той же суммой.
+// Вызывается под lockUser, поэтому двойное нажатие «Подтвердить заказ»
+// вернёт первый заказ, а не создаст второй.
+func (h *Handler) recentOrder(telegramID string, total int64) (int64, bool) {
+	var id int64
+	err := h.db.QueryRow(`
+		SELECT id FROM orders
+		WHERE user_id = ? AND status = 'new' AND total_amount = ? AND parent_order_id IS NULL
+		  AND created_at >= ?
+		ORDER BY id DESC LIMIT 1
+	`, telegramID, total, time.Now().UTC().Add(-duplicateOrderWindow).Format(dbTimeLayout)).Scan(&id)
+	return id, err == nil
+}
+
+func (h *Handler) lockUserRedis(ctx context.Context, userID int64) (func(), error) {
+	key := fmt.Sprintf("lock:user:%d", userID)
+	token := uuid.New().String()
+	for {
+		ok, err := h.redisClient.TryLock(ctx, key, token, userLockTTL)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, errUserBusy
+			}
+			return nil, err
+		}
+		if ok {
+			return func() {
+				// ctx запроса к этому моменту может быть уже отменён
+				if err := h.redisClient.Unlock(context.Background(), key, token); err != nil {
+					h.logger.Warn("release user lock", zap.Int64("user_id", userID), zap.Error(err))
+				}
+			}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errUserBusy
+		case <-time.After(userLockRetry):
+		}
+	}
+}
+
+// keyedMutex — мьютекс на каждый ключ; записи удаляются, когда никто не ждёт.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[int64]*keyedEntry
+}
+
+type keyedEntry struct {
+	ch   chan struct{} // буфер 1: занят, когда в канале лежит значение
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: map[int64]*keyedEntry{}}
+}
+
+func (k *keyedMutex) lock(ctx context.Context, key int64) (func(), error) {
+	k.mu.Lock()
+	e, ok := k.locks[key]
+	if !ok {
+		e = &keyedEntry{ch: make(chan struct{}, 1)}
+		k.locks[key] = e
+	}
+	e.refs++
+	k.mu.Unlock()
+
+	select {
+	case e.ch <- struct{}{}:
+		return func() {
+			<-e.ch
+			k.release(key, e)
+		}, nil
+	case <-ctx.Done():
+		k.release(key, e)
+		return nil, errUserBusy
+	}
+}
+
+func (k *keyedMutex) release(key int64, e *keyedEntry) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	e.refs--
+	if e.refs == 0 {
+		delete(k.locks, key)
+	}
+}
@@ -0,0 +1,141 @@
+// handler/user-menu.go
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+// Кнопки постоянного меню пользователя (ReplyKeyboard внизу чата).
+// Регистрация — по точному тексту кнопки:
+// bot.WithMessageTextHandler(handler.MenuMyOrders, bot.MatchTypeExact, handl.UserMenuHandler), ...
+const (
+	MenuMyOrders     = "📦 Мои заказы"
+	MenuMySub        = "💳 Моя подписка"
+	MenuChooseStore  = "🏪 Выбрать магазин"
+	MenuSupport      = "📞 Поддержка"
+	menuOrdersLimit  = 5
+	menuKeyboardHint = "👇 Меню всегда под рукой — внизу экрана."
+)
+
+// MenuButtons — тексты кнопок меню, для регистрации хендлеров в main.
+var MenuButtons = []string{MenuMyOrders, MenuMySub, MenuChooseStore, MenuSupport}
+
+func userMenuKeyboard() *models.ReplyKeyboardMarkup {
+	return &models.ReplyKeyboardMarkup{
+		Keyboard: [][]models.KeyboardButton{
+			{{Text: MenuMyOrders}, {Text: MenuMySub}},
+			{{Text: MenuChooseStore}, {Text: MenuSupport}},
+		},
+		ResizeKeyboard: true,
+		IsPersistent:   true,
+	}
+}
+
+// UserMenuHandler — нажатие кнопки меню: история заказов, подписка, выбор точки, поддержка.
+func (h *Handler) UserMenuHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil || update.Message.From == nil {
+		return
+	}
+	userID := update.Message.From.ID
+	params := &bot.SendMessageParams{ChatID: update.Message.Chat.ID}
+
+	switch update.Message.Text {
+	case MenuMyOrders:
+		params.Text = h.myOrdersText(userID)
+	case MenuMySub:
+		params.Text, params.ReplyMarkup = h.mySubscriptionText(userID)
+	case MenuChooseStore:
+		params.Text, params.ReplyMarkup = h.chooseStoreText(userID)
+	case MenuSupport:
+		params.Text, params.ReplyMarkup = h.supportText()
+	default:
+		return
+	}
+	if _, err := h.sender.SendMessage(ctx, params); err != nil {
+		h.logger.Warn("send user menu reply", zap.String("button", update.Message.Text), zap.Error(err))
+	}
+}
+
+// myOrdersText — последние заказы пользователя со статусами.
+func (h *Handler) myOrdersText(userID int64) string {
+	orders, err := h.orderRepo.ListByUser(h.ctx, userID, menuOrdersLimit)
+	if err != nil {
+		h.logger.Error("select user orders", zap.Int64("user_id", userID), zap.Error(err))
+		return "⚠️ Не удалось загрузить заказы, попробуйте позже."
+	}
+
+	var b strings.Builder
+	for _, o := range orders {
+		fmt.Fprintf(&b, "\n№%d · %s · %s\n📌 %s\n", o.ID, formatDate(o.CreatedAt), formatMoney(o.TotalAmount), humanOrderStatus(o.Status))
+	}
+	if len(orders) == 0 {
+		return "📦 У вас пока нет заказов.\nОткройте мини-апп, чтобы сделать первый заказ."
+	}
+	return "📦 Ваши последние заказы:\n" + b.String()
+}
+
+// mySubscriptionText — статус подписки; без активной подписки — кнопка оформления.
+func (h *Handler) mySubscriptionText(userID int64) (string, models.ReplyMarkup) {
+	sub, err := h.subscriptionState(strconv.FormatInt(userID, 10))
+	if err != nil {
+		h.logger.Error("select user subscription", zap.Int64("user_id", userID), zap.Error(err))
+		return "⚠️ Не удалось проверить подписку, попробуйте позже.", nil
+	}
+	open := &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{{
+		{Text: "🔄 Оформить подписку", WebApp: &models.WebAppInfo{URL: h.cfg.MiniAppUrl}},
+	}}}
+	switch {
+	case sub.grace:
+		return fmt.Sprintf("⏳ Подписка закончилась %s, доступ сохранится до %s.\nПродлите её, чтобы не потерять оптовые цены.",
+			formatDate(sub.until), formatDate(h.graceUntil(sub.until))), open
+	case sub.active:
+		return fmt.Sprintf("✅ Подписка активна до %s.", formatDate(sub.until)), nil
+	default:
+		return "❌ Активной подписки нет.\nС подпиской вам доступны оптовые цены «АГРО Клуба».", open
+	}
+}
+
+// chooseStoreText — текущая точка и кнопка страницы выбора точки в мини-аппе.
+func (h *Handler) chooseStoreText(userID int64) (string, models.ReplyMarkup) {
+	var name string
+	_ = h.db.QueryRow(`
+		SELECT COALESCE(s.name, '')
+		FROM users u JOIN stores s ON s.code = u.selected_store
+		WHERE u.user_id = ?
+	`, userID).Scan(&name)
+
+	text := "🏪 Магазин ещё не выбран. Выберите точку, где будете забирать заказы."
+	if name != "" {
+		text = fmt.Sprintf("🏪 Сейчас выбран магазин: %s.\nМожно выбрать другую точку.", name)
+	}
+	url := strings.TrimRight(h.cfg.MiniAppUrl, "/") + "/store-select"
+	return text, &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{{
+		{Text: "🗺 Выбрать магазин", WebApp: &models.WebAppInfo{URL: url}},
+	}}}
+}
+
+// supportText — контакт поддержки из SUPPORT_CONTACT, иначе чат с администратором.
+func (h *Handler) supportText() (string, models.ReplyMarkup) {
+	contact := strings.TrimSpace(h.cfg.SupportContact)
+	url := fmt.Sprintf("tg://user?id=%d", h.cfg.AdminID)
+	switch {
+	case strings.HasPrefix(contact, "@"):
+		url = "https://t.me/" + strings.TrimPrefix(contact, "@")
+	case strings.HasPrefix(contact, "https://"), strings.HasPrefix(contact, "tg://"):
+		url = contact
+	case contact != "":
+		// телефон или другой контакт без ссылки — просто текстом
+		return "📞 Поддержка: " + contact, nil
+	}
+	return "📞 Есть вопрос по заказу или подписке? Напишите нам — ответим в рабочее время.",
+		&models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{{
+			{Text: "✍️ Написать в поддержку", URL: url},
+		}}}
+}
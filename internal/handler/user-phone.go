@@ -0,0 +1,232 @@
+// handler/user-phone.go
+package handler
+
+import (
+	"agro/internal/domain"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Откуда пришёл номер (user_phones.source).
+const (
+	phoneSourceOrder        = "order"
+	phoneSourceContact      = "contact"
+	phoneSourceSubscription = "subscription"
+	phoneSourceProfile      = "profile"
+)
+
+var errInvalidPhone = errors.New("invalid phone")
+
+// normalizePhone приводит казахстанский номер к виду +7XXXXXXXXXX:
+// «8 701 123 45 67», «+7 (701) 123-45-67», «7011234567» → «+77011234567».
+// Нераспознанный номер — пустая строка.
+func normalizePhone(raw string) string {
+	var digits strings.Builder
+	for _, r := range raw {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	d := digits.String()
+	switch {
+	case len(d) == 10:
+		d = "7" + d
+	case len(d) == 11 && d[0] == '8':
+		d = "7" + d[1:]
+	}
+	if len(d) != 11 || d[0] != '7' {
+		return ""
+	}
+	return "+" + d
+}
+
+// rememberPhone сохраняет номер пользователя: всегда — в историю user_phones,
+// в users.phone — только если там ещё пусто. Уже записанный номер (например,
+// номер Kaspi, по которому сверяется подписка) другим не перезаписываем.
+// Возвращает нормализованный номер.
+func (h *Handler) rememberPhone(ctx context.Context, userID int64, raw, source string) (string, error) {
+	phone := normalizePhone(raw)
+	if phone == "" {
+		return "", fmt.Errorf("%w: %q", errInvalidPhone, raw)
+	}
+
+	if err := upsertUserPhone(ctx, h.db, userID, phone, source); err != nil {
+		return "", err
+	}
+
+	_, err := h.db.ExecContext(ctx, `
+		INSERT INTO users (id, user_id, nickname, phone)
+		VALUES (?, ?, 'user', ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+		  phone = CASE WHEN users.phone IS NULL OR users.phone = ''
+		               THEN excluded.phone ELSE users.phone END
+	`, uuid.New().String(), userID, phone)
+	if err != nil {
+		return "", fmt.Errorf("upsert users phone: %w", err)
+	}
+	return phone, nil
+}
+
+// upsertUserPhone добавляет уже нормализованный номер в историю user_phones.
+func upsertUserPhone(ctx context.Context, ex execer, userID int64, phone, source string) error {
+	_, err := ex.ExecContext(ctx, `
+		INSERT INTO user_phones (user_id, phone, source)
+		VALUES (?, ?, ?)
+		ON CONFLICT(user_id, phone) DO UPDATE SET
+		  source = excluded.source,
+		  uses = user_phones.uses + 1,
+		  last_used_at = CURRENT_TIMESTAMP
+	`, userID, phone, source)
+	if err != nil {
+		return fmt.Errorf("upsert user_phones: %w", err)
+	}
+	return nil
+}
+
+// rememberPhoneQuiet — rememberPhone для путей, где ошибка не должна ломать ответ
+// (заказ уже создан, заявка на подписку уже принята): только пишем в лог.
+func (h *Handler) rememberPhoneQuiet(userID int64, raw, source string) {
+	if strings.TrimSpace(raw) == "" {
+		return
+	}
+	if _, err := h.rememberPhone(h.ctx, userID, raw, source); err != nil {
+		h.logger.Warn("remember user phone", zap.Int64("user_id", userID), zap.String("source", source), zap.Error(err))
+	}
+}
+
+// rememberContactState кладёт номер в состояние пользователя в Redis, не трогая
+// текущий шаг (ожидание оплаты и т.п.): номер увидит админ в карточке оплаты.
+func (h *Handler) rememberContactState(ctx context.Context, userID int64, phone string) {
+	if h.redisClient == nil {
+		return
+	}
+	st, err := h.redisClient.GetUserState(ctx, userID)
+	if err != nil {
+		h.logger.Warn("get user state for contact", zap.Int64("user_id", userID), zap.Error(err))
+		return
+	}
+	if st == nil {
+		st = &domain.UserState{}
+	}
+	st.Contact = phone
+	if err := h.redisClient.SaveUserState(ctx, userID, st); err != nil {
+		h.logger.Warn("save contact to user state", zap.Int64("user_id", userID), zap.Error(err))
+	}
+}
+
+// handleSharedContact — пользователь поделился контактом (скрепка → «Контакт»).
+// Сохраняем только собственный номер: чужой контакт не привязываем к аккаунту.
+func (h *Handler) handleSharedContact(ctx context.Context, msg *models.Message) {
+	c := msg.Contact
+	text := "📞 Отправьте, пожалуйста, свой номер телефона — чужой контакт мы не сохраняем."
+	if c.UserID == msg.From.ID {
+		phone, err := h.rememberPhone(ctx, msg.From.ID, c.PhoneNumber, phoneSourceContact)
+		switch {
+		case errors.Is(err, errInvalidPhone):
+			text = "⚠️ Не удалось распознать номер. Укажите его при оформлении заказа в мини-аппе."
+		case err != nil:
+			h.logger.Error("remember shared contact", zap.Int64("user_id", msg.From.ID), zap.Error(err))
+			text = "⚠️ Не удалось сохранить номер, попробуйте позже."
+		default:
+			h.rememberContactState(ctx, msg.From.ID, phone)
+			text = fmt.Sprintf("✅ Спасибо! Номер %s сохранён — по нему свяжемся при доставке.", phone)
+		}
+	}
+	if _, err := h.sender.SendMessage(ctx, &bot.SendMessageParams{ChatID: msg.Chat.ID, Text: text}); err != nil {
+		h.logger.Warn("send contact reply", zap.Error(err))
+	}
+}
+
+type updatePhoneIn struct {
+	TelegramID json.RawMessage `json:"telegram_id"` // строка или число
+	Phone      string          `json:"phone"`
+}
+
+// POST /api/user/update-phone {telegram_id, phone} — пользователь сам исправляет
+// номер (например, опечатку при заявке на подписку). В отличие от rememberPhone
+// номер в users.phone перезаписывается, а в ещё не оплаченной (pending) заявке
+// на подписку меняется на новый — новый счёт запрашивать не нужно.
+// telegram_id должен совпадать с X-Telegram-Id: чужой номер поменять нельзя.
+func (h *Handler) handleUpdatePhone(w http.ResponseWriter, r *http.Request) {
+	var in updatePhoneIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	tgID, err := parseTelegramID(in.TelegramID)
+	if err != nil || tgID <= 0 {
+		writeError(w, ErrBadRequest("telegram_id is required"))
+		return
+	}
+	caller, err := strconv.ParseInt(strings.TrimSpace(r.Header.Get("X-Telegram-Id")), 10, 64)
+	if err != nil {
+		writeError(w, ErrUnauthorized("X-Telegram-Id is required"))
+		return
+	}
+	if caller != tgID {
+		writeError(w, ErrForbidden())
+		return
+	}
+	phone := normalizePhone(in.Phone)
+	if phone == "" {
+		writeError(w, ErrBadRequest("phone must be a Kazakhstan number like +77011234567").WithField("phone", in.Phone))
+		return
+	}
+
+	// не пересекаемся с заявкой на подписку и подтверждением оплаты
+	unlock, ok := h.lockUserHTTP(w, r, strconv.FormatInt(tgID, 10))
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	err = h.updateUserPhone(r.Context(), tgID, phone)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, ErrNotFound("user"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("update user phone", zap.Int64("user_id", tgID), zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	h.rememberContactState(r.Context(), tgID, phone)
+	jsonOK(w, map[string]any{"phone": phone})
+}
+
+// updateUserPhone заменяет users.phone и номер в pending-заявках на подписку.
+// Пользователя нет — sql.ErrNoRows.
+func (h *Handler) updateUserPhone(ctx context.Context, userID int64, phone string) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, `UPDATE users SET phone = ? WHERE user_id = ?`, phone, userID)
+	if err != nil {
+		return fmt.Errorf("update users phone: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := upsertUserPhone(ctx, tx, userID, phone, phoneSourceProfile); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE subscriptions SET phone = ? WHERE user_id = ? AND status = 'pending'`, phone, userID); err != nil {
+		return fmt.Errorf("update pending subscription phone: %w", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,730 @@
+// handler/webhooks.go
+package handler
+
+import (
+	"agro/traits/database"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// События, на которые можно подписать вебхук.
+const (
+	webhookOrderCreated          = "order.created"
+	webhookOrderPaid             = "order.paid"
+	webhookOrderStatusChanged    = "order.status_changed"
+	webhookSubscriptionActivated = "subscription.activated"
+	webhookPing                  = "ping"
+)
+
+var webhookEvents = []string{
+	webhookOrderCreated,
+	webhookOrderPaid,
+	webhookOrderStatusChanged,
+	webhookSubscriptionActivated,
+}
+
+const (
+	webhookBatchSize   = 50
+	webhookMaxAttempts = 4 // первая попытка + 3 ретрая (30s, 1m, 2m), дальше failed
+	webhookBackoffBase = 30 * time.Second
+	webhookBackoffMax  = time.Hour
+)
+
+// webhookEnvelope — тело POST: id одинаков при ретраях (ключ идемпотентности на стороне получателя).
+type webhookEnvelope struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+type webhookOrderItem struct {
+	ProductID int64   `json:"product_id"` // 0 — строка «Доставка»
+	Name      string  `json:"name"`
+	Unit      string  `json:"unit"`
+	Qty       float64 `json:"qty"`
+	Price     int64   `json:"price"`
+	Amount    int64   `json:"amount"`
+
+	Note              string `json:"note,omitempty"`
+	AllowSubstitution bool   `json:"allow_substitution"`
+	Emoji             string `json:"emoji,omitempty"`
+	Photo             string `json:"photo,omitempty"`
+	PricePer          string `json:"price_per,omitempty"`
+}
+
+type webhookOrder struct {
+	ID             int64              `json:"id"`
+	UserID         int64              `json:"user_id"`
+	StoreCode      string             `json:"store_code"`
+	Status         string             `json:"status"`
+	PreviousStatus string             `json:"previous_status,omitempty"`
+	TotalAmount    int64              `json:"total_amount"`
+	GoodsTotal     int64              `json:"goods_total"`
+	DeliveryPrice  int64              `json:"delivery_price"`
+	CreatedAt      time.Time          `json:"created_at"`
+	Items          []webhookOrderItem `json:"items"`
+}
+
+type webhookSubscription struct {
+	SubscriptionID int64     `json:"subscription_id,omitempty"`
+	UserID         int64     `json:"user_id"`
+	ValidUntil     time.Time `json:"valid_until"`
+	Source         string    `json:"source"` // payment | admin | gift
+}
+
+// signWebhook — HMAC-SHA256 тела в hex; уходит в X-Agro-Signature как "sha256=<hex>".
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookBackoff(attempt int) time.Duration {
+	d := webhookBackoffBase
+	for i := 1; i < attempt && d < webhookBackoffMax; i++ {
+		d *= 2
+	}
+	return min(d, webhookBackoffMax)
+}
+
+func subscribedTo(events, event string) bool {
+	for _, e := range strings.Split(events, ",") {
+		if e = strings.TrimSpace(e); e == "*" || e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// emitWebhook ставит событие в очередь всем активным вебхукам, подписанным на него.
+// Отправляет воркер RunOutbox, поэтому вызов не ждёт внешние системы.
+func (h *Handler) emitWebhook(event string, data any) {
+	rows, err := h.db.Query(`SELECT id, events FROM webhooks WHERE active = 1`)
+	if err != nil {
+		h.logger.Error("select webhooks", zap.Error(err))
+		return
+	}
+	var targets []int64
+	for rows.Next() {
+		var (
+			id     int64
+			events string
+		)
+		if err := rows.Scan(&id, &events); err == nil && subscribedTo(events, event) {
+			targets = append(targets, id)
+		}
+	}
+	rows.Close()
+	if len(targets) == 0 {
+		return
+	}
+
+	now := h.clock.Now()
+	body, err := json.Marshal(webhookEnvelope{ID: uuid.New().String(), Event: event, CreatedAt: now.UTC(), Data: data})
+	if err != nil {
+		h.logger.Error("marshal webhook payload", zap.String("event", event), zap.Error(err))
+		return
+	}
+	var orderID any
+	if o, ok := data.(webhookOrder); ok {
+		orderID = o.ID
+	}
+	for _, id := range targets {
+		_, err := h.db.Exec(`
+			INSERT INTO webhook_deliveries (webhook_id, event, order_id, payload, next_attempt_at)
+			VALUES (?, ?, ?, ?, ?)
+		`, id, event, orderID, string(body), now)
+		if err != nil {
+			h.logger.Error("enqueue webhook", zap.Int64("webhook_id", id), zap.String("event", event), zap.Error(err))
+		}
+	}
+}
+
+// emitOrderEvent — событие по заказу (previous — прежний статус для status_changed):
+// уходит в ленту заказов админки и во внешние вебхуки (с позициями).
+func (h *Handler) emitOrderEvent(event string, orderID int64, previous string) {
+	order, items, err := h.orderRepo.GetOrderWithItems(h.ctx, orderID)
+	if err != nil {
+		h.logger.Warn("load order for event", zap.Int64("order_id", orderID), zap.Error(err))
+		return
+	}
+	h.orderEvents.publish(orderEvent{
+		Type:           event,
+		OrderID:        order.ID,
+		UserID:         order.UserID,
+		StoreCode:      order.StoreCode,
+		Status:         order.Status,
+		StatusText:     humanOrderStatus(order.Status),
+		PreviousStatus: previous,
+		TotalAmount:    order.TotalAmount,
+		At:             h.clock.Now().UTC(),
+	})
+
+	out := webhookOrder{
+		ID:             order.ID,
+		UserID:         order.UserID,
+		StoreCode:      order.StoreCode,
+		Status:         order.Status,
+		PreviousStatus: previous,
+		TotalAmount:    order.TotalAmount,
+		GoodsTotal:     order.GoodsTotal,
+		DeliveryPrice:  order.DeliveryPrice,
+		CreatedAt:      order.CreatedAt.UTC(),
+		Items:          make([]webhookOrderItem, 0, len(items)),
+	}
+	for _, it := range items {
+		out.Items = append(out.Items, webhookOrderItem(it))
+	}
+	h.emitWebhook(event, out)
+}
+
+type webhookDelivery struct {
+	id, webhookID  int64
+	event, payload string
+	attempts       int
+	url, secret    string
+}
+
+// deliverWebhooks отправляет подошедшие по времени доставки (вызывает RunOutbox),
+// неудачные откладывает с экспоненциальной задержкой.
+func (h *Handler) deliverWebhooks(ctx context.Context) {
+	now := h.clock.Now()
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT d.id, d.webhook_id, d.event, d.payload, d.attempts, w.url, w.secret
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = 'pending' AND w.active = 1 AND d.next_attempt_at <= ?
+		ORDER BY d.id
+		LIMIT ?
+	`, now, webhookBatchSize)
+	if err != nil {
+		h.logger.Error("select webhook deliveries", zap.Error(err))
+		return
+	}
+	var due []webhookDelivery
+	for rows.Next() {
+		var d webhookDelivery
+		if err := rows.Scan(&d.id, &d.webhookID, &d.event, &d.payload, &d.attempts, &d.url, &d.secret); err != nil {
+			h.logger.Error("scan webhook delivery", zap.Error(err))
+			continue
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+
+	for _, d := range due {
+		code, err := h.postWebhook(ctx, d.url, d.secret, d.event, fmt.Sprint(d.id), []byte(d.payload))
+		attempts := d.attempts + 1
+		now := h.clock.Now()
+		statusCode := sql.NullInt64{Int64: int64(code), Valid: code != 0}
+		switch {
+		case err == nil:
+			_, err = h.db.Exec(`
+				UPDATE webhook_deliveries
+				SET status = 'delivered', attempts = ?, status_code = ?, attempted_at = ?, delivered_at = ?, last_error = NULL
+				WHERE id = ?
+			`, attempts, statusCode, now, now, d.id)
+		case attempts >= webhookMaxAttempts:
+			h.logger.Warn("webhook delivery failed", zap.Int64("delivery_id", d.id), zap.String("url", d.url), zap.Error(err))
+			_, err = h.db.Exec(`
+				UPDATE webhook_deliveries
+				SET status = 'failed', attempts = ?, status_code = ?, attempted_at = ?, last_error = ?
+				WHERE id = ?
+			`, attempts, statusCode, now, err.Error(), d.id)
+		default:
+			_, err = h.db.Exec(`
+				UPDATE webhook_deliveries
+				SET attempts = ?, status_code = ?, attempted_at = ?, next_attempt_at = ?, last_error = ?
+				WHERE id = ?
+			`, attempts, statusCode, now, now.Add(webhookBackoff(attempts)), err.Error(), d.id)
+		}
+		if err != nil {
+			h.logger.Error("update webhook delivery", zap.Int64("delivery_id", d.id), zap.Error(err))
+		}
+	}
+}
+
+// postWebhook отправляет подписанный POST через h.webhookClient: получатель не
+// держит воркер дольше таймаута. Ошибка — сеть или ответ не 2xx.
+func (h *Handler) postWebhook(ctx context.Context, target, secret, event, deliveryID string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "agro-webhooks/1")
+	req.Header.Set("X-Agro-Event", event)
+	req.Header.Set("X-Agro-Delivery", deliveryID)
+	req.Header.Set("X-Agro-Signature", signWebhook(secret, body))
+
+	resp, err := h.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// ======================== ADMIN API ========================
+
+type webhookIn struct {
+	ID     int64    `json:"id"`
+	URL    *string  `json:"url"`
+	Secret string   `json:"secret"` // при создании; пусто — сгенерируем
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+const errWebhookURL = "url must be an absolute https URL of a public host"
+
+// validWebhookURL — только https и не внутренний адрес: localhost, loopback,
+// частные и link-local сети. Имена, которые резолвятся во внутренние адреса,
+// отсекает webhookClient при соединении.
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && internalAddr(ip) {
+		return false
+	}
+	return true
+}
+
+// sharedAddressSpace — 100.64.0.0/10 (CGNAT), тоже не публичная сеть.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// internalAddr — адрес, на который вебхук слать нельзя.
+func internalAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+// newWebhookClient — клиент для вебхуков с таймаутом timeout. Проверяет адрес
+// при каждом соединении (и после редиректа): публичное имя, которое резолвится
+// во внутренний адрес, не пропускается. Прокси из окружения не используется —
+// иначе проверялся бы адрес прокси, а не получателя.
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip, err := netip.ParseAddr(host); err != nil || internalAddr(ip) {
+				return fmt.Errorf("webhook address %s is not public", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// normalizeWebhookEvents проверяет список событий и склеивает его для колонки events.
+func normalizeWebhookEvents(events []string) (string, error) {
+	if len(events) == 0 {
+		return "", errors.New("events are required")
+	}
+	seen := map[string]bool{}
+	var out []string
+	for _, e := range events {
+		e = strings.TrimSpace(e)
+		known := e == "*"
+		for _, k := range webhookEvents {
+			known = known || e == k
+		}
+		if !known {
+			return "", fmt.Errorf("unknown event %q", e)
+		}
+		if !seen[e] {
+			seen[e] = true
+			out = append(out, e)
+		}
+	}
+	return strings.Join(out, ","), nil
+}
+
+func newWebhookSecret() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// GET /api/admin/webhooks — вебхуки (секрет скрыт) и состояние очереди.
+func (h *Handler) handleAdminListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	rows, err := h.db.Query(`
+		SELECT w.id, w.url, w.secret, w.events, w.active,
+		       (SELECT COUNT(1) FROM webhook_deliveries d WHERE d.webhook_id = w.id AND d.status = 'pending'),
+		       (SELECT COUNT(1) FROM webhook_deliveries d WHERE d.webhook_id = w.id AND d.status = 'failed')
+		FROM webhooks w
+		ORDER BY w.id
+	`)
+	if err != nil {
+		h.logger.Error("list webhooks", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer rows.Close()
+
+	type webhookOut struct {
+		ID      int64    `json:"id"`
+		URL     string   `json:"url"`
+		Secret  string   `json:"secret"` // последние 4 символа
+		Events  []string `json:"events"`
+		Active  bool     `json:"active"`
+		Pending int64    `json:"pending"`
+		Failed  int64    `json:"failed"`
+	}
+	out := []webhookOut{}
+	for rows.Next() {
+		var (
+			wh             webhookOut
+			secret, events string
+		)
+		if err := rows.Scan(&wh.ID, &wh.URL, &secret, &events, &wh.Active, &wh.Pending, &wh.Failed); err != nil {
+			h.logger.Error("scan webhook", zap.Error(err))
+			continue
+		}
+		wh.Secret = "…" + secret[max(0, len(secret)-4):]
+		wh.Events = strings.Split(events, ",")
+		out = append(out, wh)
+	}
+	jsonOK(w, map[string]any{"webhooks": out, "events": webhookEvents})
+}
+
+// GET /api/admin/webhooks/deliveries?webhook_id=&order_id=&status=failed&limit=50 —
+// журнал доставок, новые сверху.
+func (h *Handler) handleAdminWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	q := r.URL.Query()
+	where := []string{"1=1"}
+	var args []any
+	for _, f := range []string{"webhook_id", "order_id"} {
+		v := strings.TrimSpace(q.Get(f))
+		if v == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeError(w, ErrBadRequest(f+" must be a number"))
+			return
+		}
+		where = append(where, f+" = ?")
+		args = append(args, id)
+	}
+	if s := strings.TrimSpace(q.Get("status")); s != "" {
+		where = append(where, "status = ?")
+		args = append(args, s)
+	}
+	limit := 50
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		limit = min(v, 200)
+	}
+
+	rows, err := h.db.Query(`
+		SELECT id, webhook_id, event, order_id, status, attempts, status_code, attempted_at, last_error, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY id DESC
+		LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		h.logger.Error("list webhook deliveries", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer rows.Close()
+
+	type deliveryOut struct {
+		ID          int64      `json:"id"`
+		WebhookID   int64      `json:"webhook_id"`
+		Event       string     `json:"event"`
+		OrderID     *int64     `json:"order_id"`
+		Status      string     `json:"status"`
+		Attempts    int        `json:"attempts"`
+		StatusCode  *int64     `json:"status_code"`
+		AttemptedAt *time.Time `json:"attempted_at"`
+		Error       string     `json:"error,omitempty"`
+		CreatedAt   time.Time  `json:"created_at"`
+		DeliveredAt *time.Time `json:"delivered_at"`
+	}
+	out := []deliveryOut{}
+	for rows.Next() {
+		var (
+			d                      deliveryOut
+			orderID, code          sql.NullInt64
+			attemptedAt, delivered sql.NullTime
+			lastError              sql.NullString
+		)
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &orderID, &d.Status, &d.Attempts, &code,
+			&attemptedAt, &lastError, &d.CreatedAt, &delivered); err != nil {
+			h.logger.Error("scan webhook delivery", zap.Error(err))
+			continue
+		}
+		if orderID.Valid {
+			d.OrderID = &orderID.Int64
+		}
+		if code.Valid {
+			d.StatusCode = &code.Int64
+		}
+		if attemptedAt.Valid {
+			d.AttemptedAt = &attemptedAt.Time
+		}
+		if delivered.Valid {
+			d.DeliveredAt = &delivered.Time
+		}
+		d.Error = lastError.String
+		out = append(out, d)
+	}
+	jsonOK(w, out)
+}
+
+// POST /api/admin/webhooks/add — новый вебхук; секрет возвращается только здесь.
+func (h *Handler) handleAdminAddWebhook(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in webhookIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	if in.URL == nil || !validWebhookURL(strings.TrimSpace(*in.URL)) {
+		writeError(w, ErrBadRequest(errWebhookURL))
+		return
+	}
+	events, err := normalizeWebhookEvents(in.Events)
+	if err != nil {
+		writeError(w, ErrBadRequest(err.Error()))
+		return
+	}
+	secret := strings.TrimSpace(in.Secret)
+	if secret == "" {
+		secret = newWebhookSecret()
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	target := strings.TrimSpace(*in.URL)
+	id, err := database.InsertID(r.Context(), tx, `INSERT INTO webhooks (url, secret, events) VALUES (?, ?, ?)`, target, secret, events)
+	if err == nil {
+		err = h.writeAudit(tx, h.auditActor(r), "webhook.add", fmt.Sprint(id), "", map[string]any{"url": target, "events": events})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Error("add webhook", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, map[string]any{"status": "ok", "id": id, "secret": secret})
+}
+
+// POST /api/admin/webhooks/update — url, events и/или active; отсутствующие поля не меняются.
+func (h *Handler) handleAdminUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in webhookIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	if in.ID <= 0 {
+		writeError(w, ErrBadRequest("id is required"))
+		return
+	}
+
+	sets := []string{"updated_at = CURRENT_TIMESTAMP"}
+	var args []any
+	if in.URL != nil {
+		if !validWebhookURL(strings.TrimSpace(*in.URL)) {
+			writeError(w, ErrBadRequest(errWebhookURL))
+			return
+		}
+		sets = append(sets, "url = ?")
+		args = append(args, strings.TrimSpace(*in.URL))
+	}
+	if in.Events != nil {
+		events, err := normalizeWebhookEvents(in.Events)
+		if err != nil {
+			writeError(w, ErrBadRequest(err.Error()))
+			return
+		}
+		sets = append(sets, "events = ?")
+		args = append(args, events)
+	}
+	if in.Active != nil {
+		active := 0
+		if *in.Active {
+			active = 1
+		}
+		sets = append(sets, "active = ?")
+		args = append(args, active)
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.Exec(`UPDATE webhooks SET `+strings.Join(sets, ", ")+` WHERE id = ?`, append(args, in.ID)...)
+	if err != nil {
+		h.logger.Error("update webhook", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, ErrNotFound("webhook"))
+		return
+	}
+	err = h.writeAudit(tx, h.auditActor(r), "webhook.update", fmt.Sprint(in.ID), "", in)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Error("update webhook", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, map[string]string{"status": "ok"})
+}
+
+// POST /api/admin/webhooks/delete — удалить вебхук вместе с его очередью.
+func (h *Handler) handleAdminDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in webhookIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.ID <= 0 {
+		writeError(w, ErrBadRequest("id is required"))
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.Exec(`DELETE FROM webhooks WHERE id = ?`, in.ID)
+	if err != nil {
+		h.logger.Error("delete webhook", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, ErrNotFound("webhook"))
+		return
+	}
+	_, err = tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, in.ID)
+	if err == nil {
+		err = h.writeAudit(tx, h.auditActor(r), "webhook.delete", fmt.Sprint(in.ID), "", nil)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Error("delete webhook", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, map[string]string{"status": "ok"})
+}
+
+// POST /api/admin/webhooks/ping — сразу (без очереди) отправить тестовое событие ping
+// и вернуть ответ получателя, чтобы интегратор мог проверить подпись.
+func (h *Handler) handleAdminPingWebhook(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in webhookIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.ID <= 0 {
+		writeError(w, ErrBadRequest("id is required"))
+		return
+	}
+	var target, secret string
+	err := h.db.QueryRow(`SELECT url, secret FROM webhooks WHERE id = ?`, in.ID).Scan(&target, &secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, ErrNotFound("webhook"))
+		return
+	}
+	if err != nil {
+		h.logger.Error("select webhook", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+
+	id := uuid.New().String()
+	body, _ := json.Marshal(webhookEnvelope{
+		ID:        id,
+		Event:     webhookPing,
+		CreatedAt: h.clock.Now().UTC(),
+		Data:      map[string]any{"webhook_id": in.ID},
+	})
+	// время ответа ограничивает таймаут webhookClient
+	code, err := h.postWebhook(r.Context(), target, secret, webhookPing, id, body)
+	out := map[string]any{"ok": err == nil, "status_code": code}
+	if err != nil {
+		out["error"] = err.Error()
+	}
+	jsonOK(w, out)
+}
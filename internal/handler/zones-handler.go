@@ -0,0 +1,280 @@
+// handler/zones-handler.go
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// errOutsideDeliveryZone — адрес клиента вне зоны доставки выбранной точки.
+var errOutsideDeliveryZone = errors.New("address is outside the delivery zone")
+
+// geoGeometry — GeoJSON-геометрия; координаты разбираются в parseZone.
+type geoGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// ring — замкнутый контур из точек [lng, lat]; polygon — внешний контур и дырки.
+type (
+	ring    [][2]float64
+	polygon []ring
+)
+
+// parseZone разбирает GeoJSON Polygon или MultiPolygon в список полигонов.
+func parseZone(raw string) ([]polygon, error) {
+	var g geoGeometry
+	if err := json.Unmarshal([]byte(raw), &g); err != nil {
+		return nil, fmt.Errorf("invalid geojson: %w", err)
+	}
+	var polys []polygon
+	switch g.Type {
+	case "Polygon":
+		var p polygon
+		if err := json.Unmarshal(g.Coordinates, &p); err != nil {
+			return nil, fmt.Errorf("invalid polygon coordinates: %w", err)
+		}
+		polys = []polygon{p}
+	case "MultiPolygon":
+		if err := json.Unmarshal(g.Coordinates, &polys); err != nil {
+			return nil, fmt.Errorf("invalid multipolygon coordinates: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+	for _, p := range polys {
+		if len(p) == 0 || len(p[0]) < 4 {
+			return nil, errors.New("polygon ring needs at least 4 points")
+		}
+	}
+	return polys, nil
+}
+
+// contains — точка внутри внешнего контура и вне всех дырок.
+func (p polygon) contains(lng, lat float64) bool {
+	if len(p) == 0 || !p[0].contains(lng, lat) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if hole.contains(lng, lat) {
+			return false
+		}
+	}
+	return true
+}
+
+// contains — классический ray casting.
+func (r ring) contains(lng, lat float64) bool {
+	inside := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		xi, yi := r[i][0], r[i][1]
+		xj, yj := r[j][0], r[j][1]
+		if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// distanceKm — расстояние по большому кругу (haversine).
+func distanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// checkDeliveryZone проверяет, что точка storeCode доставляет (иначе errDeliveryUnavailable)
+// и что клиент входит в её зону доставки. Если у точки есть полигоны — point-in-polygon,
+// иначе радиус stores.delivery_radius_km (или cfg.DeliveryRadiusKm) от координат точки.
+// Без координат клиента или точки проверка зоны пропускается.
+func (h *Handler) checkDeliveryZone(storeCode string, lat, lng float64) error {
+	if storeCode == "" {
+		return nil
+	}
+	settings, err := h.storeDeliverySettings(storeCode)
+	if err != nil {
+		return err
+	}
+	if !settings.Delivers {
+		return errDeliveryUnavailable
+	}
+	if lat == 0 && lng == 0 {
+		return nil
+	}
+
+	rows, err := h.db.Query(`SELECT polygon_geojson FROM delivery_zones WHERE store_code = ?`, storeCode)
+	if err != nil {
+		return err
+	}
+	var zones []polygon
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			rows.Close()
+			return err
+		}
+		polys, err := parseZone(raw)
+		if err != nil {
+			h.logger.Warn("skip invalid delivery zone", zap.String("store", storeCode), zap.Error(err))
+			continue
+		}
+		zones = append(zones, polys...)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(zones) > 0 {
+		for _, p := range zones {
+			if p.contains(lng, lat) {
+				return nil
+			}
+		}
+		return errOutsideDeliveryZone
+	}
+
+	// полигона нет — по радиусу от точки
+	var storeLat, storeLng sql.NullFloat64
+	if err := h.db.QueryRow(`SELECT latitude, longitude FROM stores WHERE code = ?`, storeCode).Scan(&storeLat, &storeLng); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	radius := settings.radiusKm(h.cfg.DeliveryRadiusKm)
+	if !storeLat.Valid || !storeLng.Valid || radius <= 0 {
+		return nil
+	}
+	if distanceKm(storeLat.Float64, storeLng.Float64, lat, lng) > radius {
+		return errOutsideDeliveryZone
+	}
+	return nil
+}
+
+// GET /api/stores/zones — зоны доставки всех точек как GeoJSON FeatureCollection.
+func (h *Handler) handleDeliveryZones(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.db.Query(`
+		SELECT z.id, z.store_code, COALESCE(s.name, ''), z.polygon_geojson
+		FROM delivery_zones z
+		LEFT JOIN stores s ON s.code = z.store_code
+		ORDER BY z.store_code, z.id
+	`)
+	if err != nil {
+		h.logger.Error("list delivery zones", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer rows.Close()
+
+	type feature struct {
+		Type       string          `json:"type"`
+		ID         int64           `json:"id"`
+		Geometry   json.RawMessage `json:"geometry"`
+		Properties map[string]any  `json:"properties"`
+	}
+	features := []feature{}
+	for rows.Next() {
+		var (
+			id              int64
+			code, name, raw string
+		)
+		if err := rows.Scan(&id, &code, &name, &raw); err != nil {
+			h.logger.Error("scan delivery zone", zap.Error(err))
+			continue
+		}
+		if !json.Valid([]byte(raw)) {
+			h.logger.Warn("skip invalid delivery zone", zap.Int64("id", id))
+			continue
+		}
+		features = append(features, feature{
+			Type:       "Feature",
+			ID:         id,
+			Geometry:   json.RawMessage(raw),
+			Properties: map[string]any{"store_code": code, "store_name": name},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"type":     "FeatureCollection",
+		"features": features,
+	})
+}
+
+type setZoneIn struct {
+	StoreCode string          `json:"store_code"`
+	Polygon   json.RawMessage `json:"polygon"` // GeoJSON Polygon/MultiPolygon; null — удалить зону
+}
+
+// POST /api/admin/stores/zone — задать (заменить) зону доставки точки.
+func (h *Handler) handleAdminSetZone(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, ErrMethodNotAllowed())
+		return
+	}
+	if !h.isAdminRequest(r) {
+		writeError(w, ErrForbidden())
+		return
+	}
+	var in setZoneIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, ErrBadRequest("invalid json").Wrap(err))
+		return
+	}
+	in.StoreCode = strings.TrimSpace(in.StoreCode)
+	if in.StoreCode == "" {
+		writeError(w, ErrBadRequest("store_code is required"))
+		return
+	}
+	var cnt int
+	_ = h.db.QueryRow(`SELECT COUNT(1) FROM stores WHERE code = ?`, in.StoreCode).Scan(&cnt)
+	if cnt == 0 {
+		writeError(w, ErrBadRequest("store not found"))
+		return
+	}
+	remove := len(in.Polygon) == 0 || string(in.Polygon) == "null"
+	if !remove {
+		if _, err := parseZone(string(in.Polygon)); err != nil {
+			writeError(w, ErrBadRequest(err.Error()))
+			return
+		}
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		h.logger.Error("tx begin", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(`DELETE FROM delivery_zones WHERE store_code = ?`, in.StoreCode)
+	if err == nil && !remove {
+		_, err = tx.Exec(`INSERT INTO delivery_zones (store_code, polygon_geojson) VALUES (?, ?)`,
+			in.StoreCode, string(in.Polygon))
+	}
+	if err == nil {
+		err = h.writeAudit(tx, h.auditActor(r), "store.zone_set", in.StoreCode, "", map[string]any{"removed": remove})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Error("save delivery zone", zap.Error(err))
+		writeError(w, ErrInternal(err))
+		return
+	}
+	jsonOK(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,355 @@
+package repository
+
+import (
+	"agro/internal/domain"
+	"agro/traits/database"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrOrderNotFound — заказа с таким id нет.
+var ErrOrderNotFound = errors.New("order not found")
+
+// ErrOrderTotalMismatch — сумма записанных позиций не сходится с total_amount:
+// заказ не сохраняем, чтобы не выставить клиенту счёт не на ту сумму.
+var ErrOrderTotalMismatch = errors.New("order total does not match items")
+
+type OrderRepository struct {
+	db *sql.DB
+}
+
+func NewOrderRepository(db *sql.DB) *OrderRepository {
+	return &OrderRepository{db: db}
+}
+
+// GetOrderWithItems возвращает заказ и его позиции одним запросом (LEFT JOIN:
+// заказ без позиций тоже находится). Позиции — в порядке добавления.
+func (r *OrderRepository) GetOrderWithItems(ctx context.Context, orderID int64) (*domain.Order, []domain.OrderItem, error) {
+	const q = `
+		SELECT o.id, o.user_id, COALESCE(o.store_code, ''), o.total_amount, o.goods_total, o.delivery_price, o.status,
+		       COALESCE(o.payment_method, ''), COALESCE(o.parent_order_id, 0), o.created_at,
+		       i.id, i.product_id, i.name, i.unit, i.qty, i.price, i.amount,
+		       COALESCE(i.note, ''), COALESCE(i.allow_substitution, 0),
+		       COALESCE(i.emoji, ''), COALESCE(i.photo_path, ''), COALESCE(i.price_per, '')
+		FROM orders o
+		LEFT JOIN order_items i ON i.order_id = o.id
+		WHERE o.id = ?
+		ORDER BY i.id
+	`
+	rows, err := r.db.QueryContext(ctx, q, orderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var (
+		order *domain.Order
+		items []domain.OrderItem
+	)
+	for rows.Next() {
+		var (
+			o         domain.Order
+			itemID    sql.NullInt64
+			productID sql.NullInt64
+			name      sql.NullString
+			unit      sql.NullString
+			qty       sql.NullFloat64
+			price     sql.NullInt64
+			amount    sql.NullInt64
+			note      string
+			allowSub  int64
+			emoji     string
+			photo     string
+			pricePer  string
+		)
+		if err := rows.Scan(&o.ID, &o.UserID, &o.StoreCode, &o.TotalAmount, &o.GoodsTotal, &o.DeliveryPrice, &o.Status, &o.PaymentMethod, &o.ParentOrderID, &o.CreatedAt,
+			&itemID, &productID, &name, &unit, &qty, &price, &amount, &note, &allowSub,
+			&emoji, &photo, &pricePer); err != nil {
+			return nil, nil, err
+		}
+		if order == nil {
+			order = &o
+		}
+		if !itemID.Valid {
+			continue // заказ без позиций
+		}
+		items = append(items, domain.OrderItem{
+			ProductID: productID.Int64,
+			Name:      name.String,
+			Unit:      unit.String,
+			Qty:       qty.Float64,
+			Price:     price.Int64,
+			Amount:    amount.Int64,
+
+			Note:              note,
+			AllowSubstitution: allowSub != 0,
+
+			Emoji:    emoji,
+			Photo:    photo,
+			PricePer: pricePer,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if order == nil {
+		return nil, nil, ErrOrderNotFound
+	}
+	return order, items, nil
+}
+
+// Create сохраняет заказ со статусом order.Status (по умолчанию new) и его позиции
+// в одной транзакции. У позиции без ProductID (строка «Доставка») product_id = NULL.
+// goods_total и delivery_price считаются по позициям, total_amount — как передан;
+// если сумма записанных товарных позиций не равна total_amount − доставка —
+// откат и ErrOrderTotalMismatch.
+func (r *OrderRepository) Create(ctx context.Context, order *domain.Order) (int64, error) {
+	return r.CreateSplit(ctx, order, nil)
+}
+
+// CreateSplit — Create для заказа из нескольких точек: order (общий чек и
+// оплата, все позиции) и его части children (позиции одной точки каждая,
+// parent_order_id = order.ID) сохраняются одной транзакцией.
+func (r *OrderRepository) CreateSplit(ctx context.Context, order *domain.Order, children []*domain.Order) (int64, error) {
+	if err := r.insertSplit(ctx, order, children, true); err != nil {
+		return 0, err
+	}
+	return order.ID, nil
+}
+
+// DryRunSplit — CreateSplit без сохранения: те же вставки и проверки (сумма,
+// ограничения схемы) в транзакции, которая всегда откатывается. Суммы и
+// статус в order и children заполняются как при настоящем создании, ID — нет.
+func (r *OrderRepository) DryRunSplit(ctx context.Context, order *domain.Order, children []*domain.Order) error {
+	err := r.insertSplit(ctx, order, children, false)
+	order.ID = 0
+	for _, child := range children {
+		child.ID, child.ParentOrderID = 0, 0
+	}
+	return err
+}
+
+func (r *OrderRepository) insertSplit(ctx context.Context, order *domain.Order, children []*domain.Order, commit bool) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := insertOrder(ctx, tx, order); err != nil {
+		return err
+	}
+	for _, child := range children {
+		child.ParentOrderID = order.ID
+		if err := insertOrder(ctx, tx, child); err != nil {
+			return fmt.Errorf("sub-order %s: %w", child.StoreCode, err)
+		}
+	}
+	if !commit {
+		return nil
+	}
+	return tx.Commit()
+}
+
+// insertOrder пишет заказ и позиции в транзакции tx и заполняет order.ID, Status.
+func insertOrder(ctx context.Context, tx *sql.Tx, order *domain.Order) error {
+	status := order.Status
+	if status == "" {
+		status = "new"
+	}
+	order.GoodsTotal, order.DeliveryPrice = 0, 0
+	for _, it := range order.Items {
+		if it.IsDelivery() {
+			order.DeliveryPrice += it.Amount
+		} else {
+			order.GoodsTotal += it.Amount
+		}
+	}
+
+	var parentID any
+	if order.ParentOrderID > 0 {
+		parentID = order.ParentOrderID
+	}
+	orderID, err := database.InsertID(ctx, tx, `
+		INSERT INTO orders (user_id, store_code, total_amount, goods_total, delivery_price, status, payment_method, parent_order_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, order.UserID, nullIfEmpty(order.StoreCode), order.TotalAmount, order.GoodsTotal, order.DeliveryPrice,
+		status, nullIfEmpty(order.PaymentMethod), parentID)
+	if err != nil {
+		return fmt.Errorf("insert order: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO order_items (order_id, product_id, name, unit, qty, price, amount, note, allow_substitution,
+		                         emoji, photo_path, price_per)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, it := range order.Items {
+		var productID any
+		if it.ProductID > 0 {
+			productID = it.ProductID
+		}
+		if _, err := stmt.ExecContext(ctx, orderID, productID, it.Name, it.Unit, it.Qty, it.Price, it.Amount,
+			nullIfEmpty(it.Note), it.AllowSubstitution, nullIfEmpty(it.Emoji), nullIfEmpty(it.Photo), nullIfEmpty(it.PricePer)); err != nil {
+			return fmt.Errorf("insert order item: %w", err)
+		}
+	}
+	// сверяем с тем, что реально легло в order_items (округления, обрезка, триггеры)
+	if len(order.Items) > 0 {
+		var goods int64
+		err := tx.QueryRowContext(ctx, `
+			SELECT COALESCE(SUM(amount), 0) FROM order_items
+			WHERE order_id = ? AND NOT (product_id IS NULL AND name = ?)
+		`, orderID, domain.DeliveryItemName).Scan(&goods)
+		if err != nil {
+			return fmt.Errorf("sum order items: %w", err)
+		}
+		if goods != order.TotalAmount-order.DeliveryPrice {
+			return fmt.Errorf("%w: items %d, total %d, delivery %d",
+				ErrOrderTotalMismatch, goods, order.TotalAmount, order.DeliveryPrice)
+		}
+	}
+	order.ID, order.Status = orderID, status
+	return nil
+}
+
+// Children — части заказа из нескольких точек (без позиций), по id.
+func (r *OrderRepository) Children(ctx context.Context, parentID int64) ([]domain.Order, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, COALESCE(store_code, ''), total_amount, goods_total, delivery_price, status,
+		       COALESCE(payment_method, ''), COALESCE(parent_order_id, 0), created_at
+		FROM orders
+		WHERE parent_order_id = ?
+		ORDER BY id
+	`, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []domain.Order
+	for rows.Next() {
+		var o domain.Order
+		if err := rows.Scan(&o.ID, &o.UserID, &o.StoreCode, &o.TotalAmount, &o.GoodsTotal, &o.DeliveryPrice, &o.Status,
+			&o.PaymentMethod, &o.ParentOrderID, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// Get — заказ с позициями (Items); ErrOrderNotFound, если заказа нет.
+func (r *OrderRepository) Get(ctx context.Context, orderID int64) (*domain.Order, error) {
+	order, items, err := r.GetOrderWithItems(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	order.Items = items
+	return order, nil
+}
+
+// ListByUser — последние limit заказов пользователя, новые первыми, без позиций.
+// Части заказа из нескольких точек не попадают: пользователь видит общий заказ.
+func (r *OrderRepository) ListByUser(ctx context.Context, userID int64, limit int) ([]domain.Order, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, COALESCE(store_code, ''), total_amount, goods_total, delivery_price, status,
+		       COALESCE(payment_method, ''), created_at
+		FROM orders
+		WHERE user_id = ? AND parent_order_id IS NULL
+		ORDER BY id DESC
+		LIMIT ?
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []domain.Order
+	for rows.Next() {
+		var o domain.Order
+		if err := rows.Scan(&o.ID, &o.UserID, &o.StoreCode, &o.TotalAmount, &o.GoodsTotal, &o.DeliveryPrice, &o.Status, &o.PaymentMethod, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// LatestByStatus — последний заказ пользователя в одном из статусов, с позициями
+// (без частей заказа из нескольких точек); ErrOrderNotFound, если такого нет.
+func (r *OrderRepository) LatestByStatus(ctx context.Context, userID int64, statuses ...string) (*domain.Order, error) {
+	if len(statuses) == 0 {
+		return nil, ErrOrderNotFound
+	}
+	args := []any{userID}
+	for _, s := range statuses {
+		args = append(args, s)
+	}
+	var id int64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT id FROM orders
+		WHERE user_id = ? AND parent_order_id IS NULL AND status IN (?`+strings.Repeat(", ?", len(statuses)-1)+`)
+		ORDER BY id DESC
+		LIMIT 1
+	`, args...).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.Get(ctx, id)
+}
+
+// UpdateStatus меняет статус заказа. from != "" — только если текущий статус
+// равен from (защита от гонки двух админов); иначе статус ставится безусловно.
+// Возвращает false, если заказ не найден или статус уже успел измениться.
+func (r *OrderRepository) UpdateStatus(ctx context.Context, orderID int64, from, to string) (bool, error) {
+	q := `UPDATE orders SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	args := []any{to, orderID}
+	if from != "" {
+		q += ` AND status = ?`
+		args = append(args, from)
+	}
+	res, err := r.db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func nullIfEmpty(s string) any {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	return s
+}
+
+// SetPaymentMethod меняет способ оплаты, только если сейчас он равен from —
+// выбор пользователя и таймаут по умолчанию не перетирают друг друга.
+func (r *OrderRepository) SetPaymentMethod(ctx context.Context, orderID int64, from, to string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE orders SET payment_method = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND payment_method = ?
+	`, to, orderID, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"agro/internal/domain"
+	"agro/traits/database"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestGetOrderWithItems(t *testing.T) {
+	db, err := database.InitDatabase(database.DriverSQLite, "file:order_repo_test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, q := range []string{
+		`INSERT INTO orders (id, user_id, store_code, total_amount, status) VALUES (1, 555, 'samal3', 2300, 'new')`,
+		`INSERT INTO orders (id, user_id, total_amount, status) VALUES (2, 777, 900, 'new')`,
+		`INSERT INTO orders (id, user_id, total_amount, status) VALUES (3, 555, 0, 'new')`,
+		`INSERT INTO order_items (order_id, product_id, name, unit, qty, price, amount) VALUES (1, 10, 'Картофель', 'кг', 2, 250, 500)`,
+		`INSERT INTO order_items (order_id, product_id, name, unit, qty, price, amount) VALUES (2, 11, 'Лук', 'кг', 3, 300, 900)`,
+		`INSERT INTO order_items (order_id, product_id, name, unit, qty, price, amount) VALUES (1, 12, 'Морковь', 'кг', 1.5, 200, 300)`,
+		`INSERT INTO order_items (order_id, product_id, name, unit, qty, price, amount) VALUES (1, NULL, 'Доставка', 'услуга', 1, 1500, 1500)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo := NewOrderRepository(db)
+	ctx := context.Background()
+
+	order, items, err := repo.GetOrderWithItems(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.ID != 1 || order.UserID != 555 || order.StoreCode != "samal3" || order.TotalAmount != 2300 || order.CreatedAt.IsZero() {
+		t.Fatalf("order = %+v", order)
+	}
+	// только позиции заказа 1, в порядке добавления; у доставки product_id = 0
+	if len(items) != 3 || items[0].Name != "Картофель" || items[1].Qty != 1.5 || items[2].ProductID != 0 || items[2].Amount != 1500 {
+		t.Fatalf("items = %+v", items)
+	}
+
+	order, items, err = repo.GetOrderWithItems(ctx, 3)
+	if err != nil || order.ID != 3 || len(items) != 0 {
+		t.Fatalf("order without items = %+v, %+v, %v", order, items, err)
+	}
+
+	if _, _, err := repo.GetOrderWithItems(ctx, 99); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("missing order err = %v", err)
+	}
+}
+
+func TestOrderRepositoryLifecycle(t *testing.T) {
+	db, err := database.InitDatabase(database.DriverSQLite, "file:order_repo_lifecycle_test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo := NewOrderRepository(db)
+	ctx := context.Background()
+
+	in := &domain.Order{
+		UserID:        555,
+		StoreCode:     "samal3",
+		TotalAmount:   2000,
+		PaymentMethod: "cash",
+		Items: []domain.OrderItem{
+			{ProductID: 10, Name: "Картофель", Unit: "кг", Qty: 2, Price: 250, Amount: 500, Note: "спелые", Emoji: "🥔", Photo: "/uploads/potato.jpg"},
+			{Name: "Доставка", Unit: "услуга", Qty: 1, Price: 1500, Amount: 1500},
+		},
+	}
+	id, err := repo.Create(ctx, in)
+	if err != nil || id == 0 || in.ID != id || in.Status != "new" {
+		t.Fatalf("create = %d, %v, order %+v", id, err, in)
+	}
+	second, err := repo.Create(ctx, &domain.Order{UserID: 555, TotalAmount: 300, Status: "checking"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Create(ctx, &domain.Order{UserID: 777, TotalAmount: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	// total не сходится с позициями — заказ не сохраняется
+	bad := &domain.Order{UserID: 555, TotalAmount: 2100, Items: in.Items}
+	if _, err := repo.Create(ctx, bad); !errors.Is(err, ErrOrderTotalMismatch) {
+		t.Fatalf("mismatch err = %v", err)
+	}
+	if list, _ := repo.ListByUser(ctx, 555, 10); len(list) != 2 {
+		t.Fatalf("mismatched order saved: %d orders", len(list))
+	}
+
+	got, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.PaymentMethod != "cash" || got.StoreCode != "samal3" || len(got.Items) != 2 ||
+		got.Items[0].Note != "спелые" || got.Items[0].Emoji != "🥔" || got.Items[0].Photo != "/uploads/potato.jpg" ||
+		got.Items[1].ProductID != 0 || got.Items[1].Emoji != "" {
+		t.Fatalf("get = %+v", got)
+	}
+	// строка «Доставка» уходит в delivery_price, остальное — в goods_total
+	if got.GoodsTotal != 500 || got.DeliveryPrice != 1500 || in.GoodsTotal != 500 {
+		t.Fatalf("totals = %d + %d", got.GoodsTotal, got.DeliveryPrice)
+	}
+
+	list, err := repo.ListByUser(ctx, 555, 10)
+	if err != nil || len(list) != 2 || list[0].ID != second || list[0].Status != "checking" || list[1].Items != nil {
+		t.Fatalf("list = %+v, %v", list, err)
+	}
+	if list, _ := repo.ListByUser(ctx, 555, 1); len(list) != 1 {
+		t.Fatalf("limit = %d", len(list))
+	}
+
+	// смена статуса с проверкой текущего: второй админ с устаревшим from проигрывает
+	if ok, err := repo.UpdateStatus(ctx, id, "new", "preparing"); !ok || err != nil {
+		t.Fatalf("update = %v, %v", ok, err)
+	}
+	if ok, _ := repo.UpdateStatus(ctx, id, "new", "cancelled"); ok {
+		t.Fatal("stale from must not update")
+	}
+	if ok, _ := repo.UpdateStatus(ctx, id, "", "paid"); !ok {
+		t.Fatal("unconditional update failed")
+	}
+	if ok, _ := repo.UpdateStatus(ctx, 999, "", "paid"); ok {
+		t.Fatal("missing order updated")
+	}
+	if got, _ := repo.Get(ctx, id); got.Status != "paid" {
+		t.Fatalf("status = %q", got.Status)
+	}
+}
+
+func TestOrderRepositoryDryRun(t *testing.T) {
+	db, err := database.InitDatabase(database.DriverSQLite, "file:order_repo_dry_run_test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo := NewOrderRepository(db)
+	ctx := context.Background()
+
+	order := &domain.Order{
+		UserID: 555, StoreCode: "samal3", TotalAmount: 2000,
+		Items: []domain.OrderItem{
+			{ProductID: 10, Name: "Картофель", Unit: "кг", Qty: 2, Price: 250, Amount: 500},
+			{Name: "Доставка", Unit: "услуга", Qty: 1, Price: 1500, Amount: 1500},
+		},
+	}
+	child := &domain.Order{UserID: 555, StoreCode: "samal3", TotalAmount: 500, Items: order.Items[:1]}
+	if err := repo.DryRunSplit(ctx, order, []*domain.Order{child}); err != nil {
+		t.Fatal(err)
+	}
+	if order.ID != 0 || child.ID != 0 || child.ParentOrderID != 0 || order.Status != "new" ||
+		order.GoodsTotal != 500 || order.DeliveryPrice != 1500 || child.GoodsTotal != 500 {
+		t.Fatalf("dry run = %+v / %+v", order, child)
+	}
+	var n int
+	_ = db.QueryRow(`SELECT COUNT(1) FROM orders`).Scan(&n)
+	if n != 0 {
+		t.Fatalf("dry run saved %d orders", n)
+	}
+
+	// проверки те же, что у CreateSplit
+	bad := &domain.Order{UserID: 555, TotalAmount: 2100, Items: order.Items}
+	if err := repo.DryRunSplit(ctx, bad, nil); !errors.Is(err, ErrOrderTotalMismatch) {
+		t.Fatalf("mismatch err = %v", err)
+	}
+}
@@ -3,6 +3,8 @@ package repository
 import (
 	"agro/internal/domain"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -40,6 +42,28 @@ func (r *ChatRepository) HitOnce(ctx context.Context, key string, ttl time.Durat
 	return false, ttlLeft, nil
 }
 
+// HitLimit counts a hit in a fixed window of length window started by the first hit.
+// Returns (allowed=true) while the count is within limit; otherwise allowed=false and ttlLeft.
+func (r *ChatRepository) HitLimit(ctx context.Context, key string, limit int64, window time.Duration) (allowed bool, ttlLeft time.Duration, err error) {
+	n, err := r.client.Incr(ctx, key).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	if n == 1 {
+		if err := r.client.Expire(ctx, key, window).Err(); err != nil {
+			return false, 0, err
+		}
+	}
+	if n <= limit {
+		return true, 0, nil
+	}
+	ttlLeft, err = r.TTL(ctx, key)
+	if err != nil {
+		return false, 0, err
+	}
+	return false, ttlLeft, nil
+}
+
 // TTL returns remaining TTL (0 if none/expired).
 func (r *ChatRepository) TTL(ctx context.Context, key string) (time.Duration, error) {
 	d, err := r.client.TTL(ctx, key).Result()
@@ -52,6 +76,32 @@ func (r *ChatRepository) TTL(ctx context.Context, key string) (time.Duration, er
 	return d, nil
 }
 
+// unlockScript удаляет ключ, только если он всё ещё принадлежит нам (token),
+// чтобы не снять чужую блокировку после истечения TTL.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// TryLock ставит блокировку key (SETNX с TTL). ok=false — ключ уже занят.
+func (r *ChatRepository) TryLock(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
+	ok, err := r.client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %s: %w", key, err)
+	}
+	return ok, nil
+}
+
+// Unlock снимает блокировку, поставленную TryLock с тем же token.
+func (r *ChatRepository) Unlock(ctx context.Context, key, token string) error {
+	if err := unlockScript.Run(ctx, r.client, []string{key}, token).Err(); err != nil {
+		return fmt.Errorf("failed to release lock %s: %w", key, err)
+	}
+	return nil
+}
+
 // User state methods
 func (r *ChatRepository) SaveUserState(ctx context.Context, userID int64, state *domain.UserState) error {
 	key := fmt.Sprintf("user_state:%d", userID)
@@ -101,6 +151,122 @@ func (r *ChatRepository) DeleteUserState(ctx context.Context, userID int64) erro
 	return nil
 }
 
+// notifyDedupPrefixLen — сколько символов текста уведомления идёт в ключ дедупликации.
+const notifyDedupPrefixLen = 100
+
+// NotifyDedupKey — ключ дедупликации уведомления админу: sha256 от первых
+// 100 символов текста. Общий для всех экземпляров бота.
+func NotifyDedupKey(text string) string {
+	if r := []rune(text); len(r) > notifyDedupPrefixLen {
+		text = string(r[:notifyDedupPrefixLen])
+	}
+	sum := sha256.Sum256([]byte(text))
+	return "notify_dedup:" + hex.EncodeToString(sum[:])
+}
+
+// ProductCacheKey — ключ кэша каталога, выданного пользователю (зависит от выбранной точки).
+func ProductCacheKey(userID int64) string {
+	return fmt.Sprintf("products:user:%d", userID)
+}
+
+// DeleteProductCache сбрасывает кэш каталога пользователя, например после смены точки.
+func (r *ChatRepository) DeleteProductCache(ctx context.Context, userID int64) error {
+	if err := r.client.Del(ctx, ProductCacheKey(userID)).Err(); err != nil {
+		return fmt.Errorf("failed to delete product cache from redis: %w", err)
+	}
+	return nil
+}
+
+// ProductsCacheTTL — сколько живёт кэш каталога /api/products.
+const ProductsCacheTTL = 60 * time.Second
+
+const productsCachePrefix = "products:catalog:"
+
+// ProductsCacheKey — ключ кэша каталога для фильтра по точке и ярлыку ("" — без фильтра).
+func ProductsCacheKey(storeCode, tag string) string {
+	return productsCachePrefix + storeCode + ":" + tag
+}
+
+// SubscriberProductsCacheKey — ключ кэша каталога с оптовыми ценами (для подписчиков).
+func SubscriberProductsCacheKey(storeCode, tag string) string {
+	return productsCachePrefix + "subscriber:" + storeCode + ":" + tag
+}
+
+// InlineSearchCacheKey — ключ кэша inline-поиска (@bot запрос). Префикс общий
+// с каталогом, поэтому InvalidateProductsCache сбрасывает и его.
+func InlineSearchCacheKey(storeCode, query string) string {
+	return productsCachePrefix + "inline:" + storeCode + ":" + query
+}
+
+// GetProductsCache возвращает закэшированный JSON каталога; nil — кэша нет.
+func (r *ChatRepository) GetProductsCache(ctx context.Context, key string) ([]byte, error) {
+	data, err := r.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get products cache from redis: %w", err)
+	}
+	return data, nil
+}
+
+// SaveProductsCache кладёт JSON каталога в кэш на ProductsCacheTTL.
+func (r *ChatRepository) SaveProductsCache(ctx context.Context, key string, data []byte) error {
+	if err := r.client.Set(ctx, key, data, ProductsCacheTTL).Err(); err != nil {
+		return fmt.Errorf("failed to save products cache to redis: %w", err)
+	}
+	return nil
+}
+
+// InvalidateProductsCache удаляет кэш каталога по всем фильтрам.
+// Вызывается после любых изменений товаров из админки.
+func (r *ChatRepository) InvalidateProductsCache(ctx context.Context) error {
+	iter := r.client.Scan(ctx, 0, productsCachePrefix+"*", 100).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to scan products cache keys: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := r.client.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to delete products cache from redis: %w", err)
+	}
+	return nil
+}
+
+// SuggestCacheTTL — сколько живут подсказки адресов /api/address/suggest.
+const SuggestCacheTTL = time.Hour
+
+// SuggestCacheKey — ключ кэша подсказок адреса: sha256 от запроса.
+func SuggestCacheKey(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return "suggest:" + hex.EncodeToString(sum[:])
+}
+
+// GetSuggestCache возвращает закэшированный JSON подсказок; nil — кэша нет.
+func (r *ChatRepository) GetSuggestCache(ctx context.Context, key string) ([]byte, error) {
+	data, err := r.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get address suggest cache from redis: %w", err)
+	}
+	return data, nil
+}
+
+// SaveSuggestCache кладёт JSON подсказок в кэш на SuggestCacheTTL.
+func (r *ChatRepository) SaveSuggestCache(ctx context.Context, key string, data []byte) error {
+	if err := r.client.Set(ctx, key, data, SuggestCacheTTL).Err(); err != nil {
+		return fmt.Errorf("failed to save address suggest cache to redis: %w", err)
+	}
+	return nil
+}
+
 // Admin state methods (using same UserState structure)
 func (r *ChatRepository) SaveAdminState(ctx context.Context, adminID int64, state *domain.UserState) error {
 	key := fmt.Sprintf("admin_state:%d", adminID)
@@ -204,7 +370,7 @@ func (r *ChatRepository) ClearAllUserStates(ctx context.Context, userID int64) e
 	return nil
 }
 
-// Health check method
+// Ping — проверка Redis для /readyz.
 func (r *ChatRepository) Ping(ctx context.Context) error {
 	return r.client.Ping(ctx).Err()
 }
@@ -20,7 +20,11 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 }
 
 func (r *UserRepository) GetAllJustUserIDs(ctx context.Context) ([]int64, error) {
-	const q = `SELECT id_user FROM just ORDER BY created_at DESC;`
+	// отписавшиеся от рассылок (users.marketing_opt_out) в список не попадают
+	const q = `
+		SELECT id_user FROM just
+		WHERE id_user NOT IN (SELECT user_id FROM users WHERE marketing_opt_out = 1)
+		ORDER BY created_at DESC;`
 	rows, err := r.db.QueryContext(ctx, q)
 	if err != nil {
 		return nil, err
@@ -95,11 +99,15 @@ func (r *UserRepository) ExistsJust(ctx context.Context, userId int64) (bool, er
 	return cnt > 0, nil
 }
 
-// InsertJust вставляет запись в таблицу just с учетом новых полей (SQLite version)
+// InsertJust вставляет запись в таблицу just или обновляет существующую по id_user
 func (r *UserRepository) InsertJust(ctx context.Context, e domain.JustEntry) error {
 	const q = `
-		INSERT OR REPLACE INTO just (id_user, userName, dataRegistred, updated_at)
-		VALUES (?, ?, ?, datetime('now'));
+		INSERT INTO just (id_user, userName, dataRegistred, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (id_user) DO UPDATE SET
+			userName = excluded.userName,
+			dataRegistred = excluded.dataRegistred,
+			updated_at = excluded.updated_at;
 	`
 	_, err := r.db.ExecContext(ctx, q, e.UserId, e.UserName, e.DateRegistered)
 	return err
@@ -153,8 +161,8 @@ func (r *UserRepository) FindUsersByFilters(sex string, ageMin, ageMax *int, q s
 		args = append(args, *ageMax)
 	}
 	if q != "" {
-		query += " AND (LOWER(nickname) LIKE ? OR LOWER(about_user) LIKE ?)"
-		pat := "%" + strings.ToLower(q) + "%"
+		query += ` AND (LOWER(nickname) LIKE ? ESCAPE '\' OR LOWER(about_user) LIKE ? ESCAPE '\')`
+		pat := "%" + escapeLike(strings.ToLower(q)) + "%"
 		args = append(args, pat, pat)
 	}
 
@@ -185,6 +193,14 @@ func (r *UserRepository) FindUsersByFilters(sex string, ageMin, ageMax *int, q s
 	return res, rows.Err()
 }
 
+// escapeLike экранирует спецсимволы LIKE (\, %, _), чтобы строка из запроса
+// искалась буквально. Использовать вместе с ESCAPE '\'.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 // GetUserNickname возвращает user_nickname для данного user_id.
 func (r *UserRepository) GetUserNickname(userID int64) (string, error) {
 	query := `SELECT nickname FROM users WHERE user_id = ?`
@@ -220,8 +236,8 @@ func (r *UserRepository) FindUsersInBBox(latMin, latMax, lonMin, lonMax float64,
 		args = append(args, *ageMax)
 	}
 	if q != "" {
-		query += " AND (LOWER(nickname) LIKE ? OR LOWER(about_user) LIKE ?)"
-		pat := "%" + strings.ToLower(q) + "%"
+		query += ` AND (LOWER(nickname) LIKE ? ESCAPE '\' OR LOWER(about_user) LIKE ? ESCAPE '\')`
+		pat := "%" + escapeLike(strings.ToLower(q)) + "%"
 		args = append(args, pat, pat)
 	}
 
@@ -0,0 +1,8 @@
+// Package static — страницы мини-аппа, вшитые в бинарник: сервису не нужен
+// каталог ./static рядом с собой. Переопределить на диск — STATIC_DIR.
+package static
+
+import "embed"
+
+//go:embed *.html
+var FS embed.FS
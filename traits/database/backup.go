@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	backupPrefix     = "agro-"
+	backupTimeLayout = "20060102-150405.000000000"
+)
+
+// BackupDatabase делает снапшот базы через VACUUM INTO в каталог dir
+// и возвращает путь к созданному файлу. В режиме WAL писатели не блокируются
+// на всё время копирования.
+func BackupDatabase(ctx context.Context, db *sql.DB, dir string) (string, error) {
+	if IsPostgres(db) {
+		return "", errors.New("backups via VACUUM INTO are sqlite-only; use pg_dump for postgres")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create backup dir: %w", err)
+	}
+
+	// наносекунды в имени: два снапшота в одну секунду не сталкиваются,
+	// а сортировка по имени по-прежнему совпадает с сортировкой по времени
+	name := fmt.Sprintf("%s%s.db", backupPrefix, time.Now().Format(backupTimeLayout))
+	dst := filepath.Join(dir, name)
+
+	if _, err := db.ExecContext(ctx, `VACUUM INTO ?`, dst); err != nil {
+		return "", fmt.Errorf("vacuum into %s: %w", dst, err)
+	}
+	return dst, nil
+}
+
+// PruneBackups оставляет в каталоге dir только keep самых свежих снапшотов.
+func PruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("read backup dir: %w", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(e.Name(), backupPrefix) && strings.HasSuffix(e.Name(), ".db") {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) <= keep {
+		return nil
+	}
+
+	// имена содержат метку времени, поэтому сортировка по имени = по дате
+	sort.Strings(names)
+	for _, n := range names[:len(names)-keep] {
+		if err := os.Remove(filepath.Join(dir, n)); err != nil {
+			return fmt.Errorf("remove old backup %s: %w", n, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackupDatabaseNames(t *testing.T) {
+	db, err := InitDatabase(DriverSQLite, filepath.Join(t.TempDir(), "agro.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		path, err := BackupDatabase(context.Background(), db, dir)
+		if err != nil {
+			t.Fatalf("backup %d: %v", i, err)
+		}
+		name := filepath.Base(path)
+		if seen[name] {
+			t.Fatalf("backup name %s reused", name)
+		}
+		seen[name] = true
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), ".db")
+		if _, err := time.Parse(backupTimeLayout, stamp); err != nil {
+			t.Fatalf("backup name %s: %v", name, err)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		"agro-20260101-100000.000000000.db",
+		"agro-20260101-100000.500000000.db",
+		"agro-20260102-090000.000000000.db",
+		"agro-20260103-080000.000000000.db",
+		"notes.txt",
+		"other-20250101.db",
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := PruneBackups(dir, 2); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	sort.Strings(got)
+	want := []string{
+		"agro-20260102-090000.000000000.db",
+		"agro-20260103-080000.000000000.db",
+		"notes.txt",
+		"other-20250101.db",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("after prune = %v, want %v", got, want)
+	}
+
+	// keep <= 0 — ничего не удаляем
+	if err := PruneBackups(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != len(want) {
+		t.Fatalf("prune with keep=0 removed files: %d left", len(entries))
+	}
+}
+
+func TestWithBusyTimeout(t *testing.T) {
+	cases := map[string]string{
+		"agro.db":                         "agro.db?_busy_timeout=5000",
+		"file:x?mode=memory&cache=shared": "file:x?mode=memory&cache=shared&_busy_timeout=5000",
+		"agro.db?_busy_timeout=100":       "agro.db?_busy_timeout=100",
+		"file:agro.db?_timeout=100&_fk=1": "file:agro.db?_timeout=100&_fk=1",
+	}
+	for in, want := range cases {
+		if got := withBusyTimeout(in); got != want {
+			t.Errorf("withBusyTimeout(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
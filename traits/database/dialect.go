@@ -0,0 +1,179 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Поддерживаемые значения DB_DRIVER.
+const (
+	DriverSQLite   = "sqlite3"
+	DriverPostgres = "postgres"
+)
+
+// postgresDriverName — lib/pq, обёрнутый так, чтобы запросы с `?` работали без изменений.
+const postgresDriverName = "agro-postgres"
+
+func init() {
+	sql.Register(postgresDriverName, rebindDriver{pq.Driver{}})
+}
+
+// IsPostgres сообщает, что db открыта через DriverPostgres.
+func IsPostgres(db *sql.DB) bool {
+	_, ok := db.Driver().(rebindDriver)
+	return ok
+}
+
+// Rebind переводит плейсхолдеры `?` в `$1, $2, ...` для PostgreSQL.
+// `?` внутри строковых литералов и идентификаторов в кавычках не трогаются.
+func Rebind(driverName, query string) string {
+	if driverName != DriverPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var (
+		b     strings.Builder
+		n     int
+		quote byte
+	)
+	b.Grow(len(query) + 8)
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+var (
+	reAutoincrement = regexp.MustCompile(`(?i)INTEGER\s+PRIMARY\s+KEY\s+AUTOINCREMENT`)
+	reInteger       = regexp.MustCompile(`\bINTEGER\b`)
+	reDatetime      = regexp.MustCompile(`\bDATETIME\b`)
+	reReal          = regexp.MustCompile(`\bREAL\b`)
+	reTrigger       = regexp.MustCompile(`(?is)CREATE\s+TRIGGER.*?\bEND;`)
+	reInsertIgnore  = regexp.MustCompile(`(?is)INSERT\s+OR\s+IGNORE\s+INTO(.*?);`)
+)
+
+// translateDDL переводит DDL из диалекта SQLite в PostgreSQL:
+// AUTOINCREMENT → BIGSERIAL, INTEGER → BIGINT (Telegram ID не влезают в int4),
+// DATETIME → TIMESTAMP, REAL → DOUBLE PRECISION, INSERT OR IGNORE → ON CONFLICT DO NOTHING.
+// Триггеры updated_at выбрасываются: в PostgreSQL updated_at получает только
+// DEFAULT CURRENT_TIMESTAMP при вставке, а UPDATE его не меняют, если запрос
+// не выставляет updated_at сам.
+func translateDDL(stmt string) string {
+	stmt = reTrigger.ReplaceAllString(stmt, "")
+	stmt = reAutoincrement.ReplaceAllString(stmt, "BIGSERIAL PRIMARY KEY")
+	stmt = reInteger.ReplaceAllString(stmt, "BIGINT")
+	stmt = reDatetime.ReplaceAllString(stmt, "TIMESTAMP")
+	stmt = reReal.ReplaceAllString(stmt, "DOUBLE PRECISION")
+	stmt = reInsertIgnore.ReplaceAllString(stmt, "INSERT INTO$1 ON CONFLICT DO NOTHING;")
+	return stmt
+}
+
+// Queryer — *sql.DB или *sql.Tx.
+type Queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// InsertID выполняет INSERT и возвращает id новой строки. lib/pq не
+// поддерживает LastInsertId, поэтому id берётся через RETURNING id —
+// его понимают и PostgreSQL, и SQLite (с 3.35).
+func InsertID(ctx context.Context, q Queryer, query string, args ...any) (int64, error) {
+	var id int64
+	err := q.QueryRowContext(ctx, strings.TrimRight(query, " \t\n;")+" RETURNING id", args...).Scan(&id)
+	return id, err
+}
+
+// execDDL выполняет DDL с учётом диалекта базы.
+func execDDL(db *sql.DB, stmt string) error {
+	if IsPostgres(db) {
+		stmt = translateDDL(stmt)
+	}
+	_, err := db.Exec(stmt)
+	return err
+}
+
+// rebindDriver / rebindConn прозрачно применяют Rebind ко всем запросам,
+// поэтому код handler с `?` работает и на PostgreSQL. Диалект самих запросов
+// не переводится: они написаны на общем подмножестве SQLite и PostgreSQL
+// (InsertID вместо LastInsertId, ON CONFLICT DO NOTHING, время — параметром).
+type rebindDriver struct{ driver.Driver }
+
+func (d rebindDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &rebindConn{c}, nil
+}
+
+type rebindConn struct{ driver.Conn }
+
+func (c *rebindConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(Rebind(DriverPostgres, query))
+}
+
+func (c *rebindConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, Rebind(DriverPostgres, query))
+	}
+	return c.Prepare(query)
+}
+
+func (c *rebindConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return e.ExecContext(ctx, Rebind(DriverPostgres, query), args)
+}
+
+func (c *rebindConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return q.QueryContext(ctx, Rebind(DriverPostgres, query), args)
+}
+
+func (c *rebindConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != 0 || opts.ReadOnly {
+		return nil, errors.New("rebind: driver does not support transaction options")
+	}
+	return c.Conn.Begin()
+}
+
+func (c *rebindConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *rebindConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
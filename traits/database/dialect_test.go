@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	q := `SELECT id FROM users WHERE user_id = ? AND nickname <> '?' AND phone = ?`
+	if got := Rebind(DriverSQLite, q); got != q {
+		t.Fatalf("sqlite query changed: %q", got)
+	}
+	want := `SELECT id FROM users WHERE user_id = $1 AND nickname <> '?' AND phone = $2`
+	if got := Rebind(DriverPostgres, q); got != want {
+		t.Fatalf("Rebind = %q, want %q", got, want)
+	}
+}
+
+func TestTranslateDDL(t *testing.T) {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS products (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		price INTEGER NOT NULL,
+		lat REAL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TRIGGER IF NOT EXISTS trg_products_updated_at
+	AFTER UPDATE ON products
+	FOR EACH ROW BEGIN
+	  UPDATE products SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+	END;
+	INSERT OR IGNORE INTO subscription_plans (code) VALUES ('month');
+	`
+	got := translateDDL(stmt)
+	for _, want := range []string{
+		"id BIGSERIAL PRIMARY KEY,",
+		"price BIGINT NOT NULL",
+		"lat DOUBLE PRECISION",
+		"updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+		"INSERT INTO subscription_plans (code) VALUES ('month') ON CONFLICT DO NOTHING;",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("translated DDL missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "TRIGGER") || strings.Contains(got, "AUTOINCREMENT") {
+		t.Errorf("sqlite-only syntax left:\n%s", got)
+	}
+}
+
+func TestInsertID(t *testing.T) {
+	db, err := sql.Open(DriverSQLite, "file:insert_id_test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE t (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for want := int64(1); want <= 2; want++ {
+		id, err := InsertID(ctx, db, `INSERT INTO t (name) VALUES (?);`, "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want {
+			t.Fatalf("InsertID = %d, want %d", id, want)
+		}
+	}
+}
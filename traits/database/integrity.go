@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// requiredColumns — колонки, на которые напрямую опираются запросы в handler.
+// CREATE TABLE IF NOT EXISTS не добавляет колонки в уже существующие таблицы,
+// поэтому старая база может их не содержать.
+var requiredColumns = []struct {
+	table   string
+	columns []string
+}{
+	{"users", []string{"user_id", "nickname", "phone", "sub_status", "sub_until", "selected_store", "previous_store", "store_changed_at", "marketing_opt_out", "price_drop_notified_at"}},
+	{"stores", []string{"code", "name", "address", "longitude", "latitude", "address_formatted", "working_hours", "delivers", "delivery_radius_km"}},
+	{"categories", []string{"slug", "name", "sort_order", "parent_slug"}},
+	{"products", []string{"name", "emoji", "category_slug", "unit", "price", "active", "description", "photo_path", "store_code", "featured", "sort_order", "stock_qty", "subscriber_only", "retail_price", "price_per", "max_qty", "cost_price"}},
+	{"subscriptions", []string{"user_id", "phone", "status", "amount", "valid_until", "decided_by", "decided_by_name", "decided_at"}},
+	{"orders", []string{"user_id", "store_code", "total_amount", "status", "payment_method", "payment_decided_by", "payment_decided_by_name", "payment_decided_at", "goods_total", "delivery_price", "parent_order_id"}},
+	{"order_items", []string{"order_id", "product_id", "name", "unit", "qty", "price", "amount", "note", "allow_substitution", "emoji", "photo_path", "price_per"}},
+}
+
+// IntegrityCheck запускает PRAGMA quick_check (full=false) или integrity_check (full=true).
+// Возвращает список найденных проблем; пустой список — база в порядке.
+// Для PostgreSQL проверки файла нет — всегда пустой список.
+func IntegrityCheck(ctx context.Context, db *sql.DB, full bool) ([]string, error) {
+	if IsPostgres(db) {
+		return nil, nil
+	}
+	pragma := "PRAGMA quick_check"
+	if full {
+		pragma = "PRAGMA integrity_check"
+	}
+	rows, err := db.QueryContext(ctx, pragma)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", pragma, err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, fmt.Errorf("scan %s: %w", pragma, err)
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	return problems, rows.Err()
+}
+
+// VerifySchema проверяет, что все колонки из requiredColumns существуют,
+// и возвращает ошибку с точными именами отсутствующих колонок.
+func VerifySchema(db *sql.DB) error {
+	for _, rc := range requiredColumns {
+		table, cols := rc.table, rc.columns
+		have, err := tableColumns(db, table)
+		if err != nil {
+			return err
+		}
+		if len(have) == 0 {
+			return fmt.Errorf("missing table %s", table)
+		}
+		var missing []string
+		for _, c := range cols {
+			if !have[c] {
+				missing = append(missing, table+"."+c)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing column(s): %s", strings.Join(missing, ", "))
+		}
+	}
+	return nil
+}
+
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	if IsPostgres(db) {
+		return tableColumnsPostgres(db, table)
+	}
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, fmt.Errorf("table_info %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	cols := map[string]bool{}
+	for rows.Next() {
+		var (
+			cid     int
+			name    string
+			typ     string
+			notNull int
+			dflt    sql.NullString
+			pk      int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return nil, fmt.Errorf("scan table_info %s: %w", table, err)
+		}
+		cols[name] = true
+	}
+	return cols, rows.Err()
+}
+
+func tableColumnsPostgres(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(`
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ?
+	`, table)
+	if err != nil {
+		return nil, fmt.Errorf("columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	cols := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scan columns of %s: %w", table, err)
+		}
+		cols[name] = true
+	}
+	return cols, rows.Err()
+}
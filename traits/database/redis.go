@@ -11,29 +11,68 @@ import (
 
 // Existing CreateTables function remains the same...
 
-// ConnectRedis creates a new Redis client connection
+// Повторные попытки подключения при старте: в docker-compose/k8s Redis
+// нередко поднимается позже бота.
+const (
+	redisConnectAttempts   = 6
+	redisConnectBackoff    = 500 * time.Millisecond // удваивается до redisConnectMaxBackoff
+	redisConnectMaxBackoff = 8 * time.Second
+	redisPingTimeout       = 3 * time.Second
+)
+
+// redisOptions — настройки пула: соединения держим недолго, запросы не ждут
+// свободного соединения дольше PoolTimeout.
+func redisOptions(addr string) *redis.Options {
+	return &redis.Options{
+		Addr:            addr,
+		Password:        "",              // No password set
+		DB:              0,               // Use default DB
+		DialTimeout:     5 * time.Second, // Connection timeout
+		ReadTimeout:     3 * time.Second, // Read timeout
+		WriteTimeout:    3 * time.Second, // Write timeout
+		PoolSize:        10,              // Connection pool size
+		MinIdleConns:    2,               // Minimum idle connections
+		PoolTimeout:     4 * time.Second, // ожидание свободного соединения из пула
+		ConnMaxIdleTime: 5 * time.Minute, // простаивающие соединения закрываем
+		MaxRetries:      2,               // повтор команды при сетевой ошибке
+	}
+}
+
+// ConnectRedis creates a new Redis client connection.
+// Если Redis ещё не готов, пробует redisConnectAttempts раз с растущей паузой.
 func ConnectRedis(ctx context.Context, logger *zap.Logger) (*redis.Client, error) {
-	// Redis connection options matching your docker-compose
-	rdb := redis.NewClient(&redis.Options{
-		Addr:         "localhost:6379", // Redis server address
-		Password:     "",               // No password set
-		DB:           0,                // Use default DB
-		DialTimeout:  5 * time.Second,  // Connection timeout
-		ReadTimeout:  3 * time.Second,  // Read timeout
-		WriteTimeout: 3 * time.Second,  // Write timeout
-		PoolSize:     10,               // Connection pool size
-		MinIdleConns: 2,                // Minimum idle connections
-	})
-
-	// Test the connection
-	_, err := rdb.Ping(ctx).Result()
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	return connectRedis(ctx, logger, redisOptions("localhost:6379"), redisConnectAttempts, redisConnectBackoff)
+}
+
+func connectRedis(ctx context.Context, logger *zap.Logger, opts *redis.Options, attempts int, backoff time.Duration) (*redis.Client, error) {
+	rdb := redis.NewClient(opts)
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, redisPingTimeout)
+		err = rdb.Ping(pingCtx).Err()
+		cancel()
+		if err == nil {
+			break
+		}
+		if attempt >= attempts {
+			_ = rdb.Close()
+			return nil, fmt.Errorf("failed to connect to Redis after %d attempts: %w", attempt, err)
+		}
+		logger.Warn("Redis is not ready, retrying",
+			zap.Int("attempt", attempt), zap.Duration("delay", backoff), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			_ = rdb.Close()
+			return nil, fmt.Errorf("failed to connect to Redis: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, redisConnectMaxBackoff)
 	}
 
 	logger.Info("Successfully connected to Redis",
-		zap.String("addr", "localhost:6379"),
-		zap.Int("db", 0))
+		zap.String("addr", opts.Addr),
+		zap.Int("db", opts.DB))
 
 	return rdb, nil
 }
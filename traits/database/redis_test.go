@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"go.uber.org/zap"
+)
+
+func TestConnectRedisRetries(t *testing.T) {
+	// Redis поднимается позже бота: первые попытки падают, потом подключаемся
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	srv := miniredis.NewMiniRedis()
+	t.Cleanup(srv.Close)
+	go func() {
+		time.Sleep(120 * time.Millisecond)
+		if err := srv.StartAddr(addr); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	opts := redisOptions(addr)
+	opts.DialTimeout = 50 * time.Millisecond
+	rdb, err := connectRedis(context.Background(), zap.NewNop(), opts, 6, 40*time.Millisecond)
+	if err != nil {
+		t.Fatalf("connect after retries: %v", err)
+	}
+	defer rdb.Close()
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnectRedisGivesUp(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	opts := redisOptions(addr)
+	opts.DialTimeout = 50 * time.Millisecond
+	opts.MaxRetries = -1
+	start := time.Now()
+	if _, err := connectRedis(context.Background(), zap.NewNop(), opts, 3, 10*time.Millisecond); err == nil {
+		t.Fatal("expected error when Redis is down")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("gave up too late: %v", d)
+	}
+}
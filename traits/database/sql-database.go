@@ -1,16 +1,33 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// InitDatabase initializes the SQLite database
-func InitDatabase(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+// InitDatabase открывает базу и создаёт таблицы.
+// driver — DriverSQLite (dsn — путь к файлу) или DriverPostgres (dsn — строка подключения lib/pq).
+func InitDatabase(driver, dsn string) (*sql.DB, error) {
+	var driverName string
+	switch driver {
+	case DriverSQLite, "":
+		driverName = DriverSQLite
+	case DriverPostgres:
+		driverName = postgresDriverName
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q (want %s or %s)", driver, DriverSQLite, DriverPostgres)
+	}
+
+	if driverName == DriverSQLite {
+		dsn = withBusyTimeout(dsn)
+	}
+	db, err := sql.Open(driverName, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -20,15 +37,50 @@ func InitDatabase(dbPath string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
+	if !IsPostgres(db) {
+		// WAL — чтобы бэкап (VACUUM INTO) не блокировал писателей надолго
+		if _, err := db.Exec(`PRAGMA journal_mode=WAL;`); err != nil {
+			return nil, fmt.Errorf("failed to enable WAL: %w", err)
+		}
+
+		// Быстрая проверка целостности файла (полная — через /api/admin/db/check)
+		problems, err := IntegrityCheck(context.Background(), db, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run quick_check: %w", err)
+		}
+		if len(problems) > 0 {
+			return nil, fmt.Errorf("database quick_check failed: %s", strings.Join(problems, "; "))
+		}
+	}
+
 	// Create tables
 	if err := CreateTables(db); err != nil {
 		return nil, fmt.Errorf("failed to create tables: %w", err)
 	}
 
+	// CreateTables не добавляет колонки в существующие таблицы — проверяем схему явно
+	if err := VerifySchema(db); err != nil {
+		return nil, fmt.Errorf("database schema check failed: %w", err)
+	}
+
 	log.Println("Database initialized successfully")
 	return db, nil
 }
 
+// withBusyTimeout добавляет в DSN sqlite _busy_timeout: PRAGMA через db.Exec
+// действует только на одно соединение из пула, а параметр DSN — на каждое.
+func withBusyTimeout(dsn string) string {
+	// _timeout= покрывает и _busy_timeout=, и его короткую форму
+	if strings.Contains(dsn, "_timeout=") {
+		return dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + "_busy_timeout=5000"
+}
+
 // CreateTables creates all necessary tables for AGRO club
 func CreateTables(db *sql.DB) error {
 	tables := []struct {
@@ -40,10 +92,29 @@ func CreateTables(db *sql.DB) error {
 		{"stores", createStoresTable}, // магазины
 		{"categories", createCategoriesTable},
 		{"products", createProductsTable},
+		{"product_tags", createProductTagsTable},
 		{"price_feed", createPriceFeedTable},
+		{"product_price_history", createProductPriceHistoryTable},
 		{"subscriptions", createSubscriptionsTable},
+		{"subscription_plans", createSubscriptionPlansTable},
+		{"subscription_reminders", createSubscriptionRemindersTable},
+		{"subscription_grace_reminders", createSubscriptionGraceRemindersTable},
 		{"orders", createOrdersTable},
 		{"order_items", createOrderItemsTable},
+		{"audit_log", createAuditLogTable},
+		{"stock_notifications", createStockNotificationsTable},
+		{"delivery_zones", createDeliveryZonesTable},
+		{"delivery_tiers", createDeliveryTiersTable},
+		{"order_feedback", createOrderFeedbackTable},
+		{"app_settings", createAppSettingsTable},
+		{"webhooks", createWebhooksTable},
+		{"store_managers", createStoreManagersTable},
+		{"user_phones", createUserPhonesTable},
+		{"order_status_messages", createOrderStatusMessagesTable},
+		{"promotions", createPromotionsTable},
+		{"notification_outbox", createNotificationOutboxTable},
+		{"product_deletions", createProductDeletionsTable},
+		{"payments", createPaymentsTable},
 	}
 
 	for _, t := range tables {
@@ -51,10 +122,197 @@ func CreateTables(db *sql.DB) error {
 			return fmt.Errorf("create %s table: %w", t.name, err)
 		}
 	}
+	// сначала пересборка order_items (она знает только исходные колонки),
+	// затем ALTER TABLE добавит новые
+	if err := relaxOrderItemsProductID(db); err != nil {
+		return err
+	}
+	if err := migrateColumns(db); err != nil {
+		return err
+	}
+	if err := migrateProductsStoreIndex(db); err != nil {
+		return err
+	}
+	// индекс по колонке из columnMigrations: в старой базе она есть только после ALTER TABLE
+	if err := execDDL(db, `CREATE INDEX IF NOT EXISTS idx_orders_parent ON orders(parent_order_id)`); err != nil {
+		return fmt.Errorf("create orders parent index: %w", err)
+	}
+	if err := backfillOrderTotals(db); err != nil {
+		return err
+	}
 	log.Println("All tables created successfully")
 	return nil
 }
 
+// columnMigrations — колонки, добавленные после первого релиза.
+// CREATE TABLE IF NOT EXISTS их в старые базы не добавит, поэтому ALTER TABLE.
+var columnMigrations = []struct {
+	table  string
+	column string
+	ddl    string
+}{
+	{"categories", "parent_slug", "TEXT"},
+	{"products", "store_code", "TEXT REFERENCES stores(code)"},
+	{"products", "featured", "INTEGER NOT NULL DEFAULT 0"},
+	{"products", "sort_order", "INTEGER NOT NULL DEFAULT 0"},
+	{"products", "stock_qty", "INTEGER"},
+	{"products", "subscriber_only", "INTEGER NOT NULL DEFAULT 0"},
+	{"products", "retail_price", "INTEGER"},
+	{"products", "price_per", "TEXT"},
+	{"products", "max_qty", "REAL"},
+	{"products", "cost_price", "INTEGER"},
+	{"users", "previous_store", "TEXT"},
+	{"users", "store_changed_at", "DATETIME"},
+	{"users", "marketing_opt_out", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "price_drop_notified_at", "DATETIME"},
+	{"stores", "longitude", "REAL"},
+	{"stores", "latitude", "REAL"},
+	{"stores", "address_formatted", "TEXT"},
+	{"stores", "working_hours", "TEXT"},
+	{"stores", "delivers", "INTEGER NOT NULL DEFAULT 1"},
+	{"stores", "delivery_radius_km", "REAL"},
+	{"order_items", "note", "TEXT"},
+	{"order_items", "allow_substitution", "INTEGER NOT NULL DEFAULT 0"},
+	{"order_items", "emoji", "TEXT"},
+	{"order_items", "photo_path", "TEXT"},
+	{"order_items", "price_per", "TEXT"},
+	{"webhook_deliveries", "order_id", "INTEGER"},
+	{"webhook_deliveries", "status_code", "INTEGER"},
+	{"webhook_deliveries", "attempted_at", "DATETIME"},
+	{"orders", "payment_method", "TEXT"},
+	{"orders", "payment_decided_by", "INTEGER"},
+	{"orders", "payment_decided_by_name", "TEXT"},
+	{"orders", "payment_decided_at", "DATETIME"},
+	{"orders", "goods_total", "INTEGER NOT NULL DEFAULT 0"},
+	{"orders", "delivery_price", "INTEGER NOT NULL DEFAULT 0"},
+	{"orders", "parent_order_id", "INTEGER REFERENCES orders(id)"},
+	{"subscriptions", "decided_by", "INTEGER"},
+	{"subscriptions", "decided_by_name", "TEXT"},
+	{"subscriptions", "decided_at", "DATETIME"},
+}
+
+// migrateColumns добавляет недостающие колонки из columnMigrations.
+func migrateColumns(db *sql.DB) error {
+	for _, m := range columnMigrations {
+		cols, err := tableColumns(db, m.table)
+		if err != nil {
+			return err
+		}
+		if cols[m.column] {
+			continue
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.ddl)
+		if err := execDDL(db, stmt); err != nil {
+			return fmt.Errorf("migrate %s.%s: %w", m.table, m.column, err)
+		}
+		log.Printf("Added column %s.%s", m.table, m.column)
+	}
+	return nil
+}
+
+// migrateProductsStoreIndex (002_add_store_code_to_products) строит индекс
+// каталога точки. Он создаётся после migrateColumns, а не в createProductsTable:
+// в старой базе products.store_code появляется только через ALTER TABLE. Прежний
+// индекс только по store_code пересоздаётся с active — каталог фильтрует по обоим.
+func migrateProductsStoreIndex(db *sql.DB) error {
+	query := `SELECT COALESCE(sql, '') FROM sqlite_master WHERE type = 'index' AND name = 'idx_products_store'`
+	if IsPostgres(db) {
+		query = `SELECT indexdef FROM pg_indexes WHERE schemaname = current_schema() AND indexname = 'idx_products_store'`
+	}
+	var def string
+	err := db.QueryRow(query).Scan(&def)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("inspect idx_products_store: %w", err)
+	}
+	if strings.Contains(strings.ReplaceAll(def, " ", ""), "(store_code,active)") {
+		return nil
+	}
+
+	const stmt = `
+	DROP INDEX IF EXISTS idx_products_store;
+	CREATE INDEX idx_products_store ON products(store_code, active);
+	`
+	if err := execDDL(db, stmt); err != nil {
+		return fmt.Errorf("migrate idx_products_store: %w", err)
+	}
+	log.Println("Rebuilt index idx_products_store on products(store_code, active)")
+	return nil
+}
+
+// backfillOrderTotals раскладывает total_amount старых заказов на goods_total
+// и delivery_price по строке «Доставка» в order_items. Заказы, где колонки уже
+// заполнены, не трогает, поэтому безопасен при каждом старте.
+func backfillOrderTotals(db *sql.DB) error {
+	res, err := db.Exec(`
+		UPDATE orders
+		SET delivery_price = COALESCE((
+		        SELECT SUM(i.amount) FROM order_items i
+		        WHERE i.order_id = orders.id AND i.product_id IS NULL AND i.name = 'Доставка'
+		    ), 0),
+		    goods_total = total_amount - COALESCE((
+		        SELECT SUM(i.amount) FROM order_items i
+		        WHERE i.order_id = orders.id AND i.product_id IS NULL AND i.name = 'Доставка'
+		    ), 0)
+		WHERE goods_total = 0 AND delivery_price = 0 AND total_amount > 0
+	`)
+	if err != nil {
+		return fmt.Errorf("backfill order totals: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Backfilled goods_total/delivery_price for %d orders", n)
+	}
+	return nil
+}
+
+// relaxOrderItemsProductID снимает NOT NULL с order_items.product_id в старых базах.
+// SQLite не умеет менять ограничения колонки, поэтому таблица пересоздаётся.
+func relaxOrderItemsProductID(db *sql.DB) error {
+	if IsPostgres(db) {
+		return nil // в PostgreSQL таблица сразу создаётся с nullable product_id
+	}
+	var notNull int
+	err := db.QueryRow(`SELECT "notnull" FROM pragma_table_info('order_items') WHERE name = 'product_id'`).Scan(&notNull)
+	if err != nil {
+		return fmt.Errorf("inspect order_items.product_id: %w", err)
+	}
+	if notNull == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	const stmt = `
+	ALTER TABLE order_items RENAME TO order_items_old;
+	CREATE TABLE order_items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		order_id INTEGER NOT NULL,
+		product_id INTEGER,
+		name TEXT NOT NULL,
+		unit TEXT NOT NULL,
+		qty REAL NOT NULL,
+		price INTEGER NOT NULL,
+		amount INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	INSERT INTO order_items (id, order_id, product_id, name, unit, qty, price, amount, created_at)
+	SELECT id, order_id, NULLIF(product_id, 0), name, unit, qty, price, amount, created_at FROM order_items_old;
+	DROP TABLE order_items_old;
+	CREATE INDEX IF NOT EXISTS idx_order_items_order ON order_items(order_id);
+	`
+	if _, err := tx.Exec(stmt); err != nil {
+		return fmt.Errorf("migrate order_items.product_id: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Println("Relaxed NOT NULL on order_items.product_id")
+	return nil
+}
+
 // createJustTable creates the just table (existing)
 func createJustTable(db *sql.DB) error {
 	const stmt = `
@@ -67,8 +325,7 @@ func createJustTable(db *sql.DB) error {
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 	`
-	_, err := db.Exec(stmt)
-	return err
+	return execDDL(db, stmt)
 }
 
 // users — убраны latitude/longitude и пр. лишнее
@@ -79,9 +336,13 @@ func createUsersTable(db *sql.DB) error {
 		user_id        INTEGER NOT NULL UNIQUE,   -- Telegram ID
 		nickname       TEXT NOT NULL,
 		phone          TEXT,                      -- телефон/Kaspi
-		sub_status     TEXT DEFAULT 'inactive',   -- inactive | active | blocked
+		sub_status     TEXT DEFAULT 'inactive',   -- inactive | active | grace | expired | blocked
 		sub_until      DATETIME,                  -- дата окончания подписки
 		selected_store TEXT,                      -- код магазина
+		previous_store TEXT,                      -- магазин до последней смены
+		store_changed_at DATETIME,                -- когда магазин меняли последний раз
+		marketing_opt_out INTEGER NOT NULL DEFAULT 0, -- 1 — не присылать рассылки и «Цены снизились»
+		price_drop_notified_at DATETIME,          -- последнее сообщение «Цены снизились» (не чаще раза в сутки)
 		created_at     DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at     DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
@@ -93,8 +354,7 @@ func createUsersTable(db *sql.DB) error {
 	  UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
 	END;
 	`
-	_, err := db.Exec(stmt)
-	return err
+	return execDDL(db, stmt)
 }
 
 func createStoresTable(db *sql.DB) error {
@@ -104,6 +364,12 @@ func createStoresTable(db *sql.DB) error {
 		code TEXT NOT NULL UNIQUE,     -- например: samal3, aksai ...
 		name TEXT NOT NULL,            -- Самал-3
 		address TEXT,
+		longitude REAL,
+		latitude REAL,
+		address_formatted TEXT,        -- адрес после геокодинга Яндекса
+		working_hours TEXT,            -- например: 09:00–21:00
+		delivers INTEGER NOT NULL DEFAULT 1, -- 0 — только самовывоз
+		delivery_radius_km REAL,       -- NULL — общий DELIVERY_RADIUS_KM
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
@@ -113,8 +379,7 @@ func createStoresTable(db *sql.DB) error {
 	  UPDATE stores SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
 	END;
 	`
-	_, err := db.Exec(stmt)
-	return err
+	return execDDL(db, stmt)
 }
 
 func createCategoriesTable(db *sql.DB) error {
@@ -123,11 +388,11 @@ func createCategoriesTable(db *sql.DB) error {
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		name TEXT NOT NULL,
 		slug TEXT NOT NULL UNIQUE,       -- vegetables, fruits, greens, promo
-		sort_order INTEGER DEFAULT 0
+		sort_order INTEGER DEFAULT 0,
+		parent_slug TEXT                 -- родитель (только верхнего уровня); NULL — корневая
 	);
 	`
-	_, err := db.Exec(stmt)
-	return err
+	return execDDL(db, stmt)
 }
 
 func createProductsTable(db *sql.DB) error {
@@ -143,19 +408,40 @@ func createProductsTable(db *sql.DB) error {
 		description TEXT,
 		photo_path TEXT,
 		store_code TEXT,                    -- 🔹 новая колонка: код точки из stores.code
+		featured INTEGER NOT NULL DEFAULT 0, -- 1 = в карусели на главной
+		sort_order INTEGER NOT NULL DEFAULT 0, -- порядок внутри категории
+		stock_qty INTEGER,                  -- остаток; NULL = не отслеживается
+		subscriber_only INTEGER NOT NULL DEFAULT 0, -- 1 = цена видна только подписчикам
+		retail_price INTEGER,               -- цена без подписки; NULL = как price
+		price_per TEXT,                     -- за что цена: kg | 100g | piece | bundle; NULL = за unit, как раньше
+		max_qty REAL,                       -- предел количества в одном заказе; NULL = ORDER_MAX_ITEM_QTY
+		cost_price INTEGER,                 -- закупочная цена у поставщика; NULL = не задана
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 	CREATE INDEX IF NOT EXISTS idx_products_cat ON products(category_slug, active);
-	CREATE INDEX IF NOT EXISTS idx_products_store ON products(store_code);
+	CREATE INDEX IF NOT EXISTS idx_products_updated ON products(updated_at);
 	CREATE TRIGGER IF NOT EXISTS trg_products_updated_at
 	AFTER UPDATE ON products
 	FOR EACH ROW BEGIN
 	  UPDATE products SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
 	END;
 	`
-	_, err := db.Exec(stmt)
-	return err
+	return execDDL(db, stmt)
+}
+
+// Ярлыки товаров («Акция», «Новинка», «Хит») — отдельно от категории,
+// у товара может быть несколько ярлыков.
+func createProductTagsTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS product_tags (
+		product_id INTEGER NOT NULL,
+		tag TEXT NOT NULL,              -- promo, new, hit ...
+		PRIMARY KEY (product_id, tag)
+	);
+	CREATE INDEX IF NOT EXISTS idx_product_tags_tag ON product_tags(tag);
+	`
+	return execDDL(db, stmt)
 }
 
 // Исторический фид цен (по желанию можно не использовать)
@@ -166,13 +452,29 @@ func createPriceFeedTable(db *sql.DB) error {
 		product_id INTEGER NOT NULL,
 		market TEXT NOT NULL DEFAULT 'Алтын Орда',
 		price INTEGER NOT NULL,
-		price_date DATE NOT NULL DEFAULT (DATE('now')),
+		price_date DATE NOT NULL DEFAULT CURRENT_DATE,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 	CREATE INDEX IF NOT EXISTS idx_price_feed_prod ON price_feed(product_id, price_date);
 	`
-	_, err := db.Exec(stmt)
-	return err
+	return execDDL(db, stmt)
+}
+
+// product_price_history — изменения цен товаров из админки (кто, когда, было/стало)
+func createProductPriceHistoryTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS product_price_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		product_id INTEGER NOT NULL,     -- products.id
+		old_price INTEGER NOT NULL,
+		new_price INTEGER NOT NULL,
+		source TEXT NOT NULL,            -- bulk | manual
+		admin_id INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_price_history_product ON product_price_history(product_id, created_at);
+	`
+	return execDDL(db, stmt)
 }
 
 func createSubscriptionsTable(db *sql.DB) error {
@@ -181,17 +483,63 @@ func createSubscriptionsTable(db *sql.DB) error {
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		user_id INTEGER NOT NULL,       -- Telegram ID (users.user_id)
 		phone TEXT,
-		status TEXT NOT NULL DEFAULT 'pending',  -- pending | active | expired | cancelled
+		status TEXT NOT NULL DEFAULT 'pending',  -- pending | active | rejected | grace | expired | cancelled
 		invoice_no TEXT,
 		amount INTEGER NOT NULL DEFAULT 3000,
 		paid_at DATETIME,
 		valid_until DATETIME,
+		decided_by INTEGER,             -- Telegram ID админа, подтвердившего/отклонившего чек
+		decided_by_name TEXT,
+		decided_at DATETIME,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 	CREATE INDEX IF NOT EXISTS idx_sub_user ON subscriptions(user_id, status);
+	CREATE INDEX IF NOT EXISTS idx_sub_status_created ON subscriptions(status, created_at); -- список в админке
 	`
-	_, err := db.Exec(stmt)
-	return err
+	return execDDL(db, stmt)
+}
+
+// Отправленные напоминания об окончании подписки (одно на подписку)
+func createSubscriptionRemindersTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS subscription_reminders (
+		subscription_id INTEGER PRIMARY KEY,
+		sent_at DATETIME NOT NULL
+	);
+	`
+	return execDDL(db, stmt)
+}
+
+// Напоминания в льготный период (grace): не чаще одного в день на подписку.
+func createSubscriptionGraceRemindersTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS subscription_grace_reminders (
+		subscription_id INTEGER NOT NULL,
+		day TEXT NOT NULL,               -- YYYY-MM-DD
+		sent_at DATETIME NOT NULL,
+		PRIMARY KEY (subscription_id, day)
+	);
+	`
+	return execDDL(db, stmt)
+}
+
+// Тарифы подписки (месяц, квартал, год ...). По умолчанию — текущий месячный тариф.
+func createSubscriptionPlansTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS subscription_plans (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT NOT NULL UNIQUE,              -- month, quarter, year
+		name TEXT NOT NULL,
+		price INTEGER NOT NULL,                 -- ₸ за весь период
+		duration_months INTEGER NOT NULL DEFAULT 1,
+		active INTEGER NOT NULL DEFAULT 1,      -- 1/0
+		sort_order INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	INSERT OR IGNORE INTO subscription_plans (code, name, price, duration_months, sort_order)
+	VALUES ('month', 'Месяц', 3000, 1, 0);
+	`
+	return execDDL(db, stmt)
 }
 
 func createOrdersTable(db *sql.DB) error {
@@ -200,8 +548,15 @@ func createOrdersTable(db *sql.DB) error {
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		user_id INTEGER NOT NULL,        -- Telegram ID
 		store_code TEXT,                 -- откуда собирать
-		total_amount INTEGER NOT NULL DEFAULT 0,
-		status TEXT NOT NULL DEFAULT 'new',  -- new | checking | invoiced | paid | preparing | done | cancelled
+		total_amount INTEGER NOT NULL DEFAULT 0, -- goods_total + delivery_price
+		goods_total INTEGER NOT NULL DEFAULT 0,    -- товары без доставки
+		delivery_price INTEGER NOT NULL DEFAULT 0, -- строка «Доставка»; 0 — самовывоз
+		status TEXT NOT NULL DEFAULT 'new',  -- new | checking | invoiced | paid | rejected | preparing | delivering | done | cancelled
+		payment_method TEXT,             -- kaspi_link | kaspi_transfer | cash; нужен для повторного чека
+		payment_decided_by INTEGER,      -- Telegram ID админа, подтвердившего/отклонившего чек
+		payment_decided_by_name TEXT,    -- @username (или имя) этого админа
+		payment_decided_at DATETIME,
+		parent_order_id INTEGER REFERENCES orders(id), -- часть заказа из нескольких точек; NULL — обычный заказ
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
@@ -212,8 +567,7 @@ func createOrdersTable(db *sql.DB) error {
 	  UPDATE orders SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
 	END;
 	`
-	_, err := db.Exec(stmt)
-	return err
+	return execDDL(db, stmt)
 }
 
 func createOrderItemsTable(db *sql.DB) error {
@@ -221,16 +575,294 @@ func createOrderItemsTable(db *sql.DB) error {
 	CREATE TABLE IF NOT EXISTS order_items (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		order_id INTEGER NOT NULL,
-		product_id INTEGER NOT NULL,
+		product_id INTEGER,         -- NULL для служебных строк («Доставка»)
 		name TEXT NOT NULL,         -- денормализация для удобства
 		unit TEXT NOT NULL,
 		qty REAL NOT NULL,
 		price INTEGER NOT NULL,     -- применённая цена на момент заказа
 		amount INTEGER NOT NULL,    -- price * qty (округление по правилам)
+		note TEXT,                  -- пожелание покупателя к позиции
+		allow_substitution INTEGER NOT NULL DEFAULT 0, -- 1 — можно заменить похожим товаром
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 	CREATE INDEX IF NOT EXISTS idx_order_items_order ON order_items(order_id);
 	`
-	_, err := db.Exec(stmt)
-	return err
+	return execDDL(db, stmt)
+}
+
+// Журнал ручных действий администраторов
+func createAuditLogTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		admin_id INTEGER NOT NULL,       -- Telegram ID администратора
+		action TEXT NOT NULL,            -- subscription.set, ...
+		target TEXT,                     -- над кем/чем выполнено действие
+		reason TEXT,
+		details TEXT,                    -- JSON с параметрами
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_admin ON audit_log(admin_id, created_at);
+	`
+	return execDDL(db, stmt)
+}
+
+// stock_notifications — «сообщить о поступлении»: ждут, пока stock_qty станет > 0
+func createStockNotificationsTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS stock_notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,        -- Telegram ID
+		product_id INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		notified_at DATETIME             -- NULL = ещё ждёт
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_stock_notifications_pending
+		ON stock_notifications(user_id, product_id) WHERE notified_at IS NULL;
+	CREATE INDEX IF NOT EXISTS idx_stock_notifications_product ON stock_notifications(product_id, notified_at);
+	`
+	return execDDL(db, stmt)
+}
+
+// delivery_zones — зона доставки точки: GeoJSON Polygon/MultiPolygon (координаты [lng, lat])
+func createDeliveryZonesTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS delivery_zones (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		store_code TEXT NOT NULL,        -- stores.code
+		polygon_geojson TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_delivery_zones_store ON delivery_zones(store_code);
+	`
+	return execDDL(db, stmt)
+}
+
+// delivery_tiers — ступени тарифа доставки точки: до up_to_km километров стоит price
+func createDeliveryTiersTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS delivery_tiers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		store_code TEXT NOT NULL,        -- stores.code
+		up_to_km REAL NOT NULL,
+		price INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (store_code, up_to_km)
+	);
+	`
+	return execDDL(db, stmt)
+}
+
+// order_feedback — оценка выполненного заказа; один отзыв на заказ
+func createOrderFeedbackTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS order_feedback (
+		order_id INTEGER PRIMARY KEY,    -- orders.id
+		rating INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 5),
+		comment TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	return execDDL(db, stmt)
+}
+
+// app_settings — настройки, которые админ меняет на лету (режим обслуживания и т.п.)
+func createAppSettingsTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS app_settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	return execDDL(db, stmt)
+}
+
+// store_managers — управляющие точек: видят в админке только заказы своей точки.
+func createStoreManagersTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS store_managers (
+		store_code TEXT NOT NULL,
+		telegram_id INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (store_code, telegram_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_store_managers_tg ON store_managers(telegram_id);
+	`
+	return execDDL(db, stmt)
+}
+
+// user_phones — все телефоны, которые называл пользователь (заказ, контакт, подписка).
+// users.phone не перезаписывается чужим номером: там может быть номер Kaspi,
+// по которому сверяются оплаты подписки, а остальные номера хранятся здесь.
+func createUserPhonesTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS user_phones (
+		user_id INTEGER NOT NULL,        -- Telegram ID
+		phone TEXT NOT NULL,             -- нормализованный: +7XXXXXXXXXX
+		source TEXT NOT NULL,            -- order | contact | subscription (где номер встретился последний раз)
+		uses INTEGER NOT NULL DEFAULT 1,
+		first_seen_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, phone)
+	);
+	CREATE INDEX IF NOT EXISTS idx_user_phones_phone ON user_phones(phone);
+	`
+	return execDDL(db, stmt)
+}
+
+// defaultOrderStatusMessages — тексты уведомлений покупателю о статусе заказа
+// (ru, kz); {order_id} заменяется номером заказа. Админ меняет их через
+// /api/admin/order-status-messages, при создании таблицы заводятся эти.
+var defaultOrderStatusMessages = []struct{ status, ru, kz string }{
+	{"review",
+		"🕵️ Заказ №{order_id} принят. Это большой заказ — администратор проверит наличие и пришлёт реквизиты для оплаты.",
+		"🕵️ №{order_id} тапсырыс қабылданды. Бұл үлкен тапсырыс — әкімші тауардың бар-жоғын тексеріп, төлем деректемелерін жібереді."},
+	{"new", "🧾 Заказ №{order_id} принят.", "🧾 №{order_id} тапсырыс қабылданды."},
+	{"checking", "🔎 Проверяем оплату заказа №{order_id}.", "🔎 №{order_id} тапсырыстың төлемін тексеріп жатырмыз."},
+	{"invoiced", "💳 По заказу №{order_id} выставлен счёт.", "💳 №{order_id} тапсырысқа шот жіберілді."},
+	{"paid",
+		"✅ Ваша оплата по заказу №{order_id} подтверждена! Спасибо за заказ.",
+		"✅ №{order_id} тапсырыс бойынша төлеміңіз расталды! Тапсырысыңызға рахмет."},
+	{"rejected",
+		"❌ Оплата по заказу №{order_id} не прошла проверку.\nПожалуйста, свяжитесь с администратором или отправьте корректный чек ещё раз.",
+		"❌ №{order_id} тапсырыс бойынша төлем тексеруден өтпеді.\nӘкімшіге хабарласыңыз немесе дұрыс чекті қайта жіберіңіз."},
+	{"preparing", "📦 Заказ №{order_id} собирается.", "📦 №{order_id} тапсырыс жиналуда."},
+	{"delivering", "🚚 Заказ №{order_id} передан курьеру.", "🚚 №{order_id} тапсырыс курьерге берілді."},
+	{"done",
+		"✅ Заказ №{order_id} выполнен. Спасибо за покупку!\nОцените, пожалуйста, заказ:",
+		"✅ №{order_id} тапсырыс орындалды. Сатып алғаныңызға рахмет!\nТапсырысты бағалаңыз:"},
+	{"cancelled",
+		"❌ Заказ №{order_id} отменён. Если это ошибка — напишите администратору.",
+		"❌ №{order_id} тапсырыстан бас тартылды. Егер бұл қате болса — әкімшіге жазыңыз."},
+}
+
+// notification_outbox — сообщения в Telegram, которые не ушли, потому что бот
+// ещё не был подключён; отправляются, как только он появится.
+func createNotificationOutboxTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS notification_outbox (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER NOT NULL,        -- Telegram ID получателя
+		text TEXT NOT NULL,
+		reply_markup TEXT,               -- JSON inline-клавиатуры
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		sent_at DATETIME                 -- NULL = ещё ждёт
+	);
+	CREATE INDEX IF NOT EXISTS idx_notification_outbox_pending ON notification_outbox(sent_at, id);
+	`
+	return execDDL(db, stmt)
+}
+
+// product_deletions — id удалённых товаров: мини-апп, синхронизирующий каталог
+// через /api/products/changes, узнаёт из них, что товар нужно убрать.
+func createProductDeletionsTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS product_deletions (
+		product_id INTEGER PRIMARY KEY,  -- products.id (id не переиспользуются)
+		deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_product_deletions_at ON product_deletions(deleted_at);
+	`
+	return execDDL(db, stmt)
+}
+
+// payments — чеки, которые покупатели прислали боту: по ним админка показывает
+// оплаты, ждущие проверки, и может заново прислать чек админу по file_id.
+func createPaymentsTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS payments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,              -- order | subscription
+		target_id INTEGER NOT NULL,      -- orders.id или subscriptions.id
+		user_id INTEGER NOT NULL,        -- Telegram ID покупателя
+		file_id TEXT NOT NULL,           -- Telegram file_id документа с чеком
+		amount INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_payments_target ON payments(kind, target_id);
+	`
+	return execDDL(db, stmt)
+}
+
+// order_status_messages — настраиваемые тексты уведомлений о статусе заказа.
+func createOrderStatusMessagesTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS order_status_messages (
+		status TEXT PRIMARY KEY,         -- new, paid, preparing ...
+		user_message_ru TEXT,
+		user_message_kz TEXT,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	if err := execDDL(db, stmt); err != nil {
+		return err
+	}
+	// уже настроенные админом тексты не трогаем
+	for _, m := range defaultOrderStatusMessages {
+		if _, err := db.Exec(`
+			INSERT INTO order_status_messages (status, user_message_ru, user_message_kz)
+			VALUES (?, ?, ?)
+			ON CONFLICT(status) DO NOTHING
+		`, m.status, m.ru, m.kz); err != nil {
+			return fmt.Errorf("seed order status %s: %w", m.status, err)
+		}
+	}
+	return nil
+}
+
+// promotions — акции с ярлыком: промо-цена на товар или на всю категорию
+// в окне [starts_at, ends_at). Время хранится в UTC; прошедшие акции просто
+// не попадают в выборку, чистить таблицу не нужно.
+func createPromotionsTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS promotions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		product_id INTEGER,              -- products.id (или category_slug)
+		category_slug TEXT,              -- categories.slug, вместе с подкатегориями
+		promo_price INTEGER NOT NULL,
+		badge TEXT,                      -- ярлык: «-20%», «Хит недели»
+		starts_at DATETIME NOT NULL,
+		ends_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_promotions_ends_at ON promotions(ends_at);
+	`
+	return execDDL(db, stmt)
+}
+
+// webhooks — внешние получатели событий (1С, склад) и очередь доставок к ним.
+// Доставки ретраятся воркером с экспоненциальной задержкой.
+func createWebhooksTable(db *sql.DB) error {
+	const stmt = `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,            -- ключ HMAC-SHA256 подписи
+		events TEXT NOT NULL,            -- через запятую; * — все события
+		active INTEGER NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,     -- webhooks.id
+		event TEXT NOT NULL,
+		order_id INTEGER,                -- для событий order.*
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending', -- pending | delivered | failed
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME,
+		status_code INTEGER,             -- HTTP-код последней попытки (NULL — сетевая ошибка)
+		attempted_at DATETIME,           -- время последней попытки
+		last_error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		delivered_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+	`
+	return execDDL(db, stmt)
 }
@@ -0,0 +1,219 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestBackfillOrderTotals(t *testing.T) {
+	db, err := InitDatabase(DriverSQLite, "file:backfill_totals_test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// заказы из базы до goods_total/delivery_price: колонки пустые
+	for _, q := range []string{
+		`INSERT INTO orders (id, user_id, total_amount, status) VALUES (1, 555, 2000, 'paid')`,
+		`INSERT INTO orders (id, user_id, total_amount, status) VALUES (2, 555, 900, 'new')`,
+		`INSERT INTO orders (id, user_id, total_amount, goods_total, delivery_price, status) VALUES (3, 555, 700, 200, 500, 'new')`,
+		`INSERT INTO order_items (order_id, product_id, name, unit, qty, price, amount) VALUES (1, 10, 'Картофель', 'кг', 2, 250, 500)`,
+		`INSERT INTO order_items (order_id, product_id, name, unit, qty, price, amount) VALUES (1, NULL, 'Доставка', 'услуга', 1, 1500, 1500)`,
+		`INSERT INTO order_items (order_id, product_id, name, unit, qty, price, amount) VALUES (2, 11, 'Лук', 'кг', 3, 300, 900)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backfillOrderTotals(db); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[int64][2]int64{1: {500, 1500}, 2: {900, 0}, 3: {200, 500}}
+	for id, w := range want {
+		var goods, delivery int64
+		if err := db.QueryRow(`SELECT goods_total, delivery_price FROM orders WHERE id = ?`, id).Scan(&goods, &delivery); err != nil {
+			t.Fatal(err)
+		}
+		if goods != w[0] || delivery != w[1] {
+			t.Errorf("order %d: goods %d, delivery %d; want %v", id, goods, delivery, w)
+		}
+	}
+}
+
+func TestMigrateProductsStoreCode(t *testing.T) {
+	const dsn = "file:products_store_code_test?mode=memory&cache=shared"
+	db, err := sql.Open(DriverSQLite, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// products из первого релиза: без store_code и поздних колонок
+	if _, err := db.Exec(`
+		CREATE TABLE products (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			emoji TEXT,
+			category_slug TEXT NOT NULL,
+			unit TEXT NOT NULL DEFAULT '₸/кг',
+			price INTEGER NOT NULL,
+			active INTEGER NOT NULL DEFAULT 1,
+			description TEXT,
+			photo_path TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO products (name, category_slug, price) VALUES ('Картофель', 'vegetables', 250);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	// второй прогон — база уже с колонкой и новым индексом
+	for run := 1; run <= 2; run++ {
+		if err := CreateTables(db); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+	}
+	if err := VerifySchema(db); err != nil {
+		t.Fatal(err)
+	}
+	var def string
+	if err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'index' AND name = 'idx_products_store'`).Scan(&def); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(def, "(store_code, active)") {
+		t.Fatalf("idx_products_store = %q", def)
+	}
+	var name string
+	if err := db.QueryRow(`SELECT name FROM products WHERE store_code IS NULL`).Scan(&name); err != nil || name != "Картофель" {
+		t.Fatalf("existing product = %q, err = %v", name, err)
+	}
+}
+
+func TestMigrateStoreGeocoords(t *testing.T) {
+	db, err := sql.Open(DriverSQLite, "file:stores_geocoords_test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// stores из первого релиза: без координат и адреса после геокодинга
+	if _, err := db.Exec(`
+		CREATE TABLE stores (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			code TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			address TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO stores (code, name, address) VALUES ('samal3', 'Самал-3', 'Алматы, Самал-3, 25');
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateTables(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySchema(db); err != nil {
+		t.Fatal(err)
+	}
+	// такие точки при старте геокодирует handler.BackfillStoreCoords
+	var pending int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM stores WHERE address IS NOT NULL AND longitude IS NULL`).Scan(&pending); err != nil || pending != 1 {
+		t.Fatalf("stores without coords = %d, err = %v", pending, err)
+	}
+}
+
+// База первого релиза должна проходить VerifySchema после CreateTables:
+// каждая колонка из requiredColumns, которой не было в первом CREATE TABLE,
+// обязана иметь ALTER в columnMigrations.
+func TestVerifySchemaUpgradesFirstRelease(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:first_release_test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`
+		CREATE TABLE users (
+			id TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL UNIQUE,
+			nickname TEXT NOT NULL,
+			phone TEXT,
+			sub_status TEXT DEFAULT 'inactive',
+			sub_until DATETIME,
+			selected_store TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE stores (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			code TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			address TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE categories (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			slug TEXT NOT NULL UNIQUE,
+			sort_order INTEGER DEFAULT 0
+		);
+		CREATE TABLE products (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			emoji TEXT,
+			category_slug TEXT NOT NULL,
+			unit TEXT NOT NULL DEFAULT '₸/кг',
+			price INTEGER NOT NULL,
+			active INTEGER NOT NULL DEFAULT 1,
+			description TEXT,
+			photo_path TEXT,
+			store_code TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE subscriptions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			phone TEXT,
+			status TEXT NOT NULL DEFAULT 'pending',
+			invoice_no TEXT,
+			amount INTEGER NOT NULL DEFAULT 3000,
+			paid_at DATETIME,
+			valid_until DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE orders (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			store_code TEXT,
+			total_amount INTEGER NOT NULL DEFAULT 0,
+			status TEXT NOT NULL DEFAULT 'new',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE order_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			order_id INTEGER NOT NULL,
+			product_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			unit TEXT NOT NULL,
+			qty REAL NOT NULL,
+			price INTEGER NOT NULL,
+			amount INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateTables(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySchema(db); err != nil {
+		t.Fatal(err)
+	}
+}